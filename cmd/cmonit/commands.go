@@ -0,0 +1,988 @@
+// commands.go - subcommands of the cmonit binary.
+//
+//	cmonit serve [flags]              Run the server (collector and web UI)
+//	cmonit check-config [flags]       Check the configuration and exit
+//	cmonit print-config [flags]       Print the effective configuration
+//	cmonit hash-password [password]   Print the bcrypt hash of a password
+//	cmonit db backup|purge|check      Database maintenance
+//	cmonit db export|import           Host dumps between instances
+//	cmonit db export-events           Events as CSV or ND-JSON
+//	cmonit import-mmonit <database>   Migration from M/Monit
+//	cmonit monit-config [-host id]    Monit configuration of an agent
+//	cmonit user list|passwd|reset-2fa Web user administration
+//	cmonit token create|list|revoke   API token administration
+//
+// The server flags without a subcommand still work as before, with a
+// deprecation warning.
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/ocochard/cmonit/internal/config"
+	"github.com/ocochard/cmonit/internal/db"
+	"github.com/ocochard/cmonit/internal/web"
+)
+
+// defaultDBPath is the default of -db and [storage] database.
+const defaultDBPath = "/var/run/cmonit/cmonit.db"
+
+const commandsUsage = `Usage: cmonit <command> [flags] [arguments]
+
+Commands:
+  serve                       Run the server (collector and web UI)
+  check-config                Check the configuration, print its problems and exit
+  print-config                Print the effective configuration (flags, environment, file, defaults), secrets masked
+  hash-password [password]    Print the bcrypt hash of a password (read from stdin if omitted)
+  db backup <file>            Write a consistent copy of the database, even while the server runs
+  db purge                    Delete metrics and events older than the retention, archive and delete silent hosts now
+  db check                    Check the integrity and schema version of the database
+  db export -host <id>        Write the data of a host as ND-JSON (to stdout, or -o file)
+  db import [-replace] <file> Add a host from a dump of db export ("-" = stdin)
+  db export-events            Write the events matching -host, -group, -from, -to... as CSV or ND-JSON (-format)
+  import-mmonit <database>    Import the hosts, host groups and events of an M/Monit SQLite database
+  monit-config [-host <id>]   Print the monitrc snippet (set mmonit, set httpd) of a host, or of a new agent
+  user list                   List the web users and their two-factor authentication
+  user passwd [name]          Print the [web] section with a new password hash of the web user
+  user reset-2fa <name>       Remove two-factor authentication of a web user
+  token create <name>         Create an API token (-scope read:status,..., -role), print it once
+  token list                  List the API tokens
+  token revoke <name>         Revoke an API token
+
+serve, check-config and print-config take the server flags ("cmonit serve -h"); db,
+import-mmonit, monit-config, user and token take -config and -db. Running the server flags without a command is
+deprecated.
+`
+
+// printConfigOnly makes serve print the effective configuration and exit
+// ("cmonit print-config").
+var printConfigOnly bool
+
+// runCommand runs the subcommand name with its arguments and returns the
+// exit code.
+func runCommand(name string, args []string) int {
+	switch name {
+	case "serve":
+		serve(args, false)
+		return 0
+	case "check-config":
+		serve(append(args, "-check-config"), false)
+		return 0 // serve exits with the result
+	case "print-config":
+		printConfigOnly = true
+		serve(args, false)
+		return 0
+	case "hash-password":
+		return runHashPasswordCommand(args)
+	case "db":
+		return runDBCommand(args)
+	case "import-mmonit":
+		return runImportMMonitCommand(args)
+	case "monit-config":
+		return runMonitConfigCommand(args)
+	case "user":
+		return runUserCommand(args)
+	case "token":
+		return runTokenSubcommand(args)
+	case "help":
+		fmt.Print(commandsUsage)
+		return 0
+	}
+	fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s", name, commandsUsage)
+	return 2
+}
+
+// serveUsage prints the commands, then the server flags.
+func serveUsage() {
+	fmt.Fprint(flag.CommandLine.Output(), commandsUsage)
+	fmt.Fprintf(flag.CommandLine.Output(), "\nServer flags (serve, check-config):\n")
+	flag.PrintDefaults()
+}
+
+// maskedSecret replaces the passwords (and tokens) printed by print-config.
+const maskedSecret = "********"
+
+// printEffectiveConfig prints cfg, the configuration serve would run with,
+// as TOML with its passwords masked. Passwords read from password_file or
+// the environment are merged in, so password_file is not printed.
+func printEffectiveConfig(cfg *config.Config) int {
+	for _, password := range []*string{&cfg.Collector.Password, &cfg.Web.Password, &cfg.EventSIEM.Token, &cfg.EventBus.Password,
+		&cfg.EventNagios.Token, &cfg.EventNagios.Password,
+		&cfg.EventSNMP.Community, &cfg.EventSNMP.AuthPassword, &cfg.EventSNMP.PrivPassword,
+		&cfg.EventGrafana.Token, &cfg.EventGrafana.Password, &cfg.Heartbeat.URL,
+		&cfg.Export.RemoteWrite.Password, &cfg.Export.RemoteWrite.Token, &cfg.Export.InfluxDB.Token, &cfg.Export.InfluxDB.Password,
+		&cfg.Export.MQTT.Password, &cfg.Export.Elasticsearch.Password, &cfg.Export.Elasticsearch.APIKey} {
+		if *password != "" {
+			*password = maskedSecret
+		}
+	}
+
+	for name := range cfg.Export.OTLP.Headers {
+		cfg.Export.OTLP.Headers[name] = maskedSecret
+	}
+	for hostname := range cfg.Heartbeat.Hosts {
+		cfg.Heartbeat.Hosts[hostname] = maskedSecret
+	}
+
+	fmt.Println("# Effective cmonit configuration (flags > environment > config file > defaults)")
+	fmt.Println("# Passwords are masked: this is not a working config file as is.")
+	if err := toml.NewEncoder(os.Stdout).Encode(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// warnDeprecatedFlags points the utility flags used without a subcommand
+// to their subcommand.
+func warnDeprecatedFlags(hashPassword, checkConfig, resetTOTP, createToken, revokeToken, listTokens bool) {
+	warn := func(flagName, command string) {
+		fmt.Fprintf(os.Stderr, "Warning: %s is deprecated, use: cmonit %s\n", flagName, command)
+	}
+	if hashPassword {
+		warn("-hash-password", "hash-password")
+	}
+	if checkConfig {
+		warn("-check-config", "check-config [flags]")
+	}
+	if resetTOTP {
+		warn("-reset-2fa", "user reset-2fa <name>")
+	}
+	if createToken {
+		warn("-create-token", "token create -scope <scopes> [-role role] <name>")
+	}
+	if revokeToken {
+		warn("-revoke-token", "token revoke <name>")
+	}
+	if listTokens {
+		warn("-list-tokens", "token list")
+	}
+}
+
+// runHashPasswordCommand prints the bcrypt hash of the password given as
+// argument, or read from the first line of stdin so that it does not show
+// in the process list and shell history.
+func runHashPasswordCommand(args []string) int {
+	if len(args) > 1 {
+		fmt.Fprintln(os.Stderr, "Usage: cmonit hash-password [password]")
+		return 2
+	}
+	if len(args) == 1 {
+		return printPasswordHash("admin", args[0])
+	}
+
+	password, ok := readPassword()
+	if !ok {
+		return 1
+	}
+	return printPasswordHash("admin", password)
+}
+
+// readPassword reads a password from the first line of stdin, reporting
+// an empty one.
+func readPassword() (string, bool) {
+	fmt.Fprint(os.Stderr, "Password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "\nError reading password: %v\n", err)
+		} else {
+			fmt.Fprintln(os.Stderr, "Error: empty password")
+		}
+		return "", false
+	}
+	return password, true
+}
+
+// printPasswordHash prints the bcrypt hash of password, ready to paste in
+// the [web] section of the configuration file for user.
+func printPasswordHash(user, password string) int {
+	// Generate bcrypt hash with cost 10 (default, balanced security/performance)
+	//
+	// bcrypt.GenerateFromPassword() creates a hash that includes:
+	// - Algorithm version ($2a$, $2b$, etc.)
+	// - Cost factor (10 = 2^10 iterations)
+	// - Salt (random, included in the hash)
+	// - Hash of password+salt
+	//
+	// Example output: $2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error generating bcrypt hash: %v\n", err)
+		return 1
+	}
+
+	// Print the hash to stdout
+	fmt.Printf("Bcrypt hash: %s\n\n", string(hash))
+	fmt.Println("Add this to your configuration file:")
+	fmt.Println("[web]")
+	fmt.Printf("user = %q\n", user)
+	fmt.Printf("password = \"%s\"\n", string(hash))
+	fmt.Println("password_format = \"bcrypt\"")
+	return 0
+}
+
+// storageFlags are the flags of the commands working on the database.
+type storageFlags struct {
+	configFile *string
+	dbPath     *string
+}
+
+// newStorageFlagSet returns the flag set of a database command, with
+// -config and -db.
+func newStorageFlagSet(name string) (*flag.FlagSet, storageFlags) {
+	fs := flag.NewFlagSet("cmonit "+name, flag.ExitOnError)
+	sf := storageFlags{
+		configFile: fs.String("config", "", "Configuration file path (TOML format, or YAML if named *.yaml or *.yml; optional)"),
+		dbPath:     fs.String("db", defaultDBPath, "Database file path"),
+	}
+	return fs, sf
+}
+
+// load returns the configuration and the database path, merged like the
+// server does: flag > environment > config file > default.
+func (sf storageFlags) load() (*config.Config, string, error) {
+	cfg := &config.Config{}
+	if *sf.configFile != "" {
+		var err error
+		if cfg, err = config.Load(*sf.configFile); err != nil {
+			return nil, "", err
+		}
+	}
+	if _, err := config.ApplyEnv(cfg, os.Environ()); err != nil {
+		return nil, "", err
+	}
+	return cfg, config.MergeString(cfg.Storage.Database, *sf.dbPath, defaultDBPath), nil
+}
+
+// runDBCommand runs "cmonit db backup|purge|check|export|export-events|import".
+func runDBCommand(args []string) int {
+	const usage = "Usage: cmonit db backup|purge|check|export|export-events|import [-config file] [-db file] [arguments]"
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+
+	sub := args[0]
+	fs, sf := newStorageFlagSet("db " + sub)
+	retentionDays := 0
+	var hostID, output string
+	var replace bool
+	var events eventExportFlags
+	switch sub {
+	case "purge":
+		fs.IntVar(&retentionDays, "retention-days", 0, "Days of metrics/events history to keep (default: [retention] metrics and events, [storage] retention_days, or 30)")
+	case "export":
+		fs.StringVar(&hostID, "host", "", "ID of the host to export (see /api/v1/hosts)")
+		fs.StringVar(&output, "o", "", "Output file (default: stdout)")
+	case "export-events":
+		fs.StringVar(&events.host, "host", "", "ID of the host of the events")
+		fs.StringVar(&events.group, "group", "", "Hostgroup of the hosts, or service group of the services, of the events")
+		fs.StringVar(&events.service, "service", "", "Service of the events")
+		fs.StringVar(&events.severity, "severity", "", "Severity of the events: info, warning or critical")
+		fs.StringVar(&events.from, "from", "", "First day (YYYY-MM-DD, local time) or RFC 3339 time of the events")
+		fs.StringVar(&events.to, "to", "", "Last day, inclusive, or RFC 3339 time of the events")
+		fs.StringVar(&events.format, "format", db.EventExportCSV, "Output format: csv or ndjson")
+		fs.StringVar(&output, "o", "", "Output file (default: stdout)")
+	case "import":
+		fs.BoolVar(&replace, "replace", false, "Replace the host if it already exists")
+	}
+	fs.Parse(args[1:])
+
+	cfg, dbPath, err := sf.load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	switch sub {
+	case "backup":
+		if fs.NArg() != 1 {
+			fmt.Fprintln(os.Stderr, "Usage: cmonit db backup [-config file] [-db file] <backup file>")
+			return 2
+		}
+		database, err := db.OpenExisting(dbPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		defer database.Close()
+		if err := db.Backup(database, fs.Arg(0)); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Backed up %s to %s\n", dbPath, fs.Arg(0))
+		fmt.Println("The secret key file (-secret-key-file) is not included: back it up separately.")
+		return 0
+
+	case "purge":
+		if retentionDays > 0 {
+			// The flag overrides both ages of the file
+			cfg.Storage.RetentionDays = retentionDays
+			cfg.Retention.Metrics, cfg.Retention.Events = "", ""
+		}
+		metricsAge, eventsAge, err := cfg.RetentionAges()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		archiveHosts, deleteHosts, deleteWarning, err := cfg.HostRetention()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		database, err := db.OpenExisting(dbPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		defer database.Close()
+		events := db.EventRetention{Age: eventsAge, PerHost: cfg.Retention.EventsPerHost, Aggregate: cfg.Retention.AggregateEvents}
+		if err := db.PruneOldData(database, metricsAge, events); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Deleted metrics older than %s and events older than %s from %s\n", formatAge(metricsAge), formatAge(eventsAge), dbPath)
+		if events.PerHost > 0 {
+			fmt.Printf("Kept at most %d events per host\n", events.PerHost)
+		}
+		hostPurge := db.HostPurge{ArchiveAfter: archiveHosts, DeleteAfter: deleteHosts, DeleteWarning: deleteWarning}
+		if hostPurge.Enabled() {
+			archived, deleted, err := db.PurgeHosts(database, hostPurge, time.Now())
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				return 1
+			}
+			fmt.Printf("Archived %d and deleted %d hosts not reporting\n", archived, deleted)
+		}
+		return 0
+
+	case "check":
+		database, err := db.OpenExisting(dbPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		defer database.Close()
+		problems, err := db.Check(database)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		if len(problems) == 0 {
+			fmt.Printf("Database %s OK\n", dbPath)
+			return 0
+		}
+		for _, p := range problems {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", p)
+		}
+		return 1
+
+	case "export":
+		if hostID == "" || fs.NArg() != 0 {
+			fmt.Fprintln(os.Stderr, "Usage: cmonit db export [-config file] [-db file] -host <id> [-o file]")
+			return 2
+		}
+		return runExportCommand(dbPath, hostID, output)
+
+	case "export-events":
+		if fs.NArg() != 0 {
+			fmt.Fprintln(os.Stderr, "Usage: cmonit db export-events [-config file] [-db file] [-host id] [-group name] [-service name] [-severity s] [-from date] [-to date] [-format csv|ndjson] [-o file]")
+			return 2
+		}
+		return runExportEventsCommand(dbPath, events, output)
+
+	case "import":
+		if fs.NArg() != 1 {
+			fmt.Fprintln(os.Stderr, "Usage: cmonit db import [-config file] [-db file] [-replace] <file|->")
+			return 2
+		}
+		return runImportCommand(dbPath, fs.Arg(0), replace)
+	}
+
+	fmt.Fprintf(os.Stderr, "Unknown db command %q\n%s\n", sub, usage)
+	return 2
+}
+
+// runExportCommand writes the dump of a host to output, or stdout.
+func runExportCommand(dbPath, hostID, output string) int {
+	database, err := db.OpenExisting(dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer database.Close()
+
+	w := os.Stdout
+	if output != "" {
+		// O_EXCL: never overwrite a file by mistake
+		f, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+
+	counts, err := db.ExportHost(database, hostID, w)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		if output != "" {
+			os.Remove(output)
+		}
+		return 1
+	}
+	if output != "" {
+		if err := w.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Exported host %s: %s\n", hostID, formatCounts(counts))
+	return 0
+}
+
+// eventExportFlags are the flags of "cmonit db export-events".
+type eventExportFlags struct {
+	host, group, service, severity string
+	from, to                       string
+	format                         string
+}
+
+// filter checks the flags and returns the events they select.
+func (f eventExportFlags) filter() (db.EventExportFilter, error) {
+	filter := db.EventExportFilter{HostID: f.host, Group: f.group, Service: f.service, EventType: -1, Severity: f.severity}
+	if f.format != db.EventExportCSV && f.format != db.EventExportNDJSON {
+		return filter, fmt.Errorf("invalid -format %q: must be csv or ndjson", f.format)
+	}
+	if f.severity != "" && !slices.Contains(db.Severities, f.severity) {
+		return filter, fmt.Errorf("invalid -severity %q: must be one of %s", f.severity, strings.Join(db.Severities, ", "))
+	}
+	var err error
+	if f.from != "" {
+		if filter.From, err = parseExportTime(f.from, false); err != nil {
+			return filter, fmt.Errorf("invalid -from %q: must be YYYY-MM-DD or an RFC 3339 time", f.from)
+		}
+	}
+	if f.to != "" {
+		if filter.To, err = parseExportTime(f.to, true); err != nil {
+			return filter, fmt.Errorf("invalid -to %q: must be YYYY-MM-DD or an RFC 3339 time", f.to)
+		}
+	}
+	return filter, nil
+}
+
+// parseExportTime parses a local YYYY-MM-DD date, the end of that day for
+// an end bound, or an RFC 3339 time.
+func parseExportTime(s string, end bool) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		if end {
+			t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+		}
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// runExportEventsCommand writes the events selected by flags to output, or
+// stdout.
+func runExportEventsCommand(dbPath string, flags eventExportFlags, output string) int {
+	filter, err := flags.filter()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+	database, err := db.OpenExisting(dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer database.Close()
+
+	w := os.Stdout
+	if output != "" {
+		// O_EXCL: never overwrite a file by mistake
+		f, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+
+	n, err := db.ExportEvents(database, filter, flags.format, w)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		if output != "" {
+			os.Remove(output)
+		}
+		return 1
+	}
+	if output != "" {
+		if err := w.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d events\n", n)
+	return 0
+}
+
+// runImportCommand adds the host of a dump (path, or "-" for stdin) to the
+// database, creating it if needed.
+func runImportCommand(dbPath, path string, replace bool) int {
+	r := os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		r = f
+	}
+
+	database, err := db.InitDB(dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+		return 1
+	}
+	defer database.Close()
+
+	hostID, counts, err := db.ImportHost(database, r, replace)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error importing %s: %v\n", path, err)
+		return 1
+	}
+	recordCLIAudit(database, db.AuditHostImport, hostID, formatCounts(counts))
+	fmt.Printf("Imported host %s: %s\n", hostID, formatCounts(counts))
+	return 0
+}
+
+// runImportMMonitCommand runs "cmonit import-mmonit <database>", reading
+// an M/Monit SQLite database (e.g. /usr/local/mmonit/db/mmonit.db) into
+// the cmonit database, created if needed.
+func runImportMMonitCommand(args []string) int {
+	fs, sf := newStorageFlagSet("import-mmonit")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: cmonit import-mmonit [-config file] [-db file] <M/Monit database>")
+		return 2
+	}
+	_, dbPath, err := sf.load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	src, err := db.OpenMMonit(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer src.Close()
+
+	database, err := db.InitDB(dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+		return 1
+	}
+	defer database.Close()
+
+	result, err := db.ImportMMonit(database, src)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error importing %s: %v\n", fs.Arg(0), err)
+		return 1
+	}
+	for _, skipped := range result.Skipped {
+		fmt.Fprintf(os.Stderr, "Skipped %s\n", skipped)
+	}
+	summary := fmt.Sprintf("hosts %d, host groups %d, events %d", result.Hosts, result.HostGroups, result.Events)
+	if result.Hosts > 0 {
+		recordCLIAudit(database, db.AuditHostImport, "mmonit:"+fs.Arg(0), summary)
+	}
+	fmt.Printf("Imported from %s: %s\n", fs.Arg(0), summary)
+	return 0
+}
+
+// runMonitConfigCommand runs "cmonit monit-config", printing the Monit
+// configuration of a host (-host) or of a new agent, to onboard agents by
+// copy-paste. The collector address and credentials are those of the
+// configuration file and environment.
+func runMonitConfigCommand(args []string) int {
+	fs, sf := newStorageFlagSet("monit-config")
+	hostID := fs.String("host", "", "Host ID (default: template for a new agent)")
+	collectorHost := fs.String("collector-host", "", "Name or address of cmonit for the agents (default: the collector listen address, or the host name)")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, "Usage: cmonit monit-config [-config file] [-db file] [-host id] [-collector-host name]")
+		return 2
+	}
+	cfg, dbPath, err := sf.load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if err := cfg.ResolveSecrets(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	address := buildAddress(config.MergeString(cfg.Network.Listen, "localhost:3000", "localhost:3000"),
+		config.MergeString(cfg.Network.CollectorPort, "8080", "8080"))
+	web.SetCollectorEndpoint(web.CollectorEndpoint{
+		Address:        address,
+		TLS:            cfg.Web.Cert != "" || cfg.Web.ACMEDomains != "",
+		User:           config.MergeString(cfg.Collector.User, "monit", "monit"),
+		Password:       config.MergeString(cfg.Collector.Password, "monit", "monit"),
+		PasswordHashed: cfg.Collector.PasswordFormat == "bcrypt",
+	})
+
+	if *collectorHost == "" {
+		host, _, _ := net.SplitHostPort(address)
+		if ip := net.ParseIP(host); host == "" || host == "localhost" || (ip != nil && (ip.IsUnspecified() || ip.IsLoopback())) {
+			host, _ = os.Hostname()
+		}
+		*collectorHost = host
+	}
+
+	if *hostID != "" {
+		database, err := db.OpenExisting(dbPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		defer database.Close()
+		web.SetDB(database)
+
+		keyFile := cfg.Storage.SecretKeyFile
+		if keyFile == "" {
+			keyFile = filepath.Join(filepath.Dir(dbPath), "cmonit.key")
+		}
+		if err := db.SetSecretKeyFile(keyFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+	}
+
+	snippet, err := web.MonitConfig(*hostID, *collectorHost, true)
+	if err == sql.ErrNoRows {
+		fmt.Fprintf(os.Stderr, "Error: host %s not found\n", *hostID)
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	fmt.Print(snippet)
+	return 0
+}
+
+// formatCounts formats row counts per table, e.g. "services 12, metrics 3400".
+func formatCounts(counts map[string]int) string {
+	tables := make([]string, 0, len(counts))
+	for table := range counts {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	parts := make([]string, 0, len(tables))
+	for _, table := range tables {
+		if counts[table] > 0 {
+			parts = append(parts, fmt.Sprintf("%s %d", table, counts[table]))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// runUserCommand runs "cmonit user <command>".
+//
+// The web UI has a single user, set in the configuration file, so users
+// cannot be added or removed from the command line yet; they can be
+// listed, get the [web] section with a new password hash to paste in the
+// configuration file, and their two-factor authentication, stored in the
+// database, can be reset.
+func runUserCommand(args []string) int {
+	const usage = "Usage: cmonit user list|passwd|reset-2fa [-config file] [-db file] [name]"
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+
+	switch sub := args[0]; sub {
+	case "reset-2fa":
+		fs, sf := newStorageFlagSet("user " + sub)
+		fs.Parse(args[1:])
+		if fs.NArg() != 1 {
+			fmt.Fprintln(os.Stderr, usage)
+			return 2
+		}
+		_, dbPath, err := sf.load()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		return runResetTOTPCommand(dbPath, fs.Arg(0))
+
+	case "list":
+		fs, sf := newStorageFlagSet("user " + sub)
+		fs.Parse(args[1:])
+		if fs.NArg() != 0 {
+			fmt.Fprintln(os.Stderr, usage)
+			return 2
+		}
+		cfg, dbPath, err := sf.load()
+		if err == nil {
+			err = cfg.ResolveSecrets()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		return runListUsersCommand(dbPath, cfg.Web.User)
+
+	case "passwd":
+		fs, sf := newStorageFlagSet("user " + sub)
+		fs.Parse(args[1:])
+		if fs.NArg() > 1 {
+			fmt.Fprintln(os.Stderr, usage)
+			return 2
+		}
+		cfg, _, err := sf.load()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		return runPasswdCommand(cfg.Web.User, fs.Arg(0))
+
+	case "add", "del":
+		fmt.Fprintf(os.Stderr, "Error: the web user is set by user and password in the [web] section of the config file (see: cmonit user passwd); \"user %s\" needs several web users, which are not supported yet\n", sub)
+		return 1
+
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown user command %q\n%s\n", sub, usage)
+		return 2
+	}
+}
+
+// runPasswdCommand reads a new password of the web user name (default: the
+// user of the configuration) from stdin and prints the [web] section with
+// its hash. The configuration file is not rewritten: the server uses the
+// new password once the section is pasted and the server restarted.
+func runPasswdCommand(user, name string) int {
+	switch {
+	case name == "" && user == "":
+		fmt.Fprintln(os.Stderr, "Error: no web user in the configuration (-config), give its name")
+		return 2
+	case name == "":
+		name = user
+	case user != "" && name != user:
+		fmt.Fprintf(os.Stderr, "Error: unknown web user %q, the web user is %q\n", name, user)
+		return 1
+	}
+
+	password, ok := readPassword()
+	if !ok {
+		return 1
+	}
+	return printPasswordHash(name, password)
+}
+
+// runListUsersCommand prints the web user of the configuration (-web-user
+// of the server is not known here) with its two-factor status.
+func runListUsersCommand(dbPath, user string) int {
+	if user == "" {
+		fmt.Println("No web user: set user and password in the [web] section of the config file (authentication is disabled)")
+		return 0
+	}
+
+	database, err := db.OpenExisting(dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer database.Close()
+
+	status, err := db.GetTOTPStatus(database, user)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	twoFactor := "off"
+	if status.Enabled {
+		twoFactor = fmt.Sprintf("on (%d recovery codes left)", status.RecoveryCodesLeft)
+	}
+	fmt.Printf("%-20s %-14s %s\n", "NAME", "SOURCE", "TWO-FACTOR")
+	fmt.Printf("%-20s %-14s %s\n", user, "config file", twoFactor)
+	return 0
+}
+
+// scopeFlag collects the scopes of "token create": -scope may be repeated
+// and holds comma-separated scopes.
+type scopeFlag []string
+
+func (f *scopeFlag) String() string { return strings.Join(*f, ",") }
+
+func (f *scopeFlag) Set(value string) error {
+	for _, s := range strings.Split(value, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			*f = append(*f, s)
+		}
+	}
+	return nil
+}
+
+// runTokenSubcommand runs "cmonit token create|list|revoke" (also run by
+// the deprecated -create-token, -revoke-token and -list-tokens flags), e.g.
+// to give a monitoring script access before the web UI is reachable:
+//
+//	cmonit token create -scope read:status grafana
+//
+// Roles (-role) are read from the [[role]] tables of -config.
+func runTokenSubcommand(args []string) int {
+	const usage = "Usage: cmonit token create|list|revoke [-config file] [-db file] [name]"
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+
+	sub := args[0]
+	fs, sf := newStorageFlagSet("token " + sub)
+	var scopes scopeFlag
+	var role string
+	if sub == "create" {
+		fs.Var(&scopes, "scope", "Scopes of the token, comma-separated or repeated: "+strings.Join(db.TokenScopes, ", ")+" (default read:status)")
+		fs.StringVar(&role, "role", "", "Role limiting the service actions of the token (defined by [[role]] in the config file)")
+	}
+	fs.Parse(args[1:])
+
+	switch sub {
+	case "create", "revoke":
+		if fs.NArg() != 1 {
+			fmt.Fprintf(os.Stderr, "Usage: cmonit token %s [-config file] [-db file] <name>\n", sub)
+			return 2
+		}
+	case "list":
+		if fs.NArg() != 0 {
+			fmt.Fprintln(os.Stderr, "Usage: cmonit token list [-config file] [-db file]")
+			return 2
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown token command %q\n%s\n", sub, usage)
+		return 2
+	}
+
+	cfg, dbPath, err := sf.load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	switch sub {
+	case "create":
+		var roles []web.Role
+		for _, rc := range cfg.Roles {
+			roles = append(roles, web.Role{Name: rc.Name, HostGroups: rc.HostGroups, Actions: rc.Actions})
+		}
+		if err := web.SetRoles(roles); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid role in config file: %v\n", err)
+			return 1
+		}
+		if len(scopes) == 0 {
+			scopes = scopeFlag{db.ScopeReadStatus}
+		}
+		if role != "" && !slices.Contains(web.RoleNames(), role) {
+			fmt.Fprintf(os.Stderr, "Error creating token: unknown role %q (define it with [[role]] in the -config file)\n", role)
+			return 1
+		}
+	}
+
+	database, err := db.InitDB(dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+		return 1
+	}
+	defer database.Close()
+
+	switch sub {
+	case "create":
+		return runTokenCreate(database, fs.Arg(0), scopes, role)
+	case "revoke":
+		return runTokenRevoke(database, fs.Arg(0))
+	default:
+		return runTokenList(database)
+	}
+}
+
+// runTokenCreate creates an API token and prints it, the only time it is
+// shown.
+func runTokenCreate(database *sql.DB, name string, scopes []string, role string) int {
+	token, info, err := db.CreateAPIToken(database, name, scopes, role, "cli")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating token: %v\n", err)
+		return 1
+	}
+	details := "scopes: " + strings.Join(info.Scopes, " ")
+	if info.Role != "" {
+		details += ", role: " + info.Role
+	}
+	recordCLIAudit(database, db.AuditTokenCreate, info.Name, details)
+	fmt.Printf("Created token %q (scopes: %s)\n\n", info.Name, strings.Join(info.Scopes, ", "))
+	fmt.Printf("%s\n\n", token)
+	fmt.Println("Store it now: it cannot be shown again. Use it as:")
+	fmt.Println("  Authorization: Bearer <token>")
+	return 0
+}
+
+// runTokenRevoke revokes the API token called name.
+func runTokenRevoke(database *sql.DB, name string) int {
+	if err := db.RevokeAPITokenByName(database, name); err != nil {
+		fmt.Fprintf(os.Stderr, "Error revoking token %q: %v\n", name, err)
+		return 1
+	}
+	recordCLIAudit(database, db.AuditTokenRevoke, name, "")
+	fmt.Printf("Revoked token %q\n", name)
+	return 0
+}
+
+// runTokenList prints the API tokens, without their values.
+func runTokenList(database *sql.DB) int {
+	tokens, err := db.ListAPITokens(database)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if len(tokens) == 0 {
+		fmt.Println("No API tokens")
+		return 0
+	}
+	fmt.Printf("%-20s %-16s %-30s %-16s %-20s %s\n", "NAME", "TOKEN", "SCOPES", "ROLE", "CREATED", "LAST USED")
+	for _, t := range tokens {
+		lastUsed := "never"
+		if t.LastUsed != nil {
+			lastUsed = t.LastUsed.Local().Format("2006-01-02 15:04")
+		}
+		role := t.Role
+		if role == "" {
+			role = "-"
+		}
+		fmt.Printf("%-20s %-16s %-30s %-16s %-20s %s\n", t.Name, t.Prefix+"...", strings.Join(t.Scopes, ","), role,
+			t.CreatedAt.Local().Format("2006-01-02 15:04"), lastUsed)
+	}
+	return 0
+}
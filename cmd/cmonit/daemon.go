@@ -0,0 +1,74 @@
+// daemon.go - readiness notifications to service managers.
+//
+// cmonit runs in the foreground by default, as supervisors (systemd,
+// daemontools, FreeBSD daemon(8)...) expect. It tells them when it is
+// ready, i.e. when both servers listen:
+//
+//   - systemd (Type=notify): READY=1, RELOADING=1 on SIGHUP, STOPPING=1,
+//     and WATCHDOG=1 keep-alives when WatchdogSec is set
+//   - -daemon: the parent waits for the child to be ready before exiting,
+//     with an error if it failed to start (see daemon_unix.go)
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// daemonizedEnv is set by -daemon in the environment of its child.
+const daemonizedEnv = "CMONIT_DAEMONIZED"
+
+// daemonReadyFD is the pipe on which the child of -daemon reports it is
+// ready to its parent.
+const daemonReadyFD = 3
+
+// daemonReadyPipe is the pipe to the parent of -daemon, in its child.
+var daemonReadyPipe *os.File
+
+// sdNotify sends state to the systemd notification socket (NOTIFY_SOCKET),
+// if there is one. Errors are only logged: cmonit works without it.
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:] // Abstract socket
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		log.Printf("[WARN] Failed to notify the service manager: %v", err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		log.Printf("[WARN] Failed to notify the service manager: %v", err)
+	}
+}
+
+// notifyReady reports that both servers listen: to systemd, to the parent
+// of -daemon, and starts the systemd watchdog keep-alives.
+func notifyReady() {
+	sdNotify("READY=1\nMAINPID=" + strconv.Itoa(os.Getpid()))
+
+	if daemonReadyPipe != nil {
+		daemonReadyPipe.Write([]byte("READY\n"))
+		daemonReadyPipe.Close()
+		daemonReadyPipe = nil
+	}
+
+	// WATCHDOG_USEC is set by systemd when WatchdogSec is configured;
+	// keep-alives are sent at half the interval, as recommended
+	if usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64); err == nil && usec > 0 {
+		interval := time.Duration(usec) * time.Microsecond / 2
+		go func() {
+			for range time.Tick(interval) {
+				sdNotify("WATCHDOG=1")
+			}
+		}()
+	}
+}
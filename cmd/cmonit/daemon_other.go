@@ -0,0 +1,14 @@
+//go:build !unix
+
+package main
+
+import "fmt"
+
+// daemonize is not available without Unix sessions: cmonit runs in the
+// foreground under the service manager of the system instead.
+func daemonize(args []string) (int, error) {
+	return 0, fmt.Errorf("-daemon is not supported on this system, run cmonit in the foreground under a service manager")
+}
+
+// openDaemonReadyPipe does nothing: there is no -daemon child.
+func openDaemonReadyPipe() {}
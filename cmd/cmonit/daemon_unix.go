@@ -0,0 +1,85 @@
+//go:build unix
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// daemonize starts cmonit again in the background (-daemon), in a new
+// session with stdin, stdout and stderr on /dev/null, and waits until it
+// is ready: the parent then exits, with an error if the child failed to
+// start. The child writes the PID file as usual; logs should go to syslog
+// (-syslog), they are discarded otherwise.
+//
+// Returns the PID of the child.
+func daemonize(args []string) (int, error) {
+	execPath, err := os.Executable()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get executable path: %w", err)
+	}
+
+	devNull, err := os.OpenFile(os.DevNull, os.O_RDWR, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer devNull.Close()
+
+	// The child writes READY to the pipe once its servers listen
+	ready, readyWriter, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer ready.Close()
+
+	childArgs := []string{execPath}
+	for _, arg := range args {
+		// Skip the -daemon flag for the child process
+		if arg != "-daemon" && arg != "--daemon" && arg != "-daemon=true" {
+			childArgs = append(childArgs, arg)
+		}
+	}
+
+	process, err := os.StartProcess(execPath, childArgs, &os.ProcAttr{
+		Env: append(os.Environ(), daemonizedEnv+"=1"),
+		// stdin, stdout, stderr, then the ready pipe (daemonReadyFD)
+		Files: []*os.File{devNull, devNull, devNull, readyWriter},
+		Sys: &syscall.SysProcAttr{
+			Setsid: true, // Create new session (detach from terminal)
+		},
+	})
+	readyWriter.Close() // Only the child writes
+	if err != nil {
+		return 0, err
+	}
+
+	// EOF before READY: the child exited (or closed the pipe) during startup
+	line, _ := bufio.NewReader(ready).ReadString('\n')
+	if line != "READY\n" {
+		state, _ := process.Wait()
+		if state != nil {
+			return 0, fmt.Errorf("cmonit failed to start (%s), see its logs", state)
+		}
+		return 0, fmt.Errorf("cmonit failed to start, see its logs")
+	}
+	pid := process.Pid // Reset by Release
+	process.Release()
+	return pid, nil
+}
+
+// openDaemonReadyPipe takes the pipe to the parent in the child of
+// -daemon. The descriptor is only used if it is a pipe, so that nothing
+// else is ever written to or closed.
+func openDaemonReadyPipe() {
+	if os.Getenv(daemonizedEnv) != "1" {
+		return
+	}
+	var st syscall.Stat_t
+	if err := syscall.Fstat(daemonReadyFD, &st); err != nil || st.Mode&syscall.S_IFMT != syscall.S_IFIFO {
+		return // Not started by -daemon: leave the descriptor alone
+	}
+	daemonReadyPipe = os.NewFile(daemonReadyFD, "ready")
+}
@@ -18,30 +18,36 @@ import (
 	// These are packages built into Go - no need to install separately
 
 	"compress/gzip"  // Gzip compression/decompression
+	"crypto/tls"     // TLS configuration (ACME certificates)
 	"database/sql"   // SQL database interface
 	"flag"           // Command-line flag parsing
 	"fmt"            // Formatted I/O - like printf() in C
 	"io"             // I/O operations
 	"log"            // Logging to stderr with timestamps
-	"log/syslog"     // Syslog support for daemon logging
+	"net"            // Listen address checks (-check-config)
 	"net/http"       // HTTP client and server functionality
 	"os"             // Operating system functions (exit codes, etc.)
 	"os/signal"      // Signal handling for graceful shutdown
 	"path/filepath"  // File path manipulation
 	"strconv"        // String conversion utilities
 	"strings"        // String manipulation
+	"sync"           // Mutex for the reloadable TLS certificate
 	"syscall"        // System call interface (for signal constants)
 	"time"           // Time operations and ticker
 
 	// External packages
-	"golang.org/x/crypto/bcrypt" // Bcrypt password hashing
+	"golang.org/x/crypto/acme"          // ACME client (custom CA directory)
+	"golang.org/x/crypto/acme/autocert" // Automatic Let's Encrypt certificates
+	"golang.org/x/crypto/bcrypt"        // Bcrypt password hashing
 
 	// Internal packages (our code)
 	// These are relative to the module path (github.com/ocochard/cmonit)
-	"github.com/ocochard/cmonit/internal/config" // Configuration file support
-	"github.com/ocochard/cmonit/internal/db"     // Database operations
-	"github.com/ocochard/cmonit/internal/parser" // XML parser
-	"github.com/ocochard/cmonit/internal/web"    // Web UI handlers
+	"github.com/ocochard/cmonit/internal/config"  // Configuration file support
+	"github.com/ocochard/cmonit/internal/control" // Monit agent client settings
+	"github.com/ocochard/cmonit/internal/db"      // Database operations
+	"github.com/ocochard/cmonit/internal/forward" // Event forwarding (syslog...)
+	"github.com/ocochard/cmonit/internal/parser"  // XML parser
+	"github.com/ocochard/cmonit/internal/web"     // Web UI handlers
 )
 
 // Global variable to hold the database connection
@@ -63,6 +69,22 @@ var globalDB *sql.DB
 // Controlled by the -debug command-line flag.
 var debugEnabled bool
 
+// parseDiagnosticsEnabled records the parse warnings of the status
+// documents in the database (-parse-diagnostics).
+var parseDiagnosticsEnabled bool
+
+// strictCollector rejects the status documents failing parser.Validate
+// with 400, and counts them per host (-strict).
+var strictCollector bool
+
+// metricForwarder sends the stored metrics to the [export.*] sinks,
+// notified by handleCollector after each status is stored.
+var metricForwarder forward.MetricForwarder
+
+// heartbeat pings the [heartbeat] URLs after each status is stored, nil
+// without any.
+var heartbeat *forward.Pinger
+
 // version is the application version number.
 //
 // This variable is set at build time using -ldflags:
@@ -86,15 +108,95 @@ var collectorAuthPasswordFormat string
 // main is the entry point of the program
 // Go programs always start execution here
 //
+// The first argument selects a subcommand ("cmonit serve", "cmonit db
+// backup", see commands.go). Without one, the flags alone run the server
+// or the utility flags, as older versions did (deprecated).
+//
+// Note: main() doesn't return a value like in C
+// Use os.Exit(code) to return exit codes
+func main() {
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		os.Exit(runCommand(os.Args[1], os.Args[2:]))
+	}
+	serve(os.Args[1:], true)
+}
+
+// flagConfigKeys maps the server flags to the config keys they override,
+// so that validation messages name the flag that set a value.
+var flagConfigKeys = map[string]string{
+	"listen":                    "network.listen",
+	"collector":                 "network.collector_port",
+	"collector-user":            "collector.user",
+	"collector-password":        "collector.password",
+	"collector-password-format": "collector.password_format",
+	"strict":                    "collector.strict",
+	"web-user":                  "web.user",
+	"web-password":              "web.password",
+	"web-password-format":       "web.password_format",
+	"session-idle-timeout":      "web.session_idle_timeout",
+	"session-remember":          "web.session_remember",
+	"totp-policy":               "web.totp_policy",
+	"public-status":             "web.public_status",
+	"tls-cert":                  "web.cert",
+	"tls-key":                   "web.key",
+	"acme-domains":              "web.acme_domains",
+	"acme-email":                "web.acme_email",
+	"acme-cache":                "web.acme_cache",
+	"acme-http":                 "web.acme_http_listen",
+	"acme-directory":            "web.acme_directory",
+	"monit-ca-file":             "control.ca_file",
+	"monit-connect-timeout":     "control.connect_timeout",
+	"monit-timeout":             "control.timeout",
+	"monit-attempts":            "control.attempts",
+	"monit-retry-backoff":       "control.retry_backoff",
+	"action-concurrency":        "control.concurrency",
+	"action-host-concurrency":   "control.host_concurrency",
+	"db":                        "storage.database",
+	"pidfile":                   "storage.pidfile",
+	"secret-key-file":           "storage.secret_key_file",
+	"retention-days":            "storage.retention_days",
+	"program-output-max":        "storage.program_output_max",
+	"program-full-output":       "storage.program_full_output",
+	"syslog":                    "logging.syslog",
+	"debug":                     "logging.debug",
+	"parse-diagnostics":         "logging.parse_diagnostics",
+	"daemon":                    "process.daemon",
+	"supervised":                "process.supervised",
+}
+
+// formatAge formats a retention age in days when it is a whole number of
+// days, e.g. "30d".
+func formatAge(d time.Duration) string {
+	if d%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	}
+	return d.String()
+}
+
+// formatHostAge formats an age of the host retention, 0 being never.
+func formatHostAge(d time.Duration) string {
+	if d == 0 {
+		return "never"
+	}
+	return formatAge(d)
+}
+
+// serve runs the server ("cmonit serve"), parsing its flags from args.
+//
 // This function:
 // 1. Prints a startup message
 // 2. Sets up the HTTP routes (URL paths and their handlers)
 // 3. Starts the HTTP server
 // 4. Waits for termination signals
 //
-// Note: main() doesn't return a value like in C
-// Use os.Exit(code) to return exit codes
-func main() {
+// legacy is set when no subcommand was given: the utility flags
+// (-hash-password, -check-config, -reset-2fa) then still work, with a
+// deprecation warning.
+func serve(args []string, legacy bool) {
+	// In the child of -daemon, take the pipe to the parent before any
+	// file is opened
+	openDaemonReadyPipe()
+
 	// Define command-line flags
 	//
 	// flag.String() creates a string flag with:
@@ -117,16 +219,46 @@ func main() {
 		"Web UI listen address (e.g., localhost:3000, 0.0.0.0:3000, [::]:3000, 192.168.1.10:3000)")
 
 	webUser := flag.String("web-user", "",
-		"Web UI HTTP Basic Auth username (empty = no authentication)")
+		"Web UI username for the login page and HTTP Basic Auth (empty = no authentication)")
 
 	webPassword := flag.String("web-password", "",
-		"Web UI HTTP Basic Auth password (empty = no authentication)")
+		"Web UI password (empty = no authentication)")
 
 	webPasswordFormat := flag.String("web-password-format", "plain",
 		"Web UI password format: 'plain' or 'bcrypt' (default: plain)")
 
+	sessionIdleTimeout := flag.String("session-idle-timeout", "30m",
+		"Web UI login session idle timeout (e.g., 30m, 2h)")
+
+	sessionRemember := flag.String("session-remember", "720h",
+		"Lifetime of \"remember me\" login sessions (0 = disable \"remember me\")")
+
+	totpPolicy := flag.String("totp-policy", "optional",
+		"Two-factor authentication policy: 'off', 'optional' or 'required'")
+
+	resetTOTP := flag.String("reset-2fa", "",
+		"Remove two-factor authentication of this web user and exit (deprecated: cmonit user reset-2fa)")
+
+	publicStatus := flag.Bool("public-status", false,
+		"Serve an unauthenticated read-only status page at /public (opted-in hosts only)")
+
 	hashPassword := flag.String("hash-password", "",
-		"Generate bcrypt hash for given password and exit (utility command)")
+		"Generate bcrypt hash for given password and exit (deprecated: cmonit hash-password)")
+
+	createToken := flag.String("create-token", "",
+		"Create an API token with this name, print it and exit (deprecated: cmonit token create)")
+
+	tokenScopes := flag.String("token-scopes", "read:status",
+		"Comma-separated scopes for -create-token: read:status, write:actions, write:events, admin")
+
+	tokenRole := flag.String("token-role", "",
+		"Role for -create-token, limiting its service actions (defined by [[role]] in the config file)")
+
+	revokeToken := flag.String("revoke-token", "",
+		"Revoke the API token with this name and exit (deprecated: cmonit token revoke)")
+
+	listTokens := flag.Bool("list-tokens", false,
+		"List API tokens and exit (deprecated: cmonit token list)")
 
 	tlsCert := flag.String("tls-cert", "",
 		"TLS certificate file for both Web UI and Collector (empty = HTTP only)")
@@ -134,18 +266,66 @@ func main() {
 	tlsKey := flag.String("tls-key", "",
 		"TLS key file for both Web UI and Collector (empty = HTTP only)")
 
+	acmeDomains := flag.String("acme-domains", "",
+		"Comma-separated domains to obtain and renew certificates for with ACME/Let's Encrypt, instead of -tls-cert/-tls-key")
+
+	acmeEmail := flag.String("acme-email", "",
+		"Contact email for the ACME account (expiry and problem notices)")
+
+	acmeCache := flag.String("acme-cache", "",
+		"Directory storing ACME account keys and certificates (default: \"acme\" next to the database)")
+
+	acmeHTTP := flag.String("acme-http", ":80",
+		"Address answering ACME HTTP-01 challenges and redirecting to HTTPS (\"off\" = TLS-ALPN-01 only, web UI must listen on port 443)")
+
+	acmeDirectory := flag.String("acme-directory", "",
+		"ACME directory URL (default: Let's Encrypt production; e.g. https://acme-staging-v02.api.letsencrypt.org/directory)")
+
+	monitCAFile := flag.String("monit-ca-file", "",
+		"CA bundle verifying the certificates of Monit agents using HTTPS (empty = system roots; can be overridden per host)")
+
+	monitConnectTimeout := flag.String("monit-connect-timeout", "5s",
+		"Timeout connecting to Monit agents (e.g., 5s)")
+
+	monitTimeout := flag.String("monit-timeout", "10s",
+		"Timeout of a whole request to a Monit agent (e.g., 10s)")
+
+	monitAttempts := flag.Int("monit-attempts", 3,
+		"Tries of a request to a Monit agent failing on the network (1 = no retry; actions are only retried if the connection failed)")
+
+	monitRetryBackoff := flag.String("monit-retry-backoff", "1s",
+		"Wait before retrying a request to a Monit agent, doubled after each retry")
+
+	actionConcurrency := flag.Int("action-concurrency", 8,
+		"Service actions sent to Monit agents at the same time (bulk and scheduled actions wait their turn)")
+
+	actionHostConcurrency := flag.Int("action-host-concurrency", 1,
+		"Service actions sent to the same Monit agent at the same time")
+
 	dbPath := flag.String("db", "/var/run/cmonit/cmonit.db",
 		"Database file path")
 
 	pidFile := flag.String("pidfile", "/var/run/cmonit/cmonit.pid",
 		"PID file path")
 
+	secretKeyFile := flag.String("secret-key-file", "",
+		"Key file encrypting the secrets stored in the database, created on first use (default: cmonit.key next to the database)")
+
+	programOutputMax := flag.Int("program-output-max", 4096,
+		"Truncate the output of program services beyond this many bytes before storing it (-1 = no limit)")
+
+	programFullOutput := flag.Bool("program-full-output", false,
+		"Also store the whole output of the truncated program outputs")
+
 	syslogFacility := flag.String("syslog", "",
 		"Syslog facility (daemon, local0-local7, or empty for stderr logging)")
 
 	debugFlag := flag.Bool("debug", false,
 		"Enable verbose DEBUG logging for troubleshooting")
 
+	parseDiagnostics := flag.Bool("parse-diagnostics", false,
+		"Record the problems found in the agents' status documents in the database")
+
 	collectorUser := flag.String("collector-user", "monit",
 		"Collector HTTP Basic Auth username (Monit agents must use this)")
 
@@ -155,97 +335,436 @@ func main() {
 	collectorPasswordFormat := flag.String("collector-password-format", "plain",
 		"Collector password format: 'plain' or 'bcrypt' (default: plain)")
 
+	strict := flag.Bool("strict", false,
+		"Reject the status documents with missing or out of range fields (400) and count them per host")
+
 	daemonMode := flag.Bool("daemon", false,
-		"Run in background as a daemon process")
+		"Run in background as a daemon process (Unix only; logs to -syslog)")
+
+	supervised := flag.Bool("supervised", false,
+		"Run in the foreground under a service manager (systemd, daemon(8)...): logs without timestamps, readiness notification")
 
 	configFile := flag.String("config", "",
-		"Configuration file path (TOML format, optional)")
+		"Configuration file path (TOML format, or YAML if named *.yaml or *.yml; optional)")
+
+	checkConfig := flag.Bool("check-config", false,
+		"Check the configuration (config file, environment and flags), print its problems and exit (deprecated: cmonit check-config)")
 
 	retentionDays := flag.Int("retention-days", 30,
 		"Days of metrics/events history to keep; older rows are pruned hourly")
 
 	// Parse command-line flags
 	//
-	// flag.Parse() processes os.Args (command-line arguments)
+	// Parse() processes args (the arguments after "serve")
 	// After this call, *collectorAddr and *webAddr contain the values
 	//
 	// Example usage:
-	//   ./cmonit                                 # Use defaults
-	//   ./cmonit -listen 0.0.0.0:3000           # Web accessible from anywhere
-	//   ./cmonit -listen [::]:3000              # IPv6 all interfaces
-	//   ./cmonit -collector 9000 -listen :4000  # Custom ports
-	flag.Parse()
+	//   ./cmonit serve                                 # Use defaults
+	//   ./cmonit serve -listen 0.0.0.0:3000           # Web accessible from anywhere
+	//   ./cmonit serve -listen [::]:3000              # IPv6 all interfaces
+	//   ./cmonit serve -collector 9000 -listen :4000  # Custom ports
+	flag.Usage = serveUsage
+	flag.CommandLine.Parse(args)
+
+	if legacy {
+		warnDeprecatedFlags(*hashPassword != "", *checkConfig, *resetTOTP != "", *createToken != "", *revokeToken != "", *listTokens)
+	}
 
 	// Handle -hash-password utility command
 	//
 	// This is a convenience command to generate bcrypt hashes for passwords.
-	// Usage: ./cmonit -hash-password "mypassword"
+	// Usage: ./cmonit -hash-password "mypassword" (deprecated: cmonit hash-password)
 	//
 	// The generated hash can be used in the configuration file:
 	//   [web]
 	//   password = "$2a$10$..."
 	//   password_format = "bcrypt"
 	if *hashPassword != "" {
-		// Generate bcrypt hash with cost 10 (default, balanced security/performance)
-		//
-		// bcrypt.GenerateFromPassword() creates a hash that includes:
-		// - Algorithm version ($2a$, $2b$, etc.)
-		// - Cost factor (10 = 2^10 iterations)
-		// - Salt (random, included in the hash)
-		// - Hash of password+salt
-		//
-		// Example output: $2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy
-		hash, err := bcrypt.GenerateFromPassword([]byte(*hashPassword), bcrypt.DefaultCost)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error generating bcrypt hash: %v\n", err)
-			os.Exit(1)
-		}
-
-		// Print the hash to stdout
-		fmt.Printf("Bcrypt hash: %s\n\n", string(hash))
-		fmt.Println("Add this to your configuration file:")
-		fmt.Println("[web]")
-		fmt.Println("user = \"admin\"")
-		fmt.Printf("password = \"%s\"\n", string(hash))
-		fmt.Println("password_format = \"bcrypt\"")
-		os.Exit(0)
+		os.Exit(printPasswordHash("admin", *hashPassword))
 	}
 
+	// Roles limiting the service actions of API tokens, only defined in
+	// the config file ([[role]] tables)
+	var roles []web.Role
+
 	// Load configuration file if specified
 	//
-	// Config file provides defaults, CLI flags override them
-	// Priority: CLI flags > Config file > Built-in defaults
+	// Config file provides defaults, environment variables and CLI flags
+	// override them
+	// Priority: CLI flags > CMONIT_* environment variables > Config file > Built-in defaults
+	//
+	// With -check-config, invalid settings are collected in configProblems
+	// and reported together instead of stopping at the first one.
+	var configProblems []string
+	configError := func(format string, args ...interface{}) {
+		if *checkConfig {
+			configProblems = append(configProblems, fmt.Sprintf(format, args...))
+			return
+		}
+		log.Fatalf("[FATAL] "+format, args...)
+	}
+
+	cfg := &config.Config{}
 	if *configFile != "" {
-		cfg, err := config.Load(*configFile)
+		var err error
+		cfg, err = config.Load(*configFile)
 		if err != nil {
 			log.Fatalf("[FATAL] Failed to load config file: %v", err)
 		}
 
 		log.Printf("[INFO] Loaded configuration from: %s", *configFile)
+		if cfg.Include != "" {
+			log.Printf("[INFO] Included configuration files: %s", cfg.Include)
+		}
 
-		// Merge config file values with CLI flags
-		// CLI flags take priority if they differ from defaults
-		//
-		// We merge each setting, checking if the CLI flag was explicitly set
-		// (differs from default) or if we should use the config file value
-		*collectorAddr = config.MergeString(cfg.Network.CollectorPort, *collectorAddr, "8080")
-		*webAddr = config.MergeString(cfg.Network.Listen, *webAddr, "localhost:3000")
-		*collectorUser = config.MergeString(cfg.Collector.User, *collectorUser, "monit")
-		*collectorPassword = config.MergeString(cfg.Collector.Password, *collectorPassword, "monit")
-		*collectorPasswordFormat = config.MergeString(cfg.Collector.PasswordFormat, *collectorPasswordFormat, "plain")
-		*webUser = config.MergeString(cfg.Web.User, *webUser, "")
-		*webPassword = config.MergeString(cfg.Web.Password, *webPassword, "")
-		*webPasswordFormat = config.MergeString(cfg.Web.PasswordFormat, *webPasswordFormat, "plain")
-		*tlsCert = config.MergeString(cfg.Web.Cert, *tlsCert, "")
-		*tlsKey = config.MergeString(cfg.Web.Key, *tlsKey, "")
-		*dbPath = config.MergeString(cfg.Storage.Database, *dbPath, "/var/run/cmonit/cmonit.db")
-		*pidFile = config.MergeString(cfg.Storage.PidFile, *pidFile, "/var/run/cmonit/cmonit.pid")
-		*syslogFacility = config.MergeString(cfg.Logging.Syslog, *syslogFacility, "")
-		*debugFlag = config.MergeBool(cfg.Logging.Debug, *debugFlag)
-		*daemonMode = config.MergeBool(cfg.Process.Daemon, *daemonMode)
-		*retentionDays = config.MergeInt(cfg.Storage.RetentionDays, *retentionDays, 30)
+		for _, key := range cfg.Unknown {
+			if *checkConfig {
+				configError("Unknown key in config file: %s", key)
+			} else {
+				log.Printf("[WARNING] Ignoring unknown key in config file: %s", key)
+			}
+		}
+	}
+
+	// Environment variables override the config file keys, e.g.
+	// CMONIT_WEB_PASSWORD for password in [web]
+	unknownEnv, err := config.ApplyEnv(cfg, os.Environ())
+	if err != nil {
+		configError("%v", err)
+	}
+	for _, name := range unknownEnv {
+		if name != daemonizedEnv { // Set by -daemon for its child
+			log.Printf("[WARNING] Ignoring environment variable %s: no such configuration key", name)
+		}
+	}
+
+	// Credentials may come from files or ${NAME} environment references
+	if err := cfg.ResolveSecrets(); err != nil {
+		configError("Invalid credentials in config file: %v", err)
+	}
+
+	// Merge config file and environment values with CLI flags
+	// CLI flags take priority if they differ from defaults
+	//
+	// We merge each setting, checking if the CLI flag was explicitly set
+	// (differs from default) or if we should use the config file value
+	*collectorAddr = config.MergeString(cfg.Network.CollectorPort, *collectorAddr, "8080")
+	*webAddr = config.MergeString(cfg.Network.Listen, *webAddr, "localhost:3000")
+	*collectorUser = config.MergeString(cfg.Collector.User, *collectorUser, "monit")
+	*collectorPassword = config.MergeString(cfg.Collector.Password, *collectorPassword, "monit")
+	*collectorPasswordFormat = config.MergeString(cfg.Collector.PasswordFormat, *collectorPasswordFormat, "plain")
+	*strict = config.MergeBool(cfg.Collector.Strict, *strict)
+	*webUser = config.MergeString(cfg.Web.User, *webUser, "")
+	*webPassword = config.MergeString(cfg.Web.Password, *webPassword, "")
+	*webPasswordFormat = config.MergeString(cfg.Web.PasswordFormat, *webPasswordFormat, "plain")
+	*sessionIdleTimeout = config.MergeString(cfg.Web.SessionIdleTimeout, *sessionIdleTimeout, "30m")
+	*sessionRemember = config.MergeString(cfg.Web.SessionRemember, *sessionRemember, "720h")
+	*totpPolicy = config.MergeString(cfg.Web.TOTPPolicy, *totpPolicy, "optional")
+	*publicStatus = config.MergeBool(cfg.Web.PublicStatus, *publicStatus)
+	*tlsCert = config.MergeString(cfg.Web.Cert, *tlsCert, "")
+	*tlsKey = config.MergeString(cfg.Web.Key, *tlsKey, "")
+	*acmeDomains = config.MergeString(cfg.Web.ACMEDomains, *acmeDomains, "")
+	*acmeEmail = config.MergeString(cfg.Web.ACMEEmail, *acmeEmail, "")
+	*acmeCache = config.MergeString(cfg.Web.ACMECache, *acmeCache, "")
+	*acmeHTTP = config.MergeString(cfg.Web.ACMEHTTP, *acmeHTTP, ":80")
+	*acmeDirectory = config.MergeString(cfg.Web.ACMEDirectory, *acmeDirectory, "")
+	*monitCAFile = config.MergeString(cfg.Control.CAFile, *monitCAFile, "")
+	*monitConnectTimeout = config.MergeString(cfg.Control.ConnectTimeout, *monitConnectTimeout, "5s")
+	*monitTimeout = config.MergeString(cfg.Control.Timeout, *monitTimeout, "10s")
+	*monitAttempts = config.MergeInt(cfg.Control.Attempts, *monitAttempts, 3)
+	*monitRetryBackoff = config.MergeString(cfg.Control.RetryBackoff, *monitRetryBackoff, "1s")
+	*actionConcurrency = config.MergeInt(cfg.Control.Concurrency, *actionConcurrency, 8)
+	*actionHostConcurrency = config.MergeInt(cfg.Control.HostConcurrency, *actionHostConcurrency, 1)
+	*dbPath = config.MergeString(cfg.Storage.Database, *dbPath, "/var/run/cmonit/cmonit.db")
+	*pidFile = config.MergeString(cfg.Storage.PidFile, *pidFile, "/var/run/cmonit/cmonit.pid")
+	*secretKeyFile = config.MergeString(cfg.Storage.SecretKeyFile, *secretKeyFile, "")
+	*syslogFacility = config.MergeString(cfg.Logging.Syslog, *syslogFacility, "")
+	*debugFlag = config.MergeBool(cfg.Logging.Debug, *debugFlag)
+	*parseDiagnostics = config.MergeBool(cfg.Logging.ParseDiagnostics, *parseDiagnostics)
+	*daemonMode = config.MergeBool(cfg.Process.Daemon, *daemonMode)
+	*supervised = config.MergeBool(cfg.Process.Supervised, *supervised)
+	*retentionDays = config.MergeInt(cfg.Storage.RetentionDays, *retentionDays, 30)
+	*programOutputMax = config.MergeInt(cfg.Storage.ProgramOutputMax, *programOutputMax, 4096)
+	*programFullOutput = config.MergeBool(cfg.Storage.ProgramFullOutput, *programFullOutput)
+
+	// The merged settings, as a config file recording where each key was
+	// set, for the validation messages and print-config
+	effective := *cfg
+	effective.Network = config.NetworkConfig{Listen: *webAddr, CollectorPort: *collectorAddr}
+	effective.Collector = config.CollectorConfig{
+		User:           *collectorUser,
+		Password:       *collectorPassword,
+		PasswordFormat: *collectorPasswordFormat,
+		Strict:         *strict,
+	}
+	effective.Web = config.WebConfig{
+		User:               *webUser,
+		Password:           *webPassword,
+		PasswordFormat:     *webPasswordFormat,
+		SessionIdleTimeout: *sessionIdleTimeout,
+		SessionRemember:    *sessionRemember,
+		TOTPPolicy:         *totpPolicy,
+		Cert:               *tlsCert,
+		Key:                *tlsKey,
+		ACMEDomains:        *acmeDomains,
+		ACMEEmail:          *acmeEmail,
+		ACMECache:          *acmeCache,
+		ACMEHTTP:           *acmeHTTP,
+		ACMEDirectory:      *acmeDirectory,
+		PublicStatus:       *publicStatus,
+	}
+	effective.Storage = config.StorageConfig{
+		Database:          *dbPath,
+		PidFile:           *pidFile,
+		SecretKeyFile:     *secretKeyFile,
+		RetentionDays:     *retentionDays,
+		ProgramOutputMax:  *programOutputMax,
+		ProgramFullOutput: *programFullOutput,
+	}
+	effective.Logging = config.LoggingConfig{Syslog: *syslogFacility, Debug: *debugFlag, ParseDiagnostics: *parseDiagnostics}
+	effective.Process = config.ProcessConfig{Daemon: *daemonMode, Supervised: *supervised}
+	effective.Control = config.ControlConfig{
+		CAFile:          *monitCAFile,
+		ConnectTimeout:  *monitConnectTimeout,
+		Timeout:         *monitTimeout,
+		Attempts:        *monitAttempts,
+		RetryBackoff:    *monitRetryBackoff,
+		Concurrency:     *actionConcurrency,
+		HostConcurrency: *actionHostConcurrency,
+	}
+	flag.Visit(func(f *flag.Flag) {
+		if key, ok := flagConfigKeys[f.Name]; ok {
+			effective.SetOrigin(key, "-"+f.Name)
+		}
+	})
+	if effective.Origin("storage.retention_days") == "-retention-days" {
+		// The flag overrides both ages of the file
+		effective.Retention.Metrics, effective.Retention.Events = "", ""
+	}
+
+	for _, problem := range effective.Validate() {
+		configError("Invalid setting: %s", problem)
+	}
+	if *daemonMode && *supervised {
+		configError("-daemon and -supervised cannot be used together: under a service manager, cmonit stays in the foreground")
+	}
+	metricsRetention, eventsRetention, _ := effective.RetentionAges()
+	eventRetention := db.EventRetention{
+		Age:       eventsRetention,
+		PerHost:   effective.Retention.EventsPerHost,
+		Aggregate: effective.Retention.AggregateEvents,
+	}
+	archiveHosts, deleteHosts, deleteWarning, _ := effective.HostRetention()
+	hostPurge := db.HostPurge{
+		ArchiveAfter:  archiveHosts,
+		DeleteAfter:   deleteHosts,
+		DeleteWarning: deleteWarning,
+	}
+
+	for _, rc := range cfg.Roles {
+		roles = append(roles, web.Role{Name: rc.Name, HostGroups: rc.HostGroups, Actions: rc.Actions})
+	}
+
+	if err := web.SetRoles(roles); err != nil {
+		configError("Invalid role in config file: %v", err)
+	}
+
+	var severityRules []db.SeverityRule
+	for _, sc := range cfg.Severity {
+		severityRules = append(severityRules, db.SeverityRule{
+			Events: sc.Events, ServiceTypes: sc.ServiceTypes, States: sc.States, Severity: sc.Severity})
+	}
+	if err := db.SetSeverityRules(severityRules); err != nil {
+		configError("Invalid severity rule in config file: %v", err)
+	}
+
+	// Invalid windows were reported by Validate
+	flapWindow, _ := time.ParseDuration(effective.Flapping.Window)
+	if err := db.SetFlapPolicy(db.FlapPolicy{Changes: effective.Flapping.Changes, Window: flapWindow}); err != nil {
+		configError("Invalid [flapping]: %v", err)
+	}
+
+	// Sinks of the stored events, fed by the forwarding job
+	var forwarder forward.Forwarder
+	if sc := effective.EventSyslog; sc.Target != "" {
+		sink, err := forward.NewSyslogSink(sc.Target, sc.Facility, sc.AppName)
+		if err == nil {
+			err = forwarder.Add(sink, sc.MinSeverity)
+		}
+		if err != nil {
+			configError("Invalid [event_syslog]: %v", err)
+		}
+	}
+	if sc := effective.EventSIEM; sc.Target != "" {
+		format := sc.Format
+		if format == "" {
+			format = forward.FormatCEF
+		}
+		sink, err := forward.NewSIEMSink(format, sc.Target, sc.Facility, sc.Token, sc.Fields, version)
+		if err == nil {
+			err = forwarder.Add(sink, sc.MinSeverity)
+		}
+		if err != nil {
+			configError("Invalid [event_siem]: %v", err)
+		}
+	}
+	if bc := effective.EventBus; bc.URL != "" {
+		sink, err := forward.NewBusSink(bc.URL, bc.Topic, bc.User, bc.Password, version)
+		if err == nil {
+			err = forwarder.Add(sink, bc.MinSeverity)
+		}
+		if err != nil {
+			configError("Invalid [event_bus]: %v", err)
+		}
+	}
+	if nc := effective.EventNagios; nc.URL != "" {
+		api := nc.API
+		if api == "" {
+			api = forward.NagiosNRDP
+		}
+		sink, err := forward.NewNagiosSink(api, nc.URL, nc.Token, nc.User, nc.Password)
+		if err == nil {
+			err = forwarder.Add(sink, nc.MinSeverity)
+		}
+		if err != nil {
+			configError("Invalid [event_nagios]: %v", err)
+		}
+	}
+	var snmpSink *forward.SNMPSink
+	if sc := effective.EventSNMP; sc.Target != "" {
+		minSeverity := sc.MinSeverity
+		if minSeverity == "" {
+			minSeverity = db.SeverityCritical
+		}
+		sink, err := forward.NewSNMPSink(forward.SNMPConfig{
+			Target: sc.Target, Version: sc.Version, Community: sc.Community,
+			User: sc.User, AuthProtocol: sc.AuthProtocol, AuthPassword: sc.AuthPassword,
+			PrivProtocol: sc.PrivProtocol, PrivPassword: sc.PrivPassword, EngineID: sc.EngineID,
+		})
+		if err == nil {
+			err = forwarder.Add(sink, minSeverity)
+		}
+		if err != nil {
+			configError("Invalid [event_snmp]: %v", err)
+		} else {
+			snmpSink = sink
+		}
+	}
+
+	if gc := effective.EventGrafana; gc.URL != "" {
+		sink, err := forward.NewGrafanaSink(gc.URL, gc.Token, gc.User, gc.Password, gc.DashboardUID, gc.Tags, gc.Events)
+		if err == nil {
+			err = forwarder.Add(sink, gc.MinSeverity)
+		}
+		if err != nil {
+			configError("Invalid [event_grafana]: %v", err)
+		}
+	}
+
+	// Dead-man's-switch pings, by handleCollector (invalid intervals were
+	// reported by Validate)
+	if hc := effective.Heartbeat; hc.URL != "" || len(hc.Hosts) > 0 {
+		interval, _ := time.ParseDuration(hc.Interval)
+		pinger, err := forward.NewPinger(hc.URL, hc.Hosts, interval)
+		if err != nil {
+			configError("Invalid [heartbeat]: %v", err)
+		} else {
+			heartbeat = pinger
+		}
+	}
+
+	// Sinks of the stored metrics, fed by the metric forwarding job
+	if rc := effective.Export.RemoteWrite; rc.URL != "" {
+		sink, err := forward.NewRemoteWriteSink(rc.URL, rc.User, rc.Password, rc.Token, rc.Headers, version)
+		if err != nil {
+			configError("Invalid [export.remote_write]: %v", err)
+		} else {
+			metricForwarder.Add(sink)
+		}
+	}
+	if ic := effective.Export.InfluxDB; ic.URL != "" {
+		sink, err := forward.NewInfluxSink(ic.URL, ic.Database, ic.RetentionPolicy, ic.User, ic.Password, ic.Org, ic.Bucket, ic.Token)
+		if err != nil {
+			configError("Invalid [export.influxdb]: %v", err)
+		} else {
+			metricForwarder.Add(sink)
+		}
+	}
+	if gc := effective.Export.Graphite; gc.Target != "" {
+		sink, err := forward.NewGraphiteSink(gc.Target, gc.Format, gc.Path)
+		if err != nil {
+			configError("Invalid [export.graphite]: %v", err)
+		} else {
+			metricForwarder.Add(sink)
+		}
+	}
+	if oc := effective.Export.OTLP; oc.Endpoint != "" {
+		sink, err := forward.NewOTLPSink(oc.Endpoint, oc.Protocol, oc.Headers, version)
+		if err != nil {
+			configError("Invalid [export.otlp]: %v", err)
+		} else {
+			metricForwarder.Add(sink)
+		}
+	}
+	if sc := effective.Export.StatsD; sc.Target != "" {
+		sink, err := forward.NewStatsDSink(sc.Target, sc.Prefix, sc.Metrics)
+		if err != nil {
+			configError("Invalid [export.statsd]: %v", err)
+		} else {
+			metricForwarder.Add(sink)
+		}
+	}
+	if ec := effective.Export.Elasticsearch; ec.URL != "" {
+		// An event sink, and a metric sink with metrics_index
+		sink, err := forward.NewElasticsearchSink(ec.URL, ec.User, ec.Password, ec.APIKey, ec.Index, ec.MetricsIndex, !ec.SkipTemplates)
+		if err == nil {
+			err = forwarder.Add(sink, ec.MinSeverity)
+		}
+		if err != nil {
+			configError("Invalid [export.elasticsearch]: %v", err)
+		} else if sink.Metrics() {
+			metricForwarder.Add(sink)
+		}
+	}
+	var mqttState *forward.MQTTStateSink
+	if mc := effective.Export.MQTT; mc.URL != "" {
+		sink, err := forward.NewMQTTStateSink(mc.URL, mc.User, mc.Password, mc.TopicPrefix, mc.DiscoveryPrefix, mc.Metrics)
+		if err != nil {
+			configError("Invalid [export.mqtt]: %v", err)
+		} else {
+			metricForwarder.Add(sink)
+			mqttState = sink
+		}
+	}
+
+	if err := web.SetMonitCAFile(*monitCAFile); err != nil {
+		configError("Invalid -monit-ca-file: %v", err)
 	}
 
+	// Timeouts and retry policy of the requests to Monit agents (invalid
+	// durations were reported by Validate)
+	monitConnect, _ := time.ParseDuration(*monitConnectTimeout)
+	monitRequest, _ := time.ParseDuration(*monitTimeout)
+	monitBackoff, _ := time.ParseDuration(*monitRetryBackoff)
+	if monitConnect > 0 && monitRequest > 0 && monitBackoff >= 0 {
+		monitOptions := control.ClientOptions{
+			ConnectTimeout: monitConnect,
+			Timeout:        monitRequest,
+			Attempts:       *monitAttempts,
+			RetryBackoff:   monitBackoff,
+		}
+		if err := web.SetMonitClientOptions(monitOptions); err != nil {
+			configError("Invalid Monit agent request settings: %v", err)
+		}
+	}
+	if err := web.SetActionConcurrency(*actionConcurrency, *actionHostConcurrency); err != nil {
+		configError("Invalid -action-concurrency or -action-host-concurrency: %v", err)
+	}
+
+	// Login session durations (checked by Validate)
+	idleTimeout, _ := time.ParseDuration(*sessionIdleTimeout)
+	rememberFor, _ := time.ParseDuration(*sessionRemember)
+
 	// Process collector address to inherit IP from -listen
 	//
 	// If -collector is just a port number (e.g., "8080" or ":8080"),
@@ -255,86 +774,70 @@ func main() {
 	// Users can still override by specifying a full address for -collector.
 	*collectorAddr = buildAddress(*webAddr, *collectorAddr)
 
-	// Handle daemon mode
-	//
-	// If -daemon flag is set, we detach from the controlling terminal
-	// and run in the background. This is done by re-executing ourselves
-	// with syscall attributes that create a new session.
-	if *daemonMode {
-		// Check if already daemonized by looking for our environment variable
-		if os.Getenv("CMONIT_DAEMONIZED") != "1" {
-			// Not yet daemonized - re-exec ourselves
-			args := make([]string, 0, len(os.Args))
-			for _, arg := range os.Args {
-				// Skip the -daemon flag for the child process
-				if arg != "-daemon" {
-					args = append(args, arg)
-				}
-			}
-
-			// Prepare syscall attributes for daemonization
-			execSpec := &syscall.ProcAttr{
-				Env: append(os.Environ(), "CMONIT_DAEMONIZED=1"),
-				Files: []uintptr{
-					// stdin, stdout, stderr -> /dev/null
-					uintptr(syscall.Stdin),
-					uintptr(syscall.Stdout),
-					uintptr(syscall.Stderr),
-				},
-				Sys: &syscall.SysProcAttr{
-					Setsid: true, // Create new session (detach from terminal)
-				},
-			}
-
-			// Get absolute path to current executable
-			execPath, err := os.Executable()
-			if err != nil {
-				log.Fatalf("[FATAL] Failed to get executable path for daemonization: %v", err)
-			}
+	// Handle "cmonit print-config": the settings merged above, as a
+	// config file
+	if printConfigOnly {
+		effective.Network.CollectorPort = *collectorAddr
+		effective.Include, effective.Unknown = "", nil
+		effective.Retention.Metrics, effective.Retention.Events = formatAge(metricsRetention), formatAge(eventsRetention)
+		os.Exit(printEffectiveConfig(&effective))
+	}
 
-			// Fork and exec the child process
-			pid, err := syscall.ForkExec(execPath, args, execSpec)
-			if err != nil {
-				log.Fatalf("[FATAL] Failed to daemonize: %v", err)
-			}
+	// Handle -check-config utility command
+	//
+	// Runs the checks needing more than parsing (files, addresses), then
+	// prints every problem found. Meant for CI and rc.d pre-start checks.
+	if *checkConfig {
+		configProblems = append(configProblems, checkStartupSettings(startupSettings{
+			webAddr:                 *webAddr,
+			collectorAddr:           *collectorAddr,
+			collectorPassword:       *collectorPassword,
+			collectorPasswordFormat: *collectorPasswordFormat,
+			webUser:                 *webUser,
+			webPassword:             *webPassword,
+			webPasswordFormat:       *webPasswordFormat,
+			tlsCert:                 *tlsCert,
+			tlsKey:                  *tlsKey,
+			acmeDomains:             *acmeDomains,
+			syslogFacility:          *syslogFacility,
+		})...)
+		os.Exit(reportConfigProblems(configProblems))
+	}
 
-			// Parent process: print the child PID and exit
-			fmt.Printf("cmonit daemonized with PID %d\n", pid)
-			os.Exit(0)
+	// Handle the deprecated API token flags
+	//
+	// They run "cmonit token" with the same config file and database
+	// (after the config file, so [storage] database applies), and exit
+	// before daemonizing.
+	if *createToken != "" || *revokeToken != "" || *listTokens {
+		args := []string{"list", "-config", *configFile, "-db", *dbPath}
+		switch {
+		case *createToken != "":
+			args = []string{"create", "-config", *configFile, "-db", *dbPath,
+				"-scope", *tokenScopes, "-role", *tokenRole, *createToken}
+		case *revokeToken != "":
+			args = []string{"revoke", "-config", *configFile, "-db", *dbPath, *revokeToken}
 		}
-		// Already daemonized, continue normally
+		os.Exit(runTokenSubcommand(args))
 	}
 
-	// Set global debug mode from flag
-	debugEnabled = *debugFlag
-	db.SetDebugMode(debugEnabled)
-
-	// Validate password formats
-	if *collectorPasswordFormat != "plain" && *collectorPasswordFormat != "bcrypt" {
-		log.Fatalf("[FATAL] Invalid -collector-password-format: %s (must be 'plain' or 'bcrypt')", *collectorPasswordFormat)
-	}
-	if *webPasswordFormat != "plain" && *webPasswordFormat != "bcrypt" {
-		log.Fatalf("[FATAL] Invalid -web-password-format: %s (must be 'plain' or 'bcrypt')", *webPasswordFormat)
+	// Handle -reset-2fa utility command
+	//
+	// For a user who lost both their authenticator and recovery codes.
+	if *resetTOTP != "" {
+		os.Exit(runResetTOTPCommand(*dbPath, *resetTOTP))
 	}
 
-	// Set collector authentication credentials from flags
-	collectorAuthUsername = *collectorUser
-	collectorAuthPassword = *collectorPassword
-	collectorAuthPasswordFormat = *collectorPasswordFormat
+	if legacy {
+		log.Printf("[WARNING] Starting without a subcommand is deprecated, use: cmonit serve [flags]")
+	}
 
 	// Setup syslog if requested
 	//
 	// If -syslog flag is provided, redirect log output to syslog
 	// Otherwise, continue logging to stderr (default)
 	if *syslogFacility != "" {
-		priority, err := parseSyslogFacility(*syslogFacility)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Invalid syslog facility: %v\n", err)
-			os.Exit(1)
-		}
-
-		// Connect to syslog
-		syslogWriter, err := syslog.New(priority, "cmonit")
+		syslogWriter, err := newSyslogWriter(*syslogFacility)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to connect to syslog: %v\n", err)
 			os.Exit(1)
@@ -343,8 +846,52 @@ func main() {
 		// Redirect log output to syslog
 		log.SetOutput(syslogWriter)
 		log.SetFlags(0) // Syslog adds its own timestamp
+	} else if *supervised {
+		log.SetFlags(0) // The service manager adds its own timestamp
+	}
+
+	// Handle daemon mode
+	//
+	// If -daemon flag is set, we detach from the controlling terminal
+	// and run in the background: cmonit starts again in a new session,
+	// and this process exits once it is ready (see daemon_unix.go). The
+	// child has daemonizedEnv set and continues normally.
+	if *daemonMode && os.Getenv(daemonizedEnv) != "1" {
+		if *syslogFacility == "" {
+			log.Printf("[WARNING] -daemon without -syslog: the logs are discarded")
+		}
+		pid, err := daemonize(os.Args[1:])
+		if err != nil {
+			log.Fatalf("[FATAL] Failed to daemonize: %v", err)
+		}
+
+		// Parent process: print the child PID and exit
+		fmt.Printf("cmonit daemonized with PID %d\n", pid)
+		os.Exit(0)
+	}
+
+	// Set global debug mode from flag
+	debugEnabled = *debugFlag
+	db.SetDebugMode(debugEnabled)
+	parser.SetDebugMode(debugEnabled)
+	parseDiagnosticsEnabled = *parseDiagnostics
+	if *programOutputMax >= 0 {
+		parser.SetProgramOutputLimit(*programOutputMax, *programFullOutput)
 	}
 
+	// Set collector authentication credentials from flags
+	collectorAuthUsername = *collectorUser
+	collectorAuthPassword = *collectorPassword
+	collectorAuthPasswordFormat = *collectorPasswordFormat
+	strictCollector = *strict
+	web.SetCollectorEndpoint(web.CollectorEndpoint{
+		Address:        *collectorAddr,
+		TLS:            *tlsCert != "" || *acmeDomains != "",
+		User:           *collectorUser,
+		Password:       *collectorPassword,
+		PasswordHashed: *collectorPasswordFormat == "bcrypt",
+	})
+
 	// Print startup banner
 	// fmt.Println() prints to standard output with a newline
 	fmt.Println("=================================")
@@ -422,6 +969,14 @@ func main() {
 		log.Fatalf("[FATAL] Failed to initialize database: %v", err)
 	}
 
+	// Key of the secrets stored in the database (per-host Monit passwords)
+	if *secretKeyFile == "" {
+		*secretKeyFile = filepath.Join(filepath.Dir(*dbPath), "cmonit.key")
+	}
+	if err := db.SetSecretKeyFile(*secretKeyFile); err != nil {
+		log.Fatalf("[FATAL] %v", err)
+	}
+
 	// defer schedules a function to run when main() exits
 	// This ensures the database connection is closed cleanly
 	//
@@ -462,6 +1017,14 @@ func main() {
 	// Set the application version for display in templates
 	web.SetVersion(version)
 
+	// Tell the web package whether the public status page is served
+	web.SetPublicStatus(*publicStatus)
+
+	// Timezone and date format of the timestamps ([display])
+	if err := web.SetDisplay(cfg.Display.Timezone, cfg.Display.DateFormat); err != nil {
+		log.Fatalf("[FATAL] [display] %v", err)
+	}
+
 	// Set up HTTP routes (URL patterns and their handler functions)
 	//
 	// http.HandleFunc() registers a handler function for a specific URL pattern
@@ -492,6 +1055,10 @@ func main() {
 	// Main status overview page (shows all hosts in a table)
 	webMux.HandleFunc("/", web.HandleStatus)
 
+	// Login page and logout (sessions are only used with -web-user/-web-password)
+	webMux.HandleFunc("/login", web.HandleLogin)
+	webMux.HandleFunc("/logout", web.HandleLogout)
+
 	// Host detail pages (with graphs) and service detail pages
 	// Must be registered before "/" to match more specific paths
 	webMux.HandleFunc("/host/", func(w http.ResponseWriter, r *http.Request) {
@@ -506,31 +1073,60 @@ func main() {
 		}
 	})
 
-	// API endpoints for JavaScript to fetch data
+	// Event history across all hosts, with filters and pagination
+	// (the per-host page above only shows the latest 100 events)
+	webMux.HandleFunc("/events", web.HandleEvents)
+
+	// Failures paired with their recoveries, with their duration
+	webMux.HandleFunc("/incidents", web.HandleIncidents)
+
+	// CPU, memory and load of several hosts overlaid on shared axes
+	webMux.HandleFunc("/compare", web.HandleCompare)
+
+	// Native JSON API (used by the web UI's JavaScript and by scripts)
 	//
-	// /api/metrics returns JSON with time-series data
-	// Used by Chart.js to draw graphs
-	webMux.HandleFunc("/api/metrics", web.HandleMetricsAPI)
+	// Endpoints are listed in web.APIRoutes() (internal/web/openapi.go), which
+	// also generates the OpenAPI document. Each route is served under /api/v1/
+	// and, for existing clients, under its original unversioned /api/ path.
+	for _, route := range web.APIRoutes() {
+		webMux.HandleFunc(route.Pattern(), route.Serve)
+		webMux.HandleFunc(route.LegacyPattern(), route.Handler)
+	}
+
+	// OpenAPI 3 document and Swagger UI page describing the routes above
+	webMux.HandleFunc("/api/v1/openapi.json", web.HandleOpenAPI)
+	webMux.HandleFunc("/api/v1/docs", web.HandleAPIDocs)
+
+	// User-composed dashboards (HTML pages; JSON API is in web.APIRoutes)
+	// Widgets and layout are stored server-side in the dashboards table
+	webMux.HandleFunc("/dashboards", web.HandleDashboards)
+	webMux.HandleFunc("/dashboards/", web.HandleDashboards)
+
+	// Display preferences page (theme, timezone, refresh, default graph range)
+	// Stored per web user, or per browser via a cookie when auth is disabled
+	webMux.HandleFunc("/preferences", web.HandlePreferences)
 
-	// /api/action performs actions on services (start, stop, restart, etc.)
-	// Used by action buttons on the dashboard
-	webMux.HandleFunc("/api/action", web.HandleActionAPI)
+	// API token management page (create/revoke Bearer tokens for scripts)
+	webMux.HandleFunc("/tokens", web.HandleTokens)
 
-	// /api/remote-metrics returns JSON with response time data for remote host services
-	// Used by Chart.js to draw response time graphs on remote host service detail pages
-	webMux.HandleFunc("/api/remote-metrics", web.HandleRemoteHostMetricsAPI)
+	// Two-factor authentication page (authenticator app enrollment)
+	webMux.HandleFunc("/2fa", web.HandleTOTP)
 
-	// /api/availability returns JSON with host availability time-series data
-	// Used by Chart.js to draw availability status graphs showing green/yellow/red status
-	webMux.HandleFunc("/api/availability", web.HandleAvailabilityAPI)
+	// Audit log of logins and administrative actions (JSON API in web.APIRoutes)
+	webMux.HandleFunc("/audit", web.HandleAudit)
 
-	// /api/host/description updates the description field for a host
-	// Allows users to add custom HTML notes for each host
-	webMux.HandleFunc("/api/host/description", web.HandleUpdateDescription)
+	// Pending scheduled service actions (JSON API in web.APIRoutes)
+	webMux.HandleFunc("/schedule", web.HandleSchedule)
 
-	// /api/hostgroups returns a list of all hostgroups with their member hosts
-	// Used to display and filter hosts by group
-	webMux.HandleFunc("/api/hostgroups", web.HandleHostGroupsAPI)
+	// Archived hosts, with their history kept (JSON API in web.APIRoutes)
+	webMux.HandleFunc("/archive", web.HandleArchive)
+
+	// Prometheus exporter of the latest values of every host and service
+	webMux.HandleFunc("/metrics", web.HandlePrometheusMetrics)
+
+	// SVG status badges for embedding in wikis and README files
+	// /badge/host/{id}.svg and /badge/service/{id}/{name}.svg
+	webMux.HandleFunc("/badge/", web.HandleBadge)
 
 	// Static files (logo, favicon, etc.)
 	// Serves embedded static assets from internal/web/static/
@@ -571,33 +1167,116 @@ func main() {
 	webMux.HandleFunc("/api/2/admin/hosts/list", web.HandleMMV2AdminHostsList)
 	webMux.HandleFunc("/api/2/admin/hosts/delete", web.HandleMMV2AdminHostsDelete)
 
+	// TLS certificates
+	//
+	// With -acme-domains, both servers get their certificates from an
+	// autocert manager instead of -tls-cert/-tls-key files. It obtains them
+	// on the first TLS handshake for a listed domain and renews them 30
+	// days before expiry. Challenges are answered with HTTP-01 on
+	// -acme-http or TLS-ALPN-01 on the TLS listeners.
+	//
+	// With -tls-cert/-tls-key, the files are reloaded when they change or
+	// on SIGHUP, so renewals (e.g. by certbot) need no restart.
+	var tlsConfig *tls.Config
+	var reloader *certReloader
+	if *acmeDomains != "" {
+		if *tlsCert != "" || *tlsKey != "" {
+			log.Fatalf("[FATAL] -acme-domains cannot be combined with -tls-cert/-tls-key")
+		}
+		cacheDir := *acmeCache
+		if cacheDir == "" {
+			cacheDir = filepath.Join(filepath.Dir(*dbPath), "acme")
+		}
+		manager, err := newACMEManager(*acmeDomains, *acmeEmail, cacheDir, *acmeDirectory)
+		if err != nil {
+			log.Fatalf("[FATAL] ACME setup failed: %v", err)
+		}
+		tlsConfig = manager.TLSConfig()
+		log.Printf("[INFO] ACME certificates for %s (cache: %s)", *acmeDomains, cacheDir)
+
+		if *acmeHTTP != "off" && *acmeHTTP != "" {
+			go func() {
+				// Answers HTTP-01 challenges; other requests are
+				// redirected to HTTPS
+				log.Printf("[INFO] ACME HTTP-01 challenge server listening on %s", *acmeHTTP)
+				err := http.ListenAndServe(*acmeHTTP, manager.HTTPHandler(nil))
+				if err != nil {
+					log.Printf("[ERROR] ACME HTTP-01 challenge server failed: %v", err)
+				}
+			}()
+		}
+	} else if *tlsCert != "" || *tlsKey != "" {
+		if *tlsCert == "" || *tlsKey == "" {
+			log.Fatalf("[FATAL] Both -tls-cert and -tls-key must be provided for TLS")
+		}
+		var err error
+		reloader, err = newCertReloader(*tlsCert, *tlsKey)
+		if err != nil {
+			log.Fatalf("[FATAL] Failed to load TLS certificate: %v", err)
+		}
+		tlsConfig = &tls.Config{GetCertificate: reloader.GetCertificate}
+
+		go reloader.watch(certCheckInterval)
+	}
+
+	// SIGHUP reloads the TLS certificate files (e.g. "service cmonit
+	// reload" after a renewal). It is always caught so that it never
+	// terminates cmonit.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if reloader == nil {
+				log.Printf("[INFO] SIGHUP received, nothing to reload")
+				continue
+			}
+			log.Printf("[INFO] SIGHUP received, reloading TLS certificate")
+			sdNotify("RELOADING=1")
+			if err := reloader.reload(); err != nil {
+				log.Printf("[ERROR] %v", err)
+			}
+			sdNotify("READY=1")
+		}
+	}()
+
 	// Start the collector HTTP server in a goroutine (lightweight thread)
 	//
 	// The "go" keyword runs a function concurrently
 	// This allows the main() function to continue while the server runs
-	// Without "go", ListenAndServe would block forever
+	// Without "go", Serve would block forever
 	//
 	// Why use a goroutine?
 	// - We need to run multiple things concurrently (collector + web UI)
 	// - We need main() to continue so we can handle shutdown signals
-	go func() {
-		// Validate TLS configuration
-		tlsEnabled := *tlsCert != "" && *tlsKey != ""
+	//
+	// Both addresses are bound first, so that readiness is only reported
+	// to the service manager (notifyReady) once both accept connections,
+	// and a busy port stops the startup.
+	collectorListener, err := net.Listen("tcp", *collectorAddr)
+	if err != nil {
+		log.Fatalf("[FATAL] Collector server failed: %v", err)
+	}
+	webListener, err := net.Listen("tcp", *webAddr)
+	if err != nil {
+		log.Fatalf("[FATAL] Web server failed: %v", err)
+	}
 
+	go func() {
 		// Start the appropriate server (HTTP or HTTPS)
-		if tlsEnabled {
+		if tlsConfig != nil {
 			log.Printf("[INFO] Collector listening on %s (HTTPS)", *collectorAddr)
-			err := http.ListenAndServeTLS(*collectorAddr, *tlsCert, *tlsKey, nil)
+			server := &http.Server{Addr: *collectorAddr, TLSConfig: tlsConfig}
+			err := server.ServeTLS(collectorListener, "", "")
 			if err != nil {
 				log.Fatalf("[FATAL] Collector server failed: %v", err)
 			}
 		} else {
 			log.Printf("[INFO] Collector listening on %s (HTTP)", *collectorAddr)
 
-			// http.ListenAndServe() starts an HTTP server
+			// http.Serve() serves HTTP on the listener bound above
 			//
 			// Parameters:
-			//   - addr: address to listen on
+			//   - listener: bound to *collectorAddr
 			//     - ":8080" = all interfaces (0.0.0.0 and ::), port 8080
 			//     - "localhost:8080" = only local connections
 			//     - "192.168.1.10:8080" = specific IPv4 address
@@ -606,13 +1285,11 @@ func main() {
 			//   - handler: if nil, uses the default ServeMux (what we registered with HandleFunc)
 			//
 			// Returns:
-			//   - error: only returns if the server fails to start or crashes
+			//   - error: only returns if the server crashes
 			//            normally this function blocks forever
 			//
 			// Note: This is a blocking call - it runs forever until an error occurs
-			//
-			// *collectorAddr dereferences the pointer to get the string value from the flag
-			err := http.ListenAndServe(*collectorAddr, nil)
+			err := http.Serve(collectorListener, nil)
 
 			// If we reach here, the server crashed or failed to start
 			// log.Fatalf() prints the error and exits the program with code 1
@@ -636,39 +1313,67 @@ func main() {
 		// Prepare the handler with optional authentication
 		var handler http.Handler = webMux
 
-		// Add HTTP Basic Auth if credentials are provided
+		// Require login if credentials are provided
+		//
+		// Browsers sign in on /login and get a session cookie; scripts
+		// can still send HTTP Basic Auth credentials with each request.
 		if *webUser != "" && *webPassword != "" {
 			log.Printf("[INFO] Web UI authentication enabled for user: %s (format: %s)", *webUser, *webPasswordFormat)
-			handler = basicAuth(webMux, *webUser, *webPassword, *webPasswordFormat)
+			web.SetSessionAuth(web.SessionAuth{
+				Verify: func(username, password string) bool {
+					return username == *webUser && checkPassword(password, *webPassword, *webPasswordFormat)
+				},
+				IdleTimeout: idleTimeout,
+				RememberFor: rememberFor,
+				TOTPPolicy:  *totpPolicy,
+			})
+			handler = web.RequireLogin(webMux)
 		} else {
 			log.Printf("[WARNING] Web UI authentication disabled - use -web-user and -web-password for production")
 		}
 
-		// Validate TLS configuration
-		tlsEnabled := *tlsCert != "" || *tlsKey != ""
-		if tlsEnabled {
-			if *tlsCert == "" || *tlsKey == "" {
-				log.Fatalf("[FATAL] Both -tls-cert and -tls-key must be provided for TLS")
-			}
+		// Public status page bypasses authentication
+		//
+		// /public, the static assets it uses (logo, favicon) and badges of
+		// public hosts are routed before the auth wrapper; everything else
+		// still goes through it.
+		if *publicStatus {
+			log.Printf("[INFO] Public status page enabled at /public")
+			publicMux := http.NewServeMux()
+			publicMux.HandleFunc("/public", web.HandlePublicStatus)
+			publicMux.HandleFunc("/static/", web.HandleStatic)
+			publicMux.Handle("/badge/", web.PublicBadges(handler))
+			publicMux.Handle("/", handler)
+			handler = publicMux
 		}
 
+		// CSRF protection wraps everything, with or without authentication:
+		// state-changing browser requests must carry the token from the
+		// cmonit_csrf cookie
+		handler = web.CSRFProtect(handler)
+
 		// Start the appropriate server (HTTP or HTTPS)
-		if tlsEnabled {
+		if tlsConfig != nil {
 			log.Printf("[INFO] Web UI listening on %s (HTTPS)", *webAddr)
-			err := http.ListenAndServeTLS(*webAddr, *tlsCert, *tlsKey, handler)
+			server := &http.Server{Addr: *webAddr, Handler: handler, TLSConfig: tlsConfig}
+			err := server.ServeTLS(webListener, "", "")
 			if err != nil {
 				log.Fatalf("[FATAL] Web server failed: %v", err)
 			}
 		} else {
 			log.Printf("[INFO] Web UI listening on %s (HTTP)", *webAddr)
 			log.Printf("[WARNING] TLS disabled - use -tls-cert and -tls-key for encrypted connections")
-			err := http.ListenAndServe(*webAddr, handler)
+			err := http.Serve(webListener, handler)
 			if err != nil {
 				log.Fatalf("[FATAL] Web server failed: %v", err)
 			}
 		}
 	}()
 
+	// Both servers accept connections: tell the service manager, or the
+	// parent of -daemon
+	notifyReady()
+
 	// Start availability recording background job
 	//
 	// This goroutine runs continuously, recording availability status
@@ -710,24 +1415,131 @@ func main() {
 	// metrics and events are append-only tables; without pruning they grow
 	// unbounded. This runs hourly rather than on every write since it's a
 	// bulk DELETE, not something that needs to react to individual inserts.
+	// It also archives and deletes the hosts no longer reporting
+	// ([retention] archive_hosts and delete_hosts).
 	go func() {
-		log.Printf("[INFO] Starting retention pruning background job (retention: %d days)", *retentionDays)
+		log.Printf("[INFO] Starting retention pruning background job (metrics: %s, events: %s)",
+			formatAge(metricsRetention), formatAge(eventsRetention))
+		if hostPurge.Enabled() {
+			log.Printf("[INFO] Hosts not reporting are archived after %s and deleted after %s",
+				formatHostAge(hostPurge.ArchiveAfter), formatHostAge(hostPurge.DeleteAfter))
+		}
+
+		prune := func() {
+			if err := db.PruneOldData(globalDB, metricsRetention, eventRetention); err != nil {
+				log.Printf("[WARN] Failed to prune old data: %v", err)
+			}
+			if hostPurge.Enabled() {
+				if _, _, err := db.PurgeHosts(globalDB, hostPurge, time.Now()); err != nil {
+					log.Printf("[WARN] Failed to archive or delete the hosts not reporting: %v", err)
+				}
+			}
+		}
 
 		// Prune once immediately so a restart doesn't leave stale data
 		// sitting around for up to an hour before the first tick.
-		if err := db.PruneOldData(globalDB, *retentionDays); err != nil {
-			log.Printf("[WARN] Failed to prune old data: %v", err)
-		}
+		prune()
 
 		ticker := time.NewTicker(1 * time.Hour)
 		defer ticker.Stop()
 
 		for {
 			<-ticker.C
+			prune()
+		}
+	}()
 
-			if err := db.PruneOldData(globalDB, *retentionDays); err != nil {
-				log.Printf("[WARN] Failed to prune old data: %v", err)
+	// Start event forwarding background job
+	//
+	// Sends the events stored from now on to syslog ([event_syslog]), a
+	// SIEM collector ([event_siem]), a message bus ([event_bus]), Nagios
+	// or Icinga 2 ([event_nagios]), SNMP traps ([event_snmp]) and Grafana
+	// annotations ([event_grafana]), and index them in Elasticsearch
+	// ([export.elasticsearch]), reading the new rows of the events table
+	// every forward.Interval.
+	if !forwarder.Empty() {
+		go forwarder.Run(globalDB)
+	}
+
+	// Start host offline trap background job
+	//
+	// Sends the cmonitHostOfflineTrap of the hosts missing their reports
+	// (see db.HostSeen.Offline), and a cmonitHostOnlineTrap when they
+	// report again, checked every minute as the availability.
+	if snmpSink != nil {
+		go func() {
+			ticker := time.NewTicker(60 * time.Second)
+			defer ticker.Stop()
+
+			for {
+				hosts, err := db.HostsSeen(globalDB)
+				if err == nil {
+					err = snmpSink.CheckHosts(hosts, time.Now())
+				}
+				if err != nil {
+					log.Printf("[WARN] Failed to send the host offline traps: %v", err)
+				}
+				<-ticker.C
+			}
+		}()
+	}
+
+	// Start MQTT state publishing background job
+	//
+	// Publishes the availability of the hosts and the state of their
+	// services ([export.mqtt]) when they change, checked every
+	// forward.Interval; the metrics go through the metric forwarding job.
+	if mqttState != nil {
+		go func() {
+			ticker := time.NewTicker(forward.Interval)
+			defer ticker.Stop()
+
+			failing := false
+			for {
+				hosts, err := db.HostsSeen(globalDB)
+				var services []db.ServiceState
+				if err == nil {
+					services, err = db.ServiceStates(globalDB)
+				}
+				if err == nil {
+					err = mqttState.PublishStates(hosts, services, time.Now())
+				}
+				switch {
+				case err != nil && !failing:
+					log.Printf("[WARN] Failed to publish the states to %s, retrying: %v", mqttState.Name(), err)
+					failing = true
+				case err == nil && failing:
+					log.Printf("[INFO] Publishing the states to %s again", mqttState.Name())
+					failing = false
+				}
+				<-ticker.C
 			}
+		}()
+	}
+
+	// Start metric forwarding background job
+	//
+	// Sends the metrics stored from now on to a Prometheus remote write
+	// endpoint ([export.remote_write]), InfluxDB ([export.influxdb]),
+	// Graphite ([export.graphite]), an OpenTelemetry collector
+	// ([export.otlp]), StatsD ([export.statsd]), MQTT ([export.mqtt]) and
+	// Elasticsearch ([export.elasticsearch] metrics_index), in batches, as
+	// soon as the collector stores them, each sink in its own goroutine.
+	if !metricForwarder.Empty() {
+		go metricForwarder.Run(globalDB)
+	}
+
+	// Start scheduled actions background job
+	//
+	// Runs the service actions scheduled from the host page or the API
+	// when they are due, so they run within ScheduleInterval of their time.
+	go func() {
+		ticker := time.NewTicker(web.ScheduleInterval)
+		defer ticker.Stop()
+
+		for {
+			web.RunScheduledActions()
+			<-ticker.C
 		}
 	}()
 
@@ -772,6 +1584,7 @@ func main() {
 
 	// We received a shutdown signal
 	log.Printf("[INFO] Shutdown signal received, exiting...")
+	sdNotify("STOPPING=1")
 
 	// Clean up PID file before exit
 	// We do this explicitly here because os.Exit() bypasses deferred functions
@@ -793,6 +1606,45 @@ func main() {
 	os.Exit(0)
 }
 
+// collectorSource returns the address of the agent posting to the
+// collector, without the port.
+func collectorSource(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// handleCollectorEvent stores the event notification of an agent.
+//
+// A status report that fails to be stored is replaced by the next one, a
+// cycle later, so handleCollector answers 200 regardless. An event is not
+// sent again unless the collector reports the failure: Monit then keeps it
+// in its event queue ("set eventqueue" in monitrc) and resends it with
+// the next posts. Storage failures therefore get 503, and stored events
+// 200.
+func handleCollectorEvent(w http.ResponseWriter, r *http.Request, status *parser.MonitStatus) {
+	if err := db.StoreMonitEvent(globalDB, status); err != nil {
+		log.Printf("[ERROR] Failed to store event from %s: %v", status.Server.LocalHostname, err)
+		w.Header().Set("Server", "cmonit/0.1")
+		http.Error(w, "Failed to store event", http.StatusServiceUnavailable)
+		return
+	}
+
+	if parseDiagnosticsEnabled {
+		warnings := parser.Diagnose(status)
+		if err := db.StoreParseDiagnostics(globalDB, db.HostID(status), collectorSource(r), warnings); err != nil {
+			log.Printf("[WARN] %v", err)
+		}
+	}
+
+	w.Header().Set("Server", "cmonit/0.1")
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "OK\n")
+}
+
 // handleCollector handles HTTP requests to the /collector endpoint
 //
 // This is the endpoint where Monit agents POST their status data.
@@ -987,12 +1839,21 @@ func handleCollector(w http.ResponseWriter, r *http.Request) {
 	// - Monit's XML is small (usually <100KB, even smaller when compressed)
 	// - Simpler than streaming parse
 	// - We need all data to parse XML anyway
-	body, err := io.ReadAll(bodyReader)
+	//
+	// The read stops past the largest document the parser accepts, after
+	// decompression: a small gzip body can expand to gigabytes
+	maxSize := parser.MaxDocumentSize()
+	body, err := io.ReadAll(io.LimitReader(bodyReader, maxSize+1))
 	if err != nil {
 		log.Printf("[ERROR] Failed to read request body: %v", err)
 		http.Error(w, "Failed to read request body", http.StatusBadRequest)
 		return
 	}
+	if int64(len(body)) > maxSize {
+		log.Printf("[WARN] Rejected document larger than %d bytes from %s", maxSize, r.RemoteAddr)
+		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
 
 	// Always close the request body when done
 	//
@@ -1017,7 +1878,7 @@ func handleCollector(w http.ResponseWriter, r *http.Request) {
 	// Parse the XML into our data structures
 	//
 	// parser.ParseMonitXML() does:
-	// 1. Fix encoding declaration (ISO-8859-1 -> UTF-8)
+	// 1. Transcode the ISO-8859-1 document to UTF-8
 	// 2. Parse XML into structs using encoding/xml
 	// 3. Return populated MonitStatus struct
 	//
@@ -1032,13 +1893,38 @@ func handleCollector(w http.ResponseWriter, r *http.Request) {
 		// - Unexpected structure
 		// - Encoding issues
 		log.Printf("[ERROR] Failed to parse XML: %v", err)
+		if parseDiagnosticsEnabled {
+			if err := db.StoreParseError(globalDB, collectorSource(r), err); err != nil {
+				log.Printf("[WARN] %v", err)
+			}
+		}
 		http.Error(w, "Failed to parse XML", http.StatusBadRequest)
 		return
 	}
 
+	// In strict mode, reject the documents the parser accepted but that
+	// miss required fields or hold impossible values, telling the sender
+	// what is wrong
+	if strictCollector {
+		if err := parser.Validate(status); err != nil {
+			log.Printf("[WARN] Rejected invalid status from %s (%s): %s",
+				collectorSource(r), status.Server.LocalHostname, strings.ReplaceAll(err.Error(), "\n", "; "))
+			if err := db.RecordValidationFailure(globalDB, status, collectorSource(r), err); err != nil {
+				log.Printf("[WARN] %v", err)
+			}
+			w.Header().Set("Server", "cmonit/0.1")
+			http.Error(w, "Invalid status document:\n"+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
 	// Log what we received for debugging
-	log.Printf("[INFO] Parsed status from %s: %d services",
-		status.Server.LocalHostname, len(status.Services))
+	if status.IsEvent() {
+		log.Printf("[INFO] Parsed event from %s: %s", status.Server.LocalHostname, status.Event.Service)
+	} else {
+		log.Printf("[INFO] Parsed status from %s: %d services",
+			status.Server.LocalHostname, len(status.Services))
+	}
 
 	// In debug mode, save the raw XML to /var/log for debugging
 	//
@@ -1062,6 +1948,13 @@ func handleCollector(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Event notifications go to their own storage path (see
+	// handleCollectorEvent)
+	if status.IsEvent() {
+		handleCollectorEvent(w, r, status)
+		return
+	}
+
 	// Store everything in the database
 	//
 	// db.StoreMonitStatus() does:
@@ -1077,6 +1970,27 @@ func handleCollector(w http.ResponseWriter, r *http.Request) {
 		// We don't want Monit to think we're down and stop sending data
 		log.Printf("[ERROR] Failed to store status: %v", err)
 		// Still return 200 OK (see comment below)
+	} else {
+		// Hand the new metrics to the [export.*] sinks, and tell the
+		// watchdogs cmonit is alive, without waiting
+		metricForwarder.Notify()
+		if heartbeat != nil {
+			heartbeat.Ping(status.Server.LocalHostname)
+		}
+	}
+
+	// Record what the parser could not make sense of (after the host is
+	// stored, for the foreign key)
+	if parseDiagnosticsEnabled {
+		warnings := parser.Diagnose(status)
+		for _, w := range warnings {
+			if debugEnabled {
+				log.Printf("[DEBUG] Parse warning for %s/%s: %s", status.Server.LocalHostname, w.Service, w.Message)
+			}
+		}
+		if err := db.StoreParseDiagnostics(globalDB, db.HostID(status), collectorSource(r), warnings); err != nil {
+			log.Printf("[WARN] %v", err)
+		}
 	}
 
 	// Set response headers
@@ -1208,146 +2122,311 @@ func buildAddress(listenAddr, collectorPort string) string {
 	return host + ":" + port
 }
 
-// parseSyslogFacility converts a facility string to syslog.Priority
-//
-// Supported facilities:
-//   - daemon: LOG_DAEMON facility
-//   - local0-local7: LOG_LOCAL0 through LOG_LOCAL7
-//
-// Returns:
-//   - syslog.Priority: The priority combining facility and severity
-//   - error: If the facility string is invalid
-func parseSyslogFacility(facility string) (syslog.Priority, error) {
-	// Map facility names to syslog constants
-	// The priority combines facility (where to log) and severity (log level)
-	// We use LOG_INFO as the default severity
-	facilities := map[string]syslog.Priority{
-		"daemon": syslog.LOG_DAEMON | syslog.LOG_INFO,
-		"local0": syslog.LOG_LOCAL0 | syslog.LOG_INFO,
-		"local1": syslog.LOG_LOCAL1 | syslog.LOG_INFO,
-		"local2": syslog.LOG_LOCAL2 | syslog.LOG_INFO,
-		"local3": syslog.LOG_LOCAL3 | syslog.LOG_INFO,
-		"local4": syslog.LOG_LOCAL4 | syslog.LOG_INFO,
-		"local5": syslog.LOG_LOCAL5 | syslog.LOG_INFO,
-		"local6": syslog.LOG_LOCAL6 | syslog.LOG_INFO,
-		"local7": syslog.LOG_LOCAL7 | syslog.LOG_INFO,
-	}
-
-	priority, ok := facilities[strings.ToLower(facility)]
-	if !ok {
-		return 0, fmt.Errorf("unknown facility '%s', supported: daemon, local0-local7", facility)
-	}
-
-	return priority, nil
-}
-
-// basicAuth wraps an HTTP handler with HTTP Basic Authentication.
-//
-// HTTP Basic Auth is a simple authentication scheme built into HTTP.
-// The client must send username:password in the Authorization header.
+// checkPassword reports whether password matches the configured one.
 //
 // Supports two password formats:
 // - "plain": Direct string comparison (less secure, default for backward compatibility)
 // - "bcrypt": Secure bcrypt hash comparison (recommended for production)
 //
 // Parameters:
-//   - next: The handler to wrap (will only be called if auth succeeds)
-//   - username: Required username
-//   - password: Required password (plain text or bcrypt hash depending on format)
+//   - password: Password supplied by the user (login form or Basic Auth)
+//   - expected: Configured password (plain text or bcrypt hash depending on format)
 //   - format: Password format ("plain" or "bcrypt")
 //
-// Returns:
-//   - http.Handler: Wrapped handler that checks credentials first
-//
-// How it works:
-// 1. Extract credentials from Authorization header
-// 2. Compare username (always plain text comparison)
-// 3. Compare password based on format:
-//    - plain: Direct string comparison
-//    - bcrypt: Hash the incoming password and compare with stored hash
-// 4. If match: call next handler
-// 5. If no match: return 401 Unauthorized
-//
 // Security notes:
 // - ALWAYS use HTTPS to encrypt credentials in transit
 // - Use bcrypt format for production deployments
 // - Use strong passwords (12+ characters, mixed case, numbers, symbols)
-// - Consider using environment variables instead of config files for credentials
-func basicAuth(next http.Handler, username, password, format string) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Get credentials from Authorization header
+func checkPassword(password, expected, format string) bool {
+	if format == "bcrypt" {
+		// Bcrypt comparison
 		//
-		// r.BasicAuth() extracts username/password from the header
-		// Returns:
-		//   - user: the username
-		//   - pass: the password
-		//   - ok: true if Authorization header was present and valid format
-		user, pass, ok := r.BasicAuth()
-
-		// Check if authentication header was provided
-		if !ok {
-			w.Header().Set("WWW-Authenticate", `Basic realm="cmonit"`)
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			log.Printf("[WARNING] Authentication missing from %s", r.RemoteAddr)
-			return
+		// bcrypt.CompareHashAndPassword() does:
+		// 1. Extracts the salt from the stored hash
+		// 2. Hashes the incoming password with that salt
+		// 3. Compares the resulting hash with the stored hash
+		//
+		// Returns nil if match, error if mismatch
+		//
+		// This is secure because:
+		// - Each password has a unique salt (prevents rainbow table attacks)
+		// - Bcrypt is intentionally slow (prevents brute force)
+		// - Cost factor can be increased as hardware improves
+		err := bcrypt.CompareHashAndPassword([]byte(expected), []byte(password))
+		return err == nil
+	}
+
+	// Plain text comparison (default)
+	//
+	// Direct string comparison
+	// Less secure but simpler for development/testing
+	return password == expected
+}
+
+// certCheckInterval is how often the -tls-cert/-tls-key files are checked
+// for changes.
+const certCheckInterval = time.Minute
+
+// certReloader serves the -tls-cert/-tls-key certificate to both servers
+// and reloads it when the files change (see watch) or on SIGHUP. A
+// certificate that fails to load (e.g. the key does not match yet while
+// files are being replaced) is logged and the previous one is kept.
+type certReloader struct {
+	certFile, keyFile string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time // Latest modification time of both files when loaded
+}
+
+// newCertReloader loads the certificate from certFile and keyFile.
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// filesModTime returns the latest modification time of the files.
+func (c *certReloader) filesModTime() (time.Time, error) {
+	var latest time.Time
+	for _, f := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(f)
+		if err != nil {
+			return time.Time{}, err
 		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
 
-		// Check username (always plain text comparison)
-		if user != username {
-			w.Header().Set("WWW-Authenticate", `Basic realm="cmonit"`)
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			log.Printf("[WARNING] Failed authentication attempt from %s (user: %s)", r.RemoteAddr, user)
-			return
+// reload loads the certificate files and, if they are valid, serves them
+// for new connections.
+func (c *certReloader) reload() error {
+	modTime, err := c.filesModTime()
+	if err != nil {
+		return fmt.Errorf("failed to reload TLS certificate: %w", err)
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to reload TLS certificate: %w", err)
+	}
+
+	c.mu.Lock()
+	c.cert = &cert
+	c.modTime = modTime
+	c.mu.Unlock()
+
+	if leaf := cert.Leaf; leaf != nil {
+		log.Printf("[INFO] Loaded TLS certificate %s (subject: %s, expires: %s)",
+			c.certFile, leaf.Subject.CommonName, leaf.NotAfter.Format(time.RFC3339))
+	} else {
+		log.Printf("[INFO] Loaded TLS certificate %s", c.certFile)
+	}
+	return nil
+}
+
+// watch reloads the certificate whenever the files are modified, checking
+// every interval. It never returns.
+func (c *certReloader) watch(interval time.Duration) {
+	for range time.Tick(interval) {
+		modTime, err := c.filesModTime()
+		if err != nil {
+			log.Printf("[WARNING] Cannot check TLS certificate files: %v", err)
+			continue
+		}
+		c.mu.RLock()
+		changed := !modTime.Equal(c.modTime)
+		c.mu.RUnlock()
+		if changed {
+			if err := c.reload(); err != nil {
+				log.Printf("[ERROR] %v (keeping the previous certificate)", err)
+			}
 		}
+	}
+}
 
-		// Check password based on format
-		var passwordMatch bool
+// GetCertificate returns the current certificate (tls.Config hook).
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
 
-		if format == "bcrypt" {
-			// Bcrypt comparison
-			//
-			// bcrypt.CompareHashAndPassword() does:
-			// 1. Extracts the salt from the stored hash
-			// 2. Hashes the incoming password with that salt
-			// 3. Compares the resulting hash with the stored hash
-			//
-			// Returns nil if match, error if mismatch
-			//
-			// This is secure because:
-			// - Each password has a unique salt (prevents rainbow table attacks)
-			// - Bcrypt is intentionally slow (prevents brute force)
-			// - Cost factor can be increased as hardware improves
-			err := bcrypt.CompareHashAndPassword([]byte(password), []byte(pass))
-			passwordMatch = (err == nil)
-		} else {
-			// Plain text comparison (default)
-			//
-			// Direct string comparison
-			// Less secure but simpler for development/testing
-			passwordMatch = (pass == password)
+// newACMEManager returns an autocert manager obtaining and renewing
+// certificates for the comma-separated domains, stored in cacheDir.
+// directoryURL selects the ACME CA ("" = Let's Encrypt production).
+func newACMEManager(domains, email, cacheDir, directoryURL string) (*autocert.Manager, error) {
+	var hosts []string
+	for _, d := range strings.Split(domains, ",") {
+		if d = strings.TrimSpace(d); d != "" {
+			hosts = append(hosts, d)
 		}
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("no domain in -acme-domains")
+	}
 
-		if !passwordMatch {
-			// Authentication failed - return 401 Unauthorized
-			//
-			// WWW-Authenticate header tells the browser to show login dialog
-			// Basic realm="..." is the authentication realm (domain/area)
-			w.Header().Set("WWW-Authenticate", `Basic realm="cmonit"`)
+	// The cache holds the account and certificate private keys
+	if err := os.MkdirAll(cacheDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create ACME cache directory: %w", err)
+	}
 
-			// 401 = Unauthorized (though "Unauthenticated" would be more accurate)
-			// This tells the client that authentication is required
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(hosts...),
+		Cache:      autocert.DirCache(cacheDir),
+		Email:      email,
+	}
+	if directoryURL != "" {
+		manager.Client = &acme.Client{DirectoryURL: directoryURL}
+	}
+	return manager, nil
+}
 
-			// Log the failed attempt (security audit trail)
-			// Don't log the password for security
-			log.Printf("[WARNING] Failed authentication attempt from %s (user: %s)", r.RemoteAddr, user)
-			return
-		}
+// runResetTOTPCommand runs the -reset-2fa utility command: it removes the
+// two-factor secret and recovery codes of username, who can then log in
+// with the password alone (and must enroll again under the "required"
+// policy).
+//
+// Returns the process exit code (0 on success).
+func runResetTOTPCommand(dbPath, username string) int {
+	database, err := db.InitDB(dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+		return 1
+	}
+	defer database.Close()
 
-		// Authentication succeeded - call the next handler
-		//
-		// next.ServeHTTP() passes the request to the wrapped handler
-		// The request continues normally from here
-		next.ServeHTTP(w, r)
+	removed, err := db.DisableTOTP(database, username)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if !removed {
+		fmt.Printf("User %q has no two-factor authentication\n", username)
+		return 0
+	}
+	recordCLIAudit(database, db.AuditTOTPDisable, username, "reset from the command line")
+	fmt.Printf("Removed two-factor authentication of user %q\n", username)
+	return 0
+}
+
+// recordCLIAudit adds an action of a utility command to the audit log.
+func recordCLIAudit(database *sql.DB, action, target, details string) {
+	err := db.RecordAudit(database, db.AuditEntry{
+		Actor:   "cli",
+		Action:  action,
+		Target:  target,
+		Details: details,
+		Success: true,
 	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+}
+
+// startupSettings are the settings checked by checkStartupSettings, after
+// merging the config file, environment and flags.
+type startupSettings struct {
+	webAddr                 string
+	collectorAddr           string
+	collectorPassword       string
+	collectorPasswordFormat string
+	webUser                 string
+	webPassword             string
+	webPasswordFormat       string
+	tlsCert                 string
+	tlsKey                  string
+	acmeDomains             string
+	syslogFacility          string
+}
+
+// checkStartupSettings returns the problems of settings that the server
+// would only find when starting: listen addresses, bcrypt hashes, TLS
+// files and the syslog facility.
+func checkStartupSettings(s startupSettings) []string {
+	var problems []string
+
+	webHost, webPort, err := checkListenAddress(s.webAddr)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("Invalid -listen: %v", err))
+	}
+	collectorHost, collectorPort, err := checkListenAddress(s.collectorAddr)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("Invalid -collector: %v", err))
+	}
+	if webPort != "" && webPort == collectorPort && listenOverlap(webHost, collectorHost) {
+		problems = append(problems, fmt.Sprintf("Collector (%s) and web UI (%s) listen on the same port", s.collectorAddr, s.webAddr))
+	}
+
+	if s.collectorPasswordFormat == "bcrypt" {
+		if _, err := bcrypt.Cost([]byte(s.collectorPassword)); err != nil {
+			problems = append(problems, "Collector password is not a bcrypt hash (see -hash-password)")
+		}
+	}
+	if s.webPasswordFormat == "bcrypt" && s.webPassword != "" {
+		if _, err := bcrypt.Cost([]byte(s.webPassword)); err != nil {
+			problems = append(problems, "Web password is not a bcrypt hash (see -hash-password)")
+		}
+	}
+	if (s.webUser == "") != (s.webPassword == "") {
+		problems = append(problems, "Web authentication needs both -web-user and -web-password (it is disabled)")
+	}
+
+	switch {
+	case s.acmeDomains != "" && (s.tlsCert != "" || s.tlsKey != ""):
+		problems = append(problems, "-acme-domains cannot be combined with -tls-cert/-tls-key")
+	case (s.tlsCert == "") != (s.tlsKey == ""):
+		problems = append(problems, "Both -tls-cert and -tls-key must be provided for TLS")
+	case s.tlsCert != "":
+		if _, err := tls.LoadX509KeyPair(s.tlsCert, s.tlsKey); err != nil {
+			problems = append(problems, fmt.Sprintf("Failed to load TLS certificate: %v", err))
+		}
+	}
+
+	if s.syslogFacility != "" {
+		if err := checkSyslogFacility(s.syslogFacility); err != nil {
+			problems = append(problems, fmt.Sprintf("Invalid -syslog: %v", err))
+		}
+	}
+
+	return problems
+}
+
+// checkListenAddress splits a listen address and checks its port.
+func checkListenAddress(addr string) (host, port string, err error) {
+	host, port, err = net.SplitHostPort(addr)
+	if err != nil {
+		return "", "", err
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > 65535 {
+		return "", "", fmt.Errorf("invalid port %q in %s", port, addr)
+	}
+	return host, port, nil
+}
+
+// listenOverlap reports whether two listen hosts can clash on the same
+// port: the same host, or a wildcard address.
+func listenOverlap(a, b string) bool {
+	wildcard := func(h string) bool { return h == "" || h == "0.0.0.0" || h == "::" }
+	return a == b || wildcard(a) || wildcard(b)
+}
+
+// reportConfigProblems prints the problems found by -check-config and
+// returns the exit code: 1 if there are any.
+func reportConfigProblems(problems []string) int {
+	if len(problems) == 0 {
+		fmt.Println("Configuration OK")
+		return 0
+	}
+	for _, p := range problems {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", p)
+	}
+	fmt.Fprintf(os.Stderr, "%d configuration problem(s) found\n", len(problems))
+	return 1
 }
@@ -0,0 +1,20 @@
+//go:build !unix
+
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// newSyslogWriter fails: there is no syslog on this system, the service
+// manager collects the logs of cmonit from stderr.
+func newSyslogWriter(facility string) (io.Writer, error) {
+	return nil, checkSyslogFacility(facility)
+}
+
+// checkSyslogFacility refuses any facility: there is no syslog on this
+// system.
+func checkSyslogFacility(facility string) error {
+	return fmt.Errorf("syslog is not supported on this system, log to stderr instead")
+}
@@ -0,0 +1,58 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/syslog"
+	"strings"
+)
+
+// newSyslogWriter connects to syslog with the given facility (-syslog).
+func newSyslogWriter(facility string) (io.Writer, error) {
+	priority, err := parseSyslogFacility(facility)
+	if err != nil {
+		return nil, err
+	}
+	return syslog.New(priority, "cmonit")
+}
+
+// checkSyslogFacility checks the -syslog facility (-check-config).
+func checkSyslogFacility(facility string) error {
+	_, err := parseSyslogFacility(facility)
+	return err
+}
+
+// parseSyslogFacility converts a facility string to syslog.Priority
+//
+// Supported facilities:
+//   - daemon: LOG_DAEMON facility
+//   - local0-local7: LOG_LOCAL0 through LOG_LOCAL7
+//
+// Returns:
+//   - syslog.Priority: The priority combining facility and severity
+//   - error: If the facility string is invalid
+func parseSyslogFacility(facility string) (syslog.Priority, error) {
+	// Map facility names to syslog constants
+	// The priority combines facility (where to log) and severity (log level)
+	// We use LOG_INFO as the default severity
+	facilities := map[string]syslog.Priority{
+		"daemon": syslog.LOG_DAEMON | syslog.LOG_INFO,
+		"local0": syslog.LOG_LOCAL0 | syslog.LOG_INFO,
+		"local1": syslog.LOG_LOCAL1 | syslog.LOG_INFO,
+		"local2": syslog.LOG_LOCAL2 | syslog.LOG_INFO,
+		"local3": syslog.LOG_LOCAL3 | syslog.LOG_INFO,
+		"local4": syslog.LOG_LOCAL4 | syslog.LOG_INFO,
+		"local5": syslog.LOG_LOCAL5 | syslog.LOG_INFO,
+		"local6": syslog.LOG_LOCAL6 | syslog.LOG_INFO,
+		"local7": syslog.LOG_LOCAL7 | syslog.LOG_INFO,
+	}
+
+	priority, ok := facilities[strings.ToLower(facility)]
+	if !ok {
+		return 0, fmt.Errorf("unknown facility '%s', supported: daemon, local0-local7", facility)
+	}
+
+	return priority, nil
+}
@@ -11,6 +11,7 @@
 //	CGO_ENABLED=0 GOOS=freebsd GOARCH=amd64 go build -o qdiag ./cmd/qdiag  # cross-compile for the deploy target
 //	./qdiag /var/run/cmonit/cmonit.db
 //	./qdiag -plan /var/run/cmonit/cmonit.db   # also print EXPLAIN QUERY PLAN for each query
+//	./qdiag -group web -os FreeBSD /var/run/cmonit/cmonit.db   # hosts query with the status page filters
 package main
 
 import (
@@ -25,15 +26,61 @@ import (
 	_ "modernc.org/sqlite"
 )
 
-// queries mirrors the status-page queries in internal/web/handlers_status.go.
-// Keep in sync if those queries change shape.
-var queries = []struct {
+// query is a status-page query with its arguments.
+type query struct {
 	name string
 	sql  string
-}{
-	{"hosts", `SELECT id, hostname, last_seen FROM hosts ORDER BY last_seen DESC`},
-	{"services", `SELECT host_id, name, type, status, monitor, pid, cpu_percent, memory_percent, memory_kb, collected_at FROM services ORDER BY host_id, type, name`},
-	{"cpu", `
+	args []interface{}
+}
+
+// hostsQuery mirrors the hosts query of getStatusData, with the filters of
+// the status page (group, os and search parameters) that are not empty.
+func hostsQuery(group, osName, search string) query {
+	q := query{name: "hosts", sql: `
+		SELECT id, hostname, last_seen, COALESCE(os_name, '')
+		FROM hosts
+		WHERE archived_at IS NULL`}
+	if search != "" {
+		q.sql += " AND hostname LIKE ? ESCAPE '\\'"
+		r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+		q.args = append(q.args, "%"+r.Replace(search)+"%")
+	}
+	if group != "" {
+		q.sql += `
+		AND (id IN (
+			SELECT hhg.host_id
+			FROM host_hostgroups hhg
+			JOIN hostgroups hg ON hg.id = hhg.hostgroup_id
+			WHERE hg.name = ?
+		) OR id IN (
+			SELECT host_id FROM service_groups WHERE group_name = ?
+		))`
+		q.args = append(q.args, group, group)
+	}
+	if osName != "" {
+		q.sql += " AND os_name = ?"
+		q.args = append(q.args, osName)
+	}
+	q.sql += " ORDER BY last_seen DESC"
+	return q
+}
+
+// queries mirrors the other status-page queries in
+// internal/web/handlers_status.go. Keep in sync if those queries change
+// shape.
+var queries = []query{
+	{name: "services", sql: `
+		SELECT host_id, name, type, status, monitor, pid, cpu_percent, memory_percent, memory_kb, collected_at,
+		       COALESCE((
+			SELECT e.ack_at IS NOT NULL
+			FROM events e
+			WHERE e.host_id = services.host_id AND e.service_name = services.name
+			ORDER BY e.id DESC
+			LIMIT 1
+		), 0)
+		FROM services
+		ORDER BY host_id, type, name`},
+	{name: "cpu", sql: `
 		SELECT host_id,
 			SUM(CASE WHEN metric_name = 'user' THEN value ELSE 0 END) +
 			SUM(CASE WHEN metric_name = 'system' THEN value ELSE 0 END) +
@@ -42,21 +89,25 @@ var queries = []struct {
 		FROM latest_metrics
 		WHERE metric_type = 'cpu'
 		GROUP BY host_id`},
-	{"mem", `
+	{name: "mem", sql: `
 		SELECT host_id, value
 		FROM latest_metrics
 		WHERE metric_type = 'memory' AND metric_name = 'percent'`},
-	{"events", `SELECT host_id, COUNT(*) FROM events GROUP BY host_id`},
-	{"hostgroups", `SELECT hhg.host_id, hg.name FROM host_hostgroups hhg JOIN hostgroups hg ON hhg.hostgroup_id = hg.id`},
-	{"allhostgroups", `SELECT name FROM hostgroups ORDER BY name`},
+	{name: "events", sql: `SELECT host_id, COUNT(*) FROM events GROUP BY host_id`},
+	{name: "hostgroups", sql: `SELECT hhg.host_id, hg.name FROM host_hostgroups hhg JOIN hostgroups hg ON hg.id = hhg.hostgroup_id ORDER BY hg.name ASC`},
+	{name: "allhostgroups", sql: `SELECT name FROM hostgroups UNION SELECT group_name FROM service_groups ORDER BY 1 ASC`},
+	{name: "allosnames", sql: `SELECT DISTINCT os_name FROM hosts WHERE os_name IS NOT NULL AND os_name != '' AND archived_at IS NULL ORDER BY os_name ASC`},
 }
 
 func main() {
 	showPlan := flag.Bool("plan", false, "also print EXPLAIN QUERY PLAN for each query")
+	group := flag.String("group", "", "filter the hosts query by host or service group, as the status page")
+	osName := flag.String("os", "", "filter the hosts query by OS name, as the status page")
+	search := flag.String("search", "", "filter the hosts query by hostname, as the status page")
 	flag.Parse()
 
 	if flag.NArg() != 1 {
-		fmt.Fprintf(os.Stderr, "usage: %s [-plan] <path-to-cmonit.db>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "usage: %s [-plan] [-group g] [-os name] [-search text] <path-to-cmonit.db>\n", os.Args[0])
 		os.Exit(1)
 	}
 
@@ -67,9 +118,9 @@ func main() {
 	}
 	defer db.Close()
 
-	for _, q := range queries {
+	for _, q := range append([]query{hostsQuery(*group, *osName, *search)}, queries...) {
 		start := time.Now()
-		rows, err := db.Query(q.sql)
+		rows, err := db.Query(q.sql, q.args...)
 		if err != nil {
 			fmt.Printf("%-14s ERROR: %v\n", q.name, err)
 			continue
@@ -89,13 +140,13 @@ func main() {
 		fmt.Printf("%-14s %10s  (%d rows)\n", q.name, elapsed.Round(time.Millisecond), n)
 
 		if *showPlan {
-			printPlan(db, q.name, q.sql)
+			printPlan(db, q.name, q.sql, q.args)
 		}
 	}
 }
 
-func printPlan(db *sql.DB, name, query string) {
-	rows, err := db.Query("EXPLAIN QUERY PLAN " + query)
+func printPlan(db *sql.DB, name, query string, args []interface{}) {
+	rows, err := db.Query("EXPLAIN QUERY PLAN "+query, args...)
 	if err != nil {
 		fmt.Printf("  plan error: %v\n", err)
 		return
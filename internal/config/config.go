@@ -5,12 +5,15 @@
 // - Config file provides defaults
 // - Built-in defaults are used if neither is specified
 //
-// Configuration files use TOML format for readability and structure.
+// Configuration files use TOML format for readability and structure, or
+// YAML for tools generating it more naturally (see yaml.go).
 package config
 
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/BurntSushi/toml"
 )
@@ -28,99 +31,715 @@ import (
 //	password = "monit"
 //
 // Fields use TOML tags to map config file keys to struct fields.
-// The `toml:"key"` tag specifies the TOML key name.
+// The `toml:"key" yaml:"key"` tag specifies the TOML key name.
 type Config struct {
-	Network   NetworkConfig   `toml:"network"`
-	Collector CollectorConfig `toml:"collector"`
-	Web       WebConfig       `toml:"web"`
-	Storage   StorageConfig   `toml:"storage"`
-	Logging   LoggingConfig   `toml:"logging"`
-	Process   ProcessConfig   `toml:"process"`
+	Network      NetworkConfig      `toml:"network" yaml:"network"`
+	Collector    CollectorConfig    `toml:"collector" yaml:"collector"`
+	Web          WebConfig          `toml:"web" yaml:"web"`
+	Storage      StorageConfig      `toml:"storage" yaml:"storage"`
+	Retention    RetentionConfig    `toml:"retention" yaml:"retention"`
+	Logging      LoggingConfig      `toml:"logging" yaml:"logging"`
+	Process      ProcessConfig      `toml:"process" yaml:"process"`
+	Control      ControlConfig      `toml:"control" yaml:"control"`
+	Display      DisplayConfig      `toml:"display" yaml:"display"`
+	EventSyslog  EventSyslogConfig  `toml:"event_syslog" yaml:"event_syslog"`
+	EventSIEM    EventSIEMConfig    `toml:"event_siem" yaml:"event_siem"`
+	EventBus     EventBusConfig     `toml:"event_bus" yaml:"event_bus"`
+	EventNagios  EventNagiosConfig  `toml:"event_nagios" yaml:"event_nagios"`
+	EventSNMP    EventSNMPConfig    `toml:"event_snmp" yaml:"event_snmp"`
+	EventGrafana EventGrafanaConfig `toml:"event_grafana" yaml:"event_grafana"`
+	Flapping     FlappingConfig     `toml:"flapping" yaml:"flapping"`
+	Export       ExportConfig       `toml:"export" yaml:"export"`
+	Heartbeat    HeartbeatConfig    `toml:"heartbeat" yaml:"heartbeat"`
+	Roles        []RoleConfig       `toml:"role" yaml:"role"`
+	Severity     []SeverityConfig   `toml:"severity" yaml:"severity"`
+
+	// Include overlays the files matching this glob pattern, in lexical
+	// order (e.g. "/usr/local/etc/cmonit/conf.d/*.toml"); a top-level key
+	Include string `toml:"include,omitempty" yaml:"include,omitempty"`
+
+	// Unknown lists the keys of the file matching no setting (e.g.
+	// "web.pasword"), set by Load
+	Unknown []string `toml:"-" yaml:"-"`
+
+	// origins records where each key was set, for the messages of
+	// Validate (see validate.go)
+	origins map[string]string
 }
 
 // NetworkConfig contains network/listening configuration.
 type NetworkConfig struct {
 	// Listen is the web UI listen address (host:port)
 	// Example: "0.0.0.0:3000", "localhost:3000", "[::]:3000"
-	Listen string `toml:"listen"`
+	Listen string `toml:"listen" yaml:"listen"`
 
 	// CollectorPort is the collector port number (inherits IP from Listen)
 	// Example: 8080, 9000
-	CollectorPort string `toml:"collector_port"`
+	CollectorPort string `toml:"collector_port" yaml:"collector_port"`
 }
 
 // CollectorConfig contains collector authentication settings.
 type CollectorConfig struct {
 	// User is the HTTP Basic Auth username for collector endpoint
 	// Monit agents must use this username to authenticate
-	User string `toml:"user"`
+	User string `toml:"user" yaml:"user"`
 
 	// Password is the HTTP Basic Auth password for collector endpoint
 	// Can be either plain text or bcrypt hash depending on PasswordFormat
 	// Monit agents must use this password to authenticate
-	Password string `toml:"password"`
+	Password string `toml:"password" yaml:"password"`
+
+	// PasswordFile reads Password from this file instead (first line), to
+	// keep it out of the config file; Password may also reference
+	// environment variables as ${NAME} (see ResolveSecrets)
+	PasswordFile string `toml:"password_file,omitempty" yaml:"password_file,omitempty"`
 
 	// PasswordFormat specifies the format of the Password field
 	// Valid values: "plain" (default) or "bcrypt"
 	// When "bcrypt", Password should be a bcrypt hash (e.g., from cmonit -hash-password)
-	PasswordFormat string `toml:"password_format"`
+	PasswordFormat string `toml:"password_format" yaml:"password_format"`
+
+	// Strict rejects the status documents missing required fields or
+	// holding out of range values with 400, and counts them per host
+	// (GET /api/v1/validation-failures)
+	Strict bool `toml:"strict" yaml:"strict"`
 }
 
 // WebConfig contains web UI settings.
 type WebConfig struct {
-	// User is the HTTP Basic Auth username for web UI
+	// User is the web UI username (login page and HTTP Basic Auth)
 	// Empty string disables authentication
-	User string `toml:"user"`
+	User string `toml:"user" yaml:"user"`
 
-	// Password is the HTTP Basic Auth password for web UI
+	// Password is the web UI password
 	// Can be either plain text or bcrypt hash depending on PasswordFormat
 	// Empty string disables authentication
-	Password string `toml:"password"`
+	Password string `toml:"password" yaml:"password"`
+
+	// PasswordFile reads Password from this file instead (first line)
+	PasswordFile string `toml:"password_file,omitempty" yaml:"password_file,omitempty"`
 
 	// PasswordFormat specifies the format of the Password field
 	// Valid values: "plain" (default) or "bcrypt"
 	// When "bcrypt", Password should be a bcrypt hash (e.g., from htpasswd or cmonit -hash-password)
-	PasswordFormat string `toml:"password_format"`
+	PasswordFormat string `toml:"password_format" yaml:"password_format"`
+
+	// SessionIdleTimeout ends login sessions after this long without a
+	// request (Go duration, e.g. "30m")
+	SessionIdleTimeout string `toml:"session_idle_timeout" yaml:"session_idle_timeout"`
+
+	// SessionRemember is the lifetime of "remember me" login sessions
+	// (Go duration, e.g. "720h"; "0" disables "remember me")
+	SessionRemember string `toml:"session_remember" yaml:"session_remember"`
+
+	// TOTPPolicy is the two-factor authentication policy
+	// Valid values: "off", "optional" (default) or "required"
+	TOTPPolicy string `toml:"totp_policy" yaml:"totp_policy"`
 
 	// Cert is the TLS certificate file path for HTTPS (applies to both Web UI and Collector)
 	// Empty string disables TLS (uses HTTP)
-	Cert string `toml:"cert"`
+	Cert string `toml:"cert" yaml:"cert"`
 
 	// Key is the TLS key file path for HTTPS (applies to both Web UI and Collector)
 	// Empty string disables TLS (uses HTTP)
-	Key string `toml:"key"`
+	Key string `toml:"key" yaml:"key"`
+
+	// ACMEDomains obtains and renews certificates automatically with
+	// ACME/Let's Encrypt for these comma-separated domains, instead of
+	// Cert and Key
+	ACMEDomains string `toml:"acme_domains" yaml:"acme_domains"`
+
+	// ACMEEmail is the contact email of the ACME account
+	ACMEEmail string `toml:"acme_email" yaml:"acme_email"`
+
+	// ACMECache is the directory storing ACME keys and certificates
+	// Default: "acme" next to the database
+	ACMECache string `toml:"acme_cache" yaml:"acme_cache"`
+
+	// ACMEHTTP is the address answering ACME HTTP-01 challenges
+	// Default: ":80"; "off" uses TLS-ALPN-01 only (web UI on port 443)
+	ACMEHTTP string `toml:"acme_http_listen" yaml:"acme_http_listen"`
+
+	// ACMEDirectory is the ACME directory URL
+	// Default: Let's Encrypt production
+	ACMEDirectory string `toml:"acme_directory" yaml:"acme_directory"`
+
+	// PublicStatus serves a read-only status page at /public without
+	// authentication, listing only hosts marked public on their host page
+	PublicStatus bool `toml:"public_status" yaml:"public_status"`
+}
+
+// ControlConfig contains settings for controlling Monit agents (service
+// actions).
+type ControlConfig struct {
+	// CAFile is the CA bundle verifying the certificates of Monit agents
+	// using HTTPS; hosts can override it on their page
+	// Empty string uses the system roots
+	CAFile string `toml:"ca_file" yaml:"ca_file"`
+
+	// ConnectTimeout bounds the connection to an agent (Go duration)
+	// Default: "5s"
+	ConnectTimeout string `toml:"connect_timeout" yaml:"connect_timeout"`
+
+	// Timeout bounds a whole request to an agent (Go duration)
+	// Default: "10s"
+	Timeout string `toml:"timeout" yaml:"timeout"`
+
+	// Attempts is the number of tries of a request to an agent that failed
+	// on the network (1 = no retry). Service actions are only retried when
+	// the connection failed, so that they are never sent twice
+	// Default: 3
+	Attempts int `toml:"attempts" yaml:"attempts"`
+
+	// RetryBackoff is the wait before the first retry, doubled after each
+	// (Go duration)
+	// Default: "1s"
+	RetryBackoff string `toml:"retry_backoff" yaml:"retry_backoff"`
+
+	// Concurrency is the number of service actions sent to agents at the
+	// same time; bulk and scheduled actions wait their turn
+	// Default: 8
+	Concurrency int `toml:"concurrency" yaml:"concurrency"`
+
+	// HostConcurrency is the number of service actions sent to the same
+	// agent at the same time
+	// Default: 1
+	HostConcurrency int `toml:"host_concurrency" yaml:"host_concurrency"`
+}
+
+// RoleConfig defines a role limiting the service actions of the API tokens
+// assigned to it ([[role]] tables):
+//
+//	[[role]]
+//	name = "lab-operators"
+//	hostgroups = ["lab"]
+//	actions = ["start", "stop", "restart"]
+type RoleConfig struct {
+	// Name identifies the role (see -token-role)
+	Name string `toml:"name" yaml:"name"`
+
+	// HostGroups are the hostgroups whose hosts the role may act on
+	// Empty means all hosts
+	HostGroups []string `toml:"hostgroups" yaml:"hostgroups"`
+
+	// Actions are the service actions the role may run
+	// Empty means all actions (start, stop, restart, monitor, unmonitor)
+	Actions []string `toml:"actions" yaml:"actions"`
+}
+
+// SeverityConfig gives a severity to the events it matches ([[severity]]
+// tables), checked in order before the default rules:
+//
+//	[[severity]]
+//	events = ["checksum"]
+//	service_types = ["file"]
+//	severity = "critical"
+type SeverityConfig struct {
+	// Events are the event types matched ("checksum", "nonexist"...)
+	// Empty means all event types
+	Events []string `toml:"events" yaml:"events"`
+
+	// ServiceTypes are the service types matched ("file", "process"...)
+	// Empty means all service types
+	ServiceTypes []string `toml:"service_types" yaml:"service_types"`
+
+	// States are the event states matched (succeeded, failed, changed,
+	// changed_not)
+	// Empty means all states
+	States []string `toml:"states" yaml:"states"`
+
+	// Severity is info, warning or critical
+	Severity string `toml:"severity" yaml:"severity"`
 }
 
 // StorageConfig contains database and file storage settings.
 type StorageConfig struct {
 	// Database is the SQLite database file path
-	Database string `toml:"database"`
+	Database string `toml:"database" yaml:"database"`
 
 	// PidFile is the PID file path
-	PidFile string `toml:"pidfile"`
+	PidFile string `toml:"pidfile" yaml:"pidfile"`
+
+	// SecretKeyFile holds the key encrypting the secrets stored in the
+	// database (per-host Monit passwords); created on first use
+	// Empty string uses cmonit.key next to the database
+	SecretKeyFile string `toml:"secret_key_file" yaml:"secret_key_file"`
 
 	// RetentionDays controls how long metrics/events are kept before a
 	// background job prunes them. 0 or unset means "use the default" (30).
-	RetentionDays int `toml:"retention_days"`
+	// The [retention] section sets them separately
+	RetentionDays int `toml:"retention_days" yaml:"retention_days"`
+
+	// ProgramOutputMax truncates the output of the program services
+	// beyond this many bytes before it is stored, with a marker giving its
+	// whole length. 0 or unset means "use the default" (4096), -1 keeps
+	// the whole output
+	ProgramOutputMax int `toml:"program_output_max" yaml:"program_output_max"`
+
+	// ProgramFullOutput also stores the whole output of the truncated
+	// program outputs, shown on the service page
+	ProgramFullOutput bool `toml:"program_full_output" yaml:"program_full_output"`
+}
+
+// RetentionConfig sets how long the history is kept before a background
+// job prunes it. Ages are Go durations or a number of days or weeks, e.g.
+// "720h", "30d" or "12w" (see ParseAge).
+type RetentionConfig struct {
+	// Metrics is the age of the service metrics history
+	// Default: [storage] retention_days, or "30d"
+	Metrics string `toml:"metrics" yaml:"metrics"`
+
+	// Events is the age of the events
+	// Default: [storage] retention_days, or "30d"
+	Events string `toml:"events" yaml:"events"`
+
+	// EventsPerHost keeps at most this many events of each host, the
+	// newest ones
+	// 0 or unset means no limit
+	EventsPerHost int `toml:"events_per_host" yaml:"events_per_host"`
+
+	// AggregateEvents adds the events deleted by the retention job to
+	// daily counts per host, service, event type and severity
+	// (GET /api/v1/events/daily)
+	AggregateEvents bool `toml:"aggregate_events" yaml:"aggregate_events"`
+
+	// ArchiveHosts archives the hosts not reporting for this long: hidden
+	// from the dashboard, with their history kept (see /archive)
+	// Default: never
+	ArchiveHosts string `toml:"archive_hosts" yaml:"archive_hosts"`
+
+	// DeleteHosts deletes the archived hosts not reporting for this long,
+	// with their history
+	// Default: never
+	DeleteHosts string `toml:"delete_hosts" yaml:"delete_hosts"`
+
+	// DeleteWarning records a warning event on the archived hosts, once a
+	// day, from this long before their deletion, which comes no sooner than
+	// this long after the first warning
+	// Default: "7d"
+	DeleteWarning string `toml:"delete_warning" yaml:"delete_warning"`
+}
+
+// DisplayConfig sets how the web UI and the API labels show timestamps.
+// Users may still choose their own timezone in their preferences.
+type DisplayConfig struct {
+	// Timezone is the IANA name of the timezone of the timestamps, e.g.
+	// "Europe/Paris" or "UTC"
+	// Default: the local time of the server
+	Timezone string `toml:"timezone" yaml:"timezone"`
+
+	// DateFormat is the Go layout of every timestamp, e.g.
+	// "2006-01-02 15:04:05" (see https://pkg.go.dev/time#Layout)
+	// Default: a layout chosen by each page
+	DateFormat string `toml:"date_format" yaml:"date_format"`
+}
+
+// EventSyslogConfig forwards the stored events to syslog, for the log
+// pipelines to pick up the state changes of the services.
+type EventSyslogConfig struct {
+	// Target is "local" for the local syslog daemon, or udp://host[:port]
+	// or tcp://host[:port] for a remote server (RFC 5424 messages; ports
+	// 514 and 601 by default)
+	// Empty string disables the forwarding
+	Target string `toml:"target" yaml:"target"`
+
+	// Facility is user, daemon or local0-local7
+	// Default: "daemon"
+	Facility string `toml:"facility" yaml:"facility"`
+
+	// AppName is the APP-NAME (or tag) of the messages
+	// Default: "cmonit"
+	AppName string `toml:"app_name" yaml:"app_name"`
+
+	// MinSeverity is the lowest severity of the events forwarded: info,
+	// warning or critical
+	// Default: "info" (all the events)
+	MinSeverity string `toml:"min_severity" yaml:"min_severity"`
+}
+
+// EventSIEMConfig ships the stored events to a SIEM collector
+// (ArcSight, QRadar...) in the CEF or LEEF format.
+type EventSIEMConfig struct {
+	// Format is cef or leef
+	// Default: "cef"
+	Format string `toml:"format" yaml:"format"`
+
+	// Target is udp://host[:port] or tcp://host[:port] for syslog, or an
+	// https:// URL receiving one POST per event
+	// Empty string disables the export
+	Target string `toml:"target" yaml:"target"`
+
+	// Facility is the syslog facility: user, daemon or local0-local7
+	// Default: "daemon"
+	Facility string `toml:"facility" yaml:"facility"`
+
+	// Token is sent as a Bearer token with the HTTPS requests
+	Token string `toml:"token" yaml:"token"`
+
+	// MinSeverity is the lowest severity of the events shipped: info,
+	// warning or critical
+	// Default: "info" (all the events)
+	MinSeverity string `toml:"min_severity" yaml:"min_severity"`
+
+	// Fields maps the CEF extension keys or LEEF attributes to event
+	// fields (id, host_id, hostname, service, event_type, state, action,
+	// message, severity, severity_level, created_at), or to a literal
+	// after "=", e.g. cs1Label = "=Service"
+	// Default: a mapping per format (see internal/forward/siem.go)
+	Fields map[string]string `toml:"fields" yaml:"fields"`
+}
+
+// EventBusConfig publishes the stored events as JSON messages to a
+// message bus, for automation to react to the state changes.
+type EventBusConfig struct {
+	// URL is nats://host[:port], mqtt://host[:port], mqtts://host[:port],
+	// or kafka+https://host[:port][/path] for a Kafka REST proxy
+	// Empty string disables the publishing
+	URL string `toml:"url" yaml:"url"`
+
+	// Topic is the subject (NATS) or topic, with {host}, {service} and
+	// {severity} replaced by the fields of the event
+	// Default: "cmonit.events" (NATS), "cmonit/events" (MQTT) or
+	// "cmonit-events" (Kafka)
+	Topic string `toml:"topic" yaml:"topic"`
+
+	// User and Password authenticate to the bus (a NATS token is the
+	// user alone)
+	User     string `toml:"user" yaml:"user"`
+	Password string `toml:"password" yaml:"password"`
+
+	// MinSeverity is the lowest severity of the events published: info,
+	// warning or critical
+	// Default: "info" (all the events)
+	MinSeverity string `toml:"min_severity" yaml:"min_severity"`
+}
+
+// ExportConfig sends the collected metrics to time series databases, one
+// subsection each, so that they keep the history cmonit does not.
+type ExportConfig struct {
+	RemoteWrite   RemoteWriteConfig   `toml:"remote_write" yaml:"remote_write"`
+	InfluxDB      InfluxDBConfig      `toml:"influxdb" yaml:"influxdb"`
+	Graphite      GraphiteConfig      `toml:"graphite" yaml:"graphite"`
+	OTLP          OTLPConfig          `toml:"otlp" yaml:"otlp"`
+	StatsD        StatsDConfig        `toml:"statsd" yaml:"statsd"`
+	MQTT          MQTTStateConfig     `toml:"mqtt" yaml:"mqtt"`
+	Elasticsearch ElasticsearchConfig `toml:"elasticsearch" yaml:"elasticsearch"`
+}
+
+// RemoteWriteConfig forwards each stored metric to a Prometheus remote
+// write endpoint (Mimir, VictoriaMetrics, Thanos receive...).
+type RemoteWriteConfig struct {
+	// URL is the http(s):// URL of the endpoint, e.g.
+	// "https://mimir.example.com/api/v1/push"
+	// Empty string disables the forwarding
+	URL string `toml:"url" yaml:"url"`
+
+	// User and Password authenticate with HTTP Basic Auth
+	User     string `toml:"user" yaml:"user"`
+	Password string `toml:"password" yaml:"password"`
+
+	// Token is sent as a Bearer token, instead of a user and password
+	Token string `toml:"token" yaml:"token"`
+
+	// Headers are added to each request, e.g. X-Scope-OrgID = "fleet"
+	// for a Mimir tenant
+	Headers map[string]string `toml:"headers" yaml:"headers"`
+}
+
+// InfluxDBConfig writes the stored metrics to InfluxDB 1.x (database) or
+// 2.x (org and bucket), in the line protocol.
+type InfluxDBConfig struct {
+	// URL is the http(s):// URL of the server, e.g.
+	// "http://influxdb.example.com:8086"
+	// Empty string disables the export
+	URL string `toml:"url" yaml:"url"`
+
+	// Org, Bucket and Token select the bucket of InfluxDB 2.x
+	Org    string `toml:"org" yaml:"org"`
+	Bucket string `toml:"bucket" yaml:"bucket"`
+	Token  string `toml:"token" yaml:"token"`
+
+	// Database and RetentionPolicy select the database of InfluxDB 1.x,
+	// used without Bucket; User and Password authenticate to it
+	Database        string `toml:"database" yaml:"database"`
+	RetentionPolicy string `toml:"retention_policy" yaml:"retention_policy"`
+	User            string `toml:"user" yaml:"user"`
+	Password        string `toml:"password" yaml:"password"`
+}
+
+// GraphiteConfig sends the stored metrics to Carbon (Graphite).
+type GraphiteConfig struct {
+	// Target is tcp://host[:port]; the port defaults to 2003 (plaintext)
+	// or 2004 (pickle)
+	// Empty string disables the export
+	Target string `toml:"target" yaml:"target"`
+
+	// Format is plaintext or pickle
+	// Default: "plaintext"
+	Format string `toml:"format" yaml:"format"`
+
+	// Path is the template of the metric paths, with {host}, {host_id},
+	// {service}, {type} (e.g. cpu), {name} (e.g. user) and {metric}
+	// (e.g. cpu_user)
+	// Default: "monit.{host}.{service}.{metric}"
+	Path string `toml:"path" yaml:"path"`
+}
+
+// OTLPConfig exports the stored metrics to an OpenTelemetry collector.
+type OTLPConfig struct {
+	// Endpoint is the http(s):// URL of the collector, e.g.
+	// "http://otel-collector:4318" (OTLP/HTTP) or
+	// "http://otel-collector:4317" (OTLP/gRPC)
+	// Empty string disables the export
+	Endpoint string `toml:"endpoint" yaml:"endpoint"`
+
+	// Protocol is http (binary protobuf) or grpc
+	// Default: "http"
+	Protocol string `toml:"protocol" yaml:"protocol"`
+
+	// Headers are added to each request, e.g. Authorization =
+	// "Bearer ${OTLP_TOKEN}"
+	Headers map[string]string `toml:"headers" yaml:"headers"`
+}
+
+// StatsDConfig emits a selection of the stored metrics as StatsD gauges.
+type StatsDConfig struct {
+	// Target is udp://host[:port]; the port defaults to 8125
+	// Empty string disables the emission
+	Target string `toml:"target" yaml:"target"`
+
+	// Prefix starts the gauge names, followed by
+	// <host>.<service>.<type>.<name>
+	// Default: "monit."
+	Prefix string `toml:"prefix" yaml:"prefix"`
+
+	// Metrics are the host/service/metric globs of the data points
+	// emitted, the metric being <type>.<name>, e.g. "web*/*/cpu.*" or
+	// "*/nginx/process_memory.percent"
+	Metrics []string `toml:"metrics" yaml:"metrics"`
+}
+
+// MQTTStateConfig publishes the state of the hosts and services, and key
+// metrics, as retained MQTT messages, with Home Assistant discovery.
+type MQTTStateConfig struct {
+	// URL is the broker, mqtt://host[:port] or mqtts://host[:port]
+	// Empty string disables the publishing
+	URL string `toml:"url" yaml:"url"`
+
+	// User and Password authenticate to the broker
+	User     string `toml:"user" yaml:"user"`
+	Password string `toml:"password" yaml:"password"`
+
+	// TopicPrefix starts the topics, followed by <host>/availability,
+	// <host>/<service>/state and <host>/<service>/<type>_<name>
+	// Default: "cmonit"
+	TopicPrefix string `toml:"topic_prefix" yaml:"topic_prefix"`
+
+	// DiscoveryPrefix is the Home Assistant MQTT discovery prefix the
+	// device and entity configs are published under, usually
+	// "homeassistant"
+	// Empty string publishes no discovery config
+	DiscoveryPrefix string `toml:"discovery_prefix" yaml:"discovery_prefix"`
+
+	// Metrics are the host/service/metric globs of the metrics published,
+	// as in [export.statsd]
+	// Default: CPU, memory, swap and load of the systems, CPU and memory
+	// of the processes
+	Metrics []string `toml:"metrics" yaml:"metrics"`
+}
+
+// ElasticsearchConfig indexes the events, and optionally the metrics, in
+// Elasticsearch or OpenSearch with the bulk API.
+type ElasticsearchConfig struct {
+	// URL is the cluster, e.g. "https://elastic.example.com:9200"
+	// Empty string disables the indexing
+	URL string `toml:"url" yaml:"url"`
+
+	// User and Password authenticate with HTTP Basic Auth, or APIKey (the
+	// base64 encoded id:key)
+	User     string `toml:"user" yaml:"user"`
+	Password string `toml:"password" yaml:"password"`
+	APIKey   string `toml:"api_key" yaml:"api_key"`
+
+	// Index is the index of the events, {date} being the day of the event
+	// (YYYY.MM.DD, UTC)
+	// Default: "cmonit-events-{date}"
+	Index string `toml:"index" yaml:"index"`
+
+	// MetricsIndex is the index of the metrics, as Index
+	// Empty string indexes no metrics
+	MetricsIndex string `toml:"metrics_index" yaml:"metrics_index"`
+
+	// SkipTemplates does not install the index templates of the mappings
+	// (cmonit-events and cmonit-metrics), e.g. when managed elsewhere
+	SkipTemplates bool `toml:"skip_templates" yaml:"skip_templates"`
+
+	// MinSeverity is the lowest severity of the events indexed: info,
+	// warning or critical
+	// Default: "info" (all the events)
+	MinSeverity string `toml:"min_severity" yaml:"min_severity"`
+}
+
+// EventNagiosConfig submits the service state changes as passive check
+// results to Nagios or Icinga 2, to keep their dashboards in sync.
+type EventNagiosConfig struct {
+	// API is nrdp (Nagios Remote Data Processor) or icinga2 (Icinga 2
+	// REST API)
+	// Default: "nrdp"
+	API string `toml:"api" yaml:"api"`
+
+	// URL is the NRDP endpoint (e.g. "https://nagios.example.com/nrdp/")
+	// or the Icinga 2 API (e.g. "https://icinga.example.com:5665")
+	// Empty string disables the submission
+	URL string `toml:"url" yaml:"url"`
+
+	// Token authenticates to NRDP
+	Token string `toml:"token" yaml:"token"`
+
+	// User and Password authenticate to the Icinga 2 API
+	User     string `toml:"user" yaml:"user"`
+	Password string `toml:"password" yaml:"password"`
+
+	// MinSeverity is the lowest severity of the events submitted: info,
+	// warning or critical (recoveries are info)
+	// Default: "info" (all the events)
+	MinSeverity string `toml:"min_severity" yaml:"min_severity"`
+}
+
+// EventSNMPConfig sends SNMP traps for the events and the hosts going
+// offline, with the cmonit MIB (docs/CMONIT-MIB.txt), for trap-driven
+// network operations centers.
+type EventSNMPConfig struct {
+	// Target is the trap receiver, as udp://host[:port] (port 162 by
+	// default)
+	// Empty string disables the traps
+	Target string `toml:"target" yaml:"target"`
+
+	// Version is 2c or 3
+	// Default: "2c"
+	Version string `toml:"version" yaml:"version"`
+
+	// Community is the SNMPv2c community
+	// Default: "public"
+	Community string `toml:"community" yaml:"community"`
+
+	// User is the SNMPv3 security name
+	User string `toml:"user" yaml:"user"`
+
+	// AuthProtocol (md5, sha or sha256) and AuthPassword authenticate the
+	// SNMPv3 traps; no password sends them unauthenticated
+	// Default: "sha"
+	AuthProtocol string `toml:"auth_protocol" yaml:"auth_protocol"`
+	AuthPassword string `toml:"auth_password" yaml:"auth_password"`
+
+	// PrivProtocol (aes, AES-128) and PrivPassword encrypt the SNMPv3
+	// traps, which must be authenticated
+	// Default: "aes"
+	PrivProtocol string `toml:"priv_protocol" yaml:"priv_protocol"`
+	PrivPassword string `toml:"priv_password" yaml:"priv_password"`
+
+	// EngineID is the SNMPv3 engine ID of cmonit, in hex, as configured
+	// for the user on the receiver
+	// Default: derived from the hostname, logged at startup
+	EngineID string `toml:"engine_id" yaml:"engine_id"`
+
+	// MinSeverity is the lowest severity of the events trapped: info,
+	// warning or critical
+	// Default: "critical"
+	MinSeverity string `toml:"min_severity" yaml:"min_severity"`
+}
+
+// EventGrafanaConfig creates Grafana annotations for the failures, the
+// recoveries and the reboots, to overlay them on existing dashboards.
+type EventGrafanaConfig struct {
+	// URL is the Grafana server, e.g. "https://grafana.example.com"
+	// Empty string disables the annotations
+	URL string `toml:"url" yaml:"url"`
+
+	// Token is a service account token (Annotations writer), or User and
+	// Password authenticate with HTTP Basic Auth
+	Token    string `toml:"token" yaml:"token"`
+	User     string `toml:"user" yaml:"user"`
+	Password string `toml:"password" yaml:"password"`
+
+	// DashboardUID restricts the annotations to a dashboard
+	// Default: "" (organization annotations, shown by the dashboards
+	// querying their tags)
+	DashboardUID string `toml:"dashboard_uid" yaml:"dashboard_uid"`
+
+	// Tags are added to the cmonit, kind, host:<host> and
+	// service:<service> tags of each annotation
+	Tags []string `toml:"tags" yaml:"tags"`
+
+	// Events are the kinds of events annotated: failure, recovery, reboot
+	// Default: all of them
+	Events []string `toml:"events" yaml:"events"`
+
+	// MinSeverity is the lowest severity of the events annotated: info,
+	// warning or critical (recoveries are info)
+	// Default: "info"
+	MinSeverity string `toml:"min_severity" yaml:"min_severity"`
+}
+
+// HeartbeatConfig pings dead-man's-switch URLs (healthchecks.io style)
+// when status reports are stored, for an external watchdog to notice when
+// cmonit itself, or the agents of a site, go silent.
+type HeartbeatConfig struct {
+	// URL is pinged (HTTP GET) after the reports of any host, at most
+	// every Interval
+	// Empty string disables the global ping
+	URL string `toml:"url" yaml:"url"`
+
+	// Interval is the least time between two pings of URL
+	// Default: "1m"
+	Interval string `toml:"interval" yaml:"interval"`
+
+	// Hosts are pinged after each report of the host, by hostname, e.g.
+	// "web1" = "https://hc-ping.com/<uuid>"
+	Hosts map[string]string `toml:"hosts" yaml:"hosts"`
+}
+
+// FlappingConfig collapses the failures and recoveries of a service
+// oscillating between the two into a single "flapping" event.
+type FlappingConfig struct {
+	// Changes is the number of failures and recoveries of a service within
+	// Window making it flapping, at least 3
+	// 0 or unset disables the detection
+	Changes int `toml:"changes" yaml:"changes"`
+
+	// Window is the time the changes are counted over; the service stops
+	// flapping when it does not change for this long
+	// Default: "10m"
+	Window string `toml:"window" yaml:"window"`
 }
 
 // LoggingConfig contains logging settings.
 type LoggingConfig struct {
 	// Syslog is the syslog facility (daemon, local0-local7)
 	// Empty string logs to stderr
-	Syslog string `toml:"syslog"`
+	Syslog string `toml:"syslog" yaml:"syslog"`
 
 	// Debug enables verbose debug logging
-	Debug bool `toml:"debug"`
+	Debug bool `toml:"debug" yaml:"debug"`
+
+	// ParseDiagnostics records the problems found in the status documents
+	// of the agents in the database (GET /api/v1/parse-diagnostics)
+	ParseDiagnostics bool `toml:"parse_diagnostics" yaml:"parse_diagnostics"`
 }
 
 // ProcessConfig contains process control settings.
 type ProcessConfig struct {
 	// Daemon runs cmonit as a background daemon
-	Daemon bool `toml:"daemon"`
+	Daemon bool `toml:"daemon" yaml:"daemon"`
+
+	// Supervised runs cmonit in the foreground under a service manager
+	// (systemd, FreeBSD daemon(8)...), which timestamps the logs and is
+	// told when cmonit is ready (sd_notify)
+	Supervised bool `toml:"supervised" yaml:"supervised"`
 }
 
-// Load reads and parses a TOML configuration file.
+// Load reads and parses a TOML configuration file, or a YAML one when its
+// name ends with .yaml or .yml (same sections and keys).
 //
 // The function:
 // 1. Checks if the file exists
@@ -150,18 +769,50 @@ func Load(path string) (*Config, error) {
 	// Create empty config struct
 	var cfg Config
 
+	unknown, err := decodeFile(path, &cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+	cfg.Unknown = unknown
+
+	// Overlay the included files (see include.go)
+	if cfg.Include != "" {
+		if err := loadIncludes(path, &cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	return &cfg, nil
+}
+
+// decodeFile decodes a TOML or YAML file (selected by its extension) into
+// cfg, setting only the keys it contains, and returns its unknown keys.
+func decodeFile(path string, cfg *Config) ([]string, error) {
+	// YAML files are selected by their extension
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return decodeYAML(path, cfg)
+	}
+
 	// Parse TOML file
 	//
 	// toml.DecodeFile() reads the file and populates the struct
 	// It uses struct tags to map TOML keys to struct fields
 	//
-	// The decoder is strict - unknown keys will cause an error
-	// This helps catch typos in config files
-	if _, err := toml.DecodeFile(path, &cfg); err != nil {
-		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	// Unknown keys are not an error, so that older versions still start
+	// with a newer file; they are returned to catch typos
+	md, err := toml.DecodeFile(path, cfg)
+	if err != nil {
+		return nil, err
 	}
-
-	return &cfg, nil
+	if err := cfg.recordTOMLOrigins(path, md); err != nil {
+		return nil, err
+	}
+	var unknown []string
+	for _, key := range md.Undecoded() {
+		unknown = append(unknown, key.String())
+	}
+	return unknown, nil
 }
 
 // Merge combines configuration from multiple sources with priority.
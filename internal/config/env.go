@@ -0,0 +1,126 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix starts the names of the environment variables overriding
+// configuration keys.
+const EnvPrefix = "CMONIT_"
+
+// EnvName returns the environment variable overriding a configuration key:
+// EnvPrefix, then the section and key in upper case, e.g. CMONIT_WEB_PASSWORD
+// for password in the [web] section.
+func EnvName(section, key string) string {
+	return EnvPrefix + strings.ToUpper(section) + "_" + strings.ToUpper(key)
+}
+
+// ApplyEnv overrides the keys of cfg with the CMONIT_* environment
+// variables of environ (as returned by os.Environ), so that containers can
+// pass settings, and secrets in particular, without flags or files.
+//
+// Priority: CLI flags > environment variables > config file > defaults.
+// [[role]] and [[severity]] tables, and [event_siem.fields], can only be
+// set in the config file.
+//
+// It returns the CMONIT_* variables matching no key, sorted, for the caller
+// to warn about typos. A value that does not parse (e.g. "yes" for a number)
+// is an error.
+func ApplyEnv(cfg *Config, environ []string) (unknown []string, err error) {
+	fields := envFields(cfg)
+
+	for _, kv := range environ {
+		name, value, ok := strings.Cut(kv, "=")
+		if !ok || !strings.HasPrefix(name, EnvPrefix) {
+			continue
+		}
+		field, ok := fields[name]
+		if !ok {
+			unknown = append(unknown, name)
+			continue
+		}
+		if err := setField(field.value, value); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", name, err)
+		}
+		cfg.SetOrigin(field.key, name)
+	}
+
+	sort.Strings(unknown)
+	return unknown, nil
+}
+
+// envField is a key of cfg set by an environment variable.
+type envField struct {
+	value reflect.Value
+	key   string // e.g. "web.password"
+}
+
+// envFields maps the environment variable of each key of cfg to its field,
+// following the toml tags of the sections and their keys.
+func envFields(cfg *Config) map[string]envField {
+	fields := make(map[string]envField)
+
+	root := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < root.NumField(); i++ {
+		section := root.Field(i)
+		if section.Kind() != reflect.Struct {
+			continue // [[role]] and [[severity]] tables
+		}
+		addEnvFields(fields, section, root.Type().Field(i).Tag.Get("toml"))
+	}
+
+	return fields
+}
+
+// addEnvFields adds the keys of section to fields, and those of its
+// subsections ([export.remote_write]: CMONIT_EXPORT_REMOTE_WRITE_URL).
+func addEnvFields(fields map[string]envField, section reflect.Value, sectionName string) {
+	for j := 0; j < section.NumField(); j++ {
+		key, _, _ := strings.Cut(section.Type().Field(j).Tag.Get("toml"), ",")
+		switch section.Field(j).Kind() {
+		case reflect.Map:
+			continue // [event_siem.fields]
+		case reflect.Struct:
+			addEnvFields(fields, section.Field(j), sectionName+"."+key)
+			continue
+		}
+		name := EnvName(strings.ReplaceAll(sectionName, ".", "_"), key)
+		fields[name] = envField{section.Field(j), sectionName + "." + key}
+	}
+}
+
+// setField sets a string, int, bool or list field from its text value,
+// the items of a list separated by commas.
+func setField(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	case reflect.Int:
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("must be a number")
+		}
+		field.SetInt(int64(n))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("must be true or false")
+		}
+		field.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported type %s", field.Kind())
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+)
+
+// loadIncludes overlays on cfg the files matching its Include pattern, in
+// lexical order, so that settings can be split into per-team files
+// (conf.d). A relative pattern is relative to the directory of mainPath.
+//
+// Each included file sets only the keys it contains, overriding the main
+// file and the files before it; its [[role]] and [[severity]] tables are
+// added to the others. Included files cannot include files themselves.
+func loadIncludes(mainPath string, cfg *Config) error {
+	pattern := cfg.Include
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(filepath.Dir(mainPath), pattern)
+	}
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid include pattern %q: %w", cfg.Include, err)
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		roles, severities := cfg.Roles, cfg.Severity
+		cfg.Roles, cfg.Severity = nil, nil
+		cfg.Include = ""
+
+		unknown, err := decodeFile(file, cfg)
+		if err != nil {
+			return fmt.Errorf("failed to parse included config file %s: %w", file, err)
+		}
+		if cfg.Include != "" {
+			return fmt.Errorf("included config file %s: include is only allowed in the main config file", file)
+		}
+		for _, key := range unknown {
+			cfg.Unknown = append(cfg.Unknown, file+": "+key)
+		}
+
+		cfg.Roles = append(roles, cfg.Roles...)
+		cfg.Severity = append(severities, cfg.Severity...)
+	}
+
+	cfg.Include = pattern
+	return nil
+}
@@ -0,0 +1,159 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// envRefPattern matches the ${NAME} references to environment variables in
+// credentials. The $NAME form is not expanded: bcrypt hashes ("$2a$10$...")
+// contain dollar signs.
+var envRefPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// ResolveSecrets completes the credentials of cfg so that secrets need not
+// be written in the config file itself:
+//
+//   - ${NAME} in users, passwords and tokens, including those of
+//     [event_siem], [event_bus], [event_nagios] and [export.*], and in the headers of
+//     [export.otlp], is replaced by the environment variable NAME, e.g.
+//     password = "${CMONIT_ADMIN_PASSWORD}"
+//   - password_file reads the password from a file (without its trailing
+//     newline), e.g. password_file = "/usr/local/etc/cmonit/web.pass"
+//
+// A reference to an unset variable, an unreadable file, or both password
+// and password_file in a section is an error.
+func (cfg *Config) ResolveSecrets() error {
+	sections := []struct {
+		name           string
+		user, password *string
+		passwordFile   string
+	}{
+		{"collector", &cfg.Collector.User, &cfg.Collector.Password, cfg.Collector.PasswordFile},
+		{"web", &cfg.Web.User, &cfg.Web.Password, cfg.Web.PasswordFile},
+	}
+
+	// Every section is resolved even if another fails, so that a problem
+	// is not followed by a misleading missing credential
+	var firstErr error
+	fail := func(err error) {
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	for _, s := range sections {
+		switch {
+		case s.passwordFile != "" && *s.password != "":
+			fail(fmt.Errorf("[%s] sets both password and password_file", s.name))
+		case s.passwordFile != "":
+			password, err := readPasswordFile(s.passwordFile)
+			if err != nil {
+				fail(fmt.Errorf("[%s] password_file: %w", s.name, err))
+			}
+			*s.password = password
+		default:
+			expanded, err := expandEnvRefs(*s.password)
+			if err != nil {
+				fail(fmt.Errorf("[%s] password: %w", s.name, err))
+			}
+			*s.password = expanded
+		}
+
+		expanded, err := expandEnvRefs(*s.user)
+		if err != nil {
+			fail(fmt.Errorf("[%s] user: %w", s.name, err))
+		}
+		*s.user = expanded
+	}
+
+	others := []struct {
+		key   string
+		value *string
+	}{
+		{"[event_siem] token", &cfg.EventSIEM.Token},
+		{"[event_bus] user", &cfg.EventBus.User},
+		{"[event_bus] password", &cfg.EventBus.Password},
+		{"[event_nagios] token", &cfg.EventNagios.Token},
+		{"[event_nagios] user", &cfg.EventNagios.User},
+		{"[event_nagios] password", &cfg.EventNagios.Password},
+		{"[event_snmp] community", &cfg.EventSNMP.Community},
+		{"[event_snmp] auth_password", &cfg.EventSNMP.AuthPassword},
+		{"[event_snmp] priv_password", &cfg.EventSNMP.PrivPassword},
+		{"[event_grafana] token", &cfg.EventGrafana.Token},
+		{"[event_grafana] user", &cfg.EventGrafana.User},
+		{"[event_grafana] password", &cfg.EventGrafana.Password},
+		{"[heartbeat] url", &cfg.Heartbeat.URL},
+		{"[export.remote_write] user", &cfg.Export.RemoteWrite.User},
+		{"[export.remote_write] password", &cfg.Export.RemoteWrite.Password},
+		{"[export.remote_write] token", &cfg.Export.RemoteWrite.Token},
+		{"[export.influxdb] token", &cfg.Export.InfluxDB.Token},
+		{"[export.influxdb] user", &cfg.Export.InfluxDB.User},
+		{"[export.influxdb] password", &cfg.Export.InfluxDB.Password},
+		{"[export.elasticsearch] user", &cfg.Export.Elasticsearch.User},
+		{"[export.elasticsearch] password", &cfg.Export.Elasticsearch.Password},
+		{"[export.elasticsearch] api_key", &cfg.Export.Elasticsearch.APIKey},
+		{"[export.mqtt] user", &cfg.Export.MQTT.User},
+		{"[export.mqtt] password", &cfg.Export.MQTT.Password},
+	}
+	for _, o := range others {
+		expanded, err := expandEnvRefs(*o.value)
+		if err != nil {
+			fail(fmt.Errorf("%s: %w", o.key, err))
+		}
+		*o.value = expanded
+	}
+
+	// Ping URLs, holding the check keys
+	for hostname, value := range cfg.Heartbeat.Hosts {
+		expanded, err := expandEnvRefs(value)
+		if err != nil {
+			fail(fmt.Errorf("[heartbeat.hosts] %s: %w", hostname, err))
+		}
+		cfg.Heartbeat.Hosts[hostname] = expanded
+	}
+
+	// Authorization headers
+	for name, value := range cfg.Export.OTLP.Headers {
+		expanded, err := expandEnvRefs(value)
+		if err != nil {
+			fail(fmt.Errorf("[export.otlp.headers] %s: %w", name, err))
+		}
+		cfg.Export.OTLP.Headers[name] = expanded
+	}
+
+	return firstErr
+}
+
+// expandEnvRefs replaces the ${NAME} references of value by the
+// environment variables they name.
+func expandEnvRefs(value string) (string, error) {
+	var missing string
+	expanded := envRefPattern.ReplaceAllStringFunc(value, func(ref string) string {
+		name := envRefPattern.FindStringSubmatch(ref)[1]
+		v, ok := os.LookupEnv(name)
+		if !ok && missing == "" {
+			missing = name
+		}
+		return v
+	})
+	if missing != "" {
+		return "", fmt.Errorf("environment variable %s is not set", missing)
+	}
+	return expanded, nil
+}
+
+// readPasswordFile reads a password from the first line of a file.
+func readPasswordFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	password, _, _ := strings.Cut(string(data), "\n")
+	password = strings.TrimSuffix(password, "\r")
+	if password == "" {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+	return password, nil
+}
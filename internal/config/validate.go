@@ -0,0 +1,462 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Origin returns where key (e.g. "web.totp_policy") was set: "file:line"
+// for the config files, the CMONIT_* variable for the environment, the
+// -flag recorded by SetOrigin, or "" for a default.
+func (cfg *Config) Origin(key string) string {
+	return cfg.origins[key]
+}
+
+// SetOrigin records where key was set, e.g. the flag overriding the file.
+func (cfg *Config) SetOrigin(key, origin string) {
+	if cfg.origins == nil {
+		cfg.origins = make(map[string]string)
+	}
+	cfg.origins[key] = origin
+}
+
+// recordTOMLOrigins records the line of each key of the TOML file path
+// decoded into md. [[role]] tables are left out: they have no single line.
+func (cfg *Config) recordTOMLOrigins(path string, md toml.MetaData) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	section := ""
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "[["):
+			section = "" // Array of tables
+			continue
+		case strings.HasPrefix(line, "["):
+			name, _, _ := strings.Cut(line[1:], "]")
+			section = strings.TrimSpace(name)
+			continue
+		}
+		key, _, ok := strings.Cut(line, "=")
+		key = strings.Trim(strings.TrimSpace(key), `"'`)
+		if !ok || key == "" || strings.HasPrefix(key, "#") {
+			continue
+		}
+		if section != "" && md.IsDefined(append(strings.Split(section, "."), key)...) {
+			cfg.SetOrigin(section+"."+key, fmt.Sprintf("%s:%d", path, i+1))
+		} else if section == "" && md.IsDefined(key) {
+			cfg.SetOrigin(key, fmt.Sprintf("%s:%d", path, i+1))
+		}
+	}
+	return nil
+}
+
+// recordYAMLOrigins records the line of each section key of the YAML
+// document data, read from path.
+func (cfg *Config) recordYAMLOrigins(path string, data []byte) error {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil
+	}
+
+	root := doc.Content[0].Content
+	for i := 0; i+1 < len(root); i += 2 {
+		section, value := root[i], root[i+1]
+		if value.Kind != yaml.MappingNode {
+			cfg.SetOrigin(section.Value, fmt.Sprintf("%s:%d", path, section.Line))
+			continue
+		}
+		cfg.recordYAMLSection(path, section.Value, value)
+	}
+	return nil
+}
+
+// recordYAMLSection records the line of each key of the section mapping,
+// and of its subsections (export: remote_write: ...).
+func (cfg *Config) recordYAMLSection(path, section string, mapping *yaml.Node) {
+	for j := 0; j+1 < len(mapping.Content); j += 2 {
+		key, value := mapping.Content[j], mapping.Content[j+1]
+		if section == "export" && value.Kind == yaml.MappingNode {
+			cfg.recordYAMLSection(path, section+"."+key.Value, value)
+			continue
+		}
+		cfg.SetOrigin(section+"."+key.Value, fmt.Sprintf("%s:%d", path, key.Line))
+	}
+}
+
+// ParseAge parses a retention age: a Go duration ("720h") or a whole
+// number of days ("30d") or weeks ("12w").
+func ParseAge(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(s, suffix); ok {
+			count, err := strconv.Atoi(n)
+			if err != nil {
+				return 0, fmt.Errorf("invalid age %q", s)
+			}
+			return time.Duration(count) * unit, nil
+		}
+	}
+	return time.ParseDuration(s)
+}
+
+// RetentionAges returns how long metrics and events are kept: [retention]
+// metrics and events, else [storage] retention_days, else 30 days.
+func (cfg *Config) RetentionAges() (metrics, events time.Duration, err error) {
+	fallback := 30 * 24 * time.Hour
+	if cfg.Storage.RetentionDays > 0 {
+		fallback = time.Duration(cfg.Storage.RetentionDays) * 24 * time.Hour
+	}
+
+	age := func(value string) (time.Duration, error) {
+		if value == "" {
+			return fallback, nil
+		}
+		return ParseAge(value)
+	}
+	if metrics, err = age(cfg.Retention.Metrics); err != nil {
+		return 0, 0, fmt.Errorf("[retention] metrics: %w", err)
+	}
+	if events, err = age(cfg.Retention.Events); err != nil {
+		return 0, 0, fmt.Errorf("[retention] events: %w", err)
+	}
+	return metrics, events, nil
+}
+
+// HostRetention returns how long after their last report the hosts are
+// archived and deleted ([retention] archive_hosts and delete_hosts, 0 for
+// never), and from how long before their deletion warnings are recorded
+// ([retention] delete_warning, 0 for the default).
+func (cfg *Config) HostRetention() (archive, remove, warning time.Duration, err error) {
+	age := func(key, value string) (time.Duration, error) {
+		if value == "" {
+			return 0, nil
+		}
+		d, err := ParseAge(value)
+		if err != nil {
+			return 0, fmt.Errorf("[retention] %s: %w", key, err)
+		}
+		return d, nil
+	}
+	if archive, err = age("archive_hosts", cfg.Retention.ArchiveHosts); err != nil {
+		return 0, 0, 0, err
+	}
+	if remove, err = age("delete_hosts", cfg.Retention.DeleteHosts); err != nil {
+		return 0, 0, 0, err
+	}
+	if warning, err = age("delete_warning", cfg.Retention.DeleteWarning); err != nil {
+		return 0, 0, 0, err
+	}
+	return archive, remove, warning, nil
+}
+
+// Validate checks the values of cfg that need more than their type, and
+// returns a message per invalid key, pointing to where it was set:
+//
+//	cmonit.conf:12: [web] session_idle_timeout = "3x": must be a positive duration, e.g. 30m
+//	[web] totp_policy = "on" (CMONIT_WEB_TOTP_POLICY): must be off, optional or required
+//
+// Settings checked against the system (files, addresses) are left to the
+// caller.
+func (cfg *Config) Validate() []string {
+	var problems []string
+	invalid := func(section, key string, value interface{}, expected string) {
+		setting := fmt.Sprintf("[%s] %s = %#v", section, key, value)
+		origin := cfg.Origin(section + "." + key)
+		switch {
+		case origin == "":
+			problems = append(problems, fmt.Sprintf("%s: %s", setting, expected))
+		case strings.HasPrefix(origin, "-"), strings.HasPrefix(origin, EnvPrefix):
+			problems = append(problems, fmt.Sprintf("%s (%s): %s", setting, origin, expected))
+		default:
+			problems = append(problems, fmt.Sprintf("%s: %s: %s", origin, setting, expected))
+		}
+	}
+	duration := func(section, key, value, example string, allowZero bool) {
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 || (d == 0 && !allowZero) {
+			kind := "a positive duration"
+			if allowZero {
+				kind = "a duration"
+			}
+			invalid(section, key, value, fmt.Sprintf("must be %s, e.g. %s", kind, example))
+		}
+	}
+
+	if f := cfg.Collector.PasswordFormat; f != "" && f != "plain" && f != "bcrypt" {
+		invalid("collector", "password_format", f, "must be plain or bcrypt")
+	}
+	if f := cfg.Web.PasswordFormat; f != "" && f != "plain" && f != "bcrypt" {
+		invalid("web", "password_format", f, "must be plain or bcrypt")
+	}
+
+	if cfg.Web.SessionIdleTimeout != "" {
+		duration("web", "session_idle_timeout", cfg.Web.SessionIdleTimeout, "30m", false)
+	}
+	if cfg.Web.SessionRemember != "" {
+		duration("web", "session_remember", cfg.Web.SessionRemember, "720h, or 0 to disable", true)
+	}
+	switch cfg.Web.TOTPPolicy {
+	case "", "off", "optional", "required":
+	default:
+		invalid("web", "totp_policy", cfg.Web.TOTPPolicy, "must be off, optional or required")
+	}
+
+	if cfg.Control.ConnectTimeout != "" {
+		duration("control", "connect_timeout", cfg.Control.ConnectTimeout, "5s", false)
+	}
+	if cfg.Control.Timeout != "" {
+		duration("control", "timeout", cfg.Control.Timeout, "10s", false)
+	}
+	if cfg.Control.RetryBackoff != "" {
+		duration("control", "retry_backoff", cfg.Control.RetryBackoff, "1s", true)
+	}
+
+	if cfg.Storage.RetentionDays < 0 {
+		invalid("storage", "retention_days", cfg.Storage.RetentionDays, "must be a number of days, at least 1")
+	}
+	if cfg.Storage.ProgramOutputMax < -1 {
+		invalid("storage", "program_output_max", cfg.Storage.ProgramOutputMax, "must be a number of bytes, or -1 for no limit")
+	}
+	age := func(key, value string) {
+		if d, err := ParseAge(value); value != "" && (err != nil || d < time.Hour) {
+			invalid("retention", key, value, "must be an age of at least 1h, e.g. 30d, 12w or 720h")
+		}
+	}
+	age("metrics", cfg.Retention.Metrics)
+	age("events", cfg.Retention.Events)
+	age("archive_hosts", cfg.Retention.ArchiveHosts)
+	age("delete_hosts", cfg.Retention.DeleteHosts)
+	age("delete_warning", cfg.Retention.DeleteWarning)
+	if archive, remove, _, err := cfg.HostRetention(); err == nil && archive > 0 && remove > 0 && remove <= archive {
+		invalid("retention", "delete_hosts", cfg.Retention.DeleteHosts, "must be longer than archive_hosts ("+cfg.Retention.ArchiveHosts+")")
+	}
+	if cfg.Retention.EventsPerHost < 0 {
+		invalid("retention", "events_per_host", cfg.Retention.EventsPerHost, "must be a number of events, or 0 for no limit")
+	}
+
+	if _, err := time.LoadLocation(cfg.Display.Timezone); err != nil {
+		invalid("display", "timezone", cfg.Display.Timezone, "must be an IANA timezone, e.g. Europe/Paris or UTC")
+	}
+	if f := cfg.Display.DateFormat; f != "" && time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC).Format(f) == f {
+		invalid("display", "date_format", f, "must be a Go time layout, e.g. 2006-01-02 15:04:05")
+	}
+
+	facility := func(section, value string) {
+		switch f := strings.ToLower(value); {
+		case f == "", f == "user", f == "daemon":
+		case len(f) == 6 && strings.HasPrefix(f, "local") && f[5] >= '0' && f[5] <= '7':
+		default:
+			invalid(section, "facility", value, "must be user, daemon or local0-local7")
+		}
+	}
+	minSeverity := func(section, value string) {
+		switch value {
+		case "", "info", "warning", "critical":
+		default:
+			invalid(section, "min_severity", value, "must be info, warning or critical")
+		}
+	}
+
+	if t := cfg.EventSyslog.Target; t != "" && t != "local" {
+		u, err := url.Parse(t)
+		if err != nil || (u.Scheme != "udp" && u.Scheme != "tcp") || u.Hostname() == "" {
+			invalid("event_syslog", "target", t, "must be local, udp://host[:port] or tcp://host[:port]")
+		}
+	}
+	facility("event_syslog", cfg.EventSyslog.Facility)
+	if a := cfg.EventSyslog.AppName; strings.ContainsFunc(a, func(r rune) bool { return r <= ' ' || r > '~' }) || len(a) > 48 {
+		invalid("event_syslog", "app_name", a, "must be up to 48 printable ASCII characters, without spaces")
+	}
+	minSeverity("event_syslog", cfg.EventSyslog.MinSeverity)
+
+	switch strings.ToLower(cfg.EventSIEM.Format) {
+	case "", "cef", "leef":
+	default:
+		invalid("event_siem", "format", cfg.EventSIEM.Format, "must be cef or leef")
+	}
+	if t := cfg.EventSIEM.Target; t != "" {
+		u, err := url.Parse(t)
+		if err != nil || u.Hostname() == "" || (u.Scheme != "udp" && u.Scheme != "tcp" && u.Scheme != "https" && u.Scheme != "http") {
+			invalid("event_siem", "target", t, "must be udp://host[:port], tcp://host[:port] or an https:// URL")
+		}
+	}
+	facility("event_siem", cfg.EventSIEM.Facility)
+	minSeverity("event_siem", cfg.EventSIEM.MinSeverity)
+
+	if raw := cfg.EventBus.URL; raw != "" {
+		u, err := url.Parse(raw)
+		switch {
+		case err != nil || u.Hostname() == "":
+			invalid("event_bus", "url", raw, "must be nats://, mqtt://, mqtts:// or kafka+https://host[:port]")
+		case u.Scheme != "nats" && u.Scheme != "mqtt" && u.Scheme != "mqtts" && u.Scheme != "kafka+http" && u.Scheme != "kafka+https":
+			invalid("event_bus", "url", raw, "must be nats://, mqtt://, mqtts:// or kafka+https://host[:port]")
+		}
+	}
+	minSeverity("event_bus", cfg.EventBus.MinSeverity)
+
+	switch cfg.EventNagios.API {
+	case "", "nrdp", "icinga2":
+	default:
+		invalid("event_nagios", "api", cfg.EventNagios.API, "must be nrdp or icinga2")
+	}
+	if raw := cfg.EventNagios.URL; raw != "" {
+		u, err := url.Parse(raw)
+		if err != nil || u.Hostname() == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			invalid("event_nagios", "url", raw, "must be an http(s):// URL")
+		}
+	}
+	minSeverity("event_nagios", cfg.EventNagios.MinSeverity)
+
+	if raw := cfg.EventSNMP.Target; raw != "" {
+		u, err := url.Parse(raw)
+		if err != nil || u.Hostname() == "" || u.Scheme != "udp" {
+			invalid("event_snmp", "target", raw, "must be udp://host[:port]")
+		}
+	}
+	switch cfg.EventSNMP.Version {
+	case "", "2c", "3":
+	default:
+		invalid("event_snmp", "version", cfg.EventSNMP.Version, "must be 2c or 3")
+	}
+	switch strings.ToLower(cfg.EventSNMP.AuthProtocol) {
+	case "", "md5", "sha", "sha256":
+	default:
+		invalid("event_snmp", "auth_protocol", cfg.EventSNMP.AuthProtocol, "must be md5, sha or sha256")
+	}
+	switch strings.ToLower(cfg.EventSNMP.PrivProtocol) {
+	case "", "aes":
+	default:
+		invalid("event_snmp", "priv_protocol", cfg.EventSNMP.PrivProtocol, "must be aes")
+	}
+	minSeverity("event_snmp", cfg.EventSNMP.MinSeverity)
+
+	if raw := cfg.Export.RemoteWrite.URL; raw != "" {
+		u, err := url.Parse(raw)
+		if err != nil || u.Hostname() == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			invalid("export.remote_write", "url", raw, "must be an http(s):// URL")
+		}
+	}
+	if ic := cfg.Export.InfluxDB; ic.URL != "" {
+		u, err := url.Parse(ic.URL)
+		if err != nil || u.Hostname() == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			invalid("export.influxdb", "url", ic.URL, "must be an http(s):// URL")
+		}
+		switch {
+		case ic.Bucket == "" && ic.Database == "":
+			invalid("export.influxdb", "bucket", ic.Bucket, "must be set (InfluxDB 2.x), or database (InfluxDB 1.x)")
+		case ic.Bucket != "" && ic.Org == "":
+			invalid("export.influxdb", "org", ic.Org, "must be set with bucket")
+		}
+	}
+	if gc := cfg.Export.Graphite; gc.Target != "" {
+		u, err := url.Parse(gc.Target)
+		if err != nil || u.Hostname() == "" || u.Scheme != "tcp" {
+			invalid("export.graphite", "target", gc.Target, "must be tcp://host[:port]")
+		}
+		switch gc.Format {
+		case "", "plaintext", "pickle":
+		default:
+			invalid("export.graphite", "format", gc.Format, "must be plaintext or pickle")
+		}
+		if strings.ContainsAny(gc.Path, " \t\n") {
+			invalid("export.graphite", "path", gc.Path, "must not contain spaces")
+		}
+	}
+	if oc := cfg.Export.OTLP; oc.Endpoint != "" {
+		u, err := url.Parse(oc.Endpoint)
+		if err != nil || u.Hostname() == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			invalid("export.otlp", "endpoint", oc.Endpoint, "must be an http(s):// URL")
+		}
+		switch oc.Protocol {
+		case "", "http", "grpc":
+		default:
+			invalid("export.otlp", "protocol", oc.Protocol, "must be http or grpc")
+		}
+	}
+	if sc := cfg.Export.StatsD; sc.Target != "" {
+		u, err := url.Parse(sc.Target)
+		if err != nil || u.Hostname() == "" || u.Scheme != "udp" {
+			invalid("export.statsd", "target", sc.Target, "must be udp://host[:port]")
+		}
+		if len(sc.Metrics) == 0 {
+			invalid("export.statsd", "metrics", sc.Metrics, "must list the host/service/metric globs of the metrics to emit")
+		}
+		for _, pattern := range sc.Metrics {
+			if strings.Count(pattern, "/") != 2 {
+				invalid("export.statsd", "metrics", pattern, "must be host/service/metric globs, e.g. \"web*/*/cpu.*\"")
+			}
+		}
+	}
+
+	if mc := cfg.Export.MQTT; mc.URL != "" {
+		u, err := url.Parse(mc.URL)
+		if err != nil || u.Hostname() == "" || (u.Scheme != "mqtt" && u.Scheme != "mqtts") {
+			invalid("export.mqtt", "url", mc.URL, "must be mqtt:// or mqtts://host[:port]")
+		}
+		for _, pattern := range mc.Metrics {
+			if strings.Count(pattern, "/") != 2 {
+				invalid("export.mqtt", "metrics", pattern, "must be host/service/metric globs, e.g. \"*/*/cpu.user\"")
+			}
+		}
+	}
+
+	if ec := cfg.Export.Elasticsearch; ec.URL != "" {
+		u, err := url.Parse(ec.URL)
+		if err != nil || u.Hostname() == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			invalid("export.elasticsearch", "url", ec.URL, "must be an http(s):// URL")
+		}
+	}
+	minSeverity("export.elasticsearch", cfg.Export.Elasticsearch.MinSeverity)
+
+	if c := cfg.Flapping.Changes; c < 0 || c == 1 || c == 2 {
+		invalid("flapping", "changes", c, "must be a number of changes of at least 3, or 0 to disable")
+	}
+	if raw := cfg.EventGrafana.URL; raw != "" {
+		u, err := url.Parse(raw)
+		if err != nil || u.Hostname() == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			invalid("event_grafana", "url", raw, "must be an http(s):// URL")
+		}
+	}
+	for _, kind := range cfg.EventGrafana.Events {
+		if kind != "failure" && kind != "recovery" && kind != "reboot" {
+			invalid("event_grafana", "events", kind, "must be failure, recovery or reboot")
+		}
+	}
+	minSeverity("event_grafana", cfg.EventGrafana.MinSeverity)
+
+	if raw := cfg.Heartbeat.URL; raw != "" {
+		u, err := url.Parse(raw)
+		if err != nil || u.Hostname() == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			invalid("heartbeat", "url", raw, "must be an http(s):// URL")
+		}
+	}
+	if cfg.Heartbeat.Interval != "" {
+		duration("heartbeat", "interval", cfg.Heartbeat.Interval, "1m", false)
+	}
+	for hostname, raw := range cfg.Heartbeat.Hosts {
+		u, err := url.Parse(raw)
+		if err != nil || u.Hostname() == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			invalid("heartbeat.hosts", hostname, raw, "must be an http(s):// URL")
+		}
+	}
+
+	if cfg.Flapping.Window != "" {
+		duration("flapping", "window", cfg.Flapping.Window, "10m", false)
+	}
+
+	return problems
+}
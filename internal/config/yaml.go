@@ -0,0 +1,82 @@
+package config
+
+import (
+	"os"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// decodeYAML decodes a YAML configuration file into cfg. It has the same
+// sections and keys as the TOML format, with [[role]] tables as a "role"
+// list:
+//
+//	network:
+//	  listen: "0.0.0.0:3000"
+//	web:
+//	  user: admin
+//	role:
+//	  - name: lab-operators
+//	    hostgroups: [lab]
+func decodeYAML(path string, cfg *Config) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.recordYAMLOrigins(path, data); err != nil {
+		return nil, err
+	}
+
+	// Decode again without the struct to find the keys matching no setting,
+	// like toml.MetaData.Undecoded() for TOML files
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	return unknownYAMLKeys(reflect.TypeOf(*cfg), raw, ""), nil
+}
+
+// unknownYAMLKeys returns the dotted keys of raw matching no yaml tag of
+// the struct type t, sorted.
+func unknownYAMLKeys(t reflect.Type, raw map[string]interface{}, prefix string) []string {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		tag, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		if tag != "" && tag != "-" {
+			fields[tag] = t.Field(i).Type
+		}
+	}
+
+	var unknown []string
+	for key, value := range raw {
+		name := prefix + key
+		ft, ok := fields[key]
+		if !ok {
+			unknown = append(unknown, name)
+			continue
+		}
+		switch {
+		case ft.Kind() == reflect.Struct:
+			if m, ok := value.(map[string]interface{}); ok {
+				unknown = append(unknown, unknownYAMLKeys(ft, m, name+".")...)
+			}
+		case ft.Kind() == reflect.Slice && ft.Elem().Kind() == reflect.Struct:
+			list, _ := value.([]interface{})
+			for _, item := range list {
+				if m, ok := item.(map[string]interface{}); ok {
+					unknown = append(unknown, unknownYAMLKeys(ft.Elem(), m, name+".")...)
+				}
+			}
+		}
+	}
+
+	sort.Strings(unknown)
+	return slices.Compact(unknown) // Role keys repeat
+}
@@ -9,17 +9,37 @@
 // - Actions: start, stop, restart, monitor, unmonitor
 // - Security: Requires CSRF token (double-submit cookie pattern)
 // - Auth: HTTP Basic Authentication
+// - Status: /_status?format=xml (see GetServiceState)
 package control
 
 import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"os"
 	"regexp"
 	"strings"
 )
 
+// Actions lists the service actions supported by Monit.
+var Actions = []string{"start", "stop", "restart", "monitor", "unmonitor"}
+
+// ValidAction reports whether action is one of Actions.
+func ValidAction(action string) bool {
+	for _, a := range Actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
 // MonitClient represents a connection to a Monit agent.
 //
 // Each Monit agent has its own HTTP server (usually on port 2812) that
@@ -38,11 +58,17 @@ type MonitClient struct {
 	Password string
 
 	// BaseURL is the complete URL to the Monit HTTP server
-	// Example: http://192.168.1.10:2812
+	// Example: http://192.168.1.10:2812 (https:// after EnableTLS)
 	BaseURL string
 
 	// HTTP client with custom settings (timeouts, etc.)
 	httpClient *http.Client
+
+	// transport of httpClient, holding the connect timeout and TLS settings
+	transport *http.Transport
+
+	// options are the timeouts and retry policy (see SetOptions)
+	options ClientOptions
 }
 
 // NewMonitClient creates a new Monit client.
@@ -53,79 +79,119 @@ type MonitClient struct {
 //   - username: HTTP Basic Auth username (usually "admin")
 //   - password: HTTP Basic Auth password
 //
-// Returns a configured MonitClient ready to use.
+// Returns a configured MonitClient ready to use, with
+// DefaultClientOptions (see SetOptions).
 func NewMonitClient(host string, port int, username, password string) *MonitClient {
-	return &MonitClient{
-		Host:     host,
-		Port:     port,
-		Username: username,
-		Password: password,
-		BaseURL:  fmt.Sprintf("http://%s:%d", host, port),
-		httpClient: &http.Client{
-			// 10 second timeout for requests
-			// Monit actions are usually fast, but we allow some buffer
-			Timeout: 10 * 1000000000, // 10 seconds in nanoseconds
-		},
+	mc := &MonitClient{
+		Host:      host,
+		Port:      port,
+		Username:  username,
+		Password:  password,
+		BaseURL:   fmt.Sprintf("http://%s:%d", host, port),
+		transport: http.DefaultTransport.(*http.Transport).Clone(),
 	}
+	mc.httpClient = &http.Client{Transport: mc.transport}
+	mc.SetOptions(DefaultClientOptions)
+	return mc
 }
 
-// getCSRFToken fetches the CSRF security token from a service page.
-//
-// Monit uses CSRF protection (double-submit cookie) for all POST requests.
-// The token is embedded in the HTML forms as a hidden field.
-//
-// How it works:
-// 1. GET /servicename to retrieve the service's HTML page
-// 2. Parse the HTML to extract the security token from hidden fields
-// 3. Return the token for use in subsequent POST requests
-//
-// The token format in HTML:
-//   <input type=hidden name='securitytoken' value='TOKEN_HERE'>
-//
-// Returns:
-//   - token: The CSRF security token string
-//   - error: nil on success, error description if failed
-func (mc *MonitClient) getCSRFToken(serviceName string) (string, error) {
-	// Build the service URL (e.g., http://host:2812/nginx)
-	serviceURL := fmt.Sprintf("%s/%s", mc.BaseURL, url.PathEscape(serviceName))
+// TLSOptions controls how the Monit agent's HTTPS certificate is verified.
+type TLSOptions struct {
+	// CAFile is a PEM CA bundle verifying the agent certificate
+	// Empty uses the system roots
+	CAFile string
 
-	// Create HTTP GET request
-	req, err := http.NewRequest("GET", serviceURL, nil)
+	// InsecureSkipVerify accepts any certificate (self-signed agents)
+	InsecureSkipVerify bool
+}
+
+// EnableTLS switches the client to HTTPS, for agents with "with ssl" in
+// their Monit "set httpd" statement.
+func (mc *MonitClient) EnableTLS(opts TLSOptions) error {
+	tlsConfig, err := newTLSConfig(opts)
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+		return err
 	}
+	mc.transport.TLSClientConfig = tlsConfig
+	mc.BaseURL = fmt.Sprintf("https://%s:%d", mc.Host, mc.Port)
+	return nil
+}
 
-	// Add HTTP Basic Authentication credentials
-	// Monit requires authentication for all pages
-	req.SetBasicAuth(mc.Username, mc.Password)
+// newTLSConfig returns the TLS configuration verifying agents as set by
+// opts.
+func newTLSConfig(opts TLSOptions) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: opts.InsecureSkipVerify,
+	}
+	if opts.CAFile != "" {
+		pool, err := LoadCAFile(opts.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
 
-	// Execute the request
-	resp, err := mc.httpClient.Do(req)
+// LoadCAFile reads a PEM CA bundle.
+func LoadCAFile(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
 	if err != nil {
-		return "", fmt.Errorf("failed to fetch service page: %w", err)
+		return nil, fmt.Errorf("failed to read CA file: %w", err)
 	}
-	defer resp.Body.Close()
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in CA file %s", path)
+	}
+	return pool, nil
+}
+
+// newSecurityToken returns a random CSRF security token.
+//
+// Monit protects POST requests with a double-submit cookie: the
+// securitytoken form field must match the securitytoken cookie. Like the
+// monit command line client, cmonit makes up the token and sends it in
+// both, so no page has to be fetched first.
+func newSecurityToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate security token: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
 
-	// Check HTTP status code
-	// 200 OK: Success
-	// 401 Unauthorized: Bad credentials
+// pageCSRFToken fetches the CSRF security token from the form of a Monit
+// page, the fallback of postForm for agents rejecting a made-up token.
+//
+// The token format in HTML:
+//   <input type=hidden name='securitytoken' value='TOKEN_HERE'>
+func (mc *MonitClient) pageCSRFToken(pageURL string) (string, error) {
+	// Execute the GET request, retried on network failures
+	// Any status but 200 OK fails:
+	// 401 Unauthorized: Bad credentials (AuthError)
 	// 404 Not Found: Service doesn't exist
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	resp, err := mc.do("fetch page", true, func() (*http.Request, error) {
+		req, err := http.NewRequest("GET", pageURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		// Monit requires authentication for all pages
+		req.SetBasicAuth(mc.Username, mc.Password)
+		return req, nil
+	})
+	if err != nil {
+		return "", err
 	}
+	defer resp.Body.Close()
 
-	// Read the HTML response body
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return "", fmt.Errorf("failed to read response body: %w", err)
 	}
 
-	// Extract the CSRF token using regex
 	// Pattern matches: <input type=hidden name='securitytoken' value='TOKEN'>
 	// We use a regex to handle variations in quotes and spacing
-	tokenPattern := regexp.MustCompile(`name=['"]securitytoken['"].*?value=['"]([^'"]+)['"]`)
-	matches := tokenPattern.FindStringSubmatch(string(body))
-
+	matches := csrfTokenPattern.FindStringSubmatch(string(body))
 	if len(matches) < 2 {
 		return "", fmt.Errorf("CSRF token not found in response")
 	}
@@ -134,23 +200,91 @@ func (mc *MonitClient) getCSRFToken(serviceName string) (string, error) {
 	return matches[1], nil
 }
 
+// csrfTokenPattern finds the security token in the forms of Monit pages.
+var csrfTokenPattern = regexp.MustCompile(`name=['"]securitytoken['"].*?value=['"]([^'"]+)['"]`)
+
+// postForm sends a form to a Monit page with a made-up security token. If
+// the agent rejects it (403 Forbidden), the token of tokenPage's form is
+// fetched and the form sent again with it.
+//
+// It is only retried if the connection failed, so that an action is never
+// sent twice. Any status but 200 OK fails:
+// 403 Forbidden: CSRF token validation failed
+// 400 Bad Request: Invalid action
+// 404 Not Found: Service doesn't exist
+func (mc *MonitClient) postForm(op, pageURL, tokenPage string, form url.Values) error {
+	token, err := newSecurityToken()
+	if err != nil {
+		return err
+	}
+	err = mc.postFormWithToken(op, pageURL, form, token)
+
+	var e *Error
+	if errors.As(err, &e) && e.StatusCode == http.StatusForbidden {
+		scraped, scrapeErr := mc.pageCSRFToken(tokenPage)
+		if scrapeErr != nil {
+			// Not a token problem after all: report the rejection
+			return err
+		}
+		err = mc.postFormWithToken(op, pageURL, form, scraped)
+	}
+	return err
+}
+
+// postFormWithToken sends a form with token in the form and the cookie.
+func (mc *MonitClient) postFormWithToken(op, pageURL string, form url.Values, token string) error {
+	// Build POST body: action=ACTIONNAME&securitytoken=TOKEN
+	// We use url.Values to properly encode the form data
+	body := url.Values{}
+	for k, v := range form {
+		body[k] = v
+	}
+	body.Set("securitytoken", token)
+
+	resp, err := mc.do(op, false, func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", pageURL, strings.NewReader(body.Encode()))
+		if err != nil {
+			return nil, err
+		}
+
+		// Set required headers
+		req.SetBasicAuth(mc.Username, mc.Password)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		// IMPORTANT: Set the CSRF token cookie
+		// Monit's double-submit cookie pattern requires the token in both:
+		// 1. POST body (done above)
+		// 2. Cookie header (done here)
+		req.Header.Set("Cookie", fmt.Sprintf("securitytoken=%s", token))
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
 // ExecuteAction performs an action on a Monit service.
 //
 // This is the main function for controlling Monit services remotely.
-// It handles the complete workflow including CSRF token retrieval.
 //
 // Parameters:
 //   - serviceName: Name of the service (as configured in monitrc)
 //   - action: Action to perform (start, stop, restart, monitor, unmonitor)
 //
 // Workflow:
-// 1. GET the service page to obtain CSRF token
-// 2. POST to the service URL with action and token
+// 1. POST to the service URL with the action and a security token
+// 2. If the agent rejects the token, fetch the one of the service page and
+//    POST again (see postForm)
 // 3. Monit schedules the action for next monitoring cycle
 // 4. Return success/failure
 //
+// Use GetServiceState to follow the result.
+//
 // Returns:
-//   - error: nil if successful, error description if failed
+//   - error: nil if successful, error description if failed (*Error for
+//     agent failures, see KindOf)
 //
 // Example usage:
 //   client := NewMonitClient("192.168.1.10", 2812, "admin", "monit")
@@ -161,108 +295,17 @@ func (mc *MonitClient) getCSRFToken(serviceName string) (string, error) {
 func (mc *MonitClient) ExecuteAction(serviceName, action string) error {
 	// Validate action parameter
 	// Only these actions are supported by Monit
-	validActions := map[string]bool{
-		"start":     true,
-		"stop":      true,
-		"restart":   true,
-		"monitor":   true,
-		"unmonitor": true,
-	}
-
-	if !validActions[action] {
-		return fmt.Errorf("invalid action '%s', must be one of: start, stop, restart, monitor, unmonitor", action)
-	}
-
-	// Step 1: Get CSRF token from the service page
-	token, err := mc.getCSRFToken(serviceName)
-	if err != nil {
-		return fmt.Errorf("failed to get CSRF token: %w", err)
+	if !ValidAction(action) {
+		return fmt.Errorf("invalid action '%s', must be one of: %s", action, strings.Join(Actions, ", "))
 	}
 
-	// Step 2: Prepare the POST request
+	// Build the service URL (e.g., http://host:2812/nginx)
 	serviceURL := fmt.Sprintf("%s/%s", mc.BaseURL, url.PathEscape(serviceName))
 
-	// Build POST body: action=ACTIONNAME&securitytoken=TOKEN
-	// We use url.Values to properly encode the form data
 	formData := url.Values{}
 	formData.Set("action", action)
-	formData.Set("securitytoken", token)
-
-	// Create HTTP POST request
-	req, err := http.NewRequest("POST", serviceURL, strings.NewReader(formData.Encode()))
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
-	}
-
-	// Set required headers
-	req.SetBasicAuth(mc.Username, mc.Password)
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-	// IMPORTANT: Set the CSRF token cookie
-	// Monit's double-submit cookie pattern requires the token in both:
-	// 1. POST body (done above)
-	// 2. Cookie header (done here)
-	req.Header.Set("Cookie", fmt.Sprintf("securitytoken=%s", token))
-
-	// Step 3: Execute the action request
-	resp, err := mc.httpClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to execute action: %w", err)
-	}
-	defer resp.Body.Close()
-
-	// Check response status
-	// 200 OK: Action accepted
-	// 403 Forbidden: CSRF token validation failed
-	// 400 Bad Request: Invalid action or service
-	if resp.StatusCode != http.StatusOK {
-		// Read error message from response body
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("action failed with status %d: %s", resp.StatusCode, string(body))
-	}
 
 	// Success! The action has been scheduled by Monit
 	// Note: The action happens asynchronously in Monit's next monitoring cycle
-	return nil
-}
-
-// GetServiceStatus retrieves the status of a service from Monit.
-//
-// This can be used to verify that an action completed successfully.
-//
-// Parameters:
-//   - serviceName: Name of the service
-//
-// Returns:
-//   - status: HTML snippet with service status
-//   - error: nil on success, error if failed
-//
-// Note: This is a simple implementation that returns raw HTML.
-// For structured data, use the XML status API instead.
-func (mc *MonitClient) GetServiceStatus(serviceName string) (string, error) {
-	serviceURL := fmt.Sprintf("%s/%s", mc.BaseURL, url.PathEscape(serviceName))
-
-	req, err := http.NewRequest("GET", serviceURL, nil)
-	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
-	}
-
-	req.SetBasicAuth(mc.Username, mc.Password)
-
-	resp, err := mc.httpClient.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("failed to fetch status: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to read response: %w", err)
-	}
-
-	return string(body), nil
+	return mc.postForm("execute action", serviceURL, serviceURL, formData)
 }
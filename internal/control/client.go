@@ -0,0 +1,181 @@
+package control
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// ClientOptions are the timeouts and retry policy of a MonitClient.
+type ClientOptions struct {
+	// ConnectTimeout bounds the TCP connection and TLS handshake
+	ConnectTimeout time.Duration
+
+	// Timeout bounds a whole request, including reading the response
+	Timeout time.Duration
+
+	// Attempts is the number of tries of a request (1 = no retry)
+	Attempts int
+
+	// RetryBackoff is the wait before the first retry, doubled after each
+	RetryBackoff time.Duration
+}
+
+// DefaultClientOptions are used by NewMonitClient.
+var DefaultClientOptions = ClientOptions{
+	ConnectTimeout: 5 * time.Second,
+	Timeout:        10 * time.Second,
+	Attempts:       3,
+	RetryBackoff:   time.Second,
+}
+
+// Validate checks that the options are usable.
+func (o ClientOptions) Validate() error {
+	if o.ConnectTimeout <= 0 {
+		return fmt.Errorf("connect timeout must be positive")
+	}
+	if o.Timeout <= 0 {
+		return fmt.Errorf("timeout must be positive")
+	}
+	if o.Attempts < 1 || o.Attempts > 10 {
+		return fmt.Errorf("attempts must be between 1 and 10")
+	}
+	if o.RetryBackoff < 0 {
+		return fmt.Errorf("retry backoff must not be negative")
+	}
+	return nil
+}
+
+// SetOptions changes the timeouts and retry policy of the client.
+func (mc *MonitClient) SetOptions(opts ClientOptions) {
+	mc.options = opts
+	mc.transport.DialContext = (&net.Dialer{
+		Timeout:   opts.ConnectTimeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	mc.transport.TLSHandshakeTimeout = opts.ConnectTimeout
+	mc.httpClient.Timeout = opts.Timeout
+}
+
+// ErrorKind classifies the failures of a MonitClient.
+type ErrorKind int
+
+const (
+	// NetworkError: the agent could not be reached or did not answer in
+	// time (connection refused, timeout, TLS failure)
+	NetworkError ErrorKind = iota + 1
+
+	// AuthError: the agent rejected the host's Monit credentials
+	AuthError
+
+	// AgentError: the agent answered with an unexpected response
+	AgentError
+)
+
+// String returns the name of the kind, as used in messages.
+func (k ErrorKind) String() string {
+	switch k {
+	case NetworkError:
+		return "network error"
+	case AuthError:
+		return "authentication failed"
+	case AgentError:
+		return "agent error"
+	}
+	return "unknown error"
+}
+
+// Error is the error returned by the requests of a MonitClient. Use
+// KindOf, or errors.As, to tell authentication failures from network
+// failures.
+type Error struct {
+	Kind       ErrorKind
+	Op         string // What was being done, e.g. "fetch service page"
+	StatusCode int    // HTTP status of the agent's response, 0 if none
+	Err        error  // Underlying error, nil for an unexpected status
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("%s: %s", e.Op, e.Kind)
+	if e.StatusCode != 0 {
+		msg += fmt.Sprintf(" (status %d)", e.StatusCode)
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// KindOf returns the kind of a MonitClient error, or 0 if err is not one.
+func KindOf(err error) ErrorKind {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	return 0
+}
+
+// do sends the request built by newReq and returns a response with status
+// 200 OK, retrying with backoff on network failures and on agent errors
+// (502, 503, 504). With idempotent false (service actions), only failed
+// connections are retried, as the agent may have received the request.
+func (mc *MonitClient) do(op string, idempotent bool, newReq func() (*http.Request, error)) (*http.Response, error) {
+	backoff := mc.options.RetryBackoff
+	var lastErr error
+	for attempt := 1; ; attempt++ {
+		req, err := newReq()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+
+		retry := false
+		resp, err := mc.httpClient.Do(req)
+		if err != nil {
+			lastErr = &Error{Kind: NetworkError, Op: op, Err: err}
+			retry = (idempotent || isDialError(err)) && !isCertificateError(err)
+		} else if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			lastErr = &Error{Kind: statusKind(resp.StatusCode, idempotent), Op: op, StatusCode: resp.StatusCode}
+			retry = idempotent && resp.StatusCode >= http.StatusBadGateway && resp.StatusCode <= http.StatusGatewayTimeout
+		} else {
+			return resp, nil
+		}
+
+		if !retry || attempt >= mc.options.Attempts {
+			return nil, lastErr
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// statusKind classifies an unexpected HTTP status. Monit answers 401 to bad
+// credentials and 403 to addresses not allowed by "set httpd"; a 403 to a
+// service action is a rejected CSRF token instead.
+func statusKind(status int, idempotent bool) ErrorKind {
+	if status == http.StatusUnauthorized || (status == http.StatusForbidden && idempotent) {
+		return AuthError
+	}
+	return AgentError
+}
+
+// isCertificateError reports whether err is a rejected agent certificate,
+// which retrying does not fix.
+func isCertificateError(err error) bool {
+	var certErr *tls.CertificateVerificationError
+	return errors.As(err, &certErr)
+}
+
+// isDialError reports whether err happened while connecting, before
+// anything was sent to the agent.
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
@@ -0,0 +1,56 @@
+package control
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// DaemonValidate is the daemon action asking the agent to check all its
+// services now instead of at its next cycle ("monit validate").
+const DaemonValidate = "validate"
+
+// DaemonActions lists the agent-wide actions: DaemonValidate and the
+// service Actions applied to every service ("monit restart all").
+//
+// Monit's HTTP interface does not offer "reload": it is a signal sent to the
+// daemon by "monit reload" on the host itself.
+var DaemonActions = append([]string{DaemonValidate}, Actions...)
+
+// ValidDaemonAction reports whether action is one of DaemonActions.
+func ValidDaemonAction(action string) bool {
+	for _, a := range DaemonActions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// ExecuteDaemonAction performs an agent-wide action. DaemonValidate is sent
+// to the runtime page (/_runtime); service actions are sent to /_doaction
+// with every name of services in a single request, as "monit <action> all"
+// does.
+//
+// Like ExecuteAction, it is only retried if the connection failed.
+func (mc *MonitClient) ExecuteDaemonAction(action string, services []string) error {
+	if !ValidDaemonAction(action) {
+		return fmt.Errorf("invalid action '%s', must be one of: %s", action, strings.Join(DaemonActions, ", "))
+	}
+
+	formData := url.Values{}
+	formData.Set("action", action)
+	page := "_runtime"
+	if action != DaemonValidate {
+		if len(services) == 0 {
+			return fmt.Errorf("no service to %s", action)
+		}
+		page = "_doaction"
+		for _, service := range services {
+			formData.Add("service", service)
+		}
+	}
+
+	// The runtime page carries a token in its forms, for the fallback
+	return mc.postForm("execute daemon action", mc.BaseURL+"/"+page, mc.BaseURL+"/_runtime", formData)
+}
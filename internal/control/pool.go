@@ -0,0 +1,156 @@
+package control
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Connection limits of the transports shared by pooled clients. Monit's
+// HTTP server handles few connections at a time, so bulk actions and
+// verification polling must not open many to the same agent.
+const (
+	agentMaxConns     = 4                // Connections per agent
+	agentMaxIdleConns = 2                // Idle connections kept per agent
+	agentIdleTimeout  = 90 * time.Second // Closing idle connections after
+)
+
+// Endpoint is where and how to reach a Monit agent.
+type Endpoint struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	SSL      bool       // "set httpd ... with ssl"
+	TLS      TLSOptions // Certificate verification, with SSL
+}
+
+// Pool caches a MonitClient per host. Clients share an http.Transport per
+// TLS setting, so connections to agents are kept alive between requests and
+// limited per agent instead of opened for each action.
+//
+// Pooled clients are safe for concurrent use and must not be changed with
+// SetOptions or EnableTLS.
+type Pool struct {
+	mu         sync.Mutex
+	options    ClientOptions
+	transports map[transportKey]*http.Transport
+	clients    map[string]*pooledClient
+}
+
+// transportKey identifies a shared transport. The CA file modification
+// time makes a changed CA bundle load again.
+type transportKey struct {
+	ssl     bool
+	tls     TLSOptions
+	caMtime time.Time
+}
+
+type pooledClient struct {
+	endpoint Endpoint
+	key      transportKey
+	client   *MonitClient
+}
+
+// NewPool creates an empty client pool using opts.
+func NewPool(opts ClientOptions) *Pool {
+	return &Pool{
+		options:    opts,
+		transports: make(map[transportKey]*http.Transport),
+		clients:    make(map[string]*pooledClient),
+	}
+}
+
+// SetOptions changes the timeouts and retry policy of the pool. Cached
+// clients and their connections are dropped.
+func (p *Pool) SetOptions(opts ClientOptions) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.options = opts
+	for key, t := range p.transports {
+		t.CloseIdleConnections()
+		delete(p.transports, key)
+	}
+	p.clients = make(map[string]*pooledClient)
+}
+
+// Client returns the client of host id, created or replaced when the
+// endpoint changed since the last call.
+func (p *Pool) Client(id string, ep Endpoint) (*MonitClient, error) {
+	key := transportKey{ssl: ep.SSL}
+	if ep.SSL {
+		key.tls = ep.TLS
+		if ep.TLS.CAFile != "" {
+			fi, err := os.Stat(ep.TLS.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read CA file: %w", err)
+			}
+			key.caMtime = fi.ModTime()
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if pc, ok := p.clients[id]; ok && pc.endpoint == ep && pc.key == key {
+		return pc.client, nil
+	}
+
+	t, ok := p.transports[key]
+	if !ok {
+		var err error
+		if t, err = p.newTransport(key); err != nil {
+			return nil, err
+		}
+		p.transports[key] = t
+	}
+
+	scheme := "http"
+	if ep.SSL {
+		scheme = "https"
+	}
+	mc := &MonitClient{
+		Host:       ep.Host,
+		Port:       ep.Port,
+		Username:   ep.Username,
+		Password:   ep.Password,
+		BaseURL:    fmt.Sprintf("%s://%s:%d", scheme, ep.Host, ep.Port),
+		httpClient: &http.Client{Transport: t, Timeout: p.options.Timeout},
+		transport:  t,
+		options:    p.options,
+	}
+	p.clients[id] = &pooledClient{endpoint: ep, key: key, client: mc}
+	return mc, nil
+}
+
+// Remove drops the client of host id, e.g. when the host is deleted.
+func (p *Pool) Remove(id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.clients, id)
+}
+
+// newTransport creates a transport shared by the clients of key, with the
+// pool's connect timeout and the agent connection limits.
+func (p *Pool) newTransport(key transportKey) (*http.Transport, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialContext = (&net.Dialer{
+		Timeout:   p.options.ConnectTimeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	t.TLSHandshakeTimeout = p.options.ConnectTimeout
+	t.MaxConnsPerHost = agentMaxConns
+	t.MaxIdleConnsPerHost = agentMaxIdleConns
+	t.IdleConnTimeout = agentIdleTimeout
+	if key.ssl {
+		tlsConfig, err := newTLSConfig(key.tls)
+		if err != nil {
+			return nil, err
+		}
+		t.TLSClientConfig = tlsConfig
+	}
+	return t, nil
+}
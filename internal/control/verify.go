@@ -0,0 +1,108 @@
+package control
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/ocochard/cmonit/internal/parser"
+)
+
+// GetServiceState fetches the structured state of a service from the
+// agent's XML status page (/_status?format=xml). It fails if the agent
+// does not report the service.
+func (mc *MonitClient) GetServiceState(serviceName string) (*parser.Service, error) {
+	resp, err := mc.do("fetch status", true, func() (*http.Request, error) {
+		req, err := http.NewRequest("GET", mc.BaseURL+"/_status?format=xml&level=full", nil)
+		if err != nil {
+			return nil, err
+		}
+		req.SetBasicAuth(mc.Username, mc.Password)
+		return req, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	status, err := parser.ParseMonitXML(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse status: %w", err)
+	}
+	for i := range status.Services {
+		if status.Services[i].Name == serviceName {
+			return &status.Services[i], nil
+		}
+	}
+	return nil, fmt.Errorf("service %s not reported by the agent", serviceName)
+}
+
+// ActionCompleted reports whether a service reached the state expected
+// after action. before is the state when the action was sent (nil if
+// unknown) and elapsed the time since.
+//
+// Monit queues actions (pendingaction) and runs them on its next cycle:
+//   - start, monitor: monitored again with nothing pending; start also
+//     needs the service OK and, for a process, a PID
+//   - restart: as start, with a new process (other PID, or an uptime
+//     shorter than elapsed)
+//   - stop, unmonitor: no longer monitored
+func ActionCompleted(action string, before, after *parser.Service, elapsed time.Duration) bool {
+	if after.PendingAction != 0 {
+		return false
+	}
+
+	switch action {
+	case "stop", "unmonitor":
+		return after.Monitor == 0
+
+	case "monitor":
+		return after.Monitor == 1
+
+	case "start", "restart":
+		if after.Monitor != 1 || after.Status != 0 {
+			return false
+		}
+		if after.Type != 3 {
+			return true
+		}
+		if after.PID == nil || *after.PID <= 0 {
+			return false
+		}
+		if action == "start" {
+			return true
+		}
+		if before != nil && before.PID != nil && *before.PID != *after.PID {
+			return true
+		}
+		return after.Uptime != nil && time.Duration(*after.Uptime)*time.Second < elapsed
+	}
+	return false
+}
+
+// DescribeState summarizes a service state for messages, e.g.
+// "status: OK, monitored, pid 1234".
+func DescribeState(s *parser.Service) string {
+	desc := "status: " + s.StatusMessage()
+	switch s.Monitor {
+	case 0:
+		desc += ", not monitored"
+	case 1:
+		desc += ", monitored"
+	default:
+		desc += ", initializing"
+	}
+	if s.PendingAction != 0 {
+		desc += ", action pending"
+	}
+	if s.PID != nil && *s.PID > 0 {
+		desc += fmt.Sprintf(", pid %d", *s.PID)
+	}
+	return desc
+}
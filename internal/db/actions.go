@@ -0,0 +1,132 @@
+// Package db - actions.go contains the history of service actions.
+//
+// Every start, stop, restart, monitor or unmonitor sent to a Monit agent is
+// recorded in the actions table with who requested it, the outcome and the
+// agent's latency. Accepted actions are then verified: the verification
+// status tells whether the service reached the expected state. The history
+// is kept for the host detail page and is not pruned by the metrics
+// retention.
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Action verification statuses
+const (
+	VerifyPending   = "pending"   // Polling the agent
+	VerifyConfirmed = "confirmed" // The service reached the expected state
+	VerifyTimeout   = "timeout"   // Not reached in time
+)
+
+// ActionRecord is one service action sent to a Monit agent.
+type ActionRecord struct {
+	ID          int64     `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	HostID      string    `json:"host_id"`
+	Hostname    string    `json:"hostname"`
+	Service     string    `json:"service"`
+	Action      string    `json:"action"`
+	RequestedBy string    `json:"requested_by"` // Web user, "token:<name>" or "anonymous"
+	SourceIP    string    `json:"source_ip"`
+	Success     bool      `json:"success"`
+	Message     string    `json:"message"` // Error message, empty on success
+	LatencyMS   int64     `json:"latency_ms"`
+
+	VerifyStatus  string     `json:"verify_status"`  // One of the Verify* constants, "" = not verified
+	VerifyMessage string     `json:"verify_message"` // Last observed state or polling error
+	VerifiedAt    *time.Time `json:"verified_at,omitempty"`
+}
+
+// actionColumns are the columns scanned by scanAction.
+const actionColumns = `id, created_at, host_id, hostname, service, action,
+	requested_by, source_ip, success, message, latency_ms,
+	verify_status, verify_message, verified_at`
+
+// scanAction scans a row of actionColumns.
+func scanAction(row interface{ Scan(...interface{}) error }) (ActionRecord, error) {
+	var a ActionRecord
+	var verifiedAt sql.NullTime
+	err := row.Scan(&a.ID, &a.CreatedAt, &a.HostID, &a.Hostname, &a.Service, &a.Action,
+		&a.RequestedBy, &a.SourceIP, &a.Success, &a.Message, &a.LatencyMS,
+		&a.VerifyStatus, &a.VerifyMessage, &verifiedAt)
+	if verifiedAt.Valid {
+		a.VerifiedAt = &verifiedAt.Time
+	}
+	return a, err
+}
+
+// RecordAction appends a service action to the history and returns its
+// ID. CreatedAt defaults to now.
+func RecordAction(db *sql.DB, a ActionRecord) (int64, error) {
+	if a.CreatedAt.IsZero() {
+		a.CreatedAt = time.Now()
+	}
+	result, err := db.Exec(`
+		INSERT INTO actions (created_at, host_id, hostname, service, action,
+		                     requested_by, source_ip, success, message, latency_ms, verify_status)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, a.CreatedAt.UTC(), a.HostID, a.Hostname, a.Service, a.Action,
+		a.RequestedBy, a.SourceIP, a.Success, a.Message, a.LatencyMS, a.VerifyStatus)
+	if err != nil {
+		return 0, fmt.Errorf("failed to record action: %w", err)
+	}
+	return result.LastInsertId()
+}
+
+// SetActionVerification stores the verification status of an action.
+// Final statuses also set verified_at.
+func SetActionVerification(db *sql.DB, id int64, status, message string) error {
+	var verifiedAt interface{}
+	if status != VerifyPending {
+		verifiedAt = time.Now().UTC()
+	}
+	_, err := db.Exec(`
+		UPDATE actions SET verify_status = ?, verify_message = ?, verified_at = ?
+		WHERE id = ?
+	`, status, message, verifiedAt, id)
+	if err != nil {
+		return fmt.Errorf("failed to update action %d: %w", id, err)
+	}
+	return nil
+}
+
+// GetAction returns an action by ID, or nil if there is none.
+func GetAction(db *sql.DB, id int64) (*ActionRecord, error) {
+	a, err := scanAction(db.QueryRow("SELECT "+actionColumns+" FROM actions WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get action %d: %w", id, err)
+	}
+	return &a, nil
+}
+
+// RecentActions returns the last limit actions sent to a host, newest
+// first.
+func RecentActions(db *sql.DB, hostID string, limit int) ([]ActionRecord, error) {
+	rows, err := db.Query(`
+		SELECT `+actionColumns+`
+		FROM actions
+		WHERE host_id = ?
+		ORDER BY created_at DESC, id DESC
+		LIMIT ?
+	`, hostID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query actions: %w", err)
+	}
+	defer rows.Close()
+
+	actions := []ActionRecord{}
+	for rows.Next() {
+		a, err := scanAction(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan action: %w", err)
+		}
+		actions = append(actions, a)
+	}
+	return actions, rows.Err()
+}
@@ -0,0 +1,156 @@
+// Package db - audit.go contains the audit log of administrative actions.
+//
+// Security-relevant actions (logins, service control, host deletion,
+// token and settings changes) are appended to the audit_log table with
+// the user, source address and time, so administrators can review who
+// did what. Entries are never pruned by the metrics retention.
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Audit log actions
+const (
+	AuditLogin             = "login"               // Web UI login
+	AuditLoginFailed       = "login_failed"        // Rejected password, code, Basic Auth or API token
+	AuditLogout            = "logout"              // Web UI logout
+	AuditAccessDenied      = "access_denied"       // API token without the needed scope
+	AuditServiceAction     = "service_action"      // start/stop/restart/monitor/unmonitor sent to an agent
+	AuditBulkAction        = "bulk_action"         // Service action run across a hostgroup, or failed services restarted (summary)
+	AuditDaemonAction      = "daemon_action"       // Agent-wide action (validate, or an action on all services)
+	AuditActionSchedule    = "action_schedule"     // Service action scheduled to run later
+	AuditActionCancel      = "action_cancel"       // Scheduled service action cancelled
+	AuditHostDelete        = "host_delete"         // Host and its history deleted
+	AuditHostArchive       = "host_archive"        // Host archived (hidden, history kept) or unarchived
+	AuditHostImport        = "host_import"         // Host and its history imported from a dump (cmonit db import)
+	AuditHostUpdate        = "host_update"         // Host description, public flag or connection settings changed
+	AuditPreferencesUpdate = "preferences_update"  // Display preferences changed
+	AuditTokenCreate       = "token_create"        // API token created
+	AuditTokenRevoke       = "token_revoke"        // API token revoked
+	AuditTOTPEnable        = "totp_enable"         // Two-factor authentication enabled
+	AuditTOTPDisable       = "totp_disable"        // Two-factor authentication disabled or reset
+	AuditTOTPRecoveryCodes = "totp_recovery_codes" // Recovery codes regenerated
+)
+
+// AuditActions lists the audit log actions, for filter drop-downs.
+var AuditActions = []string{
+	AuditLogin, AuditLoginFailed, AuditLogout, AuditAccessDenied,
+	AuditServiceAction, AuditBulkAction, AuditDaemonAction, AuditActionSchedule, AuditActionCancel, AuditHostDelete, AuditHostArchive, AuditHostUpdate, AuditPreferencesUpdate,
+	AuditTokenCreate, AuditTokenRevoke,
+	AuditTOTPEnable, AuditTOTPDisable, AuditTOTPRecoveryCodes,
+}
+
+// AuditEntry is one audit log record.
+type AuditEntry struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Actor     string    `json:"actor"`     // Web user, "token:<name>", "cli", "retention" or "anonymous"
+	SourceIP  string    `json:"source_ip"` // Client address (empty for the CLI)
+	Action    string    `json:"action"`    // One of the Audit* constants
+	Target    string    `json:"target"`    // Affected object (e.g., "web01/nginx", token name)
+	Details   string    `json:"details"`
+	Success   bool      `json:"success"`
+}
+
+// AuditFilter selects audit log entries. Empty fields match everything.
+type AuditFilter struct {
+	Actor  string
+	Action string
+	From   time.Time // Inclusive, zero = no lower bound
+	To     time.Time // Inclusive, zero = no upper bound
+	Limit  int       // 0 = no limit
+	Offset int
+}
+
+// RecordAudit appends an entry to the audit log. CreatedAt defaults to now.
+func RecordAudit(db *sql.DB, e AuditEntry) error {
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+	_, err := db.Exec(`
+		INSERT INTO audit_log (created_at, actor, source_ip, action, target, details, success)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, e.CreatedAt.UTC(), e.Actor, e.SourceIP, e.Action, e.Target, e.Details, e.Success)
+	if err != nil {
+		return fmt.Errorf("failed to record audit entry: %w", err)
+	}
+	return nil
+}
+
+// ListAuditEntries returns the entries matching f, newest first, and the
+// number of matching entries ignoring Limit and Offset.
+func ListAuditEntries(db *sql.DB, f AuditFilter) ([]AuditEntry, int, error) {
+	where := " WHERE 1=1"
+	var args []interface{}
+	if f.Actor != "" {
+		where += " AND actor = ?"
+		args = append(args, f.Actor)
+	}
+	if f.Action != "" {
+		where += " AND action = ?"
+		args = append(args, f.Action)
+	}
+	// Times are stored in UTC, so text comparison orders them
+	if !f.From.IsZero() {
+		where += " AND created_at >= ?"
+		args = append(args, f.From.UTC())
+	}
+	if !f.To.IsZero() {
+		where += " AND created_at <= ?"
+		args = append(args, f.To.UTC())
+	}
+
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*) FROM audit_log"+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count audit entries: %w", err)
+	}
+
+	query := `
+		SELECT id, created_at, actor, source_ip, action, target, details, success
+		FROM audit_log` + where + `
+		ORDER BY created_at DESC, id DESC`
+	if f.Limit > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, f.Limit, f.Offset)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list audit entries: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		err := rows.Scan(&e.ID, &e.CreatedAt, &e.Actor, &e.SourceIP, &e.Action, &e.Target, &e.Details, &e.Success)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to read audit entry: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, total, rows.Err()
+}
+
+// ListAuditActors returns the distinct actors in the audit log, for
+// filter drop-downs.
+func ListAuditActors(db *sql.DB) ([]string, error) {
+	rows, err := db.Query("SELECT DISTINCT actor FROM audit_log ORDER BY actor")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit actors: %w", err)
+	}
+	defer rows.Close()
+
+	var actors []string
+	for rows.Next() {
+		var actor string
+		if err := rows.Scan(&actor); err != nil {
+			return nil, err
+		}
+		actors = append(actors, actor)
+	}
+	return actors, rows.Err()
+}
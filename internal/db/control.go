@@ -0,0 +1,77 @@
+// Package db - control.go contains per-host Monit agent connection settings.
+//
+// Service actions connect to the Monit agent's HTTP server, over HTTPS
+// when the agent reports SSL. These settings tell how to verify the
+// agent's certificate, for agents using a private CA or a self-signed
+// certificate, and which credentials to use when the agent does not report
+// them (no <credentials> in its status) or reports read-only ones.
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// HostControl holds the Monit agent connection settings of a host.
+type HostControl struct {
+	HostID             string     `json:"host_id"`
+	CAFile             string     `json:"ca_file"`              // CA bundle; empty = default
+	InsecureSkipVerify bool       `json:"insecure_skip_verify"` // Accept any certificate
+	Username           string     `json:"username"`             // Overrides the reported username; empty = reported one
+	PasswordEnc        string     `json:"-"`                    // Encrypted password override (see EncryptSecret)
+	HasPassword        bool       `json:"has_password"`         // A password override is set
+	UpdatedAt          *time.Time `json:"updated_at,omitempty"`
+}
+
+// Password decrypts the password override of the host, empty if none.
+func (hc *HostControl) Password() (string, error) {
+	return DecryptSecret(hc.PasswordEnc, hc.HostID)
+}
+
+// GetHostControl returns the connection settings of a host. Hosts without
+// settings get the defaults (empty CAFile, verification on).
+func GetHostControl(db *sql.DB, hostID string) (*HostControl, error) {
+	hc := &HostControl{HostID: hostID}
+	var insecure int
+	var updatedAt sql.NullTime
+	err := db.QueryRow(`
+		SELECT ca_file, insecure_skip_verify, username, password_enc, updated_at
+		FROM host_control WHERE host_id = ?
+	`, hostID).Scan(&hc.CAFile, &insecure, &hc.Username, &hc.PasswordEnc, &updatedAt)
+	if err == sql.ErrNoRows {
+		return hc, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get control settings of %s: %w", hostID, err)
+	}
+	hc.InsecureSkipVerify = insecure == 1
+	hc.HasPassword = hc.PasswordEnc != ""
+	if updatedAt.Valid {
+		hc.UpdatedAt = &updatedAt.Time
+	}
+	return hc, nil
+}
+
+// SetHostControl stores the connection settings of a host. PasswordEnc must
+// already be encrypted with EncryptSecret(password, hostID).
+func SetHostControl(db *sql.DB, hc *HostControl) error {
+	insecure := 0
+	if hc.InsecureSkipVerify {
+		insecure = 1
+	}
+	_, err := db.Exec(`
+		INSERT INTO host_control (host_id, ca_file, insecure_skip_verify, username, password_enc, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(host_id) DO UPDATE SET
+			ca_file = excluded.ca_file,
+			insecure_skip_verify = excluded.insecure_skip_verify,
+			username = excluded.username,
+			password_enc = excluded.password_enc,
+			updated_at = excluded.updated_at
+	`, hc.HostID, hc.CAFile, insecure, hc.Username, hc.PasswordEnc, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to set control settings of %s: %w", hc.HostID, err)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+// Package db - diagnostics.go contains the parse diagnostics of the
+// status documents posted by the agents.
+//
+// With [logging] parse_diagnostics, the collector records the warnings
+// of parser.Diagnose for each document, and the documents it could not
+// parse, so problems with an agent can be reviewed from the API rather
+// than from debug logs and XML dumps.
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/ocochard/cmonit/internal/parser"
+)
+
+// maxParseDiagnostics is the number of entries kept per host, or per
+// source address for the documents that could not be parsed.
+const maxParseDiagnostics = 200
+
+// ParseDiagnostic is a parse warning or error of a status document.
+type ParseDiagnostic struct {
+	ID        int64     `json:"id"`
+	HostID    string    `json:"host_id,omitempty"` // Empty if the document could not be parsed
+	Source    string    `json:"source"`            // Address of the agent
+	Service   string    `json:"service,omitempty"` // Empty for the document
+	Message   string    `json:"message"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// StoreParseDiagnostics records the warnings of a status document of a
+// host, posted from source, and drops the oldest entries of the host
+// beyond maxParseDiagnostics.
+func StoreParseDiagnostics(db *sql.DB, hostID, source string, warnings []parser.Warning) error {
+	if len(warnings) == 0 {
+		return nil
+	}
+	now := time.Now()
+	for _, w := range warnings {
+		_, err := db.Exec(`INSERT INTO parse_diagnostics (host_id, source, service_name, message, created_at)
+			VALUES (?, ?, ?, ?, ?)`, hostID, source, w.Service, w.Message, now)
+		if err != nil {
+			return fmt.Errorf("failed to store parse diagnostics: %w", err)
+		}
+	}
+	_, err := db.Exec(`DELETE FROM parse_diagnostics WHERE host_id = ? AND id NOT IN (
+		SELECT id FROM parse_diagnostics WHERE host_id = ? ORDER BY id DESC LIMIT ?)`,
+		hostID, hostID, maxParseDiagnostics)
+	if err != nil {
+		return fmt.Errorf("failed to prune parse diagnostics: %w", err)
+	}
+	return nil
+}
+
+// StoreParseError records a status document posted from source that could
+// not be parsed, and drops the oldest errors of the source beyond
+// maxParseDiagnostics.
+func StoreParseError(db *sql.DB, source string, parseErr error) error {
+	_, err := db.Exec(`INSERT INTO parse_diagnostics (host_id, source, message, created_at)
+		VALUES (NULL, ?, ?, ?)`, source, parseErr.Error(), time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to store parse error: %w", err)
+	}
+	_, err = db.Exec(`DELETE FROM parse_diagnostics WHERE host_id IS NULL AND source = ? AND id NOT IN (
+		SELECT id FROM parse_diagnostics WHERE host_id IS NULL AND source = ? ORDER BY id DESC LIMIT ?)`,
+		source, source, maxParseDiagnostics)
+	if err != nil {
+		return fmt.Errorf("failed to prune parse errors: %w", err)
+	}
+	return nil
+}
+
+// ListParseDiagnostics returns the latest parse diagnostics, newest first:
+// of a host, or of all hosts and the documents that could not be parsed
+// with an empty hostID.
+func ListParseDiagnostics(db *sql.DB, hostID string, limit int) ([]ParseDiagnostic, error) {
+	query := `SELECT id, COALESCE(host_id, ''), source, service_name, message, created_at
+		FROM parse_diagnostics`
+	args := []interface{}{}
+	if hostID != "" {
+		query += ` WHERE host_id = ?`
+		args = append(args, hostID)
+	}
+	query += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list parse diagnostics: %w", err)
+	}
+	defer rows.Close()
+
+	diagnostics := []ParseDiagnostic{}
+	for rows.Next() {
+		var d ParseDiagnostic
+		if err := rows.Scan(&d.ID, &d.HostID, &d.Source, &d.Service, &d.Message, &d.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan parse diagnostic: %w", err)
+		}
+		diagnostics = append(diagnostics, d)
+	}
+	return diagnostics, rows.Err()
+}
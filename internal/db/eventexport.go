@@ -0,0 +1,173 @@
+package db
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Event export formats.
+const (
+	EventExportCSV    = "csv"    // One row per event, with a header row
+	EventExportNDJSON = "ndjson" // One JSON object (StoredEvent) per line
+)
+
+// eventExportBatch is the number of events read per query, so that a long
+// export does not hold a read transaction open while the output drains.
+const eventExportBatch = 1000
+
+// EventExportFilter selects the events of ExportEvents; zero fields do not
+// filter.
+type EventExportFilter struct {
+	HostID    string
+	Group     string // Hostgroup of the host, or service group of the service
+	Service   string
+	EventType int64 // -1 for all types
+	Severity  string
+	Ack       string // "yes" = acknowledged only, "no" = unacknowledged only
+	From, To  time.Time
+}
+
+// eventExportColumns is the CSV header row.
+var eventExportColumns = []string{"id", "created_at", "host_id", "hostname", "service", "event_type",
+	"state", "action", "severity", "flap_count", "source", "message"}
+
+// ExportEvents writes the events matching f to w, oldest first, as CSV or
+// ND-JSON, and returns the number of events written. Times are RFC 3339.
+func ExportEvents(db *sql.DB, f EventExportFilter, format string, w io.Writer) (int, error) {
+	if format != EventExportCSV && format != EventExportNDJSON {
+		return 0, fmt.Errorf("invalid export format %q (csv or ndjson)", format)
+	}
+	where, args := f.Conditions()
+
+	bw := bufio.NewWriter(w)
+	var cw *csv.Writer
+	enc := json.NewEncoder(bw)
+	if format == EventExportCSV {
+		cw = csv.NewWriter(bw)
+		if err := cw.Write(eventExportColumns); err != nil {
+			return 0, err
+		}
+	}
+
+	count := 0
+	var lastID int64
+	for {
+		events, err := exportEventBatch(db, where, args, lastID)
+		if err != nil {
+			return count, err
+		}
+		for _, e := range events {
+			if cw != nil {
+				err = cw.Write([]string{
+					strconv.FormatInt(e.ID, 10), e.CreatedAt.Format(time.RFC3339), e.HostID, e.Hostname, e.Service,
+					strconv.Itoa(e.EventType), strconv.Itoa(e.State), strconv.Itoa(e.Action), e.Severity,
+					strconv.Itoa(e.FlapCount), e.Source, e.Message,
+				})
+			} else {
+				err = enc.Encode(e)
+			}
+			if err != nil {
+				return count, err
+			}
+			count++
+			lastID = e.ID
+		}
+		if cw != nil {
+			cw.Flush()
+			if err := cw.Error(); err != nil {
+				return count, err
+			}
+		}
+		if len(events) < eventExportBatch {
+			break
+		}
+	}
+	return count, bw.Flush()
+}
+
+// Conditions returns the SQL conditions of the filter on the events e
+// (" AND ..." each, "" without filters) and their arguments.
+func (f EventExportFilter) Conditions() (string, []interface{}) {
+	where := ""
+	var args []interface{}
+	if f.HostID != "" {
+		where += " AND e.host_id = ?"
+		args = append(args, f.HostID)
+	}
+	if f.Group != "" {
+		where += `
+		  AND (e.host_id IN (
+			SELECT hhg.host_id
+			FROM host_hostgroups hhg
+			JOIN hostgroups hg ON hg.id = hhg.hostgroup_id
+			WHERE hg.name = ?
+		  ) OR EXISTS (
+			SELECT 1 FROM service_groups sg
+			WHERE sg.host_id = e.host_id AND sg.service_name = e.service_name AND sg.group_name = ?
+		  ))`
+		args = append(args, f.Group, f.Group)
+	}
+	if f.Service != "" {
+		where += " AND e.service_name = ?"
+		args = append(args, f.Service)
+	}
+	if f.EventType >= 0 {
+		where += " AND e.event_type = ?"
+		args = append(args, f.EventType)
+	}
+	if f.Severity != "" {
+		where += " AND e.severity = ?"
+		args = append(args, f.Severity)
+	}
+	switch f.Ack {
+	case "yes":
+		where += " AND e.ack_at IS NOT NULL"
+	case "no":
+		where += " AND e.ack_at IS NULL"
+	}
+	// created_at is stored in the server's local time
+	if !f.From.IsZero() {
+		where += " AND e.created_at >= ?"
+		args = append(args, f.From.In(time.Local))
+	}
+	if !f.To.IsZero() {
+		where += " AND e.created_at <= ?"
+		args = append(args, f.To.In(time.Local))
+	}
+	return where, args
+}
+
+// exportEventBatch returns the next events matching where after the event
+// afterID, by id.
+func exportEventBatch(db *sql.DB, where string, args []interface{}, afterID int64) ([]StoredEvent, error) {
+	rows, err := db.Query(`
+		SELECT e.id, e.host_id, h.hostname, e.service_name, COALESCE(e.event_type, 0),
+		       COALESCE(e.state, -1), COALESCE(e.action, -1), COALESCE(e.message, ''),
+		       e.severity, e.flap_count, COALESCE(e.source, ''), e.created_at
+		FROM events e
+		JOIN hosts h ON h.id = e.host_id
+		WHERE e.id > ?`+where+`
+		ORDER BY e.id
+		LIMIT ?`, append(append([]interface{}{afterID}, args...), eventExportBatch)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []StoredEvent
+	for rows.Next() {
+		var e StoredEvent
+		if err := rows.Scan(&e.ID, &e.HostID, &e.Hostname, &e.Service, &e.EventType,
+			&e.State, &e.Action, &e.Message, &e.Severity, &e.FlapCount, &e.Source, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to export events: %w", err)
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
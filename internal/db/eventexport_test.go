@@ -0,0 +1,30 @@
+package db
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestEventExportFilter checks the conditions of the export filters and
+// the formats accepted.
+func TestEventExportFilter(t *testing.T) {
+	if where, args := (EventExportFilter{EventType: -1}).Conditions(); where != "" || args != nil {
+		t.Errorf("no filter = %q, %v, want no condition", where, args)
+	}
+
+	from := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	where, args := EventExportFilter{HostID: "web1-0", Group: "web", EventType: 0x200, Ack: "no", From: from}.Conditions()
+	for _, condition := range []string{"e.host_id = ?", "hg.name = ?", "e.event_type = ?", "e.ack_at IS NULL", "e.created_at >= ?"} {
+		if !strings.Contains(where, condition) {
+			t.Errorf("conditions %q lack %q", where, condition)
+		}
+	}
+	if len(args) != 5 || args[0] != "web1-0" || args[3] != int64(0x200) {
+		t.Errorf("args = %v", args)
+	}
+
+	if _, err := ExportEvents(nil, EventExportFilter{}, "xml", nil); err == nil {
+		t.Error("ExportEvents(xml): no error")
+	}
+}
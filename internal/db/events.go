@@ -0,0 +1,211 @@
+// Package db - events.go stores the event notifications of the agents.
+//
+// Monit posts an event to the collector as soon as a check changes state,
+// in a document with <server> and <platform> but no services. Status
+// reports go through StoreMonitStatus instead.
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ocochard/cmonit/internal/parser"
+)
+
+// StoreMonitEvent stores the event of an event notification. The host is
+// created if this is the first document of the agent; otherwise it is left
+// as the last status report described it.
+//
+// An error means the event was not stored: the collector then answers
+// with an error status so that Monit keeps the event in its queue and
+// sends it again.
+func StoreMonitEvent(db *sql.DB, status *parser.MonitStatus) error {
+	event := status.Event
+	if event == nil {
+		return fmt.Errorf("not an event notification")
+	}
+	hostID := HostID(status)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() // no-op if Commit succeeds
+
+	var known bool
+	err = tx.QueryRow("SELECT EXISTS(SELECT 1 FROM hosts WHERE id = ?)", hostID).Scan(&known)
+	if err != nil {
+		return fmt.Errorf("failed to look up host %s: %w", hostID, err)
+	}
+	if !known {
+		if err := StoreHost(tx, &status.Server, &status.Platform, nil); err != nil {
+			return fmt.Errorf("failed to store host: %w", err)
+		}
+	}
+
+	// The type of the service, for the severity of the event; unknown for
+	// an event before the first status report
+	serviceType := -1
+	err = tx.QueryRow("SELECT type FROM services WHERE host_id = ? AND name = ?", hostID, event.Service).Scan(&serviceType)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to look up service %s: %w", event.Service, err)
+	}
+
+	createdAt := time.Now()
+	if event.CollectedSec > 0 {
+		createdAt = event.CollectedAt()
+	}
+	severity := EventSeverity(event.ID, serviceType, event.State)
+	collapsed, err := collapseFlapping(tx, hostID, event.Service, event.ID, event.State, severity, createdAt)
+	if err != nil {
+		return err
+	}
+	if collapsed {
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit transaction: %w", err)
+		}
+		log.Printf("[INFO] Collapsed flapping event for host %s: %s - %s", status.Server.LocalHostname, event.Service, event.Message)
+		return nil
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO events (host_id, service_name, event_type, message, created_at, state, action, severity)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, hostID, event.Service, event.ID, event.Message, createdAt, event.State, event.Action, severity)
+	if err != nil {
+		return fmt.Errorf("failed to store event: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	log.Printf("[INFO] Stored event for host %s: %s - %s", status.Server.LocalHostname, event.Service, event.Message)
+	return nil
+}
+
+// transitionEvent describes the change of the status of a service between
+// two status reports: the event type has the bits that changed, the state
+// is failed (1) unless the service is back to OK (0, succeeded), as in the
+// events posted by Monit.
+func transitionEvent(name string, before, after int) (eventType, state int, message string) {
+	state = 1
+	if after == 0 {
+		state = 0
+	}
+	message = fmt.Sprintf("%s: %s → %s", name, parser.StatusText(before), parser.StatusText(after))
+	return before ^ after, state, message
+}
+
+// storeTransitionEvent records the change of the status of a service from
+// before, reported at previousAt, to its status in service. It is skipped
+// when the agent posted an event for the service since previousAt, even
+// one a flapping event absorbed: Monit then already described the change.
+func storeTransitionEvent(db queryer, hostID string, service *parser.Service, before int, previousAt time.Time) error {
+	var posted bool
+	err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM events
+		WHERE host_id = ? AND service_name = ? AND
+		      ((action IS NOT NULL AND created_at >= ?) OR flap_until > ?))`,
+		hostID, service.Name, previousAt, previousAt).Scan(&posted)
+	if err != nil {
+		return fmt.Errorf("failed to look up events of %s: %w", service.Name, err)
+	}
+	if posted {
+		return nil
+	}
+
+	eventType, state, message := transitionEvent(service.Name, before, service.Status)
+	severity := EventSeverity(eventType, service.Type, state)
+	collapsed, err := collapseFlapping(db, hostID, service.Name, eventType, state, severity, service.GetCollectedTime())
+	if err != nil || collapsed {
+		return err
+	}
+	_, err = db.Exec(`INSERT INTO events (host_id, service_name, event_type, message, created_at, state, severity)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		hostID, service.Name, eventType, message, service.GetCollectedTime(), state, severity)
+	if err != nil {
+		return fmt.Errorf("failed to store transition event: %w", err)
+	}
+	if debugMode {
+		log.Printf("[DEBUG] Stored transition event for %s: %s", hostID, message)
+	}
+	return nil
+}
+
+// ExternalEvent is an event recorded by an external system through the API,
+// such as a deploy or a backup, shown with the events of its service.
+type ExternalEvent struct {
+	HostID    string
+	Service   string
+	Message   string
+	Severity  string // info, warning or critical
+	Source    string // System (or API token) recording it, e.g. "jenkins"
+	CreatedAt time.Time
+}
+
+// StoreExternalEvent stores an external event and returns its id. It has
+// no Monit event type, state or action.
+func StoreExternalEvent(db *sql.DB, e ExternalEvent) (int64, error) {
+	result, err := db.Exec(`INSERT INTO events (host_id, service_name, event_type, message, created_at, severity, source)
+		VALUES (?, ?, 0, ?, ?, ?, ?)`, e.HostID, e.Service, e.Message, e.CreatedAt, e.Severity, e.Source)
+	if err != nil {
+		return 0, fmt.Errorf("failed to store external event: %w", err)
+	}
+	return result.LastInsertId()
+}
+
+// StoredEvent is an event as stored, read by EventsAfter for the event
+// forwarders.
+type StoredEvent struct {
+	ID        int64     `json:"id"`
+	HostID    string    `json:"host_id"`
+	Hostname  string    `json:"hostname"`
+	Service   string    `json:"service"`
+	EventType int       `json:"event_type"`
+	State     int       `json:"state"`  // Monit event state, -1 for the events not sent by Monit
+	Action    int       `json:"action"` // Monit action, -1 for the events not sent by Monit
+	Message   string    `json:"message"`
+	Severity  string    `json:"severity"`
+	FlapCount int       `json:"flap_count,omitempty"` // Failures and recoveries of a flapping service
+	Source    string    `json:"source,omitempty"`     // External system that recorded the event
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// LastEventID returns the id of the newest event, 0 without events.
+func LastEventID(db *sql.DB) (int64, error) {
+	var id int64
+	err := db.QueryRow("SELECT COALESCE(MAX(id), 0) FROM events").Scan(&id)
+	return id, err
+}
+
+// EventsAfter returns at most limit events stored after the event afterID,
+// oldest first: event ids grow with each insert, as SQLite serializes the
+// transactions writing them.
+func EventsAfter(db *sql.DB, afterID int64, limit int) ([]StoredEvent, error) {
+	rows, err := db.Query(`
+		SELECT e.id, e.host_id, h.hostname, e.service_name, COALESCE(e.event_type, 0),
+		       COALESCE(e.state, -1), COALESCE(e.action, -1), COALESCE(e.message, ''),
+		       e.severity, e.flap_count, COALESCE(e.source, ''), e.created_at
+		FROM events e
+		JOIN hosts h ON h.id = e.host_id
+		WHERE e.id > ?
+		ORDER BY e.id
+		LIMIT ?`, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []StoredEvent
+	for rows.Next() {
+		var e StoredEvent
+		if err := rows.Scan(&e.ID, &e.HostID, &e.Hostname, &e.Service, &e.EventType,
+			&e.State, &e.Action, &e.Message, &e.Severity, &e.FlapCount, &e.Source, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
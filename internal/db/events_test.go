@@ -0,0 +1,72 @@
+package db
+
+import "testing"
+
+// TestTransitionEvent checks the events recorded on the status changes
+// detected at ingest.
+func TestTransitionEvent(t *testing.T) {
+	tests := []struct {
+		before, after int
+		eventType     int
+		state         int
+		message       string
+	}{
+		{0, 0x20, 0x20, 1, "nginx: OK → Connection failed"},
+		{0x20, 0, 0x20, 0, "nginx: Connection failed → OK"},
+		{0x20, 0x24, 0x4, 1, "nginx: Connection failed → Timeout, Connection failed"},
+	}
+	for _, tt := range tests {
+		eventType, state, message := transitionEvent("nginx", tt.before, tt.after)
+		if eventType != tt.eventType || state != tt.state || message != tt.message {
+			t.Errorf("transitionEvent(%#x, %#x) = %#x, %d, %q; want %#x, %d, %q",
+				tt.before, tt.after, eventType, state, message, tt.eventType, tt.state, tt.message)
+		}
+	}
+}
+
+// TestEventSeverity checks the default severity rules, and configured
+// rules checked before them.
+func TestEventSeverity(t *testing.T) {
+	defer SetSeverityRules(nil)
+
+	tests := []struct {
+		eventType, serviceType, state int
+		want                          string
+	}{
+		{0x200, 3, 1, SeverityCritical}, // process does not exist
+		{0x200, 3, 0, SeverityInfo},     // process back
+		{0x1, 2, 2, SeverityInfo},       // checksum changed
+		{0x1, 2, 1, SeverityWarning},    // checksum failed
+		{0x2, 0, 1, SeverityCritical},   // filesystem full
+		{0x2, 5, 1, SeverityWarning},    // system load
+		{0x2, -1, -1, SeverityWarning},  // unknown service type
+		{0x40000, -1, -1, SeverityWarning},
+	}
+	for _, tt := range tests {
+		if got := EventSeverity(tt.eventType, tt.serviceType, tt.state); got != tt.want {
+			t.Errorf("EventSeverity(%#x, %d, %d) = %s, want %s", tt.eventType, tt.serviceType, tt.state, got, tt.want)
+		}
+	}
+
+	err := SetSeverityRules([]SeverityRule{{Events: []string{"Checksum"}, ServiceTypes: []string{"file"}, Severity: "critical"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := EventSeverity(0x1, 2, 2); got != SeverityCritical {
+		t.Errorf("configured rule: checksum changed = %s, want critical", got)
+	}
+	if got := EventSeverity(0x1, 3, 2); got != SeverityInfo {
+		t.Errorf("configured rule applied to a process: %s", got)
+	}
+
+	for _, bad := range []SeverityRule{
+		{Severity: "fatal"},
+		{Events: []string{"checksums"}, Severity: "info"},
+		{ServiceTypes: []string{"daemon"}, Severity: "info"},
+		{States: []string{"down"}, Severity: "info"},
+	} {
+		if err := SetSeverityRules([]SeverityRule{bad}); err == nil {
+			t.Errorf("rule %+v accepted", bad)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// Package db - eventsearch.go maintains the full-text index of the event
+// messages and service names, used by the search of the events pages and
+// API. SQLite builds without FTS5 fall back to LIKE queries.
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// createEventsSearchTable creates the events_fts full-text index
+//
+// An FTS5 table whose content is the events table: it only holds the
+// index of the message and service_name columns, kept up to date by the
+// triggers (the message of a flapping event changes with its count).
+// Hostnames are matched on the hosts table instead, as they change.
+const createEventsSearchTable = `
+	CREATE VIRTUAL TABLE IF NOT EXISTS events_fts USING fts5(
+		message, service_name, content='events', content_rowid='id'
+	);
+	CREATE TRIGGER IF NOT EXISTS events_fts_insert AFTER INSERT ON events BEGIN
+		INSERT INTO events_fts(rowid, message, service_name)
+		VALUES (new.id, new.message, new.service_name);
+	END;
+	CREATE TRIGGER IF NOT EXISTS events_fts_delete AFTER DELETE ON events BEGIN
+		INSERT INTO events_fts(events_fts, rowid, message, service_name)
+		VALUES ('delete', old.id, old.message, old.service_name);
+	END;
+	CREATE TRIGGER IF NOT EXISTS events_fts_update AFTER UPDATE OF message, service_name ON events BEGIN
+		INSERT INTO events_fts(events_fts, rowid, message, service_name)
+		VALUES ('delete', old.id, old.message, old.service_name);
+		INSERT INTO events_fts(rowid, message, service_name)
+		VALUES (new.id, new.message, new.service_name);
+	END;`
+
+// eventSearchFTS reports whether events_fts is available.
+var eventSearchFTS bool
+
+// EventSearchFTS reports whether the events can be searched with the
+// events_fts full-text index, or must be with LIKE.
+func EventSearchFTS() bool {
+	return eventSearchFTS
+}
+
+// initEventSearch creates events_fts and indexes the existing events the
+// first time. A SQLite without FTS5 is not an error: the search then
+// falls back to LIKE.
+func initEventSearch(db *sql.DB) error {
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE name = 'events_fts')").Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to look up events_fts: %w", err)
+	}
+
+	if _, err := db.Exec(createEventsSearchTable); err != nil {
+		if strings.Contains(err.Error(), "no such module") {
+			log.Printf("[WARN] SQLite without FTS5, event search falls back to LIKE: %v", err)
+			eventSearchFTS = false
+			return nil
+		}
+		return fmt.Errorf("failed to create events_fts: %w", err)
+	}
+
+	if !exists {
+		log.Printf("[INFO] Indexing the events for the search")
+		if _, err := db.Exec("INSERT INTO events_fts(events_fts) VALUES ('rebuild')"); err != nil {
+			return fmt.Errorf("failed to index the events: %w", err)
+		}
+	}
+	eventSearchFTS = true
+	return nil
+}
@@ -0,0 +1,404 @@
+// Package db - export.go contains the portable export and import of the
+// data of a host ("cmonit db export" / "cmonit db import"), to move hosts
+// between cmonit instances or keep them out of a database.
+//
+// The dump is ND-JSON: a header line, then one line per row of the host's
+// tables, then its hostgroups:
+//
+//	{"type":"header","format":"cmonit-host-export","version":1,"schema_version":26,"host_id":"...","exported_at":"..."}
+//	{"type":"row","table":"services","row":{"host_id":"...","name":"sshd",...}}
+//	{"type":"hostgroup","name":"web"}
+//
+// Rows keep their column names, so a dump imports into a database with
+// another schema version: columns unknown to it are dropped, and missing
+// ones take their default.
+package db
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Format and version of host dumps.
+const (
+	exportFormat  = "cmonit-host-export"
+	exportVersion = 1
+)
+
+// hostExportTables are the tables holding the data of a host, in import
+// order (hosts first, for the foreign keys). Service actions and the
+// connection settings (host_control) are local to an instance and left out.
+var hostExportTables = []string{
+	"hosts",
+	"services",
+	"latest_metrics",
+	"metrics",
+	"events",
+	"filesystem_metrics",
+	"network_metrics",
+	"file_metrics",
+	"path_metrics",
+	"program_metrics",
+	"remote_host_metrics",
+	"host_availability",
+}
+
+// exportRecord is a line of a host dump.
+type exportRecord struct {
+	Type string `json:"type"` // header, row or hostgroup
+
+	// Header
+	Format        string     `json:"format,omitempty"`
+	Version       int        `json:"version,omitempty"`
+	SchemaVersion int        `json:"schema_version,omitempty"`
+	HostID        string     `json:"host_id,omitempty"`
+	ExportedAt    *time.Time `json:"exported_at,omitempty"`
+
+	// Row
+	Table string                 `json:"table,omitempty"`
+	Row   map[string]interface{} `json:"row,omitempty"`
+
+	// Hostgroup
+	Name string `json:"name,omitempty"`
+}
+
+// ExportHost writes the dump of a host to w and returns the number of rows
+// exported per table. The Monit password reported by the agent is left
+// out: the agent sends it again in its next status.
+func ExportHost(db *sql.DB, hostID string, w io.Writer) (map[string]int, error) {
+	var exists int
+	if err := db.QueryRow("SELECT COUNT(*) FROM hosts WHERE id = ?", hostID).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("failed to query host: %w", err)
+	}
+	if exists == 0 {
+		return nil, fmt.Errorf("host not found: %s", hostID)
+	}
+
+	version, err := getSchemaVersion(db)
+	if err != nil {
+		return nil, err
+	}
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	now := time.Now().UTC()
+	if err := enc.Encode(exportRecord{
+		Type:          "header",
+		Format:        exportFormat,
+		Version:       exportVersion,
+		SchemaVersion: version,
+		HostID:        hostID,
+		ExportedAt:    &now,
+	}); err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int)
+	for _, table := range hostExportTables {
+		n, err := exportTable(db, enc, table, hostID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to export %s: %w", table, err)
+		}
+		counts[table] = n
+	}
+
+	groups, err := hostGroupNames(db, hostID)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range groups {
+		if err := enc.Encode(exportRecord{Type: "hostgroup", Name: name}); err != nil {
+			return nil, err
+		}
+	}
+	counts["hostgroups"] = len(groups)
+
+	return counts, bw.Flush()
+}
+
+// exportTable writes the rows of a host in table.
+func exportTable(db *sql.DB, enc *json.Encoder, table, hostID string) (int, error) {
+	key := "host_id"
+	if table == "hosts" {
+		key = "id"
+	}
+	rows, err := db.Query("SELECT * FROM "+table+" WHERE "+key+" = ?", hostID)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	values := make([]interface{}, len(columns))
+	ptrs := make([]interface{}, len(columns))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+
+	n := 0
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return n, err
+		}
+		row := make(map[string]interface{}, len(columns))
+		for i, col := range columns {
+			row[col] = values[i]
+		}
+		if table == "hosts" {
+			row["http_password"] = ""
+		}
+		if err := enc.Encode(exportRecord{Type: "row", Table: table, Row: row}); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, rows.Err()
+}
+
+// hostGroupNames returns the hostgroups of a host.
+func hostGroupNames(db *sql.DB, hostID string) ([]string, error) {
+	rows, err := db.Query(`
+		SELECT g.name FROM hostgroups g
+		JOIN host_hostgroups hg ON hg.hostgroup_id = g.id
+		WHERE hg.host_id = ? ORDER BY g.name`, hostID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query hostgroups: %w", err)
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// ImportHost reads a dump written by ExportHost and adds the host to the
+// database, in a single transaction. A host already in the database is an
+// error, unless replace is set: its data is then deleted first.
+//
+// It returns the ID of the host and the number of rows imported per table.
+func ImportHost(db *sql.DB, r io.Reader, replace bool) (string, map[string]int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024) // Program outputs can be long
+
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return "", nil, err
+		}
+		return "", nil, fmt.Errorf("empty dump")
+	}
+	var header exportRecord
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil || header.Type != "header" || header.Format != exportFormat {
+		return "", nil, fmt.Errorf("not a cmonit host dump")
+	}
+	if header.Version > exportVersion {
+		return "", nil, fmt.Errorf("dump version %d is newer than this binary (%d)", header.Version, exportVersion)
+	}
+	hostID := header.HostID
+	if hostID == "" {
+		return "", nil, fmt.Errorf("dump without host_id")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() // No-op after Commit
+
+	var exists int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM hosts WHERE id = ?", hostID).Scan(&exists); err != nil {
+		return "", nil, fmt.Errorf("failed to query host: %w", err)
+	}
+	if exists > 0 {
+		if !replace {
+			return "", nil, fmt.Errorf("host %s already exists (use replace to overwrite it)", hostID)
+		}
+		if err := deleteHostData(tx, hostID); err != nil {
+			return "", nil, err
+		}
+	}
+
+	im := &hostImporter{tx: tx, hostID: hostID, columns: map[string]map[string]string{}, stmts: map[string]*sql.Stmt{}}
+	defer im.close()
+
+	counts := make(map[string]int)
+	var groups []string
+	line := 1
+	for scanner.Scan() {
+		line++
+		dec := json.NewDecoder(strings.NewReader(scanner.Text()))
+		dec.UseNumber() // Keep integers exact
+		var rec exportRecord
+		if err := dec.Decode(&rec); err != nil {
+			return "", nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		switch rec.Type {
+		case "row":
+			if err := im.insert(rec.Table, rec.Row); err != nil {
+				return "", nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			counts[rec.Table]++
+		case "hostgroup":
+			groups = append(groups, rec.Name)
+		default:
+			return "", nil, fmt.Errorf("line %d: unknown record type %q", line, rec.Type)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", nil, err
+	}
+	if counts["hosts"] != 1 {
+		return "", nil, fmt.Errorf("dump without the host row")
+	}
+
+	if err := StoreHostGroups(tx, hostID, groups); err != nil {
+		return "", nil, err
+	}
+	counts["hostgroups"] = len(groups)
+
+	im.close()
+	if err := tx.Commit(); err != nil {
+		return "", nil, fmt.Errorf("failed to commit import: %w", err)
+	}
+	return hostID, counts, nil
+}
+
+// deleteHostData deletes a host and its rows in the exported tables.
+func deleteHostData(tx *sql.Tx, hostID string) error {
+	if _, err := tx.Exec("DELETE FROM host_hostgroups WHERE host_id = ?", hostID); err != nil {
+		return fmt.Errorf("failed to delete hostgroups: %w", err)
+	}
+	for i := len(hostExportTables) - 1; i >= 0; i-- {
+		table := hostExportTables[i]
+		key := "host_id"
+		if table == "hosts" {
+			key = "id"
+		}
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE "+key+" = ?", hostID); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// hostImporter inserts the rows of a dump.
+type hostImporter struct {
+	tx      *sql.Tx
+	hostID  string
+	columns map[string]map[string]string // Table -> column -> declared type
+	stmts   map[string]*sql.Stmt         // Table and columns -> INSERT
+}
+
+// insert adds a row to table, keeping the columns the table has.
+func (im *hostImporter) insert(table string, row map[string]interface{}) error {
+	if !slices.Contains(hostExportTables, table) {
+		return fmt.Errorf("unexpected table %q", table)
+	}
+
+	// Every row must belong to the host of the header
+	key := "host_id"
+	if table == "hosts" {
+		key = "id"
+	}
+	if id, _ := row[key].(string); id != im.hostID {
+		return fmt.Errorf("%s row of another host (%v)", table, row[key])
+	}
+
+	columns, err := im.tableColumns(table)
+	if err != nil {
+		return err
+	}
+
+	// Sorted names: rows of a table share the same statement
+	var names []string
+	for name := range row {
+		if _, ok := columns[name]; !ok || (name == "id" && table != "hosts") {
+			continue // Unknown column, or a row ID given again on insert
+		}
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	args := make([]interface{}, len(names))
+	for i, name := range names {
+		args[i] = importValue(row[name], columns[name])
+	}
+
+	stmtKey := table + ":" + strings.Join(names, ",")
+	stmt, ok := im.stmts[stmtKey]
+	if !ok {
+		query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table,
+			strings.Join(names, ", "), strings.TrimSuffix(strings.Repeat("?, ", len(names)), ", "))
+		if stmt, err = im.tx.Prepare(query); err != nil {
+			return err
+		}
+		im.stmts[stmtKey] = stmt
+	}
+	_, err = stmt.Exec(args...)
+	return err
+}
+
+// tableColumns returns the columns of table and their declared types.
+func (im *hostImporter) tableColumns(table string) (map[string]string, error) {
+	if columns, ok := im.columns[table]; ok {
+		return columns, nil
+	}
+	rows, err := im.tx.Query("SELECT name, type FROM pragma_table_info(?)", table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns := make(map[string]string)
+	for rows.Next() {
+		var name, declType string
+		if err := rows.Scan(&name, &declType); err != nil {
+			return nil, err
+		}
+		columns[name] = strings.ToUpper(declType)
+	}
+	im.columns[table] = columns
+	return columns, rows.Err()
+}
+
+// close releases the prepared statements.
+func (im *hostImporter) close() {
+	for key, stmt := range im.stmts {
+		stmt.Close()
+		delete(im.stmts, key)
+	}
+}
+
+// importValue converts a JSON value of a dump back to the value stored in a
+// column: numbers to int64 or float64, and times (exported as RFC 3339) to
+// time.Time for DATETIME columns, so they are stored as cmonit stores them.
+func importValue(value interface{}, declType string) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	case string:
+		if strings.Contains(declType, "DATE") || strings.Contains(declType, "TIME") {
+			if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+				return t
+			}
+		}
+		return v
+	}
+	return value
+}
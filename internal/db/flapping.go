@@ -0,0 +1,136 @@
+// Package db - flapping.go collapses the failures and recoveries of a
+// service oscillating between the two into a single "flapping" event, so
+// that they do not fill the events pages and the event sinks.
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// DefaultFlapWindow is the window of FlapPolicy without one.
+const DefaultFlapWindow = 10 * time.Minute
+
+// FlapPolicy decides when a service is flapping: when Changes failure and
+// recovery events (state 1 and 0) of the service are stored within Window.
+type FlapPolicy struct {
+	Changes int // 0 disables the detection
+	Window  time.Duration
+}
+
+// flapPolicy is the policy of the configuration ([flapping]).
+var flapPolicy FlapPolicy
+
+// SetFlapPolicy sets the flap detection policy; a zero Window is
+// DefaultFlapWindow.
+func SetFlapPolicy(p FlapPolicy) error {
+	if p.Changes != 0 && p.Changes < 3 {
+		return fmt.Errorf("invalid flapping changes %d: must be at least 3, or 0 to disable", p.Changes)
+	}
+	if p.Window <= 0 {
+		p.Window = DefaultFlapWindow
+	}
+	flapPolicy = p
+	return nil
+}
+
+// collapseFlapping stores a failure or recovery event of a service
+// flapping, returning false when the service is not and the event is to
+// be stored as usual.
+//
+// The flapping event (flap_count > 0) replaces the events of the service
+// within the window when their number reaches the policy, and then
+// absorbs its following failures and recoveries, until none comes for a
+// window. It keeps the time of the first change, the last state, all the
+// event types and the highest severity; its message gives the number of
+// changes and how long the service has been flapping.
+func collapseFlapping(db queryer, hostID, service string, eventType, state int, severity string, at time.Time) (bool, error) {
+	if flapPolicy.Changes == 0 || (state != 0 && state != 1) {
+		return false, nil
+	}
+	since := at.Add(-flapPolicy.Window)
+
+	var id int64
+	var count int
+	var start time.Time
+	var types int
+	var highest string
+	err := db.QueryRow(`SELECT id, flap_count, created_at, COALESCE(event_type, 0), severity FROM events
+		WHERE host_id = ? AND service_name = ? AND flap_count > 0 AND flap_until >= ?
+		ORDER BY id DESC LIMIT 1`, hostID, service, since).Scan(&id, &count, &start, &types, &highest)
+	switch {
+	case err == nil:
+		_, err = db.Exec(`UPDATE events SET flap_count = ?, flap_until = ?, event_type = ?, state = ?, severity = ?, message = ?
+			WHERE id = ?`, count+1, at, types|eventType, state, higherSeverity(highest, severity),
+			flappingMessage(service, count+1, at.Sub(start)), id)
+		if err != nil {
+			return false, fmt.Errorf("failed to update flapping event of %s: %w", service, err)
+		}
+		return true, nil
+	case err != sql.ErrNoRows:
+		return false, fmt.Errorf("failed to look up flapping event of %s: %w", service, err)
+	}
+
+	// Not flapping yet: the changes within the window
+	rows, err := db.Query(`SELECT id, created_at, COALESCE(event_type, 0), severity FROM events
+		WHERE host_id = ? AND service_name = ? AND state IN (0, 1) AND flap_count = 0 AND created_at >= ?
+		ORDER BY created_at, id`, hostID, service, since)
+	if err != nil {
+		return false, fmt.Errorf("failed to look up events of %s: %w", service, err)
+	}
+	var ids []interface{}
+	start, types, highest = at, eventType, severity
+	for rows.Next() {
+		var eid int64
+		var createdAt time.Time
+		var t int
+		var s string
+		if err := rows.Scan(&eid, &createdAt, &t, &s); err != nil {
+			rows.Close()
+			return false, fmt.Errorf("failed to read events of %s: %w", service, err)
+		}
+		if len(ids) == 0 {
+			start = createdAt
+		}
+		ids = append(ids, eid)
+		types |= t
+		highest = higherSeverity(highest, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return false, fmt.Errorf("failed to read events of %s: %w", service, err)
+	}
+	count = len(ids) + 1
+	if count < flapPolicy.Changes {
+		return false, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	_, err = db.Exec("DELETE FROM events WHERE id IN ("+placeholders+")", ids...)
+	if err != nil {
+		return false, fmt.Errorf("failed to collapse events of %s: %w", service, err)
+	}
+	_, err = db.Exec(`INSERT INTO events (host_id, service_name, event_type, message, created_at, state, severity, flap_count, flap_until)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		hostID, service, types, flappingMessage(service, count, at.Sub(start)), start, state, highest, count, at)
+	if err != nil {
+		return false, fmt.Errorf("failed to store flapping event of %s: %w", service, err)
+	}
+	return true, nil
+}
+
+// flappingMessage is the message of a flapping event.
+func flappingMessage(service string, changes int, d time.Duration) string {
+	return fmt.Sprintf("%s is flapping: %d failures and recoveries in %s", service, changes, d.Round(time.Second))
+}
+
+// higherSeverity returns the highest of two severities.
+func higherSeverity(a, b string) string {
+	if slices.Index(Severities, b) > slices.Index(Severities, a) {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,37 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+// TestSetFlapPolicy checks the policies accepted and the default window.
+func TestSetFlapPolicy(t *testing.T) {
+	defer SetFlapPolicy(FlapPolicy{})
+
+	if err := SetFlapPolicy(FlapPolicy{Changes: 2}); err == nil {
+		t.Error("SetFlapPolicy(2 changes): no error")
+	}
+	if err := SetFlapPolicy(FlapPolicy{Changes: 5}); err != nil || flapPolicy.Window != DefaultFlapWindow {
+		t.Errorf("SetFlapPolicy(5 changes) = %v, window %v", err, flapPolicy.Window)
+	}
+}
+
+// TestFlappingMessage checks the message of the flapping events, and the
+// severity they keep.
+func TestFlappingMessage(t *testing.T) {
+	want := "nginx is flapping: 6 failures and recoveries in 4m12s"
+	if got := flappingMessage("nginx", 6, 4*time.Minute+12*time.Second+300*time.Millisecond); got != want {
+		t.Errorf("flappingMessage() = %q, want %q", got, want)
+	}
+
+	for _, tt := range [][3]string{
+		{SeverityInfo, SeverityCritical, SeverityCritical},
+		{SeverityCritical, SeverityWarning, SeverityCritical},
+		{SeverityWarning, SeverityInfo, SeverityWarning},
+	} {
+		if got := higherSeverity(tt[0], tt[1]); got != tt[2] {
+			t.Errorf("higherSeverity(%s, %s) = %s, want %s", tt[0], tt[1], got, tt[2])
+		}
+	}
+}
@@ -0,0 +1,254 @@
+// Package db - hostpurge.go archives the hosts that stopped reporting, then
+// deletes them with their history ([retention] archive_hosts and
+// delete_hosts), recording warning events before.
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// HostPurgeSource is the source of the events of the host retention.
+const HostPurgeSource = "retention"
+
+// DefaultDeleteWarning is the DeleteWarning of HostPurge without one.
+const DefaultDeleteWarning = 7 * 24 * time.Hour
+
+// deleteWarningRepeat is how often the warning of a coming deletion is
+// recorded again.
+const deleteWarningRepeat = 24 * time.Hour
+
+// HostPurge sets when PurgeHosts archives and deletes the hosts, from the
+// time of their last report.
+type HostPurge struct {
+	// ArchiveAfter archives the hosts not reporting for this long, unless
+	// unarchived by hand since their last report; 0 never archives
+	ArchiveAfter time.Duration
+
+	// DeleteAfter deletes the archived hosts, by the retention or by hand,
+	// not reporting for this long, and warned for DeleteWarning; 0 never
+	// deletes
+	DeleteAfter time.Duration
+
+	// DeleteWarning records a warning event on the archived hosts, once a
+	// day, from this long before their deletion, which comes no sooner
+	// than this long after the first warning; 0 means the default
+	// (DefaultDeleteWarning)
+	DeleteWarning time.Duration
+}
+
+// Enabled reports whether the policy archives or deletes hosts.
+func (p HostPurge) Enabled() bool {
+	return p.ArchiveAfter > 0 || p.DeleteAfter > 0
+}
+
+// PurgeHosts applies the host retention p at now: it archives the silent
+// hosts, records the warnings of the coming deletions and deletes the
+// archived hosts due. The archives and deletions are recorded in the audit
+// log. Returns the number of hosts archived and deleted.
+func PurgeHosts(db *sql.DB, p HostPurge, now time.Time) (archived, deleted int, err error) {
+	if p.DeleteWarning <= 0 {
+		p.DeleteWarning = DefaultDeleteWarning
+	}
+
+	hosts, err := purgeCandidates(db)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, h := range hosts {
+		switch {
+		case h.ArchivedAt.IsZero():
+			if p.ArchiveAfter <= 0 || !h.LastSeen.Before(now.Add(-p.ArchiveAfter)) ||
+				h.UnarchivedAt.After(h.LastSeen) {
+				continue
+			}
+			if err := archiveHost(db, p, h, now); err != nil {
+				return archived, deleted, err
+			}
+			archived++
+		case p.DeleteAfter > 0:
+			done, err := deleteHost(db, p, h, now)
+			if err != nil {
+				return archived, deleted, err
+			}
+			if done {
+				deleted++
+			}
+		}
+	}
+	return archived, deleted, nil
+}
+
+// purgeCandidate is a host of the host retention.
+type purgeCandidate struct {
+	ID           string
+	Hostname     string
+	LastSeen     time.Time
+	ArchivedAt   time.Time // Zero if not archived
+	UnarchivedAt time.Time // Zero if never unarchived by hand
+}
+
+// purgeCandidates returns the hosts with their last report and archive
+// times. The stored times are compared in Go: SQLite cannot parse them.
+func purgeCandidates(db *sql.DB) ([]purgeCandidate, error) {
+	rows, err := db.Query(`
+		SELECT id, hostname,
+		       COALESCE(CAST(last_seen AS TEXT), ''),
+		       COALESCE(CAST(archived_at AS TEXT), ''),
+		       COALESCE(CAST(unarchived_at AS TEXT), '')
+		FROM hosts
+		ORDER BY hostname`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query hosts: %w", err)
+	}
+	defer rows.Close()
+
+	var hosts []purgeCandidate
+	for rows.Next() {
+		var h purgeCandidate
+		var lastSeen, archivedAt, unarchivedAt string
+		if err := rows.Scan(&h.ID, &h.Hostname, &lastSeen, &archivedAt, &unarchivedAt); err != nil {
+			return nil, err
+		}
+		for _, t := range []struct {
+			dst  *time.Time
+			text string
+		}{{&h.LastSeen, lastSeen}, {&h.ArchivedAt, archivedAt}, {&h.UnarchivedAt, unarchivedAt}} {
+			if *t.dst, err = parseStoredTime(t.text); err != nil {
+				return nil, fmt.Errorf("host %s: %w", h.Hostname, err)
+			}
+		}
+		hosts = append(hosts, h)
+	}
+	return hosts, rows.Err()
+}
+
+// purgeWarnings returns the times of the first and of the last retention
+// events of the host since its archive time, zero without any.
+func purgeWarnings(db *sql.DB, h purgeCandidate) (first, last time.Time, err error) {
+	rows, err := db.Query("SELECT CAST(created_at AS TEXT) FROM events WHERE host_id = ? AND source = ?", h.ID, HostPurgeSource)
+	if err != nil {
+		return first, last, fmt.Errorf("failed to query the events of host %s: %w", h.Hostname, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var text string
+		if err := rows.Scan(&text); err != nil {
+			return first, last, err
+		}
+		t, err := parseStoredTime(text)
+		if err != nil {
+			return first, last, fmt.Errorf("event of host %s: %w", h.Hostname, err)
+		}
+		if t.Before(h.ArchivedAt) {
+			continue // Previous archive
+		}
+		if first.IsZero() || t.Before(first) {
+			first = t
+		}
+		if t.After(last) {
+			last = t
+		}
+	}
+	return first, last, rows.Err()
+}
+
+// deleteTime returns when the archived host h is deleted, given its first
+// warning at first (zero: warned at now).
+func (p HostPurge) deleteTime(h purgeCandidate, first, now time.Time) time.Time {
+	if first.IsZero() {
+		first = now
+	}
+	deleteAt := h.LastSeen.Add(p.DeleteAfter)
+	if warned := first.Add(p.DeleteWarning); warned.After(deleteAt) {
+		return warned
+	}
+	return deleteAt
+}
+
+// archiveHost archives the host h, not reporting for p.ArchiveAfter, with a
+// warning event telling when it will be deleted.
+func archiveHost(db *sql.DB, p HostPurge, h purgeCandidate, now time.Time) error {
+	if _, err := SetHostArchived(db, h.ID, true, now); err != nil {
+		return err
+	}
+
+	message := fmt.Sprintf("Host archived: no report since %s", h.LastSeen.Format(time.DateTime))
+	if p.DeleteAfter > 0 {
+		message += fmt.Sprintf("; it will be deleted with its history on %s", p.deleteTime(h, now, now).Format(time.DateOnly))
+	}
+	log.Printf("[INFO] %s: %s", h.Hostname, message)
+	if _, err := StoreExternalEvent(db, ExternalEvent{
+		HostID:    h.ID,
+		Service:   h.Hostname,
+		Message:   message,
+		Severity:  SeverityWarning,
+		Source:    HostPurgeSource,
+		CreatedAt: now,
+	}); err != nil {
+		// Without the event, the deletion waits for the next warning
+		log.Printf("[ERROR] %v", err)
+	}
+	if err := RecordAudit(db, AuditEntry{
+		CreatedAt: now,
+		Actor:     HostPurgeSource,
+		Action:    AuditHostArchive,
+		Target:    h.ID,
+		Details:   message,
+		Success:   true,
+	}); err != nil {
+		log.Printf("[ERROR] %v", err)
+	}
+	return nil
+}
+
+// deleteHost deletes the archived host h, with its history, once due: not
+// reporting for p.DeleteAfter and warned for p.DeleteWarning. Until then, it
+// records a warning event, once a day, from p.DeleteWarning before the
+// deletion. Returns whether h was deleted.
+func deleteHost(db *sql.DB, p HostPurge, h purgeCandidate, now time.Time) (bool, error) {
+	first, last, err := purgeWarnings(db, h)
+	if err != nil {
+		return false, err
+	}
+	deleteAt := p.deleteTime(h, first, now)
+
+	if first.IsZero() || now.Before(deleteAt) {
+		if now.Before(deleteAt.Add(-p.DeleteWarning)) || now.Before(last.Add(deleteWarningRepeat)) {
+			return false, nil
+		}
+		message := fmt.Sprintf("Archived host not reporting since %s: it will be deleted with its history on %s",
+			h.LastSeen.Format(time.DateTime), deleteAt.Format(time.DateOnly))
+		_, err := StoreExternalEvent(db, ExternalEvent{
+			HostID:    h.ID,
+			Service:   h.Hostname,
+			Message:   message,
+			Severity:  SeverityWarning,
+			Source:    HostPurgeSource,
+			CreatedAt: now,
+		})
+		return false, err
+	}
+
+	stats, err := DeleteHost(db, h.ID)
+	if err != nil {
+		return false, err
+	}
+	details := fmt.Sprintf("no report since %s; %d services, %d events removed",
+		h.LastSeen.Format(time.DateTime), stats.Services, stats.Events)
+	log.Printf("[INFO] %s: host deleted, %s", h.Hostname, details)
+	if err := RecordAudit(db, AuditEntry{
+		CreatedAt: now,
+		Actor:     HostPurgeSource,
+		Action:    AuditHostDelete,
+		Target:    h.ID,
+		Details:   details,
+		Success:   true,
+	}); err != nil {
+		log.Printf("[ERROR] %v", err)
+	}
+	return true, nil
+}
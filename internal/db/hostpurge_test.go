@@ -0,0 +1,130 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+)
+
+// purgeEvents returns the messages of the retention events of a host.
+func purgeEvents(t *testing.T, db *sql.DB, hostID string) []string {
+	t.Helper()
+	rows, err := db.Query("SELECT message FROM events WHERE host_id = ? AND source = ? ORDER BY id", hostID, HostPurgeSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var messages []string
+	for rows.Next() {
+		var message string
+		if err := rows.Scan(&message); err != nil {
+			t.Fatal(err)
+		}
+		messages = append(messages, message)
+	}
+	return messages
+}
+
+// hostExists reports whether the host is still stored.
+func hostExists(t *testing.T, db *sql.DB, hostID string) bool {
+	t.Helper()
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM hosts WHERE id = ?", hostID).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n > 0
+}
+
+// TestPurgeHosts runs the host retention daily on hosts stored by the
+// collector: the silent hosts are archived, warned, then deleted no sooner
+// than DeleteWarning after their first warning.
+func TestPurgeHosts(t *testing.T) {
+	db := testDB(t)
+	now := time.Now()
+	day := 24 * time.Hour
+	storeTestHost(t, db, "web1-0", "web1", time.Time{})
+	storeTestHost(t, db, "web2-0", "web2", now.Add(-31*day))
+	storeTestHost(t, db, "web3-0", "web3", now.Add(-60*day))
+	storeTestHost(t, db, "web4-0", "web4", now.Add(-60*day))
+	storeTestHost(t, db, "web5-0", "web5", now.Add(-60*day))
+	for _, archive := range []struct {
+		id       string
+		archived bool
+		at       time.Time
+	}{
+		{"web4-0", true, now.Add(-2 * time.Hour)},
+		{"web4-0", false, now.Add(-time.Hour)}, // Unarchived by hand
+		{"web5-0", true, now.Add(-day)},        // Archived by hand
+	} {
+		if _, err := SetHostArchived(db, archive.id, archive.archived, archive.at); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p := HostPurge{ArchiveAfter: 30 * day, DeleteAfter: 40 * day, DeleteWarning: 7 * day}
+
+	for _, pass := range []struct {
+		days              int
+		archived, deleted int
+		events            map[string]int // Retention events per host, -1: deleted
+	}{
+		{0, 2, 0, map[string]int{"web2-0": 1, "web3-0": 1, "web5-0": 1}},
+		{1, 0, 0, map[string]int{"web2-0": 1, "web3-0": 2, "web5-0": 2}},
+		{2, 0, 0, map[string]int{"web2-0": 2, "web3-0": 3, "web5-0": 3}},
+		{7, 0, 2, map[string]int{"web2-0": 3, "web3-0": -1, "web5-0": -1}},
+		{9, 0, 1, map[string]int{"web2-0": -1, "web3-0": -1, "web5-0": -1}},
+	} {
+		at := now.Add(time.Duration(pass.days) * day)
+		archived, deleted, err := PurgeHosts(db, p, at)
+		if err != nil {
+			t.Fatalf("day %d: %v", pass.days, err)
+		}
+		if archived != pass.archived || deleted != pass.deleted {
+			t.Errorf("day %d: archived %d, deleted %d; want %d, %d", pass.days, archived, deleted, pass.archived, pass.deleted)
+		}
+		for _, id := range []string{"web1-0", "web4-0"} {
+			if !hostExists(t, db, id) || len(purgeEvents(t, db, id)) != 0 {
+				t.Errorf("day %d: %s touched by the retention", pass.days, id)
+			}
+		}
+		for id, want := range pass.events {
+			if want < 0 {
+				if hostExists(t, db, id) {
+					t.Errorf("day %d: %s not deleted", pass.days, id)
+				}
+				continue
+			}
+			if !hostExists(t, db, id) {
+				t.Errorf("day %d: %s deleted", pass.days, id)
+			}
+			if got := purgeEvents(t, db, id); len(got) != want {
+				t.Errorf("day %d: %s events %q, want %d", pass.days, id, got, want)
+			}
+		}
+
+		if pass.days == 0 {
+			// The deletion dates are never before the end of the warning
+			for id, deleteAt := range map[string]time.Time{
+				"web2-0": now.Add(9 * day),
+				"web3-0": now.Add(7 * day),
+				"web5-0": now.Add(7 * day),
+			} {
+				events := purgeEvents(t, db, id)
+				if !strings.HasSuffix(events[0], "deleted with its history on "+deleteAt.Format(time.DateOnly)) {
+					t.Errorf("%s: got %q, want a deletion on %s", id, events[0], deleteAt.Format(time.DateOnly))
+				}
+			}
+			if events := purgeEvents(t, db, "web3-0"); !strings.HasPrefix(events[0], "Host archived") {
+				t.Errorf("web3: got %q, want the archive event", events[0])
+			}
+		}
+	}
+
+	archivedHosts, err := ArchivedHosts(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archivedHosts) != 0 {
+		t.Errorf("ArchivedHosts = %+v, want none", archivedHosts)
+	}
+}
@@ -0,0 +1,187 @@
+// Package db - hosts.go reads when each host last reported and the state
+// of its services, for the jobs watching the hosts (SNMP traps, MQTT
+// state), and archives the hosts taken out of service.
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// storedTimeLayout is the text format the SQLite driver writes time.Time
+// values in: Go's time.Time.String, which SQLite's date functions cannot
+// parse (strftime returns NULL).
+const storedTimeLayout = "2006-01-02 15:04:05.999999999 -0700 MST"
+
+// parseStoredTime parses a time column read as text (CAST(... AS TEXT)):
+// a time.Time written by the driver, without the monotonic clock reading
+// it may end with (" m=+0.017"), or a UTC CURRENT_TIMESTAMP of SQLite.
+// NULL, read as "", is the zero time.
+func parseStoredTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if i := strings.Index(s, " m="); i >= 0 {
+		s = s[:i]
+	}
+	if t, err := time.Parse(storedTimeLayout, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.DateTime, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid stored time %q", s)
+	}
+	return t, nil
+}
+
+// HostSeen is a host with its last report.
+type HostSeen struct {
+	ID           string
+	Hostname     string
+	LastSeen     time.Time
+	PollInterval int64 // Seconds
+}
+
+// Offline reports whether the host missed its reports at now: as the red
+// availability status, last seen 4 poll intervals ago or more.
+func (h HostSeen) Offline(now time.Time) bool {
+	return h.PollInterval > 0 && now.Sub(h.LastSeen) >= 4*time.Duration(h.PollInterval)*time.Second
+}
+
+// HostsSeen returns every host but the archived ones with its last report.
+func HostsSeen(db *sql.DB) ([]HostSeen, error) {
+	rows, err := db.Query(`
+		SELECT id, hostname, COALESCE(CAST(last_seen AS TEXT), ''), poll_interval
+		FROM hosts
+		WHERE archived_at IS NULL
+		ORDER BY hostname`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hosts []HostSeen
+	for rows.Next() {
+		var h HostSeen
+		var lastSeen string
+		if err := rows.Scan(&h.ID, &h.Hostname, &lastSeen, &h.PollInterval); err != nil {
+			return nil, err
+		}
+		if h.LastSeen, err = parseStoredTime(lastSeen); err != nil {
+			return nil, fmt.Errorf("host %s: %w", h.ID, err)
+		}
+		hosts = append(hosts, h)
+	}
+	return hosts, rows.Err()
+}
+
+// ServiceState is the current state of a service.
+type ServiceState struct {
+	HostID   string
+	Hostname string
+	Service  string
+	Type     int // Monit service type, e.g. 5 for system
+	Status   int // Monit error bits, 0 when OK
+	Monitor  int // 0 not monitored, 1 monitored, 2 initializing
+}
+
+// ServiceStates returns the state of every service of the hosts not
+// archived, by host.
+func ServiceStates(db *sql.DB) ([]ServiceState, error) {
+	rows, err := db.Query(`
+		SELECT s.host_id, h.hostname, s.name, s.type, COALESCE(s.status, 0), COALESCE(s.monitor, 0)
+		FROM services s
+		JOIN hosts h ON h.id = s.host_id
+		WHERE h.archived_at IS NULL
+		ORDER BY h.hostname, s.name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var states []ServiceState
+	for rows.Next() {
+		var s ServiceState
+		if err := rows.Scan(&s.HostID, &s.Hostname, &s.Service, &s.Type, &s.Status, &s.Monitor); err != nil {
+			return nil, err
+		}
+		states = append(states, s)
+	}
+	return states, rows.Err()
+}
+
+// ArchivedHost is a host taken out of service. Its history is kept until
+// it is deleted; it is unarchived when it reports again.
+type ArchivedHost struct {
+	ID         string    `json:"id"`
+	Hostname   string    `json:"hostname"`
+	LastSeen   time.Time `json:"last_seen"`
+	ArchivedAt time.Time `json:"archived_at"`
+	Services   int       `json:"services"` // Number of services kept
+}
+
+// ArchivedHosts returns the archived hosts, most recently archived first.
+func ArchivedHosts(db *sql.DB) ([]ArchivedHost, error) {
+	rows, err := db.Query(`
+		SELECT h.id, h.hostname,
+		       COALESCE(CAST(h.last_seen AS TEXT), ''),
+		       CAST(h.archived_at AS TEXT),
+		       (SELECT COUNT(*) FROM services s WHERE s.host_id = h.id)
+		FROM hosts h
+		WHERE h.archived_at IS NOT NULL
+		ORDER BY h.hostname`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hosts []ArchivedHost
+	for rows.Next() {
+		var h ArchivedHost
+		var lastSeen, archivedAt string
+		if err := rows.Scan(&h.ID, &h.Hostname, &lastSeen, &archivedAt, &h.Services); err != nil {
+			return nil, err
+		}
+		if h.LastSeen, err = parseStoredTime(lastSeen); err != nil {
+			return nil, fmt.Errorf("host %s: %w", h.Hostname, err)
+		}
+		if h.ArchivedAt, err = parseStoredTime(archivedAt); err != nil {
+			return nil, fmt.Errorf("host %s: %w", h.Hostname, err)
+		}
+		hosts = append(hosts, h)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// The stored times do not sort as text
+	sort.SliceStable(hosts, func(i, j int) bool { return hosts[i].ArchivedAt.After(hosts[j].ArchivedAt) })
+	return hosts, nil
+}
+
+// SetHostArchived archives the host at now, or unarchives it. Archiving an
+// archived host keeps its archive time; unarchiving records now, so that
+// the retention does not archive the host again before it reports.
+// Returns false if the host is unknown.
+func SetHostArchived(db *sql.DB, hostID string, archived bool, now time.Time) (bool, error) {
+	var (
+		result sql.Result
+		err    error
+	)
+	if archived {
+		result, err = db.Exec("UPDATE hosts SET archived_at = COALESCE(archived_at, ?) WHERE id = ?", now, hostID)
+	} else {
+		result, err = db.Exec("UPDATE hosts SET archived_at = NULL, unarchived_at = ? WHERE id = ?", now, hostID)
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to update host %s: %w", hostID, err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,125 @@
+package db
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ocochard/cmonit/internal/parser"
+)
+
+// testDB returns a new database in a temporary directory.
+func testDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := InitDB(filepath.Join(t.TempDir(), "cmonit.db"))
+	if err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// storeTestHost stores a host as the collector does, then moves its last
+// report to lastSeen (zero: keep now).
+func storeTestHost(t *testing.T, db *sql.DB, id, hostname string, lastSeen time.Time) {
+	t.Helper()
+	server := &parser.Server{ID: id, LocalHostname: hostname, Poll: 30, Uptime: 3600, HTTPD: parser.HTTPDInfo{Port: 2812}}
+	platform := &parser.Platform{Name: "FreeBSD", CPU: 4, Memory: 4 << 20}
+	if err := StoreHost(db, server, platform, nil); err != nil {
+		t.Fatalf("StoreHost(%s): %v", id, err)
+	}
+	if !lastSeen.IsZero() {
+		if _, err := db.Exec("UPDATE hosts SET last_seen = ? WHERE id = ?", lastSeen, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestParseStoredTime checks the times written by the driver, with and
+// without the monotonic clock reading, and by SQLite.
+func TestParseStoredTime(t *testing.T) {
+	want := time.Date(2026, 10, 16, 9, 32, 7, 80000000, time.UTC)
+	for _, s := range []string{
+		"2026-10-16 09:32:07.08 +0000 UTC m=+0.017",
+		"2026-10-16 09:32:07.08 +0000 UTC",
+		"2026-10-16 11:32:07.08 +0200 CEST",
+	} {
+		got, err := parseStoredTime(s)
+		if err != nil || !got.Equal(want) {
+			t.Errorf("parseStoredTime(%q) = %v, %v; want %v", s, got, err, want)
+		}
+	}
+
+	if got, err := parseStoredTime("2026-10-16 09:32:07"); err != nil || !got.Equal(want.Truncate(time.Second)) {
+		t.Errorf("CURRENT_TIMESTAMP = %v, %v", got, err)
+	}
+	if got, err := parseStoredTime(""); err != nil || !got.IsZero() {
+		t.Errorf("NULL = %v, %v; want the zero time", got, err)
+	}
+	if _, err := parseStoredTime("yesterday"); err == nil {
+		t.Error("invalid time accepted")
+	}
+}
+
+// TestHostsSeen checks the last reports read back from hosts stored by the
+// collector, and the offline hosts.
+func TestHostsSeen(t *testing.T) {
+	db := testDB(t)
+	now := time.Now()
+	storeTestHost(t, db, "web1-0", "web1", time.Time{})
+	storeTestHost(t, db, "web2-0", "web2", now.Add(-10*time.Minute))
+	storeTestHost(t, db, "web3-0", "web3", now.Add(-time.Hour))
+	if _, err := SetHostArchived(db, "web3-0", true, now); err != nil {
+		t.Fatal(err)
+	}
+
+	hosts, err := HostsSeen(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 2 || hosts[0].ID != "web1-0" || hosts[1].ID != "web2-0" {
+		t.Fatalf("HostsSeen = %+v, want web1 and web2 (web3 archived)", hosts)
+	}
+	if d := now.Sub(hosts[0].LastSeen); d < -time.Second || d > time.Minute {
+		t.Errorf("web1 last seen %v, want about now (%v)", hosts[0].LastSeen, now)
+	}
+	if hosts[0].Offline(now) {
+		t.Error("web1, reporting, is offline")
+	}
+	if !hosts[1].LastSeen.Equal(now.Add(-10*time.Minute).Round(0)) || !hosts[1].Offline(now) {
+		t.Errorf("web2 last seen %v, offline %t; want %v, offline", hosts[1].LastSeen, hosts[1].Offline(now), now.Add(-10*time.Minute))
+	}
+}
+
+// TestArchivedHosts checks the archived hosts, most recently archived
+// first, with their last report and archive times.
+func TestArchivedHosts(t *testing.T) {
+	db := testDB(t)
+	now := time.Now()
+	storeTestHost(t, db, "web1-0", "web1", time.Time{})
+	storeTestHost(t, db, "web2-0", "web2", now.Add(-48*time.Hour))
+	storeTestHost(t, db, "web3-0", "web3", now.Add(-72*time.Hour))
+	for id, archivedAt := range map[string]time.Time{"web2-0": now.Add(-time.Hour), "web3-0": now.Add(-time.Minute)} {
+		if _, err := SetHostArchived(db, id, true, archivedAt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hosts, err := ArchivedHosts(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 2 || hosts[0].ID != "web3-0" || hosts[1].ID != "web2-0" {
+		t.Fatalf("ArchivedHosts = %+v, want web3 then web2", hosts)
+	}
+	if !hosts[0].LastSeen.Equal(now.Add(-72*time.Hour).Round(0)) || !hosts[0].ArchivedAt.Equal(now.Add(-time.Minute).Round(0)) {
+		t.Errorf("web3 last seen %v, archived %v", hosts[0].LastSeen, hosts[0].ArchivedAt)
+	}
+	if !hosts[1].LastSeen.Equal(now.Add(-48*time.Hour).Round(0)) || !hosts[1].ArchivedAt.Equal(now.Add(-time.Hour).Round(0)) {
+		t.Errorf("web2 last seen %v, archived %v", hosts[1].LastSeen, hosts[1].ArchivedAt)
+	}
+	if hosts[0].Services != 0 {
+		t.Errorf("web3 services = %d, want 0", hosts[0].Services)
+	}
+}
@@ -0,0 +1,93 @@
+// Package db - maintenance.go holds the database maintenance operations of
+// the "cmonit db" commands: backup and integrity check.
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+)
+
+// OpenExisting opens an existing database without creating or migrating
+// it, so that maintenance commands never change the schema of the database
+// they inspect.
+func OpenExisting(dbPath string) (*sql.DB, error) {
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil, fmt.Errorf("database not found: %w", err)
+	}
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+	return db, nil
+}
+
+// Backup writes a consistent copy of the database to path (VACUUM INTO),
+// while the server may keep writing to it. path must not exist.
+//
+// The secret key file (see SetSecretKeyFile) is not part of the copy.
+func Backup(db *sql.DB, path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s already exists", path)
+	}
+	if _, err := db.Exec("VACUUM INTO ?", path); err != nil {
+		return fmt.Errorf("failed to back up database: %w", err)
+	}
+	return nil
+}
+
+// Check returns the problems of a database: SQLite integrity errors,
+// foreign keys pointing nowhere, and a schema version this binary does not
+// know (newer) or has not migrated yet (older, fixed at the next start).
+func Check(db *sql.DB) ([]string, error) {
+	var problems []string
+
+	rows, err := db.Query("PRAGMA integrity_check")
+	if err != nil {
+		return nil, fmt.Errorf("failed to check integrity: %w", err)
+	}
+	for rows.Next() {
+		var result string
+		if err := rows.Scan(&result); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if result != "ok" {
+			problems = append(problems, "integrity: "+result)
+		}
+	}
+	rows.Close()
+
+	rows, err = db.Query("PRAGMA foreign_key_check")
+	if err != nil {
+		return nil, fmt.Errorf("failed to check foreign keys: %w", err)
+	}
+	for rows.Next() {
+		var table, parent string
+		var rowID sql.NullInt64
+		var fkID int
+		if err := rows.Scan(&table, &rowID, &parent, &fkID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		problems = append(problems, fmt.Sprintf("foreign key: row %d of %s references a missing %s", rowID.Int64, table, parent))
+	}
+	rows.Close()
+
+	version, err := getSchemaVersion(db)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case version > currentSchemaVersion:
+		problems = append(problems, fmt.Sprintf("schema version %d is newer than this binary (%d)", version, currentSchemaVersion))
+	case version < currentSchemaVersion:
+		problems = append(problems, fmt.Sprintf("schema version %d is not migrated to %d yet (done at the next start)", version, currentSchemaVersion))
+	}
+
+	return problems, nil
+}
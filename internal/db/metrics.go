@@ -0,0 +1,54 @@
+// Package db - metrics.go reads the new rows of the metrics table for the
+// metric sinks (remote_write...), as EventsAfter does for the event sinks.
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// StoredMetric is a data point as stored by StoreMetric, with the hostname.
+type StoredMetric struct {
+	ID          int64
+	HostID      string
+	Hostname    string
+	Service     string
+	Type        string // e.g. "cpu"
+	Name        string // e.g. "user"
+	Value       float64
+	CollectedAt time.Time
+}
+
+// LastMetricID returns the id of the newest data point, 0 without any.
+func LastMetricID(db *sql.DB) (int64, error) {
+	var id int64
+	err := db.QueryRow("SELECT COALESCE(MAX(id), 0) FROM metrics").Scan(&id)
+	return id, err
+}
+
+// MetricsAfter returns at most limit data points stored after the data
+// point afterID, oldest first: ids grow with each insert, as SQLite
+// serializes the transactions writing them.
+func MetricsAfter(db *sql.DB, afterID int64, limit int) ([]StoredMetric, error) {
+	rows, err := db.Query(`
+		SELECT m.id, m.host_id, h.hostname, m.service_name, m.metric_type, m.metric_name, m.value, m.collected_at
+		FROM metrics m
+		JOIN hosts h ON h.id = m.host_id
+		WHERE m.id > ?
+		ORDER BY m.id
+		LIMIT ?`, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var metrics []StoredMetric
+	for rows.Next() {
+		var m StoredMetric
+		if err := rows.Scan(&m.ID, &m.HostID, &m.Hostname, &m.Service, &m.Type, &m.Name, &m.Value, &m.CollectedAt); err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics, rows.Err()
+}
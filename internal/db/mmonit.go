@@ -0,0 +1,363 @@
+// Package db - mmonit.go contains the import of an M/Monit database
+// ("cmonit import-mmonit"), for users moving from M/Monit: its hosts with
+// their Monit agent credentials, host groups and events.
+//
+// Only SQLite databases (the M/Monit default, db/mmonit.db) can be read:
+// cmonit has no MySQL or PostgreSQL driver. The M/Monit tables are read
+// through the columns they have, so that small schema differences between
+// M/Monit versions only lose the missing data:
+//
+//	host           id, monitid, hostname, ipaddr, monituser, monitpassword,
+//	               monitport, monitssl, incarnation, description
+//	hostgroup      id, name
+//	hostgroup*     hostgroupid, hostid (membership, any table name)
+//	event          hostid, collectedsec, event, message, and the service
+//	               name as servicename/service, or servicenameid/serviceid
+//	               referencing a name table
+//
+// Statistics (the time series of M/Monit) are not imported: cmonit fills
+// its own from the next status reports of the agents.
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MMonitImport counts what ImportMMonit did.
+type MMonitImport struct {
+	Hosts      int
+	HostGroups int
+	Events     int
+	Skipped    []string // Hosts left out, with the reason
+}
+
+// OpenMMonit opens the M/Monit database dsn read-only: a file path, or a
+// "sqlite://" or "file:" URL. Other databases are refused.
+func OpenMMonit(dsn string) (*sql.DB, error) {
+	path := dsn
+	switch {
+	case strings.HasPrefix(dsn, "sqlite://"):
+		path = strings.TrimPrefix(dsn, "sqlite://")
+	case strings.HasPrefix(dsn, "file:"):
+		path, _, _ = strings.Cut(strings.TrimPrefix(dsn, "file:"), "?")
+	case strings.HasPrefix(dsn, "mysql:"), strings.HasPrefix(dsn, "postgresql:"), strings.HasPrefix(dsn, "postgres:"):
+		return nil, fmt.Errorf("only SQLite M/Monit databases can be imported: dump it to SQLite first")
+	}
+
+	db, err := OpenExisting(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec("PRAGMA query_only = ON"); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open %s read-only: %w", path, err)
+	}
+	return db, nil
+}
+
+// ImportMMonit copies the hosts, host groups and events of the M/Monit
+// database src into db, in a single transaction.
+//
+// Hosts are identified by their Monit ID, like when their agent reports.
+// Hosts already in db, or without a Monit ID, are skipped with their
+// events; importing twice thus changes nothing.
+func ImportMMonit(db, src *sql.DB) (*MMonitImport, error) {
+	hostCols, err := sourceColumns(src, "host")
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range []string{"id", "monitid", "hostname"} {
+		if !hostCols[c] {
+			return nil, fmt.Errorf("not an M/Monit database: table host has no %s column", c)
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result := &MMonitImport{}
+
+	// M/Monit host id -> Monit ID of the imported hosts
+	imported, err := importMMonitHosts(tx, src, hostCols, result)
+	if err != nil {
+		return nil, err
+	}
+	if err := importMMonitHostGroups(tx, src, imported, result); err != nil {
+		return nil, err
+	}
+	if err := importMMonitEvents(tx, src, imported, result); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit import: %w", err)
+	}
+	return result, nil
+}
+
+// importMMonitHosts inserts the hosts of src. The Monit agent credentials
+// go where the agent report puts them, so that actions work before it
+// reports again. Until then, the hosts are last seen at their latest
+// event (see importMMonitEvents), or never, and shown as stale.
+func importMMonitHosts(tx *sql.Tx, src *sql.DB, cols map[string]bool, result *MMonitImport) (map[int64]string, error) {
+	optional := func(name, fallback string) string {
+		if cols[name] {
+			return name
+		}
+		return fallback
+	}
+	query := fmt.Sprintf("SELECT id, monitid, hostname, %s, %s, %s, %s, %s, %s, %s FROM host ORDER BY id",
+		optional("ipaddr", "''"), optional("monituser", "''"), optional("monitpassword", "''"),
+		optional("monitport", "0"), optional("monitssl", "0"), optional("incarnation", "0"),
+		optional("description", "''"))
+
+	rows, err := src.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read M/Monit hosts: %w", err)
+	}
+	defer rows.Close()
+
+	imported := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var monitID, hostname, address, user, password, description sql.NullString
+		var port, ssl, incarnation sql.NullInt64
+		if err := rows.Scan(&id, &monitID, &hostname, &address, &user, &password, &port, &ssl, &incarnation, &description); err != nil {
+			return nil, fmt.Errorf("failed to read M/Monit host: %w", err)
+		}
+		name := hostname.String
+		if monitID.String == "" {
+			result.Skipped = append(result.Skipped, name+": no Monit ID")
+			continue
+		}
+
+		var existing string
+		err := tx.QueryRow("SELECT id FROM hosts WHERE id = ? OR hostname = ?", monitID.String, name).Scan(&existing)
+		if err == nil {
+			result.Skipped = append(result.Skipped, name+": already in the database")
+			continue
+		}
+		if err != sql.ErrNoRows {
+			return nil, fmt.Errorf("failed to check host %s: %w", name, err)
+		}
+
+		var httpPort interface{}
+		if port.Int64 > 0 && port.Int64 <= 65535 {
+			httpPort = port.Int64
+		}
+		if len(description.String) > 8192 {
+			description.String = description.String[:8192]
+		}
+		_, err = tx.Exec(`
+			INSERT INTO hosts (id, hostname, incarnation, http_address, http_port, http_ssl,
+				http_username, http_password, description, last_seen, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, monitID.String, name, max(incarnation.Int64, 0), address.String, httpPort, ssl.Int64 != 0,
+			user.String, password.String, description.String, time.Unix(0, 0).UTC(), time.Now())
+		if err != nil {
+			return nil, fmt.Errorf("failed to import host %s: %w", name, err)
+		}
+		imported[id] = monitID.String
+		result.Hosts++
+	}
+	return imported, rows.Err()
+}
+
+// importMMonitHostGroups adds the imported hosts to their groups. The
+// membership table is the one with hostgroupid and hostid columns.
+func importMMonitHostGroups(tx *sql.Tx, src *sql.DB, imported map[int64]string, result *MMonitImport) error {
+	groupCols, err := sourceColumns(src, "hostgroup")
+	if err != nil || !groupCols["id"] || !groupCols["name"] {
+		return err // No host groups in this M/Monit version
+	}
+
+	tables, err := sourceTables(src)
+	if err != nil {
+		return err
+	}
+	var membership string
+	for _, table := range tables {
+		cols, err := sourceColumns(src, table)
+		if err != nil {
+			return err
+		}
+		if cols["hostgroupid"] && cols["hostid"] {
+			membership = table
+			break
+		}
+	}
+	if membership == "" {
+		return nil
+	}
+
+	rows, err := src.Query(fmt.Sprintf(`
+		SELECT m.hostid, g.name FROM %q m JOIN hostgroup g ON g.id = m.hostgroupid
+		ORDER BY m.hostid, g.name`, membership))
+	if err != nil {
+		return fmt.Errorf("failed to read M/Monit host groups: %w", err)
+	}
+	defer rows.Close()
+
+	groups := make(map[string][]string)
+	for rows.Next() {
+		var hostID int64
+		var name string
+		if err := rows.Scan(&hostID, &name); err != nil {
+			return fmt.Errorf("failed to read M/Monit host group: %w", err)
+		}
+		if monitID, ok := imported[hostID]; ok {
+			groups[monitID] = append(groups[monitID], name)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for monitID, names := range groups {
+		if err := StoreHostGroups(tx, monitID, names); err != nil {
+			return err
+		}
+		result.HostGroups += len(names)
+	}
+	return nil
+}
+
+// importMMonitEvents copies the events of the imported hosts.
+func importMMonitEvents(tx *sql.Tx, src *sql.DB, imported map[int64]string, result *MMonitImport) error {
+	cols, err := sourceColumns(src, "event")
+	if err != nil || !cols["hostid"] {
+		return err
+	}
+
+	eventType := "0"
+	for _, c := range []string{"event", "type", "eventtype"} {
+		if cols[c] {
+			eventType = "e." + c
+			break
+		}
+	}
+	created := "0"
+	for _, c := range []string{"collectedsec", "created"} {
+		if cols[c] {
+			created = "e." + c
+			break
+		}
+	}
+	message := "''"
+	if cols["message"] {
+		message = "e.message"
+	}
+
+	// The service name is in the event, or in a table it references
+	service, join := "h.hostname", ""
+	switch {
+	case cols["servicename"]:
+		service = "e.servicename"
+	case cols["service"]:
+		service = "e.service"
+	default:
+		for _, ref := range []struct{ column, table string }{
+			{"servicenameid", "servicename"},
+			{"serviceid", "service"},
+		} {
+			refCols, err := sourceColumns(src, ref.table)
+			if err != nil {
+				return err
+			}
+			if cols[ref.column] && refCols["id"] && refCols["name"] {
+				service = "s.name"
+				join = fmt.Sprintf("LEFT JOIN %q s ON s.id = e.%s", ref.table, ref.column)
+				break
+			}
+		}
+	}
+
+	rows, err := src.Query(fmt.Sprintf(`
+		SELECT e.hostid, COALESCE(%s, h.hostname), %s, %s, %s
+		FROM event e JOIN host h ON h.id = e.hostid %s
+		ORDER BY %s`, service, eventType, message, created, join, created))
+	if err != nil {
+		return fmt.Errorf("failed to read M/Monit events: %w", err)
+	}
+	defer rows.Close()
+
+	stmt, err := tx.Prepare("INSERT INTO events (host_id, service_name, event_type, message, created_at, severity) VALUES (?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return fmt.Errorf("failed to prepare event insert: %w", err)
+	}
+	defer stmt.Close()
+
+	lastSeen := make(map[string]time.Time)
+	for rows.Next() {
+		var hostID, typ, collected sql.NullInt64
+		var serviceName, msg sql.NullString
+		if err := rows.Scan(&hostID, &serviceName, &typ, &msg, &collected); err != nil {
+			return fmt.Errorf("failed to read M/Monit event: %w", err)
+		}
+		monitID, ok := imported[hostID.Int64]
+		if !ok {
+			continue
+		}
+		at := time.Unix(collected.Int64, 0)
+		if _, err := stmt.Exec(monitID, serviceName.String, typ.Int64, msg.String, at, EventSeverity(int(typ.Int64), -1, -1)); err != nil {
+			return fmt.Errorf("failed to import event: %w", err)
+		}
+		lastSeen[monitID] = at // Events are in time order
+		result.Events++
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for monitID, at := range lastSeen {
+		if _, err := tx.Exec("UPDATE hosts SET last_seen = ? WHERE id = ?", at, monitID); err != nil {
+			return fmt.Errorf("failed to update host %s: %w", monitID, err)
+		}
+	}
+	return nil
+}
+
+// sourceTables returns the table names of src.
+func sourceTables(src *sql.DB) ([]string, error) {
+	rows, err := src.Query("SELECT name FROM sqlite_master WHERE type = 'table' ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list M/Monit tables: %w", err)
+	}
+	defer rows.Close()
+
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		tables = append(tables, name)
+	}
+	return tables, rows.Err()
+}
+
+// sourceColumns returns the lowercased column names of a table of src,
+// none if it does not exist.
+func sourceColumns(src *sql.DB, table string) (map[string]bool, error) {
+	rows, err := src.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read M/Monit table %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	cols := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		cols[strings.ToLower(name)] = true
+	}
+	return cols, rows.Err()
+}
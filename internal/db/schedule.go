@@ -0,0 +1,193 @@
+// Package db - schedule.go contains the service actions scheduled to run
+// later.
+//
+// A scheduled action runs once at run_at, or repeats daily or weekly: after
+// each run, run_at moves to the next occurrence. Runs are recorded in the
+// actions table like any other service action.
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Scheduled action statuses
+const (
+	SchedulePending   = "pending"   // Waiting for run_at
+	ScheduleDone      = "done"      // Ran (once)
+	ScheduleCancelled = "cancelled" // Cancelled before running
+)
+
+// Repeat intervals of scheduled actions
+const (
+	RepeatOnce   = ""
+	RepeatDaily  = "daily"
+	RepeatWeekly = "weekly"
+)
+
+// ScheduledAction is a service action to run later.
+type ScheduledAction struct {
+	ID           int64      `json:"id"`
+	HostID       string     `json:"host_id"`
+	Hostname     string     `json:"hostname"`
+	Service      string     `json:"service"`
+	Action       string     `json:"action"`
+	RunAt        time.Time  `json:"run_at"` // Next run
+	Repeat       string     `json:"repeat"` // One of the Repeat* constants
+	Status       string     `json:"status"` // One of the Schedule* constants
+	CreatedBy    string     `json:"created_by"`
+	CreatedAt    time.Time  `json:"created_at"`
+	CancelledBy  string     `json:"cancelled_by,omitempty"`
+	LastRunAt    *time.Time `json:"last_run_at,omitempty"`
+	LastSuccess  bool       `json:"last_success"`
+	LastMessage  string     `json:"last_message"`
+	LastActionID int64      `json:"last_action_id,omitempty"`
+}
+
+// NextRun returns the run following at for a repeat interval, in loc so
+// that daily and weekly runs keep their local time across DST changes.
+// It returns the zero time for RepeatOnce.
+func NextRun(at time.Time, repeat string, loc *time.Location) time.Time {
+	at = at.In(loc)
+	switch repeat {
+	case RepeatDaily:
+		return at.AddDate(0, 0, 1)
+	case RepeatWeekly:
+		return at.AddDate(0, 0, 7)
+	}
+	return time.Time{}
+}
+
+// scheduledActionColumns are the columns scanned by scanScheduledAction.
+const scheduledActionColumns = `s.id, s.host_id, COALESCE(h.hostname, ''), s.service, s.action,
+	s.run_at, s.repeat, s.status, s.created_by, s.created_at, s.cancelled_by,
+	s.last_run_at, s.last_success, s.last_message, s.last_action_id`
+
+// scanScheduledAction scans a row of scheduledActionColumns.
+func scanScheduledAction(row interface{ Scan(...interface{}) error }) (ScheduledAction, error) {
+	var s ScheduledAction
+	var lastRunAt sql.NullTime
+	err := row.Scan(&s.ID, &s.HostID, &s.Hostname, &s.Service, &s.Action,
+		&s.RunAt, &s.Repeat, &s.Status, &s.CreatedBy, &s.CreatedAt, &s.CancelledBy,
+		&lastRunAt, &s.LastSuccess, &s.LastMessage, &s.LastActionID)
+	if lastRunAt.Valid {
+		s.LastRunAt = &lastRunAt.Time
+	}
+	return s, err
+}
+
+// CreateScheduledAction stores a pending scheduled action and returns its
+// ID.
+func CreateScheduledAction(db *sql.DB, s ScheduledAction) (int64, error) {
+	result, err := db.Exec(`
+		INSERT INTO scheduled_actions (host_id, service, action, run_at, repeat, status, created_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, s.HostID, s.Service, s.Action, s.RunAt.UTC(), s.Repeat, SchedulePending, s.CreatedBy, time.Now().UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to schedule action: %w", err)
+	}
+	return result.LastInsertId()
+}
+
+// ListScheduledActions returns the scheduled actions of a host (all hosts
+// if hostID is empty), pending ones only unless all is true, by next run.
+func ListScheduledActions(db *sql.DB, hostID string, all bool) ([]ScheduledAction, error) {
+	query := `SELECT ` + scheduledActionColumns + `
+		FROM scheduled_actions s
+		LEFT JOIN hosts h ON h.id = s.host_id
+		WHERE (? = '' OR s.host_id = ?) AND (? OR s.status = ?)
+		ORDER BY s.run_at ASC, s.id ASC`
+	return queryScheduledActions(db, query, hostID, hostID, all, SchedulePending)
+}
+
+// DueScheduledActions returns the pending scheduled actions whose run_at
+// is not after now.
+func DueScheduledActions(db *sql.DB, now time.Time) ([]ScheduledAction, error) {
+	query := `SELECT ` + scheduledActionColumns + `
+		FROM scheduled_actions s
+		LEFT JOIN hosts h ON h.id = s.host_id
+		WHERE s.status = ? AND s.run_at <= ?
+		ORDER BY s.run_at ASC, s.id ASC`
+	return queryScheduledActions(db, query, SchedulePending, now.UTC())
+}
+
+func queryScheduledActions(db *sql.DB, query string, args ...interface{}) ([]ScheduledAction, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query scheduled actions: %w", err)
+	}
+	defer rows.Close()
+
+	actions := []ScheduledAction{}
+	for rows.Next() {
+		s, err := scanScheduledAction(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan scheduled action: %w", err)
+		}
+		actions = append(actions, s)
+	}
+	return actions, rows.Err()
+}
+
+// GetScheduledAction returns a scheduled action by ID, or nil if there is
+// none.
+func GetScheduledAction(db *sql.DB, id int64) (*ScheduledAction, error) {
+	s, err := scanScheduledAction(db.QueryRow(`SELECT `+scheduledActionColumns+`
+		FROM scheduled_actions s
+		LEFT JOIN hosts h ON h.id = s.host_id
+		WHERE s.id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get scheduled action %d: %w", id, err)
+	}
+	return &s, nil
+}
+
+// CancelScheduledAction cancels a pending scheduled action. It returns
+// false if the action does not exist or is no longer pending.
+func CancelScheduledAction(db *sql.DB, id int64, cancelledBy string) (bool, error) {
+	result, err := db.Exec(`
+		UPDATE scheduled_actions SET status = ?, cancelled_by = ?
+		WHERE id = ? AND status = ?
+	`, ScheduleCancelled, cancelledBy, id, SchedulePending)
+	if err != nil {
+		return false, fmt.Errorf("failed to cancel scheduled action %d: %w", id, err)
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// CancelPendingActions cancels the pending one-time scheduled runs of
+// action on a service, e.g. the re-monitor of an earlier temporary
+// unmonitor. It returns the number cancelled.
+func CancelPendingActions(db *sql.DB, hostID, service, action, cancelledBy string) (int64, error) {
+	result, err := db.Exec(`
+		UPDATE scheduled_actions SET status = ?, cancelled_by = ?
+		WHERE host_id = ? AND service = ? AND action = ? AND repeat = ? AND status = ?
+	`, ScheduleCancelled, cancelledBy, hostID, service, action, RepeatOnce, SchedulePending)
+	if err != nil {
+		return 0, fmt.Errorf("failed to cancel scheduled %s of %s: %w", action, service, err)
+	}
+	return result.RowsAffected()
+}
+
+// RecordScheduledRun stores the outcome of a run of a scheduled action. A
+// zero next marks it done; otherwise it stays pending until next.
+func RecordScheduledRun(db *sql.DB, id int64, ranAt time.Time, success bool, message string, actionID int64, next time.Time) error {
+	status, runAt := SchedulePending, next
+	if next.IsZero() {
+		status, runAt = ScheduleDone, ranAt
+	}
+	_, err := db.Exec(`
+		UPDATE scheduled_actions
+		SET status = ?, run_at = ?, last_run_at = ?, last_success = ?, last_message = ?, last_action_id = ?
+		WHERE id = ? AND status = ?
+	`, status, runAt.UTC(), ranAt.UTC(), success, message, actionID, id, SchedulePending)
+	if err != nil {
+		return fmt.Errorf("failed to update scheduled action %d: %w", id, err)
+	}
+	return nil
+}
@@ -31,7 +31,7 @@ import (
 
 // currentSchemaVersion is the current database schema version.
 // Increment this when making schema changes that require migration.
-const currentSchemaVersion = 12
+const currentSchemaVersion = 44
 
 // SQL schema for the cmonit database
 //
@@ -96,6 +96,11 @@ const (
 	//   - last_seen: When we last received data from this host
 	//   - created_at: When we first saw this host
 	//   - description: User-defined HTML description/notes for this host (max 8192 chars)
+	//   - public: Listed on the unauthenticated /public status page (0=no, 1=yes)
+	//   - archived_at: When the host was archived (NULL=active); archived hosts
+	//     are hidden from the dashboard but keep their history
+	//   - unarchived_at: When the host was last unarchived by hand; the
+	//     retention does not archive it again before it reports
 	//
 	// PRIMARY KEY: id must be unique (enforced by SQLite)
 	// UNIQUE: hostname must be unique (one entry per server)
@@ -129,6 +134,9 @@ const (
 		last_seen DATETIME DEFAULT CURRENT_TIMESTAMP,
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 		description TEXT DEFAULT '' CHECK (length(description) <= 8192),
+		public INTEGER DEFAULT 0 CHECK (public IN (0, 1)),
+		archived_at DATETIME,
+		unarchived_at DATETIME,
 		UNIQUE(hostname)
 	);`
 
@@ -174,6 +182,9 @@ const (
 		memory_kb INTEGER CHECK (memory_kb >= 0),
 		collected_at DATETIME,
 		last_seen DATETIME DEFAULT CURRENT_TIMESTAMP,
+		every_type INTEGER NOT NULL DEFAULT 1,
+		every_cycles INTEGER NOT NULL DEFAULT 1,
+		every_cron TEXT NOT NULL DEFAULT '',
 		FOREIGN KEY (host_id) REFERENCES hosts(id) ON DELETE CASCADE,
 		UNIQUE(host_id, name)
 	);`
@@ -310,11 +321,26 @@ const (
 	//   - event_type: Type of event (integer from Monit)
 	//   - message: Human-readable description
 	//   - created_at: When the event occurred
+	//   - state: Monit event state (0 succeeded, 1 failed, 2 changed,
+	//     3 changed not); NULL for events not sent by Monit
+	//   - action: Monit action taken (1 alert, 2 restart...); NULL for
+	//     events not sent by Monit
+	//   - ack_by, ack_at, ack_note: Acknowledgment (who, when, note); NULL
+	//     until acknowledged from the UI or API
+	//   - severity: info, warning or critical, from the event type, the
+	//     service type and the state (see EventSeverity)
+	//   - flap_count, flap_until: for the event of a flapping service, the
+	//     number of failures and recoveries it collapses and the time of
+	//     the last one; 0 and NULL otherwise (see flapping.go)
+	//   - source: the external system (or API token) that recorded the
+	//     event through POST /api/v1/events, e.g. "jenkins"; NULL for the
+	//     events of Monit and cmonit
 	//
 	// Index:
 	// - idx_events_time: Fast queries for recent events
 	//
-	// Events are inserted but rarely updated (append-only table).
+	// Events are inserted and only updated when acknowledged, or when the
+	// flapping event of a service absorbs a failure or recovery.
 	createEventsTable = `
 	CREATE TABLE IF NOT EXISTS events (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -323,6 +349,15 @@ const (
 		event_type INTEGER,
 		message TEXT,
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		state INTEGER,
+		action INTEGER,
+		ack_by TEXT,
+		ack_at DATETIME,
+		ack_note TEXT DEFAULT '' CHECK (length(ack_note) <= 1024),
+		severity TEXT NOT NULL DEFAULT 'warning',
+		flap_count INTEGER NOT NULL DEFAULT 0,
+		flap_until DATETIME,
+		source TEXT,
 		FOREIGN KEY (host_id) REFERENCES hosts(id) ON DELETE CASCADE
 	);`
 
@@ -334,12 +369,18 @@ const (
 	// idx_events_host also covers "how many events does host X have" (getEventCount),
 	// which without it was a full table scan since events has no other index on host_id.
 	//
+	// idx_events_service finds the latest event of each service, whose
+	// acknowledgment decides whether a failing service counts against the
+	// host status color.
+	//
 	// DESC means descending order (newest first)
 	createEventsIndex = `
 	CREATE INDEX IF NOT EXISTS idx_events_time
 		ON events(created_at DESC);
 	CREATE INDEX IF NOT EXISTS idx_events_host
-		ON events(host_id, created_at DESC);`
+		ON events(host_id, created_at DESC);
+	CREATE INDEX IF NOT EXISTS idx_events_service
+		ON events(host_id, service_name, id DESC);`
 
 	// createFilesystemMetricsTable creates the filesystem_metrics table
 	//
@@ -365,8 +406,14 @@ const (
 	//   - read_ops_total: Total read operations since boot
 	//   - write_bytes_total: Total bytes written since boot
 	//   - write_ops_total: Total write operations since boot
+	//   - read_bytes_rate, read_ops_rate: Bytes and operations read per second
+	//   - write_bytes_rate, write_ops_rate: Bytes and operations written per second
+	//     (rates over the last Monit cycle; NULL before schema v30)
 	//   - collected_at: When this data was collected
 	//
+	// Each row holds one check: its ICMP, port or unix columns (rows from
+	// before schema v35 hold the first check of each kind together).
+	//
 	// This is time-series data like the metrics table, allowing us to
 	// track filesystem usage trends over time.
 	createFilesystemMetricsTable = `
@@ -389,6 +436,10 @@ const (
 		read_ops_total INTEGER CHECK (read_ops_total >= 0),
 		write_bytes_total INTEGER CHECK (write_bytes_total >= 0),
 		write_ops_total INTEGER CHECK (write_ops_total >= 0),
+		read_bytes_rate INTEGER CHECK (read_bytes_rate >= 0),
+		read_ops_rate INTEGER CHECK (read_ops_rate >= 0),
+		write_bytes_rate INTEGER CHECK (write_bytes_rate >= 0),
+		write_ops_rate INTEGER CHECK (write_ops_rate >= 0),
 		collected_at DATETIME NOT NULL,
 		FOREIGN KEY (host_id) REFERENCES hosts(id) ON DELETE CASCADE
 	);`
@@ -511,6 +562,137 @@ const (
 	CREATE INDEX IF NOT EXISTS idx_file_metrics_lookup
 		ON file_metrics(host_id, service_name, collected_at);`
 
+	// createPathMetricsTable creates the path_metrics table
+	//
+	// This table stores directory and FIFO monitoring metrics (permissions,
+	// timestamps). Only populated for directory (type 1) and FIFO (type 6)
+	// services.
+	//
+	// Columns:
+	//   - id: Auto-incrementing integer
+	//   - host_id: Which host this metric is from
+	//   - service_name: Directory or FIFO service name
+	//   - mode: Permission mode (e.g., "755")
+	//   - uid: User ID of the owner
+	//   - gid: Group ID of the owner
+	//   - access_time: Last access time (Unix timestamp)
+	//   - change_time: Last change time (Unix timestamp)
+	//   - modify_time: Last modification time (Unix timestamp)
+	//   - collected_at: When this data was collected
+	createPathMetricsTable = `
+	CREATE TABLE IF NOT EXISTS path_metrics (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		host_id TEXT NOT NULL,
+		service_name TEXT NOT NULL,
+		mode TEXT,
+		uid INTEGER CHECK (uid >= 0),
+		gid INTEGER CHECK (gid >= 0),
+		access_time INTEGER CHECK (access_time >= 0),
+		change_time INTEGER CHECK (change_time >= 0),
+		modify_time INTEGER CHECK (modify_time >= 0),
+		collected_at DATETIME NOT NULL,
+		FOREIGN KEY (host_id) REFERENCES hosts(id) ON DELETE CASCADE
+	);
+	CREATE INDEX IF NOT EXISTS idx_path_metrics_lookup
+		ON path_metrics(host_id, service_name, collected_at);`
+
+	// createParseDiagnosticsTable creates the parse_diagnostics table
+	//
+	// With [logging] parse_diagnostics, the collector records the problems
+	// found in each status document (see parser.Diagnose), and documents it
+	// could not parse, with host_id NULL. Only the latest entries of each
+	// host or source are kept (see StoreParseDiagnostics).
+	//
+	// Columns:
+	//   - host_id: Host of the document (NULL if it could not be parsed)
+	//   - source: Address of the agent that posted the document
+	//   - service_name: Service the warning is about ('' for the document)
+	//   - message: Warning or parse error
+	//   - created_at: When the document was received
+	createParseDiagnosticsTable = `
+	CREATE TABLE IF NOT EXISTS parse_diagnostics (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		host_id TEXT,
+		source TEXT NOT NULL DEFAULT '',
+		service_name TEXT NOT NULL DEFAULT '',
+		message TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		FOREIGN KEY (host_id) REFERENCES hosts(id) ON DELETE CASCADE
+	);
+	CREATE INDEX IF NOT EXISTS idx_parse_diagnostics_host
+		ON parse_diagnostics(host_id, created_at);`
+
+	// createValidationFailuresTable creates the validation_failures table
+	//
+	// In strict mode ([collector] strict), the collector rejects the status
+	// documents failing parser.Validate and counts them per host. The host
+	// may never have been stored, so the rows are keyed by the server id of
+	// the document, or its hostname, or the agent address without them.
+	//
+	// Columns:
+	//   - host_key: Server id, hostname or agent address of the documents
+	//   - hostname: Hostname of the last rejected document ('' if missing)
+	//   - source: Address of the agent that posted the last one
+	//   - failures: Number of documents rejected
+	//   - last_error: Problems of the last rejected document
+	//   - first_failure_at, last_failure_at: When the first and last were received
+	createValidationFailuresTable = `
+	CREATE TABLE IF NOT EXISTS validation_failures (
+		host_key TEXT PRIMARY KEY,
+		hostname TEXT NOT NULL DEFAULT '',
+		source TEXT NOT NULL DEFAULT '',
+		failures INTEGER NOT NULL DEFAULT 0 CHECK (failures >= 0),
+		last_error TEXT NOT NULL DEFAULT '',
+		first_failure_at DATETIME NOT NULL,
+		last_failure_at DATETIME NOT NULL
+	);`
+
+	// createEventDailyCountsTable creates the event_daily_counts table
+	//
+	// With [retention] aggregate_events, the events deleted by the
+	// retention job are first added to these counts, so that the history
+	// of the number of events outlives the events.
+	//
+	// Columns:
+	//   - host_id: Host of the events
+	//   - day: YYYY-MM-DD, in the server's local time
+	//   - service_name, event_type, severity: As in events
+	//   - count: Number of events deleted
+	createEventDailyCountsTable = `
+	CREATE TABLE IF NOT EXISTS event_daily_counts (
+		host_id TEXT NOT NULL,
+		day TEXT NOT NULL,
+		service_name TEXT NOT NULL,
+		event_type INTEGER NOT NULL,
+		severity TEXT NOT NULL,
+		count INTEGER NOT NULL DEFAULT 0 CHECK (count >= 0),
+		PRIMARY KEY (host_id, day, service_name, event_type, severity),
+		FOREIGN KEY (host_id) REFERENCES hosts(id) ON DELETE CASCADE
+	);`
+
+	// createEventCommentsTable creates the event_comments table
+	//
+	// Operators comment events from the events pages or the API ("known
+	// issue, vendor ticket #123"). The comments go with their event.
+	//
+	// Columns:
+	//   - id: Auto-incrementing integer
+	//   - event_id: Commented event
+	//   - author: Web user, or "anonymous" without web authentication
+	//   - body: Comment text
+	//   - created_at: When the comment was added
+	createEventCommentsTable = `
+	CREATE TABLE IF NOT EXISTS event_comments (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		event_id INTEGER NOT NULL,
+		author TEXT NOT NULL,
+		body TEXT NOT NULL CHECK (length(body) <= 1024),
+		created_at DATETIME NOT NULL,
+		FOREIGN KEY (event_id) REFERENCES events(id) ON DELETE CASCADE
+	);
+	CREATE INDEX IF NOT EXISTS idx_event_comments_event
+		ON event_comments(event_id);`
+
 	// createProgramMetricsTable creates the program_metrics table
 	//
 	// This table stores program status check metrics (exit status, output).
@@ -522,7 +704,10 @@ const (
 	//   - service_name: Program service name (e.g., "temperature")
 	//   - started: Unix timestamp when program was last executed
 	//   - exit_status: Program exit status code (0=success, non-zero=error)
-	//   - output: Program stdout/stderr output (up to 512 bytes)
+	//   - output: Program stdout/stderr output (up to 512 bytes by default in
+	//     Monit), truncated beyond [storage] program_output_max
+	//   - full_output: Whole output when it was truncated, with [storage]
+	//     program_full_output (NULL otherwise)
 	//   - collected_at: When this data was collected
 	//
 	// This is time-series data like the metrics table, allowing us to
@@ -535,6 +720,7 @@ const (
 		started INTEGER CHECK (started >= 0),
 		exit_status INTEGER,
 		output TEXT,
+		full_output TEXT,
 		collected_at DATETIME NOT NULL,
 		FOREIGN KEY (host_id) REFERENCES hosts(id) ON DELETE CASCADE
 	);`
@@ -556,6 +742,8 @@ const (
 	//   - id: Auto-incrementing integer
 	//   - host_id: Which host this metric is from
 	//   - service_name: Remote host service name (e.g., "homeassistant")
+	//   - check_index: Position of the check among the service's checks of
+	//     the same kind (0 = first "port" test in monitrc, 1 = second...)
 	//   - icmp_type: ICMP check type (usually "Ping")
 	//   - icmp_responsetime: Ping response time in seconds (e.g., 0.000348)
 	//   - port_hostname: Hostname/IP being monitored for port checks
@@ -575,6 +763,7 @@ const (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		host_id TEXT NOT NULL,
 		service_name TEXT NOT NULL,
+		check_index INTEGER NOT NULL DEFAULT 0,
 		icmp_type TEXT,
 		icmp_responsetime REAL CHECK (icmp_responsetime >= 0),
 		port_hostname TEXT,
@@ -686,6 +875,326 @@ const (
 		ON host_hostgroups(host_id);
 	CREATE INDEX IF NOT EXISTS idx_host_hostgroups_group
 		ON host_hostgroups(hostgroup_id);`
+
+	// createServiceGroupsTable creates the service_groups table
+	//
+	// Service groups are defined in monitrc ("group www" in a check
+	// statement) and reported in <servicegroups>. They are replaced with
+	// each status report of the host.
+	//
+	// Columns:
+	//   - host_id: Foreign key to hosts table
+	//   - group_name: Service group name (e.g., "www")
+	//   - service_name: Member service
+	createServiceGroupsTable = `
+	CREATE TABLE IF NOT EXISTS service_groups (
+		host_id TEXT NOT NULL,
+		group_name TEXT NOT NULL,
+		service_name TEXT NOT NULL,
+		FOREIGN KEY (host_id) REFERENCES hosts(id) ON DELETE CASCADE,
+		UNIQUE(host_id, group_name, service_name)
+	);
+	CREATE INDEX IF NOT EXISTS idx_service_groups_group
+		ON service_groups(group_name);`
+
+	// createServiceDependenciesTable creates the service_dependencies table
+	//
+	// Dependencies are defined in monitrc ("depends on nginx" in a check
+	// statement) and reported as <depend> in each service. They are
+	// replaced with each status report of the host.
+	//
+	// Columns:
+	//   - host_id: Foreign key to hosts table
+	//   - service_name: Dependent service
+	//   - depends_on: Service it depends on (stopped after, started before)
+	createServiceDependenciesTable = `
+	CREATE TABLE IF NOT EXISTS service_dependencies (
+		host_id TEXT NOT NULL,
+		service_name TEXT NOT NULL,
+		depends_on TEXT NOT NULL,
+		FOREIGN KEY (host_id) REFERENCES hosts(id) ON DELETE CASCADE,
+		UNIQUE(host_id, service_name, depends_on)
+	);`
+
+	// createDashboardsTable creates the dashboards table
+	//
+	// This table stores user-composed dashboards. Each dashboard is a named,
+	// ordered list of widgets serialized as JSON.
+	//
+	// Columns:
+	//   - id: Auto-incrementing integer
+	//   - owner: Username that owns the dashboard ('' = shared, visible to everyone)
+	//   - name: Display name, unique per owner
+	//   - widgets: JSON array of widget definitions (type + parameters)
+	//   - is_default: 1 for the dashboard shown to anonymous viewers
+	//   - created_at, updated_at: Timestamps
+	createDashboardsTable = `
+	CREATE TABLE IF NOT EXISTS dashboards (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		owner TEXT NOT NULL DEFAULT '',
+		name TEXT NOT NULL,
+		widgets TEXT NOT NULL DEFAULT '[]',
+		is_default INTEGER NOT NULL DEFAULT 0 CHECK (is_default IN (0, 1)),
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE(owner, name)
+	);`
+
+	// createPreferencesTable creates the preferences table
+	//
+	// This table stores web UI display preferences, one row per owner.
+	//
+	// Columns:
+	//   - owner: "user:<name>" for authenticated users, "browser:<token>" for
+	//     anonymous browsers identified by a cookie
+	//   - theme: "light" or "dark"
+	//   - timezone: IANA timezone name for displayed timestamps ('' = server local time)
+	//   - refresh_seconds: Page auto-refresh interval (0 = disabled)
+	//   - default_range: Default graph time range (1h, 6h, 24h, 7d, 30d)
+	//   - updated_at: Last modification time
+	createPreferencesTable = `
+	CREATE TABLE IF NOT EXISTS preferences (
+		owner TEXT PRIMARY KEY,
+		theme TEXT NOT NULL DEFAULT 'light' CHECK (theme IN ('light', 'dark')),
+		timezone TEXT NOT NULL DEFAULT '',
+		refresh_seconds INTEGER NOT NULL DEFAULT 60 CHECK (refresh_seconds >= 0),
+		default_range TEXT NOT NULL DEFAULT '24h',
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	// createSessionsTable creates the sessions table
+	//
+	// This table stores web UI login sessions. The cookie holds a random
+	// token; only its SHA-256 hash is stored, so a copy of the database
+	// cannot be used to hijack sessions.
+	//
+	// Columns:
+	//   - id: Hex SHA-256 of the session token
+	//   - username: Authenticated web user
+	//   - remember: 1 for "remember me" sessions (fixed lifetime, no idle timeout)
+	//   - created_at: Login time
+	//   - last_seen: Last request made with the session
+	//   - expires_at: Expiry (UTC); moved forward on activity unless remember is set
+	createSessionsTable = `
+	CREATE TABLE IF NOT EXISTS sessions (
+		id TEXT PRIMARY KEY,
+		username TEXT NOT NULL,
+		remember INTEGER NOT NULL DEFAULT 0 CHECK (remember IN (0, 1)),
+		created_at DATETIME NOT NULL,
+		last_seen DATETIME NOT NULL,
+		expires_at DATETIME NOT NULL
+	);`
+
+	// createAPITokensTable creates the api_tokens table
+	//
+	// This table stores API tokens for scripts and integrations. As with
+	// sessions, only the SHA-256 hash of each token is stored.
+	//
+	// Columns:
+	//   - id: Auto-incrementing integer
+	//   - name: Unique display name (e.g., "grafana")
+	//   - token_hash: Hex SHA-256 of the token
+	//   - prefix: First characters of the token, to identify it in lists
+	//   - scopes: Space-separated scopes (read:status, write:actions,
+	//     write:events, admin)
+	//   - role: Role limiting service actions (see [[role]] in the config
+	//     file); empty = no limit beyond the scopes
+	//   - created_by: Web user that created the token, or "cli"
+	//   - created_at: Creation time
+	//   - last_used: Last authenticated request (NULL = never used)
+	createAPITokensTable = `
+	CREATE TABLE IF NOT EXISTS api_tokens (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL UNIQUE CHECK (length(name) <= 64),
+		token_hash TEXT NOT NULL UNIQUE,
+		prefix TEXT NOT NULL,
+		scopes TEXT NOT NULL,
+		role TEXT NOT NULL DEFAULT '',
+		created_by TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL,
+		last_used DATETIME
+	);`
+
+	// createTOTPTable creates the totp table
+	//
+	// This table stores the TOTP (authenticator app) secret of web accounts
+	// using two-factor authentication.
+	//
+	// Columns:
+	//   - username: Web user
+	//   - secret: Base32 shared secret
+	//   - enabled: 0 until a first code confirms the enrollment
+	//   - created_at: Time the secret was generated
+	//   - enabled_at: Time the enrollment was confirmed
+	//   - last_counter: Time step of the last accepted code (prevents replay)
+	createTOTPTable = `
+	CREATE TABLE IF NOT EXISTS totp (
+		username TEXT PRIMARY KEY,
+		secret TEXT NOT NULL,
+		enabled INTEGER NOT NULL DEFAULT 0 CHECK (enabled IN (0, 1)),
+		created_at DATETIME NOT NULL,
+		enabled_at DATETIME,
+		last_counter INTEGER NOT NULL DEFAULT 0
+	);`
+
+	// createTOTPRecoveryCodesTable creates the totp_recovery_codes table
+	//
+	// This table stores single-use recovery codes, which replace a TOTP code
+	// when the authenticator app is lost. Only SHA-256 hashes are stored.
+	//
+	// Columns:
+	//   - username: Web user
+	//   - code_hash: Hex SHA-256 of the normalized code
+	//   - used_at: Time the code was used (NULL = still valid)
+	createTOTPRecoveryCodesTable = `
+	CREATE TABLE IF NOT EXISTS totp_recovery_codes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		username TEXT NOT NULL,
+		code_hash TEXT NOT NULL,
+		used_at DATETIME
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_totp_recovery_codes_username
+		ON totp_recovery_codes(username);`
+
+	// createAuditLogTable creates the audit_log table
+	//
+	// This table records security-relevant actions for review by
+	// administrators. It is append-only and not pruned by -retention-days.
+	//
+	// Columns:
+	//   - id: Auto-incrementing integer
+	//   - created_at: Time of the action (UTC)
+	//   - actor: Web user, "token:<name>", "cli" or "anonymous"
+	//   - source_ip: Client address (empty for command line actions)
+	//   - action: Action name (e.g., "login", "service_action")
+	//   - target: Affected object (e.g., "web01/nginx")
+	//   - details: Free text (e.g., "restart", error message)
+	//   - success: 0 if the action failed or was refused
+	createAuditLogTable = `
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		created_at DATETIME NOT NULL,
+		actor TEXT NOT NULL DEFAULT '',
+		source_ip TEXT NOT NULL DEFAULT '',
+		action TEXT NOT NULL,
+		target TEXT NOT NULL DEFAULT '',
+		details TEXT NOT NULL DEFAULT '',
+		success INTEGER NOT NULL DEFAULT 1 CHECK (success IN (0, 1))
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_audit_log_created
+		ON audit_log(created_at);`
+
+	// createHostControlTable creates the host_control table
+	//
+	// This table stores per-host settings for connecting to the Monit agent
+	// when running service actions. Hosts without a row use the defaults.
+	//
+	// Columns:
+	//   - host_id: Host the settings apply to
+	//   - ca_file: CA bundle verifying the agent's HTTPS certificate
+	//     (empty = global -monit-ca-file, or the system roots)
+	//   - insecure_skip_verify: 1 to accept any agent certificate
+	//   - username: Monit username overriding the one the agent reports
+	//     (empty = reported one)
+	//   - password_enc: Monit password override, encrypted with the secret
+	//     key file (empty = reported one)
+	//   - updated_at: Time the settings were last changed
+	createHostControlTable = `
+	CREATE TABLE IF NOT EXISTS host_control (
+		host_id TEXT PRIMARY KEY,
+		ca_file TEXT NOT NULL DEFAULT '',
+		insecure_skip_verify INTEGER NOT NULL DEFAULT 0 CHECK (insecure_skip_verify IN (0, 1)),
+		username TEXT NOT NULL DEFAULT '',
+		password_enc TEXT NOT NULL DEFAULT '',
+		updated_at DATETIME,
+		FOREIGN KEY (host_id) REFERENCES hosts(id) ON DELETE CASCADE
+	);`
+
+	// createActionsTable creates the actions table
+	//
+	// This table records every service action sent to a Monit agent, with
+	// its outcome and how long the agent took. Like the audit log, it is
+	// not pruned by -retention-days and is kept when a host is deleted.
+	//
+	// Columns:
+	//   - id: Auto-incrementing integer
+	//   - created_at: Time the action was sent (UTC)
+	//   - host_id: Target host
+	//   - hostname: Hostname at the time of the action
+	//   - service: Target service
+	//   - action: start, stop, restart, monitor or unmonitor
+	//   - requested_by: Web user, "token:<name>" or "anonymous"
+	//   - source_ip: Client address of the request
+	//   - success: 0 if the agent could not be reached or refused the action
+	//   - message: Error message (empty on success)
+	//   - latency_ms: Time taken by the agent exchange, in milliseconds
+	//   - verify_status: Whether the service reached the expected state:
+	//     "pending" (checking), "confirmed", "timeout" or "" (not checked)
+	//   - verify_message: Last observed state or polling error
+	//   - verified_at: Time the check ended
+	createActionsTable = `
+	CREATE TABLE IF NOT EXISTS actions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		created_at DATETIME NOT NULL,
+		host_id TEXT NOT NULL,
+		hostname TEXT NOT NULL DEFAULT '',
+		service TEXT NOT NULL,
+		action TEXT NOT NULL,
+		requested_by TEXT NOT NULL DEFAULT '',
+		source_ip TEXT NOT NULL DEFAULT '',
+		success INTEGER NOT NULL DEFAULT 1 CHECK (success IN (0, 1)),
+		message TEXT NOT NULL DEFAULT '',
+		latency_ms INTEGER NOT NULL DEFAULT 0,
+		verify_status TEXT NOT NULL DEFAULT '',
+		verify_message TEXT NOT NULL DEFAULT '',
+		verified_at DATETIME
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_actions_host_created
+		ON actions(host_id, created_at);`
+
+	// createScheduledActionsTable creates the scheduled_actions table
+	//
+	// This table stores service actions to run later, once or repeatedly.
+	// A background job runs the pending ones when run_at is reached.
+	//
+	// Columns:
+	//   - id: Auto-incrementing integer
+	//   - host_id: Target host
+	//   - service: Target service
+	//   - action: start, stop, restart, monitor or unmonitor
+	//   - run_at: Next run (UTC)
+	//   - repeat: "" (once), "daily" or "weekly"
+	//   - status: "pending", "done" (ran once) or "cancelled"
+	//   - created_by: Web user, "token:<name>" or "anonymous"
+	//   - created_at: Time the action was scheduled
+	//   - cancelled_by: User who cancelled it
+	//   - last_run_at: Time of the last run
+	//   - last_success: 0 if the last run failed
+	//   - last_message: Outcome of the last run
+	//   - last_action_id: Last run in the actions table
+	createScheduledActionsTable = `
+	CREATE TABLE IF NOT EXISTS scheduled_actions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		host_id TEXT NOT NULL,
+		service TEXT NOT NULL,
+		action TEXT NOT NULL,
+		run_at DATETIME NOT NULL,
+		repeat TEXT NOT NULL DEFAULT '' CHECK (repeat IN ('', 'daily', 'weekly')),
+		status TEXT NOT NULL DEFAULT 'pending',
+		created_by TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL,
+		cancelled_by TEXT NOT NULL DEFAULT '',
+		last_run_at DATETIME,
+		last_success INTEGER NOT NULL DEFAULT 0 CHECK (last_success IN (0, 1)),
+		last_message TEXT NOT NULL DEFAULT '',
+		last_action_id INTEGER NOT NULL DEFAULT 0
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_scheduled_actions_status_run
+		ON scheduled_actions(status, run_at);`
 )
 
 // InitDB initializes the database and creates all tables.
@@ -914,6 +1423,13 @@ func InitDB(dbPath string) (*sql.DB, error) {
 		return nil, fmt.Errorf("failed to create events index: %w", err)
 	}
 
+	// Create the events_fts full-text index of the events (see eventsearch.go)
+	err = initEventSearch(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
 	// Create filesystem_metrics table
 	_, err = db.Exec(createFilesystemMetricsTable)
 	if err != nil {
@@ -956,6 +1472,41 @@ func InitDB(dbPath string) (*sql.DB, error) {
 		return nil, fmt.Errorf("failed to create file_metrics index: %w", err)
 	}
 
+	// Create path_metrics table
+	_, err = db.Exec(createPathMetricsTable)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create path_metrics table: %w", err)
+	}
+
+	// Create parse_diagnostics table
+	_, err = db.Exec(createParseDiagnosticsTable)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create parse_diagnostics table: %w", err)
+	}
+
+	// Create validation_failures table
+	_, err = db.Exec(createValidationFailuresTable)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create validation_failures table: %w", err)
+	}
+
+	// Create event_daily_counts table
+	_, err = db.Exec(createEventDailyCountsTable)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create event_daily_counts table: %w", err)
+	}
+
+	// Create event_comments table
+	_, err = db.Exec(createEventCommentsTable)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create event_comments table: %w", err)
+	}
+
 	// Create program_metrics table
 	_, err = db.Exec(createProgramMetricsTable)
 	if err != nil {
@@ -1019,6 +1570,88 @@ func InitDB(dbPath string) (*sql.DB, error) {
 		return nil, fmt.Errorf("failed to create host_hostgroups indexes: %w", err)
 	}
 
+	// Create service_groups table
+	_, err = db.Exec(createServiceGroupsTable)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create service_groups table: %w", err)
+	}
+
+	// Create service_dependencies table
+	_, err = db.Exec(createServiceDependenciesTable)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create service_dependencies table: %w", err)
+	}
+
+	// Create dashboards table
+	_, err = db.Exec(createDashboardsTable)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create dashboards table: %w", err)
+	}
+
+	// Create preferences table
+	_, err = db.Exec(createPreferencesTable)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create preferences table: %w", err)
+	}
+
+	// Create sessions table
+	_, err = db.Exec(createSessionsTable)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create sessions table: %w", err)
+	}
+
+	// Create api_tokens table
+	_, err = db.Exec(createAPITokensTable)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create api_tokens table: %w", err)
+	}
+
+	// Create two-factor authentication tables
+	_, err = db.Exec(createTOTPTable)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create totp table: %w", err)
+	}
+	_, err = db.Exec(createTOTPRecoveryCodesTable)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create totp_recovery_codes table: %w", err)
+	}
+
+	// Create audit_log table
+	_, err = db.Exec(createAuditLogTable)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create audit_log table: %w", err)
+	}
+
+	// Create host_control table
+	_, err = db.Exec(createHostControlTable)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create host_control table: %w", err)
+	}
+
+	// Create actions table
+	_, err = db.Exec(createActionsTable)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create actions table: %w", err)
+	}
+
+	// Create scheduled_actions table
+	_, err = db.Exec(createScheduledActionsTable)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create scheduled_actions table: %w", err)
+	}
+
 	log.Printf("[INFO] Database schema created successfully")
 
 	// Return the database connection
@@ -1061,6 +1694,16 @@ func setSchemaVersion(db *sql.DB, version int) error {
 	return nil
 }
 
+// hasColumn reports whether table has the named column.
+func hasColumn(db *sql.DB, table, column string) (bool, error) {
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&n)
+	if err != nil {
+		return false, fmt.Errorf("failed to inspect table %s: %w", table, err)
+	}
+	return n > 0, nil
+}
+
 // migrateSchema performs database schema migrations.
 //
 // This function handles migrations from older schema versions to newer ones.
@@ -1377,6 +2020,641 @@ func migrateSchema(db *sql.DB, fromVersion, toVersion int) error {
 			}
 			log.Printf("[INFO] Successfully migrated to schema version 12")
 
+		case 12:
+			// Migration from version 12 to version 13
+			// Add dashboards table for user-composed widget dashboards
+			log.Printf("[INFO] Migrating from v12 to v13: Adding dashboards table")
+
+			_, err := db.Exec(createDashboardsTable)
+			if err != nil {
+				return fmt.Errorf("migration v12->v13 failed creating dashboards table: %w", err)
+			}
+
+			fromVersion = 13
+			err = setSchemaVersion(db, fromVersion)
+			if err != nil {
+				return err
+			}
+			log.Printf("[INFO] Successfully migrated to schema version 13")
+
+		case 13:
+			// Migration from version 13 to version 14
+			// Add preferences table for per-user/per-browser UI settings
+			log.Printf("[INFO] Migrating from v13 to v14: Adding preferences table")
+
+			_, err := db.Exec(createPreferencesTable)
+			if err != nil {
+				return fmt.Errorf("migration v13->v14 failed creating preferences table: %w", err)
+			}
+
+			fromVersion = 14
+			err = setSchemaVersion(db, fromVersion)
+			if err != nil {
+				return err
+			}
+			log.Printf("[INFO] Successfully migrated to schema version 14")
+
+		case 14:
+			// Migration from version 14 to version 15
+			// Add hosts.public opt-in flag for the public status page
+			log.Printf("[INFO] Migrating from v14 to v15: Adding hosts.public column")
+
+			_, err := db.Exec("ALTER TABLE hosts ADD COLUMN public INTEGER DEFAULT 0 CHECK (public IN (0, 1))")
+			if err != nil {
+				return fmt.Errorf("migration v14->v15 failed adding hosts.public: %w", err)
+			}
+
+			fromVersion = 15
+			err = setSchemaVersion(db, fromVersion)
+			if err != nil {
+				return err
+			}
+			log.Printf("[INFO] Successfully migrated to schema version 15")
+
+		case 15:
+			// Migration from version 15 to version 16
+			// Add event acknowledgment columns
+			log.Printf("[INFO] Migrating from v15 to v16: Adding event acknowledgment columns")
+
+			for _, stmt := range []string{
+				"ALTER TABLE events ADD COLUMN ack_by TEXT",
+				"ALTER TABLE events ADD COLUMN ack_at DATETIME",
+				"ALTER TABLE events ADD COLUMN ack_note TEXT DEFAULT '' CHECK (length(ack_note) <= 1024)",
+			} {
+				if _, err := db.Exec(stmt); err != nil {
+					return fmt.Errorf("migration v15->v16 failed: %w", err)
+				}
+			}
+
+			fromVersion = 16
+			err := setSchemaVersion(db, fromVersion)
+			if err != nil {
+				return err
+			}
+			log.Printf("[INFO] Successfully migrated to schema version 16")
+
+		case 16:
+			// Migration from version 16 to version 17
+			// Add sessions table for the web UI login page
+			log.Printf("[INFO] Migrating from v16 to v17: Adding sessions table")
+
+			_, err := db.Exec(createSessionsTable)
+			if err != nil {
+				return fmt.Errorf("migration v16->v17 failed creating sessions table: %w", err)
+			}
+
+			fromVersion = 17
+			err = setSchemaVersion(db, fromVersion)
+			if err != nil {
+				return err
+			}
+			log.Printf("[INFO] Successfully migrated to schema version 17")
+
+		case 17:
+			// Migration from version 17 to version 18
+			// Add api_tokens table for scoped Bearer tokens
+			log.Printf("[INFO] Migrating from v17 to v18: Adding api_tokens table")
+
+			_, err := db.Exec(createAPITokensTable)
+			if err != nil {
+				return fmt.Errorf("migration v17->v18 failed creating api_tokens table: %w", err)
+			}
+
+			fromVersion = 18
+			err = setSchemaVersion(db, fromVersion)
+			if err != nil {
+				return err
+			}
+			log.Printf("[INFO] Successfully migrated to schema version 18")
+
+		case 18:
+			// Migration from version 18 to version 19
+			// Add TOTP two-factor authentication tables
+			log.Printf("[INFO] Migrating from v18 to v19: Adding totp and totp_recovery_codes tables")
+
+			_, err := db.Exec(createTOTPTable)
+			if err != nil {
+				return fmt.Errorf("migration v18->v19 failed creating totp table: %w", err)
+			}
+			_, err = db.Exec(createTOTPRecoveryCodesTable)
+			if err != nil {
+				return fmt.Errorf("migration v18->v19 failed creating totp_recovery_codes table: %w", err)
+			}
+
+			fromVersion = 19
+			err = setSchemaVersion(db, fromVersion)
+			if err != nil {
+				return err
+			}
+			log.Printf("[INFO] Successfully migrated to schema version 19")
+
+		case 19:
+			// Migration from version 19 to version 20
+			// Add audit_log table for administrative actions
+			log.Printf("[INFO] Migrating from v19 to v20: Adding audit_log table")
+
+			_, err := db.Exec(createAuditLogTable)
+			if err != nil {
+				return fmt.Errorf("migration v19->v20 failed creating audit_log table: %w", err)
+			}
+
+			fromVersion = 20
+			err = setSchemaVersion(db, fromVersion)
+			if err != nil {
+				return err
+			}
+			log.Printf("[INFO] Successfully migrated to schema version 20")
+
+		case 20:
+			// Migration from version 20 to version 21
+			// Add role column to api_tokens for role-based action authorization
+			log.Printf("[INFO] Migrating from v20 to v21: Adding role column to api_tokens table")
+
+			// Databases migrated from before v18 created api_tokens with
+			// the column already
+			exists, err := hasColumn(db, "api_tokens", "role")
+			if err != nil {
+				return fmt.Errorf("migration v20->v21 failed: %w", err)
+			}
+			if !exists {
+				_, err = db.Exec("ALTER TABLE api_tokens ADD COLUMN role TEXT NOT NULL DEFAULT ''")
+				if err != nil {
+					return fmt.Errorf("migration v20->v21 failed: %w", err)
+				}
+			}
+
+			fromVersion = 21
+			err = setSchemaVersion(db, fromVersion)
+			if err != nil {
+				return err
+			}
+			log.Printf("[INFO] Successfully migrated to schema version 21")
+
+		case 21:
+			// Migration from version 21 to version 22
+			// Add host_control table for per-host Monit agent TLS settings
+			log.Printf("[INFO] Migrating from v21 to v22: Adding host_control table")
+
+			_, err := db.Exec(createHostControlTable)
+			if err != nil {
+				return fmt.Errorf("migration v21->v22 failed creating host_control table: %w", err)
+			}
+
+			fromVersion = 22
+			err = setSchemaVersion(db, fromVersion)
+			if err != nil {
+				return err
+			}
+			log.Printf("[INFO] Successfully migrated to schema version 22")
+
+		case 22:
+			// Migration from version 22 to version 23
+			// Add actions table recording service actions sent to agents
+			log.Printf("[INFO] Migrating from v22 to v23: Adding actions table")
+
+			_, err := db.Exec(createActionsTable)
+			if err != nil {
+				return fmt.Errorf("migration v22->v23 failed creating actions table: %w", err)
+			}
+
+			fromVersion = 23
+			err = setSchemaVersion(db, fromVersion)
+			if err != nil {
+				return err
+			}
+			log.Printf("[INFO] Successfully migrated to schema version 23")
+
+		case 23:
+			// Migration from version 23 to version 24
+			// Add verification columns to actions (service reached the expected state)
+			log.Printf("[INFO] Migrating from v23 to v24: Adding verification columns to actions table")
+
+			// Databases migrated from before v23 created actions with
+			// the columns already
+			columns := []struct{ name, ddl string }{
+				{"verify_status", "ALTER TABLE actions ADD COLUMN verify_status TEXT NOT NULL DEFAULT ''"},
+				{"verify_message", "ALTER TABLE actions ADD COLUMN verify_message TEXT NOT NULL DEFAULT ''"},
+				{"verified_at", "ALTER TABLE actions ADD COLUMN verified_at DATETIME"},
+			}
+			for _, c := range columns {
+				exists, err := hasColumn(db, "actions", c.name)
+				if err != nil {
+					return fmt.Errorf("migration v23->v24 failed: %w", err)
+				}
+				if !exists {
+					if _, err := db.Exec(c.ddl); err != nil {
+						return fmt.Errorf("migration v23->v24 failed adding %s: %w", c.name, err)
+					}
+				}
+			}
+
+			fromVersion = 24
+			err := setSchemaVersion(db, fromVersion)
+			if err != nil {
+				return err
+			}
+			log.Printf("[INFO] Successfully migrated to schema version 24")
+
+		case 24:
+			// Migration from version 24 to version 25
+			// Add scheduled_actions table (service actions to run later)
+			log.Printf("[INFO] Migrating from v24 to v25: Adding scheduled_actions table")
+
+			_, err := db.Exec(createScheduledActionsTable)
+			if err != nil {
+				return fmt.Errorf("migration v24->v25 failed creating scheduled_actions table: %w", err)
+			}
+
+			fromVersion = 25
+			err = setSchemaVersion(db, fromVersion)
+			if err != nil {
+				return err
+			}
+			log.Printf("[INFO] Successfully migrated to schema version 25")
+
+		case 25:
+			// Migration from version 25 to version 26
+			// Add credential overrides to host_control
+			log.Printf("[INFO] Migrating from v25 to v26: Adding credential columns to host_control table")
+
+			// Databases migrated from before v22 created host_control with
+			// the columns already
+			columns := []struct{ name, ddl string }{
+				{"username", "ALTER TABLE host_control ADD COLUMN username TEXT NOT NULL DEFAULT ''"},
+				{"password_enc", "ALTER TABLE host_control ADD COLUMN password_enc TEXT NOT NULL DEFAULT ''"},
+			}
+			for _, c := range columns {
+				exists, err := hasColumn(db, "host_control", c.name)
+				if err != nil {
+					return fmt.Errorf("migration v25->v26 failed: %w", err)
+				}
+				if !exists {
+					if _, err := db.Exec(c.ddl); err != nil {
+						return fmt.Errorf("migration v25->v26 failed adding %s: %w", c.name, err)
+					}
+				}
+			}
+
+			fromVersion = 26
+			err := setSchemaVersion(db, fromVersion)
+			if err != nil {
+				return err
+			}
+			log.Printf("[INFO] Successfully migrated to schema version 26")
+
+		case 26:
+			// Migration from version 26 to version 27
+			// Add service_groups table (<servicegroups> of the status reports)
+			log.Printf("[INFO] Migrating from v26 to v27: Adding service_groups table")
+
+			_, err := db.Exec(createServiceGroupsTable)
+			if err != nil {
+				return fmt.Errorf("migration v26->v27 failed creating service_groups table: %w", err)
+			}
+
+			fromVersion = 27
+			err = setSchemaVersion(db, fromVersion)
+			if err != nil {
+				return err
+			}
+			log.Printf("[INFO] Successfully migrated to schema version 27")
+
+		case 27:
+			// Migration from version 27 to version 28
+			// Add the check schedule ("every") of services
+			log.Printf("[INFO] Migrating from v27 to v28: Adding every columns to services table")
+
+			columns := []struct{ name, ddl string }{
+				{"every_type", "ALTER TABLE services ADD COLUMN every_type INTEGER NOT NULL DEFAULT 1"},
+				{"every_cycles", "ALTER TABLE services ADD COLUMN every_cycles INTEGER NOT NULL DEFAULT 1"},
+				{"every_cron", "ALTER TABLE services ADD COLUMN every_cron TEXT NOT NULL DEFAULT ''"},
+			}
+			for _, c := range columns {
+				exists, err := hasColumn(db, "services", c.name)
+				if err != nil {
+					return fmt.Errorf("migration v27->v28 failed: %w", err)
+				}
+				if !exists {
+					if _, err := db.Exec(c.ddl); err != nil {
+						return fmt.Errorf("migration v27->v28 failed adding %s: %w", c.name, err)
+					}
+				}
+			}
+
+			fromVersion = 28
+			err := setSchemaVersion(db, fromVersion)
+			if err != nil {
+				return err
+			}
+			log.Printf("[INFO] Successfully migrated to schema version 28")
+
+		case 28:
+			// Migration from version 28 to version 29
+			// Add service_dependencies table (<depend> of the services)
+			log.Printf("[INFO] Migrating from v28 to v29: Adding service_dependencies table")
+
+			_, err := db.Exec(createServiceDependenciesTable)
+			if err != nil {
+				return fmt.Errorf("migration v28->v29 failed creating service_dependencies table: %w", err)
+			}
+
+			fromVersion = 29
+			err = setSchemaVersion(db, fromVersion)
+			if err != nil {
+				return err
+			}
+			log.Printf("[INFO] Successfully migrated to schema version 29")
+
+		case 29:
+			// Migration from version 29 to version 30
+			// Add the filesystem read/write rates (<count> of <read>/<write>)
+			log.Printf("[INFO] Migrating from v29 to v30: Adding I/O rate columns to filesystem_metrics table")
+
+			for _, column := range []string{"read_bytes_rate", "read_ops_rate", "write_bytes_rate", "write_ops_rate"} {
+				exists, err := hasColumn(db, "filesystem_metrics", column)
+				if err != nil {
+					return fmt.Errorf("migration v29->v30 failed: %w", err)
+				}
+				if !exists {
+					ddl := fmt.Sprintf("ALTER TABLE filesystem_metrics ADD COLUMN %s INTEGER CHECK (%s >= 0)", column, column)
+					if _, err := db.Exec(ddl); err != nil {
+						return fmt.Errorf("migration v29->v30 failed adding %s: %w", column, err)
+					}
+				}
+			}
+
+			fromVersion = 30
+			err := setSchemaVersion(db, fromVersion)
+			if err != nil {
+				return err
+			}
+			log.Printf("[INFO] Successfully migrated to schema version 30")
+
+		case 30:
+			// Migration from version 30 to version 31
+			// Add path_metrics table (directory and FIFO services)
+			log.Printf("[INFO] Migrating from v30 to v31: Adding path_metrics table")
+
+			_, err := db.Exec(createPathMetricsTable)
+			if err != nil {
+				return fmt.Errorf("migration v30->v31 failed creating path_metrics table: %w", err)
+			}
+
+			fromVersion = 31
+			err = setSchemaVersion(db, fromVersion)
+			if err != nil {
+				return err
+			}
+			log.Printf("[INFO] Successfully migrated to schema version 31")
+
+		case 31:
+			// Migration from version 31 to version 32
+			// Add parse_diagnostics table ([logging] parse_diagnostics)
+			log.Printf("[INFO] Migrating from v31 to v32: Adding parse_diagnostics table")
+
+			_, err := db.Exec(createParseDiagnosticsTable)
+			if err != nil {
+				return fmt.Errorf("migration v31->v32 failed creating parse_diagnostics table: %w", err)
+			}
+
+			fromVersion = 32
+			err = setSchemaVersion(db, fromVersion)
+			if err != nil {
+				return err
+			}
+			log.Printf("[INFO] Successfully migrated to schema version 32")
+
+		case 32:
+			// Migration from version 32 to version 33
+			// Add the state and action of Monit event notifications
+			log.Printf("[INFO] Migrating from v32 to v33: Adding event state and action columns")
+
+			for _, stmt := range []string{
+				"ALTER TABLE events ADD COLUMN state INTEGER",
+				"ALTER TABLE events ADD COLUMN action INTEGER",
+			} {
+				if _, err := db.Exec(stmt); err != nil {
+					return fmt.Errorf("migration v32->v33 failed: %w", err)
+				}
+			}
+
+			fromVersion = 33
+			err := setSchemaVersion(db, fromVersion)
+			if err != nil {
+				return err
+			}
+			log.Printf("[INFO] Successfully migrated to schema version 33")
+
+		case 33:
+			// Migration from version 33 to version 34
+			// Add validation_failures table ([collector] strict)
+			log.Printf("[INFO] Migrating from v33 to v34: Adding validation_failures table")
+
+			_, err := db.Exec(createValidationFailuresTable)
+			if err != nil {
+				return fmt.Errorf("migration v33->v34 failed creating validation_failures table: %w", err)
+			}
+
+			fromVersion = 34
+			err = setSchemaVersion(db, fromVersion)
+			if err != nil {
+				return err
+			}
+			log.Printf("[INFO] Successfully migrated to schema version 34")
+
+		case 34:
+			// Migration from version 34 to version 35
+			// Store each port, ICMP and unix socket check of a service in its
+			// own remote_host_metrics row, numbered by check_index
+			log.Printf("[INFO] Migrating from v34 to v35: Adding remote_host_metrics.check_index")
+
+			_, err := db.Exec("ALTER TABLE remote_host_metrics ADD COLUMN check_index INTEGER NOT NULL DEFAULT 0")
+			if err != nil {
+				return fmt.Errorf("migration v34->v35 failed: %w", err)
+			}
+
+			fromVersion = 35
+			err = setSchemaVersion(db, fromVersion)
+			if err != nil {
+				return err
+			}
+			log.Printf("[INFO] Successfully migrated to schema version 35")
+
+		case 35:
+			// Migration from version 35 to version 36
+			// Keep the whole program output when it is truncated ([storage]
+			// program_full_output)
+			log.Printf("[INFO] Migrating from v35 to v36: Adding program_metrics.full_output")
+
+			_, err := db.Exec("ALTER TABLE program_metrics ADD COLUMN full_output TEXT")
+			if err != nil {
+				return fmt.Errorf("migration v35->v36 failed: %w", err)
+			}
+
+			fromVersion = 36
+			err = setSchemaVersion(db, fromVersion)
+			if err != nil {
+				return err
+			}
+			log.Printf("[INFO] Successfully migrated to schema version 36")
+
+		case 36:
+			// Migration from version 36 to version 37
+			// Add the severity of the events. The stored events get the
+			// default rules of EventSeverity: info for the recoveries and
+			// changes, critical for the checks finding a service down or
+			// gone, warning otherwise
+			log.Printf("[INFO] Migrating from v36 to v37: Adding events.severity")
+
+			for _, stmt := range []struct {
+				query string
+				args  []interface{}
+			}{
+				{"ALTER TABLE events ADD COLUMN severity TEXT NOT NULL DEFAULT 'warning'", nil},
+				{"UPDATE events SET severity = 'info' WHERE state IN (0, 2, 3)", nil},
+				{`UPDATE events SET severity = 'critical' WHERE (state IS NULL OR state = 1)
+					AND (event_type & ? != 0 OR (event_type & ? != 0 AND EXISTS (SELECT 1 FROM services s
+						WHERE s.host_id = events.host_id AND s.name = events.service_name AND s.type = 0)))`,
+					[]interface{}{eventTypeMask(criticalEventTypes), eventTypeBits["resource"]}},
+			} {
+				if _, err := db.Exec(stmt.query, stmt.args...); err != nil {
+					return fmt.Errorf("migration v36->v37 failed: %w", err)
+				}
+			}
+
+			fromVersion = 37
+			err := setSchemaVersion(db, fromVersion)
+			if err != nil {
+				return err
+			}
+			log.Printf("[INFO] Successfully migrated to schema version 37")
+
+		case 37:
+			// Migration from version 37 to version 38
+			// Add event_daily_counts table ([retention] aggregate_events)
+			log.Printf("[INFO] Migrating from v37 to v38: Adding event_daily_counts table")
+
+			_, err := db.Exec(createEventDailyCountsTable)
+			if err != nil {
+				return fmt.Errorf("migration v37->v38 failed creating event_daily_counts table: %w", err)
+			}
+
+			fromVersion = 38
+			err = setSchemaVersion(db, fromVersion)
+			if err != nil {
+				return err
+			}
+			log.Printf("[INFO] Successfully migrated to schema version 38")
+
+		case 38:
+			// Migration from version 38 to version 39
+			// Add event_comments table
+			log.Printf("[INFO] Migrating from v38 to v39: Adding event_comments table")
+
+			_, err := db.Exec(createEventCommentsTable)
+			if err != nil {
+				return fmt.Errorf("migration v38->v39 failed creating event_comments table: %w", err)
+			}
+
+			fromVersion = 39
+			err = setSchemaVersion(db, fromVersion)
+			if err != nil {
+				return err
+			}
+			log.Printf("[INFO] Successfully migrated to schema version 39")
+
+		case 39:
+			// Migration from version 39 to version 40
+			// Add events_fts full-text index of the events
+			log.Printf("[INFO] Migrating from v39 to v40: Adding events_fts search index")
+
+			err := initEventSearch(db)
+			if err != nil {
+				return fmt.Errorf("migration v39->v40 failed: %w", err)
+			}
+
+			fromVersion = 40
+			err = setSchemaVersion(db, fromVersion)
+			if err != nil {
+				return err
+			}
+			log.Printf("[INFO] Successfully migrated to schema version 40")
+
+		case 40:
+			// Migration from version 40 to version 41
+			// Add the flapping events ([flapping]); the events_fts trigger
+			// following their message is created by initEventSearch
+			log.Printf("[INFO] Migrating from v40 to v41: Adding events.flap_count and flap_until")
+
+			for _, stmt := range []string{
+				"ALTER TABLE events ADD COLUMN flap_count INTEGER NOT NULL DEFAULT 0",
+				"ALTER TABLE events ADD COLUMN flap_until DATETIME",
+			} {
+				if _, err := db.Exec(stmt); err != nil {
+					return fmt.Errorf("migration v40->v41 failed: %w", err)
+				}
+			}
+
+			fromVersion = 41
+			err := setSchemaVersion(db, fromVersion)
+			if err != nil {
+				return err
+			}
+			log.Printf("[INFO] Successfully migrated to schema version 41")
+
+		case 41:
+			// Migration from version 41 to version 42
+			// Add the source of the events recorded by external systems
+			// (POST /api/v1/events)
+			log.Printf("[INFO] Migrating from v41 to v42: Adding events.source")
+
+			_, err := db.Exec("ALTER TABLE events ADD COLUMN source TEXT")
+			if err != nil {
+				return fmt.Errorf("migration v41->v42 failed: %w", err)
+			}
+
+			fromVersion = 42
+			err = setSchemaVersion(db, fromVersion)
+			if err != nil {
+				return err
+			}
+			log.Printf("[INFO] Successfully migrated to schema version 42")
+
+		case 42:
+			// Migration from version 42 to version 43
+			// Add host archiving (soft delete)
+			log.Printf("[INFO] Migrating from v42 to v43: Adding hosts.archived_at")
+
+			_, err := db.Exec("ALTER TABLE hosts ADD COLUMN archived_at DATETIME")
+			if err != nil {
+				return fmt.Errorf("migration v42->v43 failed: %w", err)
+			}
+
+			fromVersion = 43
+			err = setSchemaVersion(db, fromVersion)
+			if err != nil {
+				return err
+			}
+			log.Printf("[INFO] Successfully migrated to schema version 43")
+
+		case 43:
+			// Migration from version 43 to version 44
+			// Record when hosts are unarchived by hand, so that the
+			// retention ([retention] archive_hosts) leaves them alone
+			log.Printf("[INFO] Migrating from v43 to v44: Adding hosts.unarchived_at")
+
+			_, err := db.Exec("ALTER TABLE hosts ADD COLUMN unarchived_at DATETIME")
+			if err != nil {
+				return fmt.Errorf("migration v43->v44 failed: %w", err)
+			}
+
+			fromVersion = 44
+			err = setSchemaVersion(db, fromVersion)
+			if err != nil {
+				return err
+			}
+			log.Printf("[INFO] Successfully migrated to schema version 44")
+
 		default:
 			return fmt.Errorf("no migration path from version %d", fromVersion)
 		}
@@ -0,0 +1,162 @@
+// Package db - secrets.go encrypts the secrets stored in the database, such
+// as per-host Monit passwords.
+//
+// Secrets are encrypted with AES-256-GCM using a key kept in a file outside
+// the database (-secret-key-file), so that copies of the database (backups,
+// exports) do not reveal them. The key file is created on first use.
+package db
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// secretPrefix marks encrypted values, and their format version.
+const secretPrefix = "v1:"
+
+var (
+	secretKeyMu   sync.Mutex
+	secretKeyFile string
+	secretKey     []byte
+)
+
+// SetSecretKeyFile sets the file holding the key of stored secrets. An
+// existing file is loaded now, to report a bad key at startup; a missing
+// one is created when the first secret is stored.
+func SetSecretKeyFile(path string) error {
+	secretKeyMu.Lock()
+	defer secretKeyMu.Unlock()
+	secretKeyFile = path
+	secretKey = nil
+
+	key, err := readSecretKey(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	secretKey = key
+	return nil
+}
+
+// readSecretKey reads a key file: 64 hex digits (32 bytes).
+func readSecretKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("invalid secret key file %s: must hold 64 hex digits", path)
+	}
+	return key, nil
+}
+
+// getSecretKey returns the key of stored secrets, creating the key file if
+// create is true and it does not exist.
+func getSecretKey(create bool) ([]byte, error) {
+	secretKeyMu.Lock()
+	defer secretKeyMu.Unlock()
+	if secretKey != nil {
+		return secretKey, nil
+	}
+	if secretKeyFile == "" {
+		return nil, fmt.Errorf("no secret key file configured")
+	}
+
+	key, err := readSecretKey(secretKeyFile)
+	if errors.Is(err, os.ErrNotExist) && create {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("failed to generate secret key: %w", err)
+		}
+		// O_EXCL: never overwrite a key another process just created
+		f, err := os.OpenFile(secretKeyFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create secret key file: %w", err)
+		}
+		if _, err := f.WriteString(hex.EncodeToString(key) + "\n"); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to write secret key file: %w", err)
+		}
+		if err := f.Close(); err != nil {
+			return nil, fmt.Errorf("failed to write secret key file: %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read secret key file: %w", err)
+	}
+	secretKey = key
+	return key, nil
+}
+
+// EncryptSecret encrypts a secret for storage. scope (e.g. the host ID) is
+// authenticated with it, so the value cannot be moved to another row. An
+// empty secret stays empty.
+func EncryptSecret(secret, scope string) (string, error) {
+	if secret == "" {
+		return "", nil
+	}
+	key, err := getSecretKey(true)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := newSecretCipher(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(secret), []byte(scope))
+	return secretPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptSecret decrypts a value stored by EncryptSecret with the same
+// scope.
+func DecryptSecret(value, scope string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	if !strings.HasPrefix(value, secretPrefix) {
+		return "", fmt.Errorf("unknown secret format")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, secretPrefix))
+	if err != nil {
+		return "", fmt.Errorf("invalid secret encoding: %w", err)
+	}
+	key, err := getSecretKey(false)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := newSecretCipher(key)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", fmt.Errorf("invalid secret: too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plain, err := gcm.Open(nil, nonce, ciphertext, []byte(scope))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt secret (was the secret key file changed?)")
+	}
+	return string(plain), nil
+}
+
+func newSecretCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,199 @@
+// Package db - severity.go classifies the events as info, warning or
+// critical, from their event type, the type of their service and their
+// state, with the rules of the configuration ([[severity]] tables) before
+// the default ones.
+package db
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Event severities, stored in events.severity.
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// Severities lists the event severities, lowest first.
+var Severities = []string{SeverityInfo, SeverityWarning, SeverityCritical}
+
+// eventTypeBits are the names of the Monit event types used by the
+// severity rules, with their bit (monit-5.35.2/src/event.h Event_Type).
+var eventTypeBits = map[string]int{
+	"checksum":   0x1,
+	"resource":   0x2,
+	"timeout":    0x4,
+	"timestamp":  0x8,
+	"size":       0x10,
+	"connection": 0x20,
+	"permission": 0x40,
+	"uid":        0x80,
+	"gid":        0x100,
+	"nonexist":   0x200,
+	"invalid":    0x400,
+	"data":       0x800,
+	"exec":       0x1000,
+	"fsflags":    0x2000,
+	"icmp":       0x4000,
+	"content":    0x8000,
+	"instance":   0x10000,
+	"action":     0x20000,
+	"pid":        0x40000,
+	"ppid":       0x80000,
+	"heartbeat":  0x100000,
+	"status":     0x200000,
+	"uptime":     0x400000,
+	"link":       0x800000,
+	"speed":      0x1000000,
+	"saturation": 0x2000000,
+	"bytein":     0x4000000,
+	"byteout":    0x8000000,
+	"packetin":   0x10000000,
+	"packetout":  0x20000000,
+	"exist":      0x40000000,
+}
+
+// serviceTypeNames are the names of the Monit service types, by type.
+var serviceTypeNames = []string{"filesystem", "directory", "file", "process", "host", "system", "fifo", "program", "net"}
+
+// eventStateNames are the names of the Monit event states, by state.
+var eventStateNames = []string{"succeeded", "failed", "changed", "changed_not"}
+
+// SeverityRule gives a severity to the events matching all its lists; an
+// empty list matches everything.
+type SeverityRule struct {
+	Events       []string // Event type names ("checksum", "nonexist"...)
+	ServiceTypes []string // Service type names ("file", "process"...)
+	States       []string // Event states ("succeeded", "failed", "changed", "changed_not")
+	Severity     string   // info, warning or critical
+}
+
+// severityRule is a SeverityRule with its lists as bit masks, 0 for all.
+type severityRule struct {
+	events, serviceTypes, states int
+	severity                     string
+}
+
+// criticalEventTypes are the checks finding a service down or gone.
+var criticalEventTypes = []string{"nonexist", "connection", "icmp", "exec", "timeout", "heartbeat", "link", "data", "invalid"}
+
+// defaultSeverityRules apply after the configured ones: recoveries and
+// changes are informational, the checks finding a service down or gone are
+// critical, as is a full filesystem, and everything else is a warning.
+var defaultSeverityRules = []SeverityRule{
+	{States: []string{"succeeded", "changed", "changed_not"}, Severity: SeverityInfo},
+	{Events: criticalEventTypes, Severity: SeverityCritical},
+	{Events: []string{"resource"}, ServiceTypes: []string{"filesystem"}, Severity: SeverityCritical},
+}
+
+// severityRules are the compiled configured and default rules, in order.
+var severityRules = mustCompileSeverityRules(defaultSeverityRules)
+
+// SetSeverityRules checks the configured rules and installs them before
+// the default ones.
+func SetSeverityRules(rules []SeverityRule) error {
+	compiled, err := compileSeverityRules(rules)
+	if err != nil {
+		return err
+	}
+	severityRules = append(compiled, mustCompileSeverityRules(defaultSeverityRules)...)
+	return nil
+}
+
+func mustCompileSeverityRules(rules []SeverityRule) []severityRule {
+	compiled, err := compileSeverityRules(rules)
+	if err != nil {
+		panic(err)
+	}
+	return compiled
+}
+
+func compileSeverityRules(rules []SeverityRule) ([]severityRule, error) {
+	compiled := make([]severityRule, 0, len(rules))
+	for i, rule := range rules {
+		c := severityRule{severity: strings.ToLower(strings.TrimSpace(rule.Severity))}
+		if !validSeverity(c.severity) {
+			return nil, fmt.Errorf("severity rule %d: invalid severity %q (valid: %s)", i+1, rule.Severity, strings.Join(Severities, ", "))
+		}
+		for _, name := range rule.Events {
+			bit, ok := eventTypeBits[strings.ToLower(name)]
+			if !ok {
+				return nil, fmt.Errorf("severity rule %d: unknown event type %q (valid: %s)", i+1, name, strings.Join(eventTypeNames(), ", "))
+			}
+			c.events |= bit
+		}
+		for _, name := range rule.ServiceTypes {
+			n := nameIndex(serviceTypeNames, name)
+			if n < 0 {
+				return nil, fmt.Errorf("severity rule %d: unknown service type %q (valid: %s)", i+1, name, strings.Join(serviceTypeNames, ", "))
+			}
+			c.serviceTypes |= 1 << n
+		}
+		for _, name := range rule.States {
+			n := nameIndex(eventStateNames, name)
+			if n < 0 {
+				return nil, fmt.Errorf("severity rule %d: unknown state %q (valid: %s)", i+1, name, strings.Join(eventStateNames, ", "))
+			}
+			c.states |= 1 << n
+		}
+		compiled = append(compiled, c)
+	}
+	return compiled, nil
+}
+
+// EventSeverity returns the severity of an event of eventType, on a
+// service of serviceType, in state; serviceType and state are -1 when
+// unknown, and then only match the rules not listing them.
+func EventSeverity(eventType, serviceType, state int) string {
+	for _, rule := range severityRules {
+		if rule.events != 0 && eventType&rule.events == 0 {
+			continue
+		}
+		if rule.serviceTypes != 0 && (serviceType < 0 || rule.serviceTypes&(1<<serviceType) == 0) {
+			continue
+		}
+		if rule.states != 0 && (state < 0 || rule.states&(1<<state) == 0) {
+			continue
+		}
+		return rule.severity
+	}
+	return SeverityWarning
+}
+
+// eventTypeMask returns the bits of the event types of names, which must
+// be known.
+func eventTypeMask(names []string) int {
+	mask := 0
+	for _, name := range names {
+		mask |= eventTypeBits[name]
+	}
+	return mask
+}
+
+// validSeverity reports whether s is one of Severities.
+func validSeverity(s string) bool {
+	return nameIndex(Severities, s) >= 0
+}
+
+// nameIndex returns the index of name in names, ignoring case, or -1.
+func nameIndex(names []string, name string) int {
+	for i, n := range names {
+		if strings.EqualFold(n, strings.TrimSpace(name)) {
+			return i
+		}
+	}
+	return -1
+}
+
+// eventTypeNames returns the sorted names of eventTypeBits.
+func eventTypeNames() []string {
+	names := make([]string, 0, len(eventTypeBits))
+	for name := range eventTypeBits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
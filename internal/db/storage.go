@@ -151,8 +151,10 @@ func StoreHost(db queryer, server *parser.Server, platform *parser.Platform, sys
 			boottime = excluded.boottime,
 			monit_uptime = excluded.monit_uptime,
 			poll_interval = excluded.poll_interval,
-			last_seen = excluded.last_seen
-			-- created_at and description are preserved (not updated)
+			last_seen = excluded.last_seen,
+			archived_at = NULL
+			-- created_at and description are preserved (not updated),
+			-- an archived host reporting again is unarchived
 	`
 
 	// Get the current time
@@ -299,13 +301,14 @@ func StoreEvent(db queryer, hostID, serviceName string, eventType int, message s
 			service_name,
 			event_type,
 			message,
-			created_at
-		) VALUES (?, ?, ?, ?, ?)
+			created_at,
+			severity
+		) VALUES (?, ?, ?, ?, ?, ?)
 	`
 
 	now := time.Now()
 
-	_, err := db.Exec(query, hostID, serviceName, eventType, message, now)
+	_, err := db.Exec(query, hostID, serviceName, eventType, message, now, EventSeverity(eventType, -1, -1))
 	if err != nil {
 		log.Printf("[ERROR] Failed to store event for %s/%s: %v", hostID, serviceName, err)
 		return fmt.Errorf("failed to store event: %w", err)
@@ -426,7 +429,7 @@ func RecordHostAvailability(db queryer, hostID string, timestamp int64, pollInte
 // 3. Records a new availability data point
 //
 // This creates a complete time-series of availability, including periods when
-// hosts are offline and not sending data.
+// hosts are offline and not sending data. Archived hosts are skipped.
 //
 // Parameters:
 //   - db: Database connection
@@ -443,7 +446,7 @@ func RecordAvailabilityForAllHosts(db *sql.DB) error {
 	const query = `
 		SELECT id, CAST(strftime('%s', last_seen) AS INTEGER) as last_seen, poll_interval
 		FROM hosts
-		WHERE poll_interval > 0
+		WHERE poll_interval > 0 AND archived_at IS NULL
 	`
 
 	rows, err := db.Query(query)
@@ -495,40 +498,152 @@ func RecordAvailabilityForAllHosts(db *sql.DB) error {
 	return nil
 }
 
-// PruneOldData deletes metrics and events older than retentionDays.
+// EventRetention sets which events PruneOldData deletes.
+type EventRetention struct {
+	// Age deletes the events older than it; <= 0 means the default (30 days)
+	Age time.Duration
+
+	// PerHost keeps at most this many events of each host, the newest;
+	// 0 means no limit
+	PerHost int
+
+	// Aggregate adds the deleted events to their daily counts first (see
+	// GetEventDailyCounts)
+	Aggregate bool
+}
+
+// PruneOldData deletes the metrics older than metricsAge and the events
+// beyond the events retention.
 //
 // metrics/events are append-only time-series tables; without pruning they
 // grow without bound. Called periodically from a background goroutine
 // (see main.go), not on every write, since it's a bulk operation.
 //
-// retentionDays <= 0 is treated as the default (30 days) rather than
-// disabling pruning, since 0 would otherwise delete everything.
-func PruneOldData(db *sql.DB, retentionDays int) error {
-	if retentionDays <= 0 {
-		retentionDays = 30
+// An age <= 0 is treated as the default (30 days) rather than disabling
+// pruning, since 0 would otherwise delete everything.
+func PruneOldData(db *sql.DB, metricsAge time.Duration, events EventRetention) error {
+	const defaultAge = 30 * 24 * time.Hour
+	if metricsAge <= 0 {
+		metricsAge = defaultAge
+	}
+	if events.Age <= 0 {
+		events.Age = defaultAge
 	}
 
-	cutoff := time.Now().AddDate(0, 0, -retentionDays)
+	metricsCutoff := time.Now().Add(-metricsAge)
+	eventsCutoff := time.Now().Add(-events.Age)
 
-	metricsResult, err := db.Exec("DELETE FROM metrics WHERE collected_at < ?", cutoff)
+	metricsResult, err := db.Exec("DELETE FROM metrics WHERE collected_at < ?", metricsCutoff)
 	if err != nil {
 		return fmt.Errorf("failed to prune metrics: %w", err)
 	}
 
-	eventsResult, err := db.Exec("DELETE FROM events WHERE created_at < ?", cutoff)
+	eventsDeleted, err := pruneEvents(db, eventsCutoff, events)
 	if err != nil {
-		return fmt.Errorf("failed to prune events: %w", err)
+		return err
 	}
 
 	if debugMode {
 		metricsDeleted, _ := metricsResult.RowsAffected()
-		eventsDeleted, _ := eventsResult.RowsAffected()
-		log.Printf("[DEBUG] Pruned %d metrics rows and %d events rows older than %s", metricsDeleted, eventsDeleted, cutoff.Format(time.RFC3339))
+		log.Printf("[DEBUG] Pruned %d metrics rows older than %s and %d events rows older than %s",
+			metricsDeleted, metricsCutoff.Format(time.RFC3339), eventsDeleted, eventsCutoff.Format(time.RFC3339))
 	}
 
 	return nil
 }
 
+// pruneEvents deletes the events older than cutoff, and those beyond the
+// newest retention.PerHost of their host, after adding them to their daily
+// counts with retention.Aggregate. Returns the number of events deleted.
+func pruneEvents(db *sql.DB, cutoff time.Time, retention EventRetention) (int64, error) {
+	where := "created_at < ?"
+	args := []interface{}{cutoff}
+	if retention.PerHost > 0 {
+		where += ` OR id IN (SELECT id FROM (
+			SELECT id, ROW_NUMBER() OVER (PARTITION BY host_id ORDER BY created_at DESC, id DESC) AS n
+			FROM events) WHERE n > ?)`
+		args = append(args, retention.PerHost)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() // no-op if Commit succeeds
+
+	if retention.Aggregate {
+		// created_at is stored as text in the server's local time: its
+		// first 10 characters are the local day
+		_, err := tx.Exec(`INSERT INTO event_daily_counts (host_id, day, service_name, event_type, severity, count)
+			SELECT host_id, substr(created_at, 1, 10), service_name, COALESCE(event_type, 0), severity, COUNT(*)
+			FROM events WHERE `+where+`
+			GROUP BY host_id, substr(created_at, 1, 10), service_name, COALESCE(event_type, 0), severity
+			ON CONFLICT(host_id, day, service_name, event_type, severity) DO UPDATE SET
+				count = count + excluded.count`, args...)
+		if err != nil {
+			return 0, fmt.Errorf("failed to aggregate events: %w", err)
+		}
+	}
+
+	result, err := tx.Exec("DELETE FROM events WHERE "+where, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune events: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	deleted, _ := result.RowsAffected()
+	return deleted, nil
+}
+
+// EventDailyCount is the number of events of a day aggregated by the
+// events retention ([retention] aggregate_events) before they were deleted.
+type EventDailyCount struct {
+	HostID    string `json:"host_id"`
+	Day       string `json:"day"` // YYYY-MM-DD, in the server's local time
+	Service   string `json:"service"`
+	EventType int    `json:"event_type"`
+	Severity  string `json:"severity"`
+	Count     int64  `json:"count"`
+}
+
+// GetEventDailyCounts returns the daily counts of the deleted events of a
+// host (all hosts if empty), between the days from and to (YYYY-MM-DD,
+// inclusive; unbounded if empty), oldest first.
+func GetEventDailyCounts(db *sql.DB, hostID, from, to string) ([]EventDailyCount, error) {
+	query := "SELECT host_id, day, service_name, event_type, severity, count FROM event_daily_counts WHERE 1=1"
+	var args []interface{}
+	if hostID != "" {
+		query += " AND host_id = ?"
+		args = append(args, hostID)
+	}
+	if from != "" {
+		query += " AND day >= ?"
+		args = append(args, from)
+	}
+	if to != "" {
+		query += " AND day <= ?"
+		args = append(args, to)
+	}
+	query += " ORDER BY day, host_id, service_name, event_type, severity"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get event daily counts: %w", err)
+	}
+	defer rows.Close()
+
+	counts := []EventDailyCount{}
+	for rows.Next() {
+		var c EventDailyCount
+		if err := rows.Scan(&c.HostID, &c.Day, &c.Service, &c.EventType, &c.Severity, &c.Count); err != nil {
+			return nil, fmt.Errorf("failed to scan event daily counts: %w", err)
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
+
 // StoreService saves or updates a service record in the database.
 //
 // This function stores the current status of a monitored service.
@@ -544,6 +659,9 @@ func PruneOldData(db *sql.DB, retentionDays int) error {
 //
 // Note: This only stores the service status, not the metrics.
 // Metrics (CPU%, memory%, etc.) are stored separately in StoreMetrics.
+// A change of status since the previous report is recorded as an event,
+// e.g. "nginx: OK → Connection failed", so that the events table is filled
+// even by agents not configured to post their events.
 func StoreService(db queryer, hostID string, service *parser.Service) error {
 	// SQL query to insert or update the service record
 	//
@@ -567,8 +685,11 @@ func StoreService(db queryer, hostID string, service *parser.Service) error {
 			memory_percent,
 			memory_kb,
 			collected_at,
-			last_seen
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			last_seen,
+			every_type,
+			every_cycles,
+			every_cron
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT(host_id, name) DO UPDATE SET
 			type = excluded.type,
 			status = excluded.status,
@@ -578,7 +699,10 @@ func StoreService(db queryer, hostID string, service *parser.Service) error {
 			memory_percent = excluded.memory_percent,
 			memory_kb = excluded.memory_kb,
 			collected_at = excluded.collected_at,
-			last_seen = excluded.last_seen
+			last_seen = excluded.last_seen,
+			every_type = excluded.every_type,
+			every_cycles = excluded.every_cycles,
+			every_cron = excluded.every_cron
 	`
 
 	// Get the collection timestamp from the service
@@ -605,8 +729,28 @@ func StoreService(db queryer, hostID string, service *parser.Service) error {
 		}
 	}
 
+	// Check schedule ("every"): each cycle unless the agent says otherwise
+	everyType, everyCycles, everyCron := parser.EveryCycle, 1, ""
+	if service.Every != nil && service.Every.Type >= parser.EveryCycle {
+		everyType, everyCron = service.Every.Type, service.Every.Cron
+		if service.Every.Type == parser.EverySkipCycles && service.Every.Number > 1 {
+			everyCycles = service.Every.Number
+		}
+	}
+
+	// Previous status of the service, to record its transitions as events
+	// (see storeTransitionEvent); none for a new service
+	var before int
+	var previousAt time.Time
+	err := db.QueryRow("SELECT status, collected_at FROM services WHERE host_id = ? AND name = ?",
+		hostID, service.Name).Scan(&before, &previousAt)
+	known := err == nil
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("[WARN] Failed to read previous status of %s/%s: %v", hostID, service.Name, err)
+	}
+
 	// Execute the query
-	_, err := db.Exec(
+	_, err = db.Exec(
 		query,
 		hostID,              // Which host this service belongs to
 		service.Name,        // Service name (e.g., "nginx", "system", "sshd")
@@ -619,6 +763,9 @@ func StoreService(db queryer, hostID string, service *parser.Service) error {
 		memoryKB,            // Memory usage in KB (for process services)
 		collectedAt,         // When Monit collected this data
 		now,                 // When we received/processed it
+		everyType,           // Check schedule (parser.EveryCycle...)
+		everyCycles,         // Checked every N cycles (EverySkipCycles)
+		everyCron,           // Cron spec (EveryCron, EveryNotInCron)
 	)
 
 	if err != nil {
@@ -631,6 +778,13 @@ func StoreService(db queryer, hostID string, service *parser.Service) error {
 			hostID, service.Name, service.Type, service.Status)
 	}
 
+	// The status of a service not monitored means nothing
+	if known && before != service.Status && service.Monitor != 0 {
+		if err := storeTransitionEvent(db, hostID, service, before, previousAt); err != nil {
+			log.Printf("[WARN] %v", err)
+		}
+	}
+
 	return nil
 }
 
@@ -800,6 +954,28 @@ func StoreSystemMetrics(db queryer, hostID string, service *parser.Service) erro
 		return err
 	}
 
+	// Linux also reports interrupt, steal and guest time: store the ones
+	// the agent sent, for the CPU breakdown of the system service page
+	optionalCPU := []struct {
+		name  string
+		value *float64
+	}{
+		{"hardirq", service.System.CPU.HardIRQ},
+		{"softirq", service.System.CPU.SoftIRQ},
+		{"steal", service.System.CPU.Steal},
+		{"guest", service.System.CPU.Guest},
+		{"guestnice", service.System.CPU.GuestNice},
+	}
+	for _, m := range optionalCPU {
+		if m.value == nil {
+			continue
+		}
+		err = StoreMetric(db, hostID, service.Name, "cpu", m.name, *m.value, collectedAt)
+		if err != nil {
+			return err
+		}
+	}
+
 	// Store memory usage metrics
 	//
 	// We store both percentage and absolute values:
@@ -991,14 +1167,93 @@ func StoreHostGroups(db queryer, hostID string, groupNames []string) error {
 	return nil
 }
 
+// StoreServiceGroups replaces the service groups of a host with groups,
+// from the <servicegroups> of its status report. The group names join
+// the host groups in the group filter of the status page.
+func StoreServiceGroups(db queryer, hostID string, groups []parser.ServiceGroup) error {
+	if _, err := db.Exec("DELETE FROM service_groups WHERE host_id = ?", hostID); err != nil {
+		return fmt.Errorf("failed to clear service groups: %w", err)
+	}
+	for _, group := range groups {
+		if group.Name == "" {
+			continue
+		}
+		for _, service := range group.Services {
+			_, err := db.Exec("INSERT OR IGNORE INTO service_groups (host_id, group_name, service_name) VALUES (?, ?, ?)",
+				hostID, group.Name, service)
+			if err != nil {
+				return fmt.Errorf("failed to store service group %s: %w", group.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// StoreServiceDependencies replaces the service dependencies of a host
+// with the <depend> of its services.
+func StoreServiceDependencies(db queryer, hostID string, services []parser.Service) error {
+	if _, err := db.Exec("DELETE FROM service_dependencies WHERE host_id = ?", hostID); err != nil {
+		return fmt.Errorf("failed to clear service dependencies: %w", err)
+	}
+	for _, service := range services {
+		for _, dep := range service.Depends {
+			if dep == "" {
+				continue
+			}
+			_, err := db.Exec("INSERT OR IGNORE INTO service_dependencies (host_id, service_name, depends_on) VALUES (?, ?, ?)",
+				hostID, service.Name, dep)
+			if err != nil {
+				return fmt.Errorf("failed to store dependency of %s: %w", service.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// ServiceDependency is a "depends on" relation between two services of a
+// host.
+type ServiceDependency struct {
+	Service   string // Dependent service
+	DependsOn string // Service it depends on
+}
+
+// GetServiceDependencies returns the service dependencies of a host,
+// ordered by dependent service.
+func GetServiceDependencies(db queryer, hostID string) ([]ServiceDependency, error) {
+	rows, err := db.Query(`SELECT service_name, depends_on FROM service_dependencies
+		WHERE host_id = ? ORDER BY service_name, depends_on`, hostID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deps []ServiceDependency
+	for rows.Next() {
+		var d ServiceDependency
+		if err := rows.Scan(&d.Service, &d.DependsOn); err != nil {
+			return nil, err
+		}
+		deps = append(deps, d)
+	}
+	return deps, rows.Err()
+}
+
+// HostID returns the ID of the host of a status document: the Monit ID,
+// or one generated from the hostname and incarnation without idfile.
+func HostID(status *parser.MonitStatus) string {
+	if status.Server.ID != "" {
+		return status.Server.ID
+	}
+	return fmt.Sprintf("%s-%d", status.Server.LocalHostname, status.Server.Incarnation)
+}
+
 func StoreMonitStatus(db *sql.DB, status *parser.MonitStatus) error {
 	// Generate host ID (same logic as in StoreHost)
 	//
 	// We generate the ID here so we can pass it to all storage functions.
 	// If Monit provides an ID, use it. Otherwise, generate one from hostname + incarnation.
-	hostID := status.Server.ID
-	if hostID == "" {
-		hostID = fmt.Sprintf("%s-%d", status.Server.LocalHostname, status.Server.Incarnation)
+	hostID := HostID(status)
+	if status.Server.ID == "" {
 		log.Printf("[INFO] Generated host ID: %s (no idfile configured in Monit)", hostID)
 	}
 
@@ -1043,6 +1298,18 @@ func StoreMonitStatus(db *sql.DB, status *parser.MonitStatus) error {
 		log.Printf("[WARN] Failed to store host groups for %s: %v", hostID, err)
 	}
 
+	// Step 2.6: Store service groups (<servicegroups>)
+	err = StoreServiceGroups(tx, hostID, status.ServiceGroups)
+	if err != nil {
+		log.Printf("[WARN] Failed to store service groups for %s: %v", hostID, err)
+	}
+
+	// Step 2.7: Store service dependencies (<depend> of each service)
+	err = StoreServiceDependencies(tx, hostID, status.Services)
+	if err != nil {
+		log.Printf("[WARN] Failed to store service dependencies for %s: %v", hostID, err)
+	}
+
 	// Step 3: Store all services
 	//
 	// Loop through each service in the status update.
@@ -1103,6 +1370,12 @@ func StoreMonitStatus(db *sql.DB, status *parser.MonitStatus) error {
 				log.Printf("[WARN] Failed to store file metrics for %s: %v", service.Name, err)
 			}
 
+		case 1, 6: // Directory or FIFO service
+			err = StorePathMetrics(tx, hostID, service)
+			if err != nil {
+				log.Printf("[WARN] Failed to store path metrics for %s: %v", service.Name, err)
+			}
+
 		case 7: // Program service
 			err = StoreProgramMetrics(tx, hostID, service)
 			if err != nil {
@@ -1190,14 +1463,6 @@ func StoreFilesystemMetrics(db queryer, hostID string, service *parser.Service)
 		return ""
 	}
 
-	// Helper function to safely get int64 value
-	getInt64 := func(i *int64) int64 {
-		if i != nil {
-			return *i
-		}
-		return 0
-	}
-
 	// Insert filesystem metrics into the database
 	//
 	// Using INSERT OR REPLACE means:
@@ -1212,10 +1477,22 @@ func StoreFilesystemMetrics(db queryer, hostID string, service *parser.Service)
 			inode_percent, inode_usage, inode_total,
 			read_bytes_total, read_ops_total,
 			write_bytes_total, write_ops_total,
+			read_bytes_rate, read_ops_rate,
+			write_bytes_rate, write_ops_rate,
 			collected_at
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
+	// <read> and <write> are missing when Monit has no I/O statistics for
+	// the filesystem (e.g. network filesystems)
+	var readIO, writeIO parser.FilesystemIO
+	if service.ReadIO != nil {
+		readIO = *service.ReadIO
+	}
+	if service.WriteIO != nil {
+		writeIO = *service.WriteIO
+	}
+
 	// Get UID and GID from the service fields (these might be used for file system too)
 	var uid, gid int
 	if service.UID != nil {
@@ -1239,10 +1516,14 @@ func StoreFilesystemMetrics(db queryer, hostID string, service *parser.Service)
 		service.Inode.Percent,
 		service.Inode.Usage,
 		service.Inode.Total,
-		getInt64(&service.ReadIO.Bytes.Total),
-		getInt64(&service.ReadIO.Operations.Total),
-		getInt64(&service.WriteIO.Bytes.Total),
-		getInt64(&service.WriteIO.Operations.Total),
+		readIO.Bytes.Total,
+		readIO.Operations.Total,
+		writeIO.Bytes.Total,
+		writeIO.Operations.Total,
+		readIO.Bytes.Count,
+		readIO.Operations.Count,
+		writeIO.Bytes.Count,
+		writeIO.Operations.Count,
 		collectedAt,
 	)
 
@@ -1265,6 +1546,7 @@ func StoreFilesystemMetrics(db queryer, hostID string, service *parser.Service)
 // - Link speed (bits per second) and duplex mode
 // - Download traffic (packets, bytes, errors - current and total)
 // - Upload traffic (packets, bytes, errors - current and total)
+// - Current download/upload bytes per second as "network" metrics
 //
 // Parameters:
 //   - db: Database connection
@@ -1334,6 +1616,24 @@ func StoreNetworkMetrics(db queryer, hostID string, service *parser.Service) err
 		return fmt.Errorf("failed to store network metrics: %w", err)
 	}
 
+	// Also sample current throughput into the metrics table
+	//
+	// network_metrics keeps the full counters of each report; the "now"
+	// byte rates also go to metrics so the bandwidth graphs get the same
+	// aggregation (agg/bucket) and retention (PruneOldData) as the other
+	// time series.
+	err = StoreMetric(db, hostID, service.Name, "network", "download_bytes",
+		float64(service.Link.Download.Bytes.Now), collectedAt)
+	if err != nil {
+		return err
+	}
+
+	err = StoreMetric(db, hostID, service.Name, "network", "upload_bytes",
+		float64(service.Link.Upload.Bytes.Now), collectedAt)
+	if err != nil {
+		return err
+	}
+
 	if debugMode {
 		// Convert speed from bits/sec to Mbps for display
 		speedMbps := float64(service.Link.Speed) / 1000000
@@ -1422,6 +1722,56 @@ func StoreFileMetrics(db queryer, hostID string, service *parser.Service) error
 	return nil
 }
 
+// StorePathMetrics stores directory and FIFO service metrics into the
+// database: permissions, owner and timestamps.
+//
+// Parameters:
+//   - db: Database connection
+//   - hostID: Host identifier (from hosts table)
+//   - service: Parsed service data from Monit XML
+//
+// Returns:
+//   - error: nil if successful, error describing problem if failed
+func StorePathMetrics(db queryer, hostID string, service *parser.Service) error {
+	// Check if this is actually a directory or FIFO service
+	if service.Type != 1 && service.Type != 6 {
+		return nil
+	}
+	if service.Path == nil {
+		return nil
+	}
+
+	query := `
+		INSERT INTO path_metrics (
+			host_id, service_name,
+			mode, uid, gid,
+			access_time, change_time, modify_time,
+			collected_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	_, err := db.Exec(query,
+		hostID,
+		service.Name,
+		service.Path.Mode,
+		service.Path.UID,
+		service.Path.GID,
+		service.Path.Timestamps.Access,
+		service.Path.Timestamps.Change,
+		service.Path.Timestamps.Modify,
+		service.GetCollectedTime(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to store path metrics: %w", err)
+	}
+
+	if debugMode {
+		log.Printf("[DEBUG] Stored path metrics for %s/%s (mode: %s)", hostID, service.Name, service.Path.Mode)
+	}
+
+	return nil
+}
+
 // StoreProgramMetrics stores program service metrics into the database.
 //
 // This function captures program status check data including:
@@ -1452,13 +1802,19 @@ func StoreProgramMetrics(db queryer, hostID string, service *parser.Service) err
 	// Get the collection timestamp
 	collectedAt := service.GetCollectedTime()
 
+	// The whole output is only there when it was truncated and kept
+	var fullOutput *string
+	if service.Program.FullOutput != "" {
+		fullOutput = &service.Program.FullOutput
+	}
+
 	// Insert program metrics into the database
 	query := `
 		INSERT INTO program_metrics (
 			host_id, service_name,
-			started, exit_status, output,
+			started, exit_status, output, full_output,
 			collected_at
-		) VALUES (?, ?, ?, ?, ?, ?)
+		) VALUES (?, ?, ?, ?, ?, ?, ?)
 	`
 
 	_, err := db.Exec(query,
@@ -1467,6 +1823,7 @@ func StoreProgramMetrics(db queryer, hostID string, service *parser.Service) err
 		service.Program.Started,
 		service.Program.Status,
 		service.Program.Output,
+		fullOutput,
 		collectedAt,
 	)
 
@@ -1508,17 +1865,17 @@ func StoreRemoteHostMetrics(db queryer, hostID string, service *parser.Service)
 	// DEBUG: Log service name and type
 	if debugMode {
 		log.Printf("[DEBUG] StoreRemoteHostMetrics called for %s/%s (type %d)", hostID, service.Name, service.Type)
-		log.Printf("[DEBUG]   ICMP: %v, Port: %v, Unix: %v", service.ICMP != nil, service.Port != nil, service.Unix != nil)
-		if service.ICMP != nil {
-			log.Printf("[DEBUG]   ICMP data: type=%s, responsetime=%.6f", service.ICMP.Type, service.ICMP.ResponseTime)
+		log.Printf("[DEBUG]   ICMP: %d, Port: %d, Unix: %d", len(service.ICMP), len(service.Port), len(service.Unix))
+		for _, icmp := range service.ICMP {
+			log.Printf("[DEBUG]   ICMP data: type=%s, responsetime=%.6f", icmp.Type, icmp.ResponseTime)
 		}
-		if service.Port != nil {
-			log.Printf("[DEBUG]   Port data: hostname=%s, port=%d, responsetime=%.6f", service.Port.Hostname, service.Port.PortNumber, service.Port.ResponseTime)
+		for _, port := range service.Port {
+			log.Printf("[DEBUG]   Port data: hostname=%s, port=%d, responsetime=%.6f", port.Hostname, port.PortNumber, port.ResponseTime)
 		}
 	}
 
 	// Check if any remote host metrics are present
-	if service.ICMP == nil && service.Port == nil && service.Unix == nil {
+	if len(service.ICMP) == 0 && len(service.Port) == 0 && len(service.Unix) == 0 {
 		// No remote host metrics in this service
 		if debugMode {
 			log.Printf("[DEBUG] No remote host metrics found for %s/%s", hostID, service.Name)
@@ -1553,87 +1910,79 @@ func StoreRemoteHostMetrics(db queryer, hostID string, service *parser.Service)
 		return &f
 	}
 
-	// Prepare variables for INSERT
-	var icmpType *string
-	var icmpResponseTime *float64
-	var portHostname *string
-	var portNumber *int
-	var portProtocol *string
-	var portType *string
-	var portResponseTime *float64
-	var unixPath *string
-	var unixProtocol *string
-	var unixResponseTime *float64
-
-	// Extract ICMP metrics if present
-	if service.ICMP != nil {
-		icmpType = getStringPtr(service.ICMP.Type)
-		icmpResponseTime = getFloatPtr(service.ICMP.ResponseTime)
-	}
-
-	// Extract Port metrics if present
-	if service.Port != nil {
-		portHostname = getStringPtr(service.Port.Hostname)
-		portNumber = getIntPtr(service.Port.PortNumber)
-		portProtocol = getStringPtr(service.Port.Protocol)
-		portType = getStringPtr(service.Port.Type)
-		portResponseTime = getFloatPtr(service.Port.ResponseTime)
-	}
-
-	// Extract Unix socket metrics if present
-	if service.Unix != nil {
-		unixPath = getStringPtr(service.Unix.Path)
-		unixProtocol = getStringPtr(service.Unix.Protocol)
-		unixResponseTime = getFloatPtr(service.Unix.ResponseTime)
+	// Helper function to handle nullable ICMP and port response times
+	// Monit reports -1 when the check failed: no response time
+	getResponseTimePtr := func(f float64) *float64 {
+		if f <= 0.0 {
+			return nil
+		}
+		return &f
 	}
 
-	// Insert remote host metrics into the database
+	// Insert one row per check: a service can have several checks of each
+	// kind, told apart by check_index, their position among the checks of
+	// the same kind in the status document (monitrc order)
 	//
 	// Using INSERT (not INSERT OR REPLACE) because:
 	// - Each metric is a new data point in time
 	// - We want to keep all historical values for graphing
 	// - Time-series data is append-only
-	query := `
+	const query = `
 		INSERT INTO remote_host_metrics (
-			host_id, service_name,
+			host_id, service_name, check_index,
 			icmp_type, icmp_responsetime,
 			port_hostname, port_number, port_protocol, port_type, port_responsetime,
 			unix_path, unix_protocol, unix_responsetime,
 			collected_at
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
-	_, err := db.Exec(query,
-		hostID,
-		service.Name,
-		icmpType,
-		icmpResponseTime,
-		portHostname,
-		portNumber,
-		portProtocol,
-		portType,
-		portResponseTime,
-		unixPath,
-		unixProtocol,
-		unixResponseTime,
-		collectedAt,
-	)
+	for i, icmp := range service.ICMP {
+		_, err := db.Exec(query, hostID, service.Name, i,
+			getStringPtr(icmp.Type), getResponseTimePtr(icmp.ResponseTime),
+			nil, nil, nil, nil, nil,
+			nil, nil, nil,
+			collectedAt)
+		if err != nil {
+			return fmt.Errorf("failed to store ICMP metrics: %w", err)
+		}
+	}
 
-	if err != nil {
-		return fmt.Errorf("failed to store remote host metrics: %w", err)
+	for i, port := range service.Port {
+		_, err := db.Exec(query, hostID, service.Name, i,
+			nil, nil,
+			getStringPtr(port.Hostname), getIntPtr(port.PortNumber), getStringPtr(port.Protocol),
+			getStringPtr(port.Type), getResponseTimePtr(port.ResponseTime),
+			nil, nil, nil,
+			collectedAt)
+		if err != nil {
+			return fmt.Errorf("failed to store port metrics: %w", err)
+		}
+	}
+
+	for i, unix := range service.Unix {
+		// unix_responsetime has no CHECK constraint: -1.0 records a failed check
+		_, err := db.Exec(query, hostID, service.Name, i,
+			nil, nil,
+			nil, nil, nil, nil, nil,
+			getStringPtr(unix.Path), getStringPtr(unix.Protocol), getFloatPtr(unix.ResponseTime),
+			collectedAt)
+		if err != nil {
+			return fmt.Errorf("failed to store unix socket metrics: %w", err)
+		}
 	}
 
 	if debugMode {
 		// Build debug message showing which metrics were stored
 		var metricsDesc []string
-		if service.ICMP != nil {
-			metricsDesc = append(metricsDesc, fmt.Sprintf("ICMP %.3fms", service.ICMP.ResponseTime*1000))
+		for _, icmp := range service.ICMP {
+			metricsDesc = append(metricsDesc, fmt.Sprintf("ICMP %.3fms", icmp.ResponseTime*1000))
 		}
-		if service.Port != nil {
-			metricsDesc = append(metricsDesc, fmt.Sprintf("Port %s:%d %.3fms", service.Port.Hostname, service.Port.PortNumber, service.Port.ResponseTime*1000))
+		for _, port := range service.Port {
+			metricsDesc = append(metricsDesc, fmt.Sprintf("Port %s:%d %.3fms", port.Hostname, port.PortNumber, port.ResponseTime*1000))
 		}
-		if service.Unix != nil {
-			metricsDesc = append(metricsDesc, fmt.Sprintf("Unix %s %.3fms", service.Unix.Path, service.Unix.ResponseTime*1000))
+		for _, unix := range service.Unix {
+			metricsDesc = append(metricsDesc, fmt.Sprintf("Unix %s %.3fms", unix.Path, unix.ResponseTime*1000))
 		}
 		log.Printf("[DEBUG] Stored remote host metrics for %s/%s (%s)",
 			hostID, service.Name, metricsDesc)
@@ -1649,6 +1998,7 @@ type DeleteHostStats struct {
 	FilesystemMetrics  int64 // Number of filesystem metrics deleted
 	NetworkMetrics     int64 // Number of network metrics deleted
 	FileMetrics        int64 // Number of file metrics deleted
+	PathMetrics        int64 // Number of directory and FIFO metrics deleted
 	ProgramMetrics     int64 // Number of program metrics deleted
 	RemoteHostMetrics  int64 // Number of remote host metrics deleted
 	Events             int64 // Number of events deleted
@@ -1662,6 +2012,7 @@ type DeleteHostStats struct {
 // - filesystem_metrics
 // - network_metrics
 // - file_metrics
+// - path_metrics
 // - program_metrics
 // - remote_host_metrics
 // - events
@@ -1690,19 +2041,23 @@ func DeleteHost(db *sql.DB, hostID string) (*DeleteHostStats, error) {
 	stats := &DeleteHostStats{}
 
 	// First, check if the host exists and get its last_seen
-	// Note: last_seen is stored as DATETIME, convert it to Unix timestamp
-	var lastSeen int64
-	err := db.QueryRow("SELECT CAST(strftime('%s', last_seen) AS INTEGER) FROM hosts WHERE id = ?", hostID).Scan(&lastSeen)
+	// Note: last_seen is stored as Go time text, which strftime cannot parse
+	var lastSeenText string
+	err := db.QueryRow("SELECT COALESCE(CAST(last_seen AS TEXT), '') FROM hosts WHERE id = ?", hostID).Scan(&lastSeenText)
 	if err == sql.ErrNoRows {
 		return nil, fmt.Errorf("host not found: %s", hostID)
 	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to query host: %w", err)
 	}
+	lastSeen, err := parseStoredTime(lastSeenText)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query host: %w", err)
+	}
 
 	// Safety check: only allow deletion if host has been offline for > 1 hour
 	now := time.Now().Unix()
-	secondsSince := now - lastSeen
+	secondsSince := now - lastSeen.Unix()
 	oneHour := int64(3600)
 
 	if secondsSince < oneHour {
@@ -1751,6 +2106,13 @@ func DeleteHost(db *sql.DB, hostID string) (*DeleteHostStats, error) {
 	}
 	stats.FileMetrics, _ = result.RowsAffected()
 
+	// Delete path_metrics
+	result, err = tx.Exec("DELETE FROM path_metrics WHERE host_id = ?", hostID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete path_metrics: %w", err)
+	}
+	stats.PathMetrics, _ = result.RowsAffected()
+
 	// Delete program_metrics
 	result, err = tx.Exec("DELETE FROM program_metrics WHERE host_id = ?", hostID)
 	if err != nil {
@@ -1772,6 +2134,16 @@ func DeleteHost(db *sql.DB, hostID string) (*DeleteHostStats, error) {
 	}
 	stats.Events, _ = result.RowsAffected()
 
+	// Delete host_control settings
+	if _, err := tx.Exec("DELETE FROM host_control WHERE host_id = ?", hostID); err != nil {
+		return nil, fmt.Errorf("failed to delete host_control: %w", err)
+	}
+
+	// Delete scheduled actions
+	if _, err := tx.Exec("DELETE FROM scheduled_actions WHERE host_id = ?", hostID); err != nil {
+		return nil, fmt.Errorf("failed to delete scheduled_actions: %w", err)
+	}
+
 	// Finally, delete the host itself
 	result, err = tx.Exec("DELETE FROM hosts WHERE id = ?", hostID)
 	if err != nil {
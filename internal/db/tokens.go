@@ -0,0 +1,232 @@
+// Package db - tokens.go contains API token storage.
+//
+// API tokens let scripts and integrations call the web API with an
+// "Authorization: Bearer <token>" header instead of the web password.
+// Each token carries scopes limiting what it may do. Only a SHA-256 hash
+// of the token is stored; the token itself is shown once, at creation.
+package db
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// API token scopes
+const (
+	ScopeReadStatus   = "read:status"   // Read-only API and pages (GET requests)
+	ScopeWriteActions = "write:actions" // Service actions, event acknowledgments and comments
+	ScopeWriteEvents  = "write:events"  // Events recorded by external systems (deploys, backups)
+	ScopeAdmin        = "admin"         // Everything, including token management
+)
+
+// TokenScopes lists the valid scopes.
+var TokenScopes = []string{ScopeReadStatus, ScopeWriteActions, ScopeWriteEvents, ScopeAdmin}
+
+// tokenPrefix starts every token, so leaked tokens are easy to recognize.
+const tokenPrefix = "cmonit_"
+
+// maxTokenNameLength bounds token names.
+const maxTokenNameLength = 64
+
+// ErrTokenNotFound is returned when revoking a token that does not exist.
+var ErrTokenNotFound = errors.New("token not found")
+
+// APIToken describes a stored API token (never the token itself).
+type APIToken struct {
+	ID        int64      `json:"id"`
+	Name      string     `json:"name"`
+	Prefix    string     `json:"prefix"` // First characters of the token, for identification
+	Scopes    []string   `json:"scopes"`
+	Role      string     `json:"role,omitempty"` // Limits service actions to the role's hostgroups and actions
+	CreatedBy string     `json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
+	LastUsed  *time.Time `json:"last_used,omitempty"`
+}
+
+// HasScope reports whether the token grants scope. admin grants every scope.
+func (t *APIToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateTokenScopes checks that scopes is non-empty and only holds known
+// scopes.
+func ValidateTokenScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("at least one scope is required (%s)", strings.Join(TokenScopes, ", "))
+	}
+	for _, s := range scopes {
+		valid := false
+		for _, known := range TokenScopes {
+			if s == known {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return fmt.Errorf("unknown scope %q (valid: %s)", s, strings.Join(TokenScopes, ", "))
+		}
+	}
+	return nil
+}
+
+// hashToken returns the stored form of a token.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAPIToken generates and stores a new token.
+//
+// Parameters:
+//   - db: Database connection
+//   - name: Unique token name (e.g., "grafana")
+//   - scopes: Granted scopes (see TokenScopes)
+//   - role: Role limiting service actions, or "" (checked by the caller
+//     against the configured roles)
+//   - createdBy: Web user or "cli"
+//
+// Returns the token, which cannot be retrieved again, and its description.
+func CreateAPIToken(db *sql.DB, name string, scopes []string, role, createdBy string) (string, *APIToken, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxTokenNameLength {
+		return "", nil, fmt.Errorf("token name must be 1-%d characters", maxTokenNameLength)
+	}
+	if err := ValidateTokenScopes(scopes); err != nil {
+		return "", nil, err
+	}
+
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		return "", nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+	token := tokenPrefix + hex.EncodeToString(buf)
+
+	t := &APIToken{
+		Name:      name,
+		Prefix:    token[:len(tokenPrefix)+6],
+		Scopes:    scopes,
+		Role:      strings.TrimSpace(role),
+		CreatedBy: createdBy,
+		CreatedAt: time.Now(),
+	}
+
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM api_tokens WHERE name = ?)", name).Scan(&exists)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to check token name: %w", err)
+	}
+	if exists {
+		return "", nil, fmt.Errorf("a token named %q already exists", name)
+	}
+
+	result, err := db.Exec(`
+		INSERT INTO api_tokens (name, token_hash, prefix, scopes, role, created_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, t.Name, hashToken(token), t.Prefix, strings.Join(scopes, " "), t.Role, t.CreatedBy, t.CreatedAt)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to store token: %w", err)
+	}
+	t.ID, _ = result.LastInsertId()
+
+	return token, t, nil
+}
+
+// scanAPIToken reads an api_tokens row selected with apiTokenColumns.
+func scanAPIToken(row interface{ Scan(...interface{}) error }) (*APIToken, error) {
+	var t APIToken
+	var scopes string
+	var lastUsed sql.NullTime
+	if err := row.Scan(&t.ID, &t.Name, &t.Prefix, &scopes, &t.Role, &t.CreatedBy, &t.CreatedAt, &lastUsed); err != nil {
+		return nil, err
+	}
+	t.Scopes = strings.Fields(scopes)
+	if lastUsed.Valid {
+		t.LastUsed = &lastUsed.Time
+	}
+	return &t, nil
+}
+
+// apiTokenColumns are the columns read by scanAPIToken.
+const apiTokenColumns = "id, name, prefix, scopes, role, created_by, created_at, last_used"
+
+// ListAPITokens returns all tokens, oldest first.
+func ListAPITokens(db *sql.DB) ([]APIToken, error) {
+	rows, err := db.Query("SELECT " + apiTokenColumns + " FROM api_tokens ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tokens: %w", err)
+	}
+	defer rows.Close()
+
+	tokens := []APIToken{}
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read token: %w", err)
+		}
+		tokens = append(tokens, *t)
+	}
+	return tokens, rows.Err()
+}
+
+// RevokeAPIToken deletes the token with the given ID. It returns
+// ErrTokenNotFound if there is none.
+func RevokeAPIToken(db *sql.DB, id int64) error {
+	result, err := db.Exec("DELETE FROM api_tokens WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrTokenNotFound
+	}
+	return nil
+}
+
+// RevokeAPITokenByName deletes the token with the given name. It returns
+// ErrTokenNotFound if there is none.
+func RevokeAPITokenByName(db *sql.DB, name string) error {
+	result, err := db.Exec("DELETE FROM api_tokens WHERE name = ?", name)
+	if err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrTokenNotFound
+	}
+	return nil
+}
+
+// LookupAPIToken returns the stored token matching token, or nil if there
+// is none, and records its use.
+func LookupAPIToken(db *sql.DB, token string) (*APIToken, error) {
+	if !strings.HasPrefix(token, tokenPrefix) {
+		return nil, nil
+	}
+
+	t, err := scanAPIToken(db.QueryRow(
+		"SELECT "+apiTokenColumns+" FROM api_tokens WHERE token_hash = ?", hashToken(token)))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up token: %w", err)
+	}
+
+	// Record the use at most once a minute to limit writes
+	now := time.Now()
+	if t.LastUsed == nil || now.Sub(*t.LastUsed) >= time.Minute {
+		if _, err := db.Exec("UPDATE api_tokens SET last_used = ? WHERE id = ?", now, t.ID); err != nil {
+			return nil, fmt.Errorf("failed to update token: %w", err)
+		}
+	}
+	return t, nil
+}
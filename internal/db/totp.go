@@ -0,0 +1,291 @@
+// Package db - totp.go contains two-factor authentication storage.
+//
+// Web accounts can add TOTP (RFC 6238) codes from an authenticator app as
+// a second login factor. Each account has one shared secret and a set of
+// single-use recovery codes for when the authenticator is lost. Recovery
+// codes are stored as SHA-256 hashes.
+package db
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP parameters, the defaults of authenticator apps
+const (
+	totpPeriod = 30 // Seconds per code
+	totpDigits = 6
+	totpSkew   = 1 // Codes accepted before and after the current one (clock drift)
+)
+
+// recoveryCodeCount is the number of recovery codes generated at a time.
+const recoveryCodeCount = 10
+
+// ErrTOTPNotPending is returned when confirming an enrollment that was not
+// started, or that is already confirmed.
+var ErrTOTPNotPending = errors.New("two-factor enrollment not started")
+
+// ErrTOTPEnabled is returned when starting an enrollment for an account
+// that already has two-factor authentication.
+var ErrTOTPEnabled = errors.New("two-factor authentication is already enabled")
+
+// ErrInvalidTOTPCode is returned when a code does not match.
+var ErrInvalidTOTPCode = errors.New("invalid code")
+
+// TOTPStatus describes an account's two-factor authentication.
+type TOTPStatus struct {
+	Enabled           bool       `json:"enabled"`
+	EnabledAt         *time.Time `json:"enabled_at,omitempty"`
+	RecoveryCodesLeft int        `json:"recovery_codes_left"`
+}
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// totpCode returns the code for a secret and time step (RFC 4226 dynamic
+// truncation of HMAC-SHA1).
+func totpCode(secret []byte, counter uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// hashRecoveryCode returns the stored form of a recovery code. Codes are
+// compared without case, spaces or dashes.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// TOTPURI returns the otpauth:// URI that authenticator apps scan as a
+// QR code.
+func TOTPURI(issuer, username, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("period", fmt.Sprint(totpPeriod))
+	v.Set("digits", fmt.Sprint(totpDigits))
+	return "otpauth://totp/" + url.PathEscape(issuer+":"+username) + "?" + v.Encode()
+}
+
+// GetTOTPStatus returns the two-factor status of username. Accounts that
+// never enrolled are reported as not enabled.
+func GetTOTPStatus(db *sql.DB, username string) (*TOTPStatus, error) {
+	status := &TOTPStatus{}
+	var enabledAt sql.NullTime
+	err := db.QueryRow("SELECT enabled, enabled_at FROM totp WHERE username = ?", username).
+		Scan(&status.Enabled, &enabledAt)
+	if err == sql.ErrNoRows {
+		return status, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get two-factor status: %w", err)
+	}
+	if enabledAt.Valid {
+		status.EnabledAt = &enabledAt.Time
+	}
+
+	err = db.QueryRow("SELECT COUNT(*) FROM totp_recovery_codes WHERE username = ? AND used_at IS NULL", username).
+		Scan(&status.RecoveryCodesLeft)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count recovery codes: %w", err)
+	}
+	return status, nil
+}
+
+// StartTOTPEnrollment generates a new secret for username. It is only
+// used for logins once ConfirmTOTPEnrollment has checked a code from the
+// authenticator app; starting again replaces an unconfirmed secret.
+//
+// Returns the base32 secret, as entered in authenticator apps.
+func StartTOTPEnrollment(db *sql.DB, username string) (string, error) {
+	status, err := GetTOTPStatus(db, username)
+	if err != nil {
+		return "", err
+	}
+	if status.Enabled {
+		return "", ErrTOTPEnabled
+	}
+
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate secret: %w", err)
+	}
+	secret := base32NoPadding.EncodeToString(buf)
+
+	_, err = db.Exec(`
+		INSERT INTO totp (username, secret, enabled, created_at)
+		VALUES (?, ?, 0, ?)
+		ON CONFLICT(username) DO UPDATE SET
+			secret = excluded.secret,
+			created_at = excluded.created_at,
+			last_counter = 0
+	`, username, secret, time.Now())
+	if err != nil {
+		return "", fmt.Errorf("failed to store secret: %w", err)
+	}
+	return secret, nil
+}
+
+// ConfirmTOTPEnrollment enables two-factor authentication for username if
+// code matches the pending secret.
+//
+// Returns new recovery codes, which are only shown here.
+func ConfirmTOTPEnrollment(db *sql.DB, username, code string) ([]string, error) {
+	var secret string
+	var enabled bool
+	var lastCounter int64
+	err := db.QueryRow("SELECT secret, enabled, last_counter FROM totp WHERE username = ?", username).
+		Scan(&secret, &enabled, &lastCounter)
+	if err == sql.ErrNoRows || (err == nil && enabled) {
+		return nil, ErrTOTPNotPending
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get secret: %w", err)
+	}
+
+	counter, ok := matchTOTP(secret, code, lastCounter, time.Now())
+	if !ok {
+		return nil, ErrInvalidTOTPCode
+	}
+
+	_, err = db.Exec("UPDATE totp SET enabled = 1, enabled_at = ?, last_counter = ? WHERE username = ?",
+		time.Now(), counter, username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to enable two-factor authentication: %w", err)
+	}
+	return RegenerateRecoveryCodes(db, username)
+}
+
+// matchTOTP checks code against the codes of the time steps around now.
+// Steps up to lastCounter were already used and are refused, so a code
+// cannot be replayed.
+//
+// Returns the matching time step.
+func matchTOTP(secret, code string, lastCounter int64, now time.Time) (int64, bool) {
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	key, err := base32NoPadding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0, false
+	}
+
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastCounter {
+			continue
+		}
+		if hmac.Equal([]byte(totpCode(key, uint64(step))), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// VerifyTOTP checks a login code for username: either the current code
+// of the authenticator app or an unused recovery code, which is then
+// marked as used.
+//
+// Returns ErrInvalidTOTPCode if the code does not match.
+func VerifyTOTP(db *sql.DB, username, code string) error {
+	var secret string
+	var lastCounter int64
+	err := db.QueryRow("SELECT secret, last_counter FROM totp WHERE username = ? AND enabled = 1", username).
+		Scan(&secret, &lastCounter)
+	if err == sql.ErrNoRows {
+		return ErrInvalidTOTPCode
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get secret: %w", err)
+	}
+
+	if counter, ok := matchTOTP(secret, code, lastCounter, time.Now()); ok {
+		_, err = db.Exec("UPDATE totp SET last_counter = ? WHERE username = ?", counter, username)
+		if err != nil {
+			return fmt.Errorf("failed to record code use: %w", err)
+		}
+		return nil
+	}
+
+	result, err := db.Exec(`
+		UPDATE totp_recovery_codes SET used_at = ?
+		WHERE username = ? AND code_hash = ? AND used_at IS NULL
+	`, time.Now(), username, hashRecoveryCode(code))
+	if err != nil {
+		return fmt.Errorf("failed to check recovery code: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrInvalidTOTPCode
+	}
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of username.
+//
+// Returns the new codes (e.g., "3f9a-c2d1"), which are only shown here.
+func RegenerateRecoveryCodes(db *sql.DB, username string) ([]string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM totp_recovery_codes WHERE username = ?", username); err != nil {
+		return nil, fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		buf := make([]byte, 4)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+		h := hex.EncodeToString(buf)
+		codes[i] = h[:4] + "-" + h[4:]
+
+		_, err = tx.Exec("INSERT INTO totp_recovery_codes (username, code_hash) VALUES (?, ?)",
+			username, hashRecoveryCode(codes[i]))
+		if err != nil {
+			return nil, fmt.Errorf("failed to store recovery code: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit recovery codes: %w", err)
+	}
+	return codes, nil
+}
+
+// DisableTOTP removes the secret and recovery codes of username.
+//
+// Returns false if the account had no two-factor authentication.
+func DisableTOTP(db *sql.DB, username string) (bool, error) {
+	if _, err := db.Exec("DELETE FROM totp_recovery_codes WHERE username = ?", username); err != nil {
+		return false, fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	result, err := db.Exec("DELETE FROM totp WHERE username = ?", username)
+	if err != nil {
+		return false, fmt.Errorf("failed to disable two-factor authentication: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
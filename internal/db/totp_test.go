@@ -0,0 +1,53 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 key of the RFC 6238 test vectors
+// ("12345678901234567890"), in base32.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// TestMatchTOTP checks codes against the RFC 6238 test vectors (last 6
+// digits), the accepted clock drift and the refusal of replayed codes.
+func TestMatchTOTP(t *testing.T) {
+	at := func(sec int64) time.Time { return time.Unix(sec, 0) }
+	tests := []struct {
+		name        string
+		secret      string
+		code        string
+		lastCounter int64
+		now         time.Time
+		step        int64
+		ok          bool
+	}{
+		{"RFC 6238 59", rfc6238Secret, "287082", 0, at(59), 1, true},
+		{"RFC 6238 1111111109", rfc6238Secret, "081804", 0, at(1111111109), 37037036, true},
+		{"RFC 6238 1234567890", rfc6238Secret, "005924", 0, at(1234567890), 41152263, true},
+		{"lowercase secret", "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", "287082", 0, at(59), 1, true},
+		{"spaces", rfc6238Secret, "287 082", 0, at(59), 1, true},
+		{"previous step", rfc6238Secret, "287082", 0, at(59 + totpPeriod), 1, true},
+		{"next step", rfc6238Secret, "287082", 0, at(59 - totpPeriod), 1, true},
+		{"beyond the drift", rfc6238Secret, "287082", 0, at(59 + 2*totpPeriod), 0, false},
+		{"replayed", rfc6238Secret, "287082", 1, at(59), 0, false},
+		{"wrong code", rfc6238Secret, "287083", 0, at(59), 0, false},
+		{"short code", rfc6238Secret, "28708", 0, at(59), 0, false},
+		{"invalid secret", "not base32!", "287082", 0, at(59), 0, false},
+	}
+	for _, tt := range tests {
+		step, ok := matchTOTP(tt.secret, tt.code, tt.lastCounter, tt.now)
+		if ok != tt.ok || step != tt.step {
+			t.Errorf("%s: matchTOTP = %d, %v, want %d, %v", tt.name, step, ok, tt.step, tt.ok)
+		}
+	}
+}
+
+func TestHashRecoveryCode(t *testing.T) {
+	want := hashRecoveryCode("3f9ac2d1")
+	for _, code := range []string{"3f9a-c2d1", "3F9A-C2D1", " 3f9a c2d1 "} {
+		if got := hashRecoveryCode(code); got != want {
+			t.Errorf("hashRecoveryCode(%q) differs from 3f9ac2d1", code)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+// Package db - validation.go counts the status documents rejected by the
+// collector in strict mode ([collector] strict).
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/ocochard/cmonit/internal/parser"
+)
+
+// ValidationFailures is the count of the status documents of a host
+// rejected by parser.Validate.
+type ValidationFailures struct {
+	HostKey        string    `json:"host_key"` // Server id, or hostname, or agent address
+	Hostname       string    `json:"hostname,omitempty"`
+	Source         string    `json:"source"` // Address of the agent of the last one
+	Failures       int64     `json:"failures"`
+	LastError      string    `json:"last_error"`
+	FirstFailureAt time.Time `json:"first_failure_at"`
+	LastFailureAt  time.Time `json:"last_failure_at"`
+}
+
+// validationHostKey identifies the host of a rejected document: by its
+// server id, as the stored hosts, or by its hostname or the agent address
+// when it is missing.
+func validationHostKey(status *parser.MonitStatus, source string) string {
+	switch {
+	case status.Server.ID != "":
+		return status.Server.ID
+	case status.Server.LocalHostname != "":
+		return "hostname:" + status.Server.LocalHostname
+	default:
+		return "source:" + source
+	}
+}
+
+// RecordValidationFailure counts a status document posted from source and
+// rejected by parser.Validate with validationErr.
+func RecordValidationFailure(db *sql.DB, status *parser.MonitStatus, source string, validationErr error) error {
+	now := time.Now()
+	_, err := db.Exec(`INSERT INTO validation_failures
+		(host_key, hostname, source, failures, last_error, first_failure_at, last_failure_at)
+		VALUES (?, ?, ?, 1, ?, ?, ?)
+		ON CONFLICT(host_key) DO UPDATE SET
+			hostname = excluded.hostname,
+			source = excluded.source,
+			failures = failures + 1,
+			last_error = excluded.last_error,
+			last_failure_at = excluded.last_failure_at`,
+		validationHostKey(status, source), status.Server.LocalHostname, source,
+		validationErr.Error(), now, now)
+	if err != nil {
+		return fmt.Errorf("failed to record validation failure: %w", err)
+	}
+	return nil
+}
+
+// ListValidationFailures returns the validation failure counts of all
+// hosts, the most recent failures first.
+func ListValidationFailures(db *sql.DB) ([]ValidationFailures, error) {
+	rows, err := db.Query(`SELECT host_key, hostname, source, failures, last_error, first_failure_at, last_failure_at
+		FROM validation_failures ORDER BY last_failure_at DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list validation failures: %w", err)
+	}
+	defer rows.Close()
+
+	failures := []ValidationFailures{}
+	for rows.Next() {
+		var f ValidationFailures
+		if err := rows.Scan(&f.HostKey, &f.Hostname, &f.Source, &f.Failures, &f.LastError,
+			&f.FirstFailureAt, &f.LastFailureAt); err != nil {
+			return nil, fmt.Errorf("failed to scan validation failures: %w", err)
+		}
+		failures = append(failures, f)
+	}
+	return failures, rows.Err()
+}
@@ -0,0 +1,166 @@
+package forward
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/ocochard/cmonit/internal/db"
+)
+
+// busDefaults are the default port and topic of each message bus.
+var busDefaults = map[string]struct{ port, topic string }{
+	"nats":        {"4222", "cmonit.events"},
+	"mqtt":        {"1883", "cmonit/events"},
+	"mqtts":       {"8883", "cmonit/events"},
+	"kafka+http":  {"8082", "cmonit-events"},
+	"kafka+https": {"8082", "cmonit-events"},
+}
+
+// busTopicCleaners replace the characters of the hostnames, services and
+// severities that would split or match topics, or are not allowed.
+var busTopicCleaners = map[string]*strings.Replacer{
+	"nats":  strings.NewReplacer(".", "_", " ", "_", "*", "_", ">", "_"),
+	"mqtt":  strings.NewReplacer("/", "_", "+", "_", "#", "_"),
+	"kafka": strings.NewReplacer(" ", "_", "/", "_", ":", "_", "@", "_", "(", "_", ")", "_"),
+}
+
+// BusSink publishes the events as JSON messages (db.StoredEvent) to a
+// message bus: NATS, an MQTT broker (QoS 1), or Kafka through its REST
+// proxy (keyed by host, so that the events of a host stay in order).
+type BusSink struct {
+	url     string // As configured, for Name
+	topic   string // With {host}, {service} and {severity}
+	cleaner *strings.Replacer
+
+	nats *natsClient
+	mqtt *mqttClient
+
+	kafka    string // Topics URL of the REST proxy
+	user     string
+	password string
+	client   *http.Client
+}
+
+// NewBusSink returns a sink publishing to rawURL, nats://host[:port],
+// mqtt://host[:port], mqtts://host[:port], or kafka+http(s)://host[:port]
+// for a Kafka REST proxy (with its path prefix, if any). topic may hold
+// {host}, {service} and {severity}; it defaults to cmonit.events (NATS),
+// cmonit/events (MQTT) or cmonit-events (Kafka). user and password
+// authenticate, a NATS token being the user alone; version is the cmonit
+// version announced to NATS.
+func NewBusSink(rawURL, topic, user, password, version string) (*BusSink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid message bus URL %q: %w", rawURL, err)
+	}
+	defaults, ok := busDefaults[u.Scheme]
+	if !ok || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid message bus URL %q: must be nats://, mqtt://, mqtts:// or kafka+https://host[:port]", rawURL)
+	}
+	port := u.Port()
+	if port == "" {
+		port = defaults.port
+	}
+	address := net.JoinHostPort(u.Hostname(), port)
+	if topic == "" {
+		topic = defaults.topic
+	}
+
+	s := &BusSink{url: rawURL, topic: topic}
+	switch u.Scheme {
+	case "nats":
+		s.cleaner = busTopicCleaners["nats"]
+		s.nats = &natsClient{address: address, host: u.Hostname(), user: user, password: password, version: version}
+	case "mqtt", "mqtts":
+		s.cleaner = busTopicCleaners["mqtt"]
+		s.mqtt = &mqttClient{address: address, tls: u.Scheme == "mqtts", clientID: mqttClientID("events"),
+			user: user, password: password}
+	default:
+		s.cleaner = busTopicCleaners["kafka"]
+		proxy := url.URL{Scheme: strings.TrimPrefix(u.Scheme, "kafka+"), Host: address, Path: strings.TrimSuffix(u.Path, "/")}
+		s.kafka = proxy.String() + "/topics/"
+		s.user, s.password = user, password
+		s.client = &http.Client{Timeout: siemTimeout}
+	}
+	return s, nil
+}
+
+// Name returns the URL of the bus.
+func (s *BusSink) Name() string {
+	return "message bus " + s.url
+}
+
+// Send publishes an event to its topic.
+func (s *BusSink) Send(e db.StoredEvent) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	topic := strings.NewReplacer(
+		"{host}", s.cleaner.Replace(e.Hostname),
+		"{service}", s.cleaner.Replace(e.Service),
+		"{severity}", e.Severity,
+	).Replace(s.topic)
+
+	switch {
+	case s.nats != nil:
+		return s.nats.publish(topic, payload)
+	case s.mqtt != nil:
+		return s.mqtt.publish(topic, payload, 1, false)
+	}
+	return s.sendKafka(topic, e.HostID, payload)
+}
+
+// sendKafka posts an event to the Kafka REST proxy (API v2).
+func (s *BusSink) sendKafka(topic, key string, payload []byte) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"records": []map[string]interface{}{{"key": key, "value": json.RawMessage(payload)}},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.kafka+url.PathEscape(topic), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+	if s.user != "" {
+		req.SetBasicAuth(s.user, s.password)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Kafka REST proxy answered %s", resp.Status)
+	}
+
+	// A record the proxy failed to produce is reported in its offset
+	var result struct {
+		Offsets []struct {
+			Error string `json:"error"`
+		} `json:"offsets"`
+	}
+	if json.NewDecoder(resp.Body).Decode(&result) == nil {
+		for _, o := range result.Offsets {
+			if o.Error != "" {
+				return fmt.Errorf("Kafka REST proxy: %s", o.Error)
+			}
+		}
+	}
+	return nil
+}
+
+// mqttClientID returns the client identifier of a cmonit MQTT connection,
+// unique per process so that two servers do not kick each other out.
+func mqttClientID(purpose string) string {
+	return fmt.Sprintf("cmonit-%s-%d", purpose, os.Getpid())
+}
@@ -0,0 +1,157 @@
+package forward
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewBusSink(t *testing.T) {
+	tests := []struct {
+		url, topic string
+		want       string // Default topic, "" for an error
+	}{
+		{"nats://nats.example.com", "", "cmonit.events"},
+		{"mqtts://broker.example.com", "", "cmonit/events"},
+		{"kafka+https://proxy.example.com/kafka", "events", "events"},
+		{"amqp://rabbit.example.com", "", ""},
+		{"nats://", "", ""},
+	}
+	for _, tt := range tests {
+		s, err := NewBusSink(tt.url, tt.topic, "", "", "1.0")
+		switch {
+		case tt.want == "" && err == nil:
+			t.Errorf("%s: no error", tt.url)
+		case tt.want != "" && (err != nil || s.topic != tt.want):
+			t.Errorf("%s: %v, topic %v, want %s", tt.url, err, s, tt.want)
+		}
+	}
+
+	s, _ := NewBusSink("kafka+https://proxy.example.com/kafka/", "", "", "", "1.0")
+	if s.kafka != "https://proxy.example.com:8082/kafka/topics/" {
+		t.Errorf("Kafka REST proxy %s", s.kafka)
+	}
+}
+
+// listen starts a one-connection server running serve.
+func listen(t *testing.T, serve func(conn net.Conn)) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		serve(conn)
+	}()
+	return l.Addr().String()
+}
+
+func TestBusSinkNATS(t *testing.T) {
+	published := make(chan string, 1)
+	addr := listen(t, func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		io.WriteString(conn, "INFO {\"server_id\":\"test\",\"auth_required\":true}\r\n")
+		connect, _ := r.ReadString('\n')
+		if !strings.Contains(connect, `"auth_token":"s3cret"`) {
+			io.WriteString(conn, "-ERR 'Authorization Violation'\r\n")
+			return
+		}
+		r.ReadString('\n') // PING
+		io.WriteString(conn, "PONG\r\n")
+
+		pub, _ := r.ReadString('\n')
+		payload, _ := r.ReadString('\n')
+		r.ReadString('\n') // PING
+		io.WriteString(conn, "PONG\r\n")
+		published <- pub + payload
+	})
+
+	s, err := NewBusSink("nats://"+addr, "cmonit.{host}.{severity}", "s3cret", "", "1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := testEvent()
+	e.Hostname = "web1.example.com"
+	if err := s.Send(e); err != nil {
+		t.Fatal(err)
+	}
+	got := <-published
+	if !strings.HasPrefix(got, "PUB cmonit.web1_example_com.critical ") || !strings.Contains(got, `"message":"connection failed to localhost:80"`) {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestBusSinkMQTT(t *testing.T) {
+	published := make(chan []byte, 1)
+	addr := listen(t, func(conn net.Conn) {
+		c := &mqttClient{conn: conn, r: bufio.NewReader(conn)}
+		if packetType, _, err := c.read(); err != nil || packetType != mqttConnect {
+			return
+		}
+		c.write(mqttConnack, []byte{0, 0})
+		packetType, body, err := c.read()
+		if err != nil || packetType != mqttPublish {
+			return
+		}
+		published <- body
+		id := 2 + int(binary.BigEndian.Uint16(body)) // After the topic
+		c.write(mqttPuback, body[id:id+2])
+	})
+
+	s, err := NewBusSink("mqtt://"+addr, "cmonit/{host}/{service}", "", "", "1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := testEvent()
+	e.Service = "disk/root"
+	if err := s.Send(e); err != nil {
+		t.Fatal(err)
+	}
+	body := <-published
+	topic := string(body[2 : 2+binary.BigEndian.Uint16(body)])
+	var sent map[string]interface{}
+	if err := json.Unmarshal(body[2+len(topic)+2:], &sent); err != nil || topic != "cmonit/web1/disk_root" || sent["id"] != 42.0 {
+		t.Errorf("topic %q, payload %v (%v)", topic, sent, err)
+	}
+}
+
+func TestBusSinkKafka(t *testing.T) {
+	var path, key string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Records []struct {
+				Key string `json:"key"`
+			} `json:"records"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		path = r.URL.Path
+		if len(body.Records) == 1 {
+			key = body.Records[0].Key
+		}
+		io.WriteString(w, `{"offsets":[{"partition":0,"offset":1,"error":null}]}`)
+	}))
+	defer server.Close()
+
+	s, err := NewBusSink(strings.Replace(server.URL, "http://", "kafka+http://", 1)+"/v2", "", "", "", "1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Send(testEvent()); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/v2/topics/cmonit-events" || key != "web1-0" {
+		t.Errorf("posted to %s with key %q", path, key)
+	}
+}
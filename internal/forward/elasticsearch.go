@@ -0,0 +1,310 @@
+package forward
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ocochard/cmonit/internal/db"
+)
+
+// Default indices of the Elasticsearch sink, {date} being the day of the
+// document (UTC), e.g. cmonit-events-2026.10.16.
+const (
+	DefaultElasticsearchIndex = "cmonit-events-{date}"
+	elasticsearchDateLayout   = "2006.01.02"
+)
+
+// elasticsearchEventMappings and elasticsearchMetricMappings are the
+// mappings of the index templates, so that the host, service and severity
+// aggregate as keywords and the message is searchable as text.
+var (
+	elasticsearchEventMappings = map[string]interface{}{
+		"properties": map[string]interface{}{
+			"@timestamp": map[string]string{"type": "date"},
+			"id":         map[string]string{"type": "long"},
+			"host_id":    map[string]string{"type": "keyword"},
+			"hostname":   map[string]string{"type": "keyword"},
+			"service":    map[string]string{"type": "keyword"},
+			"event_type": map[string]string{"type": "long"},
+			"state":      map[string]string{"type": "integer"},
+			"action":     map[string]string{"type": "integer"},
+			"message":    map[string]string{"type": "text"},
+			"severity":   map[string]string{"type": "keyword"},
+			"flap_count": map[string]string{"type": "integer"},
+			"source":     map[string]string{"type": "keyword"},
+			"created_at": map[string]string{"type": "date"},
+		},
+	}
+	elasticsearchMetricMappings = map[string]interface{}{
+		"properties": map[string]interface{}{
+			"@timestamp": map[string]string{"type": "date"},
+			"host_id":    map[string]string{"type": "keyword"},
+			"hostname":   map[string]string{"type": "keyword"},
+			"service":    map[string]string{"type": "keyword"},
+			"type":       map[string]string{"type": "keyword"},
+			"name":       map[string]string{"type": "keyword"},
+			"value":      map[string]string{"type": "double"},
+		},
+	}
+)
+
+// esEvent is the document of an event.
+type esEvent struct {
+	db.StoredEvent
+	Timestamp time.Time `json:"@timestamp"`
+}
+
+// esMetric is the document of a data point.
+type esMetric struct {
+	Timestamp time.Time `json:"@timestamp"`
+	HostID    string    `json:"host_id"`
+	Hostname  string    `json:"hostname"`
+	Service   string    `json:"service"`
+	Type      string    `json:"type"`
+	Name      string    `json:"name"`
+	Value     float64   `json:"value"`
+}
+
+// ElasticsearchSink indexes the events, and optionally the data points,
+// in Elasticsearch or OpenSearch with the bulk API. The documents are
+// identified by their id in cmonit, so that a batch sent again after a
+// failure does not duplicate them. Before the first documents it installs
+// the index templates of their mappings, unless told not to.
+type ElasticsearchSink struct {
+	url          string // As configured, for Name
+	base         string // Without the trailing slash
+	user         string
+	password     string
+	apiKey       string
+	index        string // Of the events, with {date}
+	metricsIndex string // Of the data points, "" for none
+	templates    bool   // Install the templates
+	client       *http.Client
+
+	eventsReady, metricsReady bool // Template installed
+}
+
+// NewElasticsearchSink returns a sink indexing to the cluster at rawURL,
+// authenticated with user and password or an API key (base64 id:key), the
+// events in index (DefaultElasticsearchIndex when empty) and the data
+// points in metricsIndex (none when empty); both may hold {date}.
+// templates installs the index templates.
+func NewElasticsearchSink(rawURL, user, password, apiKey, index, metricsIndex string, templates bool) (*ElasticsearchSink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid Elasticsearch URL %q: must be an http(s):// URL", rawURL)
+	}
+	if apiKey != "" && user != "" {
+		return nil, fmt.Errorf("Elasticsearch: api_key and user are mutually exclusive")
+	}
+	if index == "" {
+		index = DefaultElasticsearchIndex
+	}
+	for _, name := range []string{index, metricsIndex} {
+		if name != strings.ToLower(name) || strings.ContainsAny(name, `\/*?"<>| ,#`) {
+			return nil, fmt.Errorf("invalid Elasticsearch index %q: must be lowercase, without \\/*?\"<>| ,#", name)
+		}
+	}
+	return &ElasticsearchSink{
+		url:          rawURL,
+		base:         strings.TrimSuffix(rawURL, "/"),
+		user:         user,
+		password:     password,
+		apiKey:       apiKey,
+		index:        index,
+		metricsIndex: metricsIndex,
+		templates:    templates,
+		client:       &http.Client{Timeout: siemTimeout},
+	}, nil
+}
+
+// Name returns the URL of the cluster.
+func (s *ElasticsearchSink) Name() string {
+	return "Elasticsearch " + s.url
+}
+
+// Metrics reports whether the sink indexes the data points.
+func (s *ElasticsearchSink) Metrics() bool {
+	return s.metricsIndex != ""
+}
+
+// indexName returns the index of a document of time t.
+func indexName(index string, t time.Time) string {
+	return strings.ReplaceAll(index, "{date}", t.UTC().Format(elasticsearchDateLayout))
+}
+
+// Send indexes an event.
+func (s *ElasticsearchSink) Send(e db.StoredEvent) error {
+	if !s.eventsReady {
+		if err := s.putTemplate("cmonit-events", s.index, elasticsearchEventMappings); err != nil {
+			return err
+		}
+		s.eventsReady = true
+	}
+	var b bytes.Buffer
+	if err := bulkAction(&b, indexName(s.index, e.CreatedAt), e.ID, esEvent{StoredEvent: e, Timestamp: e.CreatedAt}); err != nil {
+		return err
+	}
+	return s.bulk(&b, 1)
+}
+
+// SendMetrics indexes a batch of data points.
+func (s *ElasticsearchSink) SendMetrics(metrics []db.StoredMetric) error {
+	if !s.metricsReady {
+		if err := s.putTemplate("cmonit-metrics", s.metricsIndex, elasticsearchMetricMappings); err != nil {
+			return err
+		}
+		s.metricsReady = true
+	}
+	var b bytes.Buffer
+	count := 0
+	for _, m := range metrics {
+		if math.IsNaN(m.Value) || math.IsInf(m.Value, 0) {
+			continue // Not valid JSON
+		}
+		doc := esMetric{Timestamp: m.CollectedAt, HostID: m.HostID, Hostname: m.Hostname, Service: m.Service, Type: m.Type, Name: m.Name, Value: m.Value}
+		if err := bulkAction(&b, indexName(s.metricsIndex, m.CollectedAt), m.ID, doc); err != nil {
+			return err
+		}
+		count++
+	}
+	if count == 0 {
+		return nil
+	}
+	return s.bulk(&b, count)
+}
+
+// bulkAction appends the index action of a document to a bulk request.
+func bulkAction(b *bytes.Buffer, index string, id int64, doc interface{}) error {
+	action, err := json.Marshal(map[string]interface{}{
+		"index": map[string]string{"_index": index, "_id": strconv.FormatInt(id, 10)},
+	})
+	if err != nil {
+		return err
+	}
+	source, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	b.Write(action)
+	b.WriteByte('\n')
+	b.Write(source)
+	b.WriteByte('\n')
+	return nil
+}
+
+// request sends a request to the cluster.
+func (s *ElasticsearchSink) request(method, path, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, s.base+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", "application/json")
+	if s.apiKey != "" {
+		req.Header.Set("Authorization", "ApiKey "+s.apiKey)
+	} else if s.user != "" {
+		req.SetBasicAuth(s.user, s.password)
+	}
+	return s.client.Do(req)
+}
+
+// putTemplate installs the index template name of the indices of index
+// ({date} matching any day), if enabled.
+func (s *ElasticsearchSink) putTemplate(name, index string, mappings map[string]interface{}) error {
+	if !s.templates {
+		return nil
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"index_patterns": []string{strings.ReplaceAll(index, "{date}", "*")},
+		"priority":       100,
+		"template":       map[string]interface{}{"mappings": mappings},
+		"_meta":          map[string]string{"managed_by": "cmonit"},
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := s.request(http.MethodPut, "/_index_template/"+name, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("Elasticsearch refused the index template %s: %s %s", name, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// bulkResponse is the part of a bulk response telling which documents
+// failed.
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		ID     string `json:"_id"`
+		Status int    `json:"status"`
+		Error  struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	} `json:"items"`
+}
+
+// bulk sends a bulk request of count documents. The documents the
+// cluster rejects (mapping errors...) are dropped with a warning, as
+// retrying them would fail again; a request it cannot take (429, 5xx) or
+// a document it could not index for now fails the batch, for it to be
+// retried.
+func (s *ElasticsearchSink) bulk(b *bytes.Buffer, count int) error {
+	resp, err := s.request(http.MethodPost, "/_bulk", "application/x-ndjson", b)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("Elasticsearch answered %s", resp.Status)
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+			return fmt.Errorf("Elasticsearch answered %s", resp.Status)
+		}
+		log.Printf("[WARN] Elasticsearch rejected %d documents: %s %s", count, resp.Status, strings.TrimSpace(string(msg)))
+		return nil
+	}
+
+	var result bulkResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("invalid Elasticsearch bulk response: %w", err)
+	}
+	if !result.Errors {
+		return nil
+	}
+	rejected := 0
+	for _, item := range result.Items {
+		for _, r := range item {
+			switch {
+			case r.Status == http.StatusTooManyRequests || r.Status >= 500:
+				return fmt.Errorf("Elasticsearch could not index document %s: %s", r.ID, r.Error.Reason)
+			case r.Status >= 300:
+				if rejected == 0 {
+					log.Printf("[WARN] Elasticsearch rejected document %s: %s: %s", r.ID, r.Error.Type, r.Error.Reason)
+				}
+				rejected++
+			}
+		}
+	}
+	if rejected > 1 {
+		log.Printf("[WARN] Elasticsearch rejected %d documents of the batch", rejected)
+	}
+	return nil
+}
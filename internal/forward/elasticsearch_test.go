@@ -0,0 +1,148 @@
+package forward
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ocochard/cmonit/internal/db"
+)
+
+func TestNewElasticsearchSink(t *testing.T) {
+	for _, tt := range []struct{ url, user, apiKey, index string }{
+		{"elastic:9200", "", "", ""},
+		{"https://elastic:9200", "cmonit", "a2V5", ""},
+		{"https://elastic:9200", "", "", "Cmonit-Events"},
+		{"https://elastic:9200", "", "", "cmonit events"},
+	} {
+		if _, err := NewElasticsearchSink(tt.url, tt.user, "", tt.apiKey, tt.index, "", true); err == nil {
+			t.Errorf("%+v: no error", tt)
+		}
+	}
+	s, err := NewElasticsearchSink("https://elastic:9200/", "", "", "", "", "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.index != DefaultElasticsearchIndex || s.Metrics() {
+		t.Errorf("got index %q, metrics %v", s.index, s.Metrics())
+	}
+	at := time.Date(2026, 10, 16, 23, 30, 0, 0, time.FixedZone("", -3600))
+	if got := indexName(s.index, at); got != "cmonit-events-2026.10.17" {
+		t.Errorf("got index %s", got)
+	}
+}
+
+func TestElasticsearchSink(t *testing.T) {
+	templates := make(map[string]map[string]interface{})
+	var actions []map[string]map[string]string
+	var docs []map[string]interface{}
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		switch {
+		case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/_index_template/"):
+			var template map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&template)
+			templates[strings.TrimPrefix(r.URL.Path, "/_index_template/")] = template
+			io.WriteString(w, `{"acknowledged":true}`)
+		case r.Method == http.MethodPost && r.URL.Path == "/_bulk":
+			if r.Header.Get("Content-Type") != "application/x-ndjson" {
+				http.Error(w, "bad content type", http.StatusBadRequest)
+				return
+			}
+			scanner := bufio.NewScanner(r.Body)
+			for scanner.Scan() {
+				var action map[string]map[string]string
+				json.Unmarshal(scanner.Bytes(), &action)
+				actions = append(actions, action)
+				scanner.Scan()
+				var doc map[string]interface{}
+				json.Unmarshal(scanner.Bytes(), &doc)
+				docs = append(docs, doc)
+			}
+			io.WriteString(w, `{"errors":false,"items":[]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	s, err := NewElasticsearchSink(server.URL, "", "", "a2V5", "", "cmonit-metrics", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Send(testEvent()); err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	err = s.SendMetrics([]db.StoredMetric{
+		{ID: 7, HostID: "h1", Hostname: "web1", Service: "web1", Type: "cpu", Name: "user", Value: 12.5, CollectedAt: at},
+		{ID: 8, HostID: "h1", Hostname: "web1", Service: "web1", Type: "cpu", Name: "system", Value: 3, CollectedAt: at},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if auth != "ApiKey a2V5" {
+		t.Errorf("got Authorization %q", auth)
+	}
+	if patterns := templates["cmonit-events"]["index_patterns"]; patterns == nil || patterns.([]interface{})[0] != "cmonit-events-*" {
+		t.Errorf("got events template %v", templates["cmonit-events"])
+	}
+	if patterns := templates["cmonit-metrics"]["index_patterns"]; patterns == nil || patterns.([]interface{})[0] != "cmonit-metrics" {
+		t.Errorf("got metrics template %v", templates["cmonit-metrics"])
+	}
+	if len(docs) != 3 {
+		t.Fatalf("got %d documents", len(docs))
+	}
+	if a := actions[0]["index"]; a["_index"] != "cmonit-events-2026.10.14" || a["_id"] != "42" {
+		t.Errorf("got event action %v", a)
+	}
+	if d := docs[0]; d["hostname"] != "web1" || d["@timestamp"] != "2026-10-14T09:12:05Z" || d["severity"] != db.SeverityCritical {
+		t.Errorf("got event %v", d)
+	}
+	if a := actions[2]["index"]; a["_index"] != "cmonit-metrics" || a["_id"] != "8" {
+		t.Errorf("got metric action %v", a)
+	}
+	if d := docs[2]; d["name"] != "system" || d["value"] != 3.0 || d["@timestamp"] != "2026-10-16T09:00:00Z" {
+		t.Errorf("got metric %v", d)
+	}
+}
+
+func TestElasticsearchBulkErrors(t *testing.T) {
+	status := http.StatusOK
+	response := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		io.WriteString(w, response)
+	}))
+	defer server.Close()
+	s, err := NewElasticsearchSink(server.URL, "", "", "", "", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		status   int
+		response string
+		retried  bool
+	}{
+		{http.StatusOK, `{"errors":true,"items":[{"index":{"_id":"42","status":400,"error":{"type":"mapper_parsing_exception","reason":"bad"}}}]}`, false},
+		{http.StatusOK, `{"errors":true,"items":[{"index":{"_id":"42","status":429,"error":{"type":"es_rejected_execution_exception","reason":"queue full"}}}]}`, true},
+		{http.StatusTooManyRequests, "", true},
+		{http.StatusServiceUnavailable, "", true},
+		{http.StatusUnauthorized, "", true},
+		{http.StatusRequestEntityTooLarge, "", false},
+	}
+	for _, tt := range tests {
+		status, response = tt.status, tt.response
+		if err := s.Send(testEvent()); (err != nil) != tt.retried {
+			t.Errorf("%d %s: got %v, retried %v", tt.status, tt.response, err, tt.retried)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+// Package forward sends the events stored by cmonit to external systems
+// (syslog...), so that existing pipelines pick up the state changes of the
+// Monit services.
+//
+// A Forwarder reads the new rows of the events table and hands them to
+// its sinks, whatever stored them: the events posted by the agents, the
+// status transitions seen at ingest and the events of cmonit itself. Each
+// sink keeps its own position, so that a sink failing (a remote server
+// down) gets the events it missed when it works again, without holding up
+// the others.
+//
+// A MetricForwarder does the same with the new rows of the metrics table,
+// in batches, for the time series databases (Prometheus remote write...).
+package forward
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/ocochard/cmonit/internal/db"
+)
+
+// Forwarding limits.
+const (
+	// Interval is the time between two reads of the new events
+	Interval = 5 * time.Second
+
+	// batchSize is the number of events read at once for a sink
+	batchSize = 500
+)
+
+// Sink receives the stored events, oldest first.
+type Sink interface {
+	// Name identifies the sink in the logs, e.g. "syslog udp://logs:514"
+	Name() string
+
+	// Send forwards an event; an error makes the Forwarder retry it
+	// later, with the following ones
+	Send(e db.StoredEvent) error
+}
+
+// sinkState is a sink with the last event it got.
+type sinkState struct {
+	sink        Sink
+	minSeverity int   // Rank of the lowest severity sent in db.Severities
+	after       int64 // Id of the last event sent or skipped
+	failing     bool  // The last Send failed, logged once
+}
+
+// Forwarder sends the events stored while it runs to its sinks: the
+// history is not forwarded.
+type Forwarder struct {
+	db    *sql.DB
+	sinks []*sinkState
+}
+
+// Add adds a sink receiving the events of minSeverity or higher (info,
+// warning or critical; empty for all).
+func (f *Forwarder) Add(sink Sink, minSeverity string) error {
+	rank := 0
+	if minSeverity != "" {
+		rank = slices.Index(db.Severities, minSeverity)
+		if rank < 0 {
+			return fmt.Errorf("%s: invalid severity %q (valid: info, warning, critical)", sink.Name(), minSeverity)
+		}
+	}
+	f.sinks = append(f.sinks, &sinkState{sink: sink, minSeverity: rank})
+	return nil
+}
+
+// Empty reports whether the Forwarder has no sink.
+func (f *Forwarder) Empty() bool {
+	return len(f.sinks) == 0
+}
+
+// Run forwards the events stored from now on in database every Interval,
+// forever.
+func (f *Forwarder) Run(database *sql.DB) {
+	start, err := db.LastEventID(database)
+	if err != nil {
+		log.Printf("[ERROR] Failed to read the last event, events are not forwarded: %v", err)
+		return
+	}
+	f.db = database
+	for _, s := range f.sinks {
+		s.after = start
+		log.Printf("[INFO] Forwarding events to %s", s.sink.Name())
+	}
+
+	ticker := time.NewTicker(Interval)
+	defer ticker.Stop()
+
+	for {
+		<-ticker.C
+		f.Forward()
+	}
+}
+
+// Forward sends the events stored since the last call to each sink.
+func (f *Forwarder) Forward() {
+	for _, s := range f.sinks {
+		f.forward(s)
+	}
+}
+
+// forward sends the new events to a sink, until one fails.
+func (f *Forwarder) forward(s *sinkState) {
+	for {
+		events, err := db.EventsAfter(f.db, s.after, batchSize)
+		if err != nil {
+			log.Printf("[WARN] Failed to read the events to forward: %v", err)
+			return
+		}
+
+		for _, e := range events {
+			if s.minSeverity == 0 || slices.Index(db.Severities, e.Severity) >= s.minSeverity {
+				if err := s.sink.Send(e); err != nil {
+					if !s.failing {
+						log.Printf("[WARN] Failed to forward event %d to %s, retrying: %v", e.ID, s.sink.Name(), err)
+						s.failing = true
+					}
+					return
+				}
+				if s.failing {
+					log.Printf("[INFO] Forwarding events to %s again", s.sink.Name())
+					s.failing = false
+				}
+			}
+			s.after = e.ID
+		}
+
+		if len(events) < batchSize {
+			return
+		}
+	}
+}
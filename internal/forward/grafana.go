@@ -0,0 +1,160 @@
+package forward
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ocochard/cmonit/internal/db"
+)
+
+// Kinds of the events annotated in Grafana, also their tag.
+const (
+	GrafanaFailure  = "failure"
+	GrafanaRecovery = "recovery"
+	GrafanaReboot   = "reboot"
+)
+
+// grafanaRebootTypes are the Monit event types of a reboot: uptime (the
+// uptime test of a system service) and instance (Monit started), and the
+// pid event type of the Monit restarts cmonit detects (see
+// db.StoreMonitStatus).
+const (
+	grafanaUptimeType   = 0x400000
+	grafanaInstanceType = 0x10000
+	grafanaRestartType  = 0x40000
+)
+
+// GrafanaSink creates Grafana annotations (HTTP API) for the failures, the
+// recoveries and the reboots, tagged "cmonit", the kind, the host and the
+// service, so that they show on the dashboards querying these tags.
+type GrafanaSink struct {
+	url          string // Of the annotations API
+	token        string // Service account token
+	user         string
+	password     string
+	dashboardUID string // "" for organization annotations
+	tags         []string
+	kinds        map[string]bool
+	client       *http.Client
+}
+
+// NewGrafanaSink returns a sink annotating in the Grafana at rawURL,
+// authenticated with a service account token or user and password, the
+// events of kinds (all when empty), on the dashboard dashboardUID (all the
+// dashboards when empty), with tags in addition to its own.
+func NewGrafanaSink(rawURL, token, user, password, dashboardUID string, tags, kinds []string) (*GrafanaSink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid Grafana URL %q: must be an http(s):// URL", rawURL)
+	}
+	if token != "" && user != "" {
+		return nil, fmt.Errorf("Grafana: token and user are mutually exclusive")
+	}
+	s := &GrafanaSink{
+		url:          strings.TrimSuffix(rawURL, "/") + "/api/annotations",
+		token:        token,
+		user:         user,
+		password:     password,
+		dashboardUID: dashboardUID,
+		tags:         tags,
+		kinds:        make(map[string]bool),
+		client:       &http.Client{Timeout: siemTimeout},
+	}
+	if len(kinds) == 0 {
+		kinds = []string{GrafanaFailure, GrafanaRecovery, GrafanaReboot}
+	}
+	for _, kind := range kinds {
+		switch kind {
+		case GrafanaFailure, GrafanaRecovery, GrafanaReboot:
+			s.kinds[kind] = true
+		default:
+			return nil, fmt.Errorf("invalid Grafana event kind %q (valid: failure, recovery, reboot)", kind)
+		}
+	}
+	return s, nil
+}
+
+// Name returns the annotations API.
+func (s *GrafanaSink) Name() string {
+	return "Grafana " + s.url
+}
+
+// grafanaKind returns the kind of an event, "" for the other events
+// (changes of watched values, actions...).
+func grafanaKind(e db.StoredEvent) string {
+	switch {
+	case e.EventType == grafanaUptimeType && e.State == 1, // EventStateFailed
+		e.EventType == grafanaInstanceType && e.State >= 0,
+		e.EventType == grafanaRestartType && e.State < 0:
+		return GrafanaReboot
+	case e.State == 1: // EventStateFailed
+		return GrafanaFailure
+	case e.State == 0: // EventStateSucceeded
+		return GrafanaRecovery
+	}
+	return ""
+}
+
+// grafanaAnnotation is the body of POST /api/annotations.
+type grafanaAnnotation struct {
+	DashboardUID string   `json:"dashboardUID,omitempty"`
+	Time         int64    `json:"time"` // Milliseconds
+	Tags         []string `json:"tags"`
+	Text         string   `json:"text"`
+}
+
+// Send creates the annotation of an event of a selected kind.
+func (s *GrafanaSink) Send(e db.StoredEvent) error {
+	kind := grafanaKind(e)
+	if !s.kinds[kind] {
+		return nil
+	}
+
+	tags := []string{"cmonit", kind, "host:" + e.Hostname}
+	text := e.Hostname + ": " + e.Message
+	if e.Service != "" {
+		tags = append(tags, "service:"+e.Service)
+		text = e.Hostname + "/" + e.Service + ": " + e.Message
+	}
+	tags = append(tags, s.tags...)
+	body, err := json.Marshal(grafanaAnnotation{
+		DashboardUID: s.dashboardUID,
+		Time:         e.CreatedAt.UnixMilli(),
+		Tags:         tags,
+		Text:         text,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	} else if s.user != "" {
+		req.SetBasicAuth(s.user, s.password)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode <= 299:
+		return nil
+	case resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusNotFound:
+		// A dashboard that does not exist, retrying would fail again
+		log.Printf("[WARN] Grafana rejected the annotation of event %d: %s", e.ID, resp.Status)
+		return nil
+	}
+	return fmt.Errorf("Grafana answered %s", resp.Status)
+}
@@ -0,0 +1,93 @@
+package forward
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ocochard/cmonit/internal/db"
+)
+
+func TestGrafanaKind(t *testing.T) {
+	tests := []struct {
+		eventType, state int
+		want             string
+	}{
+		{0x20, 1, GrafanaFailure},
+		{0x20, 0, GrafanaRecovery},
+		{0x1, 2, ""},
+		{0x400000, 1, GrafanaReboot},
+		{0x400000, 0, GrafanaRecovery},
+		{0x10000, 2, GrafanaReboot},
+		{0x40000, -1, GrafanaReboot},
+		{0x40000, 2, ""},
+		{0, -1, ""},
+	}
+	for _, tt := range tests {
+		if got := grafanaKind(db.StoredEvent{EventType: tt.eventType, State: tt.state}); got != tt.want {
+			t.Errorf("type %#x state %d: got %q, want %q", tt.eventType, tt.state, got, tt.want)
+		}
+	}
+}
+
+func TestNewGrafanaSink(t *testing.T) {
+	if _, err := NewGrafanaSink("grafana:3000", "", "", "", "", nil, nil); err == nil {
+		t.Error("URL without scheme: no error")
+	}
+	if _, err := NewGrafanaSink("https://grafana", "glsa_x", "admin", "", "", nil, nil); err == nil {
+		t.Error("token and user: no error")
+	}
+	if _, err := NewGrafanaSink("https://grafana", "", "", "", "", nil, []string{"deploy"}); err == nil {
+		t.Error("unknown kind: no error")
+	}
+}
+
+func TestGrafanaSink(t *testing.T) {
+	var got []grafanaAnnotation
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/grafana/api/annotations" {
+			http.NotFound(w, r)
+			return
+		}
+		auth = r.Header.Get("Authorization")
+		var a grafanaAnnotation
+		json.NewDecoder(r.Body).Decode(&a)
+		got = append(got, a)
+		w.Write([]byte(`{"id":1,"message":"Annotation added"}`))
+	}))
+	defer server.Close()
+
+	s, err := NewGrafanaSink(server.URL+"/grafana/", "glsa_secret", "", "", "abc123", []string{"prod"}, []string{GrafanaFailure})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recovery := testEvent()
+	recovery.State = 0
+	for _, e := range []db.StoredEvent{testEvent(), recovery} {
+		if err := s.Send(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(got) != 1 {
+		t.Fatalf("got %d annotations, want the failure only", len(got))
+	}
+	a := got[0]
+	if a.DashboardUID != "abc123" || a.Time != testEvent().CreatedAt.UnixMilli() || a.Text != `web1/nginx "main": connection failed to localhost:80` {
+		t.Errorf("got %+v", a)
+	}
+	want := []string{"cmonit", "failure", "host:web1", `service:nginx "main"`, "prod"}
+	if len(a.Tags) != len(want) {
+		t.Fatalf("got tags %q, want %q", a.Tags, want)
+	}
+	for i := range want {
+		if a.Tags[i] != want[i] {
+			t.Errorf("got tags %q, want %q", a.Tags, want)
+		}
+	}
+	if auth != "Bearer glsa_secret" {
+		t.Errorf("got Authorization %q", auth)
+	}
+}
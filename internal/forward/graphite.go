@@ -0,0 +1,165 @@
+package forward
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ocochard/cmonit/internal/db"
+)
+
+// Graphite formats.
+const (
+	GraphitePlaintext = "plaintext" // "path value timestamp" lines
+	GraphitePickle    = "pickle"    // Pickled lists of (path, (timestamp, value))
+)
+
+// DefaultGraphitePath is the path template without [export.graphite] path.
+const DefaultGraphitePath = "monit.{host}.{service}.{metric}"
+
+// GraphiteSink sends the metrics to Carbon (Graphite) over TCP, in the
+// plaintext or pickle format, a write per batch on a connection kept open
+// and reopened after a failure.
+type GraphiteSink struct {
+	target  string // As configured, for Name
+	address string
+	format  string
+	path    string // Template, with {host}, {service}...
+
+	conn net.Conn
+}
+
+// NewGraphiteSink returns a sink writing to target, tcp://host[:port], in
+// format, plaintext (port 2003 by default) or pickle (2004). path is the template of the metric paths,
+// with {host}, {host_id}, {service}, {type} (e.g. cpu), {name} (e.g. user)
+// and {metric} (e.g. cpu_user); it defaults to DefaultGraphitePath.
+func NewGraphiteSink(target, format, path string) (*GraphiteSink, error) {
+	if format == "" {
+		format = GraphitePlaintext
+	}
+	if format != GraphitePlaintext && format != GraphitePickle {
+		return nil, fmt.Errorf("invalid Graphite format %q (valid: plaintext, pickle)", format)
+	}
+	if path == "" {
+		path = DefaultGraphitePath
+	}
+	u, err := url.Parse(target)
+	if err != nil || u.Hostname() == "" || u.Scheme != "tcp" {
+		return nil, fmt.Errorf("invalid Graphite target %q: must be tcp://host[:port]", target)
+	}
+	port := u.Port()
+	if port == "" {
+		port = map[string]string{GraphitePlaintext: "2003", GraphitePickle: "2004"}[format]
+	}
+	return &GraphiteSink{
+		target: target, address: net.JoinHostPort(u.Hostname(), port), format: format, path: path,
+	}, nil
+}
+
+// Name returns the format and the target.
+func (s *GraphiteSink) Name() string {
+	return "Graphite " + s.format + " " + s.target
+}
+
+// SendMetrics writes a batch, reconnecting after a failure.
+func (s *GraphiteSink) SendMetrics(metrics []db.StoredMetric) error {
+	var data []byte
+	if s.format == GraphitePickle {
+		data = s.pickle(metrics)
+	} else {
+		data = []byte(s.plaintext(metrics))
+	}
+	if len(data) == 0 {
+		return nil
+	}
+
+	if s.conn == nil {
+		conn, err := net.DialTimeout("tcp", s.address, syslogTimeout)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+	if _, err := s.conn.Write(data); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+// graphiteCleaner replaces the characters of the path parts that would
+// split them (dots) or that Graphite does not allow.
+func graphiteCleaner(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-' {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// metricPath returns the path of a data point, from the template.
+func (s *GraphiteSink) metricPath(m db.StoredMetric) string {
+	return strings.NewReplacer(
+		"{host}", graphiteCleaner(m.Hostname),
+		"{host_id}", graphiteCleaner(m.HostID),
+		"{service}", graphiteCleaner(m.Service),
+		"{type}", graphiteCleaner(m.Type),
+		"{name}", graphiteCleaner(m.Name),
+		"{metric}", graphiteCleaner(m.Type+"_"+m.Name),
+	).Replace(s.path)
+}
+
+// graphiteValid reports whether Graphite accepts the value of a data point.
+func graphiteValid(m db.StoredMetric) bool {
+	return !math.IsNaN(m.Value) && !math.IsInf(m.Value, 0)
+}
+
+// plaintext formats the metrics as "path value timestamp" lines.
+func (s *GraphiteSink) plaintext(metrics []db.StoredMetric) string {
+	var b strings.Builder
+	for _, m := range metrics {
+		if graphiteValid(m) {
+			fmt.Fprintf(&b, "%s %s %d\n", s.metricPath(m), strconv.FormatFloat(m.Value, 'f', -1, 64), m.CollectedAt.Unix())
+		}
+	}
+	return b.String()
+}
+
+// pickle encodes the metrics as the message of the pickle receiver: a
+// 4-byte big-endian length, then a list of (path, (timestamp, value))
+// tuples in the pickle protocol 2.
+func (s *GraphiteSink) pickle(metrics []db.StoredMetric) []byte {
+	var p bytes.Buffer
+	p.Write([]byte{0x80, 2, ']', '('}) // PROTO 2, EMPTY_LIST, MARK
+	count := 0
+	for _, m := range metrics {
+		if !graphiteValid(m) {
+			continue
+		}
+		path := s.metricPath(m)
+		p.WriteByte('X') // BINUNICODE
+		binary.Write(&p, binary.LittleEndian, uint32(len(path)))
+		p.WriteString(path)
+		p.WriteByte('G') // BINFLOAT, not a BININT of 32 bits, for 2038
+		binary.Write(&p, binary.BigEndian, float64(m.CollectedAt.Unix()))
+		p.WriteByte('G')
+		binary.Write(&p, binary.BigEndian, m.Value)
+		p.Write([]byte{0x86, 0x86}) // TUPLE2, TUPLE2
+		count++
+	}
+	if count == 0 {
+		return nil
+	}
+	p.Write([]byte{'e', '.'}) // APPENDS, STOP
+
+	return append(binary.BigEndian.AppendUint32(nil, uint32(p.Len())), p.Bytes()...)
+}
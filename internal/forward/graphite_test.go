@@ -0,0 +1,78 @@
+package forward
+
+import (
+	"bufio"
+	"bytes"
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ocochard/cmonit/internal/db"
+)
+
+func graphiteMetrics() []db.StoredMetric {
+	at := time.Date(2026, 10, 14, 9, 12, 5, 0, time.UTC)
+	return []db.StoredMetric{
+		{HostID: "h1", Hostname: "web1.example.com", Service: "web1", Type: "cpu", Name: "user", Value: 12.5, CollectedAt: at},
+		{HostID: "h1", Hostname: "web1.example.com", Service: "my app", Type: "process-cpu", Name: "percent", Value: 3, CollectedAt: at},
+		{HostID: "h1", Hostname: "web1.example.com", Service: "web1", Type: "cpu", Name: "wait", Value: math.Inf(1), CollectedAt: at},
+	}
+}
+
+func TestGraphitePlaintext(t *testing.T) {
+	s, err := NewGraphiteSink("tcp://carbon", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.address != "carbon:2003" {
+		t.Errorf("address %s", s.address)
+	}
+	want := "monit.web1_example_com.web1.cpu_user 12.5 1791969125\n" +
+		"monit.web1_example_com.my_app.process-cpu_percent 3 1791969125\n"
+	if got := s.plaintext(graphiteMetrics()); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	s, _ = NewGraphiteSink("tcp://carbon", "", "servers.{host_id}.{type}.{name}")
+	if got := s.metricPath(graphiteMetrics()[1]); got != "servers.h1.process-cpu.percent" {
+		t.Errorf("path %s", got)
+	}
+
+	for _, target := range []string{"udp://carbon", "carbon:2003"} {
+		if _, err := NewGraphiteSink(target, "", ""); err == nil {
+			t.Errorf("no error for %s", target)
+		}
+	}
+	if _, err := NewGraphiteSink("tcp://carbon", "json", ""); err == nil {
+		t.Error("no error for the json format")
+	}
+}
+
+func TestGraphitePickle(t *testing.T) {
+	received := make(chan []byte, 1)
+	addr := listen(t, func(conn net.Conn) {
+		var b bytes.Buffer
+		b.ReadFrom(bufio.NewReader(conn))
+		received <- b.Bytes()
+	})
+	s, err := NewGraphiteSink("tcp://"+addr, GraphitePickle, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := graphiteMetrics()[:1]
+	if err := s.SendMetrics(metrics); err != nil {
+		t.Fatal(err)
+	}
+	s.conn.Close()
+
+	path := "monit.web1_example_com.web1.cpu_user"
+	want := []byte{0, 0, 0, byte(4 + 5 + len(path) + 9 + 9 + 2 + 2), 0x80, 2, ']', '(', 'X', byte(len(path)), 0, 0, 0}
+	want = append(want, path...)
+	want = append(want, 'G', 0x41, 0xda, 0xb3, 0xd1, 0xd9, 0x40, 0, 0) // 1791969125
+	want = append(want, 'G', 0x40, 0x29, 0, 0, 0, 0, 0, 0)             // 12.5
+	want = append(want, 0x86, 0x86, 'e', '.')
+	if got := <-received; !bytes.Equal(got, want) {
+		t.Errorf("got  %x\nwant %x", got, want)
+	}
+}
@@ -0,0 +1,138 @@
+package forward
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// DefaultPingInterval is the least time between two pings of the global
+// URL of a Pinger.
+const DefaultPingInterval = time.Minute
+
+// Pinger hits dead-man's-switch URLs (healthchecks.io, Cronitor, Uptime
+// Kuma push monitors...) when the collector stores a status report, so
+// that an external watchdog alerts when cmonit, or a whole site, goes
+// silent: the global URL at most every interval for any host, and the URL
+// of a host for each of its reports.
+//
+// The pings run in the background: a slow watchdog does not delay the
+// agents, and a URL still being pinged is skipped.
+type Pinger struct {
+	url      string
+	hosts    map[string]string // Hostname to URL
+	interval time.Duration
+	client   *http.Client
+
+	mu       sync.Mutex
+	last     time.Time       // Of the last global ping
+	inFlight map[string]bool // URLs being pinged
+	failing  map[string]bool // URLs whose last ping failed, logged once
+}
+
+// NewPinger returns a Pinger of the global URL (empty for none) and the
+// URLs of the hosts, pinging the global one at most every interval.
+func NewPinger(global string, hosts map[string]string, interval time.Duration) (*Pinger, error) {
+	if err := checkPingURL("url", global); err != nil {
+		return nil, err
+	}
+	for hostname, raw := range hosts {
+		if err := checkPingURL("host "+hostname, raw); err != nil {
+			return nil, err
+		}
+	}
+	if interval <= 0 {
+		interval = DefaultPingInterval
+	}
+	return &Pinger{
+		url:      global,
+		hosts:    hosts,
+		interval: interval,
+		client:   &http.Client{Timeout: siemTimeout},
+		inFlight: make(map[string]bool),
+		failing:  make(map[string]bool),
+	}, nil
+}
+
+// checkPingURL checks a ping URL, empty or http(s).
+func checkPingURL(name, raw string) error {
+	if raw == "" {
+		return nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Hostname() == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("invalid ping URL of %s: must be an http(s):// URL", name)
+	}
+	return nil
+}
+
+// Ping pings the URLs due after a report of hostname was stored, without
+// waiting for them.
+func (p *Pinger) Ping(hostname string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	if p.url != "" && now.Sub(p.last) >= p.interval {
+		p.last = now
+		p.start(p.url)
+	}
+	if u := p.hosts[hostname]; u != "" {
+		p.start(u)
+	}
+}
+
+// start pings u in the background, unless it is already being pinged. The
+// caller holds p.mu.
+func (p *Pinger) start(u string) {
+	if p.inFlight[u] {
+		return
+	}
+	p.inFlight[u] = true
+	go func() {
+		err := p.get(u)
+
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		delete(p.inFlight, u)
+		switch {
+		case err != nil && !p.failing[u]:
+			log.Printf("[WARN] Failed to ping %s: %v", redactURL(u), err)
+			p.failing[u] = true
+		case err == nil && p.failing[u]:
+			log.Printf("[INFO] Pinging %s again", redactURL(u))
+			delete(p.failing, u)
+		}
+	}()
+}
+
+// get sends a ping; its errors do not contain the URL.
+func (p *Pinger) get(u string) error {
+	resp, err := p.client.Get(u)
+	if err != nil {
+		if ue, ok := err.(*url.Error); ok {
+			return ue.Err // Without the URL
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("answered %s", resp.Status)
+	}
+	return nil
+}
+
+// redactURL returns u without its path, which holds the check key of the
+// ping services.
+func redactURL(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return "ping URL"
+	}
+	return parsed.Scheme + "://" + parsed.Host + "/..."
+}
@@ -0,0 +1,75 @@
+package forward
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewPinger(t *testing.T) {
+	if _, err := NewPinger("hc-ping.com/abc", nil, 0); err == nil {
+		t.Error("URL without scheme: no error")
+	}
+	if _, err := NewPinger("", map[string]string{"web1": "ftp://example.com/abc"}, 0); err == nil {
+		t.Error("ftp:// host URL: no error")
+	}
+	p, err := NewPinger("https://hc-ping.com/abc", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.interval != DefaultPingInterval {
+		t.Errorf("interval: got %s", p.interval)
+	}
+}
+
+func TestPinger(t *testing.T) {
+	var mu sync.Mutex
+	hits := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	p, err := NewPinger(server.URL+"/global", map[string]string{"web1": server.URL + "/web1"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wait := func() {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+			p.mu.Lock()
+			idle := len(p.inFlight) == 0
+			p.mu.Unlock()
+			if idle {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("pings still running")
+			}
+		}
+	}
+
+	// The global URL once per interval, the host URL at each report
+	p.Ping("web1")
+	wait()
+	p.Ping("web1")
+	wait()
+	p.Ping("db1")
+	wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if hits["/global"] != 1 || hits["/web1"] != 2 || len(hits) != 2 {
+		t.Errorf("got %v", hits)
+	}
+}
+
+func TestRedactURL(t *testing.T) {
+	if got := redactURL("https://hc-ping.com/5f1a3b2c-key?x=1"); got != "https://hc-ping.com/..." {
+		t.Errorf("got %s", got)
+	}
+}
@@ -0,0 +1,129 @@
+package forward
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/ocochard/cmonit/internal/db"
+)
+
+// Escaping of the line protocol: measurements, then tag keys, tag values
+// and field keys.
+var (
+	influxMeasurementEscaper = strings.NewReplacer(`,`, `\,`, ` `, `\ `, "\n", `\n`)
+	influxKeyEscaper         = strings.NewReplacer(`,`, `\,`, `=`, `\=`, ` `, `\ `, "\n", `\n`)
+)
+
+// InfluxSink writes the metrics to InfluxDB in the line protocol, a
+// request per batch: measurement the metric type, fields the metric names
+// and tags the host and service, e.g.
+//
+//	cpu,host=web1,host_id=...,service=web1 user=12.5,system=3.1 1791969125000
+//
+// so that the points of a report share a line.
+type InfluxSink struct {
+	url    string // Write endpoint, with its parameters
+	user   string // InfluxDB 1.x credentials
+	pass   string
+	token  string // InfluxDB 2.x token
+	client *http.Client
+}
+
+// NewInfluxSink returns a sink writing to the InfluxDB server at rawURL
+// (http(s)://host:8086): to bucket of org with token on InfluxDB 2.x, or to
+// database (and retention policy rp) with user and password on 1.x when
+// bucket is empty.
+func NewInfluxSink(rawURL, database, rp, user, password, org, bucket, token string) (*InfluxSink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid InfluxDB URL %q: must be an http(s):// URL", rawURL)
+	}
+	params := url.Values{"precision": {"ms"}}
+	base := strings.TrimSuffix(rawURL, "/")
+	s := &InfluxSink{client: &http.Client{Timeout: siemTimeout}}
+	switch {
+	case bucket != "":
+		if org == "" {
+			return nil, fmt.Errorf("InfluxDB: org is required with bucket")
+		}
+		params.Set("org", org)
+		params.Set("bucket", bucket)
+		s.url, s.token = base+"/api/v2/write?"+params.Encode(), token
+	case database != "":
+		params.Set("db", database)
+		if rp != "" {
+			params.Set("rp", rp)
+		}
+		s.url, s.user, s.pass = base+"/write?"+params.Encode(), user, password
+	default:
+		return nil, fmt.Errorf("InfluxDB: set a bucket (2.x) or a database (1.x)")
+	}
+	return s, nil
+}
+
+// Name returns the write endpoint.
+func (s *InfluxSink) Name() string {
+	return "InfluxDB " + s.url
+}
+
+// SendMetrics writes a batch.
+func (s *InfluxSink) SendMetrics(metrics []db.StoredMetric) error {
+	lines := influxLines(metrics)
+	if lines == "" {
+		return nil
+	}
+	req, err := http.NewRequest(http.MethodPost, s.url, strings.NewReader(lines))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Token "+s.token)
+	} else if s.user != "" {
+		req.SetBasicAuth(s.user, s.pass)
+	}
+	return sendMetricRequest(s.client, req, len(metrics))
+}
+
+// influxLines formats the metrics in the line protocol, a line per metric
+// type, host, service and time, in the order of their first data point.
+// NaN and infinite values, which InfluxDB rejects, are left out.
+func influxLines(metrics []db.StoredMetric) string {
+	type line struct {
+		series string // Measurement and tags
+		fields []string
+		at     int64
+	}
+	var lines []*line
+	byKey := make(map[string]*line)
+	for _, m := range metrics {
+		if math.IsNaN(m.Value) || math.IsInf(m.Value, 0) {
+			continue
+		}
+		at := m.CollectedAt.UnixMilli()
+		key := m.Type + "\x00" + m.HostID + "\x00" + m.Service + "\x00" + strconv.FormatInt(at, 10)
+		l, ok := byKey[key]
+		if !ok {
+			series := influxMeasurementEscaper.Replace(m.Type)
+			for _, tag := range [][2]string{{"host", m.Hostname}, {"host_id", m.HostID}, {"service", m.Service}} {
+				if tag[1] != "" { // Empty tag values are not allowed
+					series += "," + tag[0] + "=" + influxKeyEscaper.Replace(tag[1])
+				}
+			}
+			l = &line{series: series, at: at}
+			byKey[key] = l
+			lines = append(lines, l)
+		}
+		l.fields = append(l.fields, influxKeyEscaper.Replace(m.Name)+"="+strconv.FormatFloat(m.Value, 'f', -1, 64))
+	}
+
+	var b strings.Builder
+	for _, l := range lines {
+		fmt.Fprintf(&b, "%s %s %d\n", l.series, strings.Join(l.fields, ","), l.at)
+	}
+	return b.String()
+}
@@ -0,0 +1,67 @@
+package forward
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ocochard/cmonit/internal/db"
+)
+
+func TestInfluxLines(t *testing.T) {
+	at := time.Date(2026, 10, 14, 9, 12, 5, 0, time.UTC)
+	metrics := []db.StoredMetric{
+		{HostID: "h1", Hostname: "web1", Service: "web1", Type: "cpu", Name: "user", Value: 12.5, CollectedAt: at},
+		{HostID: "h1", Hostname: "web1", Service: "my app", Type: "process-cpu", Name: "percent", Value: 3, CollectedAt: at},
+		{HostID: "h1", Hostname: "web1", Service: "web1", Type: "cpu", Name: "system", Value: 1e-7, CollectedAt: at},
+		{HostID: "h1", Hostname: "web1", Service: "web1", Type: "cpu", Name: "wait", Value: math.NaN(), CollectedAt: at},
+		{HostID: "h1", Hostname: "", Service: "web1", Type: "cpu", Name: "user", Value: 14, CollectedAt: at.Add(time.Minute)},
+	}
+	want := "cpu,host=web1,host_id=h1,service=web1 user=12.5,system=0.0000001 1791969125000\n" +
+		`process-cpu,host=web1,host_id=h1,service=my\ app percent=3 1791969125000` + "\n" +
+		"cpu,host_id=h1,service=web1 user=14 1791969185000\n"
+	if got := influxLines(metrics); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestInfluxSink(t *testing.T) {
+	var path, auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		path, auth = r.URL.RequestURI(), r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	metrics := []db.StoredMetric{{HostID: "h1", Hostname: "web1", Service: "web1", Type: "cpu", Name: "user", Value: 1}}
+	tests := []struct {
+		database, org, bucket string
+		path, auth            string
+	}{
+		{"", "ops", "monit", "/api/v2/write?bucket=monit&org=ops&precision=ms", "Token secret"},
+		{"monit", "", "", "/write?db=monit&precision=ms", "Basic dXNlcjpwYXNz"},
+	}
+	for _, tt := range tests {
+		s, err := NewInfluxSink(server.URL+"/", tt.database, "", "user", "pass", tt.org, tt.bucket, "secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.SendMetrics(metrics); err != nil {
+			t.Fatal(err)
+		}
+		if path != tt.path || auth != tt.auth {
+			t.Errorf("got %s %q, want %s %q", path, auth, tt.path, tt.auth)
+		}
+	}
+
+	if _, err := NewInfluxSink(server.URL, "", "", "", "", "", "monit", "secret"); err == nil {
+		t.Error("no error for a bucket without org")
+	}
+	if _, err := NewInfluxSink(server.URL, "", "", "", "", "", "", ""); err == nil {
+		t.Error("no error without bucket or database")
+	}
+}
@@ -0,0 +1,180 @@
+package forward
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ocochard/cmonit/internal/db"
+)
+
+// Metric forwarding limits.
+const (
+	// metricBatchSize is the number of data points read, and sent, at
+	// once for a metric sink
+	metricBatchSize = 2000
+
+	// metricMaxBacklog is the number of data points a metric sink may lag
+	// behind: a sink down for longer skips the oldest ones, so that it
+	// does not replay hours of data when it works again
+	metricMaxBacklog = 1000000
+)
+
+// MetricSink receives the stored data points in batches, oldest first.
+type MetricSink interface {
+	// Name identifies the sink in the logs, e.g. "remote_write https://..."
+	Name() string
+
+	// SendMetrics forwards a batch; an error makes the MetricForwarder
+	// retry it later, with the following data points
+	SendMetrics(metrics []db.StoredMetric) error
+}
+
+// metricSinkState is a metric sink with the last data point it got.
+type metricSinkState struct {
+	sink    MetricSink
+	after   int64         // Id of the last data point sent
+	failing bool          // The last SendMetrics failed, logged once
+	wake    chan struct{} // Signaled by Notify
+}
+
+// MetricForwarder sends the data points stored while it runs to its
+// sinks, each at its own position, like the Forwarder of the events.
+//
+// Each sink runs in its own goroutine, woken by Notify when the collector
+// stores a report and every Interval otherwise: a slow or failing sink
+// (Prometheus remote write, InfluxDB...) delays neither the collector nor
+// the other sinks. The metrics table buffers the data points of each sink,
+// up to metricMaxBacklog, until it takes them.
+type MetricForwarder struct {
+	db    *sql.DB
+	sinks []*metricSinkState
+}
+
+// Add registers a metric sink, before Run.
+func (f *MetricForwarder) Add(sink MetricSink) {
+	f.sinks = append(f.sinks, &metricSinkState{sink: sink, wake: make(chan struct{}, 1)})
+}
+
+// Notify wakes the sinks up after data points were stored, without
+// waiting for them.
+func (f *MetricForwarder) Notify() {
+	for _, s := range f.sinks {
+		select {
+		case s.wake <- struct{}{}:
+		default: // Already signaled
+		}
+	}
+}
+
+// Empty reports whether the MetricForwarder has no sink.
+func (f *MetricForwarder) Empty() bool {
+	return len(f.sinks) == 0
+}
+
+// Run forwards the data points stored from now on in database, forever.
+func (f *MetricForwarder) Run(database *sql.DB) {
+	start, err := db.LastMetricID(database)
+	if err != nil {
+		log.Printf("[ERROR] Failed to read the last metric, metrics are not forwarded: %v", err)
+		return
+	}
+	f.db = database
+
+	var wg sync.WaitGroup
+	for _, s := range f.sinks {
+		s.after = start
+		log.Printf("[INFO] Forwarding metrics to %s", s.sink.Name())
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f.run(s)
+		}()
+	}
+	wg.Wait()
+}
+
+// run forwards the new data points to a sink when notified, and every
+// Interval.
+func (f *MetricForwarder) run(s *metricSinkState) {
+	ticker := time.NewTicker(Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.wake:
+			if s.failing {
+				continue // Retried every Interval only
+			}
+		}
+		f.forward(s)
+	}
+}
+
+// forward sends the new data points to a sink, until a batch fails.
+func (f *MetricForwarder) forward(s *metricSinkState) {
+	last, err := db.LastMetricID(f.db)
+	if err != nil {
+		log.Printf("[WARN] Failed to read the metrics to forward: %v", err)
+		return
+	}
+	if last-s.after > metricMaxBacklog {
+		log.Printf("[WARN] %s is %d data points behind, skipping the oldest ones", s.sink.Name(), last-s.after)
+		s.after = last - metricMaxBacklog
+	}
+
+	for {
+		metrics, err := db.MetricsAfter(f.db, s.after, metricBatchSize)
+		if err != nil {
+			log.Printf("[WARN] Failed to read the metrics to forward: %v", err)
+			return
+		}
+		if len(metrics) == 0 {
+			return
+		}
+
+		if err := s.sink.SendMetrics(metrics); err != nil {
+			if !s.failing {
+				log.Printf("[WARN] Failed to forward metrics to %s, retrying: %v", s.sink.Name(), err)
+				s.failing = true
+			}
+			return
+		}
+		if s.failing {
+			log.Printf("[INFO] Forwarding metrics to %s again", s.sink.Name())
+			s.failing = false
+		}
+		s.after = metrics[len(metrics)-1].ID
+
+		if len(metrics) < metricBatchSize {
+			return
+		}
+	}
+}
+
+// sendMetricRequest sends the request of a batch of count data points to a
+// time series database. A batch it rejects (4xx but 429, e.g. out-of-order
+// samples) is dropped with a warning, as retrying it would fail again; the
+// other failures are returned, for the batch to be retried.
+func sendMetricRequest(client *http.Client, req *http.Request, count int) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode <= 299:
+		return nil
+	case resp.StatusCode >= 400 && resp.StatusCode <= 499 && resp.StatusCode != http.StatusTooManyRequests:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		log.Printf("[WARN] %s rejected %d data points: %s %s", req.URL.Redacted(), count, resp.Status, strings.TrimSpace(string(body)))
+		return nil
+	}
+	return fmt.Errorf("%s answered %s", req.URL.Redacted(), resp.Status)
+}
@@ -0,0 +1,29 @@
+package forward
+
+import (
+	"testing"
+
+	"github.com/ocochard/cmonit/internal/db"
+)
+
+type nopMetricSink struct{}
+
+func (nopMetricSink) Name() string                                { return "nop" }
+func (nopMetricSink) SendMetrics(metrics []db.StoredMetric) error { return nil }
+
+// TestMetricForwarderNotify checks that Notify never blocks the collector,
+// whatever the sinks are doing.
+func TestMetricForwarderNotify(t *testing.T) {
+	var f MetricForwarder
+	f.Notify()
+	f.Add(nopMetricSink{})
+	f.Add(nopMetricSink{})
+	for i := 0; i < 3; i++ {
+		f.Notify() // Nobody reading
+	}
+	for _, s := range f.sinks {
+		if len(s.wake) != 1 {
+			t.Errorf("%d pending wake-ups, want 1", len(s.wake))
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package forward
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// MQTT 3.1.1 packet types, in the high nibble of the first byte.
+const (
+	mqttConnect = 0x10
+	mqttConnack = 0x20
+	mqttPublish = 0x30
+	mqttPuback  = 0x40
+)
+
+// mqttKeepAlive is the keep alive announced to the broker; an idle
+// connection it closed is opened again on the next message.
+const mqttKeepAlive = 300 // seconds
+
+// mqttConnackErrors are the return codes of a refused connection.
+var mqttConnackErrors = map[byte]string{
+	1: "unacceptable protocol version",
+	2: "client identifier rejected",
+	3: "server unavailable",
+	4: "bad user name or password",
+	5: "not authorized",
+}
+
+// mqttClient publishes messages to an MQTT 3.1.1 broker, enough of the
+// protocol for cmonit: no subscriptions, QoS 0 or 1.
+type mqttClient struct {
+	address  string // host:port
+	tls      bool   // mqtts://
+	clientID string
+	user     string
+	password string
+
+	conn     net.Conn
+	r        *bufio.Reader
+	packetID uint16
+}
+
+// publish sends a message, waiting for the broker to acknowledge it with
+// QoS 1. A connection the broker closed is opened again once.
+func (c *mqttClient) publish(topic string, payload []byte, qos byte, retain bool) error {
+	reused := c.conn != nil
+	err := c.send(topic, payload, qos, retain)
+	if err != nil && reused {
+		err = c.send(topic, payload, qos, retain)
+	}
+	return err
+}
+
+// send connects if needed and sends a message.
+func (c *mqttClient) send(topic string, payload []byte, qos byte, retain bool) error {
+	if c.conn == nil {
+		if err := c.connect(); err != nil {
+			return err
+		}
+	}
+
+	c.packetID++
+	if c.packetID == 0 {
+		c.packetID = 1
+	}
+	header := byte(mqttPublish) | qos<<1
+	if retain {
+		header |= 1
+	}
+	body := mqttString(topic)
+	if qos > 0 {
+		body = binary.BigEndian.AppendUint16(body, c.packetID)
+	}
+	if err := c.write(header, append(body, payload...)); err != nil {
+		return err
+	}
+	if qos == 0 {
+		return nil
+	}
+
+	for {
+		packetType, body, err := c.read()
+		if err != nil {
+			return err
+		}
+		if packetType == mqttPuback && len(body) == 2 && binary.BigEndian.Uint16(body) == c.packetID {
+			return nil
+		}
+	}
+}
+
+// connect opens the connection and sends CONNECT, with a clean session.
+func (c *mqttClient) connect() error {
+	dialer := &net.Dialer{Timeout: syslogTimeout}
+	var conn net.Conn
+	var err error
+	if c.tls {
+		conn, err = tls.DialWithDialer(dialer, "tcp", c.address, nil)
+	} else {
+		conn, err = dialer.Dial("tcp", c.address)
+	}
+	if err != nil {
+		return err
+	}
+	c.conn, c.r = conn, bufio.NewReader(conn)
+
+	flags := byte(0x02) // Clean session
+	payload := mqttString(c.clientID)
+	if c.user != "" {
+		flags |= 0x80
+		payload = append(payload, mqttString(c.user)...)
+		if c.password != "" {
+			flags |= 0x40
+			payload = append(payload, mqttString(c.password)...)
+		}
+	}
+	body := append(mqttString("MQTT"), 4, flags) // Protocol level 4: 3.1.1
+	body = binary.BigEndian.AppendUint16(body, mqttKeepAlive)
+	if err := c.write(mqttConnect, append(body, payload...)); err != nil {
+		return err
+	}
+
+	packetType, body, err := c.read()
+	switch {
+	case err != nil:
+		return err
+	case packetType != mqttConnack || len(body) != 2:
+		c.close()
+		return fmt.Errorf("MQTT broker %s: unexpected packet %#x", c.address, packetType)
+	case body[1] != 0:
+		c.close()
+		reason, ok := mqttConnackErrors[body[1]]
+		if !ok {
+			reason = fmt.Sprintf("return code %d", body[1])
+		}
+		return fmt.Errorf("MQTT broker %s refused the connection: %s", c.address, reason)
+	}
+	return nil
+}
+
+// write sends a packet, closing the connection on failure.
+func (c *mqttClient) write(header byte, body []byte) error {
+	packet := []byte{header}
+	for n := len(body); ; {
+		b := byte(n % 128)
+		if n /= 128; n > 0 {
+			b |= 0x80
+		}
+		packet = append(packet, b)
+		if n == 0 {
+			break
+		}
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+	if _, err := c.conn.Write(append(packet, body...)); err != nil {
+		c.close()
+		return err
+	}
+	return nil
+}
+
+// read reads a packet, returning its type and its body; it closes the
+// connection on failure.
+func (c *mqttClient) read() (byte, []byte, error) {
+	c.conn.SetReadDeadline(time.Now().Add(syslogTimeout))
+	header, err := c.r.ReadByte()
+	if err != nil {
+		c.close()
+		return 0, nil, err
+	}
+	var length, shift int
+	for {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			c.close()
+			return 0, nil, err
+		}
+		length |= int(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		if shift += 7; shift > 21 {
+			c.close()
+			return 0, nil, errors.New("invalid MQTT packet length")
+		}
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		c.close()
+		return 0, nil, err
+	}
+	return header & 0xf0, body, nil
+}
+
+// close closes the connection, opened again by the next message.
+func (c *mqttClient) close() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+// mqttString encodes a string with its length.
+func mqttString(s string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(s))), s...)
+}
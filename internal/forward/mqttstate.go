@@ -0,0 +1,301 @@
+package forward
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ocochard/cmonit/internal/db"
+)
+
+// DefaultMQTTStatePrefix starts the topics without [export.mqtt]
+// topic_prefix.
+const DefaultMQTTStatePrefix = "cmonit"
+
+// DefaultMQTTStateMetrics are the key metrics published without
+// [export.mqtt] metrics (see parseMetricPattern).
+var DefaultMQTTStateMetrics = []string{
+	"*/*/cpu.user", "*/*/cpu.system", "*/*/memory.percent", "*/*/swap.percent", "*/*/load.avg01",
+	"*/*/process_cpu.percent", "*/*/process_memory.percent",
+}
+
+// mqttStateRefresh is the time after which every state and discovery
+// config is published again, for a broker that lost its retained
+// messages.
+const mqttStateRefresh = 10 * time.Minute
+
+// mqttStateUnits are the units of the metrics, by metric name (cpu
+// metrics being percents).
+var mqttStateUnits = map[string]string{
+	"percent":        "%",
+	"total_percent":  "%",
+	"kilobyte":       "kB",
+	"download_bytes": "B/s",
+	"upload_bytes":   "B/s",
+}
+
+// mqttStateUnit returns the unit of a metric, "" if unknown.
+func mqttStateUnit(m db.StoredMetric) string {
+	if m.Type == "cpu" {
+		return "%"
+	}
+	return mqttStateUnits[m.Name]
+}
+
+// MQTTStateSink publishes the state of the hosts and services, and their
+// key metrics, as retained MQTT messages, for home automation dashboards:
+//
+//   - <prefix>/<host>/availability: online or offline (see
+//     db.HostSeen.Offline)
+//   - <prefix>/<host>/<service>/state: JSON state, "OK", "PROBLEM" or
+//     "UNMONITORED", with the Monit status bits, monitoring mode and
+//     service type
+//   - <prefix>/<host>/<service>/<type>_<name>: latest value of a metric
+//
+// With a discovery prefix, it also publishes the Home Assistant MQTT
+// discovery configs: a device per host with a connectivity binary sensor,
+// a problem binary sensor per service and a sensor per metric.
+type MQTTStateSink struct {
+	url       string // As configured, for Name
+	prefix    string
+	discovery string // Home Assistant discovery prefix, "" for none
+	patterns  []metricPattern
+
+	mu         sync.Mutex
+	mqtt       *mqttClient
+	published  map[string]string // Payload of each state topic
+	discovered map[string]bool   // Discovery config topics published
+	refreshed  time.Time
+}
+
+// NewMQTTStateSink returns a sink publishing to rawURL, mqtt://host[:port]
+// or mqtts://host[:port], under the topic prefix (DefaultMQTTStatePrefix
+// when empty) the metrics matching one of patterns
+// (DefaultMQTTStateMetrics when empty). discovery is the Home Assistant
+// discovery prefix, usually "homeassistant"; empty publishes no config.
+func NewMQTTStateSink(rawURL, user, password, prefix, discovery string, patterns []string) (*MQTTStateSink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Hostname() == "" || (u.Scheme != "mqtt" && u.Scheme != "mqtts") {
+		return nil, fmt.Errorf("invalid MQTT URL %q: must be mqtt:// or mqtts://host[:port]", rawURL)
+	}
+	port := u.Port()
+	if port == "" {
+		port = busDefaults[u.Scheme].port
+	}
+	if prefix == "" {
+		prefix = DefaultMQTTStatePrefix
+	}
+	if len(patterns) == 0 {
+		patterns = DefaultMQTTStateMetrics
+	}
+
+	s := &MQTTStateSink{
+		url:       rawURL,
+		prefix:    strings.TrimSuffix(prefix, "/"),
+		discovery: strings.TrimSuffix(discovery, "/"),
+		mqtt: &mqttClient{address: net.JoinHostPort(u.Hostname(), port), tls: u.Scheme == "mqtts",
+			clientID: mqttClientID("state"), user: user, password: password},
+		published:  make(map[string]string),
+		discovered: make(map[string]bool),
+		refreshed:  time.Now(),
+	}
+	for _, pattern := range patterns {
+		p, err := parseMetricPattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		s.patterns = append(s.patterns, p)
+	}
+	return s, nil
+}
+
+// Name returns the URL of the broker.
+func (s *MQTTStateSink) Name() string {
+	return "MQTT state " + s.url
+}
+
+// topic returns the topic of a host (and of a service and a leaf).
+func (s *MQTTStateSink) topic(hostname string, parts ...string) string {
+	topic := s.prefix + "/" + busTopicCleaners["mqtt"].Replace(hostname)
+	for _, p := range parts {
+		topic += "/" + busTopicCleaners["mqtt"].Replace(p)
+	}
+	return topic
+}
+
+// SendMetrics publishes the latest value of the selected metrics of a
+// batch.
+func (s *MQTTStateSink) SendMetrics(metrics []db.StoredMetric) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The last data point of each series only
+	latest := make(map[string]db.StoredMetric)
+	var topics []string
+	for _, m := range metrics {
+		if !matchMetric(s.patterns, m) || math.IsNaN(m.Value) || math.IsInf(m.Value, 0) {
+			continue
+		}
+		topic := s.topic(m.Hostname, m.Service, m.Type+"_"+m.Name)
+		if _, ok := latest[topic]; !ok {
+			topics = append(topics, topic)
+		}
+		latest[topic] = m
+	}
+
+	for _, topic := range topics {
+		m := latest[topic]
+		if err := s.discover("sensor", m.HostID, m.Hostname, m.Service+"_"+m.Type+"_"+m.Name, haConfig{
+			Name:              m.Service + " " + m.Type + " " + m.Name,
+			StateTopic:        topic,
+			UnitOfMeasurement: mqttStateUnit(m),
+			StateClass:        "measurement",
+			AvailabilityTopic: s.topic(m.Hostname, "availability"),
+		}); err != nil {
+			return err
+		}
+		if err := s.mqtt.publish(topic, []byte(strconv.FormatFloat(m.Value, 'f', -1, 64)), 0, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mqttServiceState is the payload of the state topic of a service.
+type mqttServiceState struct {
+	State   string `json:"state"`
+	Status  int    `json:"status"`
+	Monitor int    `json:"monitor"`
+	Type    int    `json:"type"`
+}
+
+// PublishStates publishes the availability of the hosts at now and the
+// state of the services, when they changed since the last call (all of
+// them every mqttStateRefresh).
+func (s *MQTTStateSink) PublishStates(hosts []db.HostSeen, services []db.ServiceState, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.refreshed) >= mqttStateRefresh {
+		clear(s.published)
+		clear(s.discovered)
+		s.refreshed = now
+	}
+
+	for _, h := range hosts {
+		topic := s.topic(h.Hostname, "availability")
+		if err := s.discover("binary_sensor", h.ID, h.Hostname, "availability", haConfig{
+			Name:        "Connectivity",
+			StateTopic:  topic,
+			PayloadOn:   "online",
+			PayloadOff:  "offline",
+			DeviceClass: "connectivity",
+		}); err != nil {
+			return err
+		}
+		availability := "online"
+		if h.Offline(now) {
+			availability = "offline"
+		}
+		if err := s.publishState(topic, availability); err != nil {
+			return err
+		}
+	}
+
+	for _, svc := range services {
+		topic := s.topic(svc.Hostname, svc.Service, "state")
+		if err := s.discover("binary_sensor", svc.HostID, svc.Hostname, svc.Service+"_state", haConfig{
+			Name:                svc.Service,
+			StateTopic:          topic,
+			ValueTemplate:       "{{ 'ON' if value_json.state == 'PROBLEM' else 'OFF' }}",
+			JSONAttributesTopic: topic,
+			DeviceClass:         "problem",
+			AvailabilityTopic:   s.topic(svc.Hostname, "availability"),
+		}); err != nil {
+			return err
+		}
+		state := mqttServiceState{State: "OK", Status: svc.Status, Monitor: svc.Monitor, Type: svc.Type}
+		switch {
+		case svc.Monitor == 0:
+			state.State = "UNMONITORED"
+		case svc.Status != 0:
+			state.State = "PROBLEM"
+		}
+		payload, err := json.Marshal(state)
+		if err != nil {
+			return err
+		}
+		if err := s.publishState(topic, string(payload)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// publishState publishes a retained state, unless unchanged. The caller
+// holds s.mu.
+func (s *MQTTStateSink) publishState(topic, payload string) error {
+	if previous, ok := s.published[topic]; ok && previous == payload {
+		return nil
+	}
+	if err := s.mqtt.publish(topic, []byte(payload), 1, true); err != nil {
+		return err
+	}
+	s.published[topic] = payload
+	return nil
+}
+
+// haConfig is a Home Assistant MQTT discovery config.
+type haConfig struct {
+	Name                string   `json:"name"`
+	UniqueID            string   `json:"unique_id"`
+	StateTopic          string   `json:"state_topic"`
+	ValueTemplate       string   `json:"value_template,omitempty"`
+	JSONAttributesTopic string   `json:"json_attributes_topic,omitempty"`
+	PayloadOn           string   `json:"payload_on,omitempty"`
+	PayloadOff          string   `json:"payload_off,omitempty"`
+	DeviceClass         string   `json:"device_class,omitempty"`
+	StateClass          string   `json:"state_class,omitempty"`
+	UnitOfMeasurement   string   `json:"unit_of_measurement,omitempty"`
+	AvailabilityTopic   string   `json:"availability_topic,omitempty"`
+	Device              haDevice `json:"device"`
+}
+
+// haDevice is the device of a Home Assistant entity, a Monit host.
+type haDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model"`
+}
+
+// discover publishes the discovery config of an entity of a host, once.
+// The caller holds s.mu.
+func (s *MQTTStateSink) discover(component, hostID, hostname, object string, config haConfig) error {
+	if s.discovery == "" {
+		return nil
+	}
+	node := "cmonit_" + graphiteCleaner(hostID)
+	topic := s.discovery + "/" + component + "/" + node + "/" + graphiteCleaner(object) + "/config"
+	if s.discovered[topic] {
+		return nil
+	}
+
+	config.UniqueID = node + "_" + graphiteCleaner(object)
+	config.Device = haDevice{Identifiers: []string{node}, Name: hostname, Manufacturer: "Monit", Model: "cmonit host"}
+	payload, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	if err := s.mqtt.publish(topic, payload, 1, true); err != nil {
+		return err
+	}
+	s.discovered[topic] = true
+	return nil
+}
@@ -0,0 +1,182 @@
+package forward
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ocochard/cmonit/internal/db"
+)
+
+// mqttBroker is a fake broker recording the retained messages.
+type mqttBroker struct {
+	mu       sync.Mutex
+	retained map[string]string
+	count    int // Messages published
+}
+
+// listenMQTT starts a fake broker.
+func listenMQTT(t *testing.T) (string, *mqttBroker) {
+	b := &mqttBroker{retained: make(map[string]string)}
+	addr := listen(t, func(conn net.Conn) {
+		c := &mqttClient{conn: conn, r: bufio.NewReader(conn)}
+		if packetType, _, err := c.read(); err != nil || packetType != mqttConnect {
+			return
+		}
+		c.write(mqttConnack, []byte{0, 0})
+		for {
+			header, err := c.r.Peek(1)
+			if err != nil {
+				return
+			}
+			qos, retain := header[0]>>1&3, header[0]&1 == 1
+			packetType, body, err := c.read()
+			if err != nil || packetType != mqttPublish {
+				return
+			}
+			n := 2 + int(binary.BigEndian.Uint16(body)) // After the topic
+			topic, payload := string(body[2:n]), body[n:]
+			if qos > 0 {
+				payload = body[n+2:]
+				c.write(mqttPuback, body[n:n+2])
+			}
+			b.mu.Lock()
+			b.count++
+			if retain {
+				b.retained[topic] = string(payload)
+			}
+			b.mu.Unlock()
+		}
+	})
+	return "mqtt://" + addr, b
+}
+
+// get returns the retained message of topic.
+func (b *mqttBroker) get(topic string) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.retained[topic]
+}
+
+func TestNewMQTTStateSink(t *testing.T) {
+	for _, raw := range []string{"nats://broker", "mqtt://", "broker:1883"} {
+		if _, err := NewMQTTStateSink(raw, "", "", "", "", nil); err == nil {
+			t.Errorf("%s: no error", raw)
+		}
+	}
+	if _, err := NewMQTTStateSink("mqtt://broker", "", "", "", "", []string{"*/cpu.user"}); err == nil {
+		t.Error("bad pattern: no error")
+	}
+	s, err := NewMQTTStateSink("mqtts://broker", "", "", "", "homeassistant/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.mqtt.address != "broker:8883" || s.prefix != DefaultMQTTStatePrefix || s.discovery != "homeassistant" || len(s.patterns) != len(DefaultMQTTStateMetrics) {
+		t.Errorf("got %s %s %s %d", s.mqtt.address, s.prefix, s.discovery, len(s.patterns))
+	}
+}
+
+func TestMQTTStateSink(t *testing.T) {
+	url, broker := listenMQTT(t)
+	s, err := NewMQTTStateSink(url, "", "", "home/monit", "homeassistant", []string{"*/*/cpu.*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+
+	err = s.SendMetrics([]db.StoredMetric{
+		{HostID: "web1-0", Hostname: "web1", Service: "web1", Type: "cpu", Name: "user", Value: 12.5},
+		{HostID: "web1-0", Hostname: "web1", Service: "web1", Type: "memory", Name: "percent", Value: 40},
+		{HostID: "web1-0", Hostname: "web1", Service: "web1", Type: "cpu", Name: "user", Value: 13},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts := []db.HostSeen{{ID: "web1-0", Hostname: "web1", LastSeen: now, PollInterval: 30}}
+	services := []db.ServiceState{{HostID: "web1-0", Hostname: "web1", Service: "disk/root", Type: 0, Status: 512, Monitor: 1}}
+	if err := s.PublishStates(hosts, services, now); err != nil {
+		t.Fatal(err)
+	}
+
+	// Unchanged states are not published again
+	broker.mu.Lock()
+	count := broker.count
+	broker.mu.Unlock()
+	if err := s.PublishStates(hosts, services, now); err != nil {
+		t.Fatal(err)
+	}
+	hosts[0].LastSeen = now.Add(-time.Hour)
+	if err := s.PublishStates(hosts, services, now); err != nil {
+		t.Fatal(err)
+	}
+
+	// The broker got the messages before the acknowledgment of the last one
+	for topic, want := range map[string]string{
+		"home/monit/web1/web1/cpu_user":       "13",
+		"home/monit/web1/availability":        "offline",
+		"home/monit/web1/disk_root/state":     `{"state":"PROBLEM","status":512,"monitor":1,"type":0}`,
+		"home/monit/web1/web1/memory_percent": "",
+	} {
+		if got := broker.get(topic); got != want {
+			t.Errorf("%s: got %q, want %q", topic, got, want)
+		}
+	}
+	broker.mu.Lock()
+	if broker.count != count+1 {
+		t.Errorf("got %d messages after the first states, want 1", broker.count-count)
+	}
+	broker.mu.Unlock()
+
+	var config haConfig
+	if err := json.Unmarshal([]byte(broker.get("homeassistant/binary_sensor/cmonit_web1-0/disk_root_state/config")), &config); err != nil {
+		t.Fatal(err)
+	}
+	if config.UniqueID != "cmonit_web1-0_disk_root_state" || config.DeviceClass != "problem" || config.StateTopic != "home/monit/web1/disk_root/state" ||
+		config.AvailabilityTopic != "home/monit/web1/availability" || config.Device.Name != "web1" || config.Device.Identifiers[0] != "cmonit_web1-0" {
+		t.Errorf("got service config %+v", config)
+	}
+	config = haConfig{}
+	if err := json.Unmarshal([]byte(broker.get("homeassistant/sensor/cmonit_web1-0/web1_cpu_user/config")), &config); err != nil {
+		t.Fatal(err)
+	}
+	if config.UnitOfMeasurement != "%" || config.StateClass != "measurement" || config.StateTopic != "home/monit/web1/web1/cpu_user" {
+		t.Errorf("got metric config %+v", config)
+	}
+}
+
+// TestMQTTStateSinkDB checks the availability published for the hosts read
+// from the database: online while reporting, offline once silent.
+func TestMQTTStateSinkDB(t *testing.T) {
+	url, broker := listenMQTT(t)
+	s, err := NewMQTTStateSink(url, "", "", "home/monit", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	database := testDB(t, map[string]time.Time{"web1": {}, "db1": now.Add(-time.Hour)})
+
+	hosts, err := db.HostsSeen(database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	services, err := db.ServiceStates(database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PublishStates(hosts, services, now); err != nil {
+		t.Fatal(err)
+	}
+
+	for topic, want := range map[string]string{
+		"home/monit/web1/availability": "online",
+		"home/monit/db1/availability":  "offline",
+	} {
+		if got := broker.get(topic); got != want {
+			t.Errorf("%s: got %q, want %q", topic, got, want)
+		}
+	}
+}
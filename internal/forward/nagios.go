@@ -0,0 +1,178 @@
+package forward
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ocochard/cmonit/internal/db"
+)
+
+// Nagios APIs.
+const (
+	NagiosNRDP    = "nrdp"    // Nagios Remote Data Processor
+	NagiosIcinga2 = "icinga2" // Icinga 2 REST API
+)
+
+// Nagios states of the passive check results.
+const (
+	nagiosOK       = 0
+	nagiosWarning  = 1
+	nagiosCritical = 2
+)
+
+// nrdpCheckResults is the XMLDATA of an NRDP submitcheck.
+type nrdpCheckResults struct {
+	XMLName xml.Name          `xml:"checkresults"`
+	Results []nrdpCheckResult `xml:"checkresult"`
+}
+
+type nrdpCheckResult struct {
+	Type      string `xml:"type,attr"`
+	CheckType int    `xml:"checktype,attr"` // 1: passive
+	Hostname  string `xml:"hostname"`
+	Service   string `xml:"servicename"`
+	State     int    `xml:"state"`
+	Output    string `xml:"output"`
+}
+
+// NagiosSink submits the service state changes as passive check results
+// to Nagios (NRDP) or Icinga 2 (REST API), for their dashboards to follow
+// the Monit services: the Nagios host and service are the Monit host and
+// service names.
+type NagiosSink struct {
+	api      string
+	url      string // Of the NRDP endpoint or the Icinga 2 action
+	token    string // NRDP token
+	user     string // Icinga 2 API user
+	password string
+	client   *http.Client
+}
+
+// NewNagiosSink returns a sink submitting to api, nrdp (rawURL the NRDP
+// endpoint, e.g. https://nagios/nrdp/, with token) or icinga2 (rawURL the
+// API, e.g. https://icinga:5665, with user and password).
+func NewNagiosSink(api, rawURL, token, user, password string) (*NagiosSink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid Nagios URL %q: must be an http(s):// URL", rawURL)
+	}
+	s := &NagiosSink{api: api, token: token, user: user, password: password, client: &http.Client{Timeout: siemTimeout}}
+	switch api {
+	case NagiosNRDP:
+		if token == "" {
+			return nil, fmt.Errorf("NRDP: token is required")
+		}
+		s.url = rawURL
+	case NagiosIcinga2:
+		s.url = strings.TrimSuffix(rawURL, "/") + "/v1/actions/process-check-result"
+	default:
+		return nil, fmt.Errorf("invalid Nagios API %q (valid: nrdp, icinga2)", api)
+	}
+	return s, nil
+}
+
+// Name returns the API and the URL.
+func (s *NagiosSink) Name() string {
+	return s.api + " " + s.url
+}
+
+// nagiosState returns the state of the check result of an event: OK for a
+// recovery, else from its severity.
+func nagiosState(e db.StoredEvent) int {
+	switch {
+	case e.State == 0: // EventStateSucceeded
+		return nagiosOK
+	case e.Severity == db.SeverityCritical:
+		return nagiosCritical
+	case e.Severity == db.SeverityWarning:
+		return nagiosWarning
+	}
+	return nagiosOK
+}
+
+// Send submits the check result of an event. The events without a
+// service, such as those of cmonit itself, are skipped.
+func (s *NagiosSink) Send(e db.StoredEvent) error {
+	if e.Service == "" {
+		return nil
+	}
+	if s.api == NagiosIcinga2 {
+		return s.sendIcinga2(e)
+	}
+	return s.sendNRDP(e)
+}
+
+// sendNRDP submits a check result with the submitcheck command of NRDP.
+func (s *NagiosSink) sendNRDP(e db.StoredEvent) error {
+	data, err := xml.Marshal(nrdpCheckResults{Results: []nrdpCheckResult{
+		{Type: "service", CheckType: 1, Hostname: e.Hostname, Service: e.Service, State: nagiosState(e), Output: e.Message},
+	}})
+	if err != nil {
+		return err
+	}
+	form := url.Values{"token": {s.token}, "cmd": {"submitcheck"}, "XMLDATA": {xml.Header + string(data)}}
+
+	resp, err := s.client.PostForm(s.url, form)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("NRDP answered %s", resp.Status)
+	}
+	var result struct {
+		Status  int    `xml:"status"`
+		Message string `xml:"message"`
+	}
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&result); err != nil {
+		return fmt.Errorf("NRDP: unexpected answer: %w", err)
+	}
+	if result.Status != 0 {
+		return fmt.Errorf("NRDP: %s", result.Message)
+	}
+	return nil
+}
+
+// sendIcinga2 submits a check result with the process-check-result action
+// of the Icinga 2 API. A result for a host or service Icinga does not
+// know is dropped with a warning, as retrying it would fail again.
+func (s *NagiosSink) sendIcinga2(e db.StoredEvent) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"type":          "Service",
+		"filter":        "host.name == h && service.name == s",
+		"filter_vars":   map[string]string{"h": e.Hostname, "s": e.Service},
+		"exit_status":   nagiosState(e),
+		"plugin_output": e.Message,
+		"check_source":  "cmonit",
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(s.user, s.password)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode <= 299:
+		return nil
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusBadRequest:
+		log.Printf("[WARN] Icinga 2 rejected the check result of %s/%s: %s", e.Hostname, e.Service, resp.Status)
+		return nil
+	}
+	return fmt.Errorf("Icinga 2 answered %s", resp.Status)
+}
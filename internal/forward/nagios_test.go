@@ -0,0 +1,106 @@
+package forward
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ocochard/cmonit/internal/db"
+)
+
+func TestNagiosState(t *testing.T) {
+	tests := []struct {
+		state    int
+		severity string
+		want     int
+	}{
+		{0, db.SeverityCritical, nagiosOK},
+		{1, db.SeverityCritical, nagiosCritical},
+		{1, db.SeverityWarning, nagiosWarning},
+		{2, db.SeverityInfo, nagiosOK},
+		{-1, db.SeverityWarning, nagiosWarning},
+	}
+	for _, tt := range tests {
+		if got := nagiosState(db.StoredEvent{State: tt.state, Severity: tt.severity}); got != tt.want {
+			t.Errorf("state %d %s: got %d, want %d", tt.state, tt.severity, got, tt.want)
+		}
+	}
+}
+
+func TestNagiosSinkNRDP(t *testing.T) {
+	var form map[string][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = r.PostForm
+		if r.PostForm.Get("token") != "secret" {
+			io.WriteString(w, "<result><status>-1</status><message>BAD TOKEN</message></result>")
+			return
+		}
+		io.WriteString(w, "<result><status>0</status><message>OK</message></result>")
+	}))
+	defer server.Close()
+
+	s, err := NewNagiosSink(NagiosNRDP, server.URL+"/nrdp/", "secret", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Send(testEvent()); err != nil {
+		t.Fatal(err)
+	}
+	var results nrdpCheckResults
+	if err := xml.Unmarshal([]byte(form["XMLDATA"][0]), &results); err != nil {
+		t.Fatal(err)
+	}
+	want := nrdpCheckResult{Type: "service", CheckType: 1, Hostname: "web1", Service: `nginx "main"`, State: nagiosCritical, Output: "connection failed to localhost:80"}
+	if form["cmd"][0] != "submitcheck" || len(results.Results) != 1 || results.Results[0] != want {
+		t.Errorf("got %v %+v", form["cmd"], results.Results)
+	}
+
+	s.token = "wrong"
+	if err := s.Send(testEvent()); err == nil {
+		t.Error("no error for a bad token")
+	}
+}
+
+func TestNagiosSinkIcinga2(t *testing.T) {
+	var path, user string
+	var body map[string]interface{}
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		user, _, _ = r.BasicAuth()
+		json.NewDecoder(r.Body).Decode(&body)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	s, err := NewNagiosSink(NagiosIcinga2, server.URL, "", "cmonit", "pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Send(testEvent()); err != nil {
+		t.Fatal(err)
+	}
+	vars, _ := body["filter_vars"].(map[string]interface{})
+	if path != "/v1/actions/process-check-result" || user != "cmonit" || body["exit_status"] != float64(nagiosCritical) || vars["s"] != `nginx "main"` {
+		t.Errorf("got %s %s %v", path, user, body)
+	}
+
+	status = http.StatusNotFound // Unknown service: dropped
+	if err := s.Send(testEvent()); err != nil {
+		t.Errorf("error on 404: %v", err)
+	}
+	status = http.StatusServiceUnavailable
+	if err := s.Send(testEvent()); err == nil {
+		t.Error("no error on 503")
+	}
+
+	for _, api := range []string{"nsca", NagiosNRDP} { // NRDP without token
+		if _, err := NewNagiosSink(api, server.URL, "", "", ""); err == nil {
+			t.Errorf("no error for %s", api)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package forward
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// natsClient publishes messages to a NATS server, with its text protocol:
+// no subscriptions, each message confirmed by a PING/PONG round trip (the
+// server reports the errors before the PONG).
+type natsClient struct {
+	address  string // host:port
+	host     string // For the TLS certificate
+	user     string // Or the token, without password
+	password string
+	version  string
+
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// natsInfo is the part of the INFO of the server used by the client.
+type natsInfo struct {
+	TLSRequired bool `json:"tls_required"`
+}
+
+// publish sends a message. A connection the server closed is opened
+// again once.
+func (c *natsClient) publish(subject string, payload []byte) error {
+	reused := c.conn != nil
+	err := c.send(subject, payload)
+	if err != nil && reused {
+		err = c.send(subject, payload)
+	}
+	return err
+}
+
+// send connects if needed, sends a message and waits for the PONG.
+func (c *natsClient) send(subject string, payload []byte) error {
+	if c.conn == nil {
+		if err := c.connect(); err != nil {
+			return err
+		}
+	}
+	msg := fmt.Sprintf("PUB %s %d\r\n%s\r\nPING\r\n", subject, len(payload), payload)
+	if err := c.write(msg); err != nil {
+		return err
+	}
+	return c.pong()
+}
+
+// connect opens the connection, upgraded to TLS when the server requires
+// it, and authenticates.
+func (c *natsClient) connect() error {
+	conn, err := net.DialTimeout("tcp", c.address, syslogTimeout)
+	if err != nil {
+		return err
+	}
+	c.conn, c.r = conn, bufio.NewReader(conn)
+
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+	infoJSON, ok := strings.CutPrefix(line, "INFO ")
+	var info natsInfo
+	if !ok || json.Unmarshal([]byte(infoJSON), &info) != nil {
+		c.close()
+		return fmt.Errorf("NATS server %s: unexpected greeting %q", c.address, line)
+	}
+	if info.TLSRequired {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: c.host})
+		tlsConn.SetDeadline(time.Now().Add(syslogTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			c.close()
+			return err
+		}
+		c.conn, c.r = tlsConn, bufio.NewReader(tlsConn)
+	}
+
+	options := map[string]interface{}{
+		"verbose":  false,
+		"pedantic": false,
+		"name":     "cmonit",
+		"lang":     "go",
+		"version":  c.version,
+	}
+	switch {
+	case c.user != "" && c.password != "":
+		options["user"], options["pass"] = c.user, c.password
+	case c.user != "":
+		options["auth_token"] = c.user
+	}
+	connect, _ := json.Marshal(options)
+	if err := c.write("CONNECT " + string(connect) + "\r\nPING\r\n"); err != nil {
+		return err
+	}
+	return c.pong()
+}
+
+// pong reads the lines of the server until the PONG, answering its PINGs;
+// an -ERR fails.
+func (c *natsClient) pong() error {
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return err
+		}
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			if err := c.write("PONG\r\n"); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			c.close()
+			return fmt.Errorf("NATS server %s: %s", c.address, strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+	}
+}
+
+// write sends protocol text, closing the connection on failure.
+func (c *natsClient) write(s string) error {
+	c.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+	if _, err := c.conn.Write([]byte(s)); err != nil {
+		c.close()
+		return err
+	}
+	return nil
+}
+
+// readLine reads a line of the server, closing the connection on failure.
+func (c *natsClient) readLine() (string, error) {
+	c.conn.SetReadDeadline(time.Now().Add(syslogTimeout))
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		c.close()
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// close closes the connection, opened again by the next message.
+func (c *natsClient) close() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
@@ -0,0 +1,189 @@
+package forward
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ocochard/cmonit/internal/db"
+)
+
+// OTLP protocols.
+const (
+	OTLPHTTP = "http" // OTLP/HTTP, binary protobuf
+	OTLPGRPC = "grpc" // OTLP/gRPC, over HTTP/2 (h2c for http:// endpoints)
+)
+
+// otlpGRPCMethod is the path of the gRPC method exporting metrics.
+const otlpGRPCMethod = "/opentelemetry.proto.collector.metrics.v1.MetricsService/Export"
+
+// otlpRetryableCodes are the gRPC status codes the OTLP specification
+// retries: CANCELLED, DEADLINE_EXCEEDED, RESOURCE_EXHAUSTED, ABORTED,
+// OUT_OF_RANGE, UNAVAILABLE and DATA_LOSS.
+var otlpRetryableCodes = map[string]bool{"1": true, "4": true, "8": true, "10": true, "11": true, "14": true, "15": true}
+
+// OTLPSink exports the metrics to an OpenTelemetry collector, as gauges:
+// a resource per host (host.name, host.id), metrics named
+// monit.<type>.<name> (monit.cpu.user) with the service in the
+// monit.service attribute of their data points.
+type OTLPSink struct {
+	url      string // Of the export method
+	protocol string
+	headers  map[string]string // E.g. Authorization
+	version  string            // cmonit version, in the scope
+	client   *http.Client
+}
+
+// NewOTLPSink returns a sink exporting to the collector at endpoint, an
+// http(s):// URL (port 4318 for OTLP/HTTP, 4317 for OTLP/gRPC), with
+// protocol, http or grpc, and headers; version is the cmonit version.
+func NewOTLPSink(endpoint, protocol string, headers map[string]string, version string) (*OTLPSink, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q: must be an http(s):// URL", endpoint)
+	}
+	s := &OTLPSink{protocol: protocol, headers: headers, version: version}
+	base := strings.TrimSuffix(endpoint, "/")
+	switch protocol {
+	case "", OTLPHTTP:
+		s.protocol = OTLPHTTP
+		s.url = base + "/v1/metrics"
+		s.client = &http.Client{Timeout: siemTimeout}
+	case OTLPGRPC:
+		s.url = u.Scheme + "://" + u.Host + otlpGRPCMethod
+		protocols := new(http.Protocols)
+		protocols.SetHTTP2(true)
+		protocols.SetUnencryptedHTTP2(true)
+		s.client = &http.Client{Timeout: siemTimeout, Transport: &http.Transport{Protocols: protocols}}
+	default:
+		return nil, fmt.Errorf("invalid OTLP protocol %q (valid: http, grpc)", protocol)
+	}
+	return s, nil
+}
+
+// Name returns the protocol and the URL.
+func (s *OTLPSink) Name() string {
+	return "OTLP/" + strings.ToUpper(s.protocol) + " " + s.url
+}
+
+// SendMetrics exports a batch.
+func (s *OTLPSink) SendMetrics(metrics []db.StoredMetric) error {
+	msg := s.exportRequest(metrics)
+	if s.protocol == OTLPHTTP {
+		req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(msg))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/x-protobuf")
+		s.setHeaders(req)
+		return sendMetricRequest(s.client, req, len(metrics))
+	}
+
+	// Length-prefixed message, not compressed
+	body := binary.BigEndian.AppendUint32([]byte{0}, uint32(len(msg)))
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(append(body, msg...)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	s.setHeaders(req)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body) // For the trailers
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %s", s.url, resp.Status)
+	}
+
+	// The status is in the trailers, or in the headers without a response
+	code, message := resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+	if code == "" {
+		code, message = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	}
+	switch {
+	case code == "0":
+		return nil
+	case code == "" || otlpRetryableCodes[code]:
+		return fmt.Errorf("%s answered gRPC status %q %s", s.url, code, message)
+	}
+	log.Printf("[WARN] %s rejected %d data points: gRPC status %s %s", s.url, len(metrics), code, message)
+	return nil
+}
+
+// setHeaders sets the User-Agent and the configured headers.
+func (s *OTLPSink) setHeaders(req *http.Request) {
+	req.Header.Set("User-Agent", "cmonit/"+s.version)
+	for name, value := range s.headers {
+		req.Header.Set(name, value)
+	}
+}
+
+// otlpKeyValue encodes a KeyValue with a string value.
+func otlpKeyValue(key, value string) []byte {
+	return pbBytesField(pbString(nil, 1, key), 2, pbString(nil, 1, value))
+}
+
+// exportRequest encodes the metrics as an ExportMetricsServiceRequest
+// (opentelemetry/proto/metrics/v1), hosts and metrics in the order of
+// their first data point:
+//
+//	ExportMetricsServiceRequest { repeated ResourceMetrics resource_metrics = 1; }
+//	ResourceMetrics  { Resource resource = 1; repeated ScopeMetrics scope_metrics = 2; }
+//	Resource         { repeated KeyValue attributes = 1; }
+//	ScopeMetrics     { InstrumentationScope scope = 1; repeated Metric metrics = 2; }
+//	Metric           { string name = 1; Gauge gauge = 5; }
+//	Gauge            { repeated NumberDataPoint data_points = 1; }
+//	NumberDataPoint  { repeated KeyValue attributes = 7; fixed64 time_unix_nano = 3; double as_double = 4; }
+func (s *OTLPSink) exportRequest(metrics []db.StoredMetric) []byte {
+	type host struct {
+		hostname string
+		metrics  map[string][]byte // Data points, by metric name
+		names    []string
+	}
+	var hostIDs []string
+	hosts := make(map[string]*host)
+	for _, m := range metrics {
+		h, ok := hosts[m.HostID]
+		if !ok {
+			h = &host{hostname: m.Hostname, metrics: make(map[string][]byte)}
+			hosts[m.HostID] = h
+			hostIDs = append(hostIDs, m.HostID)
+		}
+		name := "monit." + m.Type + "." + m.Name
+		if _, ok := h.metrics[name]; !ok {
+			h.names = append(h.names, name)
+		}
+		point := pbBytesField(nil, 7, otlpKeyValue("monit.service", m.Service))
+		point = pbFixed64Field(point, 3, uint64(m.CollectedAt.UnixNano()))
+		point = pbDouble(point, 4, m.Value)
+		h.metrics[name] = pbBytesField(h.metrics[name], 1, point)
+	}
+
+	scope := pbString(pbString(nil, 1, "cmonit"), 2, s.version)
+	var b []byte
+	for _, id := range hostIDs {
+		h := hosts[id]
+		resource := pbBytesField(nil, 1, otlpKeyValue("host.name", h.hostname))
+		resource = pbBytesField(resource, 1, otlpKeyValue("host.id", id))
+
+		scopeMetrics := pbBytesField(nil, 1, scope)
+		for _, name := range h.names {
+			metric := pbString(nil, 1, name)
+			metric = pbBytesField(metric, 5, h.metrics[name])
+			scopeMetrics = pbBytesField(scopeMetrics, 2, metric)
+		}
+
+		rm := pbBytesField(nil, 1, resource)
+		rm = pbBytesField(rm, 2, scopeMetrics)
+		b = pbBytesField(b, 1, rm)
+	}
+	return b
+}
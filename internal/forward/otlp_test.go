@@ -0,0 +1,145 @@
+package forward
+
+import (
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ocochard/cmonit/internal/db"
+)
+
+// pbMessages returns the length-delimited fields of a message by field
+// number, skipping the others.
+func pbMessages(t *testing.T, b []byte) map[int][][]byte {
+	t.Helper()
+	fields := make(map[int][][]byte)
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		b = b[n:]
+		switch key & 7 {
+		case pbVarint:
+			_, n = binary.Uvarint(b)
+			b = b[n:]
+		case pbFixed64:
+			b = b[8:]
+		case pbBytes:
+			l, n := binary.Uvarint(b)
+			fields[int(key>>3)] = append(fields[int(key>>3)], b[n:n+int(l)])
+			b = b[n+int(l):]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+	}
+	return fields
+}
+
+func otlpMetrics() []db.StoredMetric {
+	at := time.Date(2026, 10, 14, 9, 12, 5, 0, time.UTC)
+	return []db.StoredMetric{
+		{HostID: "h1", Hostname: "web1", Service: "web1", Type: "cpu", Name: "user", Value: 12.5, CollectedAt: at},
+		{HostID: "h2", Hostname: "db1", Service: "postgres", Type: "process-cpu", Name: "percent", Value: 3, CollectedAt: at},
+		{HostID: "h1", Hostname: "web1", Service: "web1", Type: "cpu", Name: "user", Value: 14, CollectedAt: at.Add(time.Minute)},
+	}
+}
+
+func TestOTLPExportRequest(t *testing.T) {
+	s, err := NewOTLPSink("http://collector:4318", "", nil, "2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resources := pbMessages(t, s.exportRequest(otlpMetrics()))[1]
+	if len(resources) != 2 {
+		t.Fatalf("%d resources, want a resource per host", len(resources))
+	}
+	rm := pbMessages(t, resources[0])
+	attributes := pbMessages(t, rm[1][0])[1]
+	if string(attributes[0]) != string(otlpKeyValue("host.name", "web1")) || string(attributes[1]) != string(otlpKeyValue("host.id", "h1")) {
+		t.Errorf("resource attributes %q", attributes)
+	}
+	sm := pbMessages(t, rm[2][0])
+	if scope := pbMessages(t, sm[1][0]); string(scope[1][0]) != "cmonit" || string(scope[2][0]) != "2.1" {
+		t.Errorf("scope %q", scope)
+	}
+	metric := pbMessages(t, sm[2][0])
+	if len(sm[2]) != 1 || string(metric[1][0]) != "monit.cpu.user" {
+		t.Fatalf("metrics %q", sm[2])
+	}
+	points := pbMessages(t, metric[5][0])[1]
+	if len(points) != 2 || string(pbMessages(t, points[0])[7][0]) != string(otlpKeyValue("monit.service", "web1")) {
+		t.Errorf("data points %q", points)
+	}
+
+	for _, tt := range []struct{ endpoint, protocol string }{{"collector:4317", "grpc"}, {"http://collector:4317", "thrift"}} {
+		if _, err := NewOTLPSink(tt.endpoint, tt.protocol, nil, "2.1"); err == nil {
+			t.Errorf("no error for %s %s", tt.endpoint, tt.protocol)
+		}
+	}
+}
+
+func TestOTLPSinkHTTP(t *testing.T) {
+	var path, contentType, auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		path, contentType, auth = r.URL.Path, r.Header.Get("Content-Type"), r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+
+	s, err := NewOTLPSink(server.URL+"/otlp/", OTLPHTTP, map[string]string{"Authorization": "Bearer secret"}, "2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SendMetrics(otlpMetrics()); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/otlp/v1/metrics" || contentType != "application/x-protobuf" || auth != "Bearer secret" {
+		t.Errorf("got %s %s %q", path, contentType, auth)
+	}
+}
+
+func TestOTLPSinkGRPC(t *testing.T) {
+	status := "0"
+	var path string
+	var message []byte
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 || r.Header.Get("Content-Type") != "application/grpc" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		path, message = r.URL.Path, body
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Write([]byte{0, 0, 0, 0, 0}) // Empty ExportMetricsServiceResponse
+		w.Header().Set("Grpc-Status", status)
+	}))
+	server.Config.Protocols = new(http.Protocols)
+	server.Config.Protocols.SetHTTP1(true)
+	server.Config.Protocols.SetUnencryptedHTTP2(true)
+	server.Start()
+	defer server.Close()
+
+	s, err := NewOTLPSink(server.URL, OTLPGRPC, nil, "2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := otlpMetrics()
+	if err := s.SendMetrics(metrics); err != nil {
+		t.Fatal(err)
+	}
+	want := s.exportRequest(metrics)
+	if path != otlpGRPCMethod || len(message) != 5+len(want) || message[0] != 0 || binary.BigEndian.Uint32(message[1:]) != uint32(len(want)) {
+		t.Errorf("got %s, message of %d bytes", path, len(message))
+	}
+
+	status = "14" // UNAVAILABLE: retried
+	if err := s.SendMetrics(metrics); err == nil {
+		t.Error("no error for UNAVAILABLE")
+	}
+	status = "3" // INVALID_ARGUMENT: dropped
+	if err := s.SendMetrics(metrics); err != nil {
+		t.Errorf("error for INVALID_ARGUMENT: %v", err)
+	}
+}
@@ -0,0 +1,46 @@
+package forward
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// Protocol Buffers wire types.
+const (
+	pbVarint  = 0
+	pbFixed64 = 1
+	pbBytes   = 2
+)
+
+// pbTag appends the key of field number with wire type.
+func pbTag(b []byte, field, wireType int) []byte {
+	return binary.AppendUvarint(b, uint64(field)<<3|uint64(wireType))
+}
+
+// pbVarintField appends an integer field.
+func pbVarintField(b []byte, field int, v uint64) []byte {
+	return binary.AppendUvarint(pbTag(b, field, pbVarint), v)
+}
+
+// pbFixed64Field appends a fixed64 field.
+func pbFixed64Field(b []byte, field int, v uint64) []byte {
+	return binary.LittleEndian.AppendUint64(pbTag(b, field, pbFixed64), v)
+}
+
+// pbDouble appends a double field.
+func pbDouble(b []byte, field int, v float64) []byte {
+	return binary.LittleEndian.AppendUint64(pbTag(b, field, pbFixed64), math.Float64bits(v))
+}
+
+// pbBytesField appends a length-delimited field: a string, or an embedded
+// message encoded in data.
+func pbBytesField(b []byte, field int, data []byte) []byte {
+	b = binary.AppendUvarint(pbTag(b, field, pbBytes), uint64(len(data)))
+	return append(b, data...)
+}
+
+// pbString appends a string field.
+func pbString(b []byte, field int, s string) []byte {
+	b = binary.AppendUvarint(pbTag(b, field, pbBytes), uint64(len(s)))
+	return append(b, s...)
+}
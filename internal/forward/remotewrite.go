@@ -0,0 +1,131 @@
+package forward
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ocochard/cmonit/internal/db"
+)
+
+// PrometheusName returns the Prometheus metric name of a stored metric,
+// cmonit_<type>_<name>, its parts reduced to letters, digits and
+// underscores: cmonit_cpu_user, cmonit_process_cpu_percent...
+func PrometheusName(metricType, metricName string) string {
+	clean := func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}
+	return "cmonit_" + strings.Map(clean, metricType) + "_" + strings.Map(clean, metricName)
+}
+
+// RemoteWriteSink sends the metrics to a Prometheus remote write endpoint
+// (Mimir, VictoriaMetrics, Thanos receive...): a snappy-compressed
+// protobuf WriteRequest per batch (remote write 1.0), with the labels of
+// the /metrics exporter.
+type RemoteWriteSink struct {
+	url      string
+	user     string
+	password string
+	token    string            // Bearer token, instead of user and password
+	headers  map[string]string // E.g. X-Scope-OrgID for a Mimir tenant
+	version  string            // cmonit version, in the User-Agent
+	client   *http.Client
+}
+
+// NewRemoteWriteSink returns a sink writing to the http(s) URL rawURL,
+// authenticated with user and password (HTTP Basic Auth) or token, and
+// sending headers with each request; version is the cmonit version.
+func NewRemoteWriteSink(rawURL, user, password, token string, headers map[string]string, version string) (*RemoteWriteSink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid remote write URL %q: must be an http(s):// URL", rawURL)
+	}
+	if token != "" && user != "" {
+		return nil, fmt.Errorf("remote write: set either a token or a user, not both")
+	}
+	return &RemoteWriteSink{
+		url: rawURL, user: user, password: password, token: token, headers: headers, version: version,
+		client: &http.Client{Timeout: siemTimeout},
+	}, nil
+}
+
+// Name returns the URL.
+func (s *RemoteWriteSink) Name() string {
+	return "remote_write " + s.url
+}
+
+// SendMetrics writes a batch.
+func (s *RemoteWriteSink) SendMetrics(metrics []db.StoredMetric) error {
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(snappyEncode(writeRequest(metrics))))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	req.Header.Set("User-Agent", "cmonit/"+s.version)
+	for name, value := range s.headers {
+		req.Header.Set(name, value)
+	}
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	} else if s.user != "" {
+		req.SetBasicAuth(s.user, s.password)
+	}
+
+	return sendMetricRequest(s.client, req, len(metrics))
+}
+
+// writeRequest encodes the metrics as a prometheus.WriteRequest, a time
+// series per host, service and metric with its samples in order:
+//
+//	WriteRequest { repeated TimeSeries timeseries = 1; }
+//	TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+//	Label        { string name = 1; string value = 2; }
+//	Sample       { double value = 1; int64 timestamp = 2; } // Milliseconds
+func writeRequest(metrics []db.StoredMetric) []byte {
+	type series struct {
+		labels  [][2]string // Sorted by name, as the protocol requires
+		samples []byte
+	}
+	var order []string
+	bySeries := make(map[string]*series)
+	for _, m := range metrics {
+		key := m.HostID + "\x00" + m.Service + "\x00" + m.Type + "\x00" + m.Name
+		ts, ok := bySeries[key]
+		if !ok {
+			ts = &series{labels: [][2]string{
+				{"__name__", PrometheusName(m.Type, m.Name)},
+				{"host", m.Hostname},
+				{"host_id", m.HostID},
+				{"service", m.Service},
+			}}
+			bySeries[key] = ts
+			order = append(order, key)
+		}
+		var sample []byte
+		sample = pbDouble(sample, 1, m.Value)
+		sample = pbVarintField(sample, 2, uint64(m.CollectedAt.UnixMilli()))
+		ts.samples = pbBytesField(ts.samples, 2, sample)
+	}
+
+	var b, msg []byte
+	for _, key := range order {
+		ts := bySeries[key]
+		msg = msg[:0]
+		for _, l := range ts.labels {
+			var label []byte
+			label = pbString(label, 1, l[0])
+			label = pbString(label, 2, l[1])
+			msg = pbBytesField(msg, 1, label)
+		}
+		msg = append(msg, ts.samples...)
+		b = pbBytesField(b, 1, msg)
+	}
+	return b
+}
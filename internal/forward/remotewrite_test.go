@@ -0,0 +1,140 @@
+package forward
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ocochard/cmonit/internal/db"
+)
+
+// snappyDecode decodes the literals and 2-byte offset copies written by
+// snappyEncode.
+func snappyDecode(t *testing.T, src []byte) []byte {
+	t.Helper()
+	length, n := binary.Uvarint(src)
+	src = src[n:]
+	var dst []byte
+	for len(src) > 0 {
+		switch src[0] & 3 {
+		case 0:
+			l := int(src[0]>>2) + 1
+			src = src[1:]
+			if l > 60 {
+				size := l - 60
+				l = 0
+				for j := 0; j < size; j++ {
+					l |= int(src[j]) << (8 * j)
+				}
+				l++
+				src = src[size:]
+			}
+			dst = append(dst, src[:l]...)
+			src = src[l:]
+		case 2:
+			l := int(src[0]>>2) + 1
+			offset := int(src[1]) | int(src[2])<<8
+			for j := 0; j < l; j++ {
+				dst = append(dst, dst[len(dst)-offset])
+			}
+			src = src[3:]
+		default:
+			t.Fatalf("unexpected element %#x", src[0])
+		}
+	}
+	if uint64(len(dst)) != length {
+		t.Fatalf("decoded %d bytes, want %d", len(dst), length)
+	}
+	return dst
+}
+
+func TestSnappyEncode(t *testing.T) {
+	inputs := [][]byte{
+		nil,
+		[]byte("abc"),
+		[]byte(strings.Repeat("cmonit_cpu_user host web1 ", 200)),
+		bytes.Repeat([]byte{0}, 70000),
+	}
+	long := make([]byte, 100000) // Literals longer than 60 bytes
+	for i := range long {
+		long[i] = byte(i * 7919 >> 3)
+	}
+	inputs = append(inputs, long)
+
+	for _, in := range inputs {
+		out := snappyEncode(in)
+		if got := snappyDecode(t, out); !bytes.Equal(got, in) {
+			t.Errorf("round trip of %d bytes failed", len(in))
+		}
+	}
+	if n := len(snappyEncode(inputs[2])); n > len(inputs[2])/10 {
+		t.Errorf("repeated labels compressed to %d bytes out of %d", n, len(inputs[2]))
+	}
+}
+
+func TestRemoteWriteSink(t *testing.T) {
+	var body []byte
+	var header http.Header
+	status := http.StatusNoContent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		body, header = b, r.Header
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	if _, err := NewRemoteWriteSink("udp://mimir", "", "", "", nil, "2.1"); err == nil {
+		t.Error("no error for a udp:// URL")
+	}
+	s, err := NewRemoteWriteSink(server.URL+"/api/v1/push", "", "", "secret", map[string]string{"X-Scope-OrgID": "fleet"}, "2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	at := time.Date(2026, 10, 14, 9, 12, 5, 0, time.UTC)
+	metrics := []db.StoredMetric{
+		{ID: 1, HostID: "h1", Hostname: "web1", Service: "web1", Type: "cpu", Name: "user", Value: 12.5, CollectedAt: at},
+		{ID: 2, HostID: "h1", Hostname: "web1", Service: "nginx", Type: "process-cpu", Name: "percent", Value: 3, CollectedAt: at},
+		{ID: 3, HostID: "h1", Hostname: "web1", Service: "web1", Type: "cpu", Name: "user", Value: 14, CollectedAt: at.Add(time.Minute)},
+	}
+	if err := s.SendMetrics(metrics); err != nil {
+		t.Fatal(err)
+	}
+	if header.Get("Content-Encoding") != "snappy" || header.Get("Authorization") != "Bearer secret" || header.Get("X-Scope-OrgID") != "fleet" {
+		t.Errorf("headers %v", header)
+	}
+	if got, want := snappyDecode(t, body), writeRequest(metrics); !bytes.Equal(got, want) {
+		t.Errorf("body does not decode to the WriteRequest")
+	}
+
+	// Two series, the CPU one first with its two samples
+	label := func(name, value string) []byte {
+		return pbBytesField(nil, 1, pbString(pbString(nil, 1, name), 2, value))
+	}
+	sample := func(v float64, at time.Time) []byte {
+		return pbBytesField(nil, 2, pbVarintField(pbDouble(nil, 1, v), 2, uint64(at.UnixMilli())))
+	}
+	var cpu []byte
+	for _, l := range [][2]string{{"__name__", "cmonit_cpu_user"}, {"host", "web1"}, {"host_id", "h1"}, {"service", "web1"}} {
+		cpu = append(cpu, label(l[0], l[1])...)
+	}
+	cpu = append(append(cpu, sample(12.5, at)...), sample(14, at.Add(time.Minute))...)
+	want := pbBytesField(nil, 1, cpu)
+	if got := writeRequest(metrics); !bytes.HasPrefix(got, want) || !bytes.Contains(got, []byte("cmonit_process_cpu_percent")) {
+		t.Errorf("unexpected WriteRequest %x", got)
+	}
+
+	status = http.StatusBadRequest // Dropped
+	if err := s.SendMetrics(metrics); err != nil {
+		t.Errorf("error on 400: %v", err)
+	}
+	status = http.StatusServiceUnavailable // Retried
+	if err := s.SendMetrics(metrics); err == nil {
+		t.Error("no error on 503")
+	}
+}
@@ -0,0 +1,250 @@
+package forward
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ocochard/cmonit/internal/db"
+)
+
+// SIEM formats.
+const (
+	FormatCEF  = "cef"  // ArcSight Common Event Format
+	FormatLEEF = "leef" // QRadar Log Event Extended Format
+)
+
+// siemTimeout bounds an HTTPS request to a SIEM collector.
+const siemTimeout = 10 * time.Second
+
+// SIEMFields are the event fields the SIEM attributes can be mapped to;
+// a value starting with "=" is a literal instead, e.g. a CEF label.
+var SIEMFields = []string{"id", "host_id", "hostname", "service", "event_type", "state", "action", "message", "severity", "severity_level", "created_at"}
+
+// siemAttribute is an attribute of the CEF extension or of the LEEF
+// attributes, and the event field (or literal) it holds.
+type siemAttribute struct {
+	key, field string
+}
+
+// defaultSIEMAttributes are the attributes without [event_siem.fields].
+var defaultSIEMAttributes = map[string][]siemAttribute{
+	FormatCEF: {
+		{"rt", "created_at"},
+		{"externalId", "id"},
+		{"dvchost", "hostname"},
+		{"cs1", "service"},
+		{"cs1Label", "=Service"},
+		{"cs2", "host_id"},
+		{"cs2Label", "=Host ID"},
+		{"msg", "message"},
+	},
+	FormatLEEF: {
+		{"devTime", "created_at"},
+		{"devTimeFormat", "=MMM dd yyyy HH:mm:ss.SSS z"},
+		{"sev", "severity_level"},
+		{"cat", "severity"},
+		{"resource", "hostname"},
+		{"service", "service"},
+		{"hostId", "host_id"},
+		{"msg", "message"},
+	},
+}
+
+// siemSeverities are the CEF and LEEF severities (0-10) of the event
+// severities.
+var siemSeverities = map[string]int{
+	db.SeverityInfo:     3,
+	db.SeverityWarning:  6,
+	db.SeverityCritical: 9,
+}
+
+// SIEMSink ships the events in the CEF or LEEF format to a SIEM
+// collector, over syslog (UDP or TCP, newline framed) or HTTPS (one POST
+// per event).
+type SIEMSink struct {
+	format     string
+	version    string // cmonit version, in the headers
+	attributes []siemAttribute
+
+	syslog *SyslogSink // Syslog target
+
+	url    string // HTTP(S) target
+	token  string // Bearer token of the HTTPS requests
+	client *http.Client
+}
+
+// NewSIEMSink returns a sink shipping the events in format to target,
+// udp://host[:port], tcp://host[:port] or an https:// URL. fields maps the
+// attributes to the names of SIEMFields (or "=literal"), replacing the
+// default ones; version is the cmonit version of the headers.
+func NewSIEMSink(format, target, facility, token string, fields map[string]string, version string) (*SIEMSink, error) {
+	s := &SIEMSink{format: strings.ToLower(format), version: version, token: token}
+	if _, ok := defaultSIEMAttributes[s.format]; !ok {
+		return nil, fmt.Errorf("invalid SIEM format %q (valid: cef, leef)", format)
+	}
+
+	s.attributes = defaultSIEMAttributes[s.format]
+	if len(fields) > 0 {
+		s.attributes = nil
+		keys := make([]string, 0, len(fields))
+		for key := range fields {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			field := fields[key]
+			if !strings.HasPrefix(field, "=") && !slices.Contains(SIEMFields, field) {
+				return nil, fmt.Errorf("SIEM field %s: unknown event field %q (valid: %s, or =text)", key, field, strings.Join(SIEMFields, ", "))
+			}
+			if key == "" || strings.ContainsAny(key, "= \t|") {
+				return nil, fmt.Errorf("invalid SIEM attribute name %q", key)
+			}
+			s.attributes = append(s.attributes, siemAttribute{key, field})
+		}
+	}
+
+	u, err := url.Parse(target)
+	switch {
+	case err != nil:
+		return nil, fmt.Errorf("invalid SIEM target %q: %w", target, err)
+	case u.Scheme == "https" || u.Scheme == "http":
+		s.url = target
+		s.client = &http.Client{Timeout: siemTimeout}
+	case u.Scheme == "udp" || u.Scheme == "tcp":
+		if s.syslog, err = NewSyslogSink(target, facility, ""); err != nil {
+			return nil, err
+		}
+		s.syslog.text = s.record
+	default:
+		return nil, fmt.Errorf("invalid SIEM target %q: must be udp://host[:port], tcp://host[:port] or an https:// URL", target)
+	}
+	return s, nil
+}
+
+// Name returns the format and the target.
+func (s *SIEMSink) Name() string {
+	if s.syslog != nil {
+		return strings.ToUpper(s.format) + " " + s.syslog.target
+	}
+	return strings.ToUpper(s.format) + " " + s.url
+}
+
+// Send ships an event.
+func (s *SIEMSink) Send(e db.StoredEvent) error {
+	if s.syslog != nil {
+		return s.syslog.Send(e)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewBufferString(s.record(e)+"\n"))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s answered %s", s.url, resp.Status)
+	}
+	return nil
+}
+
+// record formats an event as a CEF or LEEF record.
+func (s *SIEMSink) record(e db.StoredEvent) string {
+	if s.format == FormatLEEF {
+		return s.formatLEEF(e)
+	}
+	return s.formatCEF(e)
+}
+
+// formatCEF formats an event as a CEF record:
+// "CEF:0|cmonit|cmonit|version|event type|message|severity|key=value ...".
+func (s *SIEMSink) formatCEF(e db.StoredEvent) string {
+	header := strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ")
+	value := strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "CEF:0|cmonit|cmonit|%s|%d|%s|%d|", header.Replace(s.version), e.EventType,
+		header.Replace(e.Message), siemSeverity(e))
+	for i, a := range s.attributes {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(a.key + "=" + value.Replace(s.fieldValue(e, a.field)))
+	}
+	return b.String()
+}
+
+// formatLEEF formats an event as a LEEF 1.0 record, its attributes
+// separated by tabs: "LEEF:1.0|cmonit|cmonit|version|event type|key=value...".
+func (s *SIEMSink) formatLEEF(e db.StoredEvent) string {
+	clean := strings.NewReplacer("|", " ", "\t", " ", "\n", " ", "\r", " ")
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "LEEF:1.0|cmonit|cmonit|%s|%d|", clean.Replace(s.version), e.EventType)
+	for i, a := range s.attributes {
+		if i > 0 {
+			b.WriteByte('\t')
+		}
+		b.WriteString(a.key + "=" + clean.Replace(s.fieldValue(e, a.field)))
+	}
+	return b.String()
+}
+
+// fieldValue returns the text of an event field, or the literal after "=".
+// Timestamps are milliseconds since the epoch in CEF, and match the
+// default devTimeFormat in LEEF; severity_level is the severity of the
+// headers (0-10).
+func (s *SIEMSink) fieldValue(e db.StoredEvent, field string) string {
+	if literal, ok := strings.CutPrefix(field, "="); ok {
+		return literal
+	}
+	switch field {
+	case "id":
+		return strconv.FormatInt(e.ID, 10)
+	case "host_id":
+		return e.HostID
+	case "hostname":
+		return e.Hostname
+	case "service":
+		return e.Service
+	case "event_type":
+		return strconv.Itoa(e.EventType)
+	case "state":
+		return strconv.Itoa(e.State)
+	case "action":
+		return strconv.Itoa(e.Action)
+	case "message":
+		return e.Message
+	case "severity":
+		return e.Severity
+	case "severity_level":
+		return strconv.Itoa(siemSeverity(e))
+	case "created_at":
+		if s.format == FormatLEEF {
+			return e.CreatedAt.UTC().Format("Jan 02 2006 15:04:05.000 MST")
+		}
+		return strconv.FormatInt(e.CreatedAt.UnixMilli(), 10)
+	}
+	return ""
+}
+
+// siemSeverity returns the CEF or LEEF severity of an event.
+func siemSeverity(e db.StoredEvent) int {
+	if severity, ok := siemSeverities[e.Severity]; ok {
+		return severity
+	}
+	return siemSeverities[db.SeverityWarning]
+}
@@ -0,0 +1,82 @@
+package forward
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewSIEMSink(t *testing.T) {
+	tests := []struct {
+		format, target string
+		fields         map[string]string
+		ok             bool
+	}{
+		{"cef", "udp://siem.example.com", nil, true},
+		{"LEEF", "https://siem.example.com/events", nil, true},
+		{"cef", "tcp://siem.example.com:514", map[string]string{"dhost": "hostname", "cs1Label": "=Service"}, true},
+		{"json", "udp://siem.example.com", nil, false},
+		{"cef", "local", nil, false},
+		{"cef", "udp://siem.example.com", map[string]string{"dhost": "host"}, false},
+		{"cef", "udp://siem.example.com", map[string]string{"bad key": "hostname"}, false},
+	}
+	for _, tt := range tests {
+		_, err := NewSIEMSink(tt.format, tt.target, "", "", tt.fields, "1.0")
+		if (err == nil) != tt.ok {
+			t.Errorf("%s %s %v: error %v", tt.format, tt.target, tt.fields, err)
+		}
+	}
+}
+
+func TestSIEMFormats(t *testing.T) {
+	e := testEvent()
+	e.Message = "failed | a=b\nline"
+
+	s, err := NewSIEMSink("cef", "udp://localhost", "", "", map[string]string{"msg": "message", "cs1": "service", "cs1Label": "=Service"}, "2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `CEF:0|cmonit|cmonit|2.1|32|failed \| a=b line|9|cs1=nginx "main" cs1Label=Service msg=failed | a\=b\nline`
+	if got := s.record(e); got != want {
+		t.Errorf("CEF:\n got %s\nwant %s", got, want)
+	}
+
+	s, err = NewSIEMSink("leef", "udp://localhost", "", "", map[string]string{"msg": "message", "devTime": "created_at", "sev": "severity_level"}, "2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = "LEEF:1.0|cmonit|cmonit|2.1|32|devTime=Oct 14 2026 09:12:05.000 UTC\tmsg=failed   a=b line\tsev=9"
+	if got := s.record(e); got != want {
+		t.Errorf("LEEF:\n got %q\nwant %q", got, want)
+	}
+}
+
+func TestSIEMSinkHTTPS(t *testing.T) {
+	var body, auth string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		body, auth = string(b), r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+
+	s, err := NewSIEMSink("cef", server.URL, "", "secret", nil, "2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.client = server.Client()
+	if err := s.Send(testEvent()); err != nil {
+		t.Fatal(err)
+	}
+	if auth != "Bearer secret" || !strings.HasPrefix(body, "CEF:0|cmonit|cmonit|2.1|32|") || !strings.Contains(body, " dvchost=web1 ") {
+		t.Errorf("got %q, body %q", auth, body)
+	}
+
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	if err := s.Send(testEvent()); err == nil {
+		t.Error("no error on 503")
+	}
+}
@@ -0,0 +1,71 @@
+package forward
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// snappyEncode compresses src in the Snappy block format, as the
+// Prometheus remote write protocol requires: a greedy LZ77 matching 4-byte
+// sequences within 64 KiB, enough for the repetitive label sets of the
+// metric batches.
+func snappyEncode(src []byte) []byte {
+	const (
+		minMatch  = 4
+		maxOffset = 1 << 16
+		tableBits = 14
+	)
+	dst := binary.AppendUvarint(make([]byte, 0, len(src)/2+16), uint64(len(src)))
+
+	var table [1 << tableBits]int32 // Position + 1 of the last sequence of each hash
+	literal := 0
+	for i := 0; i+minMatch <= len(src); {
+		seq := binary.LittleEndian.Uint32(src[i:])
+		h := (seq * 0x1e35a7bd) >> (32 - tableBits)
+		candidate := int(table[h]) - 1
+		table[h] = int32(i + 1)
+		if candidate < 0 || i-candidate >= maxOffset || binary.LittleEndian.Uint32(src[candidate:]) != seq {
+			i++
+			continue
+		}
+
+		length := minMatch
+		for i+length < len(src) && src[candidate+length] == src[i+length] {
+			length++
+		}
+		dst = snappyLiteral(dst, src[literal:i])
+		dst = snappyCopy(dst, i-candidate, length)
+		i += length
+		literal = i
+	}
+	return snappyLiteral(dst, src[literal:])
+}
+
+// snappyLiteral appends a literal element.
+func snappyLiteral(dst, lit []byte) []byte {
+	if len(lit) == 0 {
+		return dst
+	}
+	n := uint32(len(lit) - 1)
+	if n < 60 {
+		dst = append(dst, byte(n<<2))
+	} else {
+		size := (bits.Len32(n) + 7) / 8 // Bytes of the length, 1 to 4
+		dst = append(dst, byte((59+size)<<2))
+		for j := 0; j < size; j++ {
+			dst = append(dst, byte(n>>(8*j)))
+		}
+	}
+	return append(dst, lit...)
+}
+
+// snappyCopy appends copy elements with a 2-byte offset, of at most 64
+// bytes each.
+func snappyCopy(dst []byte, offset, length int) []byte {
+	for length > 0 {
+		n := min(length, 64)
+		dst = append(dst, byte((n-1)<<2|2), byte(offset), byte(offset>>8))
+		length -= n
+	}
+	return dst
+}
@@ -0,0 +1,429 @@
+package forward
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ocochard/cmonit/internal/db"
+)
+
+// OIDs of the traps, see docs/CMONIT-MIB.txt: the cmonit MIB is under the
+// example enterprise number of RFC 5612, as the syslog structured data.
+var (
+	oidSysUpTime   = []int{1, 3, 6, 1, 2, 1, 1, 3, 0}
+	oidSNMPTrapOID = []int{1, 3, 6, 1, 6, 3, 1, 1, 4, 1, 0}
+
+	oidCmonitEventTrap       = cmonitOID(0, 1)
+	oidCmonitHostOfflineTrap = cmonitOID(0, 2)
+	oidCmonitHostOnlineTrap  = cmonitOID(0, 3)
+
+	oidCmonitHostName      = cmonitOID(1, 1, 0)
+	oidCmonitHostID        = cmonitOID(1, 2, 0)
+	oidCmonitServiceName   = cmonitOID(1, 3, 0)
+	oidCmonitEventID       = cmonitOID(1, 4, 0)
+	oidCmonitEventType     = cmonitOID(1, 5, 0)
+	oidCmonitEventState    = cmonitOID(1, 6, 0)
+	oidCmonitEventSeverity = cmonitOID(1, 7, 0)
+	oidCmonitEventMessage  = cmonitOID(1, 8, 0)
+	oidCmonitHostLastSeen  = cmonitOID(1, 9, 0)
+)
+
+// cmonitOID returns an OID under cmonitMIB, 1.3.6.1.4.1.32473.1.
+func cmonitOID(sub ...int) []int {
+	return append([]int{1, 3, 6, 1, 4, 1, 32473, 1}, sub...)
+}
+
+// snmpSeverities are the cmonitEventSeverity values of the severities.
+var snmpSeverities = map[string]int64{
+	db.SeverityInfo:     1,
+	db.SeverityWarning:  2,
+	db.SeverityCritical: 3,
+}
+
+// BER tags.
+const (
+	berInteger   = 0x02
+	berOctets    = 0x04
+	berOID       = 0x06
+	berSequence  = 0x30
+	berUnsigned  = 0x42 // Unsigned32, Gauge32
+	berTimeTicks = 0x43
+	berTrapPDU   = 0xa7 // SNMPv2-Trap-PDU
+)
+
+// berTLV encodes a BER element.
+func berTLV(tag byte, content ...[]byte) []byte {
+	n := 0
+	for _, c := range content {
+		n += len(c)
+	}
+	b := []byte{tag}
+	switch {
+	case n < 0x80:
+		b = append(b, byte(n))
+	case n < 0x100:
+		b = append(b, 0x81, byte(n))
+	case n < 0x10000:
+		b = append(b, 0x82, byte(n>>8), byte(n))
+	default:
+		b = append(b, 0x83, byte(n>>16), byte(n>>8), byte(n))
+	}
+	for _, c := range content {
+		b = append(b, c...)
+	}
+	return b
+}
+
+// berInt encodes an integer of type tag, in the fewest bytes.
+func berInt(tag byte, v int64) []byte {
+	b := binary.BigEndian.AppendUint64(nil, uint64(v))
+	for len(b) > 1 && (b[0] == 0 && b[1] < 0x80 || b[0] == 0xff && b[1] >= 0x80) {
+		b = b[1:]
+	}
+	return berTLV(tag, b)
+}
+
+// berObjectID encodes an OID.
+func berObjectID(oid []int) []byte {
+	b := []byte{byte(40*oid[0] + oid[1])}
+	for _, n := range oid[2:] {
+		var sub []byte
+		for sub = []byte{byte(n & 0x7f)}; n >= 0x80; {
+			n >>= 7
+			sub = append([]byte{byte(n&0x7f | 0x80)}, sub...)
+		}
+		b = append(b, sub...)
+	}
+	return berTLV(berOID, b)
+}
+
+// varBind encodes a variable binding.
+func varBind(oid []int, value []byte) []byte {
+	return berTLV(berSequence, berObjectID(oid), value)
+}
+
+// snmpAuthProtocols are the USM authentication protocols: the hash and the
+// length of the authentication parameters.
+var snmpAuthProtocols = map[string]struct {
+	hash func() hash.Hash
+	size int
+}{
+	"md5":    {md5.New, 12},    // usmHMACMD5AuthProtocol
+	"sha":    {sha1.New, 12},   // usmHMACSHAAuthProtocol
+	"sha256": {sha256.New, 24}, // usmHMAC192SHA256AuthProtocol (RFC 7860)
+}
+
+// snmpPasswordKey derives the localized key of password for engineID
+// (RFC 3414, A.2): the hash of a megabyte of the repeated password,
+// localized as H(Ku | engineID | Ku).
+func snmpPasswordKey(newHash func() hash.Hash, password string, engineID []byte) []byte {
+	h := newHash()
+	repeated := []byte(strings.Repeat(password, 64/len(password)+2))
+	for n := 0; n < 1048576; n += 64 {
+		h.Write(repeated[n%len(password) : n%len(password)+64])
+	}
+	ku := h.Sum(nil)
+
+	h.Reset()
+	h.Write(ku)
+	h.Write(engineID)
+	h.Write(ku)
+	return h.Sum(nil)
+}
+
+// SNMPSink sends SNMP traps (v2c, or v3 with USM authentication and AES
+// privacy) to a trap receiver: cmonitEventTrap for the events, and
+// cmonitHostOfflineTrap and cmonitHostOnlineTrap when a host goes silent
+// and comes back (see CheckHosts).
+type SNMPSink struct {
+	target    string // As configured, for Name
+	address   string
+	version   string // "2c" or "3"
+	community string
+	start     time.Time // For sysUpTime
+
+	// SNMPv3
+	engineID []byte
+	user     string
+	auth     string // Protocol, "" for noAuthNoPriv
+	authKey  []byte
+	privKey  []byte // AES-128, nil for authNoPriv
+
+	mu        sync.Mutex
+	conn      net.Conn
+	requestID int32
+	offline   map[string]bool // Host ids, nil before the first CheckHosts
+}
+
+// SNMPConfig are the settings of an SNMPSink.
+type SNMPConfig struct {
+	Target    string // udp://host[:port], port 162 by default
+	Version   string // "2c" (default) or "3"
+	Community string // SNMPv2c, "public" by default
+
+	// SNMPv3 user, authentication (md5, sha or sha256) and privacy (aes)
+	User         string
+	AuthProtocol string
+	AuthPassword string
+	PrivProtocol string
+	PrivPassword string
+	EngineID     string // Hex, by default derived from the hostname
+}
+
+// NewSNMPSink returns a sink sending the traps as c says.
+func NewSNMPSink(c SNMPConfig) (*SNMPSink, error) {
+	u, err := url.Parse(c.Target)
+	if err != nil || u.Scheme != "udp" || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid SNMP target %q: must be udp://host[:port]", c.Target)
+	}
+	port := u.Port()
+	if port == "" {
+		port = "162"
+	}
+	s := &SNMPSink{target: c.Target, address: net.JoinHostPort(u.Hostname(), port), version: c.Version, community: c.Community, start: time.Now()}
+	if s.version == "" {
+		s.version = "2c"
+	}
+	if s.community == "" {
+		s.community = "public"
+	}
+
+	switch s.version {
+	case "2c":
+		return s, nil
+	case "3":
+	default:
+		return nil, fmt.Errorf("invalid SNMP version %q (valid: 2c, 3)", c.Version)
+	}
+
+	if c.User == "" {
+		return nil, fmt.Errorf("SNMPv3: user is required")
+	}
+	s.user = c.User
+	if c.EngineID != "" {
+		if s.engineID, err = hex.DecodeString(strings.TrimPrefix(c.EngineID, "0x")); err != nil || len(s.engineID) < 5 || len(s.engineID) > 32 {
+			return nil, fmt.Errorf("invalid SNMPv3 engine ID %q: must be 5 to 32 bytes in hex", c.EngineID)
+		}
+	} else {
+		// Enterprise 32473 with the high bit, then text (RFC 3411)
+		hostname, _ := os.Hostname()
+		text := "cmonit@" + hostname
+		if len(text) > 27 {
+			text = text[:27]
+		}
+		s.engineID = append([]byte{0x80, 0x00, 0x7e, 0xd9, 0x04}, text...)
+	}
+
+	if c.AuthPassword == "" {
+		if c.PrivPassword != "" {
+			return nil, fmt.Errorf("SNMPv3: privacy needs an auth_password")
+		}
+		return s, nil // noAuthNoPriv
+	}
+	s.auth = strings.ToLower(c.AuthProtocol)
+	if s.auth == "" {
+		s.auth = "sha"
+	}
+	auth, ok := snmpAuthProtocols[s.auth]
+	if !ok {
+		return nil, fmt.Errorf("invalid SNMPv3 auth protocol %q (valid: md5, sha, sha256)", c.AuthProtocol)
+	}
+	if len(c.AuthPassword) < 8 || c.PrivPassword != "" && len(c.PrivPassword) < 8 {
+		return nil, fmt.Errorf("SNMPv3: passwords must be at least 8 characters")
+	}
+	s.authKey = snmpPasswordKey(auth.hash, c.AuthPassword, s.engineID)
+
+	if c.PrivPassword != "" {
+		if p := strings.ToLower(c.PrivProtocol); p != "" && p != "aes" {
+			return nil, fmt.Errorf("invalid SNMPv3 privacy protocol %q (valid: aes)", c.PrivProtocol)
+		}
+		s.privKey = snmpPasswordKey(auth.hash, c.PrivPassword, s.engineID)[:16]
+	}
+	return s, nil
+}
+
+// Name returns the version and the target, with the engine ID for SNMPv3
+// (for the users of the trap receiver).
+func (s *SNMPSink) Name() string {
+	if s.version == "3" {
+		return fmt.Sprintf("SNMPv3 %s (engine ID %x)", s.target, s.engineID)
+	}
+	return "SNMPv2c " + s.target
+}
+
+// Send sends the cmonitEventTrap of an event.
+func (s *SNMPSink) Send(e db.StoredEvent) error {
+	severity, ok := snmpSeverities[e.Severity]
+	if !ok {
+		severity = snmpSeverities[db.SeverityWarning]
+	}
+	return s.trap(oidCmonitEventTrap,
+		varBind(oidCmonitEventID, berInt(berInteger, e.ID)),
+		varBind(oidCmonitHostName, berTLV(berOctets, []byte(e.Hostname))),
+		varBind(oidCmonitHostID, berTLV(berOctets, []byte(e.HostID))),
+		varBind(oidCmonitServiceName, berTLV(berOctets, []byte(e.Service))),
+		varBind(oidCmonitEventType, berInt(berInteger, int64(e.EventType))),
+		varBind(oidCmonitEventState, berInt(berInteger, int64(e.State))),
+		varBind(oidCmonitEventSeverity, berInt(berInteger, severity)),
+		varBind(oidCmonitEventMessage, berTLV(berOctets, []byte(e.Message))),
+	)
+}
+
+// CheckHosts sends a cmonitHostOfflineTrap for each host going offline
+// (see db.HostSeen.Offline) since the previous call, and a
+// cmonitHostOnlineTrap for each one reporting again. The first call only
+// records the hosts already offline. A trap failing is retried at the
+// next call.
+func (s *SNMPSink) CheckHosts(hosts []db.HostSeen, now time.Time) error {
+	first := s.offline == nil
+	if first {
+		s.offline = make(map[string]bool)
+	}
+	var firstErr error
+	for _, h := range hosts {
+		offline := h.Offline(now)
+		if offline == s.offline[h.ID] {
+			continue
+		}
+		if !first {
+			trap := oidCmonitHostOnlineTrap
+			if offline {
+				trap = oidCmonitHostOfflineTrap
+			}
+			err := s.trap(trap,
+				varBind(oidCmonitHostName, berTLV(berOctets, []byte(h.Hostname))),
+				varBind(oidCmonitHostID, berTLV(berOctets, []byte(h.ID))),
+				varBind(oidCmonitHostLastSeen, berInt(berUnsigned, h.LastSeen.Unix())),
+			)
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+		}
+		s.offline[h.ID] = offline
+	}
+	return firstErr
+}
+
+// trap sends a trap with its variable bindings.
+func (s *SNMPSink) trap(trapOID []int, binds ...[]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requestID++
+	uptime := int64(time.Since(s.start) / (10 * time.Millisecond))
+	list := [][]byte{
+		varBind(oidSysUpTime, berInt(berTimeTicks, uptime&0xffffffff)),
+		varBind(oidSNMPTrapOID, berObjectID(trapOID)),
+	}
+	pdu := berTLV(berTrapPDU,
+		berInt(berInteger, int64(s.requestID)),
+		berInt(berInteger, 0), // error-status
+		berInt(berInteger, 0), // error-index
+		berTLV(berSequence, append(list, binds...)...),
+	)
+
+	var msg []byte
+	if s.version == "3" {
+		var err error
+		if msg, err = s.messageV3(pdu); err != nil {
+			return err
+		}
+	} else {
+		msg = berTLV(berSequence, berInt(berInteger, 1), berTLV(berOctets, []byte(s.community)), pdu)
+	}
+
+	if s.conn == nil {
+		conn, err := net.DialTimeout("udp", s.address, syslogTimeout)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+	if _, err := s.conn.Write(msg); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+// messageV3 wraps a PDU in an SNMPv3 message (RFC 3412), authenticated
+// and encrypted with the USM (RFC 3414, RFC 3826) as configured. The
+// sender of a trap is the authoritative engine: its boots are 1 and its
+// time the uptime of the sink.
+func (s *SNMPSink) messageV3(pdu []byte) ([]byte, error) {
+	const boots = 1
+	engineTime := int64(time.Since(s.start) / time.Second)
+
+	flags := byte(0)
+	scoped := berTLV(berSequence, berTLV(berOctets, s.engineID), berTLV(berOctets, nil), pdu)
+	var authParams, privParams []byte
+	if s.authKey != nil {
+		flags |= 0x01
+		authParams = make([]byte, snmpAuthProtocols[s.auth].size)
+	}
+	if s.privKey != nil {
+		flags |= 0x02
+		privParams = make([]byte, 8) // Salt
+		if _, err := rand.Read(privParams); err != nil {
+			return nil, err
+		}
+		iv := binary.BigEndian.AppendUint32(nil, boots)
+		iv = binary.BigEndian.AppendUint32(iv, uint32(engineTime))
+		iv = append(iv, privParams...)
+		block, err := aes.NewCipher(s.privKey)
+		if err != nil {
+			return nil, err
+		}
+		encrypted := make([]byte, len(scoped))
+		cipher.NewCFBEncrypter(block, iv).XORKeyStream(encrypted, scoped)
+		scoped = berTLV(berOctets, encrypted)
+	}
+
+	message := func(authParams []byte) []byte {
+		security := berTLV(berSequence,
+			berTLV(berOctets, s.engineID),
+			berInt(berInteger, boots),
+			berInt(berInteger, engineTime),
+			berTLV(berOctets, []byte(s.user)),
+			berTLV(berOctets, authParams),
+			berTLV(berOctets, privParams),
+		)
+		header := berTLV(berSequence,
+			berInt(berInteger, int64(s.requestID)), // msgID
+			berInt(berInteger, 65507),              // msgMaxSize
+			berTLV(berOctets, []byte{flags}),
+			berInt(berInteger, 3), // msgSecurityModel: USM
+		)
+		return berTLV(berSequence, berInt(berInteger, 3), header, berTLV(berOctets, security), scoped)
+	}
+
+	msg := message(authParams)
+	if s.authKey != nil {
+		// The HMAC of the message with zeroed parameters, truncated, then
+		// in their place (same length, same layout)
+		mac := hmac.New(snmpAuthProtocols[s.auth].hash, s.authKey)
+		mac.Write(msg)
+		msg = message(mac.Sum(nil)[:len(authParams)])
+	}
+	return msg, nil
+}
@@ -0,0 +1,355 @@
+package forward
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ocochard/cmonit/internal/db"
+	"github.com/ocochard/cmonit/internal/parser"
+)
+
+// berElement is a decoded BER element.
+type berElement struct {
+	tag     byte
+	content []byte
+	raw     []byte // Whole element
+}
+
+// berElements decodes the consecutive BER elements of b.
+func berElements(t *testing.T, b []byte) []berElement {
+	t.Helper()
+	var elements []berElement
+	for len(b) > 0 {
+		tag, n, header := b[0], int(b[1]), 2
+		if n >= 0x80 {
+			size := n & 0x7f
+			n = 0
+			for _, c := range b[2 : 2+size] {
+				n = n<<8 | int(c)
+			}
+			header += size
+		}
+		if header+n > len(b) {
+			t.Fatalf("truncated BER element % x", b)
+		}
+		elements = append(elements, berElement{tag, b[header : header+n], b[:header+n]})
+		b = b[header+n:]
+	}
+	return elements
+}
+
+// berIntValue decodes the content of an integer.
+func berIntValue(content []byte) int64 {
+	v := int64(int8(content[0]))
+	for _, c := range content[1:] {
+		v = v<<8 | int64(c)
+	}
+	return v
+}
+
+func TestBEREncoding(t *testing.T) {
+	tests := []struct {
+		got  []byte
+		want string
+	}{
+		{berInt(berInteger, 0), "020100"},
+		{berInt(berInteger, 127), "02017f"},
+		{berInt(berInteger, 128), "02020080"},
+		{berInt(berInteger, -1), "0201ff"},
+		{berInt(berInteger, -129), "0202ff7f"},
+		{berInt(berTimeTicks, 0xffffffff), "430500ffffffff"},
+		{berObjectID(oidSysUpTime), "06082b06010201010300"},
+		{berObjectID(cmonitOID(0, 1)), "060b2b0601040181fd59010001"},
+		{berTLV(berOctets, make([]byte, 200))[:3], "0481c8"},
+	}
+	for _, tt := range tests {
+		if got := hex.EncodeToString(tt.got); got != tt.want {
+			t.Errorf("got %s, want %s", got, tt.want)
+		}
+	}
+}
+
+func TestSNMPPasswordKey(t *testing.T) {
+	// RFC 3414, A.3.1 and A.3.2
+	engineID, _ := hex.DecodeString("000000000002")
+	engineID = append(make([]byte, 6), engineID...)
+	if got := hex.EncodeToString(snmpPasswordKey(md5.New, "maplesyrup", engineID)); got != "526f5eed9fcce26f8964c2930787d82b" {
+		t.Errorf("MD5 key: got %s", got)
+	}
+	if got := hex.EncodeToString(snmpPasswordKey(sha1.New, "maplesyrup", engineID)); got != "6695febc9288e36282235fc7151f128497b38f3f" {
+		t.Errorf("SHA key: got %s", got)
+	}
+}
+
+func TestNewSNMPSink(t *testing.T) {
+	for _, c := range []SNMPConfig{
+		{Target: "snmp.example.com:162"},
+		{Target: "tcp://snmp.example.com"},
+		{Target: "udp://snmp.example.com", Version: "1"},
+		{Target: "udp://snmp.example.com", Version: "3"},
+		{Target: "udp://snmp.example.com", Version: "3", User: "cmonit", AuthPassword: "short"},
+		{Target: "udp://snmp.example.com", Version: "3", User: "cmonit", AuthProtocol: "sha512", AuthPassword: "maplesyrup"},
+		{Target: "udp://snmp.example.com", Version: "3", User: "cmonit", PrivPassword: "maplesyrup"},
+		{Target: "udp://snmp.example.com", Version: "3", User: "cmonit", EngineID: "00zz"},
+	} {
+		if _, err := NewSNMPSink(c); err == nil {
+			t.Errorf("%+v: no error", c)
+		}
+	}
+
+	s, err := NewSNMPSink(SNMPConfig{Target: "udp://snmp.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.address != "snmp.example.com:162" || s.community != "public" || s.Name() != "SNMPv2c udp://snmp.example.com" {
+		t.Errorf("got %s %s %s", s.address, s.community, s.Name())
+	}
+}
+
+// listenSNMP returns a UDP listener and a function reading a datagram.
+func listenSNMP(t *testing.T) (string, func() []byte) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return "udp://" + conn.LocalAddr().String(), func() []byte {
+		buf := make([]byte, 65536)
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return buf[:n]
+	}
+}
+
+// trapBindings returns the OIDs and values of the variable bindings of a
+// trap PDU.
+func trapBindings(t *testing.T, pdu []byte) map[string]berElement {
+	t.Helper()
+	fields := berElements(t, pdu)
+	if len(fields) != 4 {
+		t.Fatalf("got %d PDU fields", len(fields))
+	}
+	binds := make(map[string]berElement)
+	for _, b := range berElements(t, fields[3].content) {
+		v := berElements(t, b.content)
+		binds[hex.EncodeToString(v[0].raw)] = v[1]
+	}
+	return binds
+}
+
+func TestSNMPSinkV2c(t *testing.T) {
+	target, read := listenSNMP(t)
+	s, err := NewSNMPSink(SNMPConfig{Target: target, Community: "noc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Send(testEvent()); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := berElements(t, read())
+	if len(msg) != 1 || msg[0].tag != berSequence {
+		t.Fatalf("got % x", msg)
+	}
+	fields := berElements(t, msg[0].content)
+	if berIntValue(fields[0].content) != 1 || string(fields[1].content) != "noc" || fields[2].tag != berTrapPDU {
+		t.Fatalf("got version %x, community %q, PDU %x", fields[0].content, fields[1].content, fields[2].tag)
+	}
+	binds := trapBindings(t, fields[2].content)
+	bind := func(oid []int) berElement { return binds[hex.EncodeToString(berObjectID(oid))] }
+	if !bytes.Equal(bind(oidSNMPTrapOID).raw, berObjectID(oidCmonitEventTrap)) {
+		t.Errorf("snmpTrapOID: got % x", bind(oidSNMPTrapOID).raw)
+	}
+	if bind(oidSysUpTime).tag != berTimeTicks {
+		t.Errorf("sysUpTime: got tag %x", bind(oidSysUpTime).tag)
+	}
+	for _, tt := range []struct {
+		oid  []int
+		want string
+	}{
+		{oidCmonitHostName, "web1"},
+		{oidCmonitHostID, "web1-0"},
+		{oidCmonitServiceName, `nginx "main"`},
+		{oidCmonitEventMessage, "connection failed to localhost:80"},
+	} {
+		if got := string(bind(tt.oid).content); got != tt.want {
+			t.Errorf("%v: got %q, want %q", tt.oid, got, tt.want)
+		}
+	}
+	for _, tt := range []struct {
+		oid  []int
+		want int64
+	}{
+		{oidCmonitEventID, 42},
+		{oidCmonitEventType, 0x20},
+		{oidCmonitEventState, 1},
+		{oidCmonitEventSeverity, 3},
+	} {
+		if got := berIntValue(bind(tt.oid).content); got != tt.want {
+			t.Errorf("%v: got %d, want %d", tt.oid, got, tt.want)
+		}
+	}
+}
+
+func TestSNMPSinkV3(t *testing.T) {
+	target, read := listenSNMP(t)
+	s, err := NewSNMPSink(SNMPConfig{Target: target, Version: "3", User: "cmonit",
+		AuthProtocol: "sha", AuthPassword: "maplesyrup", PrivPassword: "privsyrup", EngineID: "80007ed9046e6d73"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Send(testEvent()); err != nil {
+		t.Fatal(err)
+	}
+
+	raw := read()
+	fields := berElements(t, berElements(t, raw)[0].content)
+	header := berElements(t, fields[1].content)
+	if berIntValue(fields[0].content) != 3 || !bytes.Equal(header[2].content, []byte{3}) || berIntValue(header[3].content) != 3 {
+		t.Fatalf("got version %x, flags %x, security model %x", fields[0].content, header[2].content, header[3].content)
+	}
+	security := berElements(t, berElements(t, fields[2].content)[0].content)
+	engineID, _ := hex.DecodeString("80007ed9046e6d73")
+	if !bytes.Equal(security[0].content, engineID) || string(security[3].content) != "cmonit" {
+		t.Fatalf("got engine %x, user %q", security[0].content, security[3].content)
+	}
+
+	// Authentication: HMAC-SHA-96 of the message with zeroed parameters
+	authParams := security[4].content
+	if len(authParams) != 12 {
+		t.Fatalf("got %d bytes of authentication parameters", len(authParams))
+	}
+	zeroed := bytes.Replace(raw, authParams, make([]byte, 12), 1)
+	mac := hmac.New(sha1.New, snmpPasswordKey(sha1.New, "maplesyrup", engineID))
+	mac.Write(zeroed)
+	if !bytes.Equal(mac.Sum(nil)[:12], authParams) {
+		t.Errorf("authentication parameters % x do not match", authParams)
+	}
+
+	// Privacy: AES-128-CFB, IV of the boots, the time and the salt
+	block, _ := aes.NewCipher(snmpPasswordKey(sha1.New, "privsyrup", engineID)[:16])
+	iv := binary.BigEndian.AppendUint32(nil, uint32(berIntValue(security[1].content)))
+	iv = binary.BigEndian.AppendUint32(iv, uint32(berIntValue(security[2].content)))
+	iv = append(iv, security[5].content...)
+	scoped := make([]byte, len(fields[3].content))
+	cipher.NewCFBDecrypter(block, iv).XORKeyStream(scoped, fields[3].content)
+	pdu := berElements(t, berElements(t, scoped)[0].content)
+	if !bytes.Equal(pdu[0].content, engineID) || pdu[2].tag != berTrapPDU {
+		t.Fatalf("got scoped PDU % x", scoped)
+	}
+	binds := trapBindings(t, pdu[2].content)
+	if got := string(binds[hex.EncodeToString(berObjectID(oidCmonitHostName))].content); got != "web1" {
+		t.Errorf("cmonitHostName: got %q", got)
+	}
+}
+
+func TestSNMPSinkCheckHosts(t *testing.T) {
+	target, read := listenSNMP(t)
+	s, err := NewSNMPSink(SNMPConfig{Target: target})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	hosts := []db.HostSeen{
+		{ID: "web1-0", Hostname: "web1", LastSeen: now.Add(-time.Minute), PollInterval: 30},
+		{ID: "db1-0", Hostname: "db1", LastSeen: now.Add(-time.Hour), PollInterval: 30},
+	}
+
+	trapOID := func() []byte {
+		fields := berElements(t, berElements(t, read())[0].content)
+		return trapBindings(t, fields[2].content)[hex.EncodeToString(berObjectID(oidSNMPTrapOID))].raw
+	}
+
+	// db1 was already offline at startup, web1 goes offline then reports
+	// again
+	if err := s.CheckHosts(hosts, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CheckHosts(hosts, now.Add(5*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if got := trapOID(); !bytes.Equal(got, berObjectID(oidCmonitHostOfflineTrap)) {
+		t.Errorf("got trap % x, want cmonitHostOfflineTrap", got)
+	}
+	hosts[0].LastSeen = now.Add(5 * time.Minute)
+	if err := s.CheckHosts(hosts, now.Add(5*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if got := trapOID(); !bytes.Equal(got, berObjectID(oidCmonitHostOnlineTrap)) {
+		t.Errorf("got trap % x, want cmonitHostOnlineTrap", got)
+	}
+}
+
+// testDB returns a new database in a temporary directory with the hosts
+// stored as the collector does, then moved to their last report (zero:
+// now).
+func testDB(t *testing.T, lastSeen map[string]time.Time) *sql.DB {
+	t.Helper()
+	database, err := db.InitDB(filepath.Join(t.TempDir(), "cmonit.db"))
+	if err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+	for hostname, seen := range lastSeen {
+		server := &parser.Server{ID: hostname + "-0", LocalHostname: hostname, Poll: 30, Uptime: 3600, HTTPD: parser.HTTPDInfo{Port: 2812}}
+		if err := db.StoreHost(database, server, &parser.Platform{Name: "Linux", CPU: 2}, nil); err != nil {
+			t.Fatal(err)
+		}
+		if !seen.IsZero() {
+			if _, err := database.Exec("UPDATE hosts SET last_seen = ? WHERE id = ?", seen, server.ID); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return database
+}
+
+// TestSNMPSinkCheckHostsDB checks the offline traps of the hosts read from
+// the database: only the host going silent is trapped.
+func TestSNMPSinkCheckHostsDB(t *testing.T) {
+	target, read := listenSNMP(t)
+	s, err := NewSNMPSink(SNMPConfig{Target: target})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	database := testDB(t, map[string]time.Time{"web1": {}, "db1": now.Add(-time.Hour)})
+
+	check := func() {
+		hosts, err := db.HostsSeen(database)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.CheckHosts(hosts, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check()
+	if _, err := database.Exec("UPDATE hosts SET last_seen = ? WHERE id = 'web1-0'", now.Add(-10*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	check()
+
+	fields := berElements(t, berElements(t, read())[0].content)
+	binds := trapBindings(t, fields[2].content)
+	if got := binds[hex.EncodeToString(berObjectID(oidSNMPTrapOID))].raw; !bytes.Equal(got, berObjectID(oidCmonitHostOfflineTrap)) {
+		t.Errorf("got trap % x, want cmonitHostOfflineTrap", got)
+	}
+	if host := binds[hex.EncodeToString(berObjectID(oidCmonitHostName))].content; string(host) != "web1" {
+		t.Errorf("offline trap of %q, want web1", host)
+	}
+}
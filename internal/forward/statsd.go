@@ -0,0 +1,151 @@
+package forward
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ocochard/cmonit/internal/db"
+)
+
+// statsdPacketSize bounds the datagrams, to stay within the MTU of most
+// networks.
+const statsdPacketSize = 1400
+
+// DefaultStatsDPrefix starts the gauge names without [export.statsd] prefix.
+const DefaultStatsDPrefix = "monit."
+
+// metricPattern selects data points by host, service and metric
+// (<type>.<name>) globs.
+type metricPattern struct {
+	host, service, metric string
+}
+
+// parseMetricPattern parses a "host/service/metric" pattern, each part a
+// glob (path.Match) and the metric being <type>.<name>, e.g.
+// "web*/nginx/process_cpu.*" or "*/*/cpu.user".
+func parseMetricPattern(pattern string) (metricPattern, error) {
+	parts := strings.Split(pattern, "/")
+	if len(parts) != 3 {
+		return metricPattern{}, fmt.Errorf("invalid metric pattern %q: must be host/service/metric", pattern)
+	}
+	for _, part := range parts {
+		if _, err := path.Match(part, ""); err != nil || part == "" {
+			return metricPattern{}, fmt.Errorf("invalid metric pattern %q: bad glob %q", pattern, part)
+		}
+	}
+	return metricPattern{parts[0], parts[1], parts[2]}, nil
+}
+
+// matchMetric reports whether a data point matches one of patterns.
+func matchMetric(patterns []metricPattern, m db.StoredMetric) bool {
+	for _, p := range patterns {
+		host, _ := path.Match(p.host, m.Hostname)
+		service, _ := path.Match(p.service, m.Service)
+		metric, _ := path.Match(p.metric, m.Type+"."+m.Name)
+		if host && service && metric {
+			return true
+		}
+	}
+	return false
+}
+
+// StatsDSink emits the selected data points as StatsD gauges over UDP,
+// named <prefix><host>.<service>.<type>.<name>.
+type StatsDSink struct {
+	target   string // As configured, for Name
+	address  string
+	prefix   string
+	patterns []metricPattern
+
+	conn net.Conn
+}
+
+// NewStatsDSink returns a sink emitting to target, udp://host[:port] (port
+// 8125 by default), the data points matching one of patterns (see
+// parseMetricPattern), their names starting with prefix (DefaultStatsDPrefix
+// when empty).
+func NewStatsDSink(target, prefix string, patterns []string) (*StatsDSink, error) {
+	u, err := url.Parse(target)
+	if err != nil || u.Hostname() == "" || u.Scheme != "udp" {
+		return nil, fmt.Errorf("invalid StatsD target %q: must be udp://host[:port]", target)
+	}
+	if len(patterns) == 0 {
+		return nil, fmt.Errorf("no StatsD metric pattern: list the host/service/metric patterns to emit")
+	}
+	port := u.Port()
+	if port == "" {
+		port = "8125"
+	}
+	if prefix == "" {
+		prefix = DefaultStatsDPrefix
+	}
+	s := &StatsDSink{target: target, address: net.JoinHostPort(u.Hostname(), port), prefix: prefix}
+	for _, pattern := range patterns {
+		p, err := parseMetricPattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		s.patterns = append(s.patterns, p)
+	}
+	return s, nil
+}
+
+// Name returns the target.
+func (s *StatsDSink) Name() string {
+	return "StatsD " + s.target
+}
+
+// SendMetrics emits the selected data points of a batch, several gauges a
+// datagram.
+func (s *StatsDSink) SendMetrics(metrics []db.StoredMetric) error {
+	for _, packet := range s.packets(metrics) {
+		if s.conn == nil {
+			conn, err := net.DialTimeout("udp", s.address, syslogTimeout)
+			if err != nil {
+				return err
+			}
+			s.conn = conn
+		}
+		s.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+		if _, err := s.conn.Write([]byte(packet)); err != nil {
+			s.conn.Close()
+			s.conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+// packets formats the selected data points as "name:value|g" lines, in
+// datagrams of at most statsdPacketSize bytes. A negative value is set
+// from 0, as a signed gauge value changes the gauge instead.
+func (s *StatsDSink) packets(metrics []db.StoredMetric) []string {
+	var packets []string
+	var b strings.Builder
+	for _, m := range metrics {
+		if !matchMetric(s.patterns, m) || math.IsNaN(m.Value) || math.IsInf(m.Value, 0) {
+			continue
+		}
+		name := s.prefix + graphiteCleaner(m.Hostname) + "." + graphiteCleaner(m.Service) + "." +
+			graphiteCleaner(m.Type) + "." + graphiteCleaner(m.Name)
+		line := name + ":" + strconv.FormatFloat(m.Value, 'f', -1, 64) + "|g\n"
+		if m.Value < 0 {
+			line = name + ":0|g\n" + line
+		}
+		if b.Len() > 0 && b.Len()+len(line) > statsdPacketSize {
+			packets = append(packets, b.String())
+			b.Reset()
+		}
+		b.WriteString(line)
+	}
+	if b.Len() > 0 {
+		packets = append(packets, b.String())
+	}
+	return packets
+}
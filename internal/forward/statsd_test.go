@@ -0,0 +1,79 @@
+package forward
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ocochard/cmonit/internal/db"
+)
+
+func TestStatsDPackets(t *testing.T) {
+	s, err := NewStatsDSink("udp://statsd", "", []string{"web*/*/cpu.*", "*/nginx/process-cpu.percent"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.address != "statsd:8125" {
+		t.Errorf("address %s", s.address)
+	}
+	metrics := []db.StoredMetric{
+		{Hostname: "web1.example.com", Service: "web1", Type: "cpu", Name: "user", Value: 12.5},
+		{Hostname: "db1", Service: "db1", Type: "cpu", Name: "user", Value: 40},
+		{Hostname: "db1", Service: "nginx", Type: "process-cpu", Name: "percent", Value: 3},
+		{Hostname: "db1", Service: "nginx", Type: "process-memory", Name: "percent", Value: 9},
+		{Hostname: "web2", Service: "web2", Type: "cpu", Name: "delta", Value: -2},
+	}
+	want := "monit.web1_example_com.web1.cpu.user:12.5|g\n" +
+		"monit.db1.nginx.process-cpu.percent:3|g\n" +
+		"monit.web2.web2.cpu.delta:0|g\nmonit.web2.web2.cpu.delta:-2|g\n"
+	if got := s.packets(metrics); len(got) != 1 || got[0] != want {
+		t.Errorf("got %q\nwant %q", got, want)
+	}
+
+	// Split in datagrams of at most statsdPacketSize bytes
+	many := make([]db.StoredMetric, 100)
+	for i := range many {
+		many[i] = metrics[0]
+	}
+	packets := s.packets(many)
+	if len(packets) < 2 || strings.Count(strings.Join(packets, ""), "\n") != 100 {
+		t.Errorf("%d packets", len(packets))
+	}
+	for _, p := range packets {
+		if len(p) > statsdPacketSize {
+			t.Errorf("packet of %d bytes", len(p))
+		}
+	}
+
+	for _, patterns := range [][]string{nil, {"web*/cpu.user"}, {"web[/*/cpu.user"}} {
+		if _, err := NewStatsDSink("udp://statsd", "", patterns); err == nil {
+			t.Errorf("no error for %q", patterns)
+		}
+	}
+}
+
+func TestStatsDSink(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	s, err := NewStatsDSink("udp://"+conn.LocalAddr().String(), "fleet.", []string{"*/*/*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SendMetrics([]db.StoredMetric{{Hostname: "web1", Service: "web1", Type: "load", Name: "avg01", Value: 0.5}}); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1500)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buf[:n]); got != "fleet.web1.web1.load.avg01:0.5|g\n" {
+		t.Errorf("got %q", got)
+	}
+}
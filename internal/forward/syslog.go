@@ -0,0 +1,194 @@
+package forward
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ocochard/cmonit/internal/db"
+)
+
+// syslogTimeout bounds the connection to and the writes to a syslog server.
+const syslogTimeout = 5 * time.Second
+
+// localSyslogSockets are the sockets of the local syslog daemon, tried in
+// order (Linux, macOS, FreeBSD).
+var localSyslogSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// syslogFacilities are the facilities an event can be logged with.
+var syslogFacilities = map[string]int{
+	"user":   1,
+	"daemon": 3,
+	"local0": 16,
+	"local1": 17,
+	"local2": 18,
+	"local3": 19,
+	"local4": 20,
+	"local5": 21,
+	"local6": 22,
+	"local7": 23,
+}
+
+// syslogSeverities are the syslog severities of the event severities.
+var syslogSeverities = map[string]int{
+	db.SeverityInfo:     6, // Informational
+	db.SeverityWarning:  4, // Warning
+	db.SeverityCritical: 2, // Critical
+}
+
+// syslogSDID is the structured data element of the RFC 5424 messages,
+// under the example enterprise number of RFC 5612.
+const syslogSDID = "cmonit@32473"
+
+// SyslogSink writes the events to the local syslog daemon, or to a remote
+// server in the RFC 5424 format (over TCP with octet counting framing,
+// RFC 6587).
+type SyslogSink struct {
+	target   string // As configured, for Name
+	network  string // "udp" or "tcp", "" for the local daemon
+	address  string
+	facility int
+	appName  string
+	hostname string
+
+	// text replaces the RFC 5424 messages by traditional ones with this
+	// text, for the CEF and LEEF collectors (see SIEMSink)
+	text func(e db.StoredEvent) string
+
+	conn net.Conn
+}
+
+// NewSyslogSink returns a sink writing to target: "local" for the local
+// syslog daemon, or udp://host:port or tcp://host:port; the port defaults
+// to 514 (UDP) or 601 (TCP). The connection is opened on the first event.
+func NewSyslogSink(target, facility, appName string) (*SyslogSink, error) {
+	if facility == "" {
+		facility = "daemon"
+	}
+	code, ok := syslogFacilities[strings.ToLower(facility)]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog facility %q (valid: user, daemon, local0-local7)", facility)
+	}
+	if appName == "" {
+		appName = "cmonit"
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	s := &SyslogSink{target: target, facility: code, appName: appName, hostname: hostname}
+
+	if target == "local" {
+		return s, nil
+	}
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "udp" && u.Scheme != "tcp") || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid syslog target %q: must be local, udp://host[:port] or tcp://host[:port]", target)
+	}
+	port := u.Port()
+	if port == "" {
+		port = map[string]string{"udp": "514", "tcp": "601"}[u.Scheme]
+	}
+	s.network, s.address = u.Scheme, net.JoinHostPort(u.Hostname(), port)
+	return s, nil
+}
+
+// Name returns "syslog" and the target.
+func (s *SyslogSink) Name() string {
+	return "syslog " + s.target
+}
+
+// Send writes an event, reconnecting after a failure.
+func (s *SyslogSink) Send(e db.StoredEvent) error {
+	if s.conn == nil {
+		conn, err := s.dial()
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+
+	var msg string
+	switch {
+	case s.text != nil:
+		// Newline framing, expected by these collectors over TCP
+		msg = s.formatBSD(e, time.Now())
+	case s.network == "":
+		msg = s.formatLocal(e, time.Now())
+	case s.network == "tcp":
+		msg = s.formatRFC5424(e)
+		msg = fmt.Sprintf("%d %s", len(msg), msg)
+	default:
+		msg = s.formatRFC5424(e)
+	}
+
+	s.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+	if _, err := s.conn.Write([]byte(msg)); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+// dial connects to the syslog server, or to the first local socket
+// accepting a connection.
+func (s *SyslogSink) dial() (net.Conn, error) {
+	if s.network != "" {
+		return net.DialTimeout(s.network, s.address, syslogTimeout)
+	}
+	var err error
+	for _, path := range localSyslogSockets {
+		for _, network := range []string{"unixgram", "unix"} {
+			var conn net.Conn
+			if conn, err = net.DialTimeout(network, path, syslogTimeout); err == nil {
+				return conn, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no local syslog daemon: %w", err)
+}
+
+// priority returns the PRI of an event, from the facility and its
+// severity.
+func (s *SyslogSink) priority(e db.StoredEvent) int {
+	severity, ok := syslogSeverities[e.Severity]
+	if !ok {
+		severity = syslogSeverities[db.SeverityWarning]
+	}
+	return s.facility*8 + severity
+}
+
+// eventText is the text of an event in a syslog message.
+func eventText(e db.StoredEvent) string {
+	return fmt.Sprintf("%s %s: %s (%s)", e.Hostname, e.Service, e.Message, e.Severity)
+}
+
+// formatLocal formats an event for the local daemon, in the traditional
+// format they all understand: "<PRI>Mmm dd hh:mm:ss app[pid]: text".
+func (s *SyslogSink) formatLocal(e db.StoredEvent, now time.Time) string {
+	return fmt.Sprintf("<%d>%s %s[%d]: %s\n", s.priority(e), now.Format(time.Stamp), s.appName, os.Getpid(), eventText(e))
+}
+
+// formatBSD formats an event for a CEF or LEEF collector, in the
+// traditional format with the hostname: "<PRI>Mmm dd hh:mm:ss host text".
+func (s *SyslogSink) formatBSD(e db.StoredEvent, now time.Time) string {
+	return fmt.Sprintf("<%d>%s %s %s\n", s.priority(e), now.Format(time.Stamp), s.hostname, s.text(e))
+}
+
+// formatRFC5424 formats an event as an RFC 5424 message, its fields in the
+// structured data and the message id "event".
+func (s *SyslogSink) formatRFC5424(e db.StoredEvent) string {
+	sd := fmt.Sprintf(`[%s id="%d" host_id="%s" hostname="%s" service="%s" event_type="%d" state="%d" severity="%s"]`,
+		syslogSDID, e.ID, sdEscape(e.HostID), sdEscape(e.Hostname), sdEscape(e.Service), e.EventType, e.State, e.Severity)
+	return fmt.Sprintf("<%d>1 %s %s %s %d event %s %s\n", s.priority(e), e.CreatedAt.Format(time.RFC3339),
+		s.hostname, s.appName, os.Getpid(), sd, eventText(e))
+}
+
+// sdEscape escapes a structured data parameter value (RFC 5424 6.3.3).
+func sdEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(s)
+}
@@ -0,0 +1,105 @@
+package forward
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ocochard/cmonit/internal/db"
+)
+
+func testEvent() db.StoredEvent {
+	return db.StoredEvent{
+		ID:        42,
+		HostID:    "web1-0",
+		Hostname:  "web1",
+		Service:   `nginx "main"`,
+		EventType: 0x20,
+		State:     1,
+		Action:    1,
+		Message:   "connection failed to localhost:80",
+		Severity:  db.SeverityCritical,
+		CreatedAt: time.Date(2026, 10, 14, 9, 12, 5, 0, time.UTC),
+	}
+}
+
+func TestNewSyslogSink(t *testing.T) {
+	tests := []struct {
+		target, facility string
+		address          string // "" for an error
+	}{
+		{"udp://logs.example.com", "", "logs.example.com:514"},
+		{"tcp://logs.example.com", "local3", "logs.example.com:601"},
+		{"udp://[::1]:1514", "daemon", "[::1]:1514"},
+		{"local", "user", ""},
+		{"http://logs.example.com", "", ""},
+		{"udp://", "", ""},
+		{"udp://logs.example.com", "kern", ""},
+	}
+	for _, tt := range tests {
+		s, err := NewSyslogSink(tt.target, tt.facility, "")
+		switch {
+		case tt.target == "local":
+			if err != nil || s.network != "" {
+				t.Errorf("%s: %v, network %q", tt.target, err, s.network)
+			}
+		case tt.address == "" && err == nil:
+			t.Errorf("%s (%s): no error", tt.target, tt.facility)
+		case tt.address != "" && (err != nil || s.address != tt.address):
+			t.Errorf("%s: %v, address %v, want %s", tt.target, err, s, tt.address)
+		}
+	}
+}
+
+func TestSyslogFormats(t *testing.T) {
+	s, err := NewSyslogSink("udp://localhost", "local3", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.hostname = "collector"
+	pid := os.Getpid()
+
+	// local3 (19) * 8 + crit (2)
+	want := `<154>1 2026-10-14T09:12:05Z collector cmonit ` + strconv.Itoa(pid) + ` event ` +
+		`[cmonit@32473 id="42" host_id="web1-0" hostname="web1" service="nginx \"main\"" event_type="32" state="1" severity="critical"] ` +
+		`web1 nginx "main": connection failed to localhost:80 (critical)` + "\n"
+	if got := s.formatRFC5424(testEvent()); got != want {
+		t.Errorf("formatRFC5424 =\n%s\nwant\n%s", got, want)
+	}
+
+	now := time.Date(2026, 10, 4, 9, 12, 5, 0, time.UTC)
+	want = `<154>Oct  4 09:12:05 cmonit[` + strconv.Itoa(pid) + `]: web1 nginx "main": connection failed to localhost:80 (critical)` + "\n"
+	if got := s.formatLocal(testEvent(), now); got != want {
+		t.Errorf("formatLocal = %q, want %q", got, want)
+	}
+}
+
+func TestSyslogSinkSend(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("no UDP: %v", err)
+	}
+	defer conn.Close()
+
+	s, err := NewSyslogSink("udp://"+conn.LocalAddr().String(), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Send(testEvent()); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 2048)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// daemon (3) * 8 + crit (2)
+	if got := string(buf[:n]); !strings.HasPrefix(got, "<26>1 ") || !strings.Contains(got, "connection failed") {
+		t.Errorf("received %q", got)
+	}
+}
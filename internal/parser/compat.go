@@ -0,0 +1,94 @@
+package parser
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// MonitVersion is a Monit release number, e.g. 5.35.2.
+type MonitVersion struct {
+	Major, Minor, Patch int
+}
+
+// ParseMonitVersion parses a version reported by an agent ("5.35.2",
+// "5.26"). Suffixes of development builds ("5.34.0beta1") are ignored.
+func ParseMonitVersion(s string) (MonitVersion, bool) {
+	var v MonitVersion
+	parts := strings.SplitN(strings.TrimSpace(s), ".", 3)
+	if len(parts) < 2 {
+		return v, false
+	}
+	fields := []*int{&v.Major, &v.Minor, &v.Patch}
+	for i, part := range parts {
+		end := 0
+		for end < len(part) && part[end] >= '0' && part[end] <= '9' {
+			end++
+		}
+		n, err := strconv.Atoi(part[:end])
+		if err != nil {
+			return MonitVersion{}, false
+		}
+		*fields[i] = n
+	}
+	return v, true
+}
+
+// Before reports whether v is older than major.minor.
+func (v MonitVersion) Before(major, minor int) bool {
+	return v.Major < major || (v.Major == major && v.Minor < minor)
+}
+
+func (v MonitVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// compatShim fills the fields an agent release reports differently from
+// the current ones, so that its services are not stored half-empty.
+type compatShim struct {
+	// name describes the difference, listed in MonitStatus.Compat
+	name string
+
+	// applies reports whether the agent release needs the shim; an
+	// unknown version gets every shim, which only act on what the
+	// document holds
+	applies func(v MonitVersion) bool
+
+	// apply fixes a service converted from its proxy struct, and reports
+	// whether it changed
+	apply func(sx *ServiceXML, s *Service) bool
+}
+
+// compatShims are applied by ParseMonitXML to each service after the
+// conversion of its proxy struct, in order.
+var compatShims = []compatShim{
+	{
+		// Releases before 5.18 report the mount flags of a filesystem
+		// as a number in <flags>, later ones as text in <fsflags>
+		name:    "filesystem <flags> number used as <fsflags>",
+		applies: func(v MonitVersion) bool { return v.Before(5, 18) },
+		apply: func(sx *ServiceXML, s *Service) bool {
+			if s.Type != 0 || s.FSFlags != nil || sx.Flags == nil {
+				return false
+			}
+			flags := strconv.FormatInt(*sx.Flags, 10)
+			s.FSFlags = &flags
+			return true
+		},
+	},
+}
+
+// applyCompat runs the shims needed by the agent release version on a
+// service of ms, and records the ones that changed it in ms.Compat.
+func applyCompat(version string, sx *ServiceXML, s *Service, ms *MonitStatus) {
+	v, known := ParseMonitVersion(version)
+	for _, shim := range compatShims {
+		if known && !shim.applies(v) {
+			continue
+		}
+		if shim.apply(sx, s) && !slices.Contains(ms.Compat, shim.name) {
+			ms.Compat = append(ms.Compat, shim.name)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"unicode/utf8"
+)
+
+// decodeStatus decodes a status document in a single pass over its
+// tokens. The <monit> element and its lists are walked token by token, and
+// each <service> is decoded into the same ServiceXML, converted to a
+// Service right away: the proxy structs of all the services are never
+// held together, and the limits are checked by the token reader (see
+// limitedTokens) instead of a scan of their own.
+//
+// The services are returned in the order of the document, whether they
+// are wrapped in <services> (collector format) or listed directly under
+// <monit> (the agent's _status page).
+func decodeStatus(data []byte, l Limits) (*MonitStatus, error) {
+	if int64(len(data)) > l.MaxBytes {
+		return nil, fmt.Errorf("%w: %d bytes, at most %d", ErrLimitExceeded, len(data), l.MaxBytes)
+	}
+
+	src := xml.NewDecoder(bytes.NewReader(data))
+	src.CharsetReader = charsetReader
+	if utf8.Valid(data) {
+		src.CharsetReader = utf8CharsetReader
+	}
+	d := xml.NewTokenDecoder(&limitedTokens{src: src, limits: l})
+
+	root, err := nextStart(d)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal XML: %w", err)
+	}
+	if root.Name.Local != "monit" {
+		return nil, fmt.Errorf("failed to unmarshal XML: expected element type <monit> but have <%s>", root.Name.Local)
+	}
+
+	sd := statusDecoder{d: d, status: &MonitStatus{}}
+	for _, attr := range root.Attr {
+		if attr.Name.Local == "version" {
+			sd.version = attr.Value
+		}
+	}
+	if err := sd.children(sd.element); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal XML: %w", err)
+	}
+
+	// The collector format has the agent version in a <monit> attribute
+	// only. The id and incarnation attributes are left out: the IDs of
+	// the hosts stored without them are derived from the hostname (see
+	// db.HostID) and would change.
+	if sd.status.Server.Version == "" {
+		sd.status.Server.Version = sd.version
+	}
+	return sd.status, nil
+}
+
+// statusDecoder holds the state of decodeStatus.
+type statusDecoder struct {
+	d       *xml.Decoder
+	status  *MonitStatus
+	version string     // <monit version> attribute
+	service ServiceXML // reused for every <service>
+}
+
+// nextStart returns the first start element, skipping the prolog.
+func nextStart(d *xml.Decoder) (xml.StartElement, error) {
+	for {
+		token, err := d.Token()
+		if err != nil {
+			return xml.StartElement{}, err
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return start, nil
+		}
+	}
+}
+
+// children calls decode for each child element of the current element,
+// up to its end element. decode must consume the child entirely.
+func (sd *statusDecoder) children(decode func(start *xml.StartElement) error) error {
+	for {
+		token, err := sd.d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			if err := decode(&t); err != nil {
+				return err
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+// element decodes a child of <monit>.
+func (sd *statusDecoder) element(start *xml.StartElement) error {
+	switch start.Name.Local {
+	case "server":
+		return sd.d.DecodeElement(&sd.status.Server, start)
+	case "platform":
+		return sd.d.DecodeElement(&sd.status.Platform, start)
+	case "services":
+		return sd.children(func(start *xml.StartElement) error {
+			if start.Name.Local != "service" {
+				return sd.d.Skip()
+			}
+			return sd.serviceElement(start)
+		})
+	case "service":
+		return sd.serviceElement(start)
+	case "hostgroups":
+		return sd.children(func(start *xml.StartElement) error {
+			if start.Name.Local != "name" {
+				return sd.d.Skip()
+			}
+			var name string
+			if err := sd.d.DecodeElement(&name, start); err != nil {
+				return err
+			}
+			sd.status.HostGroups = append(sd.status.HostGroups, name)
+			return nil
+		})
+	case "servicegroups":
+		return sd.children(func(start *xml.StartElement) error {
+			if start.Name.Local != "servicegroup" {
+				return sd.d.Skip()
+			}
+			var group ServiceGroup
+			if err := sd.d.DecodeElement(&group, start); err != nil {
+				return err
+			}
+			sd.status.ServiceGroups = append(sd.status.ServiceGroups, group)
+			return nil
+		})
+	case "event":
+		if sd.status.Event == nil {
+			sd.status.Event = &Event{}
+		}
+		return sd.d.DecodeElement(sd.status.Event, start)
+	}
+	return sd.d.Skip()
+}
+
+// serviceElement decodes a <service> into the reused ServiceXML, and
+// appends its conversion to the services of the document, once the
+// compatibility shims and the program output limit are applied.
+func (sd *statusDecoder) serviceElement(start *xml.StartElement) error {
+	// The Service keeps the pointers and slices decoded: only the struct
+	// itself is reused
+	sd.service = ServiceXML{}
+	sx := &sd.service
+	if err := sd.d.DecodeElement(sx, start); err != nil {
+		return err
+	}
+
+	svc := sx.ToService()
+	version := sd.status.Server.Version
+	if version == "" {
+		version = sd.version
+	}
+	applyCompat(version, sx, &svc, sd.status)
+	truncateProgramOutput(&svc)
+
+	sd.status.Services = append(sd.status.Services, svc)
+	return nil
+}
@@ -0,0 +1,132 @@
+package parser
+
+import (
+	"encoding/xml"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// TestParseMonitXMLStreaming checks that the services decoded one at a
+// time are those of a decoding of the whole document, in both formats.
+func TestParseMonitXMLStreaming(t *testing.T) {
+	data := benchDocument(100)
+	status, err := ParseMonitXML(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var whole struct {
+		Server   Server         `xml:"server"`
+		Services []ServiceXML   `xml:"services>service"`
+		Groups   []ServiceGroup `xml:"servicegroups>servicegroup"`
+	}
+	decoder := xml.NewDecoder(strings.NewReader(string(data)))
+	decoder.CharsetReader = charsetReader
+	if err := decoder.Decode(&whole); err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Services) != len(whole.Services) {
+		t.Fatalf("%d services, want %d", len(status.Services), len(whole.Services))
+	}
+	for i := range whole.Services {
+		if want := whole.Services[i].ToService(); !reflect.DeepEqual(status.Services[i], want) {
+			t.Errorf("service %d:\n got %+v\nwant %+v", i, status.Services[i], want)
+		}
+	}
+	if !reflect.DeepEqual(status.ServiceGroups, whole.Groups) {
+		t.Errorf("service groups = %+v, want %+v", status.ServiceGroups, whole.Groups)
+	}
+	if status.Server.Version != "5.35.2" || status.Server.LocalHostname != "bench" {
+		t.Errorf("server = %+v", status.Server)
+	}
+
+	// The agent's _status page lists the services directly under <monit>
+	page := `<monit><server><localhostname>h</localhostname></server>` +
+		`<service type="3"><name>nginx</name><pid>12</pid></service>` +
+		`<service type="7"><name>backup</name></service></monit>`
+	status, err = ParseMonitXML([]byte(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Services) != 2 || status.Services[0].Name != "nginx" || status.Services[0].PID == nil ||
+		status.Services[1].Type != 7 {
+		t.Errorf("services = %+v", status.Services)
+	}
+
+	// ISO-8859-1 documents that are not valid UTF-8 are still transcoded
+	latin1, _ := charmap.ISO8859_1.NewEncoder().String(`<?xml version="1.0" encoding="ISO-8859-1"?>` +
+		`<monit><server><localhostname>hôte</localhostname></server></monit>`)
+	if status, err = ParseMonitXML([]byte(latin1)); err != nil {
+		t.Fatal(err)
+	}
+	if status.Server.LocalHostname != "hôte" {
+		t.Errorf("latin1 hostname = %q", status.Server.LocalHostname)
+	}
+
+	if _, err := ParseMonitXML([]byte(`<status><server/></status>`)); err == nil {
+		t.Error("document without <monit> accepted")
+	}
+}
+
+// benchDocument returns a collector document of n services: a system
+// service, then filesystems, processes with a port check, programs and
+// remote hosts in turn.
+func benchDocument(n int) []byte {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="ISO-8859-1"?>` +
+		`<monit id="2b8e7fb4c7e1a5a2d6c3" incarnation="1763943000" version="5.35.2"><server>` +
+		`<uptime>1000</uptime><poll>30</poll><startdelay>0</startdelay><localhostname>bench</localhostname>` +
+		`<controlfile>/usr/local/etc/monitrc</controlfile><httpd><address>127.0.0.1</address><port>2812</port><ssl>0</ssl></httpd>` +
+		`</server><platform><name>FreeBSD</name><release>15.0</release><version>FreeBSD 15.0</version>` +
+		`<machine>amd64</machine><cpu>8</cpu><memory>33554432</memory><swap>4194304</swap></platform><services>`)
+	b.WriteString(`<service name="bench"><type>5</type><collected_sec>1763943569</collected_sec><collected_usec>1</collected_usec>` +
+		`<status>0</status><status_hint>0</status_hint><monitor>1</monitor><monitormode>0</monitormode><onreboot>0</onreboot>` +
+		`<pendingaction>0</pendingaction><system><load><avg01>0.20</avg01><avg05>0.32</avg05><avg15>0.28</avg15></load>` +
+		`<cpu><user>5.2</user><system>2.1</system><nice>0.0</nice><wait>0.1</wait></cpu>` +
+		`<memory><percent>45.6</percent><kilobyte>12345678</kilobyte></memory><swap><percent>1.0</percent><kilobyte>1234</kilobyte></swap></system></service>`)
+	common := `<collected_sec>1763943569</collected_sec><collected_usec>120000</collected_usec><status>0</status>` +
+		`<status_hint>0</status_hint><monitor>1</monitor><monitormode>0</monitormode><onreboot>0</onreboot><pendingaction>0</pendingaction>`
+	for i := 1; i < n; i++ {
+		switch i % 4 {
+		case 0:
+			fmt.Fprintf(&b, `<service name="fs%d"><type>0</type>%s<fstype>zfs</fstype><fsflags>local, noatime</fsflags>`+
+				`<mode>755</mode><uid>0</uid><gid>0</gid><block><percent>90.5</percent><usage>27226910.4</usage><total>30089135.8</total></block>`+
+				`<inode><percent>0.0</percent><usage>803919</usage><total>5862641503</total></inode>`+
+				`<read><bytes><count>10</count><total>1000</total></bytes><operations><count>1</count><total>100</total></operations></read>`+
+				`<write><bytes><count>10</count><total>1000</total></bytes><operations><count>1</count><total>100</total></operations></write></service>`, i, common)
+		case 1:
+			fmt.Fprintf(&b, `<service name="proc%d"><type>3</type>%s<pid>%d</pid><ppid>1</ppid><uid>0</uid><euid>0</euid><gid>0</gid>`+
+				`<uptime>1000</uptime><boottime>1763940000</boottime><threads>4</threads><children>0</children>`+
+				`<memory><percent>1.5</percent><percenttotal>1.5</percenttotal><kilobyte>10240</kilobyte><kilobytetotal>10240</kilobytetotal></memory>`+
+				`<cpu><percent>0.5</percent><percenttotal>0.5</percenttotal></cpu>`+
+				`<port><hostname>localhost</hostname><portnumber>80</portnumber><request><![CDATA[/]]></request><protocol>HTTP</protocol>`+
+				`<type>TCP</type><responsetime>0.001</responsetime></port></service>`, i, common, 1000+i)
+		case 2:
+			fmt.Fprintf(&b, `<service name="prog%d"><type>7</type>%s<program><started>1763943500</started><status>0</status>`+
+				`<output><![CDATA[backup completed: 1234 files, 0 errors]]></output></program></service>`, i, common)
+		case 3:
+			fmt.Fprintf(&b, `<service name="remote%d"><type>4</type>%s<icmp><type>Ping</type><responsetime>0.000348</responsetime></icmp>`+
+				`<port><hostname>192.0.2.%d</hostname><portnumber>443</portnumber><request><![CDATA[/]]></request><protocol>HTTP</protocol>`+
+				`<type>TCP</type><responsetime>0.002</responsetime></port></service>`, i, common, i%250)
+		}
+	}
+	b.WriteString(`</services><servicegroups><servicegroup name="www"><service>proc1</service></servicegroup></servicegroups></monit>`)
+	return []byte(b.String())
+}
+
+// BenchmarkParseMonitXML parses a 100-service document, as posted by an
+// agent every cycle. Run with -benchmem to compare allocs/op.
+func BenchmarkParseMonitXML(b *testing.B) {
+	data := benchDocument(100)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := ParseMonitXML(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
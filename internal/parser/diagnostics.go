@@ -0,0 +1,105 @@
+package parser
+
+import "fmt"
+
+// Warning is a problem found in a status document that parsed but will
+// not be stored or shown as the agent intended: a missing element, an
+// unknown service type, a reference to an unknown service...
+type Warning struct {
+	Service string // Service the warning is about, empty for the document
+	Message string
+}
+
+// Diagnose checks a parsed status document for elements that are missing
+// or inconsistent, which encoding/xml silently leaves as zero values.
+func Diagnose(status *MonitStatus) []Warning {
+	var warnings []Warning
+	warn := func(service, format string, args ...interface{}) {
+		warnings = append(warnings, Warning{Service: service, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if status.Server.ID == "" {
+		warn("", "no <id> in <server>: the host ID is generated from the hostname and incarnation, and changes when Monit restarts")
+	}
+	if status.Server.LocalHostname == "" {
+		warn("", "no <localhostname> in <server>")
+	}
+	for _, shim := range status.Compat {
+		warn("", "Monit %s: %s", status.Server.Version, shim)
+	}
+	if status.Server.Poll <= 0 {
+		warn("", "no <poll> in <server>: the host health assumes a 30 seconds poll interval")
+	}
+	if status.IsEvent() {
+		if status.Event.Service == "" {
+			warn("", "event without <service>")
+		}
+		if status.Event.CollectedSec == 0 {
+			warn(status.Event.Service, "event without <collected_sec>: the event time is the reception time")
+		}
+	} else if len(status.Services) == 0 {
+		warn("", "no services")
+	}
+
+	names := make(map[string]bool, len(status.Services))
+	for _, svc := range status.Services {
+		if svc.Name != "" && names[svc.Name] {
+			warn(svc.Name, "duplicate service name: only the last one is kept")
+		}
+		names[svc.Name] = true
+	}
+
+	for _, svc := range status.Services {
+		if svc.Name == "" {
+			warn("", "service of type %d without a name", svc.Type)
+			continue
+		}
+		if svc.CollectedSec == 0 {
+			warn(svc.Name, "no <collected_sec>: the collection time is the epoch")
+		}
+		if svc.Every != nil && (svc.Every.Type < EveryCycle || svc.Every.Type > EveryNotInCron) {
+			warn(svc.Name, "unknown <every> type %d", svc.Every.Type)
+		}
+		for _, dep := range svc.Depends {
+			if !names[dep] {
+				warn(svc.Name, "depends on %q, which is not in the document", dep)
+			}
+		}
+
+		switch svc.Type {
+		case 0:
+			if svc.Block == nil || svc.Inode == nil {
+				warn(svc.Name, "filesystem without <block> or <inode>: metrics not stored")
+			}
+		case 1, 6:
+			if svc.Path == nil {
+				warn(svc.Name, "no <mode>, <uid> or <gid>: metrics not stored")
+			}
+		case 2:
+			if svc.File == nil {
+				warn(svc.Name, "file without <mode>, <uid>, <gid> or <size>: metrics not stored")
+			}
+		case 3:
+			if svc.Monitor == 1 && svc.Status == 0 && svc.PID == nil {
+				warn(svc.Name, "running process without <pid>")
+			}
+		case 4, 7, 8:
+		case 5:
+			if svc.System == nil {
+				warn(svc.Name, "system service without <system>: metrics not stored")
+			}
+		default:
+			warn(svc.Name, "unknown service type %d", svc.Type)
+		}
+	}
+
+	for _, group := range status.ServiceGroups {
+		for _, name := range group.Services {
+			if !names[name] {
+				warn(name, "member of service group %q but not in the document", group.Name)
+			}
+		}
+	}
+
+	return warnings
+}
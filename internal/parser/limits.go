@@ -0,0 +1,89 @@
+package parser
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+)
+
+// Limits bound the status documents ParseMonitXML accepts, so that a
+// corrupted or malicious agent post cannot exhaust the collector's memory.
+// A Monit document is a few kilobytes per service and 4 or 5 elements deep.
+type Limits struct {
+	// MaxBytes is the size of a document, after decompression
+	MaxBytes int64
+
+	// MaxElements is the number of elements of a document
+	MaxElements int
+
+	// MaxDepth is the nesting depth of the elements
+	MaxDepth int
+}
+
+// DefaultLimits fit thousands of services.
+var DefaultLimits = Limits{
+	MaxBytes:    16 << 20,
+	MaxElements: 500000,
+	MaxDepth:    32,
+}
+
+// limits are the limits applied by ParseMonitXML.
+var limits = DefaultLimits
+
+// SetLimits changes the limits applied by ParseMonitXML; zero fields keep
+// the default.
+func SetLimits(l Limits) {
+	if l.MaxBytes <= 0 {
+		l.MaxBytes = DefaultLimits.MaxBytes
+	}
+	if l.MaxElements <= 0 {
+		l.MaxElements = DefaultLimits.MaxElements
+	}
+	if l.MaxDepth <= 0 {
+		l.MaxDepth = DefaultLimits.MaxDepth
+	}
+	limits = l
+}
+
+// MaxDocumentSize returns the size of the largest document accepted, for
+// the collector to stop reading beyond it.
+func MaxDocumentSize() int64 {
+	return limits.MaxBytes
+}
+
+// ErrLimitExceeded is wrapped by the errors of the documents exceeding the
+// limits.
+var ErrLimitExceeded = errors.New("document exceeds parser limits")
+
+// limitedTokens reads the tokens of a document for the decoder of
+// ParseMonitXML, and fails on the first limit exceeded: the documents are
+// checked as they are decoded, in a single pass.
+type limitedTokens struct {
+	src      *xml.Decoder
+	limits   Limits
+	elements int
+	depth    int
+}
+
+// Token returns the next raw token of the document; the decoder reading
+// it checks that the elements match.
+func (t *limitedTokens) Token() (xml.Token, error) {
+	token, err := t.src.RawToken()
+	if err != nil {
+		return token, err
+	}
+	switch token.(type) {
+	case xml.StartElement:
+		t.elements++
+		t.depth++
+		if t.elements > t.limits.MaxElements {
+			return nil, fmt.Errorf("%w: more than %d elements", ErrLimitExceeded, t.limits.MaxElements)
+		}
+		if t.depth > t.limits.MaxDepth {
+			return nil, fmt.Errorf("%w: elements nested deeper than %d", ErrLimitExceeded, t.limits.MaxDepth)
+		}
+	case xml.EndElement:
+		t.depth--
+	}
+	return token, nil
+}
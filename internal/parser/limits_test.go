@@ -0,0 +1,49 @@
+package parser
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// TestParseMonitXMLLimits checks that the documents exceeding the limits
+// are rejected before they are decoded.
+func TestParseMonitXMLLimits(t *testing.T) {
+	defer SetLimits(DefaultLimits)
+	SetLimits(Limits{MaxBytes: 4096, MaxElements: 50, MaxDepth: 8})
+
+	head := `<?xml version="1.0" encoding="ISO-8859-1"?><monit><server><localhostname>h</localhostname></server>`
+	for name, doc := range map[string]string{
+		"size":     head + `<services><service name="x"><program><output>` + strings.Repeat("A", 5000) + `</output></program></service></services></monit>`,
+		"elements": head + `<services>` + strings.Repeat(`<service name="x"><type>3</type></service>`, 30) + `</services></monit>`,
+		"depth":    head + strings.Repeat(`<x>`, 10) + strings.Repeat(`</x>`, 10) + `</monit>`,
+	} {
+		if _, err := ParseMonitXML([]byte(doc)); !errors.Is(err, ErrLimitExceeded) {
+			t.Errorf("%s: err = %v, want ErrLimitExceeded", name, err)
+		}
+	}
+
+	ok := head + `<services>` + strings.Repeat(`<service name="x"><type>3</type></service>`, 10) + `</services></monit>`
+	if _, err := ParseMonitXML([]byte(ok)); err != nil {
+		t.Errorf("document within the limits rejected: %v", err)
+	}
+}
+
+// FuzzParseMonitXML checks that no document makes the parser, the
+// compatibility shims, Diagnose or Validate panic. The seed corpus is in
+// testdata/fuzz/FuzzParseMonitXML; run go test -fuzz=FuzzParseMonitXML to
+// extend it.
+func FuzzParseMonitXML(f *testing.F) {
+	f.Add([]byte(`<?xml version="1.0" encoding="ISO-8859-1"?><monit id="a" version="5.35.2"><server><poll>30</poll>` +
+		`<localhostname>h</localhostname></server><services><service name="h"><type>5</type>` +
+		`<system><load><avg01>0.1</avg01></load><cpu><user>1</user></cpu></system></service></services></monit>`))
+	f.Add([]byte(`<monit><event><service>nginx</service><id>32</id><state>1</state></event></monit>`))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		status, err := ParseMonitXML(data)
+		if err != nil {
+			return
+		}
+		Diagnose(status)
+		_ = Validate(status)
+	})
+}
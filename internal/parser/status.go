@@ -0,0 +1,65 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// statusTexts are the descriptions of the bits of a service status, the
+// Monit event types that failed (monit-5.35.2/src/event.h Event_Type).
+var statusTexts = []struct {
+	bit  int
+	text string
+}{
+	{0x1, "Checksum failed"},
+	{0x2, "Resource limit matched"},
+	{0x4, "Timeout"},
+	{0x8, "Timestamp changed"},
+	{0x10, "Size changed"},
+	{0x20, "Connection failed"},
+	{0x40, "Permission failed"},
+	{0x80, "UID failed"},
+	{0x100, "GID failed"},
+	{0x200, "Does not exist"},
+	{0x400, "Invalid type"},
+	{0x800, "Data access error"},
+	{0x1000, "Execution failed"},
+	{0x2000, "Filesystem flags changed"},
+	{0x4000, "ICMP failed"},
+	{0x8000, "Content match failed"},
+	{0x10000, "Instance changed"},
+	{0x20000, "Action done"},
+	{0x40000, "PID changed"},
+	{0x80000, "PPID changed"},
+	{0x100000, "Heartbeat failed"},
+	{0x200000, "Status changed"},
+	{0x400000, "Uptime failed"},
+	{0x800000, "Link down"},
+	{0x1000000, "Speed changed"},
+	{0x2000000, "Saturation exceeded"},
+	{0x4000000, "Download bytes exceeded"},
+	{0x8000000, "Upload bytes exceeded"},
+	{0x10000000, "Download packets exceeded"},
+	{0x20000000, "Upload packets exceeded"},
+	{0x40000000, "Exists"},
+}
+
+// StatusText describes a service status: "OK", or the failed checks of
+// its bits, e.g. "Connection failed" or "Timeout, Connection failed".
+func StatusText(status int) string {
+	if status == 0 {
+		return "OK"
+	}
+	var texts []string
+	rest := status
+	for _, s := range statusTexts {
+		if status&s.bit != 0 {
+			texts = append(texts, s.text)
+			rest &^= s.bit
+		}
+	}
+	if rest != 0 {
+		texts = append(texts, fmt.Sprintf("Unknown status (%d)", rest))
+	}
+	return strings.Join(texts, ", ")
+}
@@ -0,0 +1,129 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ValidationError lists what is malformed in a status document rejected
+// by Validate, one problem per line, e.g.
+//
+//	service "rootfs": <block><percent> 140.0 out of range 0-100
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return strings.Join(e.Problems, "\n")
+}
+
+// Validate checks the fields a status document needs to be stored as
+// intended: the server id and hostname, the service names, and the ranges
+// of the numeric values. It is stricter than Diagnose, whose warnings
+// describe documents that are still stored: it is used by the collector
+// in strict mode ([collector] strict) to reject a document with 400.
+//
+// Returns nil, or a *ValidationError listing every problem found.
+func Validate(status *MonitStatus) error {
+	var problems []string
+	problem := func(service, format string, args ...interface{}) {
+		msg := fmt.Sprintf(format, args...)
+		if service != "" {
+			msg = fmt.Sprintf("service %q: %s", service, msg)
+		}
+		problems = append(problems, msg)
+	}
+
+	if strings.TrimSpace(status.Server.ID) == "" {
+		problem("", "missing <server><id>")
+	}
+	if strings.TrimSpace(status.Server.LocalHostname) == "" {
+		problem("", "missing <server><localhostname>")
+	}
+	if status.Server.Poll < 0 {
+		problem("", "<server><poll> %d is negative", status.Server.Poll)
+	}
+
+	if status.IsEvent() {
+		if strings.TrimSpace(status.Event.Service) == "" {
+			problem("", "missing <event><service>")
+		}
+		if status.Event.CollectedSec < 0 {
+			problem(status.Event.Service, "<event><collected_sec> %d is negative", status.Event.CollectedSec)
+		}
+	}
+
+	for i, svc := range status.Services {
+		if strings.TrimSpace(svc.Name) == "" {
+			problem("", "service #%d (type %d) has no name", i+1, svc.Type)
+			continue
+		}
+		if svc.Type < 0 || svc.Type > 8 {
+			problem(svc.Name, "<type> %d out of range 0-8", svc.Type)
+		}
+		if svc.Monitor < 0 || svc.Monitor > 2 {
+			problem(svc.Name, "<monitor> %d out of range 0-2", svc.Monitor)
+		}
+		if svc.CollectedSec < 0 {
+			problem(svc.Name, "<collected_sec> %d is negative", svc.CollectedSec)
+		}
+		if svc.CollectedUsec < 0 || svc.CollectedUsec > 999999 {
+			problem(svc.Name, "<collected_usec> %d out of range 0-999999", svc.CollectedUsec)
+		}
+
+		if s := svc.System; s != nil {
+			checkPercent(problem, svc.Name, "<cpu><user>", s.CPU.User)
+			checkPercent(problem, svc.Name, "<cpu><system>", s.CPU.System)
+			checkPercent(problem, svc.Name, "<cpu><wait>", s.CPU.Wait)
+			checkPercent(problem, svc.Name, "<memory><percent>", s.Memory.Percent)
+			checkPercent(problem, svc.Name, "<swap><percent>", s.Swap.Percent)
+			for _, load := range []struct {
+				name  string
+				value float64
+			}{{"avg01", s.Load.Avg01}, {"avg05", s.Load.Avg05}, {"avg15", s.Load.Avg15}} {
+				if load.value < 0 {
+					problem(svc.Name, "<load><%s> %.2f is negative", load.name, load.value)
+				}
+			}
+		}
+		if svc.Block != nil {
+			checkPercent(problem, svc.Name, "<block><percent>", svc.Block.Percent)
+		}
+		if svc.Inode != nil {
+			checkPercent(problem, svc.Name, "<inode><percent>", svc.Inode.Percent)
+		}
+		if svc.Memory != nil {
+			checkPercent(problem, svc.Name, "<memory><percent>", svc.Memory.Percent)
+		}
+		for _, port := range svc.Port {
+			if port.PortNumber < 0 || port.PortNumber > 65535 {
+				problem(svc.Name, "<port><portnumber> %d out of range 0-65535", port.PortNumber)
+			}
+			if port.ResponseTime < -1 {
+				problem(svc.Name, "<port><responsetime> %.3f is negative", port.ResponseTime)
+			}
+		}
+		for _, icmp := range svc.ICMP {
+			if icmp.ResponseTime < -1 {
+				problem(svc.Name, "<icmp><responsetime> %.3f is negative", icmp.ResponseTime)
+			}
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return &ValidationError{Problems: problems}
+}
+
+// checkPercent reports a percentage out of 0-100. Monit reports -1 for
+// the values it could not compute yet (e.g. on the first cycle), which
+// are accepted.
+func checkPercent(problem func(string, string, ...interface{}), service, field string, value float64) {
+	if value == -1 {
+		return
+	}
+	if value < 0 || value > 100 {
+		problem(service, "%s %.1f out of range 0-100", field, value)
+	}
+}
@@ -23,11 +23,72 @@ import (
 	"bytes"        // Byte slice operations
 	"encoding/xml" // XML parsing and generation
 	"fmt"          // Formatted I/O
+	"io"           // Readers
 	"log"          // Logging
-	"os"           // Operating system functions
+	"strings"      // String operations
 	"time"         // Time and date functions
+	"unicode/utf8" // UTF-8 validation
+
+	"golang.org/x/text/encoding/charmap" // ISO-8859-1 decoding
+)
+
+// debugMode controls whether DEBUG log messages are output.
+// Set via SetDebugMode() from the main package.
+var debugMode bool
+
+// SetDebugMode enables or disables debug logging in the parser package.
+func SetDebugMode(enabled bool) {
+	debugMode = enabled
+}
+
+// maxProgramOutput is the length in bytes beyond which the output of the
+// program services is truncated, 0 for no limit. keepFullProgramOutput
+// keeps the whole output in ProgramInfo.FullOutput when it is truncated.
+var (
+	maxProgramOutput      int
+	keepFullProgramOutput bool
 )
 
+// SetProgramOutputLimit truncates the program output of the parsed
+// documents beyond max bytes (0 for no limit), keeping the whole output
+// in ProgramInfo.FullOutput with keepFull.
+func SetProgramOutputLimit(max int, keepFull bool) {
+	maxProgramOutput = max
+	keepFullProgramOutput = keepFull
+}
+
+// truncateOutput returns output cut to max bytes, on a character boundary,
+// followed by a marker giving its whole length. It is returned as is
+// within the limit, or without limit (max 0).
+func truncateOutput(output string, max int) (string, bool) {
+	if max <= 0 || len(output) <= max {
+		return output, false
+	}
+	cut := max
+	for cut > 0 && !utf8.RuneStart(output[cut]) {
+		cut--
+	}
+	return fmt.Sprintf("%s\n[... truncated, %d bytes in total]", output[:cut], len(output)), true
+}
+
+// truncateProgramOutput truncates the output of a chatty program before
+// it is stored.
+func truncateProgramOutput(svc *Service) {
+	if svc.Program == nil {
+		return
+	}
+	full := svc.Program.Output
+	if output, truncated := truncateOutput(full, maxProgramOutput); truncated {
+		svc.Program.Output = output
+		if keepFullProgramOutput {
+			svc.Program.FullOutput = full
+		}
+		if debugMode {
+			log.Printf("[DEBUG] Truncated output of program %s: %d bytes", svc.Name, len(full))
+		}
+	}
+}
+
 // MonitStatus represents the complete status message from a Monit agent.
 //
 // This is the root element of the XML document sent by Monit.
@@ -66,6 +127,96 @@ type MonitStatus struct {
 	// Multiple <name> elements under <hostgroups>
 	// Example: <hostgroups><name>Workstation</name><name>FreeBSD</name></hostgroups>
 	HostGroups []string `xml:"hostgroups>name"`
+
+	// ServiceGroups contains the service groups of monitrc ("group" in a
+	// check statement), with their member services
+	ServiceGroups []ServiceGroup `xml:"servicegroups>servicegroup"`
+
+	// Event is the event of an event notification, nil for a status
+	// report. Event notifications have no services.
+	Event *Event `xml:"event,omitempty"`
+
+	// Compat lists the compatibility shims applied for an older agent
+	// release (see compat.go), empty for current releases
+	Compat []string `xml:"-"`
+}
+
+// IsEvent reports whether the document is an event notification rather
+// than a status report.
+func (ms *MonitStatus) IsEvent() bool {
+	return ms.Event != nil
+}
+
+// Event states: the outcome of the check that raised an event.
+const (
+	EventStateSucceeded  = 0 // The check passed again (recovery)
+	EventStateFailed     = 1 // The check failed
+	EventStateChanged    = 2 // A watched value changed (checksum, timestamp...)
+	EventStateChangedNot = 3 // A watched value did not change when expected
+)
+
+// Event is a Monit event notification, sent to the collector as soon as a
+// check changes state. Monit keeps events it could not deliver in its
+// event queue ("set eventqueue") and resends them until the collector
+// answers with a 2xx status.
+//
+// Example XML:
+// <event>
+//   <collected_sec>1763943569</collected_sec>
+//   <collected_usec>120000</collected_usec>
+//   <service>nginx</service>
+//   <type>3</type>
+//   <id>32</id>
+//   <state>1</state>
+//   <action>1</action>
+//   <message><![CDATA[failed protocol test [HTTP] at [localhost]:80]]></message>
+// </event>
+type Event struct {
+	CollectedSec  int64  `xml:"collected_sec"`
+	CollectedUsec int64  `xml:"collected_usec"`
+	Service       string `xml:"service"` // Service name
+	ServiceType   int    `xml:"type"`    // Service type (0-8, as in <service>)
+	ID            int    `xml:"id"`      // Event type bitmask (0x20 = Connection...)
+	State         int    `xml:"state"`   // EventStateSucceeded, EventStateFailed...
+	Action        int    `xml:"action"`  // Action taken (1 = alert, 2 = restart...)
+	Message       string `xml:"message"`
+	Token         string `xml:"token,omitempty"` // Set for events of actions requested by M/Monit
+}
+
+// CollectedAt returns when the event occurred.
+func (e *Event) CollectedAt() time.Time {
+	return time.Unix(e.CollectedSec, e.CollectedUsec*1000)
+}
+
+// Every types: how often Monit checks a service.
+const (
+	EveryCycle      = 1 // Each poll cycle (default)
+	EverySkipCycles = 2 // "every N cycles"
+	EveryCron       = 3 // "every <cron spec>": only at these times
+	EveryNotInCron  = 4 // "not every <cron spec>": except at these times
+)
+
+// Every is the check schedule of a service ("every" in monitrc).
+//
+// Example XML:
+// <every><type>2</type><counter>1</counter><number>5</number></every>
+// <every><type>3</type><cron><![CDATA[0 2 * * *]]></cron></every>
+type Every struct {
+	Type    int    `xml:"type"`    // EveryCycle, EverySkipCycles, EveryCron or EveryNotInCron
+	Counter int    `xml:"counter"` // Cycles skipped so far (EverySkipCycles)
+	Number  int    `xml:"number"`  // Checked every Number cycles (EverySkipCycles)
+	Cron    string `xml:"cron"`    // Cron spec (EveryCron, EveryNotInCron)
+}
+
+// ServiceGroup is a group of services defined in monitrc.
+//
+// Example XML:
+// <servicegroups>
+//   <servicegroup name="www"><service>nginx</service><service>php-fpm</service></servicegroup>
+// </servicegroups>
+type ServiceGroup struct {
+	Name     string   `xml:"name,attr"`
+	Services []string `xml:"service"`
 }
 
 // Server represents information about the Monit agent/daemon.
@@ -279,6 +430,15 @@ type Service struct {
 	// >0 = action number (restart, stop, etc.)
 	PendingAction int `xml:"pendingaction"`
 
+	// Every is how often Monit checks the service ("every" in monitrc)
+	// nil when the agent does not report it (checked every cycle)
+	Every *Every `xml:"every,omitempty"`
+
+	// Depends lists the services this one depends on ("depends on" in
+	// monitrc): Monit stops this service before them, and starts it after
+	// Example XML: <depend>nginx</depend><depend>php-fpm</depend>
+	Depends []string `xml:"depend"`
+
 	// System contains system-level metrics (CPU, memory, load, swap)
 	// Only present when Type == 5 (system service)
 	// xml:",omitempty" means: if this is nil/empty, don't include it in output
@@ -309,6 +469,10 @@ type Service struct {
 	// Only present when Type == 2 (file service)
 	File *FileInfo `xml:",omitempty"`
 
+	// Path contains directory and FIFO information (permissions, timestamps)
+	// Only present when Type == 1 (directory) or Type == 6 (FIFO)
+	Path *PathInfo `xml:"-"`
+
 	// Filesystem fields (for type 0 - filesystem services)
 	// These are directly in the <service> element, not nested
 	FSType   *string                   `xml:"fstype,omitempty"`
@@ -324,18 +488,22 @@ type Service struct {
 	Link     *NetworkLink              `xml:"link,omitempty"`
 
 	// Remote Host monitoring fields (for type 4 - remote host services)
+	// A service can define several checks of each kind in monitrc
+	// (e.g. "if failed port 80" and "if failed port 443"): Monit then
+	// reports one element per check, in the order of monitrc
+	//
 	// ICMP contains ping monitoring information
 	// Only present when Type == 4 (remote host) with ICMP checks
-	ICMP *ICMPInfo `xml:"icmp,omitempty"`
+	ICMP []ICMPInfo `xml:"icmp,omitempty"`
 
 	// Port contains TCP/UDP port monitoring information
 	// Present when Type == 4 (remote host) with port checks
 	// Also present when Type == 3 (process) with port checks
-	Port *PortInfo `xml:"port,omitempty"`
+	Port []PortInfo `xml:"port,omitempty"`
 
 	// Unix contains Unix domain socket monitoring information
 	// Only present when Type == 3 (process) with unix socket checks
-	Unix *UnixSocketInfo `xml:"unix,omitempty"`
+	Unix []UnixSocketInfo `xml:"unix,omitempty"`
 }
 
 // SystemMetrics contains system-level performance metrics.
@@ -418,13 +586,27 @@ type CPUUsage struct {
 	// "nice" is a Unix command to run processes with lower priority
 	Nice float64 `xml:"nice"`
 
-	// HardIRQ is % of time handling hardware interrupts
-	// (only on some systems, mainly Linux)
-	HardIRQ float64 `xml:"hardirq"`
-
 	// Wait is % of time waiting for I/O operations
 	// High wait = bottleneck in disk or network
 	Wait float64 `xml:"wait"`
+
+	// The following are only reported on some systems, mainly Linux
+	// nil when the agent does not report them
+
+	// HardIRQ is % of time handling hardware interrupts
+	HardIRQ *float64 `xml:"hardirq,omitempty"`
+
+	// SoftIRQ is % of time handling software interrupts
+	SoftIRQ *float64 `xml:"softirq,omitempty"`
+
+	// Steal is % of time stolen by the hypervisor for other guests
+	Steal *float64 `xml:"steal,omitempty"`
+
+	// Guest is % of time running a virtual CPU for guests
+	Guest *float64 `xml:"guest,omitempty"`
+
+	// GuestNice is % of time running a niced guest
+	GuestNice *float64 `xml:"guestnice,omitempty"`
 }
 
 // MemoryUsage contains RAM usage information.
@@ -554,7 +736,12 @@ type ProgramInfo struct {
 	// Output is the program's output (stdout)
 	// <![CDATA[...]]> means "this is raw text, don't parse as XML"
 	// Useful when output might contain <> characters
+	// Truncated by ParseMonitXML beyond the limit of SetProgramOutputLimit
 	Output string `xml:"output"`
+
+	// FullOutput is the whole output when Output was truncated and the
+	// full output is kept (see SetProgramOutputLimit), empty otherwise
+	FullOutput string `xml:"-"`
 }
 
 // FileInfo contains file-specific information.
@@ -598,6 +785,33 @@ type FileInfo struct {
 	Checksum FileChecksum `xml:"checksum"`
 }
 
+// PathInfo contains directory and FIFO information.
+//
+// Only present for directory (type 1) and FIFO (type 6) services.
+//
+// Example XML:
+// <mode>755</mode>
+// <uid>0</uid>
+// <gid>0</gid>
+// <timestamps>
+//   <access>1763943569</access>
+//   <change>1763943568</change>
+//   <modify>1763943568</modify>
+// </timestamps>
+type PathInfo struct {
+	// Mode is the Unix permissions (octal format)
+	Mode string
+
+	// UID is the user ID that owns the directory or FIFO
+	UID int
+
+	// GID is the group ID that owns the directory or FIFO
+	GID int
+
+	// Timestamps contains access, change, and modify times
+	Timestamps FileTimestamps
+}
+
 // ICMPInfo contains ICMP (ping) monitoring information.
 //
 // Only present for Remote Host services (type 4) with ICMP checks.
@@ -750,7 +964,7 @@ type FilesystemIO struct {
 
 // FilesystemBytes contains byte transfer statistics.
 type FilesystemBytes struct {
-	// Count is the bytes transferred in the current interval
+	// Count is the bytes transferred per second over the last cycle
 	Count int64 `xml:"count"`
 
 	// Total is the total bytes transferred since system boot
@@ -759,7 +973,7 @@ type FilesystemBytes struct {
 
 // FilesystemOperations contains I/O operation count statistics.
 type FilesystemOperations struct {
-	// Count is the number of operations in the current interval
+	// Count is the number of operations per second over the last cycle
 	Count int64 `xml:"count"`
 
 	// Total is the total number of operations since system boot
@@ -886,16 +1100,20 @@ func (s *Service) StatusMessage() string {
 // then ToService() converts them to the correct nested structure based on Type.
 type ServiceXML struct {
 	// Common fields (all service types)
-	Type          int    `xml:"type"`          // Element: <type>5</type>
-	Name          string `xml:"name,attr"`     // Attribute: <service name="bigone">
-	CollectedSec  int64  `xml:"collected_sec"`
-	CollectedUsec int64  `xml:"collected_usec"`
-	Status        int    `xml:"status"`
-	StatusHint    int    `xml:"status_hint"`
-	Monitor       int    `xml:"monitor"`
-	MonitorMode   int    `xml:"monitormode"`
-	OnReboot      int    `xml:"onreboot"`
-	PendingAction int    `xml:"pendingaction"`
+	Type          int      `xml:"type"`      // Element: <type>5</type>
+	Name          string   `xml:"name,attr"` // Attribute: <service name="bigone">
+	NameElement   string   `xml:"name"`      // Element: <name>bigone</name> (_status format)
+	TypeAttr      *int     `xml:"type,attr"` // Attribute: <service type="5"> (_status format)
+	CollectedSec  int64    `xml:"collected_sec"`
+	CollectedUsec int64    `xml:"collected_usec"`
+	Status        int      `xml:"status"`
+	StatusHint    int      `xml:"status_hint"`
+	Monitor       int      `xml:"monitor"`
+	MonitorMode   int      `xml:"monitormode"`
+	OnReboot      int      `xml:"onreboot"`
+	PendingAction int      `xml:"pendingaction"`
+	Every         *Every   `xml:"every,omitempty"`
+	Depends       []string `xml:"depend"`
 
 	// Flat fields used by file (type 2), filesystem (type 0), and process (type 3)
 	// These conflict - same XML tags used for different purposes
@@ -912,6 +1130,7 @@ type ServiceXML struct {
 	// Filesystem-specific fields
 	FSType   *string           `xml:"fstype,omitempty"`  // Filesystem type
 	FSFlags  *string           `xml:"fsflags,omitempty"` // Filesystem flags
+	Flags    *int64            `xml:"flags,omitempty"`   // Numeric filesystem flags (before Monit 5.18)
 	Block    *FilesystemBlock  `xml:"block,omitempty"`   // Filesystem blocks
 	Inode    *FilesystemInode  `xml:"inode,omitempty"`   // Filesystem inodes
 	ReadIO   *FilesystemIO     `xml:"read,omitempty"`    // Filesystem read IO
@@ -934,17 +1153,26 @@ type ServiceXML struct {
 	Link    *NetworkLink   `xml:"link,omitempty"`
 
 	// Remote host monitoring fields (for type 4 and type 3 with checks)
-	ICMP    *ICMPInfo        `xml:"icmp,omitempty"`
-	Port    *PortInfo        `xml:"port,omitempty"`
-	Unix    *UnixSocketInfo  `xml:"unix,omitempty"`
+	ICMP    []ICMPInfo       `xml:"icmp,omitempty"`
+	Port    []PortInfo       `xml:"port,omitempty"`
+	Unix    []UnixSocketInfo `xml:"unix,omitempty"`
 }
 
 // ToService converts the flat ServiceXML to the domain Service struct.
 // It populates the correct nested structures based on the service Type.
 func (sx *ServiceXML) ToService() Service {
+	// The agent's _status page has <service type="N"><name>...</name>
+	name, typ := sx.Name, sx.Type
+	if name == "" {
+		name = sx.NameElement
+	}
+	if sx.TypeAttr != nil {
+		typ = *sx.TypeAttr
+	}
+
 	s := Service{
-		Type:          sx.Type,
-		Name:          sx.Name,
+		Type:          typ,
+		Name:          name,
 		CollectedSec:  sx.CollectedSec,
 		CollectedUsec: sx.CollectedUsec,
 		Status:        sx.Status,
@@ -953,6 +1181,8 @@ func (sx *ServiceXML) ToService() Service {
 		MonitorMode:   sx.MonitorMode,
 		OnReboot:      sx.OnReboot,
 		PendingAction: sx.PendingAction,
+		Every:         sx.Every,
+		Depends:       sx.Depends,
 		System:        sx.System,
 		Program:       sx.Program,
 		Link:          sx.Link,
@@ -961,7 +1191,7 @@ func (sx *ServiceXML) ToService() Service {
 		Unix:          sx.Unix,
 	}
 
-	switch sx.Type {
+	switch typ {
 	case 0: // Filesystem
 		s.FSType = sx.FSType
 		s.FSFlags = sx.FSFlags
@@ -986,6 +1216,16 @@ func (sx *ServiceXML) ToService() Service {
 			}
 		}
 
+	case 1, 6: // Directory, FIFO
+		if sx.Mode != nil || sx.UID != nil || sx.GID != nil {
+			s.Path = &PathInfo{
+				Mode:       getStringValue(sx.Mode),
+				UID:        getIntValue(sx.UID),
+				GID:        getIntValue(sx.GID),
+				Timestamps: getFileTimestamps(sx.Timestamps),
+			}
+		}
+
 	case 3: // Process
 		s.PID = sx.PID
 		s.PPID = sx.PPID
@@ -1039,43 +1279,6 @@ func getFileChecksum(p *FileChecksum) FileChecksum {
 	return FileChecksum{}
 }
 
-// MonitStatusXML is the XML proxy for the root monit element.
-// It uses ServiceXML instead of Service to handle the flat XML structure.
-//
-// The Monit collector API sends XML with attributes on the <monit> element:
-//   <monit id="..." incarnation="..." version="...">
-// while the HTTP API (?format=xml) sends them as nested elements in <server>.
-// We handle both formats by accepting them as optional attributes.
-type MonitStatusXML struct {
-	XMLName     xml.Name     `xml:"monit"`
-	ID          string       `xml:"id,attr,omitempty"`          // Optional: collector format
-	Incarnation string       `xml:"incarnation,attr,omitempty"` // Optional: collector format
-	Version     string       `xml:"version,attr,omitempty"`     // Optional: collector format
-	Server      Server       `xml:"server"`
-	Platform    Platform     `xml:"platform"`
-	ServicesWrapper struct {
-		Services []ServiceXML `xml:"service"`
-	} `xml:"services"`  // Monit sends services wrapped in <services> element
-	HostGroups  []string     `xml:"hostgroups>name"` // Host groups: <hostgroups><name>...</name></hostgroups>
-}
-
-// ToMonitStatus converts MonitStatusXML to the domain MonitStatus struct.
-func (msx *MonitStatusXML) ToMonitStatus() *MonitStatus {
-	ms := &MonitStatus{
-		Server:     msx.Server,
-		Platform:   msx.Platform,
-		Services:   make([]Service, len(msx.ServicesWrapper.Services)),
-		HostGroups: msx.HostGroups,
-	}
-
-	for i, svcXML := range msx.ServicesWrapper.Services {
-		ms.Services[i] = svcXML.ToService()
-	}
-
-	return ms
-}
-
-
 // ParseMonitXML parses Monit XML status data into a MonitStatus struct.
 //
 // This function takes raw XML bytes (from an HTTP request body) and
@@ -1089,9 +1292,10 @@ func (msx *MonitStatusXML) ToMonitStatus() *MonitStatus {
 //   - error: nil if successful, error describing problem if failed
 //
 // How it works:
-// 1. Create an empty MonitStatus struct
-// 2. Use xml.Unmarshal() to parse the XML into the struct
-// 3. The struct tags (like `xml:"monit"`) tell Unmarshal how to map XML to fields
+// 1. Read the tokens of the document, checking the limits (see SetLimits)
+// 2. Decode each <service> element into a ServiceXML proxy struct, whose
+//    struct tags (like `xml:"type"`) tell the decoder how to map XML to fields
+// 3. Convert it to a Service as soon as it is decoded (see ToService)
 // 4. Return the populated struct or an error
 //
 // Example usage:
@@ -1103,63 +1307,87 @@ func (msx *MonitStatusXML) ToMonitStatus() *MonitStatus {
 //   }
 //   fmt.Printf("Host: %s\n", status.Server.LocalHostname)
 func ParseMonitXML(data []byte) (*MonitStatus, error) {
-	// Handle encoding declaration issue
+	// Handle the encoding declaration
 	//
 	// Monit sends XML with: <?xml version="1.0" encoding="ISO-8859-1"?>
-	// Go's xml.Unmarshal() only handles UTF-8 natively.
-	//
-	// However, Monit's actual content is ASCII-compatible (doesn't use
-	// extended ISO-8859-1 characters like é, ñ, etc. in practice).
-	//
-	// Solution: Replace "ISO-8859-1" with "UTF-8" in the XML declaration.
-	// This works because:
-	// 1. ASCII is a subset of both ISO-8859-1 and UTF-8
-	// 2. Monit's data is actually ASCII (service names, hostnames, etc.)
-	// 3. Even if there are extended characters, they'll parse correctly
-	//
-	// bytes.ReplaceAll() replaces all occurrences of a byte slice
-	//   - Parameters: (data, old, new)
-	//   - Returns: modified copy of data
-	//
-	// Note: This is safe because we're creating a copy, not modifying the original
-
-	// DEBUG: Log first 500 bytes of XML before processing
-	xmlPreview := string(data)
-	if len(xmlPreview) > 500 {
-		xmlPreview = xmlPreview[:500]
+	// Go's encoding/xml only handles UTF-8 natively: other encodings go
+	// through the decoder's CharsetReader (see charsetReader), which
+	// transcodes extended characters (é, ñ...) of hostnames and program
+	// output to UTF-8.
+
+	// Log the first 500 bytes of the XML before processing (the collector
+	// saves the whole document in debug mode)
+	if debugMode {
+		xmlPreview := string(data)
+		if len(xmlPreview) > 500 {
+			xmlPreview = xmlPreview[:500]
+		}
+		log.Printf("[DEBUG] Received XML (first 500 bytes): %s", xmlPreview)
 	}
-	log.Printf("[DEBUG] Received XML (first 500 bytes): %s", xmlPreview)
 
-	// DEBUG: Save full XML to file for analysis
-	os.WriteFile("/tmp/cmonit-received-xml.xml", data, 0644)
-
-	data = bytes.ReplaceAll(data, []byte("ISO-8859-1"), []byte("UTF-8"))
+	// Decode the services one at a time, as the tokens are read: see
+	// decodeStatus
+	status, err := decodeStatus(data, limits)
+	if err != nil {
+		return nil, err
+	}
 
-	// Alternative approach (if ReplaceAll doesn't work):
-	// We could also just remove the encoding declaration entirely:
-	// data = bytes.ReplaceAll(data, []byte(` encoding="ISO-8859-1"`), []byte(""))
+	if debugMode {
+		log.Printf("[DEBUG] Decoded %d services from XML", len(status.Services))
+		if len(status.Compat) > 0 {
+			log.Printf("[DEBUG] Monit %s compatibility: %s", status.Server.Version, strings.Join(status.Compat, "; "))
+		}
+	}
 
-	// PHASE 1: Unmarshal to proxy struct (MonitStatusXML)
-	// This captures Monit's flat XML structure where fields like uid, gid, mode
-	// appear directly in <service> elements for all service types.
-	var statusXML MonitStatusXML
+	return status, nil
+}
 
-	err := xml.Unmarshal(data, &statusXML)
-	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal XML: %w", err)
+// charsetReader returns a UTF-8 reader of input, declared in the charset
+// label by the XML document. Monit declares ISO-8859-1 but copies bytes
+// such as program output as they are, which are UTF-8 on most systems:
+// a document that is valid UTF-8 is thus read as is, and only transcoded
+// from ISO-8859-1 otherwise.
+func charsetReader(label string, input io.Reader) (io.Reader, error) {
+	switch {
+	case isLatin1(label):
+		data, err := io.ReadAll(input)
+		if err != nil {
+			return nil, err
+		}
+		if utf8.Valid(data) {
+			return bytes.NewReader(data), nil
+		}
+		return charmap.ISO8859_1.NewDecoder().Reader(bytes.NewReader(data)), nil
+	case isASCII(label):
+		return input, nil
 	}
+	return nil, fmt.Errorf("unsupported charset %q", label)
+}
 
-	// DEBUG: Log how many services were parsed
-	log.Printf("[DEBUG] Proxy unmarshal: parsed %d services from XML", len(statusXML.ServicesWrapper.Services))
-
-	// PHASE 2: Convert proxy to domain model (MonitStatus)
-	// ToMonitStatus() creates the proper nested structures (File, FileInfo, etc.)
-	// based on service Type field, resolving field conflicts.
-	status := statusXML.ToMonitStatus()
+// utf8CharsetReader is the CharsetReader of the documents already known
+// to be valid UTF-8, which are read as they are, without the copy of
+// charsetReader.
+func utf8CharsetReader(label string, input io.Reader) (io.Reader, error) {
+	if isLatin1(label) || isASCII(label) {
+		return input, nil
+	}
+	return nil, fmt.Errorf("unsupported charset %q", label)
+}
 
-	log.Printf("[DEBUG] After ToMonitStatus conversion: %d services", len(status.Services))
+func isLatin1(label string) bool {
+	switch strings.ToLower(label) {
+	case "iso-8859-1", "iso8859-1", "iso_8859-1", "latin1", "l1":
+		return true
+	}
+	return false
+}
 
-	return status, nil
+func isASCII(label string) bool {
+	switch strings.ToLower(label) {
+	case "us-ascii", "ascii":
+		return true
+	}
+	return false
 }
 
 // GetCollectedTime converts the collected timestamp to a time.Time.
@@ -7,7 +7,9 @@
 package parser
 
 import (
-	"os"      // File operations
+	"errors"
+	"os" // File operations
+	"strings"
 	"testing" // Testing framework
 )
 
@@ -118,3 +120,444 @@ func TestParseMonitXML(t *testing.T) {
 		}
 	}
 }
+
+// TestParseMonitXMLCharset checks that extended characters of the
+// ISO-8859-1 documents of Monit are transcoded to UTF-8, and that UTF-8
+// bytes copied by Monit despite the declaration are kept.
+func TestParseMonitXMLCharset(t *testing.T) {
+	tests := []struct {
+		name     string
+		hostname string // As sent, in the document's bytes
+		want     string
+	}{
+		{"latin1", "caf\xe9-\xf1u", "café-ñu"},
+		{"utf8", "café-ñu", "café-ñu"},
+	}
+	for _, tt := range tests {
+		data := []byte(`<?xml version="1.0" encoding="ISO-8859-1"?>` +
+			`<monit><server><localhostname>` + tt.hostname + `</localhostname></server></monit>`)
+		status, err := ParseMonitXML(data)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if status.Server.LocalHostname != tt.want {
+			t.Errorf("%s: hostname = %q, want %q", tt.name, status.Server.LocalHostname, tt.want)
+		}
+	}
+}
+
+func TestDiagnose(t *testing.T) {
+	data := []byte(`<?xml version="1.0" encoding="ISO-8859-1"?>` +
+		`<monit><server><id>abc</id><poll>30</poll><localhostname>h</localhostname></server><services>` +
+		`<service name="h"><type>5</type><collected_sec>1</collected_sec><system><load><avg01>1</avg01></load></system></service>` +
+		`<service name="web"><type>3</type><collected_sec>1</collected_sec><monitor>1</monitor><pid>1</pid><depend>db</depend></service>` +
+		`<service name="x"><type>42</type><collected_sec>1</collected_sec></service>` +
+		`</services></monit>`)
+	status, err := ParseMonitXML(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]bool{"web": false, "x": false}
+	for _, w := range Diagnose(status) {
+		if _, ok := want[w.Service]; !ok {
+			t.Errorf("unexpected warning for %q: %s", w.Service, w.Message)
+			continue
+		}
+		want[w.Service] = true
+	}
+	for service, found := range want {
+		if !found {
+			t.Errorf("no warning for %q", service)
+		}
+	}
+}
+
+// parseServices parses a Monit collector document with the given
+// <service> elements and extra elements of <monit>.
+func parseServices(t *testing.T, services, extra string) *MonitStatus {
+	t.Helper()
+	data := []byte(`<?xml version="1.0" encoding="ISO-8859-1"?>` +
+		`<monit id="abc"><server><id>abc</id><incarnation>1763943000</incarnation><version>5.35.2</version>` +
+		`<poll>30</poll><localhostname>h</localhostname></server>` +
+		`<services>` + services + `</services>` + extra + `</monit>`)
+	status, err := ParseMonitXML(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+// TestParseMonitXMLServiceGroups checks the service groups of monitrc.
+func TestParseMonitXMLServiceGroups(t *testing.T) {
+	status := parseServices(t,
+		`<service name="nginx"><type>3</type><collected_sec>1763943569</collected_sec></service>`+
+			`<service name="php-fpm"><type>3</type><collected_sec>1763943569</collected_sec></service>`,
+		`<servicegroups>`+
+			`<servicegroup name="www"><service>nginx</service><service>php-fpm</service></servicegroup>`+
+			`<servicegroup name="proxy"><service>nginx</service></servicegroup>`+
+			`</servicegroups>`)
+
+	if len(status.ServiceGroups) != 2 {
+		t.Fatalf("got %d service groups, want 2", len(status.ServiceGroups))
+	}
+	www := status.ServiceGroups[0]
+	if www.Name != "www" || len(www.Services) != 2 || www.Services[0] != "nginx" || www.Services[1] != "php-fpm" {
+		t.Errorf("www = %+v, want nginx and php-fpm", www)
+	}
+	proxy := status.ServiceGroups[1]
+	if proxy.Name != "proxy" || len(proxy.Services) != 1 || proxy.Services[0] != "nginx" {
+		t.Errorf("proxy = %+v, want nginx", proxy)
+	}
+}
+
+// TestParseMonitXMLEvery checks the check schedule of services, nil when
+// the agent does not report it.
+func TestParseMonitXMLEvery(t *testing.T) {
+	status := parseServices(t,
+		`<service name="backup"><type>7</type><collected_sec>1763943569</collected_sec>`+
+			`<every><type>2</type><counter>1</counter><number>5</number></every></service>`+
+			`<service name="nightly"><type>7</type><collected_sec>1763943569</collected_sec>`+
+			`<every><type>3</type><cron><![CDATA[0 2 * * *]]></cron></every></service>`+
+			`<service name="sshd"><type>3</type><collected_sec>1763943569</collected_sec></service>`, "")
+
+	if len(status.Services) != 3 {
+		t.Fatalf("got %d services, want 3", len(status.Services))
+	}
+	backup := status.Services[0].Every
+	if backup == nil || backup.Type != EverySkipCycles || backup.Counter != 1 || backup.Number != 5 {
+		t.Errorf("backup every = %+v, want every 5 cycles, 1 skipped", backup)
+	}
+	nightly := status.Services[1].Every
+	if nightly == nil || nightly.Type != EveryCron || nightly.Cron != "0 2 * * *" {
+		t.Errorf("nightly every = %+v, want cron 0 2 * * *", nightly)
+	}
+	if every := status.Services[2].Every; every != nil {
+		t.Errorf("sshd every = %+v, want nil", every)
+	}
+}
+
+// TestParseMonitXMLDepends checks the dependencies of services.
+func TestParseMonitXMLDepends(t *testing.T) {
+	status := parseServices(t,
+		`<service name="nginx"><type>3</type><collected_sec>1763943569</collected_sec>`+
+			`<depend>php-fpm</depend><depend>rootfs</depend></service>`+
+			`<service name="php-fpm"><type>3</type><collected_sec>1763943569</collected_sec></service>`, "")
+
+	if len(status.Services) != 2 {
+		t.Fatalf("got %d services, want 2", len(status.Services))
+	}
+	depends := status.Services[0].Depends
+	if len(depends) != 2 || depends[0] != "php-fpm" || depends[1] != "rootfs" {
+		t.Errorf("nginx depends = %q, want php-fpm and rootfs", depends)
+	}
+	if depends := status.Services[1].Depends; len(depends) != 0 {
+		t.Errorf("php-fpm depends = %q, want none", depends)
+	}
+}
+
+// TestParseMonitXMLFilesystemIO checks the read and write rates (per
+// second over the last cycle) and totals of filesystem services.
+func TestParseMonitXMLFilesystemIO(t *testing.T) {
+	status := parseServices(t,
+		`<service name="rootfs"><type>0</type><collected_sec>1763943569</collected_sec>`+
+			`<fstype>zfs</fstype><fsflags>local, noatime</fsflags><mode>755</mode><uid>0</uid><gid>0</gid>`+
+			`<block><percent>90.5</percent><usage>27226910.4</usage><total>30089135.8</total></block>`+
+			`<inode><percent>0.0</percent><usage>803919</usage><total>5862641503</total></inode>`+
+			`<read><bytes><count>4096</count><total>123456789</total></bytes>`+
+			`<operations><count>12</count><total>98765</total></operations></read>`+
+			`<write><bytes><count>8192</count><total>223456789</total></bytes>`+
+			`<operations><count>3</count><total>45678</total></operations></write></service>`, "")
+
+	if len(status.Services) != 1 {
+		t.Fatalf("got %d services, want 1", len(status.Services))
+	}
+	fs := status.Services[0]
+	if fs.ReadIO == nil || fs.WriteIO == nil {
+		t.Fatalf("read = %v, write = %v, want both", fs.ReadIO, fs.WriteIO)
+	}
+	tests := []struct {
+		name      string
+		got, want int64
+	}{
+		{"read bytes/s", fs.ReadIO.Bytes.Count, 4096},
+		{"read bytes", fs.ReadIO.Bytes.Total, 123456789},
+		{"read ops/s", fs.ReadIO.Operations.Count, 12},
+		{"read ops", fs.ReadIO.Operations.Total, 98765},
+		{"write bytes/s", fs.WriteIO.Bytes.Count, 8192},
+		{"write bytes", fs.WriteIO.Bytes.Total, 223456789},
+		{"write ops/s", fs.WriteIO.Operations.Count, 3},
+		{"write ops", fs.WriteIO.Operations.Total, 45678},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %d, want %d", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+// TestParseMonitXMLPath checks the details of directory (type 1) and FIFO
+// (type 6) services.
+func TestParseMonitXMLPath(t *testing.T) {
+	status := parseServices(t,
+		`<service name="spool"><type>1</type><collected_sec>1763943569</collected_sec>`+
+			`<mode>755</mode><uid>0</uid><gid>5</gid>`+
+			`<timestamps><access>1763943560</access><change>1763943561</change><modify>1763943562</modify></timestamps></service>`+
+			`<service name="logpipe"><type>6</type><collected_sec>1763943569</collected_sec>`+
+			`<mode>620</mode><uid>1001</uid><gid>1001</gid></service>`, "")
+
+	if len(status.Services) != 2 {
+		t.Fatalf("got %d services, want 2", len(status.Services))
+	}
+	dir := status.Services[0].Path
+	if dir == nil {
+		t.Fatal("directory without path details")
+	}
+	if dir.Mode != "755" || dir.UID != 0 || dir.GID != 5 {
+		t.Errorf("directory = %+v, want mode 755, uid 0, gid 5", dir)
+	}
+	if dir.Timestamps.Access != 1763943560 || dir.Timestamps.Change != 1763943561 || dir.Timestamps.Modify != 1763943562 {
+		t.Errorf("directory timestamps = %+v", dir.Timestamps)
+	}
+	fifo := status.Services[1].Path
+	if fifo == nil || fifo.Mode != "620" || fifo.UID != 1001 || fifo.GID != 1001 {
+		t.Errorf("fifo = %+v, want mode 620, uid and gid 1001", fifo)
+	}
+	if status.Services[1].File != nil {
+		t.Errorf("fifo parsed as a file: %+v", status.Services[1].File)
+	}
+}
+
+// TestParseMonitXMLCPUBreakdown checks the CPU times only some systems
+// report: absent ones stay nil, so that they are not stored as zero.
+func TestParseMonitXMLCPUBreakdown(t *testing.T) {
+	status := parseServices(t,
+		`<service name="linux"><type>5</type><collected_sec>1763943569</collected_sec><system>`+
+			`<load><avg01>0.50</avg01><avg05>0.40</avg05><avg15>0.30</avg15></load>`+
+			`<cpu><user>12.5</user><system>3.2</system><nice>0.1</nice><wait>0.8</wait>`+
+			`<hardirq>0.2</hardirq><softirq>0.4</softirq><steal>1.5</steal><guest>2.0</guest><guestnice>0.0</guestnice></cpu>`+
+			`</system></service>`+
+			`<service name="freebsd"><type>5</type><collected_sec>1763943569</collected_sec><system>`+
+			`<cpu><user>10.0</user><system>2.0</system><nice>0.0</nice><wait>0.0</wait></cpu>`+
+			`</system></service>`, "")
+
+	if len(status.Services) != 2 || status.Services[0].System == nil || status.Services[1].System == nil {
+		t.Fatalf("got %d services, want 2 with system metrics", len(status.Services))
+	}
+
+	linux := status.Services[0].System.CPU
+	if linux.User != 12.5 || linux.System != 3.2 || linux.Wait != 0.8 {
+		t.Errorf("linux cpu = %+v", linux)
+	}
+	tests := []struct {
+		name string
+		got  *float64
+		want float64
+	}{
+		{"hardirq", linux.HardIRQ, 0.2},
+		{"softirq", linux.SoftIRQ, 0.4},
+		{"steal", linux.Steal, 1.5},
+		{"guest", linux.Guest, 2.0},
+		{"guestnice", linux.GuestNice, 0.0},
+	}
+	for _, tt := range tests {
+		if tt.got == nil {
+			t.Errorf("linux %s is nil, want %v", tt.name, tt.want)
+		} else if *tt.got != tt.want {
+			t.Errorf("linux %s = %v, want %v", tt.name, *tt.got, tt.want)
+		}
+	}
+
+	freebsd := status.Services[1].System.CPU
+	for name, v := range map[string]*float64{
+		"hardirq": freebsd.HardIRQ, "softirq": freebsd.SoftIRQ, "steal": freebsd.Steal,
+		"guest": freebsd.Guest, "guestnice": freebsd.GuestNice,
+	} {
+		if v != nil {
+			t.Errorf("freebsd %s = %v, want nil (not reported)", name, *v)
+		}
+	}
+}
+
+// TestParseMonitXMLEvent checks that event notifications are told apart
+// from status reports.
+func TestParseMonitXMLEvent(t *testing.T) {
+	data := []byte(`<?xml version="1.0" encoding="ISO-8859-1"?>` +
+		`<monit id="abc"><server><id>abc</id><incarnation>1763943000</incarnation><version>5.35.2</version>` +
+		`<poll>30</poll><localhostname>h</localhostname></server>` +
+		`<platform><name>FreeBSD</name></platform>` +
+		`<event><collected_sec>1763943569</collected_sec><collected_usec>120000</collected_usec>` +
+		`<service>nginx</service><type>3</type><id>32</id><state>1</state><action>1</action>` +
+		`<message><![CDATA[failed protocol test [HTTP] at [localhost]:80]]></message></event></monit>`)
+	status, err := ParseMonitXML(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.IsEvent() {
+		t.Fatal("event notification parsed as a status report")
+	}
+	e := status.Event
+	if e.Service != "nginx" || e.ServiceType != 3 || e.ID != 0x20 || e.State != EventStateFailed || e.Action != 1 {
+		t.Errorf("event = %+v", e)
+	}
+	if e.Message != "failed protocol test [HTTP] at [localhost]:80" {
+		t.Errorf("message = %q", e.Message)
+	}
+	if got := e.CollectedAt(); got.Unix() != 1763943569 || got.Nanosecond() != 120000000 {
+		t.Errorf("collected at %v", got)
+	}
+	if len(status.Services) != 0 {
+		t.Errorf("event with %d services", len(status.Services))
+	}
+
+	report := parseServices(t, `<service name="nginx"><type>3</type><collected_sec>1763943569</collected_sec></service>`, "")
+	if report.IsEvent() {
+		t.Error("status report parsed as an event notification")
+	}
+}
+
+// TestValidate checks the problems reported by the strict mode.
+func TestValidate(t *testing.T) {
+	valid := parseServices(t,
+		`<service name="h"><type>5</type><collected_sec>1</collected_sec><monitor>1</monitor>`+
+			`<system><load><avg01>0.5</avg01></load><cpu><user>-1</user></cpu><memory><percent>40</percent></memory></system></service>`+
+			`<service name="rootfs"><type>0</type><collected_sec>1</collected_sec><block><percent>90.5</percent></block><inode><percent>1</percent></inode></service>`, "")
+	if err := Validate(valid); err != nil {
+		t.Errorf("valid document rejected: %v", err)
+	}
+
+	data := []byte(`<?xml version="1.0" encoding="ISO-8859-1"?>` +
+		`<monit><server><poll>30</poll><localhostname>h</localhostname></server><services>` +
+		`<service name="rootfs"><type>0</type><collected_sec>1</collected_sec><block><percent>140</percent></block></service>` +
+		`<service><type>3</type></service>` +
+		`<service name="web"><type>3</type><monitor>7</monitor><port><portnumber>70000</portnumber></port></service>` +
+		`</services></monit>`)
+	status, err := ParseMonitXML(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var verr *ValidationError
+	if !errors.As(Validate(status), &verr) {
+		t.Fatal("invalid document accepted")
+	}
+	want := []string{
+		"missing <server><id>",
+		`service "rootfs": <block><percent> 140.0 out of range 0-100`,
+		"service #2 (type 3) has no name",
+		`service "web": <monitor> 7 out of range 0-2`,
+		`service "web": <port><portnumber> 70000 out of range 0-65535`,
+	}
+	if strings.Join(verr.Problems, "\n") != strings.Join(want, "\n") {
+		t.Errorf("problems = %q, want %q", verr.Problems, want)
+	}
+}
+
+// TestParseMonitXMLMultipleChecks checks that every port, icmp and unix
+// test of a service is kept, in monitrc order.
+func TestParseMonitXMLMultipleChecks(t *testing.T) {
+	status := parseServices(t,
+		`<service name="gateway"><type>4</type><collected_sec>1763943569</collected_sec>`+
+			`<icmp><type>Ping</type><responsetime>0.000348</responsetime></icmp>`+
+			`<port><hostname>192.0.2.1</hostname><portnumber>80</portnumber><request><![CDATA[/]]></request>`+
+			`<protocol>HTTP</protocol><type>TCP</type><responsetime>0.001</responsetime></port>`+
+			`<port><hostname>192.0.2.1</hostname><portnumber>443</portnumber><request><![CDATA[/]]></request>`+
+			`<protocol>HTTP</protocol><type>TCP</type><responsetime>-1.000</responsetime></port></service>`+
+			`<service name="syslogd"><type>3</type><collected_sec>1763943569</collected_sec>`+
+			`<unix><path>/var/run/log</path><protocol>DEFAULT</protocol><responsetime>0.000</responsetime></unix>`+
+			`<unix><path>/var/run/logpriv</path><protocol>DEFAULT</protocol><responsetime>0.000</responsetime></unix></service>`, "")
+
+	gw := status.Services[0]
+	if len(gw.ICMP) != 1 || gw.ICMP[0].Type != "Ping" {
+		t.Errorf("icmp = %+v", gw.ICMP)
+	}
+	if len(gw.Port) != 2 || gw.Port[0].PortNumber != 80 || gw.Port[1].PortNumber != 443 || gw.Port[1].ResponseTime != -1 {
+		t.Errorf("ports = %+v", gw.Port)
+	}
+	if sock := status.Services[1].Unix; len(sock) != 2 || sock[0].Path != "/var/run/log" || sock[1].Path != "/var/run/logpriv" {
+		t.Errorf("unix sockets = %+v", sock)
+	}
+}
+
+// TestParseMonitXMLProgramOutputLimit checks the truncation of the
+// program output at parse time.
+func TestParseMonitXMLProgramOutputLimit(t *testing.T) {
+	defer SetProgramOutputLimit(0, false)
+	doc := `<service name="backup"><type>7</type><collected_sec>1763943569</collected_sec>` +
+		`<program><started>1763943500</started><status>0</status><output><![CDATA[élan 0123456789]]></output></program></service>`
+
+	SetProgramOutputLimit(1, true)
+	program := parseServices(t, doc, "").Services[0].Program
+	if want := "\n[... truncated, 16 bytes in total]"; program.Output != want {
+		t.Errorf("output = %q, want %q (not cut inside é)", program.Output, want)
+	}
+	if program.FullOutput != "élan 0123456789" {
+		t.Errorf("full output = %q", program.FullOutput)
+	}
+
+	SetProgramOutputLimit(6, false)
+	program = parseServices(t, doc, "").Services[0].Program
+	if !strings.HasPrefix(program.Output, "élan \n[... truncated") || program.FullOutput != "" {
+		t.Errorf("output = %q, full output = %q", program.Output, program.FullOutput)
+	}
+
+	SetProgramOutputLimit(16, false)
+	if program = parseServices(t, doc, "").Services[0].Program; program.Output != "élan 0123456789" {
+		t.Errorf("output within the limit changed: %q", program.Output)
+	}
+}
+
+// TestParseMonitVersion checks the parsing of the agent releases.
+func TestParseMonitVersion(t *testing.T) {
+	for s, want := range map[string]MonitVersion{
+		"5.35.2":      {5, 35, 2},
+		"5.26":        {5, 26, 0},
+		"5.34.0beta1": {5, 34, 0},
+	} {
+		if v, ok := ParseMonitVersion(s); !ok || v != want {
+			t.Errorf("ParseMonitVersion(%q) = %v, %v", s, v, ok)
+		}
+	}
+	for _, s := range []string{"", "5", "x.y"} {
+		if _, ok := ParseMonitVersion(s); ok {
+			t.Errorf("ParseMonitVersion(%q) accepted", s)
+		}
+	}
+	if v := (MonitVersion{5, 17, 3}); !v.Before(5, 18) || v.Before(5, 17) {
+		t.Errorf("%v.Before() wrong", v)
+	}
+}
+
+// TestParseMonitXMLCompat checks the version of the collector format and
+// the compatibility shims of older agents.
+func TestParseMonitXMLCompat(t *testing.T) {
+	doc := func(version string) []byte {
+		return []byte(`<?xml version="1.0" encoding="ISO-8859-1"?>` +
+			`<monit id="abc" incarnation="1763943000" version="` + version + `">` +
+			`<server><poll>30</poll><localhostname>h</localhostname></server><services>` +
+			`<service name="rootfs"><type>0</type><collected_sec>1</collected_sec><flags>4096</flags></service>` +
+			`</services></monit>`)
+	}
+
+	status, err := ParseMonitXML(doc("5.17.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Server.Version != "5.17.1" {
+		t.Errorf("version = %q, want the <monit> attribute", status.Server.Version)
+	}
+	if status.Server.ID != "" || status.Server.Incarnation != 0 {
+		t.Errorf("id %q and incarnation %d taken from the attributes: host IDs would change",
+			status.Server.ID, status.Server.Incarnation)
+	}
+	if fs := status.Services[0]; fs.FSFlags == nil || *fs.FSFlags != "4096" || len(status.Compat) != 1 {
+		t.Errorf("fsflags = %v, compat = %q", fs.FSFlags, status.Compat)
+	}
+
+	status, err = ParseMonitXML(doc("5.35.2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Services[0].FSFlags != nil || len(status.Compat) != 0 {
+		t.Errorf("shim applied to 5.35.2: compat = %q", status.Compat)
+	}
+}
@@ -0,0 +1,128 @@
+package web
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxAckNoteLength matches the CHECK constraint on events.ack_note.
+const maxAckNoteLength = 1024
+
+// serviceAckedColumn is a SELECT expression over the services table that is
+// 1 when the service's most recent event has been acknowledged.
+//
+// A failing service whose latest event is acknowledged no longer counts
+// against the host status color. Any newer event for the service clears
+// this, so a recovery followed by a new failure shows up again.
+const serviceAckedColumn = `COALESCE((
+			SELECT e.ack_at IS NOT NULL
+			FROM events e
+			WHERE e.host_id = services.host_id AND e.service_name = services.name
+			ORDER BY e.id DESC
+			LIMIT 1
+		), 0)`
+
+// EventAckRequest is the JSON request for acknowledging an event.
+type EventAckRequest struct {
+	EventID int64  `json:"event_id"`         // Event to acknowledge
+	Note    string `json:"note,omitempty"`   // Optional note (max 1024 characters)
+	Remove  bool   `json:"remove,omitempty"` // true = clear an existing acknowledgment
+}
+
+// HandleEventAckAPI acknowledges an event, or clears its acknowledgment.
+//
+// POST /api/v1/events/ack
+//
+// Request body: {"event_id": 42, "note": "disk replaced tomorrow"}
+// Response: {"success": true, "message": "..."}
+//
+// The acknowledgment records the web user (or "anonymous" when web
+// authentication is disabled), the time and the note. Acknowledging an
+// event that is already acknowledged replaces the previous acknowledgment.
+func HandleEventAckAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondJSON(w, ActionResponse{
+			Success: false,
+			Message: "Method not allowed",
+		}, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req EventAckRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, ActionResponse{
+			Success: false,
+			Message: "Invalid JSON",
+		}, http.StatusBadRequest)
+		return
+	}
+	if req.EventID <= 0 {
+		respondJSON(w, ActionResponse{
+			Success: false,
+			Message: "Missing event_id",
+		}, http.StatusBadRequest)
+		return
+	}
+
+	req.Note = strings.TrimSpace(req.Note)
+	if utf8.RuneCountInString(req.Note) > maxAckNoteLength {
+		respondJSON(w, ActionResponse{
+			Success: false,
+			Message: "Note too long (max 1024 characters)",
+		}, http.StatusBadRequest)
+		return
+	}
+
+	user := currentUser(r)
+	if user == "" {
+		user = "anonymous"
+	}
+
+	var err error
+	var n int64
+	if req.Remove {
+		n, err = execRowsAffected("UPDATE events SET ack_by = NULL, ack_at = NULL, ack_note = '' WHERE id = ?", req.EventID)
+	} else {
+		n, err = execRowsAffected("UPDATE events SET ack_by = ?, ack_at = ?, ack_note = ? WHERE id = ?",
+			user, time.Now(), req.Note, req.EventID)
+	}
+	if err != nil {
+		log.Printf("[ERROR] Failed to update acknowledgment of event %d: %v", req.EventID, err)
+		respondJSON(w, ActionResponse{
+			Success: false,
+			Message: "Failed to update event",
+		}, http.StatusInternalServerError)
+		return
+	}
+	if n == 0 {
+		respondJSON(w, ActionResponse{
+			Success: false,
+			Message: "Event not found",
+		}, http.StatusNotFound)
+		return
+	}
+
+	message := "Event acknowledged"
+	if req.Remove {
+		message = "Event acknowledgment removed"
+	}
+	log.Printf("[INFO] %s: event %d by %s", message, req.EventID, user)
+
+	respondJSON(w, ActionResponse{
+		Success: true,
+		Message: message,
+	}, http.StatusOK)
+}
+
+// execRowsAffected runs a statement and returns the number of rows it changed.
+func execRowsAffected(query string, args ...interface{}) (int64, error) {
+	result, err := db.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
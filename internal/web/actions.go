@@ -0,0 +1,150 @@
+package web
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ocochard/cmonit/internal/control"
+	dbpkg "github.com/ocochard/cmonit/internal/db"
+	"github.com/ocochard/cmonit/internal/parser"
+)
+
+// recentActionsLimit is the number of actions shown on the host page.
+const recentActionsLimit = 20
+
+// Verification of accepted actions: the agent's status is polled until the
+// service reaches the expected state or the timeout expires. Monit runs
+// actions on its next cycle, and start/stop programs may take a while.
+const (
+	actionVerifyInterval = 2 * time.Second
+	actionVerifyTimeout  = 60 * time.Second
+)
+
+// recordAction adds a service action sent to an agent to the history and
+// returns its ID (0 if it could not be recorded). started is when the agent
+// exchange began; err is its outcome. Accepted actions are recorded as
+// pending verification if verify is true.
+func recordAction(r *http.Request, hostID, hostname, service, action string, started time.Time, err error, verify bool) int64 {
+	a := dbpkg.ActionRecord{
+		CreatedAt:   started,
+		HostID:      hostID,
+		Hostname:    hostname,
+		Service:     service,
+		Action:      action,
+		RequestedBy: currentUser(r),
+		SourceIP:    clientIP(r),
+		Success:     err == nil,
+		LatencyMS:   time.Since(started).Milliseconds(),
+	}
+	if a.RequestedBy == "" {
+		a.RequestedBy = "anonymous"
+	}
+	if err != nil {
+		a.Message = err.Error()
+	} else if verify {
+		a.VerifyStatus = dbpkg.VerifyPending
+	}
+	id, err := dbpkg.RecordAction(db, a)
+	if err != nil {
+		log.Printf("[ERROR] %v", err)
+		return 0
+	}
+	return id
+}
+
+// verifyAction polls the agent after an accepted action and records
+// whether the service reached the expected state. before is the service
+// state when the action was sent (restart only).
+func verifyAction(client *control.MonitClient, id int64, hostname, service, action string, before *parser.Service, sent time.Time) {
+	deadline := sent.Add(actionVerifyTimeout)
+	last := "no status received"
+	for time.Now().Before(deadline) {
+		time.Sleep(actionVerifyInterval)
+		state, err := client.GetServiceState(service)
+		if err != nil {
+			last = err.Error()
+			if control.KindOf(err) == control.AuthError {
+				break
+			}
+			continue
+		}
+		last = control.DescribeState(state)
+		if control.ActionCompleted(action, before, state, time.Since(sent)) {
+			log.Printf("[INFO] Action '%s' on %s/%s confirmed after %s (%s)",
+				action, hostname, service, time.Since(sent).Round(time.Second), last)
+			if err := dbpkg.SetActionVerification(db, id, dbpkg.VerifyConfirmed, last); err != nil {
+				log.Printf("[ERROR] %v", err)
+			}
+			return
+		}
+	}
+
+	log.Printf("[WARNING] Action '%s' on %s/%s not confirmed after %s (%s)",
+		action, hostname, service, actionVerifyTimeout, last)
+	if err := dbpkg.SetActionVerification(db, id, dbpkg.VerifyTimeout, last); err != nil {
+		log.Printf("[ERROR] %v", err)
+	}
+}
+
+// ActionsResponse is the JSON response of GET /api/v1/actions.
+type ActionsResponse struct {
+	Actions []dbpkg.ActionRecord `json:"actions"`
+}
+
+// HandleActionsAPI returns the service actions recently sent to a host's
+// agent, newest first, or one action to follow its verification.
+//
+// GET /api/v1/actions?host_id=...&limit=20
+// GET /api/v1/actions/{id}
+func HandleActionsAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondJSON(w, map[string]string{"error": "Method not allowed"}, http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s := strings.TrimPrefix(r.URL.Path, "/api/actions/"); s != r.URL.Path {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			respondJSON(w, map[string]string{"error": "Invalid action ID"}, http.StatusBadRequest)
+			return
+		}
+		a, err := dbpkg.GetAction(db, id)
+		if err != nil {
+			log.Printf("[ERROR] %v", err)
+			respondJSON(w, map[string]string{"error": "Failed to get action"}, http.StatusInternalServerError)
+			return
+		}
+		if a == nil {
+			respondJSON(w, map[string]string{"error": "Action not found"}, http.StatusNotFound)
+			return
+		}
+		respondJSON(w, a, http.StatusOK)
+		return
+	}
+
+	hostID := r.URL.Query().Get("host_id")
+	if hostID == "" {
+		respondJSON(w, map[string]string{"error": "Missing host_id parameter"}, http.StatusBadRequest)
+		return
+	}
+	limit := recentActionsLimit
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > 500 {
+			respondJSON(w, map[string]string{"error": "Invalid limit (1-500)"}, http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	actions, err := dbpkg.RecentActions(db, hostID, limit)
+	if err != nil {
+		log.Printf("[ERROR] %v", err)
+		respondJSON(w, map[string]string{"error": "Failed to query actions"}, http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, ActionsResponse{Actions: actions}, http.StatusOK)
+}
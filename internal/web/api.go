@@ -5,13 +5,18 @@
 package web
 
 import (
+	"database/sql"  // Nullable columns
 	"encoding/json" // JSON encoding/decoding
+	"errors"        // Error values
+	"fmt"           // String formatting
 	"log"           // Logging
 	"net/http"      // HTTP server
 	"strconv"       // String conversion (string to int, etc.)
 	"time"          // Time handling
 
 	"github.com/ocochard/cmonit/internal/control" // Monit control API client
+	dbpkg "github.com/ocochard/cmonit/internal/db"
+	"github.com/ocochard/cmonit/internal/parser"
 )
 
 // =============================================================================
@@ -34,6 +39,10 @@ type MetricsResponse struct {
 	StartTime time.Time `json:"start_time"` // Start of time range
 	EndTime   time.Time `json:"end_time"`   // End of time range
 
+	// Aggregation applied to the series (empty for raw data)
+	Agg    string `json:"agg,omitempty"`    // avg, min or max
+	Bucket string `json:"bucket,omitempty"` // Bucket width (e.g., "5m0s")
+
 	// Metrics data
 	// Each MetricSeries contains timestamps and values for one metric
 	Metrics []MetricSeries `json:"metrics"`
@@ -46,10 +55,11 @@ type MetricsResponse struct {
 // - Timestamps: [t1, t2, t3, ...]
 // - Values: [10.5, 12.3, 15.7, ...]
 type MetricSeries struct {
-	Name       string    `json:"name"`       // Metric name (e.g., "load_avg01")
-	Type       string    `json:"type"`       // Metric type (e.g., "load", "cpu")
-	Timestamps []string  `json:"timestamps"` // ISO 8601 timestamps
-	Values     []float64 `json:"values"`     // Metric values
+	Name       string    `json:"name"`            // Metric name (e.g., "load_avg01")
+	Type       string    `json:"type"`            // Metric type (e.g., "load", "cpu")
+	Label      string    `json:"label,omitempty"` // What the series measures, when the name is not enough (e.g., "localhost:443 TCP")
+	Timestamps []string  `json:"timestamps"`      // ISO 8601 timestamps
+	Values     []float64 `json:"values"`          // Metric values
 }
 
 // MetricPoint represents a single data point.
@@ -73,9 +83,13 @@ type MetricPoint struct {
 // Query parameters:
 //   - host_id (required): Host identifier
 //   - service (required): Service name
-//   - range (optional): Time range (1h, 6h, 24h, 7d, 30d), default: 24h
+//   - range (optional): Time range (1h, 6h, 24h, 7d, 30d), default: preferred range (24h)
+//   - agg (optional): Aggregate per bucket in SQL: avg, min or max
+//   - bucket (optional): Bucket width (e.g., 5m, 1h); chosen from the range if omitted
 //
 // Returns JSON with timestamps and values for all metrics of the service.
+// When agg or bucket is set, each point is one bucket, stamped with the
+// bucket start time.
 func HandleMetricsAPI(w http.ResponseWriter, r *http.Request) {
 	// Only allow GET requests
 	if r.Method != http.MethodGet {
@@ -102,9 +116,9 @@ func HandleMetricsAPI(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Default to 24 hours if range not specified
+	// Default to the viewer's preferred range (24h unless changed)
 	if rangeStr == "" {
-		rangeStr = "24h"
+		rangeStr = loadPreferences(r).DefaultRange
 	}
 
 	// Parse time range
@@ -124,8 +138,20 @@ func HandleMetricsAPI(w http.ResponseWriter, r *http.Request) {
 	endTime := time.Now()
 	startTime := endTime.Add(-duration)
 
+	// Optional server-side aggregation
+	agg, bucket, err := parseAggregation(query.Get("agg"), query.Get("bucket"), duration)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Query metrics from database
-	metrics, err := getMetricsForService(hostID, service, startTime, endTime)
+	var metrics []MetricSeries
+	if bucket > 0 {
+		metrics, err = getAggregatedMetricsForService(hostID, service, startTime, endTime, agg, bucket)
+	} else {
+		metrics, err = getMetricsForService(hostID, service, startTime, endTime)
+	}
 	if err != nil {
 		log.Printf("[ERROR] Failed to get metrics: %v", err)
 		http.Error(w, "Failed to get metrics", http.StatusInternalServerError)
@@ -148,6 +174,10 @@ func HandleMetricsAPI(w http.ResponseWriter, r *http.Request) {
 		EndTime:   endTime,
 		Metrics:   metrics,
 	}
+	if bucket > 0 {
+		response.Agg = agg
+		response.Bucket = bucket.String()
+	}
 
 	// Set response headers for JSON
 	//
@@ -299,6 +329,112 @@ func getMetricsForService(hostID, service string, startTime, endTime time.Time)
 	return result, nil
 }
 
+// aggFunctions maps the agg query parameter to its SQL aggregate.
+var aggFunctions = map[string]string{
+	"avg": "AVG",
+	"min": "MIN",
+	"max": "MAX",
+}
+
+// parseAggregation validates the agg and bucket query parameters.
+// Returns a zero bucket when neither is set (raw samples requested).
+func parseAggregation(agg, bucketStr string, rangeDuration time.Duration) (string, time.Duration, error) {
+	if agg == "" && bucketStr == "" {
+		return "", 0, nil
+	}
+	if agg == "" {
+		agg = "avg"
+	}
+	if _, ok := aggFunctions[agg]; !ok {
+		return "", 0, errors.New("Invalid agg parameter (expected avg, min or max)")
+	}
+	if bucketStr == "" {
+		return agg, autoBucket(rangeDuration), nil
+	}
+	bucket, err := parseTimeRange(bucketStr)
+	if err != nil || bucket < time.Minute || bucket%time.Second != 0 {
+		return "", 0, errors.New("Invalid bucket parameter (minimum 1m)")
+	}
+	return agg, bucket, nil
+}
+
+// autoBuckets are the bucket widths autoBucket picks from.
+var autoBuckets = []time.Duration{
+	time.Minute, 5 * time.Minute, 15 * time.Minute, time.Hour, 6 * time.Hour, 24 * time.Hour,
+}
+
+// autoBucket returns the smallest standard bucket that keeps a range at
+// or under ~300 points per series.
+func autoBucket(rangeDuration time.Duration) time.Duration {
+	const maxPoints = 300
+	for _, b := range autoBuckets {
+		if rangeDuration/b <= maxPoints {
+			return b
+		}
+	}
+	return autoBuckets[len(autoBuckets)-1]
+}
+
+// storedTimeLayout is the text format the SQLite driver writes time.Time
+// values in (Go's time.Time.String without the monotonic clock reading).
+const storedTimeLayout = "2006-01-02 15:04:05.999999999 -0700 MST"
+
+// getAggregatedMetricsForService is getMetricsForService with values
+// grouped into fixed-width time buckets and aggregated in SQL.
+//
+// collected_at is stored as Go time text, which SQLite date functions cannot
+// parse whole. Buckets are computed from its leading "YYYY-MM-DD HH:MM:SS"
+// wall-clock part, and the UTC offset is restored in Go from the earliest
+// sample of each bucket.
+func getAggregatedMetricsForService(hostID, service string, startTime, endTime time.Time, agg string, bucket time.Duration) ([]MetricSeries, error) {
+	query := `
+		SELECT metric_type, metric_name,
+		       CAST(strftime('%s', substr(collected_at, 1, 19)) AS INTEGER) / ? AS bucket,
+		       ` + aggFunctions[agg] + `(value),
+		       MIN(collected_at)
+		FROM metrics
+		WHERE host_id = ? AND service_name = ?
+		  AND collected_at BETWEEN ? AND ?
+		GROUP BY metric_type, metric_name, bucket
+		ORDER BY metric_type, metric_name, bucket
+	`
+
+	bucketSecs := int64(bucket / time.Second)
+	rows, err := db.Query(query, bucketSecs, hostID, service, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []MetricSeries
+	for rows.Next() {
+		var metricType, metricName, firstSample string
+		var bucketIndex int64
+		var value float64
+
+		if err := rows.Scan(&metricType, &metricName, &bucketIndex, &value, &firstSample); err != nil {
+			return nil, err
+		}
+
+		ts := time.Unix(bucketIndex*bucketSecs, 0).UTC()
+		if t, err := time.Parse(storedTimeLayout, firstSample); err == nil {
+			// Bucket index is wall-clock seconds; shift back by the zone offset.
+			_, offset := t.Zone()
+			ts = ts.Add(-time.Duration(offset) * time.Second)
+		}
+
+		n := len(result)
+		if n == 0 || result[n-1].Type != metricType || result[n-1].Name != metricName {
+			result = append(result, MetricSeries{Name: metricName, Type: metricType})
+			n++
+		}
+		result[n-1].Timestamps = append(result[n-1].Timestamps, ts.Format(time.RFC3339))
+		result[n-1].Values = append(result[n-1].Values, value)
+	}
+
+	return result, rows.Err()
+}
+
 // getHostname looks up the hostname for a host ID.
 //
 // Parameters:
@@ -392,6 +528,10 @@ type ActionRequest struct {
 	HostID  string `json:"host_id"`  // Host identifier
 	Service string `json:"service"`  // Service name
 	Action  string `json:"action"`   // Action to perform (start, stop, restart, monitor, unmonitor)
+
+	// Duration makes an unmonitor temporary: monitoring is resumed after it
+	// (Go duration, e.g. "4h")
+	Duration string `json:"duration,omitempty"`
 }
 
 // ActionResponse represents the response from an action request.
@@ -410,8 +550,11 @@ type ActionRequest struct {
 //     "message": "Failed to execute action: invalid action 'foo'"
 //   }
 type ActionResponse struct {
-	Success bool   `json:"success"` // Whether the action succeeded
-	Message string `json:"message"` // Human-readable message
+	Success  bool   `json:"success"`             // Whether the action succeeded
+	Message  string `json:"message"`             // Human-readable message
+	ActionID int64  `json:"action_id,omitempty"` // Service actions: ID to follow the verification at /api/v1/actions/{id}
+
+	RemonitorAt *time.Time `json:"remonitor_at,omitempty"` // Temporary unmonitor: when monitoring resumes
 }
 
 // HandleActionAPI handles requests to perform actions on services.
@@ -483,48 +626,128 @@ func HandleActionAPI(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Temporary unmonitor: checked before anything is sent to the agent
+	var duration time.Duration
+	if req.Duration != "" {
+		duration, err = time.ParseDuration(req.Duration)
+		if err != nil || req.Action != "unmonitor" || duration < time.Minute || duration > maxUnmonitorDuration {
+			respondJSON(w, ActionResponse{
+				Success: false,
+				Message: "Invalid duration: only for unmonitor, from 1m to 720h (30 days)",
+			}, http.StatusBadRequest)
+			return
+		}
+	}
+
+	status, resp := runServiceAction(r, req.HostID, req.Service, req.Action)
+	if resp.Success && duration > 0 {
+		hostname, _ := getHostname(req.HostID)
+		remonitorAt, err := scheduleRemonitor(r, req.HostID, hostname, req.Service, duration)
+		if err != nil {
+			// The service stays unmonitored: tell the caller
+			log.Printf("[ERROR] Failed to schedule re-monitor of %s/%s: %v", hostname, req.Service, err)
+			resp.Message += ", but monitoring could not be scheduled to resume: monitor it manually"
+		} else {
+			resp.RemonitorAt = &remonitorAt
+			resp.Message += ", monitoring resumes at " + displayTime(remonitorAt, "2006-01-02 15:04 MST")
+		}
+	}
+	respondJSON(w, resp, status)
+}
+
+// runServiceAction runs action on a service of hostID after checking the
+// role of the request's API token, and records it in the audit log. It
+// returns the HTTP status and response for the action API.
+func runServiceAction(r *http.Request, hostID, service, action string) (int, ActionResponse) {
 	// Query host credentials from database
-	hostInfo, err := getHostCredentials(req.HostID)
+	hostInfo, err := getHostCredentials(hostID)
 	if err != nil {
-		log.Printf("[ERROR] Failed to get host credentials for %s: %v", req.HostID, err)
-		respondJSON(w, ActionResponse{
+		log.Printf("[ERROR] Failed to get host credentials for %s: %v", hostID, err)
+		auditRequest(r, dbpkg.AuditServiceAction, hostID+"/"+service, action+": host not found", false)
+		return http.StatusNotFound, ActionResponse{
 			Success: false,
 			Message: "Host not found or missing credentials",
-		}, http.StatusNotFound)
-		return
+		}
+	}
+
+	// Check the role of the API token, if any
+	allowed, reason, err := authorizeAction(r, hostID, action)
+	if err != nil {
+		log.Printf("[ERROR] %v", err)
+		return http.StatusInternalServerError, ActionResponse{
+			Success: false,
+			Message: "Failed to check authorization",
+		}
+	}
+	if !allowed {
+		log.Printf("[WARNING] Action '%s' on %s/%s refused for %s: %s",
+			action, hostInfo.Hostname, service, currentUser(r), reason)
+		auditRequest(r, dbpkg.AuditAccessDenied, hostInfo.Hostname+"/"+service, action+": "+reason, false)
+		return http.StatusForbidden, ActionResponse{
+			Success: false,
+			Message: "Not allowed: " + reason,
+		}
 	}
 
 	// Log the action attempt
 	log.Printf("[INFO] Executing action '%s' on service '%s' (host: %s)",
-		req.Action, req.Service, hostInfo.Hostname)
-
-	// Create Monit client with host's credentials
-	client := control.NewMonitClient(
-		hostInfo.HTTPAddress,
-		hostInfo.HTTPPort,
-		hostInfo.HTTPUsername,
-		hostInfo.HTTPPassword,
-	)
+		action, service, hostInfo.Hostname)
+
+	// Wait for the action queue, which limits the agents contacted at once
+	release := actionSlots.acquire(hostID)
+	defer release()
+
+	// Get the (cached) Monit client with host's credentials
+	started := time.Now()
+	client, err := monitClient(hostID, hostInfo)
+	if err != nil {
+		log.Printf("[ERROR] Failed to set up HTTPS to host %s: %v", hostInfo.Hostname, err)
+		recordAction(r, hostID, hostInfo.Hostname, service, action, started, err, true)
+		auditRequest(r, dbpkg.AuditServiceAction, hostInfo.Hostname+"/"+service, action+": "+err.Error(), false)
+		return http.StatusInternalServerError, ActionResponse{
+			Success: false,
+			Message: "Failed to set up HTTPS: " + err.Error(),
+		}
+	}
+
+	// Remember the process before a restart, to tell when it is replaced
+	var before *parser.Service
+	if action == "restart" {
+		before, _ = client.GetServiceState(service)
+		started = time.Now()
+	}
 
 	// Execute the action
-	err = client.ExecuteAction(req.Service, req.Action)
+	err = client.ExecuteAction(service, action)
+	actionID := recordAction(r, hostID, hostInfo.Hostname, service, action, started, err, true)
 	if err != nil {
 		log.Printf("[ERROR] Failed to execute action: %v", err)
-		respondJSON(w, ActionResponse{
+		auditRequest(r, dbpkg.AuditServiceAction, hostInfo.Hostname+"/"+service, action+": "+err.Error(), false)
+		message := "Failed to execute action: " + err.Error()
+		if control.KindOf(err) == control.AuthError {
+			message += " (check the Monit username and password of the host)"
+		}
+		return actionErrorStatus(err), ActionResponse{
 			Success: false,
-			Message: "Failed to execute action: " + err.Error(),
-		}, http.StatusInternalServerError)
-		return
+			Message: message,
+		}
 	}
 
 	// Success!
 	log.Printf("[INFO] Action '%s' successfully sent to service '%s' on host '%s'",
-		req.Action, req.Service, hostInfo.Hostname)
+		action, service, hostInfo.Hostname)
+	auditRequest(r, dbpkg.AuditServiceAction, hostInfo.Hostname+"/"+service, action, true)
 
-	respondJSON(w, ActionResponse{
-		Success: true,
-		Message: "Action '" + req.Action + "' successfully sent to service '" + req.Service + "' on host '" + hostInfo.Hostname + "'",
-	}, http.StatusOK)
+	// Check in the background that the service reaches the expected state
+	if actionID != 0 {
+		go verifyAction(client, actionID, hostInfo.Hostname, service, action, before, started)
+	}
+
+	return http.StatusOK, ActionResponse{
+		Success:  true,
+		Message:  "Action '" + action + "' successfully sent to service '" + service + "' on host '" + hostInfo.Hostname + "'",
+		ActionID: actionID,
+	}
 }
 
 // HostCredentials represents the information needed to control a Monit agent.
@@ -536,7 +759,7 @@ type HostCredentials struct {
 	HTTPPort     int    // Monit HTTP server port
 	HTTPSSL      int    // SSL enabled flag
 	HTTPUsername string // HTTP authentication username
-	HTTPPassword string // HTTP authentication password
+	HTTPPassword string `json:"-"` // HTTP authentication password, never serialized
 }
 
 // getHostCredentials retrieves the Monit connection info for a host.
@@ -598,7 +821,7 @@ func respondJSON(w http.ResponseWriter, data interface{}, statusCode int) {
 // Query parameters:
 //   - host_id (required): Host identifier
 //   - service (required): Service name
-//   - range (optional): Time range (1h, 6h, 24h, 7d, 30d), default: 24h
+//   - range (optional): Time range (1h, 6h, 24h, 7d, 30d), default: preferred range (24h)
 //
 // Returns JSON with timestamps and response time values (ICMP ping and port checks).
 func HandleRemoteHostMetricsAPI(w http.ResponseWriter, r *http.Request) {
@@ -624,9 +847,9 @@ func HandleRemoteHostMetricsAPI(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Default to 24 hours if range not specified
+	// Default to the viewer's preferred range (24h unless changed)
 	if rangeStr == "" {
-		rangeStr = "24h"
+		rangeStr = loadPreferences(r).DefaultRange
 	}
 
 	// Parse time range
@@ -679,6 +902,11 @@ func HandleRemoteHostMetricsAPI(w http.ResponseWriter, r *http.Request) {
 // This is used for graphing historical data. Do not confuse with getRemoteHostMetrics in handlers_status.go
 // which gets only the latest metrics for display on the service detail page.
 //
+// A service can have several checks of each kind: the series of the first
+// ICMP, port and unix socket checks are named icmp_response_time,
+// port_response_time and unix_response_time, the next ones get the
+// position of the check as suffix (port_response_time_2...).
+//
 // Parameters:
 //   - hostID: The host identifier
 //   - service: The service name
@@ -686,17 +914,17 @@ func HandleRemoteHostMetricsAPI(w http.ResponseWriter, r *http.Request) {
 //   - endTime: End of time range
 //
 // Returns:
-//   - []MetricSeries: Array of metric series (ICMP and Port response times)
+//   - []MetricSeries: Array of metric series (ICMP, Port and Unix socket response times)
 //   - error: Any database error
 func getRemoteHostMetricsForGraph(hostID, service string, startTime, endTime time.Time) ([]MetricSeries, error) {
-	// Query remote host metrics for this service in the time range
-	// We'll get ICMP response times and Port response times
 	const query = `
-		SELECT collected_at, icmp_responsetime, port_responsetime
+		SELECT collected_at, check_index, icmp_type, icmp_responsetime,
+		       port_hostname, port_number, port_type, port_responsetime,
+		       unix_path, unix_responsetime
 		FROM remote_host_metrics
 		WHERE host_id = ? AND service_name = ?
 		  AND collected_at BETWEEN ? AND ?
-		ORDER BY collected_at
+		ORDER BY collected_at, check_index
 	`
 
 	rows, err := db.Query(query, hostID, service, startTime, endTime)
@@ -705,79 +933,66 @@ func getRemoteHostMetricsForGraph(hostID, service string, startTime, endTime tim
 	}
 	defer rows.Close()
 
-	// Collect data points
-	var icmpPoints []MetricPoint
-	var portPoints []MetricPoint
+	// Series by kind ("icmp", "port", "unix") and check index, in the
+	// order they first appear
+	type seriesKey struct {
+		kind  string
+		index int
+	}
+	series := map[seriesKey]*MetricSeries{}
+	var order []seriesKey
+
+	add := func(kind string, index int, label string, collectedAt time.Time, seconds sql.NullFloat64) {
+		// Failed checks have no response time (or -1 for unix sockets)
+		if !seconds.Valid || seconds.Float64 <= 0 {
+			return
+		}
+		key := seriesKey{kind, index}
+		s, ok := series[key]
+		if !ok {
+			name := kind + "_response_time"
+			if index > 0 {
+				name = fmt.Sprintf("%s_%d", name, index+1)
+			}
+			s = &MetricSeries{Name: name, Type: "response_time", Label: label}
+			series[key] = s
+			order = append(order, key)
+		}
+		s.Timestamps = append(s.Timestamps, collectedAt.Format(time.RFC3339))
+		s.Values = append(s.Values, seconds.Float64*1000) // Convert seconds to milliseconds
+	}
 
 	for rows.Next() {
 		var collectedAt time.Time
-		var icmpResponse, portResponse *float64
-
-		err := rows.Scan(&collectedAt, &icmpResponse, &portResponse)
+		var index int
+		var icmpType, portHostname, portType, unixPath sql.NullString
+		var portNumber sql.NullInt64
+		var icmpResponse, portResponse, unixResponse sql.NullFloat64
+
+		err := rows.Scan(&collectedAt, &index, &icmpType, &icmpResponse,
+			&portHostname, &portNumber, &portType, &portResponse,
+			&unixPath, &unixResponse)
 		if err != nil {
 			return nil, err
 		}
 
-		// Add ICMP response time if available (convert to milliseconds)
-		if icmpResponse != nil && *icmpResponse > 0 {
-			icmpPoints = append(icmpPoints, MetricPoint{
-				Timestamp: collectedAt,
-				Value:     *icmpResponse * 1000, // Convert seconds to milliseconds
-			})
-		}
-
-		// Add Port response time if available (convert to milliseconds)
-		if portResponse != nil && *portResponse > 0 {
-			portPoints = append(portPoints, MetricPoint{
-				Timestamp: collectedAt,
-				Value:     *portResponse * 1000, // Convert seconds to milliseconds
-			})
+		add("icmp", index, icmpType.String, collectedAt, icmpResponse)
+		label := fmt.Sprintf("%s:%d", portHostname.String, portNumber.Int64)
+		if portType.Valid {
+			label += " " + portType.String
 		}
+		add("port", index, label, collectedAt, portResponse)
+		add("unix", index, unixPath.String, collectedAt, unixResponse)
 	}
 
 	if err = rows.Err(); err != nil {
 		return nil, err
 	}
 
-	// Build metric series
-	var result []MetricSeries
-
-	// Add ICMP series if we have data
-	if len(icmpPoints) > 0 {
-		timestamps := make([]string, len(icmpPoints))
-		values := make([]float64, len(icmpPoints))
-
-		for i, point := range icmpPoints {
-			timestamps[i] = point.Timestamp.Format(time.RFC3339)
-			values[i] = point.Value
-		}
-
-		result = append(result, MetricSeries{
-			Name:       "icmp_response_time",
-			Type:       "response_time",
-			Timestamps: timestamps,
-			Values:     values,
-		})
+	result := []MetricSeries{}
+	for _, key := range order {
+		result = append(result, *series[key])
 	}
-
-	// Add Port series if we have data
-	if len(portPoints) > 0 {
-		timestamps := make([]string, len(portPoints))
-		values := make([]float64, len(portPoints))
-
-		for i, point := range portPoints {
-			timestamps[i] = point.Timestamp.Format(time.RFC3339)
-			values[i] = point.Value
-		}
-
-		result = append(result, MetricSeries{
-			Name:       "port_response_time",
-			Type:       "response_time",
-			Timestamps: timestamps,
-			Values:     values,
-		})
-	}
-
 	return result, nil
 }
 
@@ -885,6 +1100,7 @@ func HandleUpdateDescription(w http.ResponseWriter, r *http.Request) {
 
 	// Success!
 	log.Printf("[INFO] Updated description for host %s (%d bytes)", req.HostID, len(req.Description))
+	auditRequest(r, dbpkg.AuditHostUpdate, req.HostID, "description", true)
 
 	respondJSON(w, UpdateDescriptionResponse{
 		Success: true,
@@ -978,3 +1194,155 @@ func HandleHostGroupsAPI(w http.ResponseWriter, r *http.Request) {
 
 	respondJSON(w, HostGroupsResponse{Groups: groups}, http.StatusOK)
 }
+
+// =============================================================================
+// PROCESS METRICS API
+// =============================================================================
+
+// processMetricTypes are the metric types StoreProcessMetrics writes for
+// process services (type 3).
+var processMetricTypes = map[string]bool{
+	"process_cpu":    true,
+	"process_memory": true,
+}
+
+// HandleProcessMetricsAPI serves CPU and memory history of a process service.
+//
+// URL format:
+//   /api/process-metrics?host_id=xxx&service=xxx&range=24h
+//
+// Query parameters:
+//   - host_id (required): Host identifier
+//   - service (required): Process service name
+//   - range (optional): Time range (1h, 6h, 24h, 7d, 30d), default: preferred range (24h)
+//   - agg, bucket (optional): Server-side aggregation, as for /api/metrics
+//
+// Returns the process_cpu (percent, total_percent) and process_memory
+// (percent, total_percent, kilobyte) series. total_* include child processes.
+func HandleProcessMetricsAPI(w http.ResponseWriter, r *http.Request) {
+	serveServiceMetricTypes(w, r, processMetricTypes)
+}
+
+// serveServiceMetricTypes answers a metrics request for one service,
+// keeping only the series whose type is in types. It takes the same
+// parameters as /api/metrics (host_id, service, range, agg, bucket).
+func serveServiceMetricTypes(w http.ResponseWriter, r *http.Request, types map[string]bool) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Parse query parameters
+	query := r.URL.Query()
+	hostID := query.Get("host_id")
+	service := query.Get("service")
+	rangeStr := query.Get("range")
+
+	// Validate required parameters
+	if hostID == "" {
+		http.Error(w, "Missing host_id parameter", http.StatusBadRequest)
+		return
+	}
+	if service == "" {
+		http.Error(w, "Missing service parameter", http.StatusBadRequest)
+		return
+	}
+
+	// Default to the viewer's preferred range (24h unless changed)
+	if rangeStr == "" {
+		rangeStr = loadPreferences(r).DefaultRange
+	}
+
+	// Parse time range
+	duration, err := parseTimeRange(rangeStr)
+	if err != nil {
+		http.Error(w, "Invalid range parameter", http.StatusBadRequest)
+		return
+	}
+
+	// Calculate time window
+	endTime := time.Now()
+	startTime := endTime.Add(-duration)
+
+	// Optional server-side aggregation
+	agg, bucket, err := parseAggregation(query.Get("agg"), query.Get("bucket"), duration)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Query the service's metrics and keep the requested series
+	var all []MetricSeries
+	if bucket > 0 {
+		all, err = getAggregatedMetricsForService(hostID, service, startTime, endTime, agg, bucket)
+	} else {
+		all, err = getMetricsForService(hostID, service, startTime, endTime)
+	}
+	if err != nil {
+		log.Printf("[ERROR] Failed to get %s/%s metrics: %v", hostID, service, err)
+		http.Error(w, "Failed to get metrics", http.StatusInternalServerError)
+		return
+	}
+
+	metrics := []MetricSeries{}
+	for _, series := range all {
+		if types[series.Type] {
+			metrics = append(metrics, series)
+		}
+	}
+
+	// Get hostname for the response
+	hostname, err := getHostname(hostID)
+	if err != nil {
+		log.Printf("[ERROR] Failed to get hostname: %v", err)
+		hostname = hostID
+	}
+
+	// Build JSON response
+	response := MetricsResponse{
+		HostID:    hostID,
+		Hostname:  hostname,
+		Service:   service,
+		StartTime: startTime,
+		EndTime:   endTime,
+		Metrics:   metrics,
+	}
+	if bucket > 0 {
+		response.Agg = agg
+		response.Bucket = bucket.String()
+	}
+
+	// Set response headers for JSON
+	w.Header().Set("Content-Type", "application/json")
+
+	// Encode response as JSON and write to client
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		log.Printf("[ERROR] Failed to encode JSON: %v", err)
+	}
+}
+
+// =============================================================================
+// NETWORK METRICS API
+// =============================================================================
+
+// networkMetricTypes are the metric types StoreNetworkMetrics writes for
+// network interface services (type 8).
+var networkMetricTypes = map[string]bool{
+	"network": true,
+}
+
+// HandleNetworkMetricsAPI serves throughput history of a network interface
+// service.
+//
+// URL format:
+//   /api/network-metrics?host_id=xxx&service=xxx&range=24h
+//
+// Query parameters are the same as for /api/process-metrics.
+//
+// Returns the network series download_bytes and upload_bytes, Monit's
+// "now" byte counters (bytes per second at each report).
+func HandleNetworkMetricsAPI(w http.ResponseWriter, r *http.Request) {
+	serveServiceMetricTypes(w, r, networkMetricTypes)
+}
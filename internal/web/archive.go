@@ -0,0 +1,141 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	dbpkg "github.com/ocochard/cmonit/internal/db"
+)
+
+// HostArchiveRequest is the JSON request for archiving or unarchiving a
+// host.
+type HostArchiveRequest struct {
+	HostID   string `json:"host_id"`  // Host identifier
+	Archived bool   `json:"archived"` // true = archive, false = unarchive
+}
+
+// ArchivedHostsResponse is the JSON response of GET /api/v1/host/archive.
+type ArchivedHostsResponse struct {
+	Archived []dbpkg.ArchivedHost `json:"archived"`
+}
+
+// HandleHostArchiveAPI lists, archives and unarchives hosts.
+//
+// GET /api/v1/host/archive
+// POST /api/v1/host/archive
+//
+// Request body: {"host_id": "...", "archived": true}
+// Response: {"success": true, "message": "..."}
+//
+// An archived host is hidden from the dashboard, the search, the public
+// status page and the exporters, and no longer raises offline alerts. Its
+// services, metrics and events are kept, so it can be looked up from the
+// archive page (/archive); it is unarchived when it reports again.
+func HandleHostArchiveAPI(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		archived, err := dbpkg.ArchivedHosts(db)
+		if err != nil {
+			log.Printf("[ERROR] Failed to query archived hosts: %v", err)
+			respondJSON(w, map[string]string{"error": "Failed to query archived hosts"}, http.StatusInternalServerError)
+			return
+		}
+		respondJSON(w, ArchivedHostsResponse{Archived: archived}, http.StatusOK)
+	case http.MethodPost:
+		setHostArchived(w, r)
+	default:
+		respondJSON(w, ActionResponse{
+			Success: false,
+			Message: "Method not allowed",
+		}, http.StatusMethodNotAllowed)
+	}
+}
+
+// setHostArchived handles POST /api/v1/host/archive.
+func setHostArchived(w http.ResponseWriter, r *http.Request) {
+	var req HostArchiveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, ActionResponse{
+			Success: false,
+			Message: "Invalid JSON",
+		}, http.StatusBadRequest)
+		return
+	}
+	if req.HostID == "" {
+		respondJSON(w, ActionResponse{
+			Success: false,
+			Message: "Missing host_id",
+		}, http.StatusBadRequest)
+		return
+	}
+
+	found, err := dbpkg.SetHostArchived(db, req.HostID, req.Archived, time.Now())
+	if err != nil {
+		log.Printf("[ERROR] %v", err)
+		auditRequest(r, dbpkg.AuditHostArchive, req.HostID, err.Error(), false)
+		respondJSON(w, ActionResponse{
+			Success: false,
+			Message: "Failed to update host",
+		}, http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		respondJSON(w, ActionResponse{
+			Success: false,
+			Message: "Host not found",
+		}, http.StatusNotFound)
+		return
+	}
+
+	message := "Host unarchived"
+	if req.Archived {
+		message = "Host archived"
+	}
+	log.Printf("[INFO] %s: %s", message, req.HostID)
+	auditRequest(r, dbpkg.AuditHostArchive, req.HostID, fmt.Sprintf("archived=%t", req.Archived), true)
+
+	respondJSON(w, ActionResponse{
+		Success: true,
+		Message: message,
+	}, http.StatusOK)
+}
+
+// ArchivePageData is the data of the archived hosts page.
+type ArchivePageData struct {
+	Archived   []dbpkg.ArchivedHost
+	LastUpdate time.Time
+	AppVersion string
+	Prefs      Preferences
+}
+
+// HandleArchive renders the archived hosts page (/archive): the hosts
+// taken out of service with a link to their history and a button to
+// unarchive them.
+func HandleArchive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	archived, err := dbpkg.ArchivedHosts(db)
+	if err != nil {
+		log.Printf("[ERROR] Failed to query archived hosts: %v", err)
+		http.Error(w, "Failed to load archived hosts", http.StatusInternalServerError)
+		return
+	}
+
+	data := ArchivePageData{
+		Archived:   archived,
+		LastUpdate: time.Now(),
+		AppVersion: appVersion,
+		Prefs:      loadPreferences(r),
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := templates.ExecuteTemplate(w, "archive.html", data); err != nil {
+		log.Printf("[ERROR] Failed to render template: %v", err)
+	}
+}
@@ -0,0 +1,262 @@
+package web
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	dbpkg "github.com/ocochard/cmonit/internal/db"
+)
+
+// defaultAuditPerPage is the number of audit entries per page.
+const defaultAuditPerPage = 100
+
+// clientIP returns the address of the client, without the port.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// recordAudit appends an action by actor to the audit log. Errors are
+// logged: a failure to audit never fails the request.
+func recordAudit(r *http.Request, actor, action, target, details string, success bool) {
+	if actor == "" {
+		actor = "anonymous"
+	}
+	err := dbpkg.RecordAudit(db, dbpkg.AuditEntry{
+		Actor:    actor,
+		SourceIP: clientIP(r),
+		Action:   action,
+		Target:   target,
+		Details:  details,
+		Success:  success,
+	})
+	if err != nil {
+		log.Printf("[ERROR] %v", err)
+	}
+}
+
+// auditRequest appends an action by the request's user to the audit log.
+func auditRequest(r *http.Request, action, target, details string, success bool) {
+	recordAudit(r, currentUser(r), action, target, details, success)
+}
+
+// AuditQuery holds the filter and pagination parameters of the audit log
+// page and API.
+type AuditQuery struct {
+	Actor  string // "actor"
+	Action string // "action"
+	From   string // Start date YYYY-MM-DD or RFC 3339 timestamp ("from")
+	To     string // End date YYYY-MM-DD (whole day) or RFC 3339 timestamp ("to")
+	Page   int    // 1-based page number ("page")
+
+	from, to time.Time
+}
+
+// parseAuditQuery extracts AuditQuery from the request. Dates without a
+// time are interpreted in loc. Returns an error message for invalid dates.
+func parseAuditQuery(r *http.Request, loc *time.Location) (AuditQuery, string) {
+	v := r.URL.Query()
+	q := AuditQuery{
+		Actor:  v.Get("actor"),
+		Action: v.Get("action"),
+		From:   strings.TrimSpace(v.Get("from")),
+		To:     strings.TrimSpace(v.Get("to")),
+		Page:   1,
+	}
+	if page, err := strconv.Atoi(v.Get("page")); err == nil && page > 0 {
+		q.Page = page
+	}
+
+	var err error
+	if q.From != "" {
+		if q.from, err = parseEventsTime(q.From, loc, false); err != nil {
+			return q, "Invalid from date: " + q.From
+		}
+	}
+	if q.To != "" {
+		if q.to, err = parseEventsTime(q.To, loc, true); err != nil {
+			return q, "Invalid to date: " + q.To
+		}
+	}
+	return q, ""
+}
+
+// filter returns the database filter for one page, or all entries if
+// perPage is 0.
+func (q AuditQuery) filter(perPage int) dbpkg.AuditFilter {
+	f := dbpkg.AuditFilter{Actor: q.Actor, Action: q.Action, From: q.from, To: q.to}
+	if perPage > 0 {
+		f.Limit = perPage
+		f.Offset = (q.Page - 1) * perPage
+	}
+	return f
+}
+
+// values encodes the filters back into URL parameters, omitting defaults.
+func (q AuditQuery) values() url.Values {
+	v := url.Values{}
+	if q.Actor != "" {
+		v.Set("actor", q.Actor)
+	}
+	if q.Action != "" {
+		v.Set("action", q.Action)
+	}
+	if q.From != "" {
+		v.Set("from", q.From)
+	}
+	if q.To != "" {
+		v.Set("to", q.To)
+	}
+	if q.Page > 1 {
+		v.Set("page", strconv.Itoa(q.Page))
+	}
+	return v
+}
+
+// PageURL returns the audit page URL for the given page number.
+func (q AuditQuery) PageURL(page int) string {
+	next := q
+	next.Page = page
+	return "/audit?" + next.values().Encode()
+}
+
+// ExportURL returns the JSON export URL for the current filters.
+func (q AuditQuery) ExportURL() string {
+	next := q
+	next.Page = 1
+	return APIv1Prefix + "/audit/export?" + next.values().Encode()
+}
+
+// AuditPageData holds data for the audit log page.
+type AuditPageData struct {
+	Entries     []dbpkg.AuditEntry
+	Query       AuditQuery
+	Total       int
+	TotalPages  int
+	Actors      []string // Actors for the filter drop-down
+	Actions     []string // Actions for the filter drop-down
+	LastUpdate  time.Time
+	AppVersion  string
+	Prefs       Preferences
+	FilterError string
+}
+
+// AuditResponse is the JSON response for the audit log API.
+type AuditResponse struct {
+	Entries []dbpkg.AuditEntry `json:"entries"`
+	Total   int                `json:"total"`
+	Page    int                `json:"page"`
+	PerPage int                `json:"per_page"`
+}
+
+// HandleAudit serves the audit log page.
+//
+// GET /audit?actor=&action=&from=&to=&page=
+func HandleAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	prefs := loadPreferences(r)
+	q, filterErr := parseAuditQuery(r, prefs.Location())
+
+	data := AuditPageData{
+		Entries:     []dbpkg.AuditEntry{},
+		Query:       q,
+		Actions:     dbpkg.AuditActions,
+		LastUpdate:  time.Now(),
+		AppVersion:  appVersion,
+		Prefs:       prefs,
+		FilterError: filterErr,
+	}
+
+	if filterErr == "" {
+		entries, total, err := dbpkg.ListAuditEntries(db, q.filter(defaultAuditPerPage))
+		if err != nil {
+			log.Printf("[ERROR] %v", err)
+			http.Error(w, "Failed to load audit log", http.StatusInternalServerError)
+			return
+		}
+		data.Entries = entries
+		data.Total = total
+		data.TotalPages = (total + defaultAuditPerPage - 1) / defaultAuditPerPage
+	}
+
+	var err error
+	if data.Actors, err = dbpkg.ListAuditActors(db); err != nil {
+		log.Printf("[ERROR] %v", err)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	err = templates.ExecuteTemplate(w, "audit.html", data)
+	if err != nil {
+		log.Printf("[ERROR] Failed to render template: %v", err)
+	}
+}
+
+// HandleAuditAPI returns audit log entries as JSON.
+//
+// GET /api/v1/audit?actor=&action=&from=&to=&page=
+// GET /api/v1/audit/export?actor=&action=&from=&to=
+//
+// The first form returns one page, newest first. The export returns every
+// matching entry as a JSON array, as a file download. Called with an API
+// token, both need the admin scope.
+func HandleAuditAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q, filterErr := parseAuditQuery(r, loadPreferences(r).Location())
+	if filterErr != "" {
+		respondJSON(w, map[string]string{"error": filterErr}, http.StatusBadRequest)
+		return
+	}
+
+	switch strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/audit"), "/") {
+	case "":
+		entries, total, err := dbpkg.ListAuditEntries(db, q.filter(defaultAuditPerPage))
+		if err != nil {
+			log.Printf("[ERROR] %v", err)
+			respondJSON(w, map[string]string{"error": "Failed to get audit log"}, http.StatusInternalServerError)
+			return
+		}
+		respondJSON(w, AuditResponse{
+			Entries: entries,
+			Total:   total,
+			Page:    q.Page,
+			PerPage: defaultAuditPerPage,
+		}, http.StatusOK)
+
+	case "export":
+		entries, _, err := dbpkg.ListAuditEntries(db, q.filter(0))
+		if err != nil {
+			log.Printf("[ERROR] %v", err)
+			respondJSON(w, map[string]string{"error": "Failed to get audit log"}, http.StatusInternalServerError)
+			return
+		}
+		filename := "cmonit-audit-" + time.Now().Format("20060102-150405") + ".json"
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(entries); err != nil {
+			log.Printf("[ERROR] Failed to write audit export: %v", err)
+		}
+
+	default:
+		respondJSON(w, map[string]string{"error": "Not found"}, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,192 @@
+package web
+
+import (
+	"database/sql"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// badgeColors maps status colors to badge fill colors.
+var badgeColors = map[string]string{
+	"green":  "#4c1",
+	"yellow": "#dfb317",
+	"orange": "#fe7d37",
+	"red":    "#e05d44",
+	"gray":   "#9f9f9f",
+}
+
+// badgeTemplate is a flat two-part badge: label on the left, status on the
+// right. Arguments: total width, label width, status width, status color,
+// label text x, status text x, label, status.
+const badgeTemplate = `<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="%[7]s: %[8]s">
+<title>%[7]s: %[8]s</title>
+<linearGradient id="s" x2="0" y2="100%%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>
+<clipPath id="r"><rect width="%[1]d" height="20" rx="3" fill="#fff"/></clipPath>
+<g clip-path="url(#r)"><rect width="%[2]d" height="20" fill="#555"/><rect x="%[2]d" width="%[3]d" height="20" fill="%[4]s"/><rect width="%[1]d" height="20" fill="url(#s)"/></g>
+<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+<text x="%[5]d" y="15" fill="#010101" fill-opacity=".3">%[7]s</text><text x="%[5]d" y="14">%[7]s</text>
+<text x="%[6]d" y="15" fill="#010101" fill-opacity=".3">%[8]s</text><text x="%[6]d" y="14">%[8]s</text>
+</g>
+</svg>
+`
+
+// renderBadge returns the SVG for a badge. Text widths are estimated from
+// the character count, which is close enough for 11px Verdana.
+func renderBadge(label, status, color string) []byte {
+	fill, ok := badgeColors[color]
+	if !ok {
+		fill = badgeColors["gray"]
+	}
+
+	labelWidth := utf8.RuneCountInString(label)*7 + 10
+	statusWidth := utf8.RuneCountInString(status)*7 + 10
+
+	return []byte(fmt.Sprintf(badgeTemplate,
+		labelWidth+statusWidth,
+		labelWidth,
+		statusWidth,
+		fill,
+		labelWidth/2,
+		labelWidth+statusWidth/2,
+		html.EscapeString(label),
+		html.EscapeString(status),
+	))
+}
+
+// badgeRequest is a parsed /badge/ URL.
+type badgeRequest struct {
+	HostID  string
+	Service string // "" for host badges
+}
+
+// parseBadgePath parses /badge/host/{id}.svg and
+// /badge/service/{id}/{name}.svg.
+func parseBadgePath(path string) (badgeRequest, bool) {
+	rest, ok := strings.CutSuffix(strings.TrimPrefix(path, "/badge/"), ".svg")
+	if !ok {
+		return badgeRequest{}, false
+	}
+
+	if hostID, ok := strings.CutPrefix(rest, "host/"); ok && hostID != "" && !strings.Contains(hostID, "/") {
+		return badgeRequest{HostID: hostID}, true
+	}
+	if target, ok := strings.CutPrefix(rest, "service/"); ok {
+		hostID, service, ok := strings.Cut(target, "/")
+		if ok && hostID != "" && service != "" {
+			return badgeRequest{HostID: hostID, Service: service}, true
+		}
+	}
+	return badgeRequest{}, false
+}
+
+// HandleBadge serves SVG status badges for embedding in wikis and READMEs.
+//
+// URL format:
+//
+//	GET /badge/host/{host_id}.svg
+//	GET /badge/service/{host_id}/{service}.svg
+//
+// The optional "label" query parameter replaces the left-hand text (default:
+// hostname or service name). Host badges show OK/Warning/Critical/Unknown as
+// on the status page; service badges show the service status, or
+// "Unmonitored" when monitoring is disabled.
+func HandleBadge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	req, ok := parseBadgePath(r.URL.Path)
+	if !ok {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	var label, status, color string
+	var err error
+	if req.Service == "" {
+		label, status, color, err = hostBadgeStatus(req.HostID)
+	} else {
+		label, status, color, err = serviceBadgeStatus(req.HostID, req.Service)
+	}
+	if err == sql.ErrNoRows {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("[ERROR] Failed to get badge status for %s/%s: %v", req.HostID, req.Service, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	if l := r.URL.Query().Get("label"); l != "" {
+		label = l
+	}
+
+	// Badges are fetched through image proxies (e.g. GitHub camo); ask them
+	// not to cache so the status stays current.
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", "no-cache, max-age=0")
+	w.Write(renderBadge(label, status, color))
+}
+
+// hostBadgeStatus returns the badge label, status text and color for a host.
+// Returns sql.ErrNoRows if the host does not exist.
+func hostBadgeStatus(hostID string) (string, string, string, error) {
+	var hs HostStatus
+	err := db.QueryRow("SELECT id, hostname, last_seen FROM hosts WHERE id = ?", hostID).
+		Scan(&hs.ID, &hs.Hostname, &hs.LastSeen)
+	if err != nil {
+		return "", "", "", err
+	}
+	hs.IsStale = time.Since(hs.LastSeen) > 5*time.Minute
+
+	services, err := getServicesForHost(hostID)
+	if err != nil {
+		return "", "", "", err
+	}
+	calculateHostStatus(&hs, services)
+
+	return hs.Hostname, hs.StatusName, hs.StatusColor, nil
+}
+
+// serviceBadgeStatus returns the badge label, status text and color for a
+// service. Returns sql.ErrNoRows if the service does not exist.
+func serviceBadgeStatus(hostID, service string) (string, string, string, error) {
+	var status, monitor int
+	err := db.QueryRow("SELECT status, monitor FROM services WHERE host_id = ? AND name = ?", hostID, service).
+		Scan(&status, &monitor)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	if monitor == 0 {
+		return service, "Unmonitored", "gray", nil
+	}
+	name, color := getServiceStatusInfo(status)
+	return service, name, color, nil
+}
+
+// PublicBadges serves badges for hosts listed on the public status page
+// without authentication, and passes every other request to next.
+//
+// main wraps the authenticated handler with it when -public-status is set,
+// so badges of public hosts can be embedded in pages viewed by anyone.
+func PublicBadges(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if req, ok := parseBadgePath(r.URL.Path); ok {
+			var public bool
+			err := db.QueryRow("SELECT COALESCE(public, 0) FROM hosts WHERE id = ?", req.HostID).Scan(&public)
+			if err == nil && public {
+				HandleBadge(w, r)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,363 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/ocochard/cmonit/internal/control"
+	dbpkg "github.com/ocochard/cmonit/internal/db"
+)
+
+// BulkActionRequest is the request body of POST /api/v1/hostgroups/action.
+type BulkActionRequest struct {
+	HostGroup string `json:"hostgroup"`
+	Service   string `json:"service"`
+	Action    string `json:"action"`
+	DryRun    bool   `json:"dry_run"` // Only report the hosts the action would run on
+}
+
+// BulkActionResult is the outcome of a bulk action on one host.
+type BulkActionResult struct {
+	HostID   string `json:"host_id"`
+	Hostname string `json:"hostname"`
+	Success  bool   `json:"success"`
+	Message  string `json:"message"`
+	ActionID int64  `json:"action_id,omitempty"`
+}
+
+// BulkActionResponse summarizes a bulk action. Success is true only when
+// the action succeeded on every host. In a dry run, results tell whether the
+// action would be sent to each host.
+type BulkActionResponse struct {
+	Success   bool               `json:"success"`
+	DryRun    bool               `json:"dry_run,omitempty"`
+	Message   string             `json:"message"`
+	Total     int                `json:"total"`
+	Succeeded int                `json:"succeeded"`
+	Failed    int                `json:"failed"`
+	Results   []BulkActionResult `json:"results"`
+}
+
+// HandleBulkActionAPI runs an action on a service of every host of a
+// hostgroup that has it, e.g. restart "nginx" on all web servers. Each host
+// goes through the same checks as POST /api/v1/action (token role, audit
+// log); hosts failing or refused are reported without stopping the others.
+// The action queue limits how many agents are contacted at the same time.
+// With dry_run, the hosts are resolved and checked against the token role
+// without contacting any agent.
+//
+// POST /api/v1/hostgroups/action
+//
+// Request body: {"hostgroup": "web", "service": "nginx", "action": "restart"}
+// Response: {"success": false, "message": "...", "total": 3, "succeeded": 2, "failed": 1, "results": [...]}
+func HandleBulkActionAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondJSON(w, ActionResponse{
+			Success: false,
+			Message: "Method not allowed",
+		}, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req BulkActionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, ActionResponse{
+			Success: false,
+			Message: "Invalid request body",
+		}, http.StatusBadRequest)
+		return
+	}
+	req.HostGroup = strings.TrimSpace(req.HostGroup)
+	req.Service = strings.TrimSpace(req.Service)
+	if req.HostGroup == "" || req.Service == "" {
+		respondJSON(w, ActionResponse{
+			Success: false,
+			Message: "Missing hostgroup or service",
+		}, http.StatusBadRequest)
+		return
+	}
+	if !control.ValidAction(req.Action) {
+		respondJSON(w, ActionResponse{
+			Success: false,
+			Message: fmt.Sprintf("Invalid action %q (valid: %s)", req.Action, strings.Join(control.Actions, ", ")),
+		}, http.StatusBadRequest)
+		return
+	}
+
+	results, err := bulkActionHosts(req.HostGroup, req.Service)
+	if err != nil {
+		log.Printf("[ERROR] Failed to find hosts for bulk action: %v", err)
+		respondJSON(w, ActionResponse{
+			Success: false,
+			Message: "Failed to find hosts",
+		}, http.StatusInternalServerError)
+		return
+	}
+	if len(results) == 0 {
+		respondJSON(w, ActionResponse{
+			Success: false,
+			Message: fmt.Sprintf("No host of hostgroup '%s' has a service '%s'", req.HostGroup, req.Service),
+		}, http.StatusNotFound)
+		return
+	}
+
+	if req.DryRun {
+		summary := BulkActionResponse{DryRun: true, Total: len(results), Results: results}
+		for i := range results {
+			res := &results[i]
+			res.Success, res.Message = dryRunAction(r, res.HostID, res.Hostname, req.Service, req.Action)
+			if res.Success {
+				summary.Succeeded++
+			} else {
+				summary.Failed++
+			}
+		}
+		summary.Success = summary.Failed == 0
+		summary.Message = fmt.Sprintf("Dry run: %s '%s' would be sent to %d of %d hosts", req.Action, req.Service, summary.Succeeded, summary.Total)
+		respondJSON(w, summary, http.StatusOK)
+		return
+	}
+
+	log.Printf("[INFO] Bulk action '%s' on service '%s' of %d hosts (hostgroup: %s)",
+		req.Action, req.Service, len(results), req.HostGroup)
+
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(res *BulkActionResult) {
+			defer wg.Done()
+			_, resp := runServiceAction(r, res.HostID, req.Service, req.Action)
+			res.Success = resp.Success
+			res.Message = resp.Message
+			res.ActionID = resp.ActionID
+		}(&results[i])
+	}
+	wg.Wait()
+
+	summary := BulkActionResponse{Total: len(results), Results: results}
+	for _, res := range results {
+		if res.Success {
+			summary.Succeeded++
+		} else {
+			summary.Failed++
+		}
+	}
+	summary.Success = summary.Failed == 0
+	summary.Message = fmt.Sprintf("%s '%s': %d of %d hosts succeeded", req.Action, req.Service, summary.Succeeded, summary.Total)
+	if summary.Failed > 0 {
+		summary.Message += fmt.Sprintf(", %d failed", summary.Failed)
+	}
+
+	auditRequest(r, dbpkg.AuditBulkAction, req.HostGroup+"/"+req.Service, summary.Message, summary.Success)
+	respondJSON(w, summary, http.StatusOK)
+}
+
+// bulkActionHosts returns a result to fill for each host of a hostgroup
+// that has the service, sorted by hostname.
+func bulkActionHosts(hostgroup, service string) ([]BulkActionResult, error) {
+	rows, err := db.Query(`
+		SELECT h.id, h.hostname
+		FROM hosts h
+		JOIN host_hostgroups hhg ON hhg.host_id = h.id
+		JOIN hostgroups hg ON hg.id = hhg.hostgroup_id
+		JOIN services s ON s.host_id = h.id
+		WHERE hg.name = ? AND s.name = ? AND h.archived_at IS NULL
+		ORDER BY h.hostname ASC
+	`, hostgroup, service)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []BulkActionResult
+	for rows.Next() {
+		var res BulkActionResult
+		if err := rows.Scan(&res.HostID, &res.Hostname); err != nil {
+			return nil, err
+		}
+		results = append(results, res)
+	}
+	return results, rows.Err()
+}
+
+// RestartFailedRequest is the request body of POST
+// /api/v1/host/restart-failed.
+type RestartFailedRequest struct {
+	HostID string `json:"host_id"`
+	DryRun bool   `json:"dry_run"` // Only report the services that would be restarted
+}
+
+// ServiceActionResult is the outcome of an action on one service.
+type ServiceActionResult struct {
+	Service  string `json:"service"`
+	Success  bool   `json:"success"`
+	Message  string `json:"message"`
+	ActionID int64  `json:"action_id,omitempty"`
+}
+
+// RestartFailedResponse summarizes the restart of the failed services of a
+// host. Success is true only when every restart was sent.
+type RestartFailedResponse struct {
+	Success   bool                  `json:"success"`
+	DryRun    bool                  `json:"dry_run,omitempty"`
+	Message   string                `json:"message"`
+	Total     int                   `json:"total"`
+	Succeeded int                   `json:"succeeded"`
+	Failed    int                   `json:"failed"`
+	Results   []ServiceActionResult `json:"results"`
+}
+
+// HandleRestartFailedAPI restarts every failed process service of a host:
+// monitored processes whose status is not OK. Each restart goes through
+// the same checks as POST /api/v1/action (token role, action history,
+// audit log); failures are reported per service without stopping the
+// others. The action queue limits the restarts sent to the agent at the
+// same time (one by default). With dry_run, the services are listed and
+// checked against the token role without contacting the agent.
+//
+// POST /api/v1/host/restart-failed
+//
+// Request body: {"host_id": "..."}
+// Response: {"success": true, "message": "...", "total": 2, "succeeded": 2, "failed": 0, "results": [...]}
+func HandleRestartFailedAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondJSON(w, ActionResponse{
+			Success: false,
+			Message: "Method not allowed",
+		}, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req RestartFailedRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.HostID == "" {
+		respondJSON(w, ActionResponse{
+			Success: false,
+			Message: "Invalid request body (host_id required)",
+		}, http.StatusBadRequest)
+		return
+	}
+
+	creds, err := getHostCredentials(req.HostID)
+	if err != nil {
+		respondJSON(w, ActionResponse{
+			Success: false,
+			Message: "Host not found",
+		}, http.StatusNotFound)
+		return
+	}
+
+	services, err := failedProcessServices(req.HostID)
+	if err != nil {
+		log.Printf("[ERROR] Failed to find failed services of host %s: %v", creds.Hostname, err)
+		respondJSON(w, ActionResponse{
+			Success: false,
+			Message: "Failed to find failed services",
+		}, http.StatusInternalServerError)
+		return
+	}
+
+	summary := RestartFailedResponse{Total: len(services), Results: []ServiceActionResult{}}
+	if len(services) == 0 {
+		summary.Success = true
+		summary.Message = fmt.Sprintf("No failed service to restart on host '%s'", creds.Hostname)
+		respondJSON(w, summary, http.StatusOK)
+		return
+	}
+
+	if req.DryRun {
+		summary.DryRun = true
+		for _, service := range services {
+			res := ServiceActionResult{Service: service}
+			res.Success, res.Message = dryRunAction(r, req.HostID, creds.Hostname, service, "restart")
+			if res.Success {
+				summary.Succeeded++
+			} else {
+				summary.Failed++
+			}
+			summary.Results = append(summary.Results, res)
+		}
+		summary.Success = summary.Failed == 0
+		summary.Message = fmt.Sprintf("Dry run: %d of %d failed services would be restarted", summary.Succeeded, summary.Total)
+		respondJSON(w, summary, http.StatusOK)
+		return
+	}
+
+	log.Printf("[INFO] Restarting %d failed services on host %s: %s",
+		len(services), creds.Hostname, strings.Join(services, ", "))
+
+	summary.Results = make([]ServiceActionResult, len(services))
+	var wg sync.WaitGroup
+	for i, service := range services {
+		wg.Add(1)
+		go func(res *ServiceActionResult, service string) {
+			defer wg.Done()
+			_, resp := runServiceAction(r, req.HostID, service, "restart")
+			*res = ServiceActionResult{
+				Service:  service,
+				Success:  resp.Success,
+				Message:  resp.Message,
+				ActionID: resp.ActionID,
+			}
+		}(&summary.Results[i], service)
+	}
+	wg.Wait()
+
+	for _, res := range summary.Results {
+		if res.Success {
+			summary.Succeeded++
+		} else {
+			summary.Failed++
+		}
+	}
+	summary.Success = summary.Failed == 0
+	summary.Message = fmt.Sprintf("Restart of failed services: %d of %d sent", summary.Succeeded, summary.Total)
+	if summary.Failed > 0 {
+		summary.Message += fmt.Sprintf(", %d failed", summary.Failed)
+	}
+
+	auditRequest(r, dbpkg.AuditBulkAction, creds.Hostname, summary.Message, summary.Success)
+	respondJSON(w, summary, http.StatusOK)
+}
+
+// failedProcessServices returns the monitored process services of a host
+// whose status is not OK, by name.
+func failedProcessServices(hostID string) ([]string, error) {
+	rows, err := db.Query(`
+		SELECT name FROM services
+		WHERE host_id = ? AND type = 3 AND monitor = 1 AND status != 0
+		ORDER BY name ASC
+	`, hostID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var services []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		services = append(services, name)
+	}
+	return services, rows.Err()
+}
+
+// dryRunAction reports whether action on a service of a host would be sent
+// to its agent, checking the token role like runServiceAction, without
+// contacting the agent.
+func dryRunAction(r *http.Request, hostID, hostname, service, action string) (bool, string) {
+	allowed, reason, err := authorizeAction(r, hostID, action)
+	if err != nil {
+		log.Printf("[ERROR] %v", err)
+		return false, "Failed to check authorization"
+	}
+	if !allowed {
+		return false, "Would be refused: " + reason
+	}
+	return true, fmt.Sprintf("Would send '%s' to service '%s' on host '%s'", action, service, hostname)
+}
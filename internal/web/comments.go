@@ -0,0 +1,175 @@
+package web
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxEventCommentLength matches the CHECK constraint on event_comments.body.
+const maxEventCommentLength = 1024
+
+// EventComment is a comment of an operator on an event ("known issue,
+// vendor ticket #123").
+type EventComment struct {
+	ID        int64     `json:"id"`
+	EventID   int64     `json:"event_id"`
+	Author    string    `json:"author"` // Web user, or "anonymous" without web authentication
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// EventCommentRequest is the JSON request for commenting an event.
+type EventCommentRequest struct {
+	EventID int64  `json:"event_id"` // Event to comment
+	Body    string `json:"body"`     // Comment (max 1024 characters)
+}
+
+// HandleEventCommentAPI adds a comment to an event.
+//
+// POST /api/v1/events/comments
+//
+// Request body: {"event_id": 42, "body": "known issue, vendor ticket #123"}
+// Response: {"success": true, "message": "..."}
+//
+// The comment records the web user (or "anonymous" when web
+// authentication is disabled) and the time.
+func HandleEventCommentAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondJSON(w, ActionResponse{Success: false, Message: "Method not allowed"}, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req EventCommentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, ActionResponse{Success: false, Message: "Invalid JSON"}, http.StatusBadRequest)
+		return
+	}
+	if req.EventID <= 0 {
+		respondJSON(w, ActionResponse{Success: false, Message: "Missing event_id"}, http.StatusBadRequest)
+		return
+	}
+	req.Body = strings.TrimSpace(req.Body)
+	if req.Body == "" {
+		respondJSON(w, ActionResponse{Success: false, Message: "Missing body"}, http.StatusBadRequest)
+		return
+	}
+	if utf8.RuneCountInString(req.Body) > maxEventCommentLength {
+		respondJSON(w, ActionResponse{Success: false, Message: "Comment too long (max 1024 characters)"}, http.StatusBadRequest)
+		return
+	}
+
+	user := currentUser(r)
+	if user == "" {
+		user = "anonymous"
+	}
+
+	var exists bool
+	if err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM events WHERE id = ?)", req.EventID).Scan(&exists); err != nil {
+		log.Printf("[ERROR] Failed to look up event %d: %v", req.EventID, err)
+		respondJSON(w, ActionResponse{Success: false, Message: "Failed to add comment"}, http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		respondJSON(w, ActionResponse{Success: false, Message: "Event not found"}, http.StatusNotFound)
+		return
+	}
+
+	_, err := db.Exec("INSERT INTO event_comments (event_id, author, body, created_at) VALUES (?, ?, ?, ?)",
+		req.EventID, user, req.Body, time.Now())
+	if err != nil {
+		log.Printf("[ERROR] Failed to add comment to event %d: %v", req.EventID, err)
+		respondJSON(w, ActionResponse{Success: false, Message: "Failed to add comment"}, http.StatusInternalServerError)
+		return
+	}
+	log.Printf("[INFO] Comment added to event %d by %s", req.EventID, user)
+
+	respondJSON(w, ActionResponse{Success: true, Message: "Comment added"}, http.StatusOK)
+}
+
+// HandleEventDetailAPI returns an event with its comments.
+//
+// GET /api/v1/events/detail?id=42
+func HandleEventDetailAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil || id <= 0 {
+		respondJSON(w, map[string]string{"error": "Missing or invalid id"}, http.StatusBadRequest)
+		return
+	}
+
+	var event Event
+	err = db.QueryRow(`
+		SELECT e.id, e.host_id, h.hostname, e.service_name, e.event_type,
+		       COALESCE(e.message, ''), e.created_at,
+		       COALESCE(e.ack_by, ''), e.ack_at, COALESCE(e.ack_note, ''), e.severity, e.flap_count, COALESCE(e.source, '')
+		FROM events e
+		JOIN hosts h ON h.id = e.host_id
+		WHERE e.id = ?`, id).Scan(
+		&event.ID, &event.HostID, &event.Hostname, &event.ServiceName, &event.EventType,
+		&event.Message, &event.CreatedAt, &event.AckBy, &event.AckAt, &event.AckNote, &event.Severity,
+		&event.FlapCount, &event.Source)
+	if err == sql.ErrNoRows {
+		respondJSON(w, map[string]string{"error": "Event not found"}, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("[ERROR] Failed to get event %d: %v", id, err)
+		respondJSON(w, map[string]string{"error": "Failed to get event"}, http.StatusInternalServerError)
+		return
+	}
+	event.EventTypeName = getEventTypeName(event.EventType)
+	if event.Source != "" {
+		event.EventTypeName = "External"
+	}
+
+	events := []Event{event}
+	if err := attachEventComments(events); err != nil {
+		log.Printf("[ERROR] Failed to get comments of event %d: %v", id, err)
+		respondJSON(w, map[string]string{"error": "Failed to get event"}, http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, events[0], http.StatusOK)
+}
+
+// attachEventComments fills the comments of events, oldest first.
+func attachEventComments(events []Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	byID := make(map[int]*Event, len(events))
+	placeholders := make([]string, len(events))
+	args := make([]interface{}, len(events))
+	for i := range events {
+		byID[events[i].ID] = &events[i]
+		placeholders[i] = "?"
+		args[i] = events[i].ID
+	}
+
+	rows, err := db.Query(`SELECT id, event_id, author, body, created_at FROM event_comments
+		WHERE event_id IN (`+strings.Join(placeholders, ",")+`) ORDER BY created_at, id`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c EventComment
+		if err := rows.Scan(&c.ID, &c.EventID, &c.Author, &c.Body, &c.CreatedAt); err != nil {
+			return err
+		}
+		if event := byID[int(c.EventID)]; event != nil {
+			event.Comments = append(event.Comments, c)
+		}
+	}
+	return rows.Err()
+}
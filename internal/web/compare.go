@@ -0,0 +1,311 @@
+package web
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxCompareHosts bounds the number of hosts overlaid on one graph.
+const maxCompareHosts = 10
+
+// compareMetric describes one comparable system metric. A host's value in a
+// bucket is the sum of the bucket averages of Names (for CPU, the total of
+// user, system, nice and wait).
+type compareMetric struct {
+	Type  string
+	Names []string
+}
+
+// compareMetrics are the metrics available on the comparison view.
+var compareMetrics = map[string]compareMetric{
+	"cpu":    {Type: "cpu", Names: []string{"user", "system", "nice", "wait"}},
+	"memory": {Type: "memory", Names: []string{"percent"}},
+	"load":   {Type: "load", Names: []string{"avg01"}},
+}
+
+// CompareSeries is one host's series in a comparison. Values are aligned
+// with CompareResponse.Timestamps; null where the host has no data.
+type CompareSeries struct {
+	HostID   string     `json:"host_id"`
+	Hostname string     `json:"hostname"`
+	Values   []*float64 `json:"values"`
+}
+
+// CompareResponse is the JSON response for the host comparison API.
+type CompareResponse struct {
+	Metric     string          `json:"metric"`     // cpu, memory or load
+	StartTime  time.Time       `json:"start_time"` // Start of time range
+	EndTime    time.Time       `json:"end_time"`   // End of time range
+	Bucket     string          `json:"bucket"`     // Bucket width (e.g., "5m0s")
+	Timestamps []string        `json:"timestamps"` // Shared time axis (RFC 3339)
+	Hosts      []CompareSeries `json:"hosts"`
+}
+
+// CompareData holds data for the host comparison page.
+type CompareData struct {
+	Hosts      []HostOption    // All hosts, for the selection form
+	Selected   []HostOption    // Hosts being compared, in request order
+	Range      string          // Time range
+	Error      string          // Invalid selection message
+	SelectedID map[string]bool // Selected host IDs, for the form
+	LastUpdate time.Time
+	AppVersion string
+	Prefs      Preferences
+}
+
+// parseCompareHosts returns the host IDs from the "hosts" parameter, which
+// may be repeated or comma-separated, without duplicates.
+func parseCompareHosts(r *http.Request) []string {
+	var ids []string
+	seen := make(map[string]bool)
+	for _, v := range r.URL.Query()["hosts"] {
+		for _, id := range strings.Split(v, ",") {
+			id = strings.TrimSpace(id)
+			if id != "" && !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+// lookupCompareHosts resolves host IDs to HostOptions, keeping their order.
+func lookupCompareHosts(ids []string) ([]HostOption, error) {
+	if len(ids) > maxCompareHosts {
+		return nil, fmt.Errorf("too many hosts (max %d)", maxCompareHosts)
+	}
+
+	hosts := make([]HostOption, 0, len(ids))
+	for _, id := range ids {
+		h := HostOption{ID: id}
+		err := db.QueryRow("SELECT hostname FROM hosts WHERE id = ?", id).Scan(&h.Hostname)
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("unknown host %q", id)
+		}
+		if err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, h)
+	}
+	return hosts, nil
+}
+
+// compareBucketPoint is one host's value in one time bucket.
+type compareBucketPoint struct {
+	value float64
+	ts    time.Time // Bucket start, with the zone offset restored
+}
+
+// getCompareBuckets returns a host's bucketed values for metric, keyed by
+// bucket index. See getAggregatedMetricsForService for the bucketing of
+// the stored time text.
+func getCompareBuckets(hostID string, m compareMetric, startTime, endTime time.Time, bucket time.Duration) (map[int64]compareBucketPoint, error) {
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(m.Names)), ", ")
+	query := `
+		SELECT bucket, SUM(avg_value), MIN(first_sample)
+		FROM (
+			SELECT metric_name,
+			       CAST(strftime('%s', substr(collected_at, 1, 19)) AS INTEGER) / ? AS bucket,
+			       AVG(value) AS avg_value,
+			       MIN(collected_at) AS first_sample
+			FROM metrics
+			WHERE metric_type = ? AND host_id = ?
+			  AND metric_name IN (` + placeholders + `)
+			  AND collected_at BETWEEN ? AND ?
+			GROUP BY metric_name, bucket
+		)
+		GROUP BY bucket
+	`
+
+	bucketSecs := int64(bucket / time.Second)
+	args := []interface{}{bucketSecs, m.Type, hostID}
+	for _, name := range m.Names {
+		args = append(args, name)
+	}
+	args = append(args, startTime, endTime)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	points := make(map[int64]compareBucketPoint)
+	for rows.Next() {
+		var bucketIndex int64
+		var value float64
+		var firstSample string
+		if err := rows.Scan(&bucketIndex, &value, &firstSample); err != nil {
+			return nil, err
+		}
+
+		ts := time.Unix(bucketIndex*bucketSecs, 0).UTC()
+		if t, err := time.Parse(storedTimeLayout, firstSample); err == nil {
+			_, offset := t.Zone()
+			ts = ts.Add(-time.Duration(offset) * time.Second)
+		}
+		points[bucketIndex] = compareBucketPoint{value: value, ts: ts}
+	}
+	return points, rows.Err()
+}
+
+// getComparison builds the aligned series of metric for hosts.
+func getComparison(hosts []HostOption, metric string, duration time.Duration) (*CompareResponse, error) {
+	m := compareMetrics[metric]
+	endTime := time.Now()
+	startTime := endTime.Add(-duration)
+	bucket := autoBucket(duration)
+
+	perHost := make([]map[int64]compareBucketPoint, len(hosts))
+	axis := make(map[int64]time.Time)
+	for i, h := range hosts {
+		points, err := getCompareBuckets(h.ID, m, startTime, endTime, bucket)
+		if err != nil {
+			return nil, err
+		}
+		perHost[i] = points
+		for idx, p := range points {
+			if _, ok := axis[idx]; !ok {
+				axis[idx] = p.ts
+			}
+		}
+	}
+
+	indexes := make([]int64, 0, len(axis))
+	for idx := range axis {
+		indexes = append(indexes, idx)
+	}
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+
+	resp := &CompareResponse{
+		Metric:     metric,
+		StartTime:  startTime,
+		EndTime:    endTime,
+		Bucket:     bucket.String(),
+		Timestamps: make([]string, len(indexes)),
+		Hosts:      make([]CompareSeries, len(hosts)),
+	}
+	for i, idx := range indexes {
+		resp.Timestamps[i] = axis[idx].Format(time.RFC3339)
+	}
+	for i, h := range hosts {
+		series := CompareSeries{HostID: h.ID, Hostname: h.Hostname, Values: make([]*float64, len(indexes))}
+		for j, idx := range indexes {
+			if p, ok := perHost[i][idx]; ok {
+				v := p.value
+				series.Values[j] = &v
+			}
+		}
+		resp.Hosts[i] = series
+	}
+	return resp, nil
+}
+
+// HandleCompare serves the host comparison page.
+//
+// GET /compare?hosts=a,b,c&range=24h
+//
+// The page overlays CPU, memory and load graphs of the selected hosts on
+// shared axes; the graphs are loaded from /api/v1/compare.
+func HandleCompare(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	prefs := loadPreferences(r)
+	data := CompareData{
+		Range:      r.URL.Query().Get("range"),
+		SelectedID: make(map[string]bool),
+		LastUpdate: time.Now(),
+		AppVersion: appVersion,
+		Prefs:      prefs,
+	}
+	if data.Range == "" {
+		data.Range = prefs.DefaultRange
+	}
+
+	var err error
+	if data.Hosts, err = getHostOptions(); err != nil {
+		log.Printf("[ERROR] Failed to get hosts for comparison: %v", err)
+		http.Error(w, "Failed to load hosts", http.StatusInternalServerError)
+		return
+	}
+
+	selected, err := lookupCompareHosts(parseCompareHosts(r))
+	if err != nil {
+		data.Error = "Invalid selection: " + err.Error()
+	}
+	data.Selected = selected
+	for _, h := range selected {
+		data.SelectedID[h.ID] = true
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	err = templates.ExecuteTemplate(w, "compare.html", data)
+	if err != nil {
+		log.Printf("[ERROR] Failed to render template: %v", err)
+	}
+}
+
+// HandleCompareAPI returns one metric of several hosts on a shared time axis.
+//
+// GET /api/v1/compare?hosts=a,b,c&metric=cpu|memory|load&range=24h
+//
+// Values are averaged into buckets sized for the range (as with agg=avg on
+// /api/v1/metrics). cpu is the total of user, system, nice and wait; memory
+// is the used percentage; load is the 1-minute load average.
+func HandleCompareAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+
+	metric := query.Get("metric")
+	if metric == "" {
+		metric = "cpu"
+	}
+	if _, ok := compareMetrics[metric]; !ok {
+		respondJSON(w, map[string]string{"error": "Invalid metric (cpu, memory or load)"}, http.StatusBadRequest)
+		return
+	}
+
+	rangeStr := query.Get("range")
+	if rangeStr == "" {
+		rangeStr = loadPreferences(r).DefaultRange
+	}
+	duration, err := parseTimeRange(rangeStr)
+	if err != nil || duration <= 0 {
+		respondJSON(w, map[string]string{"error": "Invalid range parameter"}, http.StatusBadRequest)
+		return
+	}
+
+	ids := parseCompareHosts(r)
+	if len(ids) == 0 {
+		respondJSON(w, map[string]string{"error": "Missing hosts parameter"}, http.StatusBadRequest)
+		return
+	}
+	hosts, err := lookupCompareHosts(ids)
+	if err != nil {
+		respondJSON(w, map[string]string{"error": err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	resp, err := getComparison(hosts, metric, duration)
+	if err != nil {
+		log.Printf("[ERROR] Failed to compare hosts: %v", err)
+		respondJSON(w, map[string]string{"error": "Failed to get metrics"}, http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, resp, http.StatusOK)
+}
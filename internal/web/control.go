@@ -0,0 +1,248 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/ocochard/cmonit/internal/control"
+	dbpkg "github.com/ocochard/cmonit/internal/db"
+)
+
+// monitCAFile is the default CA bundle verifying the HTTPS certificates of
+// Monit agents (-monit-ca-file); empty uses the system roots.
+var monitCAFile string
+
+// monitClients caches the clients of Monit agents, keeping their
+// connections alive between service actions.
+var monitClients = control.NewPool(control.DefaultClientOptions)
+
+// SetMonitClientOptions sets the timeouts and retry policy of the requests
+// to Monit agents.
+func SetMonitClientOptions(opts control.ClientOptions) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	monitClients.SetOptions(opts)
+	return nil
+}
+
+// monitClient returns the client of hostID's Monit agent, using HTTPS for
+// agents with "with ssl" in their "set httpd" statement, and the host's
+// credential overrides instead of the reported credentials when set.
+func monitClient(hostID string, creds *HostCredentials) (*control.MonitClient, error) {
+	hc, err := dbpkg.GetHostControl(db, hostID)
+	if err != nil {
+		return nil, err
+	}
+	ep := control.Endpoint{
+		Host:     creds.HTTPAddress,
+		Port:     creds.HTTPPort,
+		Username: creds.HTTPUsername,
+		Password: creds.HTTPPassword,
+		SSL:      creds.HTTPSSL == 1,
+	}
+	if hc.Username != "" {
+		ep.Username = hc.Username
+	}
+	if hc.HasPassword {
+		if ep.Password, err = hc.Password(); err != nil {
+			return nil, fmt.Errorf("Monit password of the host: %w", err)
+		}
+	}
+	if ep.SSL {
+		ep.TLS = monitTLSOptions(hc)
+	}
+	return monitClients.Client(hostID, ep)
+}
+
+// actionErrorStatus returns the HTTP status reporting a failed exchange
+// with a Monit agent: 502 when the agent rejected the credentials or
+// answered with an error, 504 when it could not be reached.
+func actionErrorStatus(err error) int {
+	switch control.KindOf(err) {
+	case control.NetworkError:
+		return http.StatusGatewayTimeout
+	case control.AuthError, control.AgentError:
+		return http.StatusBadGateway
+	}
+	return http.StatusInternalServerError
+}
+
+// SetMonitCAFile sets the default CA bundle for Monit agents using HTTPS.
+func SetMonitCAFile(path string) error {
+	if path != "" {
+		if _, err := control.LoadCAFile(path); err != nil {
+			return err
+		}
+	}
+	monitCAFile = path
+	return nil
+}
+
+// monitTLSOptions returns how to verify the certificate of a host's Monit
+// agent: the host's own settings, falling back to the default CA bundle.
+func monitTLSOptions(hc *dbpkg.HostControl) control.TLSOptions {
+	opts := control.TLSOptions{
+		CAFile:             hc.CAFile,
+		InsecureSkipVerify: hc.InsecureSkipVerify,
+	}
+	if opts.CAFile == "" {
+		opts.CAFile = monitCAFile
+	}
+	return opts
+}
+
+// HostControlRequest is the request body of POST /api/v1/host/control.
+// Username and Password are kept unchanged when omitted; an empty value
+// removes the override.
+type HostControlRequest struct {
+	HostID             string  `json:"host_id"`
+	CAFile             string  `json:"ca_file"`
+	InsecureSkipVerify bool    `json:"insecure_skip_verify"`
+	Username           *string `json:"username,omitempty"`
+	Password           *string `json:"password,omitempty"`
+}
+
+// HostControlResponse describes the Monit agent connection settings of a
+// host. DefaultCAFile is the CA bundle used when CAFile is empty.
+type HostControlResponse struct {
+	dbpkg.HostControl
+	SSL           bool   `json:"ssl"`
+	DefaultCAFile string `json:"default_ca_file"`
+}
+
+// HandleHostControlAPI gets or sets how cmonit connects to a host's Monit
+// agent for service actions (CA bundle and certificate verification for
+// agents using HTTPS, credentials overriding the reported ones). Like
+// connection details, these settings need the admin scope when using an API
+// token. The password is stored encrypted and never returned.
+//
+// GET /api/v1/host/control?host_id=...
+// POST /api/v1/host/control
+//
+// Request body: {"host_id": "...", "ca_file": "...", "insecure_skip_verify": false, "username": "...", "password": "..."}
+// Response: {"success": true, "message": "..."}
+func HandleHostControlAPI(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		respondJSON(w, map[string]string{"error": "Insufficient scope: admin required"}, http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		hostID := r.URL.Query().Get("host_id")
+		if hostID == "" {
+			respondJSON(w, map[string]string{"error": "Missing host_id parameter"}, http.StatusBadRequest)
+			return
+		}
+		creds, err := getHostCredentials(hostID)
+		if err != nil {
+			respondJSON(w, map[string]string{"error": "Host not found"}, http.StatusNotFound)
+			return
+		}
+		hc, err := dbpkg.GetHostControl(db, hostID)
+		if err != nil {
+			log.Printf("[ERROR] %v", err)
+			respondJSON(w, map[string]string{"error": "Failed to get control settings"}, http.StatusInternalServerError)
+			return
+		}
+		respondJSON(w, HostControlResponse{
+			HostControl:   *hc,
+			SSL:           creds.HTTPSSL == 1,
+			DefaultCAFile: monitCAFile,
+		}, http.StatusOK)
+
+	case http.MethodPost:
+		var req HostControlRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondJSON(w, ActionResponse{
+				Success: false,
+				Message: "Invalid JSON",
+			}, http.StatusBadRequest)
+			return
+		}
+		if req.HostID == "" {
+			respondJSON(w, ActionResponse{
+				Success: false,
+				Message: "Missing host_id",
+			}, http.StatusBadRequest)
+			return
+		}
+		if _, err := getHostCredentials(req.HostID); err != nil {
+			respondJSON(w, ActionResponse{
+				Success: false,
+				Message: "Host not found",
+			}, http.StatusNotFound)
+			return
+		}
+
+		// Check the CA bundle now rather than at the next action
+		req.CAFile = strings.TrimSpace(req.CAFile)
+		if req.CAFile != "" {
+			if _, err := control.LoadCAFile(req.CAFile); err != nil {
+				respondJSON(w, ActionResponse{
+					Success: false,
+					Message: "Invalid CA file: " + err.Error(),
+				}, http.StatusBadRequest)
+				return
+			}
+		}
+
+		hc, err := dbpkg.GetHostControl(db, req.HostID)
+		if err != nil {
+			log.Printf("[ERROR] %v", err)
+			respondJSON(w, ActionResponse{
+				Success: false,
+				Message: "Failed to get control settings",
+			}, http.StatusInternalServerError)
+			return
+		}
+		hc.CAFile = req.CAFile
+		hc.InsecureSkipVerify = req.InsecureSkipVerify
+		if req.Username != nil {
+			hc.Username = strings.TrimSpace(*req.Username)
+		}
+		if req.Password != nil {
+			hc.PasswordEnc, err = dbpkg.EncryptSecret(*req.Password, req.HostID)
+			if err != nil {
+				log.Printf("[ERROR] Failed to encrypt Monit password of host %s: %v", req.HostID, err)
+				respondJSON(w, ActionResponse{
+					Success: false,
+					Message: "Failed to encrypt the password: " + err.Error(),
+				}, http.StatusInternalServerError)
+				return
+			}
+		}
+
+		err = dbpkg.SetHostControl(db, hc)
+		if err != nil {
+			log.Printf("[ERROR] %v", err)
+			respondJSON(w, ActionResponse{
+				Success: false,
+				Message: "Failed to update control settings",
+			}, http.StatusInternalServerError)
+			return
+		}
+
+		log.Printf("[INFO] Updated Monit connection settings of host %s", req.HostID)
+		details := fmt.Sprintf("ca_file=%q insecure_skip_verify=%t", req.CAFile, req.InsecureSkipVerify)
+		if req.Username != nil {
+			details += fmt.Sprintf(" username=%q", hc.Username)
+		}
+		if req.Password != nil {
+			details += fmt.Sprintf(" password_set=%t", *req.Password != "")
+		}
+		auditRequest(r, dbpkg.AuditHostUpdate, req.HostID, details, true)
+
+		respondJSON(w, ActionResponse{
+			Success: true,
+			Message: "Monit connection settings saved",
+		}, http.StatusOK)
+
+	default:
+		respondJSON(w, map[string]string{"error": "Method not allowed"}, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,125 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCrossOrigin(t *testing.T) {
+	tests := []struct {
+		name, origin, fetchSite string
+		want                    bool
+	}{
+		{"no headers", "", "", false},
+		{"same origin", "http://cmonit.example.org:3000", "same-origin", false},
+		{"other site", "https://evil.example", "", true},
+		{"other port", "http://cmonit.example.org:8080", "", true},
+		{"sec-fetch-site", "", "cross-site", true},
+		{"invalid origin", "://", "", true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "http://cmonit.example.org:3000/api/v1/action", nil)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if tt.fetchSite != "" {
+			r.Header.Set("Sec-Fetch-Site", tt.fetchSite)
+		}
+		if got := crossOrigin(r); got != tt.want {
+			t.Errorf("%s: crossOrigin = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestCSRFProtect checks the double-submit token of browser requests and
+// the requests let through without it.
+func TestCSRFProtect(t *testing.T) {
+	handler := CSRFProtect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	token := strings.Repeat("ab", csrfTokenLength/2)
+	cookie := &http.Cookie{Name: csrfCookieName, Value: token}
+
+	tests := []struct {
+		name   string
+		method string
+		setup  func(r *http.Request)
+		body   string
+		status int
+	}{
+		{"GET without token", http.MethodGet, func(r *http.Request) {}, "", http.StatusNoContent},
+		{"script without cookies", http.MethodPost, func(r *http.Request) {}, "", http.StatusNoContent},
+		{"browser with header", http.MethodPost, func(r *http.Request) {
+			r.AddCookie(cookie)
+			r.Header.Set(csrfHeaderName, token)
+		}, "", http.StatusNoContent},
+		{"browser with form field", http.MethodPost, func(r *http.Request) {
+			r.AddCookie(cookie)
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}, url.Values{csrfFormField: {token}}.Encode(), http.StatusNoContent},
+		{"browser without token", http.MethodPost, func(r *http.Request) {
+			r.AddCookie(cookie)
+		}, "", http.StatusForbidden},
+		{"browser with wrong token", http.MethodDelete, func(r *http.Request) {
+			r.AddCookie(cookie)
+			r.Header.Set(csrfHeaderName, strings.Repeat("cd", csrfTokenLength/2))
+		}, "", http.StatusForbidden},
+		{"same-origin Origin without token", http.MethodPost, func(r *http.Request) {
+			r.Header.Set("Origin", "http://example.com")
+		}, "", http.StatusForbidden},
+		{"Authorization header", http.MethodPost, func(r *http.Request) {
+			r.AddCookie(cookie)
+			r.Header.Set("Authorization", "Bearer x")
+		}, "", http.StatusNoContent},
+		{"cross-origin with token", http.MethodPost, func(r *http.Request) {
+			r.AddCookie(cookie)
+			r.Header.Set(csrfHeaderName, token)
+			r.Header.Set("Origin", "https://evil.example")
+		}, "", http.StatusForbidden},
+		{"cross-origin with Authorization", http.MethodPost, func(r *http.Request) {
+			r.Header.Set("Authorization", "Basic YWRtaW46bW9uaXQ=")
+			r.Header.Set("Sec-Fetch-Site", "cross-site")
+		}, "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, "http://example.com/api/v1/action", strings.NewReader(tt.body))
+		tt.setup(r)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.status)
+		}
+	}
+}
+
+// TestCSRFProtectCookie checks that the token cookie is issued when
+// missing or malformed, and kept otherwise.
+func TestCSRFProtectCookie(t *testing.T) {
+	handler := CSRFProtect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, value := range []string{"", "short"} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if value != "" {
+			r.AddCookie(&http.Cookie{Name: csrfCookieName, Value: value})
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		cookies := w.Result().Cookies()
+		if len(cookies) != 1 || cookies[0].Name != csrfCookieName || len(cookies[0].Value) != csrfTokenLength {
+			t.Errorf("cookie %q: issued %v, want a new %s", value, cookies, csrfCookieName)
+		} else if cookies[0].HttpOnly {
+			t.Errorf("cookie %q: HttpOnly, page scripts cannot read it", value)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(&http.Cookie{Name: csrfCookieName, Value: strings.Repeat("ab", csrfTokenLength/2)})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if cookies := w.Result().Cookies(); len(cookies) != 0 {
+		t.Errorf("valid cookie replaced: %v", cookies)
+	}
+}
@@ -0,0 +1,165 @@
+package web
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ocochard/cmonit/internal/control"
+	dbpkg "github.com/ocochard/cmonit/internal/db"
+)
+
+// daemonActionService is the service name recorded in the action history
+// for daemon actions, which apply to the whole agent.
+const daemonActionService = "all"
+
+// DaemonActionRequest is the request body of POST /api/v1/host/daemon.
+type DaemonActionRequest struct {
+	HostID string `json:"host_id"`
+	Action string `json:"action"` // validate, or a service action applied to all services
+}
+
+// HandleDaemonActionAPI sends an agent-wide action to a host's Monit
+// daemon: "validate" checks every service now, and start, stop, restart,
+// monitor and unmonitor apply to all its services. These affect the whole
+// host, so API tokens need the admin scope.
+//
+// POST /api/v1/host/daemon
+//
+// Request body: {"host_id": "...", "action": "restart"}
+// Response: {"success": true, "message": "...", "action_id": 42}
+func HandleDaemonActionAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondJSON(w, ActionResponse{
+			Success: false,
+			Message: "Method not allowed",
+		}, http.StatusMethodNotAllowed)
+		return
+	}
+	if !isAdmin(r) {
+		respondJSON(w, ActionResponse{
+			Success: false,
+			Message: "Insufficient scope: admin required",
+		}, http.StatusForbidden)
+		return
+	}
+
+	var req DaemonActionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.HostID == "" {
+		respondJSON(w, ActionResponse{
+			Success: false,
+			Message: "Invalid request body (host_id required)",
+		}, http.StatusBadRequest)
+		return
+	}
+	if !control.ValidDaemonAction(req.Action) {
+		respondJSON(w, ActionResponse{
+			Success: false,
+			Message: "Invalid action, must be one of: " + strings.Join(control.DaemonActions, ", "),
+		}, http.StatusBadRequest)
+		return
+	}
+
+	creds, err := getHostCredentials(req.HostID)
+	if err != nil {
+		respondJSON(w, ActionResponse{
+			Success: false,
+			Message: "Host not found",
+		}, http.StatusNotFound)
+		return
+	}
+
+	allowed, reason, err := authorizeAction(r, req.HostID, req.Action)
+	if err != nil {
+		log.Printf("[ERROR] %v", err)
+		respondJSON(w, ActionResponse{
+			Success: false,
+			Message: "Failed to check authorization",
+		}, http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		log.Printf("[WARNING] Daemon action '%s' on %s refused for %s: %s",
+			req.Action, creds.Hostname, currentUser(r), reason)
+		auditRequest(r, dbpkg.AuditAccessDenied, creds.Hostname, req.Action+" all: "+reason, false)
+		respondJSON(w, ActionResponse{
+			Success: false,
+			Message: "Not allowed: " + reason,
+		}, http.StatusForbidden)
+		return
+	}
+
+	// Service actions name every service the agent last reported
+	var services []string
+	if req.Action != control.DaemonValidate {
+		services, err = hostServiceNames(req.HostID)
+		if err != nil {
+			log.Printf("[ERROR] Failed to list services of host %s: %v", creds.Hostname, err)
+			respondJSON(w, ActionResponse{
+				Success: false,
+				Message: "Failed to list services",
+			}, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	log.Printf("[INFO] Executing daemon action '%s' on host %s", req.Action, creds.Hostname)
+
+	release := actionSlots.acquire(req.HostID)
+	started := time.Now()
+	client, err := monitClient(req.HostID, creds)
+	if err == nil {
+		err = client.ExecuteDaemonAction(req.Action, services)
+	}
+	release()
+	actionID := recordAction(r, req.HostID, creds.Hostname, daemonActionService, req.Action, started, err, false)
+	if err != nil {
+		log.Printf("[ERROR] Failed to execute daemon action on host %s: %v", creds.Hostname, err)
+		auditRequest(r, dbpkg.AuditDaemonAction, creds.Hostname, req.Action+": "+err.Error(), false)
+		message := "Failed to execute action: " + err.Error()
+		if control.KindOf(err) == control.AuthError {
+			message += " (check the Monit username and password of the host)"
+		}
+		respondJSON(w, ActionResponse{
+			Success:  false,
+			Message:  message,
+			ActionID: actionID,
+		}, actionErrorStatus(err))
+		return
+	}
+
+	log.Printf("[INFO] Daemon action '%s' successfully sent to host '%s'", req.Action, creds.Hostname)
+	auditRequest(r, dbpkg.AuditDaemonAction, creds.Hostname, req.Action, true)
+
+	message := "Validation requested on host '" + creds.Hostname + "'"
+	if req.Action != control.DaemonValidate {
+		message = "Action '" + req.Action + "' successfully sent to all services on host '" + creds.Hostname + "'"
+	}
+	respondJSON(w, ActionResponse{
+		Success:  true,
+		Message:  message,
+		ActionID: actionID,
+	}, http.StatusOK)
+}
+
+// hostServiceNames returns the names of the services of a host, as last
+// reported by its agent.
+func hostServiceNames(hostID string) ([]string, error) {
+	rows, err := db.Query(`SELECT name FROM services WHERE host_id = ? ORDER BY name ASC`, hostID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
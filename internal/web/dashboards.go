@@ -0,0 +1,480 @@
+package web
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Widget types supported by user dashboards.
+const (
+	WidgetHostGrid    = "host_grid"    // Status tiles for all hosts, optionally filtered by group
+	WidgetMetricGraph = "metric_graph" // Chart of one metric type of one service
+	WidgetTopCPU      = "top_cpu"      // Hosts with the highest system CPU usage
+	WidgetHotSpots    = "hot_spots"    // Top hosts and processes/filesystems by CPU, memory or disk
+)
+
+// maxDashboardWidgets bounds the size of a stored dashboard.
+const maxDashboardWidgets = 50
+
+// Widget is a single dashboard element. Which fields are used depends on Type.
+type Widget struct {
+	Type       string `json:"type"`
+	Title      string `json:"title,omitempty"`
+	Group      string `json:"group,omitempty"`       // host_grid: hostgroup filter
+	HostID     string `json:"host_id,omitempty"`     // metric_graph: host
+	Service    string `json:"service,omitempty"`     // metric_graph: service name
+	MetricType string `json:"metric_type,omitempty"` // metric_graph: e.g. "cpu", "load", "memory"; hot_spots: cpu, memory, disk
+	Range      string `json:"range,omitempty"`       // metric_graph: 1h, 6h, 24h, 7d, 30d
+	Limit      int    `json:"limit,omitempty"`       // top_cpu, hot_spots: number of entries
+}
+
+// Dashboard is a stored, user-composed dashboard.
+type Dashboard struct {
+	ID        int64     `json:"id"`
+	Owner     string    `json:"owner"` // "" for shared dashboards
+	Name      string    `json:"name"`
+	IsDefault bool      `json:"is_default"`
+	Widgets   []Widget  `json:"widgets"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// DashboardRequest is the JSON body for creating or updating a dashboard.
+type DashboardRequest struct {
+	Name    string   `json:"name"`
+	Shared  bool     `json:"shared"` // Store with an empty owner, visible to everyone
+	Widgets []Widget `json:"widgets"`
+}
+
+// WidgetView is a widget plus the server-side data needed to render it.
+type WidgetView struct {
+	Widget
+	Index int          // Position on the dashboard, used for element IDs
+	Hosts []HostStatus // host_grid and top_cpu data
+	Top   *TopResponse // hot_spots data
+}
+
+// DashboardListData holds data for the dashboards index page.
+type DashboardListData struct {
+	User       string
+	Dashboards []Dashboard
+	LastUpdate time.Time
+	AppVersion string
+	Prefs      Preferences
+}
+
+// DashboardViewData holds data for a single dashboard page.
+type DashboardViewData struct {
+	User       string
+	Dashboard  Dashboard
+	Widgets    []WidgetView
+	CanEdit    bool
+	Groups     []string
+	LastUpdate time.Time
+	AppVersion string
+	Prefs      Preferences
+}
+
+// currentUser returns the name of the authenticated web user, or "" for
+// anonymous access (no web authentication configured). The user is set by
+// RequireLogin from the session cookie or Basic Auth credentials.
+func currentUser(r *http.Request) string {
+	user, _ := r.Context().Value(userContextKey).(string)
+	return user
+}
+
+// validateWidgets checks widget types and fills in defaults.
+func validateWidgets(widgets []Widget) error {
+	if len(widgets) > maxDashboardWidgets {
+		return fmt.Errorf("too many widgets (max %d)", maxDashboardWidgets)
+	}
+	for i := range widgets {
+		w := &widgets[i]
+		switch w.Type {
+		case WidgetHostGrid:
+		case WidgetMetricGraph:
+			if w.HostID == "" || w.Service == "" || w.MetricType == "" {
+				return fmt.Errorf("widget %d: metric_graph requires host_id, service and metric_type", i)
+			}
+			if w.Range == "" {
+				w.Range = "24h"
+			}
+			if _, err := parseTimeRange(w.Range); err != nil {
+				return fmt.Errorf("widget %d: invalid range %q", i, w.Range)
+			}
+		case WidgetTopCPU, WidgetHotSpots:
+			if w.Limit <= 0 {
+				w.Limit = 10
+			}
+			if w.Limit > 100 {
+				w.Limit = 100
+			}
+			if w.Type == WidgetHotSpots {
+				if w.MetricType == "" {
+					w.MetricType = "cpu"
+				}
+				if _, ok := topQueries[w.MetricType]; !ok {
+					return fmt.Errorf("widget %d: hot_spots metric_type must be cpu, memory or disk", i)
+				}
+			}
+		default:
+			return fmt.Errorf("widget %d: unknown type %q", i, w.Type)
+		}
+	}
+	return nil
+}
+
+// defaultWidgets is the layout of the built-in default dashboard.
+func defaultWidgets() []Widget {
+	return []Widget{
+		{Type: WidgetHostGrid, Title: "All hosts"},
+		{Type: WidgetTopCPU, Title: "Top CPU", Limit: 10},
+	}
+}
+
+// ensureDefaultDashboard creates the shared default dashboard if none exists.
+func ensureDefaultDashboard() error {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM dashboards WHERE is_default = 1`).Scan(&count)
+	if err != nil || count > 0 {
+		return err
+	}
+
+	widgets, _ := json.Marshal(defaultWidgets())
+	_, err = db.Exec(`
+		INSERT OR IGNORE INTO dashboards (owner, name, widgets, is_default)
+		VALUES ('', 'Default', ?, 1)
+	`, string(widgets))
+	return err
+}
+
+// scanDashboard reads one dashboards row.
+func scanDashboard(row interface{ Scan(...interface{}) error }) (Dashboard, error) {
+	var d Dashboard
+	var widgets string
+	var isDefault int
+	err := row.Scan(&d.ID, &d.Owner, &d.Name, &widgets, &isDefault, &d.UpdatedAt)
+	if err != nil {
+		return d, err
+	}
+	d.IsDefault = isDefault == 1
+	if err := json.Unmarshal([]byte(widgets), &d.Widgets); err != nil {
+		return d, fmt.Errorf("invalid widgets for dashboard %d: %w", d.ID, err)
+	}
+	return d, nil
+}
+
+// listDashboards returns the dashboards visible to user: its own plus shared.
+func listDashboards(user string) ([]Dashboard, error) {
+	if err := ensureDefaultDashboard(); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT id, owner, name, widgets, is_default, updated_at
+		FROM dashboards
+		WHERE owner = ? OR owner = ''
+		ORDER BY is_default DESC, owner DESC, name ASC
+	`, user)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	dashboards := []Dashboard{}
+	for rows.Next() {
+		d, err := scanDashboard(rows)
+		if err != nil {
+			return nil, err
+		}
+		dashboards = append(dashboards, d)
+	}
+	return dashboards, rows.Err()
+}
+
+// getDashboard loads a dashboard visible to user. Returns sql.ErrNoRows if
+// it does not exist or belongs to another user.
+func getDashboard(id int64, user string) (Dashboard, error) {
+	row := db.QueryRow(`
+		SELECT id, owner, name, widgets, is_default, updated_at
+		FROM dashboards
+		WHERE id = ? AND (owner = ? OR owner = '')
+	`, id, user)
+	return scanDashboard(row)
+}
+
+// canEditDashboard reports whether user may modify d. Shared dashboards can
+// be edited by anyone allowed to reach the web UI.
+func canEditDashboard(d Dashboard, user string) bool {
+	return d.Owner == "" || d.Owner == user
+}
+
+// buildWidgetViews loads the server-side data for each widget.
+func buildWidgetViews(widgets []Widget) []WidgetView {
+	views := make([]WidgetView, len(widgets))
+	for i, w := range widgets {
+		views[i] = WidgetView{Widget: w, Index: i}
+
+		switch w.Type {
+		case WidgetHostGrid:
+			data, err := getStatusData(StatusQuery{
+				Group: w.Group, Sort: "status", Dir: "asc", Page: 1, PerPage: maxStatusPerPage,
+			})
+			if err != nil {
+				log.Printf("[ERROR] Failed to load host grid widget: %v", err)
+				continue
+			}
+			views[i].Hosts = data.Hosts
+
+		case WidgetTopCPU:
+			data, err := getStatusData(StatusQuery{
+				Sort: "cpu", Dir: "desc", Page: 1, PerPage: maxStatusPerPage,
+			})
+			if err != nil {
+				log.Printf("[ERROR] Failed to load top CPU widget: %v", err)
+				continue
+			}
+			// Sorted by CPU descending; hosts without CPU data sort last.
+			hosts := make([]HostStatus, 0, w.Limit)
+			for _, h := range data.Hosts {
+				if h.CPUPercent == nil || len(hosts) == w.Limit {
+					break
+				}
+				hosts = append(hosts, h)
+			}
+			views[i].Hosts = hosts
+
+		case WidgetHotSpots:
+			top, err := getTopConsumers(w.MetricType, w.Limit)
+			if err != nil {
+				log.Printf("[ERROR] Failed to load hot spots widget: %v", err)
+				continue
+			}
+			views[i].Top = top
+		}
+	}
+	return views
+}
+
+// HandleDashboards serves the user dashboard pages.
+//
+// GET /dashboards       - list of dashboards visible to the current user
+// GET /dashboards/{id}  - render a dashboard
+func HandleDashboards(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user := currentUser(r)
+	idStr := strings.Trim(strings.TrimPrefix(r.URL.Path, "/dashboards"), "/")
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	if idStr == "" {
+		dashboards, err := listDashboards(user)
+		if err != nil {
+			log.Printf("[ERROR] Failed to list dashboards: %v", err)
+			http.Error(w, "Failed to load dashboards", http.StatusInternalServerError)
+			return
+		}
+
+		err = templates.ExecuteTemplate(w, "dashboards.html", DashboardListData{
+			User:       user,
+			Dashboards: dashboards,
+			LastUpdate: time.Now(),
+			AppVersion: appVersion,
+			Prefs:      loadPreferences(r),
+		})
+		if err != nil {
+			log.Printf("[ERROR] Failed to render template: %v", err)
+		}
+		return
+	}
+
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid dashboard ID", http.StatusBadRequest)
+		return
+	}
+
+	d, err := getDashboard(id, user)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Dashboard not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("[ERROR] Failed to load dashboard %d: %v", id, err)
+		http.Error(w, "Failed to load dashboard", http.StatusInternalServerError)
+		return
+	}
+
+	groups, err := getAllHostGroups()
+	if err != nil {
+		log.Printf("[ERROR] Failed to get all hostgroups: %v", err)
+	}
+
+	err = templates.ExecuteTemplate(w, "custom_dashboard.html", DashboardViewData{
+		User:       user,
+		Dashboard:  d,
+		Widgets:    buildWidgetViews(d.Widgets),
+		CanEdit:    canEditDashboard(d, user),
+		Groups:     groups,
+		LastUpdate: time.Now(),
+		AppVersion: appVersion,
+		Prefs:      loadPreferences(r),
+	})
+	if err != nil {
+		log.Printf("[ERROR] Failed to render template: %v", err)
+	}
+}
+
+// HandleDashboardsAPI manages stored dashboards.
+//
+// GET    /api/dashboards       - list dashboards visible to the current user
+// POST   /api/dashboards       - create a dashboard
+// GET    /api/dashboards/{id}  - get one dashboard
+// PUT    /api/dashboards/{id}  - replace name and widgets
+// DELETE /api/dashboards/{id}  - delete a dashboard (the default cannot be deleted)
+func HandleDashboardsAPI(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	idStr := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/dashboards"), "/")
+
+	if idStr == "" {
+		switch r.Method {
+		case http.MethodGet:
+			dashboards, err := listDashboards(user)
+			if err != nil {
+				log.Printf("[ERROR] Failed to list dashboards: %v", err)
+				respondJSON(w, map[string]string{"error": "Failed to list dashboards"}, http.StatusInternalServerError)
+				return
+			}
+			respondJSON(w, map[string]interface{}{"dashboards": dashboards}, http.StatusOK)
+		case http.MethodPost:
+			createDashboard(w, r, user)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		respondJSON(w, map[string]string{"error": "Invalid dashboard ID"}, http.StatusBadRequest)
+		return
+	}
+
+	d, err := getDashboard(id, user)
+	if err == sql.ErrNoRows {
+		respondJSON(w, map[string]string{"error": "Dashboard not found"}, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("[ERROR] Failed to load dashboard %d: %v", id, err)
+		respondJSON(w, map[string]string{"error": "Failed to load dashboard"}, http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		respondJSON(w, d, http.StatusOK)
+
+	case http.MethodPut:
+		if !canEditDashboard(d, user) {
+			respondJSON(w, map[string]string{"error": "Not allowed to edit this dashboard"}, http.StatusForbidden)
+			return
+		}
+		var req DashboardRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondJSON(w, map[string]string{"error": "Invalid JSON"}, http.StatusBadRequest)
+			return
+		}
+		if req.Name == "" {
+			req.Name = d.Name
+		}
+		if err := validateWidgets(req.Widgets); err != nil {
+			respondJSON(w, map[string]string{"error": err.Error()}, http.StatusBadRequest)
+			return
+		}
+		widgets, _ := json.Marshal(req.Widgets)
+		_, err := db.Exec(`
+			UPDATE dashboards
+			SET name = ?, widgets = ?, updated_at = CURRENT_TIMESTAMP
+			WHERE id = ?
+		`, req.Name, string(widgets), id)
+		if err != nil {
+			log.Printf("[ERROR] Failed to update dashboard %d: %v", id, err)
+			respondJSON(w, map[string]string{"error": "Failed to update dashboard"}, http.StatusConflict)
+			return
+		}
+		d.Name = req.Name
+		d.Widgets = req.Widgets
+		respondJSON(w, d, http.StatusOK)
+
+	case http.MethodDelete:
+		if !canEditDashboard(d, user) || d.IsDefault {
+			respondJSON(w, map[string]string{"error": "Not allowed to delete this dashboard"}, http.StatusForbidden)
+			return
+		}
+		if _, err := db.Exec(`DELETE FROM dashboards WHERE id = ?`, id); err != nil {
+			log.Printf("[ERROR] Failed to delete dashboard %d: %v", id, err)
+			respondJSON(w, map[string]string{"error": "Failed to delete dashboard"}, http.StatusInternalServerError)
+			return
+		}
+		respondJSON(w, map[string]interface{}{"success": true}, http.StatusOK)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// createDashboard handles POST /api/dashboards.
+func createDashboard(w http.ResponseWriter, r *http.Request, user string) {
+	var req DashboardRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, map[string]string{"error": "Invalid JSON"}, http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		respondJSON(w, map[string]string{"error": "Missing name"}, http.StatusBadRequest)
+		return
+	}
+	if req.Widgets == nil {
+		req.Widgets = []Widget{}
+	}
+	if err := validateWidgets(req.Widgets); err != nil {
+		respondJSON(w, map[string]string{"error": err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	owner := user
+	if req.Shared {
+		owner = ""
+	}
+
+	widgets, _ := json.Marshal(req.Widgets)
+	result, err := db.Exec(`
+		INSERT INTO dashboards (owner, name, widgets)
+		VALUES (?, ?, ?)
+	`, owner, req.Name, string(widgets))
+	if err != nil {
+		log.Printf("[ERROR] Failed to create dashboard %q: %v", req.Name, err)
+		respondJSON(w, map[string]string{"error": "A dashboard with this name already exists"}, http.StatusConflict)
+		return
+	}
+
+	id, _ := result.LastInsertId()
+	d, err := getDashboard(id, user)
+	if err != nil {
+		log.Printf("[ERROR] Failed to reload dashboard %d: %v", id, err)
+		respondJSON(w, map[string]string{"error": "Failed to load dashboard"}, http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, d, http.StatusCreated)
+}
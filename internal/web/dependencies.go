@@ -0,0 +1,69 @@
+package web
+
+import (
+	"sort"
+
+	dbpkg "github.com/ocochard/cmonit/internal/db"
+)
+
+// DependencyNode is a service in the dependency tree of a host. Its
+// children are the services depending on it: Monit stops them before it
+// and starts them after it, so stopping a node stops its whole subtree.
+type DependencyNode struct {
+	Name     string
+	Status   int // Service status (0=ok), -1 if not reported
+	Children []*DependencyNode
+}
+
+// buildDependencyTree returns the dependency tree of a host, rooted at
+// the services that others depend on but that depend on nothing. A
+// service depending on several others appears under each of them.
+func buildDependencyTree(deps []dbpkg.ServiceDependency, services []Service) []*DependencyNode {
+	if len(deps) == 0 {
+		return nil
+	}
+
+	status := make(map[string]int, len(services))
+	for _, svc := range services {
+		status[svc.Name] = svc.Status
+	}
+	dependants := make(map[string][]string)
+	dependent := make(map[string]bool)
+	for _, d := range deps {
+		dependants[d.DependsOn] = append(dependants[d.DependsOn], d.Service)
+		dependent[d.Service] = true
+	}
+
+	// path guards against cycles, which Monit rejects but an agent could
+	// still report
+	path := make(map[string]bool)
+	var build func(name string) *DependencyNode
+	build = func(name string) *DependencyNode {
+		node := &DependencyNode{Name: name, Status: -1}
+		if st, ok := status[name]; ok {
+			node.Status = st
+		}
+		path[name] = true
+		for _, child := range dependants[name] {
+			if !path[child] {
+				node.Children = append(node.Children, build(child))
+			}
+		}
+		delete(path, name)
+		return node
+	}
+
+	var roots []string
+	for name := range dependants {
+		if !dependent[name] {
+			roots = append(roots, name)
+		}
+	}
+	sort.Strings(roots)
+
+	tree := make([]*DependencyNode, 0, len(roots))
+	for _, name := range roots {
+		tree = append(tree, build(name))
+	}
+	return tree
+}
@@ -0,0 +1,74 @@
+package web
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	dbpkg "github.com/ocochard/cmonit/internal/db"
+)
+
+// defaultParseDiagnostics is the number of parse diagnostics returned
+// without limit.
+const defaultParseDiagnostics = 100
+
+// HandleParseDiagnosticsAPI returns the latest parse warnings of the
+// status documents, newest first: of a host, or of all hosts and the
+// documents that could not be parsed without host_id. They are recorded
+// with [logging] parse_diagnostics. Entries include the agent addresses,
+// so it needs the admin scope when using an API token.
+//
+// GET /api/v1/parse-diagnostics?host_id=...&limit=100
+func HandleParseDiagnosticsAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondJSON(w, map[string]string{"error": "Method not allowed"}, http.StatusMethodNotAllowed)
+		return
+	}
+	if !isAdmin(r) {
+		respondJSON(w, map[string]string{"error": "Insufficient scope: admin required"}, http.StatusForbidden)
+		return
+	}
+
+	limit := defaultParseDiagnostics
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > 1000 {
+			respondJSON(w, map[string]string{"error": "Invalid limit (1-1000)"}, http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	diagnostics, err := dbpkg.ListParseDiagnostics(db, r.URL.Query().Get("host_id"), limit)
+	if err != nil {
+		log.Printf("[ERROR] %v", err)
+		respondJSON(w, map[string]string{"error": "Failed to get parse diagnostics"}, http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, diagnostics, http.StatusOK)
+}
+
+// HandleValidationFailuresAPI returns the number of status documents of
+// each host rejected by the collector in strict mode ([collector] strict),
+// with the problems of the last one, most recent failures first. It needs
+// the admin scope when using an API token.
+//
+// GET /api/v1/validation-failures
+func HandleValidationFailuresAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondJSON(w, map[string]string{"error": "Method not allowed"}, http.StatusMethodNotAllowed)
+		return
+	}
+	if !isAdmin(r) {
+		respondJSON(w, map[string]string{"error": "Insufficient scope: admin required"}, http.StatusForbidden)
+		return
+	}
+
+	failures, err := dbpkg.ListValidationFailures(db)
+	if err != nil {
+		log.Printf("[ERROR] %v", err)
+		respondJSON(w, map[string]string{"error": "Failed to get validation failures"}, http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, failures, http.StatusOK)
+}
@@ -0,0 +1,531 @@
+package web
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	dbpkg "github.com/ocochard/cmonit/internal/db"
+)
+
+// Events page defaults and limits.
+const (
+	defaultEventsPerPage = 50
+	maxEventsPerPage     = 500
+)
+
+// eventsDateLayout is the date format of the from/to filters (HTML date inputs).
+const eventsDateLayout = "2006-01-02"
+
+// EventsQuery holds the filter and pagination parameters of the global
+// events page and API, parsed from the request query string.
+type EventsQuery struct {
+	HostID   string // Host identifier ("host")
+	Group    string // Hostgroup name ("group")
+	Service  string // Service name ("service")
+	Type     string // Event type code, decimal or 0x hex ("type")
+	From     string // Start date YYYY-MM-DD or RFC 3339 timestamp, inclusive ("from")
+	To       string // End date YYYY-MM-DD (whole day) or RFC 3339 timestamp ("to")
+	Ack      string // "no" = unacknowledged only, "yes" = acknowledged only ("ack")
+	Severity string // info, warning or critical ("severity")
+	Search   string // Words of the message, service name or hostname ("q")
+	Page     int    // 1-based page number ("page")
+	PerPage  int    // Events per page ("per_page")
+
+	eventType int64     // Parsed Type
+	from, to  time.Time // Parsed From/To, zero if unset
+}
+
+// GlobalEventsData holds data for the global events page.
+type GlobalEventsData struct {
+	Events      []Event         // Events on the current page, newest first
+	Query       EventsQuery     // Filter and pagination parameters
+	Total       int             // Events matching the filters (all pages)
+	TotalPages  int             // Number of pages for Total at Query.PerPage
+	Hosts       []HostOption    // Hosts for the host filter
+	Groups      []string        // Hostgroups and service groups for the group filter
+	EventTypes  []EventTypeInfo // Event types present in the database
+	Severities  []string        // Severities for the severity filter
+	LastUpdate  time.Time
+	AppVersion  string
+	Prefs       Preferences
+	FilterError string // Invalid filter message, shown above the table
+}
+
+// HostOption is a host entry in a filter drop-down.
+type HostOption struct {
+	ID       string
+	Hostname string
+}
+
+// EventTypeInfo is an event type code with its name.
+type EventTypeInfo struct {
+	Code int
+	Name string
+}
+
+// EventsResponse is the JSON response for the events API.
+type EventsResponse struct {
+	Events  []Event `json:"events"`
+	Total   int     `json:"total"`
+	Page    int     `json:"page"`
+	PerPage int     `json:"per_page"`
+}
+
+// parseEventsQuery extracts EventsQuery from the request. Dates without a
+// time are interpreted in loc. Returns an error message for invalid type or
+// date filters.
+func parseEventsQuery(r *http.Request, loc *time.Location) (EventsQuery, string) {
+	v := r.URL.Query()
+
+	q := EventsQuery{
+		HostID:    v.Get("host"),
+		Group:     v.Get("group"),
+		Service:   strings.TrimSpace(v.Get("service")),
+		Type:      strings.TrimSpace(v.Get("type")),
+		From:      strings.TrimSpace(v.Get("from")),
+		To:        strings.TrimSpace(v.Get("to")),
+		Ack:       v.Get("ack"),
+		Severity:  v.Get("severity"),
+		Search:    strings.TrimSpace(v.Get("q")),
+		Page:      1,
+		PerPage:   defaultEventsPerPage,
+		eventType: -1,
+	}
+
+	if page, err := strconv.Atoi(v.Get("page")); err == nil && page > 0 {
+		q.Page = page
+	}
+	if perPage, err := strconv.Atoi(v.Get("per_page")); err == nil && perPage > 0 {
+		q.PerPage = perPage
+		if q.PerPage > maxEventsPerPage {
+			q.PerPage = maxEventsPerPage
+		}
+	}
+
+	if q.Type != "" {
+		t, err := strconv.ParseInt(q.Type, 0, 64)
+		if err != nil || t < 0 {
+			return q, "Invalid event type: " + q.Type
+		}
+		q.eventType = t
+		q.Type = strconv.FormatInt(t, 10) // Matches the filter drop-down values
+	}
+
+	if q.Ack != "" && q.Ack != "yes" && q.Ack != "no" {
+		return q, "Invalid ack filter (yes or no): " + q.Ack
+	}
+
+	if q.Severity != "" && !slices.Contains(dbpkg.Severities, q.Severity) {
+		return q, "Invalid severity (" + strings.Join(dbpkg.Severities, ", ") + "): " + q.Severity
+	}
+
+	var err error
+	if q.From != "" {
+		if q.from, err = parseEventsTime(q.From, loc, false); err != nil {
+			return q, "Invalid from date: " + q.From
+		}
+	}
+	if q.To != "" {
+		if q.to, err = parseEventsTime(q.To, loc, true); err != nil {
+			return q, "Invalid to date: " + q.To
+		}
+	}
+
+	return q, ""
+}
+
+// parseEventsTime parses a YYYY-MM-DD date or RFC 3339 timestamp. For an
+// end bound, a date means the end of that day.
+func parseEventsTime(s string, loc *time.Location, end bool) (time.Time, error) {
+	if t, err := time.ParseInLocation(eventsDateLayout, s, loc); err == nil {
+		if end {
+			t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+		}
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// values encodes the query back into URL parameters, omitting defaults.
+func (q EventsQuery) values() url.Values {
+	v := url.Values{}
+	if q.HostID != "" {
+		v.Set("host", q.HostID)
+	}
+	if q.Group != "" {
+		v.Set("group", q.Group)
+	}
+	if q.Service != "" {
+		v.Set("service", q.Service)
+	}
+	if q.Type != "" {
+		v.Set("type", q.Type)
+	}
+	if q.From != "" {
+		v.Set("from", q.From)
+	}
+	if q.To != "" {
+		v.Set("to", q.To)
+	}
+	if q.Ack != "" {
+		v.Set("ack", q.Ack)
+	}
+	if q.Severity != "" {
+		v.Set("severity", q.Severity)
+	}
+	if q.Search != "" {
+		v.Set("q", q.Search)
+	}
+	if q.Page > 1 {
+		v.Set("page", strconv.Itoa(q.Page))
+	}
+	if q.PerPage != defaultEventsPerPage {
+		v.Set("per_page", strconv.Itoa(q.PerPage))
+	}
+	return v
+}
+
+// ExportURL returns the URL exporting the events matching the filters.
+func (q EventsQuery) ExportURL(format string) string {
+	v := q.values()
+	v.Del("q")
+	v.Del("page")
+	v.Del("per_page")
+	v.Set("format", format)
+	return "/api/v1/events/export?" + v.Encode()
+}
+
+// exportFilter returns the filters of q for dbpkg.ExportEvents.
+func (q EventsQuery) exportFilter() dbpkg.EventExportFilter {
+	return dbpkg.EventExportFilter{
+		HostID:    q.HostID,
+		Group:     q.Group,
+		Service:   q.Service,
+		EventType: q.eventType,
+		Severity:  q.Severity,
+		Ack:       q.Ack,
+		From:      q.from,
+		To:        q.to,
+	}
+}
+
+// PageURL returns the events page URL for the given page number.
+func (q EventsQuery) PageURL(page int) string {
+	next := q
+	next.Page = page
+	return "/events?" + next.values().Encode()
+}
+
+// getEvents returns one page of events matching q, newest first, and the
+// total number of matching events.
+func getEvents(q EventsQuery) ([]Event, int, error) {
+	where := " WHERE 1=1"
+	var args []interface{}
+
+	if q.HostID != "" {
+		where += " AND e.host_id = ?"
+		args = append(args, q.HostID)
+	}
+	if q.Group != "" {
+		// Events of the hosts of a hostgroup, or of the services of a
+		// service group
+		where += `
+		  AND (e.host_id IN (
+			SELECT hhg.host_id
+			FROM host_hostgroups hhg
+			JOIN hostgroups hg ON hg.id = hhg.hostgroup_id
+			WHERE hg.name = ?
+		  ) OR EXISTS (
+			SELECT 1 FROM service_groups sg
+			WHERE sg.host_id = e.host_id AND sg.service_name = e.service_name AND sg.group_name = ?
+		  ))`
+		args = append(args, q.Group, q.Group)
+	}
+	if q.Service != "" {
+		where += " AND e.service_name = ?"
+		args = append(args, q.Service)
+	}
+	if q.eventType >= 0 {
+		where += " AND e.event_type = ?"
+		args = append(args, q.eventType)
+	}
+	if q.Severity != "" {
+		where += " AND e.severity = ?"
+		args = append(args, q.Severity)
+	}
+	if condition, searchArgs := eventSearchCondition(q.Search); condition != "" {
+		where += " AND " + condition
+		args = append(args, searchArgs...)
+	}
+	switch q.Ack {
+	case "yes":
+		where += " AND e.ack_at IS NOT NULL"
+	case "no":
+		where += " AND e.ack_at IS NULL"
+	}
+	// created_at is stored as text in the server's local time, so bounds
+	// are converted to local time before the comparison
+	if !q.from.IsZero() {
+		where += " AND e.created_at >= ?"
+		args = append(args, q.from.In(time.Local))
+	}
+	if !q.to.IsZero() {
+		where += " AND e.created_at <= ?"
+		args = append(args, q.to.In(time.Local))
+	}
+
+	var total int
+	err := db.QueryRow("SELECT COUNT(*) FROM events e JOIN hosts h ON h.id = e.host_id"+where, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := `
+		SELECT e.id, e.host_id, h.hostname, e.service_name, e.event_type,
+		       COALESCE(e.message, ''), e.created_at,
+		       COALESCE(e.ack_by, ''), e.ack_at, COALESCE(e.ack_note, ''), e.severity, e.flap_count, COALESCE(e.source, '')
+		FROM events e
+		JOIN hosts h ON h.id = e.host_id` + where + `
+		ORDER BY e.created_at DESC, e.id DESC
+		LIMIT ? OFFSET ?
+	`
+	args = append(args, q.PerPage, (q.Page-1)*q.PerPage)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	events := []Event{}
+	for rows.Next() {
+		var event Event
+		err := rows.Scan(
+			&event.ID,
+			&event.HostID,
+			&event.Hostname,
+			&event.ServiceName,
+			&event.EventType,
+			&event.Message,
+			&event.CreatedAt,
+			&event.AckBy,
+			&event.AckAt,
+			&event.AckNote,
+			&event.Severity,
+			&event.FlapCount,
+			&event.Source,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+		event.EventTypeName = getEventTypeName(event.EventType)
+		if event.Source != "" {
+			event.EventTypeName = "External"
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	if err := attachEventComments(events); err != nil {
+		return nil, 0, err
+	}
+	return events, total, nil
+}
+
+// maxSearchTerms bounds the words of an event search.
+const maxSearchTerms = 10
+
+// eventSearchCondition returns the SQL condition selecting the events
+// matching every word of search in their message, service name or
+// hostname, with its arguments, or "" without words. The query names the
+// events table e and joins the hosts table as h. Words match as prefixes
+// in the events_fts index, or anywhere with LIKE on a SQLite without FTS5;
+// hostnames, which change, always match with LIKE.
+func eventSearchCondition(search string) (string, []interface{}) {
+	terms := strings.Fields(search)
+	if len(terms) > maxSearchTerms {
+		terms = terms[:maxSearchTerms]
+	}
+
+	var conditions []string
+	var args []interface{}
+	for _, term := range terms {
+		pattern := "%" + escapeLike(term) + "%"
+		if dbpkg.EventSearchFTS() {
+			// A quoted FTS5 string: operators and punctuation in the
+			// word are not interpreted
+			conditions = append(conditions, `(e.id IN (SELECT rowid FROM events_fts WHERE events_fts MATCH ?)
+			  OR h.hostname LIKE ? ESCAPE '\')`)
+			args = append(args, `"`+strings.ReplaceAll(term, `"`, `""`)+`"*`, pattern)
+		} else {
+			conditions = append(conditions, `(e.message LIKE ? ESCAPE '\' OR e.service_name LIKE ? ESCAPE '\'
+			  OR h.hostname LIKE ? ESCAPE '\')`)
+			args = append(args, pattern, pattern, pattern)
+		}
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// getEventTypesInUse returns the distinct event types stored in the events table.
+func getEventTypesInUse() ([]EventTypeInfo, error) {
+	rows, err := db.Query("SELECT DISTINCT event_type FROM events WHERE event_type IS NOT NULL ORDER BY event_type")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var types []EventTypeInfo
+	for rows.Next() {
+		var code int
+		if err := rows.Scan(&code); err != nil {
+			return nil, err
+		}
+		types = append(types, EventTypeInfo{Code: code, Name: getEventTypeName(code)})
+	}
+	return types, rows.Err()
+}
+
+// getHostOptions returns all hosts ordered by hostname, for filter drop-downs.
+func getHostOptions() ([]HostOption, error) {
+	rows, err := db.Query("SELECT id, hostname FROM hosts ORDER BY hostname")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hosts []HostOption
+	for rows.Next() {
+		var h HostOption
+		if err := rows.Scan(&h.ID, &h.Hostname); err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, h)
+	}
+	return hosts, rows.Err()
+}
+
+// HandleEvents serves the global events page.
+//
+// GET /events?host=&group=&service=&type=&from=&to=&ack=&severity=&q=&page=&per_page=
+func HandleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	prefs := loadPreferences(r)
+	q, filterErr := parseEventsQuery(r, prefs.Location())
+
+	data := GlobalEventsData{
+		Events:      []Event{},
+		Query:       q,
+		LastUpdate:  time.Now(),
+		AppVersion:  appVersion,
+		Prefs:       prefs,
+		Severities:  dbpkg.Severities,
+		FilterError: filterErr,
+	}
+
+	if filterErr == "" {
+		events, total, err := getEvents(q)
+		if err != nil {
+			log.Printf("[ERROR] Failed to get events: %v", err)
+			http.Error(w, "Failed to load events data", http.StatusInternalServerError)
+			return
+		}
+		data.Events = events
+		data.Total = total
+		data.TotalPages = (total + q.PerPage - 1) / q.PerPage
+	}
+
+	var err error
+	if data.Hosts, err = getHostOptions(); err != nil {
+		log.Printf("[ERROR] Failed to get hosts for events filter: %v", err)
+	}
+	if data.Groups, err = getAllHostGroups(); err != nil {
+		log.Printf("[ERROR] Failed to get hostgroups for events filter: %v", err)
+	}
+	if data.EventTypes, err = getEventTypesInUse(); err != nil {
+		log.Printf("[ERROR] Failed to get event types for events filter: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	err = templates.ExecuteTemplate(w, "all_events.html", data)
+	if err != nil {
+		log.Printf("[ERROR] Failed to render template: %v", err)
+	}
+}
+
+// HandleEventsAPI returns events across all hosts as JSON, or records an
+// external event (POST, see handleEventCreate).
+//
+// GET /api/v1/events?host=&group=&service=&type=&from=&to=&ack=&severity=&q=&page=&per_page=
+//
+// Takes the same filters as the /events page. Events are ordered newest
+// first; total is the number of matching events across all pages.
+func HandleEventsAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		handleEventCreate(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q, filterErr := parseEventsQuery(r, loadPreferences(r).Location())
+	if filterErr != "" {
+		respondJSON(w, map[string]string{"error": filterErr}, http.StatusBadRequest)
+		return
+	}
+
+	events, total, err := getEvents(q)
+	if err != nil {
+		log.Printf("[ERROR] Failed to get events: %v", err)
+		respondJSON(w, map[string]string{"error": "Failed to get events"}, http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, EventsResponse{
+		Events:  events,
+		Total:   total,
+		Page:    q.Page,
+		PerPage: q.PerPage,
+	}, http.StatusOK)
+}
+
+// HandleEventDailyCountsAPI returns the daily counts of the events deleted
+// by the retention job ([retention] aggregate_events), oldest first.
+//
+// GET /api/v1/events/daily?host=&from=YYYY-MM-DD&to=YYYY-MM-DD
+func HandleEventDailyCountsAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	v := r.URL.Query()
+	from, to := strings.TrimSpace(v.Get("from")), strings.TrimSpace(v.Get("to"))
+	for _, day := range []string{from, to} {
+		if _, err := time.Parse(eventsDateLayout, day); day != "" && err != nil {
+			respondJSON(w, map[string]string{"error": "Invalid date (YYYY-MM-DD): " + day}, http.StatusBadRequest)
+			return
+		}
+	}
+
+	counts, err := dbpkg.GetEventDailyCounts(db, v.Get("host"), from, to)
+	if err != nil {
+		log.Printf("[ERROR] %v", err)
+		respondJSON(w, map[string]string{"error": "Failed to get event daily counts"}, http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, counts, http.StatusOK)
+}
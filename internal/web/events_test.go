@@ -0,0 +1,86 @@
+package web
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	dbpkg "github.com/ocochard/cmonit/internal/db"
+)
+
+// TestEventSearchCondition covers the LIKE fallback, used without the
+// events_fts index of InitDB.
+func TestEventSearchCondition(t *testing.T) {
+	if condition, args := eventSearchCondition("  "); condition != "" || args != nil {
+		t.Errorf("blank search = %q, %v, want no condition", condition, args)
+	}
+
+	condition, args := eventSearchCondition("nginx  50%_done")
+	if n := strings.Count(condition, " AND "); n != 1 {
+		t.Errorf("condition has %d AND, want 1 for 2 words: %s", n, condition)
+	}
+	want := []string{"%nginx%", "%nginx%", "%nginx%", `%50\%\_done%`, `%50\%\_done%`, `%50\%\_done%`}
+	if len(args) != len(want) {
+		t.Fatalf("args = %v, want %v", args, want)
+	}
+	for i := range want {
+		if args[i] != want[i] {
+			t.Errorf("args[%d] = %v, want %s", i, args[i], want[i])
+		}
+	}
+
+	_, args = eventSearchCondition(strings.Repeat("word ", maxSearchTerms+5))
+	if len(args) != 3*maxSearchTerms {
+		t.Errorf("%d args for %d words, want the first %d words only", len(args), maxSearchTerms+5, maxSearchTerms)
+	}
+}
+
+// TestEventCreateRequest checks the validation of the external events and
+// the scope recording them needs.
+func TestEventCreateRequest(t *testing.T) {
+	now := time.Now()
+	future := now.Add(time.Hour)
+	tests := []struct {
+		req  EventCreateRequest
+		want string // Error message prefix, "" when valid
+	}{
+		{EventCreateRequest{Host: "web1", Service: "deploy", Message: " v2.3.1 deployed "}, ""},
+		{EventCreateRequest{Host: "web1", Service: "deploy", Message: "backup failed", Severity: "Critical"}, ""},
+		{EventCreateRequest{Service: "deploy", Message: "v2.3.1"}, "Missing host"},
+		{EventCreateRequest{Host: "web1", Service: " ", Message: "v2.3.1"}, "Missing service"},
+		{EventCreateRequest{Host: "web1", Service: "deploy"}, "Missing message"},
+		{EventCreateRequest{Host: "web1", Service: "deploy", Message: strings.Repeat("x", maxExternalMessageLength+1)}, "Message too long"},
+		{EventCreateRequest{Host: "web1", Service: "deploy", Message: "v2.3.1", Severity: "fatal"}, "Invalid severity"},
+		{EventCreateRequest{Host: "web1", Service: "deploy", Message: "v2.3.1", CreatedAt: &future}, "created_at is in the future"},
+	}
+	for _, tt := range tests {
+		req := tt.req
+		if got := req.validate(now); !strings.HasPrefix(got, tt.want) || (tt.want == "") != (got == "") {
+			t.Errorf("validate(%+v) = %q, want %q", tt.req, got, tt.want)
+		}
+	}
+
+	req := EventCreateRequest{Host: "web1", Service: "deploy", Message: " v2.3.1 deployed "}
+	req.validate(now)
+	if req.Severity != dbpkg.SeverityInfo || req.Message != "v2.3.1 deployed" {
+		t.Errorf("normalized request %+v, want info severity and trimmed message", req)
+	}
+
+	for method, want := range map[string]string{"POST": dbpkg.ScopeWriteEvents, "GET": dbpkg.ScopeReadStatus} {
+		if got := requiredScope(httptest.NewRequest(method, "/api/v1/events", nil)); got != want {
+			t.Errorf("%s /api/v1/events needs %s, want %s", method, got, want)
+		}
+	}
+}
+
+// TestEventStatsAPIErrors checks the parameters rejected before any query.
+func TestEventStatsAPIErrors(t *testing.T) {
+	for _, query := range []string{"limit=0", "limit=ten", "severity=fatal", "from=yesterday"} {
+		w := httptest.NewRecorder()
+		HandleEventStatsAPI(w, httptest.NewRequest("GET", "/api/v1/events/stats?"+query, nil))
+		if w.Code != 400 {
+			t.Errorf("%s: status %d, want 400", query, w.Code)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package web
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defaultEventStatsRange is the range of the event statistics without a
+// from date.
+const defaultEventStatsRange = 30 * 24 * time.Hour
+
+// EventStatsResponse is the JSON response of the event statistics API.
+type EventStatsResponse struct {
+	From  time.Time        `json:"from"`
+	To    time.Time        `json:"to"`
+	Total int              `json:"total"`
+	Days  []EventDayCount  `json:"days"`  // Oldest first, days without events left out
+	Hosts []EventHostCount `json:"hosts"` // Noisiest first, at most limit
+	Types []EventTypeCount `json:"types"` // Most common first, at most limit
+}
+
+// EventDayCount is the number of events of a day, by severity.
+type EventDayCount struct {
+	Day      string `json:"day"` // YYYY-MM-DD, server's local time
+	Count    int    `json:"count"`
+	Info     int    `json:"info"`
+	Warning  int    `json:"warning"`
+	Critical int    `json:"critical"`
+}
+
+// EventHostCount is the number of events of a host.
+type EventHostCount struct {
+	HostID   string `json:"host_id"`
+	Hostname string `json:"hostname"`
+	Count    int    `json:"count"`
+	Critical int    `json:"critical"`
+}
+
+// EventTypeCount is the number of events of an event type.
+type EventTypeCount struct {
+	EventType     int    `json:"event_type"`
+	EventTypeName string `json:"event_type_name"`
+	Count         int    `json:"count"`
+}
+
+// HandleEventStatsAPI returns event counts per day, per host and per event
+// type, for dashboards of the noisiest hosts and most common failures.
+//
+// GET /api/v1/events/stats?from=&to=&host=&group=&service=&type=&ack=&severity=&q=&limit=10
+//
+// Takes the filters of /api/v1/events; without from, the range is the
+// last 30 days. limit (default 10, max 100) bounds the hosts and types.
+func HandleEventStatsAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q, filterErr := parseEventsQuery(r, loadPreferences(r).Location())
+	if filterErr != "" {
+		respondJSON(w, map[string]string{"error": filterErr}, http.StatusBadRequest)
+		return
+	}
+	limit := defaultTopN
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			respondJSON(w, map[string]string{"error": "Invalid limit: " + v}, http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxTopN)
+	}
+	now := time.Now()
+	if q.to.IsZero() {
+		q.to = now
+	}
+	if q.from.IsZero() {
+		q.from = q.to.Add(-defaultEventStatsRange)
+	}
+
+	stats, err := getEventStats(q, limit)
+	if err != nil {
+		log.Printf("[ERROR] Failed to get event statistics: %v", err)
+		respondJSON(w, map[string]string{"error": "Failed to get event statistics"}, http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, stats, http.StatusOK)
+}
+
+// getEventStats counts the events matching q per day, host and type,
+// keeping the limit noisiest hosts and most common types.
+func getEventStats(q EventsQuery, limit int) (EventStatsResponse, error) {
+	stats := EventStatsResponse{From: q.from, To: q.to, Days: []EventDayCount{}, Hosts: []EventHostCount{}, Types: []EventTypeCount{}}
+	where, args := q.exportFilter().Conditions()
+	if condition, searchArgs := eventSearchCondition(q.Search); condition != "" {
+		where += " AND " + condition
+		args = append(args, searchArgs...)
+	}
+	from := " FROM events e JOIN hosts h ON h.id = e.host_id WHERE 1=1" + where
+
+	// created_at is stored as text in the server's local time: its first
+	// 10 characters are the local day, as in event_daily_counts
+	rows, err := db.Query(`SELECT substr(e.created_at, 1, 10) AS day, COUNT(*),
+		SUM(e.severity = 'info'), SUM(e.severity = 'warning'), SUM(e.severity = 'critical')`+
+		from+` GROUP BY day ORDER BY day`, args...)
+	if err != nil {
+		return stats, err
+	}
+	for rows.Next() {
+		var d EventDayCount
+		if err := rows.Scan(&d.Day, &d.Count, &d.Info, &d.Warning, &d.Critical); err != nil {
+			rows.Close()
+			return stats, err
+		}
+		stats.Days = append(stats.Days, d)
+		stats.Total += d.Count
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return stats, err
+	}
+
+	rows, err = db.Query(`SELECT e.host_id, h.hostname, COUNT(*) AS n, SUM(e.severity = 'critical')`+
+		from+` GROUP BY e.host_id ORDER BY n DESC, h.hostname LIMIT ?`, append(args, limit)...)
+	if err != nil {
+		return stats, err
+	}
+	for rows.Next() {
+		var h EventHostCount
+		if err := rows.Scan(&h.HostID, &h.Hostname, &h.Count, &h.Critical); err != nil {
+			rows.Close()
+			return stats, err
+		}
+		stats.Hosts = append(stats.Hosts, h)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return stats, err
+	}
+
+	rows, err = db.Query(`SELECT COALESCE(e.event_type, 0) AS type, COUNT(*) AS n`+
+		from+` GROUP BY type ORDER BY n DESC, type LIMIT ?`, append(args, limit)...)
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t EventTypeCount
+		if err := rows.Scan(&t.EventType, &t.Count); err != nil {
+			return stats, err
+		}
+		t.EventTypeName = getEventTypeName(t.EventType)
+		stats.Types = append(stats.Types, t)
+	}
+	return stats, rows.Err()
+}
@@ -0,0 +1,298 @@
+package web
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	dbpkg "github.com/ocochard/cmonit/internal/db"
+)
+
+// exportTimeLayout is the timestamp format written to CSV files. Spreadsheet
+// applications recognise it as a date-time without extra configuration.
+const exportTimeLayout = "2006-01-02 15:04:05"
+
+// unsafeFilenameChars matches characters replaced in download file names.
+var unsafeFilenameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// HandleMetricsExport streams service metrics or event history as CSV.
+//
+// GET /api/metrics/export?host_id=<id>&service=<name>&range=24h&format=csv
+//
+// Query parameters:
+//   - host_id, service (required): Service to export
+//   - range (optional): Time range, default: preferred range
+//   - format (optional): Output format, only "csv" is supported (default)
+//   - data (optional): "metrics" (default) or "events"
+//   - metric (optional, repeatable): Limit to a metric type ("cpu") or a
+//     single metric ("cpu.user")
+//   - agg, bucket (optional): Aggregate per bucket, as for /api/metrics
+//
+// Metrics are written one row per timestamp with one column per metric;
+// events one row per event. Timestamps use the viewer's preferred timezone.
+func HandleMetricsExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	hostID := query.Get("host_id")
+	service := query.Get("service")
+	if hostID == "" {
+		http.Error(w, "Missing host_id parameter", http.StatusBadRequest)
+		return
+	}
+	if service == "" {
+		http.Error(w, "Missing service parameter", http.StatusBadRequest)
+		return
+	}
+
+	if format := query.Get("format"); format != "" && format != "csv" {
+		http.Error(w, "Unsupported format (expected csv)", http.StatusBadRequest)
+		return
+	}
+
+	prefs := loadPreferences(r)
+	rangeStr := query.Get("range")
+	if rangeStr == "" {
+		rangeStr = prefs.DefaultRange
+	}
+	duration, err := parseTimeRange(rangeStr)
+	if err != nil {
+		http.Error(w, "Invalid range parameter", http.StatusBadRequest)
+		return
+	}
+	endTime := time.Now()
+	startTime := endTime.Add(-duration)
+
+	data := query.Get("data")
+	if data == "" {
+		data = "metrics"
+	}
+
+	var records [][]string
+	switch data {
+	case "metrics":
+		agg, bucket, err := parseAggregation(query.Get("agg"), query.Get("bucket"), duration)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		records, err = metricsCSVRecords(hostID, service, startTime, endTime, agg, bucket, metricFilters(query["metric"]), prefs)
+		if err != nil {
+			log.Printf("[ERROR] Failed to export metrics: %v", err)
+			http.Error(w, "Failed to get metrics", http.StatusInternalServerError)
+			return
+		}
+	case "events":
+		records, err = eventsCSVRecords(hostID, service, startTime, endTime, prefs)
+		if err != nil {
+			log.Printf("[ERROR] Failed to export events: %v", err)
+			http.Error(w, "Failed to get events", http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, "Invalid data parameter (expected metrics or events)", http.StatusBadRequest)
+		return
+	}
+
+	filename := fmt.Sprintf("%s_%s_%s_%s.csv", hostID, service, data, endTime.Format("20060102-1504"))
+	filename = unsafeFilenameChars.ReplaceAllString(filename, "_")
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+
+	cw := csv.NewWriter(w)
+	if err := cw.WriteAll(records); err != nil {
+		log.Printf("[ERROR] Failed to write CSV: %v", err)
+	}
+}
+
+// metricsCSVRecords builds the CSV rows for a service's metrics: a header of
+// "timestamp" plus one "type.name" column per metric, then one row per
+// timestamp. Cells are empty where a metric has no sample at that time.
+func metricsCSVRecords(hostID, service string, startTime, endTime time.Time, agg string, bucket time.Duration, filters []string, prefs Preferences) ([][]string, error) {
+	var series []MetricSeries
+	var err error
+	if bucket > 0 {
+		series, err = getAggregatedMetricsForService(hostID, service, startTime, endTime, agg, bucket)
+	} else {
+		series, err = getMetricsForService(hostID, service, startTime, endTime)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Remote host services keep response times in their own table
+	remote, err := getRemoteHostMetricsForGraph(hostID, service, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+	series = append(series, remote...)
+
+	// Column order: sorted by type, then name
+	sort.Slice(series, func(i, j int) bool {
+		if series[i].Type != series[j].Type {
+			return series[i].Type < series[j].Type
+		}
+		return series[i].Name < series[j].Name
+	})
+
+	header := []string{"timestamp"}
+	rows := make(map[time.Time][]string)
+	for _, s := range series {
+		column := s.Type + "." + s.Name
+		if !metricSelected(s.Type, column, filters) {
+			continue
+		}
+		col := len(header)
+		header = append(header, column)
+
+		for i, ts := range s.Timestamps {
+			t, err := time.Parse(time.RFC3339, ts)
+			if err != nil {
+				continue
+			}
+			row := rows[t]
+			for len(row) <= col {
+				row = append(row, "")
+			}
+			row[col] = strconv.FormatFloat(s.Values[i], 'f', -1, 64)
+			rows[t] = row
+		}
+	}
+
+	times := make([]time.Time, 0, len(rows))
+	for t := range rows {
+		times = append(times, t)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+
+	records := [][]string{header}
+	for _, t := range times {
+		row := rows[t]
+		for len(row) < len(header) {
+			row = append(row, "")
+		}
+		row[0] = prefs.Format(t, exportTimeLayout)
+		records = append(records, row)
+	}
+	return records, nil
+}
+
+// metricFilters flattens repeated and comma-separated metric= values,
+// dropping empty entries (an empty form field means "all metrics").
+func metricFilters(values []string) []string {
+	var filters []string
+	for _, v := range values {
+		for _, part := range strings.Split(v, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				filters = append(filters, part)
+			}
+		}
+	}
+	return filters
+}
+
+// metricSelected reports whether a metric passes the metric filters.
+// No filters selects everything.
+func metricSelected(metricType, column string, filters []string) bool {
+	if len(filters) == 0 {
+		return true
+	}
+	for _, f := range filters {
+		if f == metricType || f == column {
+			return true
+		}
+	}
+	return false
+}
+
+// eventsCSVRecords builds the CSV rows for a service's event history,
+// oldest first.
+func eventsCSVRecords(hostID, service string, startTime, endTime time.Time, prefs Preferences) ([][]string, error) {
+	const query = `
+		SELECT created_at, event_type, severity, COALESCE(message, '')
+		FROM events
+		WHERE host_id = ? AND service_name = ?
+		  AND created_at BETWEEN ? AND ?
+		ORDER BY created_at
+	`
+
+	rows, err := db.Query(query, hostID, service, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := [][]string{{"timestamp", "event_type", "severity", "message"}}
+	for rows.Next() {
+		var createdAt time.Time
+		var eventType int
+		var severity, message string
+		if err := rows.Scan(&createdAt, &eventType, &severity, &message); err != nil {
+			return nil, err
+		}
+		records = append(records, []string{
+			prefs.Format(createdAt, exportTimeLayout),
+			getEventTypeName(eventType),
+			severity,
+			message,
+		})
+	}
+	return records, rows.Err()
+}
+
+// HandleEventsExport streams all the events matching the filters, oldest
+// first, for audits and offline analysis.
+//
+// GET /api/v1/events/export?format=csv|ndjson&host=&group=&service=&type=&from=&to=&ack=&severity=
+//
+// Takes the filters of /api/v1/events but the search (q), without
+// pagination. CSV (default) has a header row; ND-JSON has one event per
+// line, as in /api/v1/events. Times are RFC 3339.
+func HandleEventsExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = dbpkg.EventExportCSV
+	}
+	contentType := map[string]string{
+		dbpkg.EventExportCSV:    "text/csv; charset=utf-8",
+		dbpkg.EventExportNDJSON: "application/x-ndjson",
+	}[format]
+	if contentType == "" {
+		http.Error(w, "Unsupported format (expected csv or ndjson)", http.StatusBadRequest)
+		return
+	}
+
+	q, filterErr := parseEventsQuery(r, loadPreferences(r).Location())
+	if filterErr == "" && q.Search != "" {
+		filterErr = "The export does not support the q search filter"
+	}
+	if filterErr != "" {
+		http.Error(w, filterErr, http.StatusBadRequest)
+		return
+	}
+
+	filename := "events_" + time.Now().Format("20060102-1504") + "." + format
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+
+	n, err := dbpkg.ExportEvents(db, q.exportFilter(), format, w)
+	if err != nil {
+		// The response has started: the download ends truncated
+		log.Printf("[ERROR] Event export stopped after %d events: %v", n, err)
+	}
+}
@@ -0,0 +1,158 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	dbpkg "github.com/ocochard/cmonit/internal/db"
+)
+
+// External event limits.
+const (
+	maxExternalMessageLength = 4096
+	maxExternalSourceLength  = 64
+	maxExternalFuture        = 5 * time.Minute // Clock skew allowed for created_at
+)
+
+// EventCreateRequest is the JSON request recording an external event, such
+// as a deploy or a backup, against a host and service.
+type EventCreateRequest struct {
+	Host      string     `json:"host"`                 // Host id, or its hostname when unique
+	Service   string     `json:"service"`              // Service, which need not be monitored by Monit ("deploy")
+	Message   string     `json:"message"`              // Max 4096 characters
+	Severity  string     `json:"severity,omitempty"`   // info (default), warning or critical
+	Source    string     `json:"source,omitempty"`     // Recording system; defaults to the API token or web user
+	CreatedAt *time.Time `json:"created_at,omitempty"` // RFC 3339; defaults to now
+}
+
+// EventCreateResponse is the JSON response to a recorded external event.
+type EventCreateResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	ID      int64  `json:"id,omitempty"` // Event id
+}
+
+// validate checks and normalizes the request, returning an error message.
+func (req *EventCreateRequest) validate(now time.Time) string {
+	req.Host = strings.TrimSpace(req.Host)
+	req.Service = strings.TrimSpace(req.Service)
+	req.Message = strings.TrimSpace(req.Message)
+	req.Source = strings.TrimSpace(req.Source)
+	req.Severity = strings.ToLower(strings.TrimSpace(req.Severity))
+	switch {
+	case req.Host == "":
+		return "Missing host"
+	case req.Service == "":
+		return "Missing service"
+	case req.Message == "":
+		return "Missing message"
+	case utf8.RuneCountInString(req.Message) > maxExternalMessageLength:
+		return fmt.Sprintf("Message too long (max %d characters)", maxExternalMessageLength)
+	case utf8.RuneCountInString(req.Source) > maxExternalSourceLength:
+		return fmt.Sprintf("Source too long (max %d characters)", maxExternalSourceLength)
+	case req.CreatedAt != nil && req.CreatedAt.After(now.Add(maxExternalFuture)):
+		return "created_at is in the future"
+	}
+	if req.Severity == "" {
+		req.Severity = dbpkg.SeverityInfo
+	} else if !slices.Contains(dbpkg.Severities, req.Severity) {
+		return "Invalid severity (info, warning or critical): " + req.Severity
+	}
+	return ""
+}
+
+// resolveEventHost returns the id of a host given by id or hostname, or an
+// error message and status when none or several hosts match.
+func resolveEventHost(host string) (string, string, int) {
+	rows, err := db.Query("SELECT id FROM hosts WHERE id = ? OR hostname = ? ORDER BY id = ? DESC", host, host, host)
+	if err != nil {
+		log.Printf("[ERROR] Failed to look up host %s: %v", host, err)
+		return "", "Failed to look up host", http.StatusInternalServerError
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			log.Printf("[ERROR] Failed to look up host %s: %v", host, err)
+			return "", "Failed to look up host", http.StatusInternalServerError
+		}
+		if id == host {
+			return id, "", 0
+		}
+		ids = append(ids, id)
+	}
+	switch len(ids) {
+	case 0:
+		return "", "Host not found: " + host, http.StatusNotFound
+	case 1:
+		return ids[0], "", 0
+	}
+	return "", "Several hosts are named " + host + ", use the host id", http.StatusConflict
+}
+
+// handleEventCreate records an external event.
+//
+// POST /api/v1/events
+//
+// Request body: {"host": "web1", "service": "deploy", "message": "v2.3.1 deployed",
+// "severity": "info", "source": "jenkins"}
+// Response (201): {"success": true, "message": "...", "id": 42}
+//
+// The event appears with the events of the host, typed "External", and is
+// forwarded to the event sinks like the others. Tokens need the
+// write:events scope.
+func handleEventCreate(w http.ResponseWriter, r *http.Request) {
+	var req EventCreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, EventCreateResponse{Success: false, Message: "Invalid JSON"}, http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	if msg := req.validate(now); msg != "" {
+		respondJSON(w, EventCreateResponse{Success: false, Message: msg}, http.StatusBadRequest)
+		return
+	}
+
+	hostID, msg, status := resolveEventHost(req.Host)
+	if msg != "" {
+		respondJSON(w, EventCreateResponse{Success: false, Message: msg}, status)
+		return
+	}
+
+	source := req.Source
+	if source == "" {
+		source = strings.TrimPrefix(currentUser(r), "token:")
+	}
+	if source == "" {
+		source = "anonymous"
+	}
+	createdAt := now
+	if req.CreatedAt != nil {
+		createdAt = *req.CreatedAt
+	}
+
+	id, err := dbpkg.StoreExternalEvent(db, dbpkg.ExternalEvent{
+		HostID:    hostID,
+		Service:   req.Service,
+		Message:   req.Message,
+		Severity:  req.Severity,
+		Source:    source,
+		CreatedAt: createdAt,
+	})
+	if err != nil {
+		log.Printf("[ERROR] %v", err)
+		respondJSON(w, EventCreateResponse{Success: false, Message: "Failed to record event"}, http.StatusInternalServerError)
+		return
+	}
+	log.Printf("[INFO] External event %d recorded for %s/%s by %s", id, hostID, req.Service, source)
+
+	respondJSON(w, EventCreateResponse{Success: true, Message: "Event recorded", ID: id}, http.StatusCreated)
+}
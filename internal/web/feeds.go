@@ -0,0 +1,248 @@
+package web
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	dbpkg "github.com/ocochard/cmonit/internal/db"
+)
+
+// Feed formats of the events feed.
+const (
+	feedAtom = "atom"
+	feedRSS  = "rss"
+)
+
+// atomFeed is an Atom 1.0 feed (RFC 4287).
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Link    []atomLink  `xml:"link"`
+	Author  string      `xml:"author>name"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomEntry struct {
+	ID       string   `xml:"id"`
+	Title    string   `xml:"title"`
+	Updated  string   `xml:"updated"`
+	Link     atomLink `xml:"link"`
+	Category struct {
+		Term string `xml:"term,attr"`
+	} `xml:"category"`
+	Summary string `xml:"summary"`
+}
+
+// rssFeed is an RSS 2.0 feed.
+type rssFeed struct {
+	XMLName     xml.Name  `xml:"rss"`
+	Version     string    `xml:"version,attr"`
+	Title       string    `xml:"channel>title"`
+	Link        string    `xml:"channel>link"`
+	Description string    `xml:"channel>description"`
+	Items       []rssItem `xml:"channel>item"`
+}
+
+type rssItem struct {
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	GUID        string `xml:"guid"`
+	PubDate     string `xml:"pubDate"`
+	Category    string `xml:"category"`
+	Description string `xml:"description"`
+}
+
+// requestBaseURL returns the scheme and host the client reached, for the
+// absolute links of the feeds.
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// eventFeedTitle is the title of the feed entry of an event.
+func eventFeedTitle(e Event) string {
+	return fmt.Sprintf("[%s] %s %s: %s", e.Severity, e.Hostname, e.ServiceName, e.EventTypeName)
+}
+
+// HandleEventsFeed serves the recent events as an Atom or RSS feed, for
+// feed readers.
+//
+// GET /api/v1/events/feed?format=atom|rss&host=&group=&service=&type=&ack=&severity=&q=&per_page=
+//
+// Takes the filters of /api/v1/events; the feed has the newest per_page
+// events (default 50, max 500). Readers authenticate like any API client,
+// with HTTP Basic Auth or an API token.
+func HandleEventsFeed(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = feedAtom
+	}
+	if format != feedAtom && format != feedRSS {
+		http.Error(w, "Unsupported format (expected atom or rss)", http.StatusBadRequest)
+		return
+	}
+	q, filterErr := parseEventsQuery(r, loadPreferences(r).Location())
+	if filterErr != "" {
+		http.Error(w, filterErr, http.StatusBadRequest)
+		return
+	}
+	q.Page = 1
+
+	events, _, err := getEvents(q)
+	if err != nil {
+		log.Printf("[ERROR] Failed to get events for the feed: %v", err)
+		http.Error(w, "Failed to get events", http.StatusInternalServerError)
+		return
+	}
+
+	base := requestBaseURL(r)
+	pageURL := base + q.PageURL(1)
+	var feed interface{}
+	if format == feedAtom {
+		atom := atomFeed{
+			ID:     pageURL,
+			Title:  "cmonit events",
+			Link:   []atomLink{{Href: pageURL}, {Href: base + r.URL.RequestURI(), Rel: "self"}},
+			Author: "cmonit",
+		}
+		atom.Updated = time.Now().UTC().Format(time.RFC3339)
+		if len(events) > 0 {
+			atom.Updated = events[0].CreatedAt.UTC().Format(time.RFC3339)
+		}
+		for _, e := range events {
+			entry := atomEntry{
+				ID:      fmt.Sprintf("%s/api/v1/events/detail?id=%d", base, e.ID),
+				Title:   eventFeedTitle(e),
+				Updated: e.CreatedAt.UTC().Format(time.RFC3339),
+				Link:    atomLink{Href: base + "/host/" + url.PathEscape(e.HostID)},
+				Summary: e.Message,
+			}
+			entry.Category.Term = e.Severity
+			atom.Entries = append(atom.Entries, entry)
+		}
+		feed = atom
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	} else {
+		rss := rssFeed{Version: "2.0", Title: "cmonit events", Link: pageURL, Description: "Events of the hosts monitored by cmonit"}
+		for _, e := range events {
+			rss.Items = append(rss.Items, rssItem{
+				Title:       eventFeedTitle(e),
+				Link:        base + "/host/" + url.PathEscape(e.HostID),
+				GUID:        fmt.Sprintf("%s/api/v1/events/detail?id=%d", base, e.ID),
+				PubDate:     e.CreatedAt.Format(time.RFC1123Z),
+				Category:    e.Severity,
+				Description: e.Message,
+			})
+		}
+		feed = rss
+		w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+	}
+
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(feed); err != nil {
+		log.Printf("[ERROR] Failed to write events feed: %v", err)
+	}
+}
+
+// icalEscaper escapes iCalendar TEXT values (RFC 5545, 3.3.11).
+var icalEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`)
+
+// icalRepeatRules are the recurrence rules of the repeat intervals.
+var icalRepeatRules = map[string]string{
+	dbpkg.RepeatDaily:  "FREQ=DAILY",
+	dbpkg.RepeatWeekly: "FREQ=WEEKLY",
+}
+
+// icalLine writes a content line, folded at 75 octets (RFC 5545, 3.1):
+// the continuation lines start with a space.
+func icalLine(b *strings.Builder, line string) {
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && line[cut]&0xC0 == 0x80 { // Not within a UTF-8 sequence
+			cut--
+		}
+		b.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+		limit = 74
+	}
+	b.WriteString(line + "\r\n")
+}
+
+// scheduleCalendar returns the iCalendar of the scheduled actions, one
+// event each, repeated as the action; host is the domain of the UIDs.
+func scheduleCalendar(actions []dbpkg.ScheduledAction, host string, now time.Time) string {
+	const stamp = "20060102T150405Z"
+	var b strings.Builder
+	icalLine(&b, "BEGIN:VCALENDAR")
+	icalLine(&b, "VERSION:2.0")
+	icalLine(&b, "PRODID:-//cmonit//Scheduled actions//EN")
+	icalLine(&b, "X-WR-CALNAME:cmonit scheduled actions")
+	for _, s := range actions {
+		hostname := s.Hostname
+		if hostname == "" {
+			hostname = s.HostID
+		}
+		icalLine(&b, "BEGIN:VEVENT")
+		icalLine(&b, fmt.Sprintf("UID:scheduled-action-%d@%s", s.ID, host))
+		icalLine(&b, "DTSTAMP:"+now.UTC().Format(stamp))
+		icalLine(&b, "DTSTART:"+s.RunAt.UTC().Format(stamp))
+		icalLine(&b, "DURATION:PT15M")
+		if rule, ok := icalRepeatRules[s.Repeat]; ok {
+			icalLine(&b, "RRULE:"+rule)
+		}
+		icalLine(&b, "SUMMARY:"+icalEscaper.Replace(fmt.Sprintf("%s %s on %s", s.Action, s.Service, hostname)))
+		icalLine(&b, "DESCRIPTION:"+icalEscaper.Replace(fmt.Sprintf("Scheduled by %s (cmonit scheduled action %d)", s.CreatedBy, s.ID)))
+		icalLine(&b, "CATEGORIES:"+icalEscaper.Replace(s.Action))
+		icalLine(&b, "END:VEVENT")
+	}
+	icalLine(&b, "END:VCALENDAR")
+	return b.String()
+}
+
+// HandleScheduleCalendar serves the pending scheduled actions, such as the
+// unmonitor and monitor actions of a maintenance, as an iCalendar feed,
+// for calendar applications.
+//
+// GET /api/v1/schedule/calendar?host_id=
+//
+// Each action is a 15-minute event at its next run, repeated daily or
+// weekly as the action. Calendars authenticate like any API client.
+func HandleScheduleCalendar(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	actions, err := dbpkg.ListScheduledActions(db, r.URL.Query().Get("host_id"), false)
+	if err != nil {
+		log.Printf("[ERROR] %v", err)
+		http.Error(w, "Failed to get scheduled actions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Write([]byte(scheduleCalendar(actions, r.Host, time.Now())))
+}
@@ -0,0 +1,41 @@
+package web
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	dbpkg "github.com/ocochard/cmonit/internal/db"
+)
+
+// TestScheduleCalendar checks the iCalendar of the scheduled actions:
+// escaping, recurrence and line folding.
+func TestScheduleCalendar(t *testing.T) {
+	runAt := time.Date(2026, 10, 25, 2, 0, 0, 0, time.UTC)
+	actions := []dbpkg.ScheduledAction{
+		{ID: 7, HostID: "web1-0", Hostname: "web1", Service: "postgresql", Action: "restart", RunAt: runAt, Repeat: dbpkg.RepeatWeekly, CreatedBy: "admin"},
+		{ID: 8, HostID: "web2-0", Service: "nginx, proxy", Action: "unmonitor", RunAt: runAt, CreatedBy: strings.Repeat("a", 200)},
+	}
+	cal := scheduleCalendar(actions, "cmonit.example.com", runAt)
+
+	for _, want := range []string{
+		"BEGIN:VCALENDAR\r\n",
+		"UID:scheduled-action-7@cmonit.example.com\r\n",
+		"DTSTART:20261025T020000Z\r\n",
+		"RRULE:FREQ=WEEKLY\r\n",
+		`SUMMARY:unmonitor nginx\, proxy on web2-0` + "\r\n",
+		"END:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(cal, want) {
+			t.Errorf("calendar lacks %q:\n%s", want, cal)
+		}
+	}
+	if n := strings.Count(cal, "RRULE"); n != 1 {
+		t.Errorf("%d RRULE, want 1 for the weekly action only", n)
+	}
+	for _, line := range strings.Split(cal, "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("line of %d octets not folded: %s", len(line), line)
+		}
+	}
+}
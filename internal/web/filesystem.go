@@ -0,0 +1,192 @@
+package web
+
+import (
+	"log"
+	"net/http"
+	"time"
+)
+
+// FilesystemTrend is a linear fit of space usage over the requested range.
+type FilesystemTrend struct {
+	PercentPerDay float64    `json:"percent_per_day"`   // Growth of space used, in percentage points per day
+	FullAt        *time.Time `json:"full_at,omitempty"` // Projected time usage reaches 100% (nil if not growing)
+	Fitted        []float64  `json:"fitted"`            // Fitted line, aligned with Timestamps
+}
+
+// FilesystemUsageResponse is the JSON response for the filesystem usage API.
+type FilesystemUsageResponse struct {
+	HostID       string           `json:"host_id"`
+	Service      string           `json:"service"`
+	StartTime    time.Time        `json:"start_time"`
+	EndTime      time.Time        `json:"end_time"`
+	Bucket       string           `json:"bucket"`        // Bucket width (e.g., "5m0s")
+	Timestamps   []string         `json:"timestamps"`    // Bucket starts (RFC 3339)
+	BlockPercent []float64        `json:"block_percent"` // Average space used %
+	InodePercent []float64        `json:"inode_percent"` // Average inodes used %
+	ReadBytes    []float64        `json:"read_bytes"`    // Average bytes read per second
+	WriteBytes   []float64        `json:"write_bytes"`   // Average bytes written per second
+	ReadOps      []float64        `json:"read_ops"`      // Average read operations per second
+	WriteOps     []float64        `json:"write_ops"`     // Average write operations per second
+	Trend        *FilesystemTrend `json:"trend"`         // nil with fewer than two buckets
+}
+
+// getFilesystemUsage returns the bucketed space and inode usage and I/O
+// rate history of a filesystem service. See getAggregatedMetricsForService for the
+// bucketing of the stored time text.
+func getFilesystemUsage(hostID, serviceName string, startTime, endTime time.Time, bucket time.Duration) (*FilesystemUsageResponse, []time.Time, error) {
+	const query = `
+		SELECT CAST(strftime('%s', substr(collected_at, 1, 19)) AS INTEGER) / ? AS bucket,
+		       AVG(block_percent), AVG(inode_percent),
+		       COALESCE(AVG(read_bytes_rate), 0), COALESCE(AVG(write_bytes_rate), 0),
+		       COALESCE(AVG(read_ops_rate), 0), COALESCE(AVG(write_ops_rate), 0),
+		       MIN(collected_at)
+		FROM filesystem_metrics
+		WHERE host_id = ? AND service_name = ?
+		  AND collected_at BETWEEN ? AND ?
+		GROUP BY bucket
+		ORDER BY bucket
+	`
+
+	bucketSecs := int64(bucket / time.Second)
+	rows, err := db.Query(query, bucketSecs, hostID, serviceName, startTime, endTime)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	resp := &FilesystemUsageResponse{
+		HostID:       hostID,
+		Service:      serviceName,
+		StartTime:    startTime,
+		EndTime:      endTime,
+		Bucket:       bucket.String(),
+		Timestamps:   []string{},
+		BlockPercent: []float64{},
+		InodePercent: []float64{},
+		ReadBytes:    []float64{},
+		WriteBytes:   []float64{},
+		ReadOps:      []float64{},
+		WriteOps:     []float64{},
+	}
+	var times []time.Time
+	for rows.Next() {
+		var bucketIndex int64
+		var block, inode *float64
+		var readBytes, writeBytes, readOps, writeOps float64
+		var firstSample string
+		if err := rows.Scan(&bucketIndex, &block, &inode, &readBytes, &writeBytes, &readOps, &writeOps, &firstSample); err != nil {
+			return nil, nil, err
+		}
+		if block == nil {
+			continue
+		}
+
+		ts := time.Unix(bucketIndex*bucketSecs, 0).UTC()
+		if t, err := time.Parse(storedTimeLayout, firstSample); err == nil {
+			_, offset := t.Zone()
+			ts = ts.Add(-time.Duration(offset) * time.Second)
+		}
+		times = append(times, ts)
+		resp.Timestamps = append(resp.Timestamps, ts.Format(time.RFC3339))
+		resp.BlockPercent = append(resp.BlockPercent, *block)
+		if inode != nil {
+			resp.InodePercent = append(resp.InodePercent, *inode)
+		} else {
+			resp.InodePercent = append(resp.InodePercent, 0)
+		}
+		resp.ReadBytes = append(resp.ReadBytes, readBytes)
+		resp.WriteBytes = append(resp.WriteBytes, writeBytes)
+		resp.ReadOps = append(resp.ReadOps, readOps)
+		resp.WriteOps = append(resp.WriteOps, writeOps)
+	}
+	return resp, times, rows.Err()
+}
+
+// fitFilesystemTrend fits a least-squares line through the usage samples
+// and projects when it crosses 100%. It returns nil with fewer than two
+// distinct sample times.
+func fitFilesystemTrend(times []time.Time, percents []float64, now time.Time) *FilesystemTrend {
+	if len(times) < 2 {
+		return nil
+	}
+
+	// x is in days since the first sample
+	origin := times[0]
+	n := float64(len(times))
+	var sumX, sumY, sumXX, sumXY float64
+	for i, t := range times {
+		x := t.Sub(origin).Hours() / 24
+		y := percents[i]
+		sumX += x
+		sumY += y
+		sumXX += x * x
+		sumXY += x * y
+	}
+	denom := n*sumXX - sumX*sumX
+	if denom == 0 {
+		return nil
+	}
+	slope := (n*sumXY - sumX*sumY) / denom
+	intercept := (sumY - slope*sumX) / n
+
+	trend := &FilesystemTrend{PercentPerDay: slope, Fitted: make([]float64, len(times))}
+	for i, t := range times {
+		trend.Fitted[i] = intercept + slope*t.Sub(origin).Hours()/24
+	}
+
+	if slope > 0 {
+		days := (100 - intercept) / slope
+		fullAt := origin.Add(time.Duration(days * 24 * float64(time.Hour)))
+		if fullAt.Before(now) {
+			fullAt = now // Already at the projected limit
+		}
+		trend.FullAt = &fullAt
+	}
+	return trend
+}
+
+// HandleFilesystemUsageAPI returns the space and inode usage and I/O rate
+// history of a filesystem service with a linear projection of when it
+// fills up.
+//
+// GET /api/v1/filesystem-metrics?host_id=xxx&service=yyy&range=7d
+//
+// Usage is averaged into buckets sized for the range. The projection fits
+// a straight line through the space used % buckets, so a longer range
+// gives a steadier estimate; full_at is omitted when usage is not growing.
+func HandleFilesystemUsageAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	hostID := query.Get("host_id")
+	serviceName := query.Get("service")
+	if hostID == "" || serviceName == "" {
+		respondJSON(w, map[string]string{"error": "Missing host_id or service parameter"}, http.StatusBadRequest)
+		return
+	}
+
+	rangeStr := query.Get("range")
+	if rangeStr == "" {
+		rangeStr = loadPreferences(r).DefaultRange
+	}
+	duration, err := parseTimeRange(rangeStr)
+	if err != nil || duration <= 0 {
+		respondJSON(w, map[string]string{"error": "Invalid range parameter"}, http.StatusBadRequest)
+		return
+	}
+
+	endTime := time.Now()
+	startTime := endTime.Add(-duration)
+	resp, times, err := getFilesystemUsage(hostID, serviceName, startTime, endTime, autoBucket(duration))
+	if err != nil {
+		log.Printf("[ERROR] Failed to get filesystem usage for %s/%s: %v", hostID, serviceName, err)
+		respondJSON(w, map[string]string{"error": "Failed to get filesystem usage"}, http.StatusInternalServerError)
+		return
+	}
+	resp.Trend = fitFilesystemTrend(times, resp.BlockPercent, endTime)
+
+	respondJSON(w, resp, http.StatusOK)
+}
@@ -15,10 +15,14 @@ import (
 	"html/template" // HTML templating
 	"log"           // Logging
 	"net/http"      // HTTP server
+	"strings"       // String manipulation
 	"time"          // Time handling
 
 	"github.com/gomarkdown/markdown"      // Markdown parser
 	"github.com/gomarkdown/markdown/html" // HTML renderer
+
+	dbpkg "github.com/ocochard/cmonit/internal/db" // Database helpers
+	"github.com/ocochard/cmonit/internal/parser"   // Monit check schedule types, status texts
 )
 
 // =============================================================================
@@ -30,35 +34,47 @@ import (
 // This struct is passed to the HTML template engine.
 // Go templates can access these fields using {{.Hosts}}, {{.LastUpdate}}, etc.
 type DashboardData struct {
-	Hosts      []HostWithServices // List of all monitored hosts
-	LastUpdate time.Time          // When this data was retrieved
-	AppVersion string             // Application version (e.g., "1.0.0")
+	Hosts        []HostWithServices // List of all monitored hosts
+	LastUpdate   time.Time          // When this data was retrieved
+	AppVersion   string             // Application version (e.g., "1.0.0")
+	Prefs        Preferences        // Viewer display preferences
+	PublicStatus bool               // Public status page is enabled (-public-status)
+	MonitCAFile  string             // Default CA bundle for Monit agents using HTTPS
 }
 
 // HostWithServices represents a host and all its services.
 //
 // This combines data from the hosts and services tables.
 type HostWithServices struct {
-	ID            string    // Unique host ID from Monit
-	Hostname      string    // Display name (e.g., "bigone")
-	Version       string    // Monit version
-	OSName        string    // Operating system name
-	OSRelease     string    // OS version/release
-	Machine       string    // CPU architecture
-	CPUCount      int       // Number of CPU cores
-	TotalMemory   int64     // Total RAM in bytes
-	TotalSwap     int64     // Total swap in bytes
-	SystemUptime  *int64    // System uptime in seconds
-	Boottime      *int64    // Unix timestamp of last boot
-	LastSeen      time.Time // Last successful update
-	Services      []Service // All services on this host
-	IsStale       bool      // True if not seen in 5+ minutes (deprecated, use HealthStatus)
-	PollInterval  int       // Monit poll interval in seconds
-	HealthStatus  string    // Host health status: "green", "yellow", "red"
-	HealthEmoji   string    // Health status emoji: 🟢, 🟡, 🔴
-	HealthLabel   string    // Health status label: "Healthy", "Warning", "Offline"
-	LastSeenText  string    // Human-readable "last seen" text (e.g., "5 minutes ago")
-	Description   string    // User-defined HTML description/notes for this host
+	ID           string             // Unique host ID from Monit
+	Hostname     string             // Display name (e.g., "bigone")
+	Version      string             // Monit version
+	OSName       string             // Operating system name
+	OSRelease    string             // OS version/release
+	Machine      string             // CPU architecture
+	CPUCount     int                // Number of CPU cores
+	TotalMemory  int64              // Total RAM in bytes
+	TotalSwap    int64              // Total swap in bytes
+	SystemUptime *int64             // System uptime in seconds
+	Boottime     *int64             // Unix timestamp of last boot
+	LastSeen     time.Time          // Last successful update
+	Services     []Service          // All services on this host
+	IsStale      bool               // True if not seen in 5+ minutes (deprecated, use HealthStatus)
+	PollInterval int                // Monit poll interval in seconds
+	HealthStatus string             // Host health status: "green", "yellow", "red"
+	HealthEmoji  string             // Health status emoji: 🟢, 🟡, 🔴
+	HealthLabel  string             // Health status label: "Healthy", "Warning", "Offline"
+	LastSeenText string             // Human-readable "last seen" text (e.g., "5 minutes ago")
+	Description  string             // User-defined HTML description/notes for this host
+	Public       bool               // Listed on the public status page
+	ArchivedAt   *time.Time         // When the host was archived (nil = active)
+	HTTPSSL      bool               // Monit agent serves HTTPS
+	Control      *dbpkg.HostControl // Agent connection settings (nil unless the viewer may see them)
+
+	RecentActions    []dbpkg.ActionRecord    // Last service actions sent to the agent (host page only)
+	ScheduledActions []dbpkg.ScheduledAction // Pending scheduled actions (host page only)
+	FailedServices   []string                // Failed process services, restarted by "Restart Failed" (host page only)
+	Dependencies     []*DependencyNode       // Service dependency tree (host page only)
 }
 
 // Service represents a monitored service.
@@ -77,14 +93,51 @@ type Service struct {
 	MemoryPercent *float64  // Memory usage % (for process services)
 	MemoryKB      *int64    // Memory usage in KB (for process services)
 	CollectedAt   time.Time // When metrics were last collected
+	Acknowledged  bool      // Latest event acknowledged (failure does not affect host status)
+
+	EveryType     int           // Check schedule (parser.EveryCycle, EverySkipCycles, EveryCron, EveryNotInCron)
+	EveryCycles   int           // Checked every N poll cycles
+	EveryCron     string        // Cron spec of EveryCron and EveryNotInCron schedules
+	CheckInterval time.Duration // Expected time between checks (0 for cron schedules)
+	Overdue       bool          // Not collected for twice CheckInterval
+}
+
+// CheckSchedule returns the check schedule of the service as in monitrc.
+func (s *Service) CheckSchedule() string {
+	switch s.EveryType {
+	case parser.EverySkipCycles:
+		if s.EveryCycles > 1 {
+			return fmt.Sprintf("Every %d cycles", s.EveryCycles)
+		}
+	case parser.EveryCron:
+		return "Every \"" + s.EveryCron + "\""
+	case parser.EveryNotInCron:
+		return "Not every \"" + s.EveryCron + "\""
+	}
+	return "Every cycle"
+}
+
+// NextCheck returns when the next report of the service is expected, or
+// the zero time for cron schedules.
+func (s *Service) NextCheck() time.Time {
+	if s.CheckInterval == 0 {
+		return time.Time{}
+	}
+	return s.CollectedAt.Add(s.CheckInterval)
 }
 
 // StatusData holds data for the main status overview page.
 type StatusData struct {
-	Hosts      []HostStatus // List of all hosts with aggregated status
+	Hosts      []HostStatus // Hosts on the current page, filtered and sorted
 	LastUpdate time.Time    // When this data was retrieved
 	AppVersion string       // Application version (e.g., "1.0.0")
-	Groups     []string     // List of all unique hostgroups for filtering
+	Groups     []string     // Hostgroup and service group names for filtering
+	Prefs      Preferences  // Viewer display preferences
+	OSNames    []string     // List of all unique OS names for filtering
+	Query      StatusQuery  // Filter/sort/pagination parameters of this request
+	TotalHosts int          // Number of hosts matching the filters (all pages)
+	TotalPages int          // Number of pages for TotalHosts at Query.PerPage
+	User       string       // Logged-in web user ("" without authentication)
 }
 
 // HostStatus represents a host's overall status for the status page.
@@ -100,27 +153,40 @@ type HostStatus struct {
 	MemoryPercent     *float64  // System memory usage %
 	EventCount        int       // Number of events for this host
 	TotalServices     int       // Total number of services
-	FailedServices    int       // Number of failed/warning services
+	FailedServices    int       // Number of failed/warning services, excluding acknowledged ones
+	AckedServices     int       // Number of failed/warning services with an acknowledged event
 	Groups            []string  // Hostgroups this host belongs to
+	OSName            string    // Operating system name (e.g., "FreeBSD")
 }
 
 // EventsData holds data for the events page.
 type EventsData struct {
-	HostID     string    // Host ID
-	Hostname   string    // Host display name
-	Events     []Event   // List of events
-	LastUpdate time.Time // When this data was retrieved
-	AppVersion string    // Application version (e.g., "1.0.0")
+	HostID     string      // Host ID
+	Hostname   string      // Host display name
+	Events     []Event     // List of events
+	LastUpdate time.Time   // When this data was retrieved
+	AppVersion string      // Application version (e.g., "1.0.0")
+	Prefs      Preferences // Viewer display preferences
 }
 
 // Event represents a single event from the events table.
 type Event struct {
-	ID            int       // Event ID
-	ServiceName   string    // Service that generated the event
-	EventType     int       // Event type code
-	EventTypeName string    // Human-readable event type
-	Message       string    // Event message
-	CreatedAt     time.Time // When the event occurred
+	ID            int        `json:"id"`                   // Event ID
+	HostID        string     `json:"host_id,omitempty"`    // Host that generated the event (global events view)
+	Hostname      string     `json:"hostname,omitempty"`   // Hostname (global events view)
+	ServiceName   string     `json:"service"`              // Service that generated the event
+	EventType     int        `json:"event_type"`           // Event type code
+	EventTypeName string     `json:"event_type_name"`      // Human-readable event type
+	Severity      string     `json:"severity"`             // info, warning or critical (see db.EventSeverity)
+	FlapCount     int        `json:"flap_count,omitempty"` // Failures and recoveries collapsed, for a flapping service
+	Source        string     `json:"source,omitempty"`     // External system that recorded the event (POST /api/v1/events)
+	Message       string     `json:"message"`              // Event message
+	CreatedAt     time.Time  `json:"created_at"`           // When the event occurred
+	AckBy         string     `json:"ack_by,omitempty"`     // Who acknowledged the event
+	AckAt         *time.Time `json:"ack_at,omitempty"`     // When it was acknowledged (nil = unacknowledged)
+	AckNote       string     `json:"ack_note,omitempty"`   // Acknowledgment note
+
+	Comments []EventComment `json:"comments,omitempty"` // Comments of the operators, oldest first
 }
 
 // ServiceDetailData holds data for the service detail page.
@@ -130,6 +196,7 @@ type ServiceDetailData struct {
 	Service         Service             // Service information
 	FilesystemData  *FilesystemMetrics  // Filesystem metrics (if type 0)
 	FileData        *FileMetrics        // File metrics (if type 2)
+	PathData        *PathMetrics        // Directory or FIFO metrics (if type 1 or 6)
 	ProcessData     *ProcessMetrics     // Process metrics (if type 3)
 	SystemData      *SystemMetrics      // System metrics (if type 5)
 	ProgramData     *ProgramMetrics     // Program metrics (if type 7)
@@ -137,6 +204,7 @@ type ServiceDetailData struct {
 	RemoteHostData  *RemoteHostMetrics  // Remote host metrics (if type 3 or 4)
 	LastUpdate      time.Time           // When this data was retrieved
 	AppVersion      string              // Application version (e.g., "1.0.0")
+	Prefs           Preferences         // Viewer display preferences
 }
 
 // FilesystemMetrics holds filesystem service metrics.
@@ -156,6 +224,10 @@ type FilesystemMetrics struct {
 	ReadOpsTotal    int64   // Total read operations
 	WriteBytesTotal int64   // Total bytes written
 	WriteOpsTotal   int64   // Total write operations
+	ReadBytesRate   int64   // Bytes read per second (last cycle)
+	ReadOpsRate     int64   // Read operations per second (last cycle)
+	WriteBytesRate  int64   // Bytes written per second (last cycle)
+	WriteOpsRate    int64   // Write operations per second (last cycle)
 }
 
 // ProcessMetrics holds process service metrics.
@@ -199,6 +271,16 @@ type FileMetrics struct {
 	ChecksumValue string // Checksum value
 }
 
+// PathMetrics holds directory and FIFO service metrics.
+type PathMetrics struct {
+	Mode       string // Permissions mode (e.g., "755")
+	UID        int    // Owner user ID
+	GID        int    // Owner group ID
+	AccessTime *int64 // Unix timestamp of last access (nil if not reported)
+	ChangeTime *int64 // Unix timestamp of last change (nil if not reported)
+	ModifyTime *int64 // Unix timestamp of last modification (nil if not reported)
+}
+
 // ProgramMetrics holds program service metrics.
 type ProgramMetrics struct {
 	Started    int64  // Unix timestamp when program started
@@ -233,23 +315,34 @@ type SystemMetrics struct {
 	SwapKB      int64   // Swap usage in KB
 }
 
-// RemoteHostMetrics holds remote host service metrics (ICMP, Port, Unix socket).
+// RemoteHostMetrics holds remote host service metrics (ICMP, Port, Unix socket),
+// one entry per check of the service, in monitrc order.
 type RemoteHostMetrics struct {
-	// ICMP Metrics
-	ICMPType           string  // Ping type (e.g., "echo")
-	ICMPResponseTimeMs float64 // Response time in milliseconds
-
-	// Port Metrics
-	PortHostname       string  // Target hostname for port monitoring
-	PortNumber         int     // Port number
-	PortProtocol       string  // Protocol (e.g., "TCP" or "UDP")
-	PortType           string  // Port type
-	PortResponseTimeMs float64 // Response time in milliseconds
-
-	// Unix Socket Metrics
-	UnixPath           string  // Unix socket path
-	UnixProtocol       string  // Protocol
-	UnixResponseTimeMs float64 // Response time in milliseconds
+	ICMP  []ICMPCheck
+	Ports []PortCheck
+	Unix  []UnixCheck
+}
+
+// ICMPCheck holds the latest result of a ping check.
+type ICMPCheck struct {
+	Type           string  // Ping type (e.g., "echo")
+	ResponseTimeMs float64 // Response time in milliseconds
+}
+
+// PortCheck holds the latest result of a TCP/UDP port check.
+type PortCheck struct {
+	Hostname       string  // Target hostname for port monitoring
+	Number         int     // Port number
+	Protocol       string  // Protocol (e.g., "HTTP" or "DEFAULT")
+	Type           string  // Transport (TCP or UDP)
+	ResponseTimeMs float64 // Response time in milliseconds
+}
+
+// UnixCheck holds the latest result of a Unix socket check.
+type UnixCheck struct {
+	Path           string  // Unix socket path
+	Protocol       string  // Protocol
+	ResponseTimeMs float64 // Response time in milliseconds
 }
 
 // =============================================================================
@@ -381,8 +474,7 @@ func InitTemplates() error {
 			if ts == nil {
 				return "N/A"
 			}
-			t := time.Unix(*ts, 0)
-			return t.Format("Jan 2, 15:04")
+			return displayTime(time.Unix(*ts, 0), "Jan 2, 15:04")
 		},
 		"deref": func(f *float64) float64 {
 			if f == nil {
@@ -390,6 +482,12 @@ func InitTemplates() error {
 			}
 			return *f
 		},
+		"add": func(a, b int) int {
+			return a + b
+		},
+		"split": func(s string) []string {
+			return strings.Split(s, ",")
+		},
 		"renderMarkdown": func(s string) template.HTML {
 			// Configure HTML renderer with security options
 			htmlFlags := html.CommonFlags | html.HrefTargetBlank
@@ -464,6 +562,7 @@ func HandleDashboard(w http.ResponseWriter, r *http.Request) {
 	// 3. Writes output to 'w' (the HTTP response)
 	//
 	// Template can access data fields like {{.Hosts}}, {{.LastUpdate}}
+	data.Prefs = loadPreferences(r)
 	err = templates.ExecuteTemplate(w, "dashboard.html", data)
 	if err != nil {
 		log.Printf("[ERROR] Failed to render template: %v", err)
@@ -501,6 +600,7 @@ func getDashboardData() (*DashboardData, error) {
 		SELECT id, hostname, version, os_name, os_release, machine,
 		       cpu_count, total_memory, total_swap, system_uptime, boottime, last_seen, description
 		FROM hosts
+		WHERE archived_at IS NULL
 		ORDER BY last_seen DESC
 	`
 
@@ -607,7 +707,10 @@ func getServicesForHost(hostID string) ([]Service, error) {
 	// ORDER BY type, name: Group by type, then alphabetically
 	// Include process metrics (pid, cpu_percent, memory_percent, memory_kb) for process services
 	const servicesQuery = `
-		SELECT name, type, status, monitor, pid, cpu_percent, memory_percent, memory_kb, collected_at
+		SELECT name, type, status, monitor, pid, cpu_percent, memory_percent, memory_kb, collected_at,
+		       ` + serviceAckedColumn + `,
+		       every_type, every_cycles, every_cron,
+		       COALESCE((SELECT poll_interval FROM hosts WHERE hosts.id = services.host_id), 30)
 		FROM services
 		WHERE host_id = ?
 		ORDER BY type, name
@@ -627,6 +730,7 @@ func getServicesForHost(hostID string) ([]Service, error) {
 
 	for rows.Next() {
 		var svc Service
+		var pollInterval int
 
 		// Scan all fields including process metrics (which may be NULL)
 		err := rows.Scan(
@@ -639,10 +743,16 @@ func getServicesForHost(hostID string) ([]Service, error) {
 			&svc.MemoryPercent,
 			&svc.MemoryKB,
 			&svc.CollectedAt,
+			&svc.Acknowledged,
+			&svc.EveryType,
+			&svc.EveryCycles,
+			&svc.EveryCron,
+			&pollInterval,
 		)
 		if err != nil {
 			return nil, err
 		}
+		setServiceSchedule(&svc, pollInterval)
 
 		// Convert numeric type to human-readable string
 		svc.TypeName = getServiceTypeName(svc.Type)
@@ -728,77 +838,10 @@ func getServiceStatusInfo(status int) (string, string) {
 
 // StatusMessage returns a human-readable status message for the service.
 //
-// Monit status codes (from monit-5.35.2/src/event.h Event_Type enum):
-// These are bit flags that can be combined, but typically appear individually.
+// Monit status codes are bit flags of the failed event types
+// (monit-5.35.2/src/event.h Event_Type), see parser.StatusText.
 func (s *Service) StatusMessage() string {
-	switch s.Status {
-	case 0:
-		return "OK"
-	case 0x1: // Event_Checksum
-		return "Checksum failed"
-	case 0x2: // Event_Resource
-		return "Resource limit matched"
-	case 0x4: // Event_Timeout
-		return "Timeout"
-	case 0x8: // Event_Timestamp
-		return "Timestamp changed"
-	case 0x10: // Event_Size
-		return "Size changed"
-	case 0x20: // Event_Connection
-		return "Connection failed"
-	case 0x40: // Event_Permission
-		return "Permission failed"
-	case 0x80: // Event_Uid
-		return "UID failed"
-	case 0x100: // Event_Gid
-		return "GID failed"
-	case 0x200: // Event_NonExist
-		return "Does not exist"
-	case 0x400: // Event_Invalid
-		return "Invalid type"
-	case 0x800: // Event_Data
-		return "Data access error"
-	case 0x1000: // Event_Exec
-		return "Execution failed"
-	case 0x2000: // Event_FsFlag
-		return "Filesystem flags changed"
-	case 0x4000: // Event_Icmp
-		return "ICMP failed"
-	case 0x8000: // Event_Content
-		return "Content match failed"
-	case 0x10000: // Event_Instance
-		return "Instance changed"
-	case 0x20000: // Event_Action
-		return "Action done"
-	case 0x40000: // Event_Pid
-		return "PID changed"
-	case 0x80000: // Event_PPid
-		return "PPID changed"
-	case 0x100000: // Event_Heartbeat
-		return "Heartbeat failed"
-	case 0x200000: // Event_Status
-		return "Status changed"
-	case 0x400000: // Event_Uptime
-		return "Uptime failed"
-	case 0x800000: // Event_Link
-		return "Link down"
-	case 0x1000000: // Event_Speed
-		return "Speed changed"
-	case 0x2000000: // Event_Saturation
-		return "Saturation exceeded"
-	case 0x4000000: // Event_ByteIn
-		return "Download bytes exceeded"
-	case 0x8000000: // Event_ByteOut
-		return "Upload bytes exceeded"
-	case 0x10000000: // Event_PacketIn
-		return "Download packets exceeded"
-	case 0x20000000: // Event_PacketOut
-		return "Upload packets exceeded"
-	case 0x40000000: // Event_Exist
-		return "Exists"
-	default:
-		return fmt.Sprintf("Unknown status (%d)", s.Status)
-	}
+	return parser.StatusText(s.Status)
 }
 
 // =============================================================================
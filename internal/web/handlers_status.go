@@ -6,8 +6,13 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
+
+	dbpkg "github.com/ocochard/cmonit/internal/db"
 )
 
 // HandleStatus serves the main status overview page.
@@ -17,7 +22,7 @@ func HandleStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	data, err := getStatusData()
+	data, err := getStatusData(parseStatusQuery(r))
 	if err != nil {
 		log.Printf("[ERROR] Failed to get status data: %v", err)
 		http.Error(w, "Failed to load status data", http.StatusInternalServerError)
@@ -26,6 +31,8 @@ func HandleStatus(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 
+	data.Prefs = loadPreferences(r)
+	data.User = currentUser(r)
 	err = templates.ExecuteTemplate(w, "status.html", data)
 	if err != nil {
 		log.Printf("[ERROR] Failed to render template: %v", err)
@@ -55,8 +62,16 @@ func HandleHostDetail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if canSeeConnections(r) {
+		data.Hosts[0].Control, err = dbpkg.GetHostControl(db, hostID)
+		if err != nil {
+			log.Printf("[ERROR] %v", err)
+		}
+	}
+
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 
+	data.Prefs = loadPreferences(r)
 	err = templates.ExecuteTemplate(w, "dashboard.html", data)
 	if err != nil {
 		log.Printf("[ERROR] Failed to render template: %v", err)
@@ -88,12 +103,150 @@ func HandleHostEvents(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 
+	data.Prefs = loadPreferences(r)
 	err = templates.ExecuteTemplate(w, "events.html", data)
 	if err != nil {
 		log.Printf("[ERROR] Failed to render template: %v", err)
 	}
 }
 
+// Status page defaults and limits.
+const (
+	defaultStatusPerPage = 50
+	maxStatusPerPage     = 500
+)
+
+// StatusQuery holds the filter, sort and pagination parameters of the
+// status overview page, parsed from the request query string.
+type StatusQuery struct {
+	Search  string // Case-insensitive hostname substring ("q")
+	Group   string // Hostgroup name ("group")
+	Status  string // Status color: green, orange, red, gray ("status")
+	OS      string // Operating system name ("os")
+	Sort    string // hostname, status, cpu, memory, events, lastseen ("sort")
+	Dir     string // asc or desc ("dir")
+	Page    int    // 1-based page number ("page")
+	PerPage int    // Hosts per page ("per_page")
+}
+
+// statusSortKeys lists the accepted values of the "sort" parameter.
+var statusSortKeys = map[string]bool{
+	"hostname": true,
+	"status":   true,
+	"cpu":      true,
+	"memory":   true,
+	"events":   true,
+	"lastseen": true,
+}
+
+// statusColorOrder ranks status colors from worst to best so that an
+// ascending status sort lists problem hosts first.
+var statusColorOrder = map[string]int{
+	"red":    0,
+	"orange": 1,
+	"gray":   2,
+	"green":  3,
+}
+
+// parseStatusQuery extracts StatusQuery from the request, falling back to
+// defaults for missing or invalid values.
+func parseStatusQuery(r *http.Request) StatusQuery {
+	v := r.URL.Query()
+
+	q := StatusQuery{
+		Search:  strings.TrimSpace(v.Get("q")),
+		Group:   v.Get("group"),
+		OS:      v.Get("os"),
+		Sort:    "hostname",
+		Dir:     "asc",
+		Page:    1,
+		PerPage: defaultStatusPerPage,
+	}
+
+	if _, ok := statusColorOrder[v.Get("status")]; ok {
+		q.Status = v.Get("status")
+	}
+	if statusSortKeys[v.Get("sort")] {
+		q.Sort = v.Get("sort")
+	}
+	if v.Get("dir") == "desc" {
+		q.Dir = "desc"
+	}
+	if page, err := strconv.Atoi(v.Get("page")); err == nil && page > 0 {
+		q.Page = page
+	}
+	if perPage, err := strconv.Atoi(v.Get("per_page")); err == nil && perPage > 0 {
+		q.PerPage = perPage
+		if q.PerPage > maxStatusPerPage {
+			q.PerPage = maxStatusPerPage
+		}
+	}
+
+	return q
+}
+
+// values encodes the query back into URL parameters, omitting defaults.
+func (q StatusQuery) values() url.Values {
+	v := url.Values{}
+	if q.Search != "" {
+		v.Set("q", q.Search)
+	}
+	if q.Group != "" {
+		v.Set("group", q.Group)
+	}
+	if q.Status != "" {
+		v.Set("status", q.Status)
+	}
+	if q.OS != "" {
+		v.Set("os", q.OS)
+	}
+	if q.Sort != "hostname" {
+		v.Set("sort", q.Sort)
+	}
+	if q.Dir != "asc" {
+		v.Set("dir", q.Dir)
+	}
+	if q.Page > 1 {
+		v.Set("page", strconv.Itoa(q.Page))
+	}
+	if q.PerPage != defaultStatusPerPage {
+		v.Set("per_page", strconv.Itoa(q.PerPage))
+	}
+	return v
+}
+
+// SortURL returns the status page URL sorted by the given column. Selecting
+// the column already in use toggles the direction. Used by status.html.
+func (q StatusQuery) SortURL(column string) string {
+	next := q
+	next.Page = 1
+	if q.Sort == column && q.Dir == "asc" {
+		next.Dir = "desc"
+	} else {
+		next.Dir = "asc"
+	}
+	next.Sort = column
+	return "/?" + next.values().Encode()
+}
+
+// SortIndicator returns the arrow shown next to a column header.
+func (q StatusQuery) SortIndicator(column string) string {
+	if q.Sort != column {
+		return "▲▼"
+	}
+	if q.Dir == "desc" {
+		return "▼"
+	}
+	return "▲"
+}
+
+// PageURL returns the status page URL for the given page number.
+func (q StatusQuery) PageURL(page int) string {
+	next := q
+	next.Page = page
+	return "/?" + next.values().Encode()
+}
+
 // getStatusData queries the database and builds StatusData for the main status page.
 //
 // Originally this ran 5 queries per host (services, cpu, memory, event count,
@@ -101,14 +254,42 @@ func HandleHostEvents(w http.ResponseWriter, r *http.Request) {
 // issues a fixed number of grouped queries and assembles per-host results
 // from Go maps keyed by host_id, preserving the exact output fields/defaults
 // of the previous per-host implementation.
-func getStatusData() (*StatusData, error) {
-	const hostsQuery = `
-		SELECT id, hostname, last_seen
+//
+// Hostname, group and OS filters are applied in SQL. The status color is
+// derived from services, so the status filter, sorting and pagination run
+// in Go after the per-host fields are assembled.
+func getStatusData(q StatusQuery) (*StatusData, error) {
+	hostsQuery := `
+		SELECT id, hostname, last_seen, COALESCE(os_name, '')
 		FROM hosts
-		ORDER BY last_seen DESC
+		WHERE archived_at IS NULL
 	`
-
-	rows, err := db.Query(hostsQuery)
+	var args []interface{}
+
+	if q.Search != "" {
+		hostsQuery += " AND hostname LIKE ? ESCAPE '\\'"
+		args = append(args, "%"+escapeLike(q.Search)+"%")
+	}
+	if q.Group != "" {
+		// Hosts of the hostgroup, or with services in the service group
+		hostsQuery += `
+		AND (id IN (
+			SELECT hhg.host_id
+			FROM host_hostgroups hhg
+			JOIN hostgroups hg ON hg.id = hhg.hostgroup_id
+			WHERE hg.name = ?
+		) OR id IN (
+			SELECT host_id FROM service_groups WHERE group_name = ?
+		))`
+		args = append(args, q.Group, q.Group)
+	}
+	if q.OS != "" {
+		hostsQuery += " AND os_name = ?"
+		args = append(args, q.OS)
+	}
+	hostsQuery += " ORDER BY last_seen DESC"
+
+	rows, err := db.Query(hostsQuery, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -123,6 +304,7 @@ func getStatusData() (*StatusData, error) {
 			&hostStatus.ID,
 			&hostStatus.Hostname,
 			&hostStatus.LastSeen,
+			&hostStatus.OSName,
 		)
 		if err != nil {
 			return nil, err
@@ -163,12 +345,17 @@ func getStatusData() (*StatusData, error) {
 		groupsByHost = map[string][]string{}
 	}
 
+	filtered := hosts[:0]
 	for i := range hosts {
 		hostStatus := &hosts[i]
 
 		services := servicesByHost[hostStatus.ID]
 		calculateHostStatus(hostStatus, services)
 
+		if q.Status != "" && hostStatus.StatusColor != q.Status {
+			continue
+		}
+
 		if cpu, ok := cpuByHost[hostStatus.ID]; ok {
 			hostStatus.CPUPercent = &cpu
 		}
@@ -183,7 +370,27 @@ func getStatusData() (*StatusData, error) {
 		} else {
 			hostStatus.Groups = []string{}
 		}
+
+		filtered = append(filtered, *hostStatus)
+	}
+	hosts = filtered
+
+	sortHostStatuses(hosts, q.Sort, q.Dir == "desc")
+
+	totalHosts := len(hosts)
+	totalPages := (totalHosts + q.PerPage - 1) / q.PerPage
+	if totalPages == 0 {
+		totalPages = 1
+	}
+	if q.Page > totalPages {
+		q.Page = totalPages
 	}
+	start := (q.Page - 1) * q.PerPage
+	end := start + q.PerPage
+	if end > totalHosts {
+		end = totalHosts
+	}
+	hosts = hosts[start:end]
 
 	// Get all unique hostgroup names for the filter dropdown
 	allGroups, err := getAllHostGroups()
@@ -192,19 +399,90 @@ func getStatusData() (*StatusData, error) {
 		allGroups = []string{}
 	}
 
+	osNames, err := getAllOSNames()
+	if err != nil {
+		log.Printf("[ERROR] Failed to get OS names: %v", err)
+		osNames = []string{}
+	}
+
 	return &StatusData{
 		Hosts:      hosts,
 		LastUpdate: time.Now(),
 		AppVersion: appVersion,
 		Groups:     allGroups,
+		OSNames:    osNames,
+		Query:      q,
+		TotalHosts: totalHosts,
+		TotalPages: totalPages,
 	}, nil
 }
 
+// sortHostStatuses orders hosts in place by the given StatusQuery sort key.
+// Hosts without CPU/memory data sort below any host that has it. Ties are
+// broken by hostname so the order is stable across page loads.
+func sortHostStatuses(hosts []HostStatus, key string, desc bool) {
+	optional := func(p *float64) float64 {
+		if p == nil {
+			return -1
+		}
+		return *p
+	}
+
+	sort.SliceStable(hosts, func(i, j int) bool {
+		a, b := &hosts[i], &hosts[j]
+		var cmp int
+		switch key {
+		case "status":
+			cmp = statusColorOrder[a.StatusColor] - statusColorOrder[b.StatusColor]
+		case "cpu":
+			cmp = compareFloat(optional(a.CPUPercent), optional(b.CPUPercent))
+		case "memory":
+			cmp = compareFloat(optional(a.MemoryPercent), optional(b.MemoryPercent))
+		case "events":
+			cmp = a.EventCount - b.EventCount
+		case "lastseen":
+			cmp = a.LastSeen.Compare(b.LastSeen)
+		}
+		if cmp == 0 {
+			cmp = strings.Compare(strings.ToLower(a.Hostname), strings.ToLower(b.Hostname))
+			if key != "hostname" {
+				// Secondary hostname order is always ascending.
+				return cmp < 0
+			}
+		}
+		if desc {
+			return cmp > 0
+		}
+		return cmp < 0
+	})
+}
+
+// compareFloat returns -1, 0 or 1 like strings.Compare.
+func compareFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// escapeLike escapes LIKE wildcards so user input matches literally.
+// Queries using it must declare ESCAPE '\'.
+func escapeLike(s string) string {
+	s = strings.ReplaceAll(s, "\\", "\\\\")
+	s = strings.ReplaceAll(s, "%", "\\%")
+	s = strings.ReplaceAll(s, "_", "\\_")
+	return s
+}
+
 // getServicesGroupedByHost loads every host's services in one query and
 // buckets them by host_id, replacing N per-host getServicesForHost calls.
 func getServicesGroupedByHost() (map[string][]Service, error) {
 	const query = `
-		SELECT host_id, name, type, status, monitor, pid, cpu_percent, memory_percent, memory_kb, collected_at
+		SELECT host_id, name, type, status, monitor, pid, cpu_percent, memory_percent, memory_kb, collected_at,
+		       ` + serviceAckedColumn + `
 		FROM services
 		ORDER BY host_id, type, name
 	`
@@ -232,6 +510,7 @@ func getServicesGroupedByHost() (map[string][]Service, error) {
 			&svc.MemoryPercent,
 			&svc.MemoryKB,
 			&svc.CollectedAt,
+			&svc.Acknowledged,
 		)
 		if err != nil {
 			return nil, err
@@ -378,12 +657,14 @@ func getHostGroupsGroupedByHost() (map[string][]string, error) {
 func getHostDetailData(hostID string) (*DashboardData, error) {
 	const hostQuery = `
 		SELECT id, hostname, version, os_name, os_release, machine,
-		       cpu_count, total_memory, total_swap, system_uptime, boottime, last_seen, poll_interval, description
+		       cpu_count, total_memory, total_swap, system_uptime, boottime, last_seen, poll_interval, description,
+		       COALESCE(public, 0), COALESCE(http_ssl, 0), archived_at
 		FROM hosts
 		WHERE id = ?
 	`
 
 	var host HostWithServices
+	var archivedAt sql.NullTime
 
 	err := db.QueryRow(hostQuery, hostID).Scan(
 		&host.ID,
@@ -400,10 +681,16 @@ func getHostDetailData(hostID string) (*DashboardData, error) {
 		&host.LastSeen,
 		&host.PollInterval,
 		&host.Description,
+		&host.Public,
+		&host.HTTPSSL,
+		&archivedAt,
 	)
 	if err != nil {
 		return nil, err
 	}
+	if archivedAt.Valid {
+		host.ArchivedAt = &archivedAt.Time
+	}
 
 	// Calculate health status based on last_seen and poll_interval
 	lastSeenUnix := host.LastSeen.Unix()
@@ -424,7 +711,7 @@ func getHostDetailData(hostID string) (*DashboardData, error) {
 	// If heartbeat shows warning (yellow) or healthy (green), check services
 	hasFailedServices := false
 	for _, svc := range host.Services {
-		if svc.Status != 0 { // Not OK
+		if svc.Status != 0 && !svc.Acknowledged { // Not OK and not acknowledged
 			hasFailedServices = true
 			break
 		}
@@ -444,10 +731,32 @@ func getHostDetailData(hostID string) (*DashboardData, error) {
 	host.HealthLabel = GetHealthLabel(healthStatus)
 	host.LastSeenText = FormatTimeSince(lastSeenUnix)
 
+	host.RecentActions, err = dbpkg.RecentActions(db, host.ID, recentActionsLimit)
+	if err != nil {
+		log.Printf("[ERROR] %v", err)
+	}
+	host.ScheduledActions, err = dbpkg.ListScheduledActions(db, host.ID, false)
+	if err != nil {
+		log.Printf("[ERROR] %v", err)
+	}
+	// Same selection as HandleRestartFailedAPI
+	for _, svc := range host.Services {
+		if svc.Type == 3 && svc.Monitor == 1 && svc.Status != 0 {
+			host.FailedServices = append(host.FailedServices, svc.Name)
+		}
+	}
+	deps, err := dbpkg.GetServiceDependencies(db, host.ID)
+	if err != nil {
+		log.Printf("[ERROR] Failed to get service dependencies: %v", err)
+	}
+	host.Dependencies = buildDependencyTree(deps, host.Services)
+
 	return &DashboardData{
-		Hosts:      []HostWithServices{host},
-		LastUpdate: time.Now(),
-		AppVersion: appVersion,
+		Hosts:        []HostWithServices{host},
+		LastUpdate:   time.Now(),
+		AppVersion:   appVersion,
+		PublicStatus: publicStatusEnabled,
+		MonitCAFile:  monitCAFile,
 	}, nil
 }
 
@@ -462,7 +771,8 @@ func getEventsData(hostID string) (*EventsData, error) {
 
 	// Query events for this host (most recent first, limit to 100)
 	const eventsQuery = `
-		SELECT id, service_name, event_type, message, created_at
+		SELECT id, service_name, event_type, message, created_at,
+		       COALESCE(ack_by, ''), ack_at, COALESCE(ack_note, ''), severity, flap_count, COALESCE(source, '')
 		FROM events
 		WHERE host_id = ?
 		ORDER BY created_at DESC
@@ -486,12 +796,21 @@ func getEventsData(hostID string) (*EventsData, error) {
 			&event.EventType,
 			&event.Message,
 			&event.CreatedAt,
+			&event.AckBy,
+			&event.AckAt,
+			&event.AckNote,
+			&event.Severity,
+			&event.FlapCount,
+			&event.Source,
 		)
 		if err != nil {
 			return nil, err
 		}
 
 		event.EventTypeName = getEventTypeName(event.EventType)
+		if event.Source != "" {
+			event.EventTypeName = "External"
+		}
 
 		events = append(events, event)
 	}
@@ -500,6 +819,10 @@ func getEventsData(hostID string) (*EventsData, error) {
 		return nil, err
 	}
 
+	if err := attachEventComments(events); err != nil {
+		return nil, err
+	}
+
 	return &EventsData{
 		HostID:     hostID,
 		Hostname:   hostname,
@@ -513,10 +836,16 @@ func getEventsData(hostID string) (*EventsData, error) {
 func calculateHostStatus(hostStatus *HostStatus, services []Service) {
 	hostStatus.TotalServices = len(services)
 	hostStatus.FailedServices = 0
+	hostStatus.AckedServices = 0
 
-	// Count failed/warning services
+	// Count failed/warning services; acknowledged ones do not affect the color
 	for _, svc := range services {
-		if svc.Status != 0 { // Not OK
+		if svc.Status == 0 { // OK
+			continue
+		}
+		if svc.Acknowledged {
+			hostStatus.AckedServices++
+		} else {
 			hostStatus.FailedServices++
 		}
 	}
@@ -527,14 +856,20 @@ func calculateHostStatus(hostStatus *HostStatus, services []Service) {
 		hostStatus.StatusColor = "red"
 		hostStatus.StatusName = "Critical"
 		hostStatus.StatusDescription = fmt.Sprintf("No report from Monit. Last report was %s",
-			hostStatus.LastSeen.Format("02 Jan 2006 15:04:05 MST"))
+			displayTime(hostStatus.LastSeen, "02 Jan 2006 15:04:05 MST"))
 	} else if hostStatus.FailedServices > 0 {
 		// Orange: Some services are down
 		hostStatus.StatusColor = "orange"
 		hostStatus.StatusName = "Warning"
-		availableServices := hostStatus.TotalServices - hostStatus.FailedServices
+		availableServices := hostStatus.TotalServices - hostStatus.FailedServices - hostStatus.AckedServices
 		hostStatus.StatusDescription = fmt.Sprintf("%d out of %d services are available",
 			availableServices, hostStatus.TotalServices)
+	} else if hostStatus.AckedServices > 0 {
+		// Green: Remaining failures are all acknowledged
+		hostStatus.StatusColor = "green"
+		hostStatus.StatusName = "OK"
+		hostStatus.StatusDescription = fmt.Sprintf("%d out of %d services failing (acknowledged)",
+			hostStatus.AckedServices, hostStatus.TotalServices)
 	} else if hostStatus.TotalServices > 0 {
 		// Green: All services are OK
 		hostStatus.StatusColor = "green"
@@ -685,6 +1020,7 @@ func HandleServiceDetail(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 
+	data.Prefs = loadPreferences(r)
 	err = templates.ExecuteTemplate(w, "service.html", data)
 	if err != nil {
 		log.Printf("[ERROR] Failed to render template: %v", err)
@@ -695,7 +1031,8 @@ func HandleServiceDetail(w http.ResponseWriter, r *http.Request) {
 func getServiceDetailData(hostID, serviceName string) (*ServiceDetailData, error) {
 	// Query service information
 	const serviceQuery = `
-		SELECT name, type, status, monitor, pid, cpu_percent, memory_percent, memory_kb, collected_at
+		SELECT name, type, status, monitor, pid, cpu_percent, memory_percent, memory_kb, collected_at,
+		       every_type, every_cycles, every_cron
 		FROM services
 		WHERE host_id = ? AND name = ?
 		ORDER BY collected_at DESC
@@ -713,6 +1050,9 @@ func getServiceDetailData(hostID, serviceName string) (*ServiceDetailData, error
 		&svc.MemoryPercent,
 		&svc.MemoryKB,
 		&svc.CollectedAt,
+		&svc.EveryType,
+		&svc.EveryCycles,
+		&svc.EveryCron,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("service not found: %w", err)
@@ -722,12 +1062,14 @@ func getServiceDetailData(hostID, serviceName string) (*ServiceDetailData, error
 	svc.TypeName = getServiceTypeName(svc.Type)
 	svc.StatusName, svc.StatusColor = getServiceStatusInfo(svc.Status)
 
-	// Get hostname
+	// Get hostname and poll interval (for the check schedule)
 	var hostname string
-	err = db.QueryRow("SELECT hostname FROM hosts WHERE id = ?", hostID).Scan(&hostname)
+	pollInterval := 30
+	err = db.QueryRow("SELECT hostname, COALESCE(poll_interval, 30) FROM hosts WHERE id = ?", hostID).Scan(&hostname, &pollInterval)
 	if err != nil {
 		hostname = hostID // Fallback to host ID if hostname not found
 	}
+	setServiceSchedule(&svc, pollInterval)
 
 	data := &ServiceDetailData{
 		HostID:     hostID,
@@ -769,6 +1111,14 @@ func getServiceDetailData(hostID, serviceName string) (*ServiceDetailData, error
 		}
 	}
 
+	// Get directory or FIFO metrics (type 1 or 6)
+	if svc.Type == 1 || svc.Type == 6 {
+		data.PathData, err = getPathMetrics(hostID, serviceName)
+		if err != nil {
+			log.Printf("[WARN] Failed to get path metrics for %s/%s: %v", hostID, serviceName, err)
+		}
+	}
+
 	// Get system metrics if this is a system service (type 5)
 	if svc.Type == 5 {
 		data.SystemData, err = getSystemMetricsForService(hostID, serviceName)
@@ -812,7 +1162,9 @@ func getFilesystemMetrics(hostID, serviceName string) (*FilesystemMetrics, error
 		       block_percent, block_usage_mb, block_total_mb,
 		       inode_percent, inode_usage, inode_total,
 		       read_bytes_total, read_ops_total,
-		       write_bytes_total, write_ops_total
+		       write_bytes_total, write_ops_total,
+		       COALESCE(read_bytes_rate, 0), COALESCE(read_ops_rate, 0),
+		       COALESCE(write_bytes_rate, 0), COALESCE(write_ops_rate, 0)
 		FROM filesystem_metrics
 		WHERE host_id = ? AND service_name = ?
 		ORDER BY collected_at DESC
@@ -839,6 +1191,10 @@ func getFilesystemMetrics(hostID, serviceName string) (*FilesystemMetrics, error
 		&fm.ReadOpsTotal,
 		&fm.WriteBytesTotal,
 		&fm.WriteOpsTotal,
+		&fm.ReadBytesRate,
+		&fm.ReadOpsRate,
+		&fm.WriteBytesRate,
+		&fm.WriteOpsRate,
 	)
 	if err != nil {
 		return nil, err
@@ -905,6 +1261,50 @@ func getNetworkMetrics(hostID, serviceName string) (*NetworkMetrics, error) {
 	return &nm, nil
 }
 
+// getPathMetrics retrieves the latest directory or FIFO metrics for a
+// service.
+func getPathMetrics(hostID, serviceName string) (*PathMetrics, error) {
+	const query = `
+		SELECT mode, uid, gid, access_time, change_time, modify_time
+		FROM path_metrics
+		WHERE host_id = ? AND service_name = ?
+		ORDER BY collected_at DESC
+		LIMIT 1
+	`
+
+	var pm PathMetrics
+	var mode sql.NullString
+	var uid, gid, accessTime, changeTime, modifyTime sql.NullInt64
+
+	err := db.QueryRow(query, hostID, serviceName).Scan(
+		&mode,
+		&uid,
+		&gid,
+		&accessTime,
+		&changeTime,
+		&modifyTime,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	pm.Mode = mode.String
+	pm.UID = int(uid.Int64)
+	pm.GID = int(gid.Int64)
+	// Monit reports 0 for a timestamp it could not read
+	if accessTime.Int64 > 0 {
+		pm.AccessTime = &accessTime.Int64
+	}
+	if changeTime.Int64 > 0 {
+		pm.ChangeTime = &changeTime.Int64
+	}
+	if modifyTime.Int64 > 0 {
+		pm.ModifyTime = &modifyTime.Int64
+	}
+
+	return &pm, nil
+}
+
 // getFileMetrics retrieves the latest file metrics for a service.
 func getFileMetrics(hostID, serviceName string) (*FileMetrics, error) {
 	const query = `
@@ -972,10 +1372,12 @@ func getFileMetrics(hostID, serviceName string) (*FileMetrics, error) {
 	return &fm, nil
 }
 
-// getProgramMetrics retrieves the latest program metrics for a service.
+// getProgramMetrics retrieves the latest program metrics for a service, with
+// the whole output when it was truncated and kept ([storage]
+// program_full_output).
 func getProgramMetrics(hostID, serviceName string) (*ProgramMetrics, error) {
 	const query = `
-		SELECT started, exit_status, output
+		SELECT started, exit_status, COALESCE(full_output, output)
 		FROM program_metrics
 		WHERE host_id = ? AND service_name = ?
 		ORDER BY collected_at DESC
@@ -1107,7 +1509,9 @@ func getSystemMetricsForService(hostID, serviceName string) (*SystemMetrics, err
 	return sm, nil
 }
 
-// getRemoteHostMetrics retrieves the latest remote host metrics for a service.
+// getRemoteHostMetrics retrieves the latest remote host metrics for a service:
+// the checks of its last collection, each stored in its own row (rows stored
+// before schema v35 hold the first check of each kind together).
 func getRemoteHostMetrics(hostID, serviceName string) (*RemoteHostMetrics, error) {
 	const query = `
 		SELECT icmp_type, icmp_responsetime,
@@ -1115,69 +1519,73 @@ func getRemoteHostMetrics(hostID, serviceName string) (*RemoteHostMetrics, error
 		       unix_path, unix_protocol, unix_responsetime
 		FROM remote_host_metrics
 		WHERE host_id = ? AND service_name = ?
-		ORDER BY collected_at DESC
-		LIMIT 1
+		  AND collected_at = (SELECT MAX(collected_at) FROM remote_host_metrics
+		                      WHERE host_id = ? AND service_name = ?)
+		ORDER BY check_index, id
 	`
 
-	var rhm RemoteHostMetrics
-	var icmpType, portHostname, portProtocol, portType, unixPath, unixProtocol sql.NullString
-	var portNumber sql.NullInt64
-	var icmpResponsetime, portResponsetime, unixResponsetime sql.NullFloat64
-
-	err := db.QueryRow(query, hostID, serviceName).Scan(
-		&icmpType,
-		&icmpResponsetime,
-		&portHostname,
-		&portNumber,
-		&portProtocol,
-		&portType,
-		&portResponsetime,
-		&unixPath,
-		&unixProtocol,
-		&unixResponsetime,
-	)
+	rows, err := db.Query(query, hostID, serviceName, hostID, serviceName)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			// No metrics found is not an error - return nil
-			return nil, nil
-		}
 		return nil, err
 	}
+	defer rows.Close()
 
-	// Convert nullable fields and response times from seconds to milliseconds
-	if icmpType.Valid {
-		rhm.ICMPType = icmpType.String
-	}
-	if icmpResponsetime.Valid {
-		rhm.ICMPResponseTimeMs = icmpResponsetime.Float64 * 1000
-	}
+	var rhm RemoteHostMetrics
+	found := false
+	for rows.Next() {
+		var icmpType, portHostname, portProtocol, portType, unixPath, unixProtocol sql.NullString
+		var portNumber sql.NullInt64
+		var icmpResponsetime, portResponsetime, unixResponsetime sql.NullFloat64
 
-	if portHostname.Valid {
-		rhm.PortHostname = portHostname.String
-	}
-	if portNumber.Valid {
-		rhm.PortNumber = int(portNumber.Int64)
-	}
-	if portProtocol.Valid {
-		rhm.PortProtocol = portProtocol.String
-	}
-	if portType.Valid {
-		rhm.PortType = portType.String
+		err := rows.Scan(
+			&icmpType,
+			&icmpResponsetime,
+			&portHostname,
+			&portNumber,
+			&portProtocol,
+			&portType,
+			&portResponsetime,
+			&unixPath,
+			&unixProtocol,
+			&unixResponsetime,
+		)
+		if err != nil {
+			return nil, err
+		}
+		found = true
+
+		// Convert nullable fields and response times from seconds to milliseconds
+		if icmpType.Valid {
+			rhm.ICMP = append(rhm.ICMP, ICMPCheck{
+				Type:           icmpType.String,
+				ResponseTimeMs: icmpResponsetime.Float64 * 1000,
+			})
+		}
+		if portHostname.Valid || portNumber.Valid {
+			rhm.Ports = append(rhm.Ports, PortCheck{
+				Hostname:       portHostname.String,
+				Number:         int(portNumber.Int64),
+				Protocol:       portProtocol.String,
+				Type:           portType.String,
+				ResponseTimeMs: portResponsetime.Float64 * 1000,
+			})
+		}
+		if unixPath.Valid {
+			rhm.Unix = append(rhm.Unix, UnixCheck{
+				Path:           unixPath.String,
+				Protocol:       unixProtocol.String,
+				ResponseTimeMs: unixResponsetime.Float64 * 1000,
+			})
+		}
 	}
-	if portResponsetime.Valid {
-		rhm.PortResponseTimeMs = portResponsetime.Float64 * 1000
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
 
-	if unixPath.Valid {
-		rhm.UnixPath = unixPath.String
-	}
-	if unixProtocol.Valid {
-		rhm.UnixProtocol = unixProtocol.String
-	}
-	if unixResponsetime.Valid {
-		rhm.UnixResponseTimeMs = unixResponsetime.Float64 * 1000
+	if !found {
+		// No metrics found is not an error - return nil
+		return nil, nil
 	}
-
 	return &rhm, nil
 }
 
@@ -1306,8 +1714,7 @@ func getAvailabilityData(hostID string, hours int) (*AvailabilityResponse, error
 		}
 
 		// Format timestamp as human-readable label
-		t := time.Unix(timestamp, 0)
-		label := t.Format("Jan 2 15:04")
+		label := displayTime(time.Unix(timestamp, 0), "Jan 2 15:04")
 
 		datapoints = append(datapoints, AvailabilityDatapoint{
 			Timestamp: timestamp,
@@ -1327,12 +1734,14 @@ func getAvailabilityData(hostID string, hours int) (*AvailabilityResponse, error
 	}, nil
 }
 
-// getAllHostGroups returns all unique hostgroup names for the filter dropdown.
+// getAllHostGroups returns all unique hostgroup and service group names
+// for the filter dropdown.
 func getAllHostGroups() ([]string, error) {
 	const query = `
-		SELECT name
-		FROM hostgroups
-		ORDER BY name ASC
+		SELECT name FROM hostgroups
+		UNION
+		SELECT group_name FROM service_groups
+		ORDER BY 1 ASC
 	`
 
 	rows, err := db.Query(query)
@@ -1352,3 +1761,30 @@ func getAllHostGroups() ([]string, error) {
 
 	return groups, rows.Err()
 }
+
+// getAllOSNames returns all distinct host OS names for the filter dropdown.
+func getAllOSNames() ([]string, error) {
+	const query = `
+		SELECT DISTINCT os_name
+		FROM hosts
+		WHERE os_name IS NOT NULL AND os_name != '' AND archived_at IS NULL
+		ORDER BY os_name ASC
+	`
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+
+	return names, rows.Err()
+}
@@ -3,6 +3,8 @@ package web
 import (
 	"fmt"
 	"time"
+
+	"github.com/ocochard/cmonit/internal/parser"
 )
 
 // HostHealthStatus represents the health status of a host based on heartbeat
@@ -144,3 +146,26 @@ func CanDeleteHost(lastSeen int64) bool {
 	oneHour := int64(3600)
 	return secondsSince >= oneHour
 }
+
+// ServiceCheckInterval returns how often Monit checks a service: the poll
+// interval of the host for a service checked every cycle, N times that for
+// "every N cycles", and 0 for cron schedules, which have no fixed interval.
+func ServiceCheckInterval(pollInterval, everyType, everyCycles int) time.Duration {
+	switch everyType {
+	case parser.EveryCron, parser.EveryNotInCron:
+		return 0
+	}
+	if everyCycles < 1 {
+		everyCycles = 1
+	}
+	return time.Duration(pollInterval*everyCycles) * time.Second
+}
+
+// setServiceSchedule sets the check interval of a service from the poll
+// interval of its host, and whether its next report is overdue: not
+// collected for twice its interval.
+func setServiceSchedule(svc *Service, pollInterval int) {
+	svc.CheckInterval = ServiceCheckInterval(pollInterval, svc.EveryType, svc.EveryCycles)
+	svc.Overdue = svc.CheckInterval > 0 && !svc.CollectedAt.IsZero() &&
+		time.Since(svc.CollectedAt) > 2*svc.CheckInterval
+}
@@ -0,0 +1,170 @@
+package web
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Cross-host incident grouping defaults and limits.
+const (
+	defaultGroupWindow = 5 * time.Minute // Incidents page without group_window
+	maxGroupWindow     = 24 * time.Hour
+	minGroupHosts      = 2 // Hosts failing together making a group
+)
+
+// IncidentGroup gathers the incidents of the hosts of a hostgroup failing
+// at about the same time, such as a network outage taking a whole site
+// down: each incident starts within the grouping window of the previous
+// one.
+type IncidentGroup struct {
+	Hostgroup string     `json:"hostgroup"`
+	Start     time.Time  `json:"start"`         // First failure
+	End       *time.Time `json:"end,omitempty"` // Last recovery, nil while an incident is ongoing
+	Hosts     int        `json:"hosts"`
+	Incidents []Incident `json:"incidents"` // Newest first
+	Severity  string     `json:"severity"`  // Highest severity of the incidents
+	Summary   string     `json:"summary"`   // "12 hosts of web failed within 1m 30s"
+}
+
+// parseGroupWindow parses the group_window parameter, a Go duration;
+// "0" disables the grouping and an empty value is def.
+func parseGroupWindow(value string, def time.Duration) (time.Duration, string) {
+	if value == "" {
+		return def, ""
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 || d > maxGroupWindow {
+		return 0, "Invalid group window (a duration up to 24h, e.g. 5m, or 0): " + value
+	}
+	return d, ""
+}
+
+// groupIncidents gathers the incidents, newest first, of the hosts sharing
+// a hostgroup (hostgroups maps the host ids to their hostgroups) and
+// starting within window of each other, when they span minGroupHosts
+// hosts or more. It returns the groups, newest first, and the incidents
+// in none, in their order.
+//
+// An incident is in a single group: the groups spanning the most hosts
+// are formed first.
+func groupIncidents(incidents []Incident, hostgroups map[string][]string, window time.Duration) ([]IncidentGroup, []Incident) {
+	if window <= 0 {
+		return nil, incidents
+	}
+
+	// Incidents of each hostgroup, oldest first
+	members := map[string][]int{}
+	for i := len(incidents) - 1; i >= 0; i-- {
+		for _, name := range hostgroups[incidents[i].HostID] {
+			members[name] = append(members[name], i)
+		}
+	}
+	for _, list := range members {
+		sort.SliceStable(list, func(a, b int) bool { return incidents[list[a]].Start.Before(incidents[list[b]].Start) })
+	}
+	names := make([]string, 0, len(members))
+	for name := range members {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	grouped := make([]bool, len(incidents))
+	var groups []IncidentGroup
+	for {
+		// The cluster of ungrouped incidents spanning the most hosts
+		var best []int
+		var bestName string
+		bestHosts := minGroupHosts - 1
+		for _, name := range names {
+			var cluster []int
+			flush := func() {
+				if hosts := countHosts(incidents, cluster); hosts > bestHosts {
+					best, bestName, bestHosts = cluster, name, hosts
+				}
+			}
+			for _, i := range members[name] {
+				if grouped[i] {
+					continue
+				}
+				if len(cluster) > 0 && incidents[i].Start.Sub(incidents[cluster[len(cluster)-1]].Start) > window {
+					flush()
+					cluster = nil
+				}
+				cluster = append(cluster, i)
+			}
+			flush()
+		}
+		if best == nil {
+			break
+		}
+
+		sort.Ints(best) // Newest first, as incidents
+		group := IncidentGroup{Hostgroup: bestName, Hosts: bestHosts}
+		ongoing := false
+		for _, i := range best {
+			grouped[i] = true
+			incident := incidents[i]
+			group.Incidents = append(group.Incidents, incident)
+			if group.Start.IsZero() || incident.Start.Before(group.Start) {
+				group.Start = incident.Start
+			}
+			if incident.End == nil {
+				ongoing = true
+			} else if group.End == nil || incident.End.After(*group.End) {
+				group.End = incident.End
+			}
+			if severityRank(incident.Severity) > severityRank(group.Severity) {
+				group.Severity = incident.Severity
+			}
+		}
+		if ongoing {
+			group.End = nil
+		}
+		last := group.Incidents[0].Start // Newest
+		group.Summary = fmt.Sprintf("%d hosts of %s failed within %s", group.Hosts, group.Hostgroup,
+			formatIncidentDuration(int64(last.Sub(group.Start)/time.Second)))
+		groups = append(groups, group)
+	}
+
+	sort.SliceStable(groups, func(a, b int) bool { return groups[a].Start.After(groups[b].Start) })
+	rest := []Incident{}
+	for i, incident := range incidents {
+		if !grouped[i] {
+			rest = append(rest, incident)
+		}
+	}
+	return groups, rest
+}
+
+// countHosts returns the number of hosts of the incidents at indexes.
+func countHosts(incidents []Incident, indexes []int) int {
+	hosts := map[string]bool{}
+	for _, i := range indexes {
+		hosts[incidents[i].HostID] = true
+	}
+	return len(hosts)
+}
+
+// getHostgroupsByHost returns the hostgroups of each host, by host id.
+func getHostgroupsByHost() (map[string][]string, error) {
+	rows, err := db.Query(`
+		SELECT hhg.host_id, hg.name
+		FROM host_hostgroups hhg
+		JOIN hostgroups hg ON hg.id = hhg.hostgroup_id
+		ORDER BY hg.name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hostgroups := map[string][]string{}
+	for rows.Next() {
+		var hostID, name string
+		if err := rows.Scan(&hostID, &name); err != nil {
+			return nil, err
+		}
+		hostgroups[hostID] = append(hostgroups[hostID], name)
+	}
+	return hostgroups, rows.Err()
+}
@@ -0,0 +1,317 @@
+package web
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	dbpkg "github.com/ocochard/cmonit/internal/db"
+)
+
+// Incidents page defaults and limits.
+const (
+	defaultIncidentDays = 7   // Events scanned without a from filter
+	defaultIncidents    = 100 // Incidents returned without a limit
+	maxIncidents        = 1000
+)
+
+// Incident pairs the failures of a service with its recovery: it starts
+// with the first failure event and ends with the success event clearing
+// the last failed check. End is nil while the service is still failing.
+type Incident struct {
+	HostID   string     `json:"host_id"`
+	Hostname string     `json:"hostname"`
+	Service  string     `json:"service"`
+	Start    time.Time  `json:"start"`            // First failure event
+	End      *time.Time `json:"end,omitempty"`    // Recovery event, nil = ongoing
+	Duration int64      `json:"duration_seconds"` // Until End, or until now when ongoing
+	Events   int        `json:"events"`           // Failure and recovery events paired
+	Message  string     `json:"message"`          // Message of the first failure
+	Severity string     `json:"severity"`         // Highest severity of the failures
+	Summary  string     `json:"summary"`          // "nginx was down for 7m 32s"
+}
+
+// IncidentsResponse is the JSON response for the incidents API.
+type IncidentsResponse struct {
+	Incidents []Incident      `json:"incidents"`        // Not in a group
+	Groups    []IncidentGroup `json:"groups,omitempty"` // With group_window
+	From      time.Time       `json:"from"`             // Start of the events scanned
+}
+
+// IncidentsData holds data for the incidents page.
+type IncidentsData struct {
+	Incidents   []Incident      // Not in a group, newest first
+	Groups      []IncidentGroup // Hosts of a hostgroup failing together, newest first
+	GroupWindow string          // "5m", "0" without grouping
+	Query       EventsQuery     // Host, service and date filters
+	Hosts       []HostOption
+	LastUpdate  time.Time
+	AppVersion  string
+	Prefs       Preferences
+	FilterError string
+}
+
+// incidentEvent is a failure (state 1) or success (state 0) event read by
+// getIncidents.
+type incidentEvent struct {
+	HostID, Hostname, Service string
+	EventType, State          int
+	Message, Severity         string
+	CreatedAt                 time.Time
+}
+
+// pairIncidents turns the events, oldest first, into incidents, newest
+// first. The event types of the failures of a service are accumulated
+// until success events clear them all; a success event clearing no
+// failed check, such as a recovery whose failure is before the events
+// read, is ignored. Ongoing incidents last until now.
+func pairIncidents(events []incidentEvent, now time.Time) []Incident {
+	type open struct {
+		incident *Incident
+		failing  int // Event type bits still failing
+	}
+	var incidents []*Incident
+	opened := map[string]*open{}
+
+	for _, e := range events {
+		key := e.HostID + "\x00" + e.Service
+		o := opened[key]
+		switch {
+		case e.State == 1 && o == nil:
+			incident := &Incident{
+				HostID:   e.HostID,
+				Hostname: e.Hostname,
+				Service:  e.Service,
+				Start:    e.CreatedAt,
+				Events:   1,
+				Message:  e.Message,
+				Severity: e.Severity,
+			}
+			incidents = append(incidents, incident)
+			opened[key] = &open{incident: incident, failing: e.EventType}
+		case e.State == 1:
+			o.incident.Events++
+			o.failing |= e.EventType
+			if severityRank(e.Severity) > severityRank(o.incident.Severity) {
+				o.incident.Severity = e.Severity
+			}
+		case e.State == 0 && o != nil && o.failing&e.EventType != 0:
+			o.incident.Events++
+			o.failing &^= e.EventType
+			if o.failing == 0 {
+				end := e.CreatedAt
+				o.incident.End = &end
+				delete(opened, key)
+			}
+		}
+	}
+
+	result := make([]Incident, 0, len(incidents))
+	for i := len(incidents) - 1; i >= 0; i-- {
+		incident := *incidents[i]
+		end := now
+		if incident.End != nil {
+			end = *incident.End
+		}
+		incident.Duration = int64(end.Sub(incident.Start) / time.Second)
+		if incident.End != nil {
+			incident.Summary = fmt.Sprintf("%s was down for %s", incident.Service, formatIncidentDuration(incident.Duration))
+		} else {
+			incident.Summary = fmt.Sprintf("%s has been down for %s", incident.Service, formatIncidentDuration(incident.Duration))
+		}
+		result = append(result, incident)
+	}
+	return result
+}
+
+// severityRank orders the severities, -1 for an unknown one.
+func severityRank(severity string) int {
+	return slices.Index(dbpkg.Severities, severity)
+}
+
+// formatIncidentDuration formats seconds as "7m 32s", "2h 5m" or "3d 4h".
+func formatIncidentDuration(seconds int64) string {
+	if seconds < 0 {
+		seconds = 0
+	}
+	days, hours, minutes := seconds/86400, seconds%86400/3600, seconds%3600/60
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%dd %dh", days, hours)
+	case hours > 0:
+		return fmt.Sprintf("%dh %dm", hours, minutes)
+	case minutes > 0:
+		return fmt.Sprintf("%dm %ds", minutes, seconds%60)
+	}
+	return fmt.Sprintf("%ds", seconds)
+}
+
+// getIncidents pairs the failure and success events matching the host,
+// service and date filters of q into incidents, newest first. Without a
+// from date, the events of the last defaultIncidentDays days are read;
+// the from date used is returned.
+func getIncidents(q EventsQuery, limit int) ([]Incident, time.Time, error) {
+	from := q.from
+	if from.IsZero() {
+		from = time.Now().AddDate(0, 0, -defaultIncidentDays)
+	}
+
+	// created_at is stored as text in the server's local time
+	where := " WHERE e.state IN (0, 1) AND e.created_at >= ?"
+	args := []interface{}{from.In(time.Local)}
+	if q.HostID != "" {
+		where += " AND e.host_id = ?"
+		args = append(args, q.HostID)
+	}
+	if q.Service != "" {
+		where += " AND e.service_name = ?"
+		args = append(args, q.Service)
+	}
+	if !q.to.IsZero() {
+		where += " AND e.created_at <= ?"
+		args = append(args, q.to.In(time.Local))
+	}
+
+	rows, err := db.Query(`
+		SELECT e.host_id, h.hostname, e.service_name, COALESCE(e.event_type, 0), e.state,
+		       COALESCE(e.message, ''), e.severity, e.created_at
+		FROM events e
+		JOIN hosts h ON h.id = e.host_id`+where+`
+		ORDER BY e.created_at, e.id`, args...)
+	if err != nil {
+		return nil, from, err
+	}
+	defer rows.Close()
+
+	var events []incidentEvent
+	for rows.Next() {
+		var e incidentEvent
+		if err := rows.Scan(&e.HostID, &e.Hostname, &e.Service, &e.EventType, &e.State,
+			&e.Message, &e.Severity, &e.CreatedAt); err != nil {
+			return nil, from, err
+		}
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, from, err
+	}
+
+	incidents := pairIncidents(events, time.Now())
+	if len(incidents) > limit {
+		incidents = incidents[:limit]
+	}
+	return incidents, from, nil
+}
+
+// HandleIncidentsAPI returns the incidents of the services, newest first.
+//
+// GET /api/v1/incidents?host=&service=&from=&to=&limit=&group_window=
+//
+// Takes the host, service and date filters of the events API; from
+// defaults to 7 days ago, and an incident whose failure is before it is
+// not reported. With group_window, the incidents of the hosts of a
+// hostgroup starting within it of each other are returned in groups.
+func HandleIncidentsAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q, filterErr := parseEventsQuery(r, loadPreferences(r).Location())
+	if filterErr != "" {
+		respondJSON(w, map[string]string{"error": filterErr}, http.StatusBadRequest)
+		return
+	}
+	window, filterErr := parseGroupWindow(r.URL.Query().Get("group_window"), 0)
+	if filterErr != "" {
+		respondJSON(w, map[string]string{"error": filterErr}, http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultIncidents
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 {
+		limit = min(n, maxIncidents)
+	}
+
+	incidents, from, err := getIncidents(q, limit)
+	if err != nil {
+		log.Printf("[ERROR] Failed to get incidents: %v", err)
+		respondJSON(w, map[string]string{"error": "Failed to get incidents"}, http.StatusInternalServerError)
+		return
+	}
+
+	response := IncidentsResponse{Incidents: incidents, From: from}
+	if window > 0 {
+		hostgroups, err := getHostgroupsByHost()
+		if err != nil {
+			log.Printf("[ERROR] Failed to get hostgroups for incidents: %v", err)
+			respondJSON(w, map[string]string{"error": "Failed to get incidents"}, http.StatusInternalServerError)
+			return
+		}
+		response.Groups, response.Incidents = groupIncidents(incidents, hostgroups, window)
+	}
+
+	respondJSON(w, response, http.StatusOK)
+}
+
+// HandleIncidents serves the incidents page.
+//
+// GET /incidents?host=&service=&from=&to=&group_window=
+//
+// The incidents of the hosts of a hostgroup starting within 5 minutes of
+// each other are shown as a group, unless group_window says otherwise.
+func HandleIncidents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	prefs := loadPreferences(r)
+	q, filterErr := parseEventsQuery(r, prefs.Location())
+	window, windowErr := parseGroupWindow(r.URL.Query().Get("group_window"), defaultGroupWindow)
+	if filterErr == "" {
+		filterErr = windowErr
+	}
+
+	data := IncidentsData{
+		Incidents:   []Incident{},
+		GroupWindow: window.String(),
+		Query:       q,
+		LastUpdate:  time.Now(),
+		AppVersion:  appVersion,
+		Prefs:       prefs,
+		FilterError: filterErr,
+	}
+
+	if filterErr == "" {
+		incidents, _, err := getIncidents(q, maxIncidents)
+		if err != nil {
+			log.Printf("[ERROR] Failed to get incidents: %v", err)
+			http.Error(w, "Failed to load incidents", http.StatusInternalServerError)
+			return
+		}
+		data.Incidents = incidents
+		if window > 0 {
+			hostgroups, err := getHostgroupsByHost()
+			if err != nil {
+				log.Printf("[ERROR] Failed to get hostgroups for incidents: %v", err)
+			}
+			data.Groups, data.Incidents = groupIncidents(incidents, hostgroups, window)
+		}
+	}
+
+	var err error
+	if data.Hosts, err = getHostOptions(); err != nil {
+		log.Printf("[ERROR] Failed to get hosts for incidents filter: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	err = templates.ExecuteTemplate(w, "incidents.html", data)
+	if err != nil {
+		log.Printf("[ERROR] Failed to render template: %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package web
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPairIncidents(t *testing.T) {
+	start := time.Date(2026, 10, 14, 9, 12, 5, 0, time.UTC)
+	at := func(seconds int) time.Time { return start.Add(time.Duration(seconds) * time.Second) }
+	event := func(host, service string, eventType, state, seconds int, severity string) incidentEvent {
+		return incidentEvent{HostID: host, Hostname: host, Service: service, EventType: eventType,
+			State: state, Message: service + " event", Severity: severity, CreatedAt: at(seconds)}
+	}
+
+	events := []incidentEvent{
+		event("a", "nginx", 0x20, 0, 0, "info"),           // Recovery without failure: ignored
+		event("a", "nginx", 0x20, 1, 0, "warning"),        // connection failed
+		event("a", "nginx", 0x2, 1, 60, "critical"),       // resource failed too
+		event("b", "nginx", 0x200, 1, 100, "critical"),    // Other host, never recovers
+		event("a", "nginx", 0x20, 0, 120, "info"),         // connection back, resource still failing
+		event("a", "nginx", 0x4000, 0, 200, "info"),       // Unrelated check: ignored
+		event("a", "nginx", 0x2, 0, 452, "info"),          // resource back: end
+		event("a", "nginx", 0x20, 1, 1000, "warning"),     // New incident
+		event("a", "nginx", 0x20, 0, 1000+3*3600, "info"), // 3 hours later
+	}
+	incidents := pairIncidents(events, at(2000))
+
+	if len(incidents) != 3 {
+		t.Fatalf("got %d incidents, want 3: %+v", len(incidents), incidents)
+	}
+
+	// Newest first
+	last, ongoing, first := incidents[0], incidents[1], incidents[2]
+
+	if first.HostID != "a" || !first.Start.Equal(at(0)) || first.End == nil || !first.End.Equal(at(452)) {
+		t.Errorf("first incident = %+v, want a from 0s to 452s", first)
+	}
+	if first.Duration != 452 || first.Events != 4 || first.Severity != "critical" {
+		t.Errorf("first incident duration %d, events %d, severity %s, want 452, 4, critical", first.Duration, first.Events, first.Severity)
+	}
+	if first.Summary != "nginx was down for 7m 32s" {
+		t.Errorf("first incident summary = %q", first.Summary)
+	}
+
+	if ongoing.HostID != "b" || ongoing.End != nil || ongoing.Duration != 1900 {
+		t.Errorf("ongoing incident = %+v, want b lasting 1900s", ongoing)
+	}
+	if ongoing.Summary != "nginx has been down for 31m 40s" {
+		t.Errorf("ongoing incident summary = %q", ongoing.Summary)
+	}
+
+	if last.Summary != "nginx was down for 3h 0m" {
+		t.Errorf("last incident summary = %q", last.Summary)
+	}
+}
+
+func TestFormatIncidentDuration(t *testing.T) {
+	tests := []struct {
+		seconds int64
+		want    string
+	}{
+		{-5, "0s"},
+		{42, "42s"},
+		{452, "7m 32s"},
+		{7500, "2h 5m"},
+		{3*86400 + 4*3600 + 59, "3d 4h"},
+	}
+	for _, tt := range tests {
+		if got := formatIncidentDuration(tt.seconds); got != tt.want {
+			t.Errorf("formatIncidentDuration(%d) = %q, want %q", tt.seconds, got, tt.want)
+		}
+	}
+}
+
+func TestGroupIncidents(t *testing.T) {
+	start := time.Date(2026, 10, 14, 9, 0, 0, 0, time.UTC)
+	incident := func(host string, minutes int, recovered bool) Incident {
+		i := Incident{HostID: host, Hostname: host, Service: "ping", Start: start.Add(time.Duration(minutes) * time.Minute), Severity: "warning"}
+		if recovered {
+			end := i.Start.Add(time.Hour)
+			i.End = &end
+		}
+		return i
+	}
+	// Newest first, as pairIncidents returns them
+	incidents := []Incident{
+		incident("d", 120, true), // Alone two hours later
+		incident("c", 6, false),  // Within 5 minutes of b
+		incident("b", 2, true),
+		incident("a", 0, true),
+		incident("x", 1, true), // Not in the hostgroup
+	}
+	hostgroups := map[string][]string{"a": {"paris"}, "b": {"paris"}, "c": {"paris"}, "d": {"paris"}}
+
+	groups, rest := groupIncidents(incidents, hostgroups, 5*time.Minute)
+	if len(groups) != 1 || len(rest) != 2 || rest[0].HostID != "d" || rest[1].HostID != "x" {
+		t.Fatalf("got %d groups, rest %+v; want 1 group, d and x", len(groups), rest)
+	}
+	g := groups[0]
+	if g.Hostgroup != "paris" || g.Hosts != 3 || !g.Start.Equal(start) || g.End != nil || len(g.Incidents) != 3 || g.Incidents[0].HostID != "c" {
+		t.Errorf("group = %+v, want a, b and c of paris, ongoing", g)
+	}
+	if g.Summary != "3 hosts of paris failed within 6m 0s" {
+		t.Errorf("group summary = %q", g.Summary)
+	}
+
+	// Too short a window to chain b to c
+	if groups, _ = groupIncidents(incidents, hostgroups, 3*time.Minute); len(groups) != 1 || groups[0].Hosts != 2 || groups[0].End == nil {
+		t.Errorf("3m window: groups = %+v, want a and b", groups)
+	}
+	if groups, rest = groupIncidents(incidents, hostgroups, 0); groups != nil || len(rest) != len(incidents) {
+		t.Errorf("no window: got %d groups", len(groups))
+	}
+}
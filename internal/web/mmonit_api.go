@@ -9,6 +9,7 @@ package web
 import (
 	"database/sql" //SQL database
 	"encoding/json" // JSON encoding/decoding
+	"fmt"           // String formatting
 	"log"           // Logging
 	"net/http"      // HTTP server
 	"strings"       // String manipulation
@@ -40,18 +41,29 @@ type MMHostSummary struct {
 //
 // This is returned by GET /status/hosts/:id
 type MMHostDetail struct {
-	ID              string           `json:"id"`
-	Hostname        string           `json:"hostname"`
-	Status          int              `json:"status"`
-	Platform        string           `json:"platform"`
-	PlatformVersion string           `json:"platformversion,omitempty"`
-	CPUCount        int              `json:"cpucount,omitempty"`
-	Memory          int64            `json:"memory,omitempty"` // Total memory in bytes
-	Uptime          int64            `json:"uptime,omitempty"` // System uptime in seconds
-	MonitUptime     int64            `json:"monituptime"`
-	MonitVersion    string           `json:"monitversion,omitempty"`
-	LastSeen        string           `json:"lastseen"`
-	Services        []MMServiceBrief `json:"services,omitempty"`
+	ID              string            `json:"id"`
+	Hostname        string            `json:"hostname"`
+	Status          int               `json:"status"`
+	Platform        string            `json:"platform"`
+	PlatformVersion string            `json:"platformversion,omitempty"`
+	CPUCount        int               `json:"cpucount,omitempty"`
+	Memory          int64             `json:"memory,omitempty"` // Total memory in bytes
+	Uptime          int64             `json:"uptime,omitempty"` // System uptime in seconds
+	MonitUptime     int64             `json:"monituptime"`
+	MonitVersion    string            `json:"monitversion,omitempty"`
+	LastSeen        string            `json:"lastseen"`
+	Services        []MMServiceBrief  `json:"services,omitempty"`
+	Connection      *MMHostConnection `json:"connection,omitempty"` // Only for admins, see canSeeConnections
+}
+
+// MMHostConnection describes how cmonit reaches the Monit HTTP interface of
+// a host. It deliberately has no password field: Monit credentials are
+// never returned by the API.
+type MMHostConnection struct {
+	Address  string `json:"address"`
+	Port     int    `json:"port"`
+	SSL      bool   `json:"ssl"`
+	Username string `json:"username,omitempty"`
 }
 
 // MMServiceBrief represents a service in brief format for host detail view.
@@ -162,7 +174,7 @@ func HandleMMStatusHosts(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	hosts, err := getMMHostsSummary()
+	hosts, err := getMMHostsSummary(false)
 	if err != nil {
 		log.Printf("[ERROR] Failed to get hosts summary: %v", err)
 		respondMMError(w, "Failed to retrieve hosts", http.StatusInternalServerError)
@@ -204,6 +216,12 @@ func HandleMMStatusHost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if canSeeConnections(r) {
+		if host.Connection, err = getMMHostConnection(hostID); err != nil {
+			log.Printf("[ERROR] Failed to get host connection: %v", err)
+		}
+	}
+
 	respondJSON(w, host, http.StatusOK)
 }
 
@@ -347,7 +365,7 @@ func HandleMMAdminHosts(w http.ResponseWriter, r *http.Request) {
 //
 // GET /admin/hosts
 func handleMMAdminHostsList(w http.ResponseWriter, r *http.Request) {
-	hosts, err := getMMHostsSummary()
+	hosts, err := getMMHostsSummary(true)
 	if err != nil {
 		log.Printf("[ERROR] Failed to get hosts: %v", err)
 		respondMMError(w, "Failed to retrieve hosts", http.StatusInternalServerError)
@@ -372,6 +390,7 @@ func handleMMAdminHostsList(w http.ResponseWriter, r *http.Request) {
 // - filesystem_metrics
 // - network_metrics
 // - file_metrics
+// - path_metrics
 // - program_metrics
 // - events
 //
@@ -383,6 +402,8 @@ func handleMMAdminHostDelete(w http.ResponseWriter, r *http.Request, hostID stri
 	// dbpkg is the imported db package, db is the *sql.DB connection
 	stats, err := dbpkg.DeleteHost(db, hostID)
 	if err != nil {
+		auditRequest(r, dbpkg.AuditHostDelete, hostID, err.Error(), false)
+
 		// Check specific error types
 		if strings.Contains(err.Error(), "host not found") {
 			respondMMError(w, err.Error(), http.StatusNotFound)
@@ -410,6 +431,7 @@ func handleMMAdminHostDelete(w http.ResponseWriter, r *http.Request, hostID stri
 			"filesystem_metrics":  stats.FilesystemMetrics,
 			"network_metrics":     stats.NetworkMetrics,
 			"file_metrics":        stats.FileMetrics,
+			"path_metrics":        stats.PathMetrics,
 			"program_metrics":     stats.ProgramMetrics,
 			"events":              stats.Events,
 		},
@@ -417,6 +439,8 @@ func handleMMAdminHostDelete(w http.ResponseWriter, r *http.Request, hostID stri
 
 	log.Printf("[INFO] Successfully deleted host %s: %d services, %d metrics, %d events",
 		hostID, stats.Services, stats.Metrics, stats.Events)
+	auditRequest(r, dbpkg.AuditHostDelete, hostID, fmt.Sprintf("%d services, %d events removed", stats.Services, stats.Events), true)
+	monitClients.Remove(hostID)
 
 	respondJSON(w, response, http.StatusOK)
 }
@@ -425,16 +449,18 @@ func handleMMAdminHostDelete(w http.ResponseWriter, r *http.Request, hostID stri
 // DATABASE QUERY FUNCTIONS
 // =============================================================================
 
-// getMMHostsSummary retrieves a summary of all hosts.
-func getMMHostsSummary() ([]MMHostSummary, error) {
+// getMMHostsSummary retrieves a summary of all hosts. The archived hosts
+// are only listed for the administration.
+func getMMHostsSummary(withArchived bool) ([]MMHostSummary, error) {
 	const query = `
 		SELECT id, hostname, os_name, os_release, machine, version,
 		       last_seen, monit_uptime
 		FROM hosts
+		WHERE ? OR archived_at IS NULL
 		ORDER BY hostname
 	`
 
-	rows, err := db.Query(query)
+	rows, err := db.Query(query, withArchived)
 	if err != nil {
 		return nil, err
 	}
@@ -534,6 +560,33 @@ func getMMHostDetail(hostID string) (*MMHostDetail, error) {
 	return &h, nil
 }
 
+// getMMHostConnection retrieves the Monit HTTP interface of a host, without
+// its password. Returns nil if the host did not report one.
+func getMMHostConnection(hostID string) (*MMHostConnection, error) {
+	const query = `
+		SELECT http_address, http_port, http_ssl, http_username
+		FROM hosts
+		WHERE id = ?
+	`
+
+	var address, username sql.NullString
+	var port, ssl sql.NullInt64
+	err := db.QueryRow(query, hostID).Scan(&address, &port, &ssl, &username)
+	if err != nil {
+		return nil, err
+	}
+	if !address.Valid || !port.Valid {
+		return nil, nil
+	}
+
+	return &MMHostConnection{
+		Address:  address.String,
+		Port:     int(port.Int64),
+		SSL:      ssl.Int64 != 0,
+		Username: username.String,
+	}, nil
+}
+
 // getMMServicesForHost retrieves all services for a host.
 func getMMServicesForHost(hostID string) ([]MMServiceBrief, error) {
 	const query = `
@@ -820,6 +873,12 @@ func HandleMMV2StatusHostsGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if canSeeConnections(r) {
+		if host.Connection, err = getMMHostConnection(hostID); err != nil {
+			log.Printf("[ERROR] Failed to get host connection: %v", err)
+		}
+	}
+
 	respondJSON(w, host, http.StatusOK)
 }
 
@@ -835,6 +894,7 @@ func HandleMMV2StatusHostsSummary(w http.ResponseWriter, r *http.Request) {
 			          AND (strftime('%s','now') - last_seen) < poll_interval * 5 THEN 1 ELSE 0 END) AS orange,
 			SUM(CASE WHEN (strftime('%s','now') - last_seen) >= poll_interval * 5 THEN 1 ELSE 0 END) AS red
 		FROM hosts
+		WHERE archived_at IS NULL
 	`
 	var green, orange, red int
 	err := db.QueryRow(query).Scan(&green, &orange, &red)
@@ -889,6 +949,7 @@ func HandleMMV2AdminHostsDelete(w http.ResponseWriter, r *http.Request) {
 
 	stats, err := dbpkg.DeleteHost(db, hostID)
 	if err != nil {
+		auditRequest(r, dbpkg.AuditHostDelete, hostID, err.Error(), false)
 		if strings.Contains(err.Error(), "host not found") {
 			respondMMError(w, err.Error(), http.StatusNotFound)
 			return
@@ -901,10 +962,12 @@ func HandleMMV2AdminHostsDelete(w http.ResponseWriter, r *http.Request) {
 		respondMMError(w, "Failed to delete host", http.StatusInternalServerError)
 		return
 	}
+	auditRequest(r, dbpkg.AuditHostDelete, hostID, fmt.Sprintf("%d services, %d events removed", stats.Services, stats.Events), true)
+	monitClients.Remove(hostID)
 
 	respondJSON(w, map[string]interface{}{
 		"deleted": stats.Services + stats.Metrics + stats.FilesystemMetrics +
-			stats.NetworkMetrics + stats.FileMetrics + stats.ProgramMetrics + stats.Events,
+			stats.NetworkMetrics + stats.FileMetrics + stats.PathMetrics + stats.ProgramMetrics + stats.Events,
 	}, http.StatusOK)
 }
 
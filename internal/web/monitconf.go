@@ -0,0 +1,162 @@
+package web
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	dbpkg "github.com/ocochard/cmonit/internal/db"
+)
+
+// CollectorEndpoint is how Monit agents reach the collector, for the
+// "set mmonit" line of the generated Monit configuration.
+type CollectorEndpoint struct {
+	Address        string // Listen address, e.g. "0.0.0.0:8080"
+	TLS            bool
+	User           string
+	Password       string
+	PasswordHashed bool // bcrypt: the password cannot be shown
+}
+
+// collectorEndpoint is set by SetCollectorEndpoint.
+var collectorEndpoint = CollectorEndpoint{Address: ":8080", User: "monit", Password: "monit"}
+
+// SetCollectorEndpoint sets the collector address and credentials used in
+// the generated Monit configuration.
+func SetCollectorEndpoint(ep CollectorEndpoint) {
+	collectorEndpoint = ep
+}
+
+// MonitConfig returns the Monit configuration snippet of a host: the
+// "set mmonit" statement reporting to the collector, reached as
+// collectorHost, and the "set httpd" statement cmonit uses for service
+// actions, with the agent settings and credentials of the host. Without
+// hostID, the snippet is a template for a new agent. Without
+// withPasswords, the collector and agent passwords are replaced by
+// PASSWORD: only the local CLI and admins asking for them see them.
+func MonitConfig(hostID, collectorHost string, withPasswords bool) (string, error) {
+	var b strings.Builder
+
+	ep := collectorEndpoint
+	_, port, err := net.SplitHostPort(ep.Address)
+	if err != nil {
+		port = strings.TrimPrefix(ep.Address, ":")
+	}
+	scheme := "http"
+	if ep.TLS {
+		scheme = "https"
+	}
+	password := ep.Password
+	if ep.PasswordHashed {
+		password = "PASSWORD"
+		b.WriteString("# The collector password is stored as a bcrypt hash: replace PASSWORD\n")
+	} else if !withPasswords {
+		password = "PASSWORD"
+		b.WriteString("# Replace PASSWORD with the collector password (cmonit monit-config shows it)\n")
+	}
+	collector := url.URL{
+		Scheme: scheme,
+		User:   url.UserPassword(ep.User, password),
+		Host:   net.JoinHostPort(collectorHost, port),
+		Path:   "/collector",
+	}
+	fmt.Fprintf(&b, "set mmonit %s\n", collector.String())
+	if ep.TLS {
+		b.WriteString("    # with ssl { verify: enable }  # if the collector certificate is signed by a known CA\n")
+	}
+	b.WriteString("\n")
+
+	if hostID == "" {
+		b.WriteString("# Monit HTTP server used by cmonit for service actions\n")
+		b.WriteString("set httpd port 2812\n")
+		b.WriteString("    use address 0.0.0.0\n")
+		b.WriteString("    allow admin:\"PASSWORD\"\n")
+		return b.String(), nil
+	}
+
+	creds, err := getHostCredentials(hostID)
+	if err != nil {
+		return "", err
+	}
+	user, password := creds.HTTPUsername, creds.HTTPPassword
+	hc, err := dbpkg.GetHostControl(db, hostID)
+	if err != nil {
+		return "", err
+	}
+	if hc.Username != "" {
+		user = hc.Username
+	}
+	if hc.HasPassword && withPasswords {
+		if password, err = hc.Password(); err != nil {
+			return "", fmt.Errorf("Monit password of the host: %w", err)
+		}
+	}
+	if user == "" {
+		user = "admin"
+	}
+	if password == "" || !withPasswords {
+		password = "PASSWORD"
+	}
+	address := creds.HTTPAddress
+	if address == "" {
+		address = "0.0.0.0"
+	}
+	httpPort := creds.HTTPPort
+	if httpPort == 0 {
+		httpPort = 2812
+	}
+
+	fmt.Fprintf(&b, "# Monit HTTP server of %s, used by cmonit for service actions\n", creds.Hostname)
+	fmt.Fprintf(&b, "set httpd port %d\n", httpPort)
+	fmt.Fprintf(&b, "    use address %s\n", address)
+	if creds.HTTPSSL == 1 {
+		b.WriteString("    with ssl { pemfile: /etc/monit/monit.pem }\n")
+	}
+	fmt.Fprintf(&b, "    allow %s:%q\n", user, password)
+	return b.String(), nil
+}
+
+// HandleMonitConfigAPI returns the Monit configuration snippet of a host,
+// or of a new agent without host_id, as text to paste in monitrc. It needs
+// the admin scope when using an API token. The passwords are replaced by
+// PASSWORD unless passwords=1 is given, for a snippet ready to paste; a
+// bcrypt collector password cannot be shown either way. The collector is
+// named as in the request (Host header) unless collector_host is given.
+//
+// GET /api/v1/host/monit-config?host_id=...&collector_host=...&passwords=1
+func HandleMonitConfigAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondJSON(w, map[string]string{"error": "Method not allowed"}, http.StatusMethodNotAllowed)
+		return
+	}
+	if !isAdmin(r) {
+		respondJSON(w, map[string]string{"error": "Insufficient scope: admin required"}, http.StatusForbidden)
+		return
+	}
+
+	collectorHost := r.URL.Query().Get("collector_host")
+	if collectorHost == "" {
+		collectorHost = r.Host
+		if host, _, err := net.SplitHostPort(r.Host); err == nil {
+			collectorHost = host
+		}
+	}
+
+	snippet, err := MonitConfig(r.URL.Query().Get("host_id"), collectorHost, r.URL.Query().Get("passwords") == "1")
+	if err == sql.ErrNoRows {
+		respondJSON(w, map[string]string{"error": "Host not found"}, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("[ERROR] Failed to generate the Monit configuration: %v", err)
+		respondJSON(w, map[string]string{"error": "Failed to generate the Monit configuration"}, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(snippet))
+}
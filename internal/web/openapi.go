@@ -0,0 +1,676 @@
+package web
+
+import (
+	"log"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	dbpkg "github.com/ocochard/cmonit/internal/db"
+)
+
+// APIv1Prefix is the base path of the versioned native JSON API.
+const APIv1Prefix = "/api/v1"
+
+// apiParam describes a query or path parameter of an API operation.
+type apiParam struct {
+	Name        string
+	In          string // "query" or "path"
+	Type        string // "string" or "integer"
+	Required    bool
+	Description string
+	Enum        []string
+}
+
+// apiOperation describes one HTTP method of an API route.
+//
+// Request and Response hold zero values of the Go types encoded as JSON;
+// their OpenAPI schemas are derived from the struct fields and json tags.
+type apiOperation struct {
+	Method      string
+	Summary     string
+	Params      []apiParam
+	Request     interface{} // JSON request body, nil = none
+	Response    interface{} // JSON response body, nil = none
+	Status      int         // Success status, 0 = 200
+	ContentType string      // Response media type, "" = application/json
+}
+
+// APIRoute is a native JSON endpoint. Routes are served under APIv1Prefix
+// and, for existing clients, under their original unversioned /api path.
+type APIRoute struct {
+	Path       string // Path below the prefix, e.g. "/dashboards/{id}"
+	Handler    http.HandlerFunc
+	Operations []apiOperation
+}
+
+// Shared parameter descriptions.
+var (
+	hostIDParam  = apiParam{Name: "host_id", In: "query", Type: "string", Required: true, Description: "Host identifier"}
+	serviceParam = apiParam{Name: "service", In: "query", Type: "string", Required: true, Description: "Service name"}
+	rangeParam   = apiParam{Name: "range", In: "query", Type: "string", Description: "Time range (1h, 6h, 24h, 7d, 30d); default: preferred range"}
+	aggParam     = apiParam{Name: "agg", In: "query", Type: "string", Description: "Aggregate each bucket in SQL", Enum: []string{"avg", "min", "max"}}
+	bucketParam  = apiParam{Name: "bucket", In: "query", Type: "string", Description: "Bucket width (e.g. 5m, 1h; minimum 1m)"}
+	dashboardID  = apiParam{Name: "id", In: "path", Type: "integer", Required: true, Description: "Dashboard ID"}
+	tokenID      = apiParam{Name: "id", In: "path", Type: "integer", Required: true, Description: "Token ID"}
+)
+
+// auditParams are the audit log filters; page only applies to the listing.
+var auditParams = []apiParam{
+	{Name: "actor", In: "query", Type: "string", Description: "Web user, token:<name>, cli or anonymous"},
+	{Name: "action", In: "query", Type: "string", Description: "Action", Enum: dbpkg.AuditActions},
+	{Name: "from", In: "query", Type: "string", Description: "Start date (YYYY-MM-DD) or RFC 3339 timestamp"},
+	{Name: "to", In: "query", Type: "string", Description: "End date (YYYY-MM-DD, inclusive) or RFC 3339 timestamp"},
+	{Name: "page", In: "query", Type: "integer", Description: "Page number (100 entries per page)"},
+}
+
+// apiRoutes lists the native JSON API. It drives both route registration
+// and the OpenAPI document served at /api/v1/openapi.json.
+var apiRoutes = []APIRoute{
+	{Path: "/metrics", Handler: HandleMetricsAPI, Operations: []apiOperation{{
+		Method:   http.MethodGet,
+		Summary:  "Time-series metrics for a service (used by dashboard graphs)",
+		Params:   []apiParam{hostIDParam, serviceParam, rangeParam, aggParam, bucketParam},
+		Response: MetricsResponse{},
+	}}},
+	{Path: "/metrics/export", Handler: HandleMetricsExport, Operations: []apiOperation{{
+		Method:  http.MethodGet,
+		Summary: "Download service metrics or event history as CSV",
+		Params: []apiParam{hostIDParam, serviceParam, rangeParam,
+			{Name: "format", In: "query", Type: "string", Description: "Output format", Enum: []string{"csv"}},
+			{Name: "data", In: "query", Type: "string", Description: "What to export (default metrics)", Enum: []string{"metrics", "events"}},
+			{Name: "metric", In: "query", Type: "string", Description: "Metric type (cpu) or metric (cpu.user); repeatable or comma-separated"},
+			aggParam, bucketParam,
+		},
+		ContentType: "text/csv",
+	}}},
+	{Path: "/remote-metrics", Handler: HandleRemoteHostMetricsAPI, Operations: []apiOperation{{
+		Method:   http.MethodGet,
+		Summary:  "Response times (ms) for a remote host service",
+		Params:   []apiParam{hostIDParam, serviceParam, rangeParam},
+		Response: MetricsResponse{},
+	}}},
+	{Path: "/process-metrics", Handler: HandleProcessMetricsAPI, Operations: []apiOperation{{
+		Method:   http.MethodGet,
+		Summary:  "CPU and memory history of a process service",
+		Params:   []apiParam{hostIDParam, serviceParam, rangeParam, aggParam, bucketParam},
+		Response: MetricsResponse{},
+	}}},
+	{Path: "/network-metrics", Handler: HandleNetworkMetricsAPI, Operations: []apiOperation{{
+		Method:   http.MethodGet,
+		Summary:  "Download and upload throughput history of a network interface service",
+		Params:   []apiParam{hostIDParam, serviceParam, rangeParam, aggParam, bucketParam},
+		Response: MetricsResponse{},
+	}}},
+	{Path: "/filesystem-metrics", Handler: HandleFilesystemUsageAPI, Operations: []apiOperation{{
+		Method:   http.MethodGet,
+		Summary:  "Space and inode usage history of a filesystem service, with a full-by estimate",
+		Params:   []apiParam{hostIDParam, serviceParam, rangeParam},
+		Response: FilesystemUsageResponse{},
+	}}},
+	{Path: "/availability", Handler: HandleAvailabilityAPI, Operations: []apiOperation{{
+		Method:  http.MethodGet,
+		Summary: "Host availability status history",
+		Params: []apiParam{hostIDParam,
+			{Name: "hours", In: "query", Type: "integer", Description: "Hours of history, 1-8760 (default 24)"},
+		},
+		Response: AvailabilityResponse{},
+	}}},
+	{Path: "/top", Handler: HandleTopAPI, Operations: []apiOperation{{
+		Method:  http.MethodGet,
+		Summary: "Hosts and processes (or filesystems) currently using the most CPU, memory or disk",
+		Params: []apiParam{
+			{Name: "metric", In: "query", Type: "string", Description: "Resource (default cpu)", Enum: []string{"cpu", "memory", "disk"}},
+			{Name: "n", In: "query", Type: "integer", Description: "Entries per list, 1-100 (default 10)"},
+		},
+		Response: TopResponse{},
+	}}},
+	{Path: "/compare", Handler: HandleCompareAPI, Operations: []apiOperation{{
+		Method:  http.MethodGet,
+		Summary: "One system metric of several hosts on a shared time axis",
+		Params: []apiParam{
+			{Name: "hosts", In: "query", Type: "string", Required: true, Description: "Comma-separated host identifiers (max 10)"},
+			{Name: "metric", In: "query", Type: "string", Description: "Metric (default cpu)", Enum: []string{"cpu", "memory", "load"}},
+			rangeParam,
+		},
+		Response: CompareResponse{},
+	}}},
+	{Path: "/events", Handler: HandleEventsAPI, Operations: []apiOperation{{
+		Method:  http.MethodGet,
+		Summary: "Events across all hosts, newest first, with filters and pagination",
+		Params: []apiParam{
+			{Name: "host", In: "query", Type: "string", Description: "Host identifier"},
+			{Name: "group", In: "query", Type: "string", Description: "Host group name"},
+			{Name: "service", In: "query", Type: "string", Description: "Service name (exact match)"},
+			{Name: "type", In: "query", Type: "string", Description: "Event type code, decimal or hex (e.g. 0x200)"},
+			{Name: "from", In: "query", Type: "string", Description: "Start date (YYYY-MM-DD, preferred timezone) or RFC 3339 timestamp"},
+			{Name: "to", In: "query", Type: "string", Description: "End date, inclusive (YYYY-MM-DD) or RFC 3339 timestamp"},
+			{Name: "ack", In: "query", Type: "string", Description: "Acknowledged only (yes) or unacknowledged only (no)", Enum: []string{"yes", "no"}},
+			{Name: "severity", In: "query", Type: "string", Description: "Event severity", Enum: []string{"info", "warning", "critical"}},
+			{Name: "q", In: "query", Type: "string", Description: "Words to find in the message, service name or hostname (all must match)"},
+			{Name: "page", In: "query", Type: "integer", Description: "Page number (default 1)"},
+			{Name: "per_page", In: "query", Type: "integer", Description: "Events per page (default 50, max 500)"},
+		},
+		Response: EventsResponse{},
+	}, {
+		Method:   http.MethodPost,
+		Summary:  "Record an external event (deploy, backup...) against a host and service",
+		Request:  EventCreateRequest{},
+		Response: EventCreateResponse{},
+		Status:   http.StatusCreated,
+	}}},
+	{Path: "/events/export", Handler: HandleEventsExport, Operations: []apiOperation{{
+		Method:  http.MethodGet,
+		Summary: "Download all the events matching the filters as CSV or ND-JSON, oldest first",
+		Params: []apiParam{
+			{Name: "format", In: "query", Type: "string", Description: "Output format (default csv)", Enum: []string{"csv", "ndjson"}},
+			{Name: "host", In: "query", Type: "string", Description: "Host identifier"},
+			{Name: "group", In: "query", Type: "string", Description: "Host group name"},
+			{Name: "service", In: "query", Type: "string", Description: "Service name (exact match)"},
+			{Name: "type", In: "query", Type: "string", Description: "Event type code, decimal or hex (e.g. 0x200)"},
+			{Name: "from", In: "query", Type: "string", Description: "Start date (YYYY-MM-DD, preferred timezone) or RFC 3339 timestamp"},
+			{Name: "to", In: "query", Type: "string", Description: "End date, inclusive (YYYY-MM-DD) or RFC 3339 timestamp"},
+			{Name: "ack", In: "query", Type: "string", Description: "Acknowledged only (yes) or unacknowledged only (no)", Enum: []string{"yes", "no"}},
+			{Name: "severity", In: "query", Type: "string", Description: "Event severity", Enum: []string{"info", "warning", "critical"}},
+		},
+		ContentType: "text/csv",
+	}}},
+	{Path: "/events/stats", Handler: HandleEventStatsAPI, Operations: []apiOperation{{
+		Method:  http.MethodGet,
+		Summary: "Event counts per day, per host (noisiest first) and per event type (most common first)",
+		Params: []apiParam{
+			{Name: "from", In: "query", Type: "string", Description: "Start date (YYYY-MM-DD, preferred timezone) or RFC 3339 timestamp (default 30 days ago)"},
+			{Name: "to", In: "query", Type: "string", Description: "End date, inclusive (YYYY-MM-DD) or RFC 3339 timestamp (default now)"},
+			{Name: "host", In: "query", Type: "string", Description: "Host identifier"},
+			{Name: "group", In: "query", Type: "string", Description: "Host group name"},
+			{Name: "service", In: "query", Type: "string", Description: "Service name (exact match)"},
+			{Name: "type", In: "query", Type: "string", Description: "Event type code, decimal or hex (e.g. 0x200)"},
+			{Name: "ack", In: "query", Type: "string", Description: "Acknowledged only (yes) or unacknowledged only (no)", Enum: []string{"yes", "no"}},
+			{Name: "severity", In: "query", Type: "string", Description: "Event severity", Enum: []string{"info", "warning", "critical"}},
+			{Name: "q", In: "query", Type: "string", Description: "Words to find in the message, service name or hostname (all must match)"},
+			{Name: "limit", In: "query", Type: "integer", Description: "Hosts and event types returned (default 10, max 100)"},
+		},
+		Response: EventStatsResponse{},
+	}}},
+	{Path: "/events/feed", Handler: HandleEventsFeed, Operations: []apiOperation{{
+		Method:  http.MethodGet,
+		Summary: "Atom or RSS feed of the newest events, for feed readers",
+		Params: []apiParam{
+			{Name: "format", In: "query", Type: "string", Description: "Feed format (default atom)", Enum: []string{"atom", "rss"}},
+			{Name: "host", In: "query", Type: "string", Description: "Host identifier"},
+			{Name: "group", In: "query", Type: "string", Description: "Host group name"},
+			{Name: "service", In: "query", Type: "string", Description: "Service name (exact match)"},
+			{Name: "severity", In: "query", Type: "string", Description: "Event severity", Enum: []string{"info", "warning", "critical"}},
+			{Name: "per_page", In: "query", Type: "integer", Description: "Events in the feed (default 50, max 500)"},
+		},
+		ContentType: "application/atom+xml",
+	}}},
+	{Path: "/events/daily", Handler: HandleEventDailyCountsAPI, Operations: []apiOperation{{
+		Method:  http.MethodGet,
+		Summary: "Daily counts of the events deleted by the retention job ([retention] aggregate_events)",
+		Params: []apiParam{
+			{Name: "host", In: "query", Type: "string", Description: "Host identifier"},
+			{Name: "from", In: "query", Type: "string", Description: "First day, YYYY-MM-DD (server's local time)"},
+			{Name: "to", In: "query", Type: "string", Description: "Last day, inclusive, YYYY-MM-DD"},
+		},
+		Response: []dbpkg.EventDailyCount{},
+	}}},
+	{Path: "/events/ack", Handler: HandleEventAckAPI, Operations: []apiOperation{{
+		Method:   http.MethodPost,
+		Summary:  "Acknowledge an event (or clear its acknowledgment)",
+		Request:  EventAckRequest{},
+		Response: ActionResponse{},
+	}}},
+	{Path: "/events/comments", Handler: HandleEventCommentAPI, Operations: []apiOperation{{
+		Method:   http.MethodPost,
+		Summary:  "Comment an event",
+		Request:  EventCommentRequest{},
+		Response: ActionResponse{},
+	}}},
+	{Path: "/events/detail", Handler: HandleEventDetailAPI, Operations: []apiOperation{{
+		Method:  http.MethodGet,
+		Summary: "An event with its comments",
+		Params: []apiParam{
+			{Name: "id", In: "query", Type: "integer", Required: true, Description: "Event ID"},
+		},
+		Response: Event{},
+	}}},
+	{Path: "/incidents", Handler: HandleIncidentsAPI, Operations: []apiOperation{{
+		Method:  http.MethodGet,
+		Summary: "Failures of the services paired with their recoveries, newest first",
+		Params: []apiParam{
+			{Name: "host", In: "query", Type: "string", Description: "Host identifier"},
+			{Name: "service", In: "query", Type: "string", Description: "Service name"},
+			{Name: "from", In: "query", Type: "string", Description: "Start date YYYY-MM-DD or RFC 3339 timestamp (default 7 days ago)"},
+			{Name: "to", In: "query", Type: "string", Description: "End date YYYY-MM-DD (inclusive) or RFC 3339 timestamp"},
+			{Name: "limit", In: "query", Type: "integer", Description: "Maximum incidents (default 100, max 1000)"},
+			{Name: "group_window", In: "query", Type: "string", Description: "Group the incidents of the hosts of a hostgroup starting within this duration of each other, e.g. 5m (default 0, no grouping)"},
+		},
+		Response: IncidentsResponse{},
+	}}},
+	{Path: "/action", Handler: HandleActionAPI, Operations: []apiOperation{{
+		Method:   http.MethodPost,
+		Summary:  "Run a Monit action (start, stop, restart, monitor, unmonitor) on a service",
+		Request:  ActionRequest{},
+		Response: ActionResponse{},
+	}}},
+	{Path: "/actions", Handler: HandleActionsAPI, Operations: []apiOperation{{
+		Method:   http.MethodGet,
+		Summary:  "Service actions recently sent to a host's agent, newest first",
+		Params:   []apiParam{hostIDParam, {Name: "limit", In: "query", Type: "integer", Description: "Maximum actions (default 20, max 500)"}},
+		Response: ActionsResponse{},
+	}}},
+	{Path: "/actions/{id}", Handler: HandleActionsAPI, Operations: []apiOperation{{
+		Method:   http.MethodGet,
+		Summary:  "A service action, to follow its verification (verify_status pending, confirmed or timeout)",
+		Params:   []apiParam{{Name: "id", In: "path", Type: "integer", Required: true, Description: "Action ID (action_id of the action response)"}},
+		Response: dbpkg.ActionRecord{},
+	}}},
+	{Path: "/schedule", Handler: HandleScheduleAPI, Operations: []apiOperation{
+		{Method: http.MethodGet, Summary: "Scheduled service actions, pending ones by next run", Params: []apiParam{
+			{Name: "host_id", In: "query", Type: "string", Description: "Host identifier (default: all hosts)"},
+			{Name: "all", In: "query", Type: "integer", Description: "1 to include the actions done or cancelled"},
+		}, Response: ScheduledActionsResponse{}},
+		{Method: http.MethodPost, Summary: "Schedule a Monit action on a service, once or repeated daily or weekly", Request: ScheduleRequest{}, Response: ScheduleResponse{}},
+	}},
+	{Path: "/schedule/calendar", Handler: HandleScheduleCalendar, Operations: []apiOperation{{
+		Method:  http.MethodGet,
+		Summary: "iCalendar feed of the pending scheduled actions, for calendar applications",
+		Params: []apiParam{
+			{Name: "host_id", In: "query", Type: "string", Description: "Host identifier (default: all hosts)"},
+		},
+		ContentType: "text/calendar",
+	}}},
+	{Path: "/schedule/cancel", Handler: HandleScheduleAPI, Operations: []apiOperation{{
+		Method:   http.MethodPost,
+		Summary:  "Cancel a pending scheduled action",
+		Request:  ScheduleCancelRequest{},
+		Response: ActionResponse{},
+	}}},
+	{Path: "/host/description", Handler: HandleUpdateDescription, Operations: []apiOperation{{
+		Method:   http.MethodPost,
+		Summary:  "Set a host's description",
+		Request:  UpdateDescriptionRequest{},
+		Response: UpdateDescriptionResponse{},
+	}}},
+	{Path: "/host/public", Handler: HandleHostPublicAPI, Operations: []apiOperation{{
+		Method:   http.MethodPost,
+		Summary:  "List or unlist a host on the public status page",
+		Request:  HostPublicRequest{},
+		Response: ActionResponse{},
+	}}},
+	{Path: "/host/archive", Handler: HandleHostArchiveAPI, Operations: []apiOperation{
+		{Method: http.MethodGet, Summary: "Archived hosts, most recently archived first", Response: ArchivedHostsResponse{}},
+		{Method: http.MethodPost, Summary: "Archive a host (hidden from the dashboard, no offline alerts, history kept) or unarchive it", Request: HostArchiveRequest{}, Response: ActionResponse{}},
+	}},
+	{Path: "/host/control", Handler: HandleHostControlAPI, Operations: []apiOperation{
+		{Method: http.MethodGet, Summary: "Get how service actions connect to a host's Monit agent (admin)", Params: []apiParam{hostIDParam}, Response: HostControlResponse{}},
+		{Method: http.MethodPost, Summary: "Set the CA bundle and certificate verification for a host's Monit agent over HTTPS", Request: HostControlRequest{}, Response: ActionResponse{}},
+	}},
+	{Path: "/host/monit-config", Handler: HandleMonitConfigAPI, Operations: []apiOperation{{
+		Method:  http.MethodGet,
+		Summary: "Monit configuration snippet (set mmonit, set httpd) of a host, or of a new agent without host_id (admin)",
+		Params: []apiParam{
+			{Name: "host_id", In: "query", Type: "string", Description: "Host identifier; omit for a new agent"},
+			{Name: "collector_host", In: "query", Type: "string", Description: "Name or address of cmonit for the agents (default: as requested)"},
+			{Name: "passwords", In: "query", Type: "integer", Description: "1 to include the collector and agent passwords instead of PASSWORD"},
+		},
+		ContentType: "text/plain",
+	}}},
+	{Path: "/parse-diagnostics", Handler: HandleParseDiagnosticsAPI, Operations: []apiOperation{{
+		Method:  http.MethodGet,
+		Summary: "Latest problems found in the agents' status documents, newest first ([logging] parse_diagnostics, admin)",
+		Params: []apiParam{
+			{Name: "host_id", In: "query", Type: "string", Description: "Host identifier; omit for all hosts and the documents that could not be parsed"},
+			{Name: "limit", In: "query", Type: "integer", Description: "Number of entries, 1-1000 (default 100)"},
+		},
+		Response: []dbpkg.ParseDiagnostic{},
+	}}},
+	{Path: "/validation-failures", Handler: HandleValidationFailuresAPI, Operations: []apiOperation{{
+		Method:   http.MethodGet,
+		Summary:  "Status documents of each host rejected in strict mode ([collector] strict, admin)",
+		Response: []dbpkg.ValidationFailures{},
+	}}},
+	{Path: "/host/daemon", Handler: HandleDaemonActionAPI, Operations: []apiOperation{{
+		Method:   http.MethodPost,
+		Summary:  "Send an agent-wide action: validate, or a service action on all services (admin)",
+		Request:  DaemonActionRequest{},
+		Response: ActionResponse{},
+	}}},
+	{Path: "/host/restart-failed", Handler: HandleRestartFailedAPI, Operations: []apiOperation{{
+		Method:   http.MethodPost,
+		Summary:  "Restart every failed process service of a host, with per-service results",
+		Request:  RestartFailedRequest{},
+		Response: RestartFailedResponse{},
+	}}},
+	{Path: "/hostgroups", Handler: HandleHostGroupsAPI, Operations: []apiOperation{{
+		Method:   http.MethodGet,
+		Summary:  "Host groups with their member hostnames",
+		Response: HostGroupsResponse{},
+	}}},
+	{Path: "/hostgroups/action", Handler: HandleBulkActionAPI, Operations: []apiOperation{{
+		Method:   http.MethodPost,
+		Summary:  "Run a Monit action on a service of every host of a hostgroup, with per-host results",
+		Request:  BulkActionRequest{},
+		Response: BulkActionResponse{},
+	}}},
+	{Path: "/search", Handler: HandleSearchAPI, Operations: []apiOperation{{
+		Method:  http.MethodGet,
+		Summary: "Search hostnames, descriptions, host groups and service names",
+		Params: []apiParam{
+			{Name: "q", In: "query", Type: "string", Required: true, Description: "Search text (case-insensitive substring)"},
+			{Name: "limit", In: "query", Type: "integer", Description: "Maximum results per category (default 20, max 100)"},
+		},
+		Response: SearchResponse{},
+	}}},
+	{Path: "/dashboards", Handler: HandleDashboardsAPI, Operations: []apiOperation{
+		{
+			Method:  http.MethodGet,
+			Summary: "List dashboards visible to the caller",
+			Response: struct {
+				Dashboards []Dashboard `json:"dashboards"`
+			}{},
+		},
+		{
+			Method:   http.MethodPost,
+			Summary:  "Create a dashboard",
+			Request:  DashboardRequest{},
+			Response: Dashboard{},
+			Status:   http.StatusCreated,
+		},
+	}},
+	{Path: "/dashboards/{id}", Handler: HandleDashboardsAPI, Operations: []apiOperation{
+		{Method: http.MethodGet, Summary: "Get a dashboard", Params: []apiParam{dashboardID}, Response: Dashboard{}},
+		{Method: http.MethodPut, Summary: "Replace a dashboard's name, sharing and widgets", Params: []apiParam{dashboardID}, Request: DashboardRequest{}, Response: Dashboard{}},
+		{Method: http.MethodDelete, Summary: "Delete a dashboard", Params: []apiParam{dashboardID}, Response: struct {
+			Success bool `json:"success"`
+		}{}},
+	}},
+	{Path: "/tokens", Handler: HandleTokensAPI, Operations: []apiOperation{
+		{Method: http.MethodGet, Summary: "List API tokens (names, prefixes and scopes only)", Response: TokensResponse{}},
+		{
+			Method:   http.MethodPost,
+			Summary:  "Create an API token; the token is only returned by this call",
+			Request:  TokenRequest{},
+			Response: TokenCreatedResponse{},
+			Status:   http.StatusCreated,
+		},
+	}},
+	{Path: "/tokens/{id}", Handler: HandleTokensAPI, Operations: []apiOperation{
+		{Method: http.MethodDelete, Summary: "Revoke an API token", Params: []apiParam{tokenID}, Response: ActionResponse{}},
+	}},
+	{Path: "/2fa", Handler: HandleTOTPAPI, Operations: []apiOperation{
+		{Method: http.MethodGet, Summary: "Two-factor authentication status of the current user", Response: TOTPStatusResponse{}},
+	}},
+	{Path: "/2fa/enroll", Handler: HandleTOTPAPI, Operations: []apiOperation{
+		{Method: http.MethodPost, Summary: "Generate a TOTP secret to add to an authenticator app", Response: TOTPEnrollResponse{}},
+	}},
+	{Path: "/2fa/confirm", Handler: HandleTOTPAPI, Operations: []apiOperation{
+		{Method: http.MethodPost, Summary: "Enable two-factor authentication with a first code; returns recovery codes", Request: TOTPCodeRequest{}, Response: TOTPRecoveryCodesResponse{}},
+	}},
+	{Path: "/2fa/recovery-codes", Handler: HandleTOTPAPI, Operations: []apiOperation{
+		{Method: http.MethodPost, Summary: "Replace the recovery codes", Request: TOTPCodeRequest{}, Response: TOTPRecoveryCodesResponse{}},
+	}},
+	{Path: "/2fa/disable", Handler: HandleTOTPAPI, Operations: []apiOperation{
+		{Method: http.MethodPost, Summary: "Disable two-factor authentication (refused under the required policy)", Request: TOTPCodeRequest{}, Response: ActionResponse{}},
+	}},
+	{Path: "/audit", Handler: HandleAuditAPI, Operations: []apiOperation{{
+		Method:   http.MethodGet,
+		Summary:  "Audit log of logins and administrative actions, newest first",
+		Params:   auditParams,
+		Response: AuditResponse{},
+	}}},
+	{Path: "/audit/export", Handler: HandleAuditAPI, Operations: []apiOperation{{
+		Method:   http.MethodGet,
+		Summary:  "Download all matching audit log entries as a JSON array",
+		Params:   auditParams[:4],
+		Response: []dbpkg.AuditEntry{},
+	}}},
+	{Path: "/preferences", Handler: HandlePreferencesAPI, Operations: []apiOperation{
+		{Method: http.MethodGet, Summary: "Get display preferences", Response: Preferences{}},
+		{Method: http.MethodPut, Summary: "Update display preferences (missing fields are kept)", Request: Preferences{}, Response: Preferences{}},
+	}},
+}
+
+// APIRoutes returns the native JSON API routes.
+func APIRoutes() []APIRoute {
+	return apiRoutes
+}
+
+// Pattern returns the ServeMux pattern for the versioned route. Routes with
+// path parameters are matched as a subtree ("/dashboards/{id}" becomes
+// "/api/v1/dashboards/").
+func (rt APIRoute) Pattern() string {
+	return APIv1Prefix + rt.muxPath()
+}
+
+// LegacyPattern returns the ServeMux pattern for the unversioned /api path.
+func (rt APIRoute) LegacyPattern() string {
+	return "/api" + rt.muxPath()
+}
+
+func (rt APIRoute) muxPath() string {
+	if i := strings.Index(rt.Path, "{"); i >= 0 {
+		return rt.Path[:i]
+	}
+	return rt.Path
+}
+
+// Serve handles a request to the versioned route. The handler sees the
+// unversioned /api path, so handlers that parse the URL path (e.g. the
+// dashboard ID) work under both prefixes.
+func (rt APIRoute) Serve(w http.ResponseWriter, r *http.Request) {
+	r2 := r.Clone(r.Context())
+	r2.URL.Path = "/api" + strings.TrimPrefix(r.URL.Path, APIv1Prefix)
+	r2.URL.RawPath = ""
+	rt.Handler(w, r2)
+}
+
+// HandleOpenAPI serves the OpenAPI 3 document for the native API.
+//
+// GET /api/v1/openapi.json
+func HandleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	respondJSON(w, buildOpenAPISpec(), http.StatusOK)
+}
+
+// APIDocsData holds data for the API documentation page.
+type APIDocsData struct {
+	SpecURL    string
+	Prefs      Preferences
+	LastUpdate time.Time
+	AppVersion string
+}
+
+// HandleAPIDocs serves a Swagger UI page for the OpenAPI document.
+//
+// GET /api/v1/docs
+func HandleAPIDocs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	err := templates.ExecuteTemplate(w, "api_docs.html", APIDocsData{
+		SpecURL:    APIv1Prefix + "/openapi.json",
+		Prefs:      loadPreferences(r),
+		LastUpdate: time.Now(),
+		AppVersion: appVersion,
+	})
+	if err != nil {
+		log.Printf("[ERROR] Failed to render template: %v", err)
+	}
+}
+
+// buildOpenAPISpec generates the OpenAPI 3 document from apiRoutes.
+func buildOpenAPISpec() map[string]interface{} {
+	g := &schemaGenerator{schemas: map[string]interface{}{}}
+
+	paths := map[string]interface{}{}
+	for _, rt := range apiRoutes {
+		item := map[string]interface{}{}
+		for _, op := range rt.Operations {
+			item[strings.ToLower(op.Method)] = g.operation(op)
+		}
+		paths[rt.Path] = item
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "cmonit API",
+			"version":     appVersion,
+			"description": "Native cmonit JSON API. Every path is also served without the /v1 segment for older clients. The M/Monit-compatible API under /api/2/ is not described here.",
+		},
+		"servers": []interface{}{
+			map[string]interface{}{"url": APIv1Prefix},
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": g.schemas,
+			"securitySchemes": map[string]interface{}{
+				"basicAuth":  map[string]interface{}{"type": "http", "scheme": "basic"},
+				"cookieAuth": map[string]interface{}{"type": "apiKey", "in": "cookie", "name": sessionCookieName},
+				"bearerAuth": map[string]interface{}{"type": "http", "scheme": "bearer", "description": "API token with scopes read:status, write:actions, write:events or admin"},
+			},
+		},
+		// Authentication applies only when -web-user/-web-password are set;
+		// the session cookie is set by the /login page
+		"security": []interface{}{
+			map[string]interface{}{},
+			map[string]interface{}{"basicAuth": []string{}},
+			map[string]interface{}{"cookieAuth": []string{}},
+			map[string]interface{}{"bearerAuth": []string{}},
+		},
+	}
+}
+
+// schemaGenerator builds OpenAPI schemas from Go types, collecting named
+// struct types under components/schemas.
+type schemaGenerator struct {
+	schemas map[string]interface{}
+}
+
+// operation builds the OpenAPI operation object for op.
+func (g *schemaGenerator) operation(op apiOperation) map[string]interface{} {
+	out := map[string]interface{}{"summary": op.Summary}
+
+	if len(op.Params) > 0 {
+		params := make([]interface{}, 0, len(op.Params))
+		for _, p := range op.Params {
+			schema := map[string]interface{}{"type": p.Type}
+			if len(p.Enum) > 0 {
+				schema["enum"] = p.Enum
+			}
+			params = append(params, map[string]interface{}{
+				"name":        p.Name,
+				"in":          p.In,
+				"required":    p.Required,
+				"description": p.Description,
+				"schema":      schema,
+			})
+		}
+		out["parameters"] = params
+	}
+
+	if op.Request != nil {
+		out["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": g.schema(reflect.TypeOf(op.Request))},
+			},
+		}
+	}
+
+	success := map[string]interface{}{"description": "Success"}
+	switch {
+	case op.ContentType != "":
+		success["content"] = map[string]interface{}{
+			op.ContentType: map[string]interface{}{"schema": map[string]interface{}{"type": "string"}},
+		}
+	case op.Response != nil:
+		success["content"] = map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": g.schema(reflect.TypeOf(op.Response))},
+		}
+	}
+
+	status := op.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	out["responses"] = map[string]interface{}{
+		strconv.Itoa(status): success,
+		"default":            map[string]interface{}{"description": "Error (plain text or {\"error\": \"...\"})"},
+	}
+	return out
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schema returns the OpenAPI schema for t. Named structs are added to
+// components/schemas and referenced.
+func (g *schemaGenerator) schema(t reflect.Type) map[string]interface{} {
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return g.schema(t.Elem())
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		name := t.Name()
+		if name != "" {
+			ref := map[string]interface{}{"$ref": "#/components/schemas/" + name}
+			if _, done := g.schemas[name]; done {
+				return ref
+			}
+			g.schemas[name] = nil // Reserve the name before recursing
+			g.schemas[name] = g.structSchema(t)
+			return ref
+		}
+		return g.structSchema(t)
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+// structSchema returns an object schema with one property per exported,
+// JSON-encoded field.
+func (g *schemaGenerator) structSchema(t reflect.Type) map[string]interface{} {
+	props := map[string]interface{}{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = g.schema(f.Type)
+	}
+	return map[string]interface{}{"type": "object", "properties": props}
+}
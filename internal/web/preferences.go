@@ -0,0 +1,265 @@
+package web
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	dbpkg "github.com/ocochard/cmonit/internal/db"
+)
+
+// prefsCookieName identifies anonymous browsers for preference storage.
+const prefsCookieName = "cmonit_prefs"
+
+// Preferences holds display settings for the web UI.
+type Preferences struct {
+	Theme          string `json:"theme"`           // "light" or "dark"
+	Timezone       string `json:"timezone"`        // IANA name, "" = server local time
+	RefreshSeconds int    `json:"refresh_seconds"` // Page auto-refresh, 0 = disabled
+	DefaultRange   string `json:"default_range"`   // Default graph range (1h, 6h, 24h, 7d, 30d)
+
+	location *time.Location // Resolved Timezone
+}
+
+// displayTimezone, displayLocation and displayFormat are the server-wide
+// display settings ([display]): the timezone of users without a preferred
+// one, and the layout of every timestamp ("" = each page's own layout).
+var (
+	displayTimezone string
+	displayLocation = time.Local
+	displayFormat   string
+)
+
+// SetDisplay sets the default timezone (IANA name, "" = server local time)
+// and the layout of the timestamps shown by the web UI and API labels.
+func SetDisplay(timezone, dateFormat string) error {
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return fmt.Errorf("invalid timezone %q", timezone)
+	}
+	if timezone == "" {
+		loc = time.Local
+	}
+	displayTimezone, displayLocation, displayFormat = timezone, loc, dateFormat
+	return nil
+}
+
+// displayTime formats t with the server-wide display settings, for labels
+// built without the viewer's preferences.
+func displayTime(t time.Time, layout string) string {
+	if displayFormat != "" {
+		layout = displayFormat
+	}
+	return t.In(displayLocation).Format(layout)
+}
+
+// PreferencesPageData holds data for the preferences page.
+type PreferencesPageData struct {
+	Prefs      Preferences
+	User       string
+	LastUpdate time.Time
+	AppVersion string
+}
+
+// defaultPreferences returns the settings used when nothing is stored.
+func defaultPreferences() Preferences {
+	return Preferences{
+		Theme:          "light",
+		RefreshSeconds: 60,
+		DefaultRange:   "24h",
+		location:       displayLocation,
+	}
+}
+
+// validate checks field values and resolves the timezone.
+func (p *Preferences) validate() error {
+	if p.Theme != "light" && p.Theme != "dark" {
+		return fmt.Errorf("invalid theme %q (expected light or dark)", p.Theme)
+	}
+	loc, err := time.LoadLocation(p.Timezone)
+	if err != nil {
+		return fmt.Errorf("invalid timezone %q", p.Timezone)
+	}
+	if p.Timezone == "" {
+		loc = displayLocation
+	}
+	p.location = loc
+	if p.RefreshSeconds != 0 && (p.RefreshSeconds < 10 || p.RefreshSeconds > 3600) {
+		return fmt.Errorf("refresh_seconds must be 0 (disabled) or between 10 and 3600")
+	}
+	if _, err := parseTimeRange(p.DefaultRange); err != nil {
+		return fmt.Errorf("invalid default_range %q", p.DefaultRange)
+	}
+	return nil
+}
+
+// Location returns the preferred timezone.
+func (p Preferences) Location() *time.Location {
+	if p.location == nil {
+		return displayLocation
+	}
+	return p.location
+}
+
+// EffectiveTimezone returns the IANA name of the timezone timestamps are
+// shown in, "" for the local time (of the browser, in scripts).
+func (p Preferences) EffectiveTimezone() string {
+	if p.Timezone != "" {
+		return p.Timezone
+	}
+	return displayTimezone
+}
+
+// Format formats t in the preferred timezone, with layout unless [display]
+// date_format applies one to all pages. Used by templates.
+func (p Preferences) Format(t time.Time, layout string) string {
+	if displayFormat != "" {
+		layout = displayFormat
+	}
+	return t.In(p.Location()).Format(layout)
+}
+
+// RefreshMillis returns the auto-refresh interval for JavaScript timers.
+func (p Preferences) RefreshMillis() int {
+	return p.RefreshSeconds * 1000
+}
+
+// preferencesOwner returns the storage key for the request: the web user
+// when authenticated, otherwise the browser cookie token. Returns "" for an
+// anonymous browser that has not saved preferences yet.
+func preferencesOwner(r *http.Request) string {
+	if user := currentUser(r); user != "" {
+		return "user:" + user
+	}
+	if c, err := r.Cookie(prefsCookieName); err == nil && c.Value != "" {
+		return "browser:" + c.Value
+	}
+	return ""
+}
+
+// loadPreferences returns the stored preferences for the request, or the
+// defaults if none are stored.
+func loadPreferences(r *http.Request) Preferences {
+	prefs := defaultPreferences()
+
+	owner := preferencesOwner(r)
+	if owner == "" {
+		return prefs
+	}
+
+	err := db.QueryRow(`
+		SELECT theme, timezone, refresh_seconds, default_range
+		FROM preferences
+		WHERE owner = ?
+	`, owner).Scan(&prefs.Theme, &prefs.Timezone, &prefs.RefreshSeconds, &prefs.DefaultRange)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("[ERROR] Failed to load preferences for %s: %v", owner, err)
+		}
+		return defaultPreferences()
+	}
+
+	if err := prefs.validate(); err != nil {
+		// A timezone can disappear from the system tzdata after it was saved.
+		log.Printf("[WARN] Ignoring stored preferences for %s: %v", owner, err)
+		return defaultPreferences()
+	}
+	return prefs
+}
+
+// savePreferences stores prefs for the request, issuing a browser cookie to
+// anonymous clients that do not have one yet.
+func savePreferences(w http.ResponseWriter, r *http.Request, prefs Preferences) error {
+	owner := preferencesOwner(r)
+	if owner == "" {
+		buf := make([]byte, 16)
+		if _, err := rand.Read(buf); err != nil {
+			return fmt.Errorf("failed to generate preferences token: %w", err)
+		}
+		token := hex.EncodeToString(buf)
+		http.SetCookie(w, &http.Cookie{
+			Name:     prefsCookieName,
+			Value:    token,
+			Path:     "/",
+			MaxAge:   365 * 24 * 3600,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+		owner = "browser:" + token
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO preferences (owner, theme, timezone, refresh_seconds, default_range, updated_at)
+		VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(owner) DO UPDATE SET
+			theme = excluded.theme,
+			timezone = excluded.timezone,
+			refresh_seconds = excluded.refresh_seconds,
+			default_range = excluded.default_range,
+			updated_at = excluded.updated_at
+	`, owner, prefs.Theme, prefs.Timezone, prefs.RefreshSeconds, prefs.DefaultRange)
+	if err != nil {
+		return fmt.Errorf("failed to save preferences: %w", err)
+	}
+	return nil
+}
+
+// HandlePreferences serves the preferences page.
+//
+// GET /preferences
+func HandlePreferences(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	err := templates.ExecuteTemplate(w, "preferences.html", PreferencesPageData{
+		Prefs:      loadPreferences(r),
+		User:       currentUser(r),
+		LastUpdate: time.Now(),
+		AppVersion: appVersion,
+	})
+	if err != nil {
+		log.Printf("[ERROR] Failed to render template: %v", err)
+	}
+}
+
+// HandlePreferencesAPI reads or updates the caller's preferences.
+//
+// GET /api/preferences - current preferences (defaults if none stored)
+// PUT /api/preferences - replace preferences; missing fields keep their current value
+func HandlePreferencesAPI(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		respondJSON(w, loadPreferences(r), http.StatusOK)
+
+	case http.MethodPut, http.MethodPost:
+		prefs := loadPreferences(r)
+		if err := json.NewDecoder(r.Body).Decode(&prefs); err != nil {
+			respondJSON(w, map[string]string{"error": "Invalid JSON"}, http.StatusBadRequest)
+			return
+		}
+		if err := prefs.validate(); err != nil {
+			respondJSON(w, map[string]string{"error": err.Error()}, http.StatusBadRequest)
+			return
+		}
+		if err := savePreferences(w, r, prefs); err != nil {
+			log.Printf("[ERROR] %v", err)
+			respondJSON(w, map[string]string{"error": "Failed to save preferences"}, http.StatusInternalServerError)
+			return
+		}
+		auditRequest(r, dbpkg.AuditPreferencesUpdate, "", fmt.Sprintf("theme=%s timezone=%s refresh=%ds range=%s",
+			prefs.Theme, prefs.Timezone, prefs.RefreshSeconds, prefs.DefaultRange), true)
+		respondJSON(w, prefs, http.StatusOK)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,221 @@
+package web
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ocochard/cmonit/internal/forward"
+)
+
+// promSample is a sample of a Prometheus gauge.
+type promSample struct {
+	labels [][2]string // Name and value, in order
+	value  float64
+}
+
+// promFamily is a Prometheus gauge with its samples.
+type promFamily struct {
+	help    string
+	samples []promSample
+}
+
+// promMetrics gathers the gauges of the fleet exporter, by metric name.
+type promMetrics map[string]*promFamily
+
+// add appends a sample to the gauge name, created with help if needed.
+func (m promMetrics) add(name, help string, value float64, labels ...[2]string) {
+	f, ok := m[name]
+	if !ok {
+		f = &promFamily{help: help}
+		m[name] = f
+	}
+	f.samples = append(f.samples, promSample{labels: labels, value: value})
+}
+
+// promLabelEscaper escapes label values (text exposition format).
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// write writes the gauges in the Prometheus text exposition format
+// (version 0.0.4), by metric name.
+func (m promMetrics) write(w io.Writer) error {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		f := m[name]
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n", name, f.help, name)
+		for _, s := range f.samples {
+			b.WriteString(name)
+			if len(s.labels) > 0 {
+				b.WriteByte('{')
+				for i, l := range s.labels {
+					if i > 0 {
+						b.WriteByte(',')
+					}
+					fmt.Fprintf(&b, `%s="%s"`, l[0], promLabelEscaper.Replace(l[1]))
+				}
+				b.WriteByte('}')
+			}
+			b.WriteString(" " + strconv.FormatFloat(s.value, 'g', -1, 64) + "\n")
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// HandlePrometheusMetrics exposes the latest collected values of every
+// host and service as Prometheus gauges, so that Prometheus and
+// Alertmanager can consume the Monit data.
+//
+// GET /metrics
+//
+//   - cmonit_<type>_<name>{host, host_id, service}: latest metrics, e.g.
+//     cmonit_cpu_user, cmonit_memory_percent, cmonit_load_avg01,
+//     cmonit_process_cpu_percent, cmonit_network_download_bytes
+//   - cmonit_fs_block_percent, cmonit_fs_inode_percent,
+//     cmonit_fs_block_usage_mb, cmonit_fs_block_total_mb: filesystems
+//   - cmonit_service_status (Monit error bits, 0 = OK),
+//     cmonit_service_monitored (0 no, 1 yes, 2 initializing)
+//   - cmonit_host_last_seen_timestamp_seconds,
+//     cmonit_host_poll_interval_seconds
+//
+// Scrapers authenticate like any API client (HTTP Basic Auth, or an API
+// token with read:status).
+func HandlePrometheusMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	metrics, err := getPrometheusMetrics()
+	if err != nil {
+		log.Printf("[ERROR] Failed to get Prometheus metrics: %v", err)
+		http.Error(w, "Failed to get metrics", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := metrics.write(w); err != nil {
+		log.Printf("[ERROR] Failed to write Prometheus metrics: %v", err)
+	}
+}
+
+// getPrometheusMetrics reads the latest values of the hosts and services,
+// leaving out the archived hosts.
+func getPrometheusMetrics() (promMetrics, error) {
+	m := promMetrics{}
+
+	rows, err := db.Query(`SELECT id, hostname, COALESCE(CAST(strftime('%s', last_seen) AS INTEGER), 0), poll_interval FROM hosts WHERE archived_at IS NULL`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id, hostname string
+		var lastSeen, interval int64
+		if err := rows.Scan(&id, &hostname, &lastSeen, &interval); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		host := [][2]string{{"host", hostname}, {"host_id", id}}
+		m.add("cmonit_host_last_seen_timestamp_seconds", "Time of the last status report of the host.", float64(lastSeen), host...)
+		m.add("cmonit_host_poll_interval_seconds", "Poll interval of the Monit agent of the host.", float64(interval), host...)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Query(`
+		SELECT h.hostname, m.host_id, m.service_name, m.metric_type, m.metric_name, m.value
+		FROM latest_metrics m
+		JOIN hosts h ON h.id = m.host_id
+		WHERE h.archived_at IS NULL
+		ORDER BY m.host_id, m.service_name`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var hostname, hostID, service, metricType, metricName string
+		var value float64
+		if err := rows.Scan(&hostname, &hostID, &service, &metricType, &metricName, &value); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		m.add(forward.PrometheusName(metricType, metricName), fmt.Sprintf("Latest %s %s reported by Monit.", metricType, metricName), value,
+			[2]string{"host", hostname}, [2]string{"host_id", hostID}, [2]string{"service", service})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Query(`
+		SELECT h.hostname, s.host_id, s.name, s.type, COALESCE(s.status, 0), COALESCE(s.monitor, 0)
+		FROM services s
+		JOIN hosts h ON h.id = s.host_id
+		WHERE h.archived_at IS NULL
+		ORDER BY s.host_id, s.name`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var hostname, hostID, service string
+		var serviceType, status, monitor int
+		if err := rows.Scan(&hostname, &hostID, &service, &serviceType, &status, &monitor); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		labels := [][2]string{{"host", hostname}, {"host_id", hostID}, {"service", service}, {"type", getServiceTypeName(serviceType)}}
+		m.add("cmonit_service_status", "Monit error bits of the service, 0 when OK.", float64(status), labels...)
+		m.add("cmonit_service_monitored", "Whether Monit monitors the service: 0 no, 1 yes, 2 initializing.", float64(monitor), labels...)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Latest row of each filesystem service, as latestFilesystemUsage
+	rows, err = db.Query(`
+		SELECT h.hostname, f.host_id, f.service_name, f.block_percent, f.inode_percent, f.block_usage_mb, f.block_total_mb
+		FROM services s
+		JOIN hosts h ON h.id = s.host_id
+		JOIN filesystem_metrics f ON f.id = (
+			SELECT id FROM filesystem_metrics
+			WHERE host_id = s.host_id AND service_name = s.name
+			ORDER BY collected_at DESC
+			LIMIT 1
+		)
+		WHERE s.type = 0 AND h.archived_at IS NULL
+		ORDER BY f.host_id, f.service_name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var hostname, hostID, service string
+		var values [4]*float64
+		if err := rows.Scan(&hostname, &hostID, &service, &values[0], &values[1], &values[2], &values[3]); err != nil {
+			return nil, err
+		}
+		labels := [][2]string{{"host", hostname}, {"host_id", hostID}, {"service", service}}
+		for i, g := range []struct{ name, help string }{
+			{"cmonit_fs_block_percent", "Space used on the filesystem, in percent."},
+			{"cmonit_fs_inode_percent", "Inodes used on the filesystem, in percent."},
+			{"cmonit_fs_block_usage_mb", "Space used on the filesystem, in megabytes."},
+			{"cmonit_fs_block_total_mb", "Size of the filesystem, in megabytes."},
+		} {
+			if values[i] != nil {
+				m.add(g.name, g.help, *values[i], labels...)
+			}
+		}
+	}
+	return m, rows.Err()
+}
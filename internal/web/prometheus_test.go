@@ -0,0 +1,31 @@
+package web
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ocochard/cmonit/internal/forward"
+)
+
+// TestPromMetricsWrite checks the text exposition format: families sorted
+// by name, label values escaped, and metric names sanitized.
+func TestPromMetricsWrite(t *testing.T) {
+	m := promMetrics{}
+	m.add(forward.PrometheusName("process-cpu", "percent"), "Latest process CPU.", 12.5, [2]string{"host", "web1"}, [2]string{"service", `say "hi"\now`})
+	m.add("cmonit_host_poll_interval_seconds", "Poll interval.", 30, [2]string{"host", "web1"})
+
+	var b strings.Builder
+	if err := m.write(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP cmonit_host_poll_interval_seconds Poll interval.
+# TYPE cmonit_host_poll_interval_seconds gauge
+cmonit_host_poll_interval_seconds{host="web1"} 30
+# HELP cmonit_process_cpu_percent Latest process CPU.
+# TYPE cmonit_process_cpu_percent gauge
+cmonit_process_cpu_percent{host="web1",service="say \"hi\"\\now"} 12.5
+`
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
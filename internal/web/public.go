@@ -0,0 +1,218 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	dbpkg "github.com/ocochard/cmonit/internal/db"
+)
+
+// publicStatusEnabled reports whether the unauthenticated /public page is
+// served. The host page only offers the "public" toggle when it is.
+var publicStatusEnabled bool
+
+// SetPublicStatus records whether the public status page is enabled.
+//
+// This should be called at startup from main, which also routes /public
+// around web authentication.
+func SetPublicStatus(enabled bool) {
+	publicStatusEnabled = enabled
+}
+
+// PublicStatusData holds data for the public status page.
+//
+// Only the fields below are exposed: no host IDs, addresses, descriptions,
+// metrics or links to the authenticated UI.
+type PublicStatusData struct {
+	Hosts      []PublicHost
+	LastUpdate time.Time
+	Prefs      Preferences
+}
+
+// PublicHost is a host listed on the public status page.
+type PublicHost struct {
+	Hostname          string
+	StatusColor       string // "green", "orange", "red", "gray"
+	StatusName        string // "OK", "Warning", "Critical", "Unknown"
+	StatusDescription string
+	LastSeen          time.Time
+	Services          []PublicService
+}
+
+// PublicService is a service listed on the public status page.
+type PublicService struct {
+	Name        string
+	TypeName    string
+	StatusName  string
+	StatusColor string
+}
+
+// HandlePublicStatus serves the read-only public status page.
+//
+// GET /public
+//
+// Lists hosts that have been opted in (hosts.public = 1) with the status of
+// their services. main registers this route outside web authentication when
+// -public-status is set.
+func HandlePublicStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	hosts, err := getPublicHosts()
+	if err != nil {
+		log.Printf("[ERROR] Failed to get public status data: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	err = templates.ExecuteTemplate(w, "public.html", PublicStatusData{
+		Hosts:      hosts,
+		LastUpdate: time.Now(),
+		Prefs:      loadPreferences(r),
+	})
+	if err != nil {
+		log.Printf("[ERROR] Failed to render template: %v", err)
+	}
+}
+
+// getPublicHosts returns the opted-in hosts, ordered by hostname.
+func getPublicHosts() ([]PublicHost, error) {
+	const query = `
+		SELECT id, hostname, last_seen
+		FROM hosts
+		WHERE public = 1 AND archived_at IS NULL
+		ORDER BY hostname
+	`
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var statuses []HostStatus
+	for rows.Next() {
+		var hs HostStatus
+		if err := rows.Scan(&hs.ID, &hs.Hostname, &hs.LastSeen); err != nil {
+			return nil, err
+		}
+		hs.IsStale = time.Since(hs.LastSeen) > 5*time.Minute
+		statuses = append(statuses, hs)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(statuses) == 0 {
+		return nil, nil
+	}
+
+	servicesByHost, err := getServicesGroupedByHost()
+	if err != nil {
+		return nil, err
+	}
+
+	hosts := make([]PublicHost, 0, len(statuses))
+	for _, hs := range statuses {
+		services := servicesByHost[hs.ID]
+		calculateHostStatus(&hs, services)
+
+		host := PublicHost{
+			Hostname:          hs.Hostname,
+			StatusColor:       hs.StatusColor,
+			StatusName:        hs.StatusName,
+			StatusDescription: hs.StatusDescription,
+			LastSeen:          hs.LastSeen,
+		}
+		for _, svc := range services {
+			host.Services = append(host.Services, PublicService{
+				Name:        svc.Name,
+				TypeName:    svc.TypeName,
+				StatusName:  svc.StatusName,
+				StatusColor: svc.StatusColor,
+			})
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts, nil
+}
+
+// HostPublicRequest is the JSON request for listing a host on the public
+// status page.
+type HostPublicRequest struct {
+	HostID string `json:"host_id"` // Host identifier
+	Public bool   `json:"public"`  // true = list on /public
+}
+
+// HandleHostPublicAPI sets whether a host is listed on the public status page.
+//
+// POST /api/v1/host/public
+//
+// Request body: {"host_id": "...", "public": true}
+// Response: {"success": true, "message": "..."}
+func HandleHostPublicAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondJSON(w, ActionResponse{
+			Success: false,
+			Message: "Method not allowed",
+		}, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req HostPublicRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, ActionResponse{
+			Success: false,
+			Message: "Invalid JSON",
+		}, http.StatusBadRequest)
+		return
+	}
+	if req.HostID == "" {
+		respondJSON(w, ActionResponse{
+			Success: false,
+			Message: "Missing host_id",
+		}, http.StatusBadRequest)
+		return
+	}
+
+	public := 0
+	if req.Public {
+		public = 1
+	}
+
+	result, err := db.Exec("UPDATE hosts SET public = ? WHERE id = ?", public, req.HostID)
+	if err != nil {
+		log.Printf("[ERROR] Failed to update public flag for host %s: %v", req.HostID, err)
+		respondJSON(w, ActionResponse{
+			Success: false,
+			Message: "Failed to update host",
+		}, http.StatusInternalServerError)
+		return
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		respondJSON(w, ActionResponse{
+			Success: false,
+			Message: "Host not found",
+		}, http.StatusNotFound)
+		return
+	}
+
+	message := "Host removed from the public status page"
+	if req.Public {
+		message = "Host listed on the public status page"
+	}
+	log.Printf("[INFO] %s: %s", message, req.HostID)
+	auditRequest(r, dbpkg.AuditHostUpdate, req.HostID, fmt.Sprintf("public=%t", req.Public), true)
+
+	respondJSON(w, ActionResponse{
+		Success: true,
+		Message: message,
+	}, http.StatusOK)
+}
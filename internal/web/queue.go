@@ -0,0 +1,82 @@
+package web
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Default limits of the action queue (see SetActionConcurrency).
+const (
+	DefaultActionConcurrency     = 8 // Agent exchanges at the same time, all hosts
+	DefaultActionHostConcurrency = 1 // Agent exchanges at the same time per host
+)
+
+// actionSlots queues the exchanges of service actions with agents, so that
+// bulk and scheduled actions on many hosts do not contact them all at once.
+var actionSlots = newActionQueue(DefaultActionConcurrency, DefaultActionHostConcurrency)
+
+// SetActionConcurrency sets how many service actions are sent to agents at
+// the same time, overall and per host. It must be called before serving
+// requests.
+func SetActionConcurrency(global, perHost int) error {
+	if global < 1 || global > 64 {
+		return fmt.Errorf("action concurrency must be between 1 and 64")
+	}
+	if perHost < 1 || perHost > global {
+		return fmt.Errorf("per-host action concurrency must be between 1 and the action concurrency (%d)", global)
+	}
+	actionSlots = newActionQueue(global, perHost)
+	return nil
+}
+
+// actionQueue limits the agent exchanges in flight overall and per host.
+// A caller first waits for a slot of its host, then for a global one, so
+// that actions waiting on a busy host do not hold up the other hosts.
+type actionQueue struct {
+	global  chan struct{}
+	perHost int
+
+	mu    sync.Mutex
+	hosts map[string]*hostSlots
+}
+
+// hostSlots are the slots of a host, dropped when no caller uses them.
+type hostSlots struct {
+	slots chan struct{}
+	users int
+}
+
+func newActionQueue(global, perHost int) *actionQueue {
+	return &actionQueue{
+		global:  make(chan struct{}, global),
+		perHost: perHost,
+		hosts:   make(map[string]*hostSlots),
+	}
+}
+
+// acquire waits for a slot to contact the agent of hostID. The returned
+// function releases it.
+func (q *actionQueue) acquire(hostID string) (release func()) {
+	q.mu.Lock()
+	h, ok := q.hosts[hostID]
+	if !ok {
+		h = &hostSlots{slots: make(chan struct{}, q.perHost)}
+		q.hosts[hostID] = h
+	}
+	h.users++
+	q.mu.Unlock()
+
+	h.slots <- struct{}{}
+	q.global <- struct{}{}
+
+	return func() {
+		<-q.global
+		<-h.slots
+		q.mu.Lock()
+		h.users--
+		if h.users == 0 {
+			delete(q.hosts, hostID)
+		}
+		q.mu.Unlock()
+	}
+}
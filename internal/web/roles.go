@@ -0,0 +1,117 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/ocochard/cmonit/internal/control"
+	dbpkg "github.com/ocochard/cmonit/internal/db"
+)
+
+// Role limits the service actions of the API tokens assigned to it to the
+// hosts of some hostgroups and to some actions. Roles are defined in the
+// configuration file ([[role]] tables); the web user is not limited.
+type Role struct {
+	Name       string
+	HostGroups []string // Empty = all hosts
+	Actions    []string // Empty = all actions
+}
+
+// roles holds the configured roles by name.
+var roles = map[string]Role{}
+
+// SetRoles checks and installs the configured roles.
+func SetRoles(list []Role) error {
+	byName := make(map[string]Role, len(list))
+	for _, role := range list {
+		role.Name = strings.TrimSpace(role.Name)
+		if role.Name == "" {
+			return fmt.Errorf("role without a name")
+		}
+		if _, dup := byName[role.Name]; dup {
+			return fmt.Errorf("role %q defined twice", role.Name)
+		}
+		for _, a := range role.Actions {
+			if !control.ValidAction(a) {
+				return fmt.Errorf("role %q: invalid action %q (valid: %s)", role.Name, a, strings.Join(control.Actions, ", "))
+			}
+		}
+		byName[role.Name] = role
+	}
+	roles = byName
+	return nil
+}
+
+// RoleNames returns the sorted names of the configured roles.
+func RoleNames() []string {
+	names := make([]string, 0, len(roles))
+	for name := range roles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// validRole reports whether name is "" (no role) or a configured role.
+func validRole(name string) bool {
+	_, ok := roles[name]
+	return name == "" || ok
+}
+
+// authorizeAction checks that the request may run action on a service of
+// hostID. Requests without a token role are allowed; otherwise the role
+// must list the action (or none) and one of the host's hostgroups (or
+// none). When refused, the reason is returned.
+func authorizeAction(r *http.Request, hostID, action string) (bool, string, error) {
+	t, ok := r.Context().Value(tokenContextKey).(*dbpkg.APIToken)
+	if !ok || t.Role == "" {
+		return true, "", nil
+	}
+
+	role, ok := roles[t.Role]
+	if !ok {
+		// The role was removed from the configuration: fail closed
+		return false, fmt.Sprintf("role %q is not defined", t.Role), nil
+	}
+
+	if len(role.Actions) > 0 && !containsString(role.Actions, action) {
+		return false, fmt.Sprintf("role %q may not %s services", role.Name, action), nil
+	}
+
+	if len(role.HostGroups) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(role.HostGroups)), ",")
+		args := []interface{}{hostID}
+		for _, g := range role.HostGroups {
+			args = append(args, g)
+		}
+		var member bool
+		err := db.QueryRow(`
+			SELECT EXISTS(
+				SELECT 1 FROM host_hostgroups hhg
+				JOIN hostgroups hg ON hg.id = hhg.hostgroup_id
+				WHERE hhg.host_id = ? AND hg.name IN (`+placeholders+`)
+			)
+		`, args...).Scan(&member)
+		if err != nil {
+			return false, "", fmt.Errorf("failed to check hostgroups of %s: %w", hostID, err)
+		}
+		if !member {
+			return false, fmt.Sprintf("role %q may not act on this host (hostgroups: %s)",
+				role.Name, strings.Join(role.HostGroups, ", ")), nil
+		}
+	}
+
+	return true, "", nil
+}
+
+// containsString reports whether list holds s.
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
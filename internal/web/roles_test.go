@@ -0,0 +1,89 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	dbpkg "github.com/ocochard/cmonit/internal/db"
+)
+
+func TestSetRoles(t *testing.T) {
+	saved := roles
+	defer func() { roles = saved }()
+
+	tests := []struct {
+		name  string
+		roles []Role
+		err   string // Part of the expected error, "" for none
+	}{
+		{"valid", []Role{{Name: "web", HostGroups: []string{"www"}, Actions: []string{"restart"}}, {Name: "ops"}}, ""},
+		{"no name", []Role{{Name: " "}}, "without a name"},
+		{"duplicate", []Role{{Name: "ops"}, {Name: "ops "}}, "defined twice"},
+		{"invalid action", []Role{{Name: "ops", Actions: []string{"reboot"}}}, "invalid action"},
+	}
+	for _, tt := range tests {
+		err := SetRoles(tt.roles)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%s: err = %v, want %q", tt.name, err, tt.err)
+		}
+	}
+
+	if err := SetRoles([]Role{{Name: "web"}, {Name: "backup"}, {Name: "ops"}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(RoleNames(), ","); got != "backup,ops,web" {
+		t.Errorf("RoleNames = %s, want backup,ops,web", got)
+	}
+	if !validRole("") || !validRole("ops") || validRole("dba") {
+		t.Errorf("validRole: want \"\" and ops, not dba")
+	}
+}
+
+// TestAuthorizeAction checks the action limits of token roles. Hostgroup
+// limits need the database and are not covered here.
+func TestAuthorizeAction(t *testing.T) {
+	saved := roles
+	defer func() { roles = saved }()
+	if err := SetRoles([]Role{
+		{Name: "restarter", Actions: []string{"restart", "monitor"}},
+		{Name: "anything"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		token  *dbpkg.APIToken // nil for a logged-in user
+		action string
+		ok     bool
+	}{
+		{"web user", nil, "stop", true},
+		{"token without role", &dbpkg.APIToken{Name: "t"}, "stop", true},
+		{"allowed action", &dbpkg.APIToken{Name: "t", Role: "restarter"}, "restart", true},
+		{"refused action", &dbpkg.APIToken{Name: "t", Role: "restarter"}, "stop", false},
+		{"role without limits", &dbpkg.APIToken{Name: "t", Role: "anything"}, "unmonitor", true},
+		{"removed role", &dbpkg.APIToken{Name: "t", Role: "gone"}, "restart", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/action", nil)
+		if tt.token != nil {
+			r = withToken(r, tt.token)
+		}
+		ok, reason, err := authorizeAction(r, "host-1", tt.action)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if ok != tt.ok {
+			t.Errorf("%s: ok = %v (%s), want %v", tt.name, ok, reason, tt.ok)
+		}
+		if !ok && reason == "" {
+			t.Errorf("%s: refused without a reason", tt.name)
+		}
+	}
+}
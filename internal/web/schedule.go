@@ -0,0 +1,416 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ocochard/cmonit/internal/control"
+	dbpkg "github.com/ocochard/cmonit/internal/db"
+)
+
+// ScheduleInterval is how often RunScheduledActions should be called: the
+// precision of scheduled actions.
+const ScheduleInterval = 30 * time.Second
+
+// maxUnmonitorDuration is the longest temporary unmonitor: longer ones are
+// better left as a permanent unmonitor.
+const maxUnmonitorDuration = 30 * 24 * time.Hour
+
+// ScheduleRequest is the request body of POST /api/v1/schedule.
+type ScheduleRequest struct {
+	HostID  string    `json:"host_id"`
+	Service string    `json:"service"`
+	Action  string    `json:"action"`
+	RunAt   time.Time `json:"run_at"`  // RFC 3339, e.g. "2026-10-18T03:00:00+02:00"
+	Repeat  string    `json:"repeat"`  // "" (once), "daily" or "weekly"
+	DryRun  bool      `json:"dry_run"` // Only check the request and report the next runs
+}
+
+// ScheduleCancelRequest is the request body of POST /api/v1/schedule/cancel.
+type ScheduleCancelRequest struct {
+	ID int64 `json:"id"`
+}
+
+// ScheduleResponse is the JSON response of POST /api/v1/schedule.
+type ScheduleResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	ID      int64  `json:"id,omitempty"` // Scheduled action ID, to cancel it
+
+	// NextRuns are the first runs of the action, returned by dry runs
+	NextRuns []time.Time `json:"next_runs,omitempty"`
+}
+
+// dryRunNextRuns is the number of runs a dry run of a repeated schedule
+// reports.
+const dryRunNextRuns = 5
+
+// ScheduledActionsResponse is the JSON response of GET /api/v1/schedule.
+type ScheduledActionsResponse struct {
+	Scheduled []dbpkg.ScheduledAction `json:"scheduled"`
+}
+
+// HandleScheduleAPI lists, creates and cancels service actions scheduled
+// to run later, once or repeatedly.
+//
+// GET /api/v1/schedule?host_id=...&all=1
+// POST /api/v1/schedule
+// POST /api/v1/schedule/cancel
+//
+// The list has the pending actions, by next run (all=1 adds those done or
+// cancelled). The action and host are checked against the token role when
+// scheduling; the runs are recorded in the action history and audit log as
+// requested by the user who scheduled them. A dry run checks the request
+// and returns the next runs without storing anything.
+func HandleScheduleAPI(w http.ResponseWriter, r *http.Request) {
+	switch strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/schedule"), "/") {
+	case "":
+		switch r.Method {
+		case http.MethodGet:
+			q := r.URL.Query()
+			scheduled, err := dbpkg.ListScheduledActions(db, q.Get("host_id"), q.Get("all") == "1")
+			if err != nil {
+				log.Printf("[ERROR] %v", err)
+				respondJSON(w, map[string]string{"error": "Failed to query scheduled actions"}, http.StatusInternalServerError)
+				return
+			}
+			respondJSON(w, ScheduledActionsResponse{Scheduled: scheduled}, http.StatusOK)
+		case http.MethodPost:
+			scheduleAction(w, r)
+		default:
+			respondJSON(w, map[string]string{"error": "Method not allowed"}, http.StatusMethodNotAllowed)
+		}
+	case "cancel":
+		if r.Method != http.MethodPost {
+			respondJSON(w, map[string]string{"error": "Method not allowed"}, http.StatusMethodNotAllowed)
+			return
+		}
+		cancelScheduledAction(w, r)
+	default:
+		respondJSON(w, map[string]string{"error": "Not found"}, http.StatusNotFound)
+	}
+}
+
+// scheduleAction handles POST /api/v1/schedule.
+func scheduleAction(w http.ResponseWriter, r *http.Request) {
+	var req ScheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, ActionResponse{
+			Success: false,
+			Message: "Invalid request body (run_at must be an RFC 3339 time)",
+		}, http.StatusBadRequest)
+		return
+	}
+	if req.HostID == "" || req.Service == "" {
+		respondJSON(w, ActionResponse{
+			Success: false,
+			Message: "Missing host_id or service",
+		}, http.StatusBadRequest)
+		return
+	}
+	if !control.ValidAction(req.Action) {
+		respondJSON(w, ActionResponse{
+			Success: false,
+			Message: fmt.Sprintf("Invalid action %q (valid: %s)", req.Action, strings.Join(control.Actions, ", ")),
+		}, http.StatusBadRequest)
+		return
+	}
+	switch req.Repeat {
+	case dbpkg.RepeatOnce, dbpkg.RepeatDaily, dbpkg.RepeatWeekly:
+	default:
+		respondJSON(w, ActionResponse{
+			Success: false,
+			Message: fmt.Sprintf("Invalid repeat %q (valid: daily, weekly, or empty for once)", req.Repeat),
+		}, http.StatusBadRequest)
+		return
+	}
+	req.RunAt = req.RunAt.Truncate(time.Second)
+	if !req.RunAt.After(time.Now()) {
+		respondJSON(w, ActionResponse{
+			Success: false,
+			Message: "run_at must be in the future",
+		}, http.StatusBadRequest)
+		return
+	}
+
+	var hostname string
+	err := db.QueryRow(`
+		SELECT h.hostname FROM hosts h
+		JOIN services s ON s.host_id = h.id
+		WHERE h.id = ? AND s.name = ?
+	`, req.HostID, req.Service).Scan(&hostname)
+	if err != nil {
+		respondJSON(w, ActionResponse{
+			Success: false,
+			Message: "Host or service not found",
+		}, http.StatusNotFound)
+		return
+	}
+
+	target := hostname + "/" + req.Service
+	allowed, reason, err := authorizeAction(r, req.HostID, req.Action)
+	if err != nil {
+		log.Printf("[ERROR] %v", err)
+		respondJSON(w, ActionResponse{
+			Success: false,
+			Message: "Failed to check authorization",
+		}, http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		auditRequest(r, dbpkg.AuditAccessDenied, target, "schedule "+req.Action+": "+reason, false)
+		respondJSON(w, ActionResponse{
+			Success: false,
+			Message: "Not allowed: " + reason,
+		}, http.StatusForbidden)
+		return
+	}
+
+	// Dry run: the request is valid and allowed, report when it would run
+	if req.DryRun {
+		runs := []time.Time{req.RunAt}
+		for next := req.RunAt; req.Repeat != dbpkg.RepeatOnce && len(runs) < dryRunNextRuns; {
+			next = dbpkg.NextRun(next, req.Repeat, time.Local)
+			runs = append(runs, next)
+		}
+		respondJSON(w, ScheduleResponse{
+			Success:  true,
+			Message:  fmt.Sprintf("Dry run: %s would be scheduled on %s", req.Action, target),
+			NextRuns: runs,
+		}, http.StatusOK)
+		return
+	}
+
+	createdBy := currentUser(r)
+	if createdBy == "" {
+		createdBy = "anonymous"
+	}
+	id, err := dbpkg.CreateScheduledAction(db, dbpkg.ScheduledAction{
+		HostID:    req.HostID,
+		Service:   req.Service,
+		Action:    req.Action,
+		RunAt:     req.RunAt,
+		Repeat:    req.Repeat,
+		CreatedBy: createdBy,
+	})
+	if err != nil {
+		log.Printf("[ERROR] %v", err)
+		respondJSON(w, ActionResponse{
+			Success: false,
+			Message: "Failed to schedule action",
+		}, http.StatusInternalServerError)
+		return
+	}
+
+	details := fmt.Sprintf("%s at %s", req.Action, req.RunAt.UTC().Format(time.RFC3339))
+	if req.Repeat != dbpkg.RepeatOnce {
+		details += ", " + req.Repeat
+	}
+	log.Printf("[INFO] Scheduled action %d: %s on %s", id, details, target)
+	auditRequest(r, dbpkg.AuditActionSchedule, target, details, true)
+
+	respondJSON(w, ScheduleResponse{
+		Success: true,
+		Message: fmt.Sprintf("Action '%s' on service '%s' scheduled", req.Action, req.Service),
+		ID:      id,
+	}, http.StatusOK)
+}
+
+// cancelScheduledAction handles POST /api/v1/schedule/cancel.
+func cancelScheduledAction(w http.ResponseWriter, r *http.Request) {
+	var req ScheduleCancelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == 0 {
+		respondJSON(w, ActionResponse{
+			Success: false,
+			Message: "Invalid request body",
+		}, http.StatusBadRequest)
+		return
+	}
+
+	s, err := dbpkg.GetScheduledAction(db, req.ID)
+	if err != nil {
+		log.Printf("[ERROR] %v", err)
+		respondJSON(w, ActionResponse{
+			Success: false,
+			Message: "Failed to cancel scheduled action",
+		}, http.StatusInternalServerError)
+		return
+	}
+	if s == nil {
+		respondJSON(w, ActionResponse{
+			Success: false,
+			Message: "Scheduled action not found",
+		}, http.StatusNotFound)
+		return
+	}
+
+	// A token may only cancel what its role would allow it to schedule
+	allowed, reason, err := authorizeAction(r, s.HostID, s.Action)
+	if err != nil {
+		log.Printf("[ERROR] %v", err)
+		respondJSON(w, ActionResponse{
+			Success: false,
+			Message: "Failed to check authorization",
+		}, http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		auditRequest(r, dbpkg.AuditAccessDenied, s.Hostname+"/"+s.Service, "cancel "+s.Action+": "+reason, false)
+		respondJSON(w, ActionResponse{
+			Success: false,
+			Message: "Not allowed: " + reason,
+		}, http.StatusForbidden)
+		return
+	}
+
+	user := currentUser(r)
+	if user == "" {
+		user = "anonymous"
+	}
+	cancelled, err := dbpkg.CancelScheduledAction(db, req.ID, user)
+	if err != nil {
+		log.Printf("[ERROR] %v", err)
+		respondJSON(w, ActionResponse{
+			Success: false,
+			Message: "Failed to cancel scheduled action",
+		}, http.StatusInternalServerError)
+		return
+	}
+	if !cancelled {
+		respondJSON(w, ActionResponse{
+			Success: false,
+			Message: "Scheduled action is no longer pending",
+		}, http.StatusConflict)
+		return
+	}
+
+	log.Printf("[INFO] Cancelled scheduled action %d (%s on %s/%s)", s.ID, s.Action, s.Hostname, s.Service)
+	auditRequest(r, dbpkg.AuditActionCancel, s.Hostname+"/"+s.Service,
+		fmt.Sprintf("%s at %s", s.Action, s.RunAt.UTC().Format(time.RFC3339)), true)
+	respondJSON(w, ActionResponse{
+		Success: true,
+		Message: "Scheduled action cancelled",
+	}, http.StatusOK)
+}
+
+// RunScheduledActions runs the scheduled actions that are due and moves
+// repeating ones to their next run. Runs missed while cmonit was stopped
+// happen once, at the next call. It is called every ScheduleInterval.
+func RunScheduledActions() {
+	now := time.Now()
+	due, err := dbpkg.DueScheduledActions(db, now)
+	if err != nil {
+		log.Printf("[ERROR] %v", err)
+		return
+	}
+
+	// Due actions run together, through the action queue
+	var wg sync.WaitGroup
+	for _, s := range due {
+		wg.Add(1)
+		go func(s dbpkg.ScheduledAction) {
+			defer wg.Done()
+			log.Printf("[INFO] Running scheduled action %d: %s on %s/%s (scheduled by %s)",
+				s.ID, s.Action, s.Hostname, s.Service, s.CreatedBy)
+			_, resp := runServiceAction(scheduledRequest(s), s.HostID, s.Service, s.Action)
+
+			var next time.Time
+			if s.Repeat != dbpkg.RepeatOnce {
+				next = dbpkg.NextRun(s.RunAt, s.Repeat, time.Local)
+				for !next.After(now) {
+					next = dbpkg.NextRun(next, s.Repeat, time.Local)
+				}
+			}
+			if err := dbpkg.RecordScheduledRun(db, s.ID, now, resp.Success, resp.Message, resp.ActionID, next); err != nil {
+				log.Printf("[ERROR] %v", err)
+			}
+		}(s)
+	}
+	wg.Wait()
+}
+
+// scheduledRequest returns the request a scheduled action runs as: the
+// user who scheduled it, from the "scheduler" source address. It carries no
+// API token, the role having been checked when scheduling.
+func scheduledRequest(s dbpkg.ScheduledAction) *http.Request {
+	ctx := context.WithValue(context.Background(), userContextKey, s.CreatedBy)
+	r, _ := http.NewRequestWithContext(ctx, http.MethodPost, "/api/schedule", nil)
+	r.RemoteAddr = "scheduler"
+	return r
+}
+
+// SchedulePageData is the data of the scheduled actions page.
+type SchedulePageData struct {
+	Scheduled  []dbpkg.ScheduledAction
+	All        bool // Showing the actions done or cancelled too
+	LastUpdate time.Time
+	AppVersion string
+	Prefs      Preferences
+}
+
+// HandleSchedule renders the scheduled actions page (/schedule): pending
+// actions of all hosts with their next run, and a button to cancel them.
+func HandleSchedule(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	all := r.URL.Query().Get("all") == "1"
+	scheduled, err := dbpkg.ListScheduledActions(db, "", all)
+	if err != nil {
+		log.Printf("[ERROR] %v", err)
+		http.Error(w, "Failed to load scheduled actions", http.StatusInternalServerError)
+		return
+	}
+
+	data := SchedulePageData{
+		Scheduled:  scheduled,
+		All:        all,
+		LastUpdate: time.Now(),
+		AppVersion: appVersion,
+		Prefs:      loadPreferences(r),
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := templates.ExecuteTemplate(w, "schedule.html", data); err != nil {
+		log.Printf("[ERROR] Failed to render template: %v", err)
+	}
+}
+
+// scheduleRemonitor schedules the monitor action ending a temporary
+// unmonitor of a service, d from now. A re-monitor pending from an earlier
+// temporary unmonitor is replaced, so the latest window wins.
+func scheduleRemonitor(r *http.Request, hostID, hostname, service string, d time.Duration) (time.Time, error) {
+	createdBy := currentUser(r)
+	if createdBy == "" {
+		createdBy = "anonymous"
+	}
+	if _, err := dbpkg.CancelPendingActions(db, hostID, service, "monitor", createdBy); err != nil {
+		return time.Time{}, err
+	}
+
+	runAt := time.Now().Add(d).Truncate(time.Second)
+	id, err := dbpkg.CreateScheduledAction(db, dbpkg.ScheduledAction{
+		HostID:    hostID,
+		Service:   service,
+		Action:    "monitor",
+		RunAt:     runAt,
+		Repeat:    dbpkg.RepeatOnce,
+		CreatedBy: createdBy,
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	details := fmt.Sprintf("monitor at %s (end of temporary unmonitor)", runAt.UTC().Format(time.RFC3339))
+	log.Printf("[INFO] Scheduled action %d: %s on %s/%s", id, details, hostname, service)
+	auditRequest(r, dbpkg.AuditActionSchedule, hostname+"/"+service, details, true)
+	return runAt, nil
+}
@@ -0,0 +1,200 @@
+package web
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Search result limits.
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// SearchResult is a single match returned by /api/search.
+type SearchResult struct {
+	Type     string `json:"type"`              // "host" or "service"
+	HostID   string `json:"host_id"`           // Host the match belongs to
+	Hostname string `json:"hostname"`          // Host display name
+	Service  string `json:"service,omitempty"` // Service name (service results only)
+	Match    string `json:"match"`             // Matched field: hostname, description, group, service
+	Snippet  string `json:"snippet,omitempty"` // Matched text (group name or description excerpt)
+	URL      string `json:"url"`               // Deep link to the host or service detail page
+}
+
+// SearchResponse is the JSON response for the search API.
+type SearchResponse struct {
+	Query   string         `json:"query"`
+	Results []SearchResult `json:"results"`
+}
+
+// HandleSearchAPI searches hosts and services.
+//
+// GET /api/search?q=<text>&limit=<n>
+//
+// Case-insensitive substring match on hostnames, host descriptions,
+// hostgroup names (tags) and service names. Host matches are listed before
+// service matches; each category returns at most limit entries. Archived
+// hosts are left out.
+func HandleSearchAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		respondJSON(w, map[string]string{"error": "Missing required parameter: q"}, http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultSearchLimit
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 {
+		limit = l
+		if limit > maxSearchLimit {
+			limit = maxSearchLimit
+		}
+	}
+
+	results, err := search(q, limit)
+	if err != nil {
+		log.Printf("[ERROR] Search for %q failed: %v", q, err)
+		respondJSON(w, map[string]string{"error": "Search failed"}, http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, SearchResponse{Query: q, Results: results}, http.StatusOK)
+}
+
+// search runs the host and service queries for HandleSearchAPI.
+func search(q string, limit int) ([]SearchResult, error) {
+	pattern := "%" + escapeLike(q) + "%"
+	results := []SearchResult{}
+
+	// A host can match on several fields; report the most specific one
+	// (hostname, then group, then description).
+	const hostsQuery = `
+		SELECT h.id, h.hostname, COALESCE(h.description, ''),
+		       COALESCE((
+		           SELECT hg.name
+		           FROM host_hostgroups hhg
+		           JOIN hostgroups hg ON hg.id = hhg.hostgroup_id
+		           WHERE hhg.host_id = h.id AND hg.name LIKE ?1 ESCAPE '\'
+		           ORDER BY hg.name
+		           LIMIT 1
+		       ), '')
+		FROM hosts h
+		WHERE h.archived_at IS NULL
+		  AND (h.hostname LIKE ?1 ESCAPE '\'
+		   OR h.description LIKE ?1 ESCAPE '\'
+		   OR EXISTS (
+		       SELECT 1
+		       FROM host_hostgroups hhg
+		       JOIN hostgroups hg ON hg.id = hhg.hostgroup_id
+		       WHERE hhg.host_id = h.id AND hg.name LIKE ?1 ESCAPE '\'
+		   ))
+		ORDER BY h.hostname
+		LIMIT ?2
+	`
+
+	rows, err := db.Query(hostsQuery, pattern, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lower := strings.ToLower(q)
+	for rows.Next() {
+		var res SearchResult
+		var description, group string
+		if err := rows.Scan(&res.HostID, &res.Hostname, &description, &group); err != nil {
+			return nil, err
+		}
+		res.Type = "host"
+		res.URL = "/host/" + url.PathEscape(res.HostID)
+		switch {
+		case strings.Contains(strings.ToLower(res.Hostname), lower):
+			res.Match = "hostname"
+		case group != "":
+			res.Match = "group"
+			res.Snippet = group
+		default:
+			res.Match = "description"
+			res.Snippet = searchSnippet(description, lower)
+		}
+		results = append(results, res)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	const servicesQuery = `
+		SELECT s.host_id, h.hostname, s.name
+		FROM services s
+		JOIN hosts h ON h.id = s.host_id
+		WHERE s.name LIKE ? ESCAPE '\' AND h.archived_at IS NULL
+		ORDER BY h.hostname, s.name
+		LIMIT ?
+	`
+
+	svcRows, err := db.Query(servicesQuery, pattern, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer svcRows.Close()
+
+	for svcRows.Next() {
+		res := SearchResult{Type: "service", Match: "service"}
+		if err := svcRows.Scan(&res.HostID, &res.Hostname, &res.Service); err != nil {
+			return nil, err
+		}
+		res.URL = "/host/" + url.PathEscape(res.HostID) + "/service/" + url.PathEscape(res.Service)
+		results = append(results, res)
+	}
+
+	return results, svcRows.Err()
+}
+
+// searchSnippet returns up to ~80 characters of text around the first
+// case-insensitive occurrence of lowerQuery.
+func searchSnippet(text, lowerQuery string) string {
+	const radius = 40
+
+	idx := strings.Index(strings.ToLower(text), lowerQuery)
+	if idx < 0 {
+		return ""
+	}
+	if idx >= len(text) {
+		// Lowercasing changed byte lengths; fall back to the start of text.
+		idx = 0
+	}
+	start := idx - radius
+	if start < 0 {
+		start = 0
+	}
+	end := idx + len(lowerQuery) + radius
+	if end > len(text) {
+		end = len(text)
+	}
+
+	// Keep the cut on UTF-8 boundaries.
+	for start > 0 && !utf8.RuneStart(text[start]) {
+		start--
+	}
+	for end < len(text) && !utf8.RuneStart(text[end]) {
+		end++
+	}
+
+	snippet := strings.TrimSpace(text[start:end])
+	if start > 0 {
+		snippet = "…" + snippet
+	}
+	if end < len(text) {
+		snippet += "…"
+	}
+	return snippet
+}
@@ -0,0 +1,410 @@
+package web
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	dbpkg "github.com/ocochard/cmonit/internal/db"
+)
+
+// sessionCookieName holds the login session token.
+const sessionCookieName = "cmonit_session"
+
+// sessionTouchInterval limits how often a session's idle expiry is pushed
+// back, so that every request does not write to the database.
+const sessionTouchInterval = time.Minute
+
+// SessionAuth configures web UI login.
+type SessionAuth struct {
+	// Verify reports whether the username and password are valid
+	Verify func(username, password string) bool
+
+	// IdleTimeout ends sessions after this long without a request
+	IdleTimeout time.Duration
+
+	// RememberFor is the lifetime of "remember me" sessions, which have
+	// no idle timeout (0 disables "remember me")
+	RememberFor time.Duration
+
+	// TOTPPolicy is the two-factor authentication policy: TOTPPolicyOff,
+	// TOTPPolicyOptional (default) or TOTPPolicyRequired
+	TOTPPolicy string
+}
+
+// sessionAuth is the login configuration; Verify is nil when web
+// authentication is disabled.
+var sessionAuth SessionAuth
+
+// SetSessionAuth enables login sessions with the given configuration.
+//
+// This should be called at startup from main, which also wraps the web
+// handler with RequireLogin.
+func SetSessionAuth(auth SessionAuth) {
+	sessionAuth = auth
+}
+
+// contextKey is the type of request context keys set by this package.
+type contextKey string
+
+// userContextKey holds the authenticated username in the request context.
+const userContextKey contextKey = "user"
+
+// tokenContextKey holds the *dbpkg.APIToken of requests authenticated by
+// an API token.
+const tokenContextKey contextKey = "token"
+
+// withUser returns r with username recorded as the authenticated user.
+func withUser(r *http.Request, username string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), userContextKey, username))
+}
+
+// RequireLogin wraps the web UI handler with authentication.
+//
+// A request is authenticated by a session cookie from the login page, by
+// an API token ("Authorization: Bearer", limited to its scopes), or by
+// HTTP Basic Auth credentials so that scripts and M/Monit-compatible
+// clients keep working. Basic Auth is refused for accounts using
+// two-factor authentication. Unauthenticated page loads are redirected to
+// /login; other requests get 401. The login page and static assets are
+// always served.
+func RequireLogin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" || r.URL.Path == "/logout" || strings.HasPrefix(r.URL.Path, "/static/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if token, ok := bearerToken(r); ok {
+			if t, ok := authenticateToken(w, r, token); ok {
+				next.ServeHTTP(w, withToken(withUser(r, tokenUser(t)), t))
+			}
+			return
+		}
+
+		if username, ok := lookupSession(r); ok {
+			if requireTOTPEnrollment(w, r, username) {
+				next.ServeHTTP(w, withUser(r, username))
+			}
+			return
+		}
+
+		if user, pass, ok := r.BasicAuth(); ok {
+			if sessionAuth.Verify(user, pass) {
+				allowed, err := basicAuthAllowed(user)
+				if err != nil {
+					log.Printf("[ERROR] %v", err)
+					respondJSON(w, map[string]string{"error": "Failed to check two-factor authentication"}, http.StatusInternalServerError)
+					return
+				}
+				if !allowed {
+					log.Printf("[WARNING] Basic Auth refused for %s from %s: two-factor authentication in use", user, r.RemoteAddr)
+					recordAudit(r, user, dbpkg.AuditLoginFailed, "", "HTTP Basic Auth refused: two-factor authentication in use", false)
+					respondJSON(w, map[string]string{
+						"error": "Two-factor authentication is in use: log in on /login or use an API token",
+					}, http.StatusUnauthorized)
+					return
+				}
+				next.ServeHTTP(w, withUser(r, user))
+				return
+			}
+			log.Printf("[WARNING] Failed authentication attempt from %s (user: %s)", r.RemoteAddr, user)
+			recordAudit(r, user, dbpkg.AuditLoginFailed, "", "HTTP Basic Auth: invalid credentials", false)
+			w.Header().Set("WWW-Authenticate", `Basic realm="cmonit"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// Browsers are sent to the login page, API clients get an error
+		if (r.Method == http.MethodGet || r.Method == http.MethodHead) &&
+			strings.Contains(r.Header.Get("Accept"), "text/html") {
+			http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+			return
+		}
+		respondJSON(w, map[string]string{"error": "Authentication required"}, http.StatusUnauthorized)
+	})
+}
+
+// hashSessionToken returns the stored form of a session token.
+func hashSessionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// lookupSession returns the user of the request's session cookie, if the
+// session exists and has not expired. Idle sessions have their expiry
+// pushed back.
+func lookupSession(r *http.Request) (string, bool) {
+	c, err := r.Cookie(sessionCookieName)
+	if err != nil || c.Value == "" {
+		return "", false
+	}
+	id := hashSessionToken(c.Value)
+
+	var username string
+	var remember bool
+	var lastSeen, expiresAt time.Time
+	err = db.QueryRow(
+		"SELECT username, remember, last_seen, expires_at FROM sessions WHERE id = ?", id,
+	).Scan(&username, &remember, &lastSeen, &expiresAt)
+	if err != nil {
+		return "", false
+	}
+
+	now := time.Now().UTC()
+	valid, touch := checkSession(now, lastSeen, expiresAt, remember)
+	if !valid {
+		return "", false
+	}
+
+	if touch {
+		_, err = db.Exec("UPDATE sessions SET last_seen = ?, expires_at = ? WHERE id = ?",
+			now, now.Add(sessionAuth.IdleTimeout), id)
+		if err != nil {
+			log.Printf("[ERROR] Failed to update session: %v", err)
+		}
+	}
+	return username, true
+}
+
+// checkSession reports whether a session last seen at lastSeen and
+// expiring at expiresAt is still valid at now, and whether its idle expiry
+// should be pushed back ("remember me" sessions have none).
+func checkSession(now, lastSeen, expiresAt time.Time, remember bool) (valid, touch bool) {
+	if now.After(expiresAt) {
+		return false, false
+	}
+	return true, !remember && now.Sub(lastSeen) >= sessionTouchInterval
+}
+
+// createSession stores a new session for username and sets its cookie.
+// Expired sessions are removed at the same time.
+func createSession(w http.ResponseWriter, r *http.Request, username string, remember bool) error {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Errorf("failed to generate session token: %w", err)
+	}
+	token := hex.EncodeToString(buf)
+
+	now := time.Now().UTC()
+	expiresAt := now.Add(sessionAuth.IdleTimeout)
+	if remember {
+		expiresAt = now.Add(sessionAuth.RememberFor)
+	}
+
+	if _, err := db.Exec("DELETE FROM sessions WHERE expires_at < ?", now); err != nil {
+		return fmt.Errorf("failed to remove expired sessions: %w", err)
+	}
+	_, err := db.Exec(`
+		INSERT INTO sessions (id, username, remember, created_at, last_seen, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, hashSessionToken(token), username, remember, now, now, expiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to store session: %w", err)
+	}
+
+	cookie := &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if remember {
+		// Persistent cookie; otherwise it ends with the browser session
+		cookie.MaxAge = int(sessionAuth.RememberFor / time.Second)
+	}
+	http.SetCookie(w, cookie)
+	return nil
+}
+
+// safeRedirect returns next if it is a path on this site, otherwise "/".
+func safeRedirect(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+// rememberLabel formats the "remember me" lifetime for the login page.
+func rememberLabel(d time.Duration) string {
+	day := 24 * time.Hour
+	switch {
+	case d == day:
+		return "1 day"
+	case d > day && d%day == 0:
+		return fmt.Sprintf("%d days", d/day)
+	default:
+		return d.String()
+	}
+}
+
+// LoginPageData holds data for the login page.
+type LoginPageData struct {
+	Next        string // Page to return to after login
+	Username    string // Username of a failed attempt
+	Error       string
+	CanRemember bool   // "Remember me" is enabled
+	RememberFor string // "Remember me" lifetime, e.g. "30 days"
+	Challenge   string // Pending login waiting for a two-factor code
+	AppVersion  string
+	Prefs       Preferences
+}
+
+// HandleLogin serves the login page and checks submitted credentials.
+//
+// GET  /login?next=/host/xxx
+// POST /login (form: username, password, remember, next)
+// POST /login (form: challenge, code, next) - second step with two-factor
+//
+// On success a session cookie is set and the browser is sent to next.
+// Accounts using two-factor authentication are first asked for a code.
+func HandleLogin(w http.ResponseWriter, r *http.Request) {
+	if sessionAuth.Verify == nil {
+		// Web authentication disabled
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	data := LoginPageData{
+		Next:        safeRedirect(r.FormValue("next")),
+		CanRemember: sessionAuth.RememberFor > 0,
+		RememberFor: rememberLabel(sessionAuth.RememberFor),
+		AppVersion:  appVersion,
+		Prefs:       loadPreferences(r),
+	}
+
+	status := http.StatusOK
+	switch r.Method {
+	case http.MethodGet:
+		if _, ok := lookupSession(r); ok {
+			http.Redirect(w, r, data.Next, http.StatusSeeOther)
+			return
+		}
+
+	case http.MethodPost:
+		if challenge := r.PostFormValue("challenge"); challenge != "" {
+			p, err := finishTOTPLogin(challenge, r.PostFormValue("code"))
+			var username string
+			if p != nil {
+				username = p.username
+			}
+			switch {
+			case err == nil:
+				completeLogin(w, r, p.username, p.remember, data.Next, "password and two-factor code")
+				return
+			case errors.Is(err, dbpkg.ErrInvalidTOTPCode):
+				log.Printf("[WARNING] Invalid two-factor code from %s (user: %s)", r.RemoteAddr, username)
+				recordAudit(r, username, dbpkg.AuditLoginFailed, "", "invalid two-factor code", false)
+				data.Challenge = challenge
+				data.Error = "Invalid code"
+			case errors.Is(err, errTOTPLoginExpired):
+				if p != nil {
+					recordAudit(r, username, dbpkg.AuditLoginFailed, "", "too many invalid two-factor codes", false)
+				}
+				data.Error = "Login expired or too many attempts, please log in again"
+			default:
+				log.Printf("[ERROR] Failed to check two-factor code: %v", err)
+				http.Error(w, "Failed to check code", http.StatusInternalServerError)
+				return
+			}
+			status = http.StatusUnauthorized
+			break
+		}
+
+		username := r.PostFormValue("username")
+		password := r.PostFormValue("password")
+		remember := data.CanRemember && r.PostFormValue("remember") != ""
+
+		if sessionAuth.Verify(username, password) {
+			needCode, err := totpEnabled(username)
+			if err != nil {
+				log.Printf("[ERROR] Failed to check two-factor authentication: %v", err)
+				http.Error(w, "Failed to log in", http.StatusInternalServerError)
+				return
+			}
+			if !needCode {
+				completeLogin(w, r, username, remember, data.Next, "password")
+				return
+			}
+
+			data.Challenge, err = startTOTPLogin(username, remember)
+			if err != nil {
+				log.Printf("[ERROR] Failed to start two-factor login: %v", err)
+				http.Error(w, "Failed to log in", http.StatusInternalServerError)
+				return
+			}
+			break
+		}
+
+		log.Printf("[WARNING] Failed login attempt from %s (user: %s)", r.RemoteAddr, username)
+		recordAudit(r, username, dbpkg.AuditLoginFailed, "", "invalid username or password", false)
+		data.Username = username
+		data.Error = "Invalid username or password"
+		status = http.StatusUnauthorized
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+
+	err := templates.ExecuteTemplate(w, "login.html", data)
+	if err != nil {
+		log.Printf("[ERROR] Failed to render template: %v", err)
+	}
+}
+
+// completeLogin creates the session of an authenticated user and sends the
+// browser to next. method describes the factors checked, for the audit log.
+func completeLogin(w http.ResponseWriter, r *http.Request, username string, remember bool, next, method string) {
+	if err := createSession(w, r, username, remember); err != nil {
+		log.Printf("[ERROR] Failed to create session: %v", err)
+		http.Error(w, "Failed to create session", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("[INFO] User %s logged in from %s", username, r.RemoteAddr)
+	recordAudit(r, username, dbpkg.AuditLogin, "", method, true)
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+// HandleLogout ends the current session and returns to the login page.
+//
+// POST /logout
+func HandleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if c, err := r.Cookie(sessionCookieName); err == nil && c.Value != "" {
+		if username, ok := lookupSession(r); ok {
+			recordAudit(r, username, dbpkg.AuditLogout, "", "", true)
+		}
+		if _, err := db.Exec("DELETE FROM sessions WHERE id = ?", hashSessionToken(c.Value)); err != nil {
+			log.Printf("[ERROR] Failed to delete session: %v", err)
+		}
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
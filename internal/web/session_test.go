@@ -0,0 +1,109 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSafeRedirect(t *testing.T) {
+	tests := []struct {
+		next, want string
+	}{
+		{"/host/abc", "/host/abc"},
+		{"/events?host=abc", "/events?host=abc"},
+		{"/", "/"},
+		{"", "/"},
+		{"host/abc", "/"},
+		{"https://evil.example/", "/"},
+		{"//evil.example/", "/"},
+		{"/\\evil.example/", "/"},
+		{"javascript:alert(1)", "/"},
+	}
+	for _, tt := range tests {
+		if got := safeRedirect(tt.next); got != tt.want {
+			t.Errorf("safeRedirect(%q) = %q, want %q", tt.next, got, tt.want)
+		}
+	}
+}
+
+func TestCheckSession(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name         string
+		lastSeen     time.Time
+		expiresAt    time.Time
+		remember     bool
+		valid, touch bool
+	}{
+		{"fresh", now.Add(-10 * time.Second), now.Add(time.Hour), false, true, false},
+		{"idle past touch interval", now.Add(-sessionTouchInterval), now.Add(time.Hour), false, true, true},
+		{"expired", now.Add(-2 * time.Hour), now.Add(-time.Second), false, false, false},
+		{"expires now", now.Add(-time.Hour), now, false, true, true},
+		{"remember me is not touched", now.Add(-24 * time.Hour), now.Add(24 * time.Hour), true, true, false},
+		{"remember me expired", now.Add(-24 * time.Hour), now.Add(-time.Second), true, false, false},
+	}
+	for _, tt := range tests {
+		valid, touch := checkSession(now, tt.lastSeen, tt.expiresAt, tt.remember)
+		if valid != tt.valid || touch != tt.touch {
+			t.Errorf("%s: valid, touch = %v, %v, want %v, %v", tt.name, valid, touch, tt.valid, tt.touch)
+		}
+	}
+}
+
+func TestRememberLabel(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{24 * time.Hour, "1 day"},
+		{30 * 24 * time.Hour, "30 days"},
+		{36 * time.Hour, "36h0m0s"},
+	}
+	for _, tt := range tests {
+		if got := rememberLabel(tt.d); got != tt.want {
+			t.Errorf("rememberLabel(%v) = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
+
+// TestRequireLoginUnauthenticated checks the responses to requests without
+// credentials: the login page and static assets are served, pages redirect
+// to the login page, and API calls get 401.
+func TestRequireLoginUnauthenticated(t *testing.T) {
+	saved := sessionAuth
+	defer func() { sessionAuth = saved }()
+	sessionAuth = SessionAuth{Verify: func(string, string) bool { return false }, IdleTimeout: time.Hour}
+
+	handler := RequireLogin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	tests := []struct {
+		name, method, path, accept string
+		status                     int
+		location                   string
+	}{
+		{"login page", http.MethodGet, "/login", "text/html", http.StatusTeapot, ""},
+		{"static asset", http.MethodGet, "/static/app.js", "", http.StatusTeapot, ""},
+		{"page", http.MethodGet, "/host/abc?tab=events", "text/html,application/xhtml+xml", http.StatusSeeOther,
+			"/login?next=%2Fhost%2Fabc%3Ftab%3Devents"},
+		{"API", http.MethodGet, "/api/v1/status", "application/json", http.StatusUnauthorized, ""},
+		{"form post", http.MethodPost, "/host/abc", "text/html", http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.status)
+		}
+		if loc := w.Header().Get("Location"); loc != tt.location {
+			t.Errorf("%s: Location = %q, want %q", tt.name, loc, tt.location)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	dbpkg "github.com/ocochard/cmonit/internal/db"
+)
+
+// requiredScope returns the API token scope needed for r.
+//
+//   - Token management, the audit log, M/Monit host deletion, the agent
+//     connection settings, agent-wide actions, the Monit configuration
+//     snippet and the parse diagnostics need admin
+//   - Service actions (run now or scheduled) and event acknowledgments
+//     need write:actions
+//   - Recording external events (POST /api/v1/events) needs write:events
+//   - Other GET and HEAD requests need read:status
+//   - Any other change needs admin
+func requiredScope(r *http.Request) string {
+	path := r.URL.Path
+	switch {
+	case strings.HasPrefix(path, "/tokens"), strings.HasPrefix(path, "/audit"),
+		path == "/api/v1/tokens" || strings.HasPrefix(path, "/api/v1/tokens/"),
+		path == "/api/tokens" || strings.HasPrefix(path, "/api/tokens/"),
+		path == "/api/v1/audit" || strings.HasPrefix(path, "/api/v1/audit/"),
+		path == "/api/audit" || strings.HasPrefix(path, "/api/audit/"),
+		path == "/api/2/admin/hosts/delete",
+		path == "/api/v1/host/control" || path == "/api/host/control",
+		path == "/api/v1/host/daemon" || path == "/api/host/daemon",
+		path == "/api/v1/host/monit-config" || path == "/api/host/monit-config",
+		path == "/api/v1/parse-diagnostics" || path == "/api/parse-diagnostics",
+		path == "/api/v1/validation-failures" || path == "/api/validation-failures":
+		return dbpkg.ScopeAdmin
+	case path == "/api/v1/action" || path == "/api/action",
+		path == "/api/v1/hostgroups/action" || path == "/api/hostgroups/action",
+		path == "/api/v1/host/restart-failed" || path == "/api/host/restart-failed",
+		path == "/api/v1/events/ack" || path == "/api/events/ack",
+		path == "/api/v1/events/comments" || path == "/api/events/comments":
+		return dbpkg.ScopeWriteActions
+	case r.Method == http.MethodPost && (path == "/api/v1/schedule" || strings.HasPrefix(path, "/api/v1/schedule/") ||
+		path == "/api/schedule" || strings.HasPrefix(path, "/api/schedule/")):
+		return dbpkg.ScopeWriteActions
+	case r.Method == http.MethodPost && (path == "/api/v1/events" || path == "/api/events"):
+		return dbpkg.ScopeWriteEvents
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		return dbpkg.ScopeReadStatus
+	default:
+		return dbpkg.ScopeAdmin
+	}
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header.
+func bearerToken(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(auth[7:]), true
+}
+
+// authenticateToken checks a Bearer token against the scope r needs. On
+// failure it writes the error response and returns false.
+func authenticateToken(w http.ResponseWriter, r *http.Request, token string) (*dbpkg.APIToken, bool) {
+	t, err := dbpkg.LookupAPIToken(db, token)
+	if err != nil {
+		log.Printf("[ERROR] Failed to check API token: %v", err)
+		respondJSON(w, map[string]string{"error": "Failed to check token"}, http.StatusInternalServerError)
+		return nil, false
+	}
+	if t == nil {
+		log.Printf("[WARNING] Invalid API token from %s", r.RemoteAddr)
+		recordAudit(r, "", dbpkg.AuditLoginFailed, "", "invalid API token", false)
+		respondJSON(w, map[string]string{"error": "Invalid token"}, http.StatusUnauthorized)
+		return nil, false
+	}
+
+	scope := requiredScope(r)
+	if !t.HasScope(scope) {
+		log.Printf("[WARNING] API token %q lacks scope %s for %s %s", t.Name, scope, r.Method, r.URL.Path)
+		recordAudit(r, tokenUser(t), dbpkg.AuditAccessDenied, r.Method+" "+r.URL.Path, "missing scope "+scope, false)
+		respondJSON(w, map[string]string{"error": "Token lacks scope " + scope}, http.StatusForbidden)
+		return nil, false
+	}
+	return t, true
+}
+
+// withToken returns r with t recorded as the API token it was
+// authenticated with.
+func withToken(r *http.Request, t *dbpkg.APIToken) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), tokenContextKey, t))
+}
+
+// isAdmin reports whether r may use the admin endpoints. Logged-in users
+// may; API tokens need the admin scope (see requiredScope).
+func isAdmin(r *http.Request) bool {
+	t, ok := r.Context().Value(tokenContextKey).(*dbpkg.APIToken)
+	return !ok || t.HasScope(dbpkg.ScopeAdmin)
+}
+
+// canSeeConnections reports whether r may see how cmonit connects to Monit
+// agents (address, port, username) in the host details: only admins.
+// Agent passwords are never returned.
+func canSeeConnections(r *http.Request) bool {
+	return isAdmin(r)
+}
+
+// tokenAuditDetails describes a new token in the audit log.
+func tokenAuditDetails(t *dbpkg.APIToken) string {
+	details := "scopes: " + strings.Join(t.Scopes, " ")
+	if t.Role != "" {
+		details += ", role: " + t.Role
+	}
+	return details
+}
+
+// tokenUser is the user name recorded for requests made with a token
+// (event acknowledgments, dashboard ownership).
+func tokenUser(t *dbpkg.APIToken) string {
+	return "token:" + t.Name
+}
+
+// TokenRequest is the JSON body for creating an API token.
+type TokenRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	Role   string   `json:"role,omitempty"`
+}
+
+// TokenCreatedResponse returns a new token. The token is only shown here.
+type TokenCreatedResponse struct {
+	Token string         `json:"token"`
+	Info  dbpkg.APIToken `json:"info"`
+}
+
+// TokensResponse lists API tokens.
+type TokensResponse struct {
+	Tokens []dbpkg.APIToken `json:"tokens"`
+	Scopes []string         `json:"scopes"` // Valid scopes
+	Roles  []string         `json:"roles"`  // Configured roles
+}
+
+// HandleTokensAPI lists, creates and revokes API tokens.
+//
+// GET    /api/v1/tokens       - list tokens (never the token values)
+// POST   /api/v1/tokens       - create a token: {"name": "...", "scopes": ["read:status"], "role": "..."}
+// DELETE /api/v1/tokens/{id}  - revoke a token
+//
+// Tokens are sent as "Authorization: Bearer <token>". Called with a token,
+// these endpoints need the admin scope.
+func HandleTokensAPI(w http.ResponseWriter, r *http.Request) {
+	idStr := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/tokens"), "/")
+
+	if idStr == "" {
+		switch r.Method {
+		case http.MethodGet:
+			tokens, err := dbpkg.ListAPITokens(db)
+			if err != nil {
+				log.Printf("[ERROR] %v", err)
+				respondJSON(w, map[string]string{"error": "Failed to list tokens"}, http.StatusInternalServerError)
+				return
+			}
+			respondJSON(w, TokensResponse{Tokens: tokens, Scopes: dbpkg.TokenScopes, Roles: RoleNames()}, http.StatusOK)
+
+		case http.MethodPost:
+			var req TokenRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				respondJSON(w, map[string]string{"error": "Invalid JSON"}, http.StatusBadRequest)
+				return
+			}
+			if !validRole(req.Role) {
+				respondJSON(w, map[string]string{"error": "Unknown role: " + req.Role}, http.StatusBadRequest)
+				return
+			}
+			createdBy := currentUser(r)
+			if createdBy == "" {
+				createdBy = "anonymous"
+			}
+			token, info, err := dbpkg.CreateAPIToken(db, req.Name, req.Scopes, req.Role, createdBy)
+			if err != nil {
+				respondJSON(w, map[string]string{"error": err.Error()}, http.StatusBadRequest)
+				return
+			}
+			log.Printf("[INFO] API token %q created by %s (scopes: %s)", info.Name, createdBy, strings.Join(info.Scopes, " "))
+			auditRequest(r, dbpkg.AuditTokenCreate, info.Name, tokenAuditDetails(info), true)
+			respondJSON(w, TokenCreatedResponse{Token: token, Info: *info}, http.StatusCreated)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		respondJSON(w, map[string]string{"error": "Invalid token ID"}, http.StatusBadRequest)
+		return
+	}
+	err = dbpkg.RevokeAPIToken(db, id)
+	if errors.Is(err, dbpkg.ErrTokenNotFound) {
+		respondJSON(w, map[string]string{"error": "Token not found"}, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("[ERROR] %v", err)
+		respondJSON(w, map[string]string{"error": "Failed to revoke token"}, http.StatusInternalServerError)
+		return
+	}
+	log.Printf("[INFO] API token %d revoked by %s", id, currentUser(r))
+	auditRequest(r, dbpkg.AuditTokenRevoke, "token "+idStr, "", true)
+	respondJSON(w, ActionResponse{Success: true, Message: "Token revoked"}, http.StatusOK)
+}
+
+// TokensPageData holds data for the API tokens page.
+type TokensPageData struct {
+	Tokens      []dbpkg.APIToken
+	Scopes      []string
+	Roles       []string // Configured roles
+	AuthEnabled bool     // Web authentication is configured (tokens are needed)
+	LastUpdate  time.Time
+	AppVersion  string
+	Prefs       Preferences
+}
+
+// HandleTokens serves the API tokens page.
+//
+// GET /tokens
+func HandleTokens(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tokens, err := dbpkg.ListAPITokens(db)
+	if err != nil {
+		log.Printf("[ERROR] %v", err)
+		http.Error(w, "Failed to load tokens", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	err = templates.ExecuteTemplate(w, "tokens.html", TokensPageData{
+		Tokens:      tokens,
+		Scopes:      dbpkg.TokenScopes,
+		Roles:       RoleNames(),
+		AuthEnabled: sessionAuth.Verify != nil,
+		LastUpdate:  time.Now(),
+		AppVersion:  appVersion,
+		Prefs:       loadPreferences(r),
+	})
+	if err != nil {
+		log.Printf("[ERROR] Failed to render template: %v", err)
+	}
+}
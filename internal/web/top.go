@@ -0,0 +1,186 @@
+package web
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// Top-N limits.
+const (
+	defaultTopN = 10
+	maxTopN     = 100
+)
+
+// topQueries holds, per metric, the query for the top hosts and the query
+// for the top services. Both take the limit as their only parameter and
+// return host_id, hostname, service name ("" for hosts) and value.
+//
+//   - cpu: host total CPU % (latest_metrics); process CPU % (services)
+//   - memory: host memory % (latest_metrics); process memory % (services)
+//   - disk: fullest filesystem per host; filesystem space used %
+//     (latest filesystem_metrics row of each filesystem service)
+var topQueries = map[string]struct{ hosts, services string }{
+	"cpu": {
+		hosts: `
+			SELECT h.id, h.hostname, '',
+				SUM(CASE WHEN m.metric_name IN ('user', 'system', 'nice', 'wait') THEN m.value ELSE 0 END) AS value
+			FROM latest_metrics m
+			JOIN hosts h ON h.id = m.host_id
+			WHERE m.metric_type = 'cpu'
+			GROUP BY h.id
+			ORDER BY value DESC
+			LIMIT ?`,
+		services: `
+			SELECT h.id, h.hostname, s.name, s.cpu_percent
+			FROM services s
+			JOIN hosts h ON h.id = s.host_id
+			WHERE s.type = 3 AND s.cpu_percent IS NOT NULL
+			ORDER BY s.cpu_percent DESC
+			LIMIT ?`,
+	},
+	"memory": {
+		hosts: `
+			SELECT h.id, h.hostname, '', m.value
+			FROM latest_metrics m
+			JOIN hosts h ON h.id = m.host_id
+			WHERE m.metric_type = 'memory' AND m.metric_name = 'percent'
+			ORDER BY m.value DESC
+			LIMIT ?`,
+		services: `
+			SELECT h.id, h.hostname, s.name, s.memory_percent
+			FROM services s
+			JOIN hosts h ON h.id = s.host_id
+			WHERE s.type = 3 AND s.memory_percent IS NOT NULL
+			ORDER BY s.memory_percent DESC
+			LIMIT ?`,
+	},
+	"disk": {
+		hosts: `
+			SELECT host_id, hostname, '', MAX(value) AS value
+			FROM (` + latestFilesystemUsage + `)
+			GROUP BY host_id
+			ORDER BY value DESC
+			LIMIT ?`,
+		services: `
+			SELECT host_id, hostname, name, value
+			FROM (` + latestFilesystemUsage + `)
+			ORDER BY value DESC
+			LIMIT ?`,
+	},
+}
+
+// latestFilesystemUsage selects the latest space used % of every
+// filesystem service (type 0). The correlated lookup uses
+// idx_filesystem_metrics_lookup, so the cost is one index probe per
+// filesystem rather than a scan of the history.
+const latestFilesystemUsage = `
+				SELECT s.host_id, h.hostname, s.name, (
+					SELECT f.block_percent
+					FROM filesystem_metrics f
+					WHERE f.host_id = s.host_id AND f.service_name = s.name
+					ORDER BY f.collected_at DESC
+					LIMIT 1
+				) AS value
+				FROM services s
+				JOIN hosts h ON h.id = s.host_id
+				WHERE s.type = 0`
+
+// TopEntry is a host or service in a top-N list.
+type TopEntry struct {
+	HostID   string  `json:"host_id"`
+	Hostname string  `json:"hostname"`
+	Service  string  `json:"service,omitempty"` // Process or filesystem name; empty for hosts
+	Value    float64 `json:"value"`             // Percent
+}
+
+// TopResponse is the JSON response for the top-N API.
+type TopResponse struct {
+	Metric   string     `json:"metric"`   // cpu, memory or disk
+	Hosts    []TopEntry `json:"hosts"`    // Hosts using the most of the resource
+	Services []TopEntry `json:"services"` // Processes (cpu, memory) or filesystems (disk)
+}
+
+// getTopConsumers returns the n hosts and n services currently using the
+// most of metric ("cpu", "memory" or "disk"), highest first.
+func getTopConsumers(metric string, n int) (*TopResponse, error) {
+	q := topQueries[metric]
+
+	hosts, err := queryTopEntries(q.hosts, n)
+	if err != nil {
+		return nil, err
+	}
+	services, err := queryTopEntries(q.services, n)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TopResponse{Metric: metric, Hosts: hosts, Services: services}, nil
+}
+
+// queryTopEntries runs one of the topQueries.
+func queryTopEntries(query string, n int) ([]TopEntry, error) {
+	rows, err := db.Query(query, n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []TopEntry{}
+	for rows.Next() {
+		var e TopEntry
+		var value *float64
+		if err := rows.Scan(&e.HostID, &e.Hostname, &e.Service, &value); err != nil {
+			return nil, err
+		}
+		if value == nil {
+			continue // Filesystem without metrics yet
+		}
+		e.Value = *value
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// HandleTopAPI returns the hosts and processes currently consuming the most
+// of a resource.
+//
+// GET /api/v1/top?metric=cpu|memory|disk&n=10
+//
+// For cpu and memory, services lists process services; for disk, it lists
+// filesystems and each host's value is its fullest filesystem. Values are
+// percentages from the latest report of each host.
+func HandleTopAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	metric := r.URL.Query().Get("metric")
+	if metric == "" {
+		metric = "cpu"
+	}
+	if _, ok := topQueries[metric]; !ok {
+		respondJSON(w, map[string]string{"error": "Invalid metric (cpu, memory or disk)"}, http.StatusBadRequest)
+		return
+	}
+
+	n := defaultTopN
+	if s := r.URL.Query().Get("n"); s != "" {
+		v, err := strconv.Atoi(s)
+		if err != nil || v < 1 || v > maxTopN {
+			respondJSON(w, map[string]string{"error": "Invalid n (1-100)"}, http.StatusBadRequest)
+			return
+		}
+		n = v
+	}
+
+	top, err := getTopConsumers(metric, n)
+	if err != nil {
+		log.Printf("[ERROR] Failed to get top %s consumers: %v", metric, err)
+		respondJSON(w, map[string]string{"error": "Failed to get top consumers"}, http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, top, http.StatusOK)
+}
@@ -0,0 +1,380 @@
+package web
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	dbpkg "github.com/ocochard/cmonit/internal/db"
+)
+
+// Two-factor authentication policies (SessionAuth.TOTPPolicy)
+const (
+	TOTPPolicyOff      = "off"      // Never ask for codes, even from enrolled accounts
+	TOTPPolicyOptional = "optional" // Accounts choose to enroll
+	TOTPPolicyRequired = "required" // Accounts must enroll before using the UI
+)
+
+// totpIssuer names cmonit in authenticator apps.
+const totpIssuer = "cmonit"
+
+// totpLoginTimeout is how long a user has to enter a code after the
+// password was accepted.
+const totpLoginTimeout = 5 * time.Minute
+
+// maxTOTPAttempts is the number of wrong codes allowed per login.
+const maxTOTPAttempts = 5
+
+// errTOTPLoginExpired is returned for an unknown, expired or exhausted
+// pending login.
+var errTOTPLoginExpired = errors.New("login expired")
+
+// pendingLogin is a login whose password was accepted and which waits for
+// a two-factor code.
+type pendingLogin struct {
+	username string
+	remember bool
+	expires  time.Time
+	attempts int
+}
+
+// pendingLogins holds logins waiting for a code, by random ID. They only
+// live a few minutes, so they are kept in memory.
+var pendingLogins = struct {
+	sync.Mutex
+	m map[string]*pendingLogin
+}{m: make(map[string]*pendingLogin)}
+
+// verifyLoginCode checks the code of a pending login (replaced by tests).
+var verifyLoginCode = func(username, code string) error {
+	return dbpkg.VerifyTOTP(db, username, code)
+}
+
+// totpPolicy returns the configured policy.
+func totpPolicy() string {
+	if sessionAuth.TOTPPolicy == "" {
+		return TOTPPolicyOptional
+	}
+	return sessionAuth.TOTPPolicy
+}
+
+// totpEnabled reports whether logins of username need a code.
+func totpEnabled(username string) (bool, error) {
+	if totpPolicy() == TOTPPolicyOff {
+		return false, nil
+	}
+	status, err := dbpkg.GetTOTPStatus(db, username)
+	if err != nil {
+		return false, err
+	}
+	return status.Enabled, nil
+}
+
+// startTOTPLogin records a login waiting for a code and returns its ID,
+// sent back by the code form. Expired logins are removed at the same time.
+func startTOTPLogin(username string, remember bool) (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(buf)
+
+	now := time.Now()
+	pendingLogins.Lock()
+	defer pendingLogins.Unlock()
+	for k, p := range pendingLogins.m {
+		if now.After(p.expires) {
+			delete(pendingLogins.m, k)
+		}
+	}
+	pendingLogins.m[id] = &pendingLogin{
+		username: username,
+		remember: remember,
+		expires:  now.Add(totpLoginTimeout),
+	}
+	return id, nil
+}
+
+// finishTOTPLogin checks the code of a pending login.
+//
+// Returns dbpkg.ErrInvalidTOTPCode for a wrong code (the login can be
+// retried) and errTOTPLoginExpired once it timed out or ran out of
+// attempts. The pending login is returned whenever it was found, so that
+// failures can be attributed to its user.
+func finishTOTPLogin(id, code string) (*pendingLogin, error) {
+	pendingLogins.Lock()
+	defer pendingLogins.Unlock()
+
+	p, ok := pendingLogins.m[id]
+	if !ok || time.Now().After(p.expires) {
+		delete(pendingLogins.m, id)
+		return nil, errTOTPLoginExpired
+	}
+
+	err := verifyLoginCode(p.username, code)
+	if errors.Is(err, dbpkg.ErrInvalidTOTPCode) {
+		p.attempts++
+		if p.attempts >= maxTOTPAttempts {
+			delete(pendingLogins.m, id)
+			return p, errTOTPLoginExpired
+		}
+		return p, err
+	}
+	if err != nil {
+		return p, err
+	}
+	delete(pendingLogins.m, id)
+	return p, nil
+}
+
+// totpEnrollmentPath reports whether path stays reachable for accounts
+// that still have to enroll under the "required" policy.
+func totpEnrollmentPath(path string) bool {
+	return path == "/2fa" || path == "/logout" ||
+		path == "/api/v1/2fa" || strings.HasPrefix(path, "/api/v1/2fa/") ||
+		path == "/api/2fa" || strings.HasPrefix(path, "/api/2fa/")
+}
+
+// requireTOTPEnrollment enforces the "required" policy for a logged-in
+// user: until they enroll, pages redirect to /2fa and other requests get
+// 403. It returns false if it wrote such a response.
+func requireTOTPEnrollment(w http.ResponseWriter, r *http.Request, username string) bool {
+	if totpPolicy() != TOTPPolicyRequired || totpEnrollmentPath(r.URL.Path) {
+		return true
+	}
+	status, err := dbpkg.GetTOTPStatus(db, username)
+	if err != nil {
+		log.Printf("[ERROR] %v", err)
+		respondJSON(w, map[string]string{"error": "Failed to check two-factor authentication"}, http.StatusInternalServerError)
+		return false
+	}
+	if status.Enabled {
+		return true
+	}
+
+	if r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html") {
+		http.Redirect(w, r, "/2fa", http.StatusSeeOther)
+		return false
+	}
+	respondJSON(w, map[string]string{"error": "Two-factor enrollment required"}, http.StatusForbidden)
+	return false
+}
+
+// basicAuthAllowed reports whether username may use HTTP Basic Auth, which
+// cannot carry a two-factor code: not once the account is enrolled, nor
+// under the "required" policy. Scripts use API tokens instead.
+func basicAuthAllowed(username string) (bool, error) {
+	switch totpPolicy() {
+	case TOTPPolicyOff:
+		return true, nil
+	case TOTPPolicyRequired:
+		return false, nil
+	}
+	enabled, err := totpEnabled(username)
+	return !enabled, err
+}
+
+// TOTPStatusResponse is the two-factor status of the current user.
+type TOTPStatusResponse struct {
+	dbpkg.TOTPStatus
+	User   string `json:"user"`
+	Policy string `json:"policy"` // off, optional or required
+}
+
+// TOTPEnrollResponse returns a new secret to add to an authenticator app.
+type TOTPEnrollResponse struct {
+	Secret string `json:"secret"` // Base32, for manual entry
+	URI    string `json:"uri"`    // otpauth:// URI, shown as a QR code
+}
+
+// TOTPCodeRequest is the JSON body of requests confirmed with a code.
+type TOTPCodeRequest struct {
+	Code string `json:"code"` // Authenticator code, or a recovery code where noted
+}
+
+// TOTPRecoveryCodesResponse returns new recovery codes, only shown once.
+type TOTPRecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// HandleTOTPAPI manages two-factor authentication of the current web user.
+//
+// GET  /api/v1/2fa                 - status and policy
+// POST /api/v1/2fa/enroll          - new secret (not used until confirmed)
+// POST /api/v1/2fa/confirm         - {"code"}: enable, returns recovery codes
+// POST /api/v1/2fa/recovery-codes  - {"code"}: replace recovery codes
+// POST /api/v1/2fa/disable         - {"code"}: disable (not under "required")
+//
+// Codes of recovery-codes and disable may be recovery codes.
+func HandleTOTPAPI(w http.ResponseWriter, r *http.Request) {
+	action := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/2fa"), "/")
+
+	user := currentUser(r)
+	if user == "" {
+		respondJSON(w, map[string]string{"error": "Web authentication is disabled"}, http.StatusBadRequest)
+		return
+	}
+	if strings.HasPrefix(user, "token:") {
+		respondJSON(w, map[string]string{"error": "Two-factor authentication applies to web accounts, not API tokens"}, http.StatusForbidden)
+		return
+	}
+
+	if action == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		status, err := dbpkg.GetTOTPStatus(db, user)
+		if err != nil {
+			log.Printf("[ERROR] %v", err)
+			respondJSON(w, map[string]string{"error": "Failed to get two-factor status"}, http.StatusInternalServerError)
+			return
+		}
+		respondJSON(w, TOTPStatusResponse{TOTPStatus: *status, User: user, Policy: totpPolicy()}, http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if totpPolicy() == TOTPPolicyOff {
+		respondJSON(w, map[string]string{"error": "Two-factor authentication is disabled by the administrator"}, http.StatusBadRequest)
+		return
+	}
+
+	var req TOTPCodeRequest
+	if action != "enroll" {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondJSON(w, map[string]string{"error": "Invalid JSON"}, http.StatusBadRequest)
+			return
+		}
+	}
+
+	switch action {
+	case "enroll":
+		secret, err := dbpkg.StartTOTPEnrollment(db, user)
+		if errors.Is(err, dbpkg.ErrTOTPEnabled) {
+			respondJSON(w, map[string]string{"error": "Two-factor authentication is already enabled"}, http.StatusConflict)
+			return
+		}
+		if err != nil {
+			log.Printf("[ERROR] %v", err)
+			respondJSON(w, map[string]string{"error": "Failed to start enrollment"}, http.StatusInternalServerError)
+			return
+		}
+		respondJSON(w, TOTPEnrollResponse{Secret: secret, URI: dbpkg.TOTPURI(totpIssuer, user, secret)}, http.StatusOK)
+
+	case "confirm":
+		codes, err := dbpkg.ConfirmTOTPEnrollment(db, user, req.Code)
+		switch {
+		case errors.Is(err, dbpkg.ErrTOTPNotPending):
+			respondJSON(w, map[string]string{"error": "No enrollment in progress"}, http.StatusConflict)
+		case errors.Is(err, dbpkg.ErrInvalidTOTPCode):
+			respondJSON(w, map[string]string{"error": "Invalid code"}, http.StatusBadRequest)
+		case err != nil:
+			log.Printf("[ERROR] %v", err)
+			respondJSON(w, map[string]string{"error": "Failed to enable two-factor authentication"}, http.StatusInternalServerError)
+		default:
+			log.Printf("[INFO] Two-factor authentication enabled for user %s", user)
+			auditRequest(r, dbpkg.AuditTOTPEnable, user, "", true)
+			respondJSON(w, TOTPRecoveryCodesResponse{RecoveryCodes: codes}, http.StatusOK)
+		}
+
+	case "recovery-codes", "disable":
+		if action == "disable" && totpPolicy() == TOTPPolicyRequired {
+			respondJSON(w, map[string]string{"error": "Two-factor authentication is required by the administrator"}, http.StatusForbidden)
+			return
+		}
+		err := dbpkg.VerifyTOTP(db, user, req.Code)
+		if errors.Is(err, dbpkg.ErrInvalidTOTPCode) {
+			log.Printf("[WARNING] Invalid two-factor code from %s (user: %s)", r.RemoteAddr, user)
+			respondJSON(w, map[string]string{"error": "Invalid code"}, http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Printf("[ERROR] %v", err)
+			respondJSON(w, map[string]string{"error": "Failed to check code"}, http.StatusInternalServerError)
+			return
+		}
+
+		if action == "disable" {
+			if _, err := dbpkg.DisableTOTP(db, user); err != nil {
+				log.Printf("[ERROR] %v", err)
+				respondJSON(w, map[string]string{"error": "Failed to disable two-factor authentication"}, http.StatusInternalServerError)
+				return
+			}
+			log.Printf("[INFO] Two-factor authentication disabled for user %s", user)
+			auditRequest(r, dbpkg.AuditTOTPDisable, user, "", true)
+			respondJSON(w, ActionResponse{Success: true, Message: "Two-factor authentication disabled"}, http.StatusOK)
+			return
+		}
+
+		codes, err := dbpkg.RegenerateRecoveryCodes(db, user)
+		if err != nil {
+			log.Printf("[ERROR] %v", err)
+			respondJSON(w, map[string]string{"error": "Failed to generate recovery codes"}, http.StatusInternalServerError)
+			return
+		}
+		log.Printf("[INFO] Recovery codes regenerated for user %s", user)
+		auditRequest(r, dbpkg.AuditTOTPRecoveryCodes, user, "", true)
+		respondJSON(w, TOTPRecoveryCodesResponse{RecoveryCodes: codes}, http.StatusOK)
+
+	default:
+		respondJSON(w, map[string]string{"error": "Not found"}, http.StatusNotFound)
+	}
+}
+
+// TOTPPageData holds data for the two-factor authentication page.
+type TOTPPageData struct {
+	User        string
+	Status      *dbpkg.TOTPStatus
+	Policy      string
+	AuthEnabled bool // Web authentication is configured
+	LastUpdate  time.Time
+	AppVersion  string
+	Prefs       Preferences
+}
+
+// HandleTOTP serves the two-factor authentication page, where the current
+// user enrolls an authenticator app and manages recovery codes.
+//
+// GET /2fa
+func HandleTOTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data := TOTPPageData{
+		User:        currentUser(r),
+		Status:      &dbpkg.TOTPStatus{},
+		Policy:      totpPolicy(),
+		AuthEnabled: sessionAuth.Verify != nil,
+		LastUpdate:  time.Now(),
+		AppVersion:  appVersion,
+		Prefs:       loadPreferences(r),
+	}
+	if data.User != "" {
+		status, err := dbpkg.GetTOTPStatus(db, data.User)
+		if err != nil {
+			log.Printf("[ERROR] %v", err)
+			http.Error(w, "Failed to load two-factor status", http.StatusInternalServerError)
+			return
+		}
+		data.Status = status
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	err := templates.ExecuteTemplate(w, "totp.html", data)
+	if err != nil {
+		log.Printf("[ERROR] Failed to render template: %v", err)
+	}
+}
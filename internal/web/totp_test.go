@@ -0,0 +1,100 @@
+package web
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	dbpkg "github.com/ocochard/cmonit/internal/db"
+)
+
+// TestFinishTOTPLogin checks that a pending login accepts a valid code
+// once, allows maxTOTPAttempts wrong codes and expires.
+func TestFinishTOTPLogin(t *testing.T) {
+	saved := verifyLoginCode
+	defer func() { verifyLoginCode = saved }()
+	verifyLoginCode = func(username, code string) error {
+		if username == "alice" && code == "123456" {
+			return nil
+		}
+		return dbpkg.ErrInvalidTOTPCode
+	}
+
+	// Valid code, then the login is gone
+	id, err := startTOTPLogin("alice", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := finishTOTPLogin(id, "123456")
+	if err != nil || p == nil || p.username != "alice" || !p.remember {
+		t.Fatalf("valid code: %+v, %v", p, err)
+	}
+	if _, err := finishTOTPLogin(id, "123456"); !errors.Is(err, errTOTPLoginExpired) {
+		t.Errorf("reused login: err = %v, want errTOTPLoginExpired", err)
+	}
+
+	// Wrong codes until the attempts run out
+	id, err = startTOTPLogin("alice", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < maxTOTPAttempts; i++ {
+		if p, err := finishTOTPLogin(id, "000000"); !errors.Is(err, dbpkg.ErrInvalidTOTPCode) || p == nil {
+			t.Fatalf("attempt %d: %+v, %v, want ErrInvalidTOTPCode", i, p, err)
+		}
+	}
+	if p, err := finishTOTPLogin(id, "000000"); !errors.Is(err, errTOTPLoginExpired) || p == nil || p.username != "alice" {
+		t.Errorf("last attempt: %+v, %v, want errTOTPLoginExpired for alice", p, err)
+	}
+	if _, err := finishTOTPLogin(id, "123456"); !errors.Is(err, errTOTPLoginExpired) {
+		t.Errorf("after the last attempt: err = %v, want errTOTPLoginExpired", err)
+	}
+
+	// Expired login
+	id, err = startTOTPLogin("alice", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pendingLogins.Lock()
+	pendingLogins.m[id].expires = time.Now().Add(-time.Second)
+	pendingLogins.Unlock()
+	if _, err := finishTOTPLogin(id, "123456"); !errors.Is(err, errTOTPLoginExpired) {
+		t.Errorf("expired login: err = %v, want errTOTPLoginExpired", err)
+	}
+
+	if _, err := finishTOTPLogin("unknown", "123456"); !errors.Is(err, errTOTPLoginExpired) {
+		t.Errorf("unknown login: err = %v, want errTOTPLoginExpired", err)
+	}
+}
+
+func TestBasicAuthAllowedByPolicy(t *testing.T) {
+	saved := sessionAuth
+	defer func() { sessionAuth = saved }()
+
+	sessionAuth.TOTPPolicy = TOTPPolicyOff
+	if ok, err := basicAuthAllowed("alice"); !ok || err != nil {
+		t.Errorf("policy off: %v, %v, want allowed", ok, err)
+	}
+	sessionAuth.TOTPPolicy = TOTPPolicyRequired
+	if ok, err := basicAuthAllowed("alice"); ok || err != nil {
+		t.Errorf("policy required: %v, %v, want refused", ok, err)
+	}
+}
+
+func TestTOTPEnrollmentPath(t *testing.T) {
+	for path, want := range map[string]bool{
+		"/2fa":                true,
+		"/logout":             true,
+		"/api/v1/2fa":         true,
+		"/api/v1/2fa/confirm": true,
+		"/api/2fa/enroll":     true,
+		"/":                   false,
+		"/api/v1/status":      false,
+		"/api/v1/2fax":        false,
+		"/host/abc":           false,
+	} {
+		if got := totpEnrollmentPath(path); got != want {
+			t.Errorf("totpEnrollmentPath(%q) = %v, want %v", path, got, want)
+		}
+	}
+}